@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logfile"
+)
+
+const (
+	accessLogCombined = "combined"
+	accessLogJSON     = "json"
+	accessLogOff      = "off"
+)
+
+// accessLogger writes one line per request in either the Apache combined
+// format, extended with latency and request ID, or as JSON.
+type accessLogger struct {
+	format string
+	mu     sync.Mutex
+	out    io.Writer
+}
+
+type accessLogEntry struct {
+	Time      time.Time `json:"time"`
+	RemoteIP  string    `json:"remote_ip"`
+	UserID    string    `json:"user_id,omitempty"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	LatencyMS float64   `json:"latency_ms"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	RequestID string    `json:"request_id"`
+}
+
+// newAccessLogger reads the ACCESS_LOG_* settings. A nil logger means
+// access logging is off. Log files are reopened on SIGHUP so they can be
+// rotated externally as well as by size.
+func newAccessLogger() (*accessLogger, error) {
+	format := os.Getenv("ACCESS_LOG_FORMAT")
+	if format == "" {
+		format = accessLogCombined
+	}
+	switch format {
+	case accessLogOff:
+		return nil, nil
+	case accessLogCombined, accessLogJSON:
+	default:
+		return nil, fmt.Errorf("ACCESS_LOG_FORMAT must be %s, %s or %s", accessLogCombined, accessLogJSON, accessLogOff)
+	}
+
+	path := os.Getenv("ACCESS_LOG_FILE")
+	if path == "" {
+		return &accessLogger{format: format, out: os.Stdout}, nil
+	}
+
+	maxSize := envInt("ACCESS_LOG_MAX_SIZE_MB", 100) << 20
+	f, err := logfile.Open(path, int64(maxSize), envInt("ACCESS_LOG_MAX_BACKUPS", 5))
+	if err != nil {
+		return nil, err
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := f.Reopen(); err != nil {
+				log.Printf("Couldn't reopen access log: %v", err)
+			}
+		}
+	}()
+
+	return &accessLogger{format: format, out: f}, nil
+}
+
+func (cfg *apiConfig) accessLogMiddleware(logger *accessLogger, next http.Handler) http.Handler {
+	if logger == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		entry := accessLogEntry{
+			Time:      start,
+			RemoteIP:  cfg.clientIP(r),
+			UserID:    cfg.requestUserID(r),
+			Method:    r.Method,
+			Path:      redactedRequestURI(r),
+			Proto:     r.Proto,
+			Status:    rec.statusCode(),
+			Bytes:     rec.bytes,
+			LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+			RequestID: w.Header().Get(requestIDHeader),
+		}
+		logger.write(entry)
+	})
+}
+
+func (l *accessLogger) write(e accessLogEntry) {
+	var line []byte
+	if l.format == accessLogJSON {
+		line, _ = json.Marshal(e)
+		line = append(line, '\n')
+	} else {
+		line = fmt.Appendf(nil, "%s - %s [%s] %q %d %d %q %q %.3f %s\n",
+			e.RemoteIP,
+			dashIfEmpty(e.UserID),
+			e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			e.Method+" "+e.Path+" "+e.Proto,
+			e.Status,
+			e.Bytes,
+			dashIfEmpty(e.Referer),
+			dashIfEmpty(e.UserAgent),
+			e.LatencyMS,
+			dashIfEmpty(e.RequestID),
+		)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.out.Write(line); err != nil {
+		log.Printf("Couldn't write access log: %v", err)
+	}
+}
+
+// requestUserID returns the user behind the request's bearer token, if it
+// carries a valid one. Handlers still do their own authentication.
+func (cfg *apiConfig) requestUserID(r *http.Request) string {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return ""
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		return ""
+	}
+	return userID.String()
+}
+
+// clientIP honours X-Forwarded-For only when proxy headers are trusted.
+func (cfg *apiConfig) clientIP(r *http.Request) string {
+	if cfg.trustProxyHeaders {
+		if ip := firstHeaderValue(r.Header.Get("X-Forwarded-For")); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// redactedRequestURI masks the token query parameter accepted by the
+// assets endpoint so JWTs don't end up in log files.
+func redactedRequestURI(r *http.Request) string {
+	query := r.URL.Query()
+	if !query.Has("token") {
+		return r.URL.RequestURI()
+	}
+	query.Set("token", "REDACTED")
+	u := *r.URL
+	u.RawQuery = query.Encode()
+	return u.RequestURI()
+}
+
+func dashIfEmpty(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "-"
+	}
+	return s
+}
+
+// statusRecorder captures the status code and body size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+func (rec *statusRecorder) statusCode() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g.
+// to flush streamed responses.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Types of the events on the admin event stream.
+const (
+	eventUploadStarted    = "upload.started"
+	eventUploadFinished   = "upload.finished"
+	eventProcessingFailed = "processing.failed"
+	// eventProcessingProgress is sent for each percent ffmpeg gets through
+	// a video.
+	eventProcessingProgress = "processing.progress"
+	eventQuotaExceeded      = "quota.exceeded"
+	eventStorageError       = "storage.error"
+)
+
+// Upload methods, as reported in upload events and offered by the upload
+// preflight.
+const (
+	uploadMethodForm    = "form"
+	uploadMethodSession = "session"
+	uploadMethodGRPC    = "grpc"
+	uploadMethodDirect  = "direct"
+)
+
+type uploadEvent struct {
+	VideoID uuid.UUID `json:"video_id"`
+	UserID  uuid.UUID `json:"user_id"`
+	Method  string    `json:"method"`
+	Size    int64     `json:"size"`
+}
+
+type processingFailedEvent struct {
+	VideoID uuid.UUID        `json:"video_id"`
+	UserID  uuid.UUID        `json:"user_id"`
+	Error   string           `json:"error"`
+	Stages  map[string]int64 `json:"stage_ms"`
+}
+
+type processingProgressEvent struct {
+	VideoID uuid.UUID `json:"video_id"`
+	UserID  uuid.UUID `json:"user_id"`
+	Stage   string    `json:"stage"`
+	Percent int       `json:"percent"`
+}
+
+type quotaExceededEvent struct {
+	UserID  uuid.UUID  `json:"user_id"`
+	OrgID   *uuid.UUID `json:"org_id,omitempty"`
+	PlanID  string     `json:"plan_id"`
+	Used    int64      `json:"bytes_used"`
+	Limit   int64      `json:"bytes_limit"`
+	Request int64      `json:"bytes_requested"`
+}
+
+type storageErrorEvent struct {
+	Operation string `json:"operation"`
+	Key       string `json:"key"`
+	Error     string `json:"error"`
+}
+
+// reportStorageError puts a failed store call on the admin event stream.
+func (cfg *apiConfig) reportStorageError(operation, key string, err error) {
+	cfg.events.Publish(eventStorageError, storageErrorEvent{
+		Operation: operation,
+		Key:       key,
+		Error:     err.Error(),
+	})
+}
+
+// sseKeepAlive is how often an idle stream gets a comment line, so proxies
+// don't close it.
+const sseKeepAlive = 30 * time.Second
+
+// handlerAdminEvents streams server events as they happen, as Server-Sent
+// Events. Clients that reconnect with Last-Event-ID get the recent events
+// they missed first.
+func (cfg *apiConfig) handlerAdminEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	var lastID uint64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid Last-Event-ID header", err)
+			return
+		}
+		lastID = id
+	}
+
+	events, unsubscribe := cfg.events.Subscribe(lastID, 64)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case e := <-events:
+			dat, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, dat); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
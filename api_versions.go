@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The REST API is versioned so responses can change shape without breaking
+// the clients written against them. Every version is served under
+// /api/v<N>/. The unversioned /api/ paths predate versioning and stay as
+// they were: they serve the version named in the API-Version header, and
+// version 1 to clients that don't send it.
+const (
+	apiVersionHeader  = "API-Version"
+	defaultAPIVersion = 1
+)
+
+// apiVersions are the versions served, oldest first.
+var apiVersions = []int{1}
+
+type apiVersionContextKey struct{}
+
+// requestAPIVersion is the version the request is being served as.
+func requestAPIVersion(ctx context.Context) int {
+	if v, ok := ctx.Value(apiVersionContextKey{}).(int); ok {
+		return v
+	}
+	return defaultAPIVersion
+}
+
+// apiPath is path, relative to /api, as the client should ask for it: under
+// the same version prefix the request came in on, if it had one.
+func apiPath(r *http.Request, path string) string {
+	if v, ok := versionedAPIPath(r.URL.Path); ok {
+		return fmt.Sprintf("/api/v%d%s", v, path)
+	}
+	return "/api" + path
+}
+
+var versionPrefix = regexp.MustCompile(`^/api/v([0-9]+)/`)
+
+// versionedAPIPath reports the version a path under /api/v<N>/ asks for.
+func versionedAPIPath(path string) (int, bool) {
+	m := versionPrefix.FindStringSubmatch(path)
+	if m == nil {
+		return 0, false
+	}
+	v, err := strconv.Atoi(m[1])
+	return v, err == nil
+}
+
+// unversionedAPIPath strips the version prefix from a path, so the path
+// can be matched the same whichever version it was asked for under.
+func unversionedAPIPath(path string) string {
+	if m := versionPrefix.FindString(path); m != "" {
+		return "/api/" + path[len(m):]
+	}
+	return path
+}
+
+// apiRouter collects the REST routes and mounts them under every version.
+// A version serves the handler registered for it, or for a route it left
+// alone, the one registered for the closest earlier version. A breaking
+// change to a route is registered with Since, so the versions before it
+// keep the old handler.
+type apiRouter struct {
+	since  int
+	routes *apiRoutes
+}
+
+type apiRoutes struct {
+	// patterns keeps the order routes were first registered in.
+	patterns []string
+	handlers map[string]map[int]http.Handler
+}
+
+func newAPIRouter() apiRouter {
+	return apiRouter{
+		since:  apiVersions[0],
+		routes: &apiRoutes{handlers: map[string]map[int]http.Handler{}},
+	}
+}
+
+// Since returns a router whose routes replace the earlier versions' from
+// the given version on.
+func (a apiRouter) Since(version int) apiRouter {
+	a.since = version
+	return a
+}
+
+// Handle registers a handler for a pattern like "GET /videos/{videoID}",
+// with the path relative to /api.
+func (a apiRouter) Handle(pattern string, handler http.Handler) {
+	if _, ok := a.routes.handlers[pattern]; !ok {
+		a.routes.patterns = append(a.routes.patterns, pattern)
+		a.routes.handlers[pattern] = map[int]http.Handler{}
+	}
+	if _, ok := a.routes.handlers[pattern][a.since]; ok {
+		panic(fmt.Sprintf("api: %q registered twice for version %d", pattern, a.since))
+	}
+	a.routes.handlers[pattern][a.since] = handler
+}
+
+func (a apiRouter) HandleFunc(pattern string, handler http.HandlerFunc) {
+	a.Handle(pattern, handler)
+}
+
+// handlerFor returns the handler the route has in the given version, or
+// nil if the route doesn't exist in it yet.
+func (a apiRouter) handlerFor(pattern string, version int) http.Handler {
+	var handler http.Handler
+	since := 0
+	for v, h := range a.routes.handlers[pattern] {
+		if v <= version && v > since {
+			handler, since = h, v
+		}
+	}
+	return handler
+}
+
+// Mount registers every route on mux, once per version under its prefix
+// and once under the unversioned /api/ paths. Routes keep the timeout
+// class they were registered with; an unversioned path gets the longest of
+// its versions', since the header picks one only once it's served.
+func (a apiRouter) Mount(mux *http.ServeMux) {
+	for _, pattern := range a.routes.patterns {
+		method, path, _ := strings.Cut(pattern, " ")
+		byVersion := map[int]http.Handler{}
+		class := timeoutShort
+		for _, v := range apiVersions {
+			h := a.handlerFor(pattern, v)
+			if h == nil {
+				continue
+			}
+			byVersion[v] = h
+			class = max(class, timeoutClassOf(h))
+			mux.Handle(fmt.Sprintf("%s /api/v%d%s", method, v, path), withTimeoutClass(timeoutClassOf(h), withAPIVersion(v, h)))
+		}
+		mux.Handle(method+" /api"+path, withTimeoutClass(class, negotiateAPIVersion(byVersion)))
+	}
+}
+
+func withAPIVersion(version int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(apiVersionHeader, strconv.Itoa(version))
+		ctx := context.WithValue(r.Context(), apiVersionContextKey{}, version)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// negotiateAPIVersion serves an unversioned path with the version the
+// client asks for. Since the response depends on the header, caches are
+// told so.
+func negotiateAPIVersion(byVersion map[int]http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", apiVersionHeader)
+		version := defaultAPIVersion
+		if s := strings.TrimSpace(r.Header.Get(apiVersionHeader)); s != "" {
+			v, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(s), "v"))
+			if err != nil || !slices.Contains(apiVersions, v) {
+				respondWithAPIError(w, apiError{
+					Status:  http.StatusBadRequest,
+					Code:    errCodeUnsupportedAPIVersion,
+					Message: fmt.Sprintf("Unsupported API version %q", s),
+					Details: supportedAPIVersions(),
+				})
+				return
+			}
+			version = v
+		}
+		h, ok := byVersion[version]
+		if !ok {
+			respondWithErrorCode(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("Not available in API version %d", version), nil)
+			return
+		}
+		withAPIVersion(version, h).ServeHTTP(w, r)
+	})
+}
+
+func supportedAPIVersions() []errorDetail {
+	details := make([]errorDetail, len(apiVersions))
+	for i, v := range apiVersions {
+		details[i] = errorDetail{Field: apiVersionHeader, Message: fmt.Sprintf("version %d is supported", v)}
+	}
+	return details
+}
@@ -4,9 +4,13 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
+	"mime"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+
+	"github.com/google/uuid"
 )
 
 func (cfg apiConfig) ensureAssetsDir() error {
@@ -16,16 +20,25 @@ func (cfg apiConfig) ensureAssetsDir() error {
 	return nil
 }
 
-func getAssetPath(mediaType string) string {
+// sortableAssetNames switches asset names and object keys from random
+// strings to UUIDv7s, which sort by creation time.
+var sortableAssetNames bool
+
+// newAssetName returns a unique name for a new asset or object.
+func newAssetName() string {
+	if sortableAssetNames {
+		id, err := uuid.NewV7()
+		if err != nil {
+			panic("failed to generate UUIDv7")
+		}
+		return id.String()
+	}
 	key := make([]byte, 32)
 	_, err := rand.Read(key)
 	if err != nil {
 		panic("failed to generate random bytes")
 	}
-	filename := base64.RawURLEncoding.EncodeToString(key)
-
-	ext := mediaTypeToExtension(mediaType)
-	return fmt.Sprintf("%s%s", filename, ext)
+	return base64.RawURLEncoding.EncodeToString(key)
 }
 
 func (cfg apiConfig) getAssetDiskPath(filename string) string {
@@ -33,17 +46,62 @@ func (cfg apiConfig) getAssetDiskPath(filename string) string {
 }
 
 func (cfg apiConfig) getAssetURL(filename string) string {
-	return fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, filename)
+	return fmt.Sprintf("%s/assets/%s", cfg.getBaseURL(), filename)
+}
+
+// getBaseURL returns the externally visible origin of the server,
+// EXTERNAL_BASE_URL, or localhost when that isn't set. It's never taken
+// from a request: URLs built from it are stored and handed to other
+// viewers, and the Host header is whatever the client sent.
+func (cfg apiConfig) getBaseURL() string {
+	if cfg.externalBaseURL != "" {
+		return cfg.externalBaseURL
+	}
+	return fmt.Sprintf("http://localhost:%s", cfg.port)
+}
+
+// firstHeaderValue returns the left-most entry of a comma separated header,
+// which is the one set by the proxy closest to the client.
+func firstHeaderValue(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	return strings.TrimSpace(first)
 }
 
-func (cfg apiConfig) getObjectURL(key string) string {
-	return fmt.Sprintf("https://%s/%s", cfg.s3CfDistribution, key)
+// extensionOverrides pins the extension for types where the system MIME
+// tables offer several candidates or none at all.
+var extensionOverrides = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/svg+xml":   ".svg",
+	"image/webp":      ".webp",
+	"image/x-icon":    ".ico",
+	"video/mp4":       ".mp4",
+	"video/quicktime": ".mov",
+	"video/webm":      ".webm",
+	"video/x-msvideo": ".avi",
+	"text/vtt":        ".vtt",
 }
 
 func mediaTypeToExtension(mediaType string) string {
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if ext, ok := extensionOverrides[mediaType]; ok {
+		return ext
+	}
+
+	exts, err := mime.ExtensionsByType(mediaType)
+	if err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+
 	parts := strings.Split(mediaType, "/")
 	if len(parts) != 2 {
 		return ".bin"
 	}
-	return "." + parts[1]
+	subtype := strings.TrimPrefix(parts[1], "x-")
+	subtype, _, _ = strings.Cut(subtype, "+")
+	if !validExtension.MatchString(subtype) {
+		return ".bin"
+	}
+	return "." + subtype
 }
+
+var validExtension = regexp.MustCompile(`^[a-z0-9]{1,10}$`)
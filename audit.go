@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
+	"github.com/google/uuid"
+)
+
+// Subjects of audit log entries.
+const (
+	auditSubjectVideo        = "video"
+	auditSubjectUser         = "user"
+	auditSubjectReport       = "report"
+	auditSubjectOrganization = "organization"
+)
+
+func auditUser(userID uuid.UUID) string {
+	return "user:" + userID.String()
+}
+
+// audit records an action that has already happened, so a failure to
+// write the entry is logged rather than undoing it.
+func (cfg *apiConfig) audit(actor, action, subjectType, subjectID string, details any) {
+	err := cfg.db.CreateAuditEntry(database.CreateAuditEntryParams{
+		Actor:       actor,
+		Action:      action,
+		SubjectType: subjectType,
+		SubjectID:   subjectID,
+		Details:     details,
+	})
+	if err != nil {
+		log.Printf("Couldn't write audit entry %s on %s %s: %v", action, subjectType, subjectID, err)
+	}
+}
+
+// handlerAdminAuditLog lists audit entries newest first, optionally
+// filtered by subject_type and subject_id.
+func (cfg *apiConfig) handlerAdminAuditLog(w http.ResponseWriter, r *http.Request) {
+	params, err := pagination.ParseRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid pagination parameters", err)
+		return
+	}
+
+	query := r.URL.Query()
+	entries, page, err := cfg.db.GetAuditLogPage(query.Get("subject_type"), query.Get("subject_id"), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve audit log", err)
+		return
+	}
+
+	pagination.WriteHeaders(w, page)
+	respondWithJSON(w, http.StatusOK, entries)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// permission is something a caller can need to do to a video.
+type permission int
+
+const (
+	// permView covers watching a private video.
+	permView permission = iota
+	// permEdit covers uploads, thumbnails, captions and metadata.
+	permEdit
+	// permManage covers deleting the video, giving it away and deciding
+	// who else has access to it.
+	permManage
+)
+
+func (p permission) String() string {
+	switch p {
+	case permView:
+		return "view"
+	case permEdit:
+		return "edit"
+	}
+	return "manage"
+}
+
+// roleAllows reports whether the role carries the permission.
+func roleAllows(role string, p permission) bool {
+	switch role {
+	case database.RoleOwner:
+		return true
+	case database.RoleEditor:
+		return p <= permEdit
+	case database.RoleViewer:
+		return p == permView
+	}
+	return false
+}
+
+// videoRole works out the strongest role the user has on the video. The
+// uploader owns it; in an organization's library, admins own it and
+// members edit it; anyone else needs a grant on the video or on one of
+// the folders it's filed under. It returns "" for no access.
+func (cfg *apiConfig) videoRole(video database.Video, userID uuid.UUID) (string, error) {
+	if video.UserID == userID {
+		return database.RoleOwner, nil
+	}
+
+	role := ""
+	if video.OrgID != nil {
+		member, err := cfg.db.GetOrganizationMember(*video.OrgID, userID)
+		if err != nil {
+			return "", err
+		}
+		switch member.Role {
+		case database.OrgRoleAdmin:
+			return database.RoleOwner, nil
+		case database.OrgRoleMember:
+			role = database.RoleEditor
+		}
+	}
+
+	var folderIDs []uuid.UUID
+	if video.FolderID != nil {
+		path, err := cfg.db.GetFolderPath(*video.FolderID)
+		if err != nil {
+			return "", err
+		}
+		for _, folder := range path {
+			folderIDs = append(folderIDs, folder.ID)
+		}
+	}
+	granted, err := cfg.db.GetGrantedRole(userID, video.ID, folderIDs)
+	if err != nil {
+		return "", err
+	}
+	if database.RoleRank(granted) > database.RoleRank(role) {
+		role = granted
+	}
+	return role, nil
+}
+
+// folderRole is videoRole for a folder: its owner owns it, and anyone else
+// needs a grant on it or on a folder above it.
+func (cfg *apiConfig) folderRole(folder database.Folder, userID uuid.UUID) (string, error) {
+	if folder.UserID == userID {
+		return database.RoleOwner, nil
+	}
+	path, err := cfg.db.GetFolderPath(folder.ID)
+	if err != nil {
+		return "", err
+	}
+	folderIDs := make([]uuid.UUID, 0, len(path))
+	for _, f := range path {
+		folderIDs = append(folderIDs, f.ID)
+	}
+	return cfg.db.GetGrantedRole(userID, uuid.Nil, folderIDs)
+}
+
+// authorizeVideo reports whether the user has the permission on the video.
+func (cfg *apiConfig) authorizeVideo(video database.Video, userID uuid.UUID, p permission) (bool, error) {
+	role, err := cfg.videoRole(video, userID)
+	if err != nil {
+		return false, err
+	}
+	return roleAllows(role, p), nil
+}
+
+// checkVideoAccess responds with an error unless the user has the
+// permission on the video.
+func (cfg *apiConfig) checkVideoAccess(w http.ResponseWriter, video database.Video, userID uuid.UUID, p permission, message string) bool {
+	allowed, err := cfg.authorizeVideo(video, userID, p)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check access", err)
+		return false
+	}
+	if !allowed {
+		respondWithErrorCode(w, http.StatusForbidden, errCodeNotOwner, message, nil)
+		return false
+	}
+	return true
+}
+
+// checkPrivateVideo lets anyone through to a public or unlisted video, but
+// a private one needs a signed-in caller who can view it. It responds if
+// they can't.
+func (cfg *apiConfig) checkPrivateVideo(w http.ResponseWriter, r *http.Request, video database.Video) bool {
+	if video.Visibility != database.VisibilityPrivate {
+		return true
+	}
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return false
+	}
+	return cfg.checkVideoAccess(w, video, userID, permView, "This video is private")
+}
+
+// privateObjects puts checkPrivateVideo in front of the objects served by
+// the store itself, so a private video's file isn't open to anyone who
+// learns its key.
+func (cfg *apiConfig) privateObjects(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		video, err := cfg.db.GetVideoByKey(strings.TrimPrefix(r.URL.Path, "/"))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		if video.ID != uuid.Nil && !cfg.checkPrivateVideo(w, r, video) {
+			return
+		}
+		if video.ID != uuid.Nil {
+			cfg.recordVideoAccess(video)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorizedVideo loads the video named in the path and checks the caller
+// has the permission on it, responding if not.
+func (cfg *apiConfig) authorizedVideo(w http.ResponseWriter, r *http.Request, p permission) (database.Video, bool) {
+	video, _, ok := cfg.authorizedVideoUser(w, r, p)
+	return video, ok
+}
+
+// authorizedVideoUser is authorizedVideo for handlers that also need to
+// know who the caller is, which isn't always the video's owner.
+func (cfg *apiConfig) authorizedVideoUser(w http.ResponseWriter, r *http.Request, p permission) (database.Video, uuid.UUID, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid ID", err)
+		return database.Video{}, uuid.Nil, false
+	}
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return database.Video{}, uuid.Nil, false
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, uuid.Nil, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithErrorCode(w, http.StatusNotFound, errCodeVideoNotFound, "Video not found", nil)
+		return database.Video{}, uuid.Nil, false
+	}
+	if !cfg.checkVideoAccess(w, video, userID, p, "You can't "+p.String()+" this video") {
+		return database.Video{}, uuid.Nil, false
+	}
+	return video, userID, true
+}
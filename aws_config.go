@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// loadAWSConfig loads the SDK's default configuration for region. Its
+// credential chain already covers IRSA and other web identity setups
+// through AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE. When
+// ASSUME_ROLE_ARN is set, those credentials are only used to assume that
+// role, and the role's short-lived credentials are refreshed before they
+// expire.
+func loadAWSConfig(ctx context.Context, region string) (aws.Config, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("couldn't load default config: %w", err)
+	}
+	roleARN := os.Getenv("ASSUME_ROLE_ARN")
+	if roleARN == "" {
+		return awsConfig, nil
+	}
+
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsConfig), roleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = os.Getenv("ASSUME_ROLE_SESSION_NAME")
+		if o.RoleSessionName == "" {
+			o.RoleSessionName = "tubely"
+		}
+		o.Duration = envDuration("ASSUME_ROLE_DURATION", time.Hour)
+		if externalID := os.Getenv("ASSUME_ROLE_EXTERNAL_ID"); externalID != "" {
+			o.ExternalID = aws.String(externalID)
+		}
+	})
+	awsConfig.Credentials = aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = envDuration("ASSUME_ROLE_REFRESH_WINDOW", 5*time.Minute)
+		o.ExpiryWindowJitterFrac = 0.5
+	})
+
+	// Assume the role now, so a trust policy or external ID mistake stops
+	// the server at startup instead of failing its first upload.
+	creds, err := awsConfig.Credentials.Retrieve(ctx)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("couldn't assume role %s: %w", roleARN, err)
+	}
+	log.Printf("Assumed role %s until %s", roleARN, creds.Expires.Format(time.RFC3339))
+	return awsConfig, nil
+}
@@ -1,10 +1,149 @@
 package main
 
-import "net/http"
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
 
-func noCacheMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Cache-Control", "no-store")
-		next.ServeHTTP(w, r)
-	})
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cache"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// appCache keeps video rows and signed playback URLs in Redis, so popular
+// videos are served without a database query or a signature per view.
+// Every method falls back to the database or the signer when Redis is
+// unreachable: a cache outage only makes pages slower.
+type appCache struct {
+	redis  *cache.Redis
+	prefix string
+	// videoTTL bounds how stale a video read just before an update can
+	// get. Updates drop the cached row, but a read that started before
+	// one can put the old row back.
+	videoTTL time.Duration
+}
+
+// newAppCache returns nil when REDIS_URL isn't set, which turns caching
+// off.
+func newAppCache() (*appCache, error) {
+	rawURL := os.Getenv("REDIS_URL")
+	if rawURL == "" {
+		return nil, nil
+	}
+	redis, err := cache.NewRedis(rawURL, envInt("REDIS_POOL_SIZE", 10))
+	if err != nil {
+		return nil, err
+	}
+	redis.Timeout = envDuration("REDIS_TIMEOUT", 500*time.Millisecond)
+	return &appCache{
+		redis:    redis,
+		prefix:   cmp.Or(os.Getenv("REDIS_KEY_PREFIX"), "tubely:"),
+		videoTTL: envDuration("CACHE_VIDEO_TTL", time.Minute),
+	}, nil
+}
+
+func (c *appCache) videoKey(id uuid.UUID) string {
+	return c.prefix + "video:" + id.String()
+}
+
+// invalidateVideos drops the cached rows of the videos. It's called by the
+// database client after it writes them, before the write is reported
+// back, so the next read sees the change.
+func (c *appCache) invalidateVideos(ids ...uuid.UUID) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = c.videoKey(id)
+	}
+	if err := c.redis.Delete(context.Background(), keys...); err != nil {
+		log.Printf("Couldn't drop cached videos %v: %v", ids, err)
+	}
+}
+
+// getVideo is cfg.db.GetVideo for read-only requests, served from the
+// cache when the video is in it. Rows are stored with gob rather than
+// JSON, which leaves out the fields clients don't see.
+func (cfg *apiConfig) getVideo(ctx context.Context, id uuid.UUID) (database.Video, error) {
+	c := cfg.cache
+	if c == nil {
+		return cfg.db.GetVideo(id)
+	}
+	key := c.videoKey(id)
+	data, ok, err := c.redis.Get(ctx, key)
+	if err != nil {
+		log.Printf("Couldn't read cached video %s: %v", id, err)
+	}
+	if ok {
+		var video database.Video
+		err := gob.NewDecoder(bytes.NewReader(data)).Decode(&video)
+		if err == nil {
+			return video, nil
+		}
+		log.Printf("Couldn't decode cached video %s: %v", id, err)
+	}
+
+	video, err := cfg.db.GetVideo(id)
+	if err != nil || video.ID == uuid.Nil {
+		return video, err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(video); err != nil {
+		log.Printf("Couldn't encode video %s for the cache: %v", id, err)
+		return video, nil
+	}
+	if err := c.redis.Set(ctx, key, buf.Bytes(), c.videoTTL); err != nil {
+		log.Printf("Couldn't cache video %s: %v", id, err)
+	}
+	return video, nil
+}
+
+// signedURL is a signed URL and when it stops working.
+type signedURL struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// signPlaybackURL signs the object at key under the playback policy for
+// visibility. URLs are cached for half their lifetime, so the ones handed
+// out from the cache still have at least half of it left. The cache key
+// covers the policy and network the URL was signed for, so a video made
+// private doesn't hand out its public URLs.
+func (cfg *apiConfig) signPlaybackURL(ctx context.Context, key, visibility string, now time.Time, clientIP string) (signedURL, error) {
+	policy := cfg.playbackPolicies[visibility]
+	opts := policy.signOptions(now, clientIP)
+	sign := func() (signedURL, error) {
+		url, err := cfg.store.SignedURL(key, opts)
+		return signedURL{URL: url, Expires: opts.Expires}, err
+	}
+	c := cfg.cache
+	if c == nil {
+		return sign()
+	}
+
+	cacheKey := c.prefix + "url:" + visibility + ":" + opts.IPRange + ":" + key
+	data, ok, err := c.redis.Get(ctx, cacheKey)
+	if err != nil {
+		log.Printf("Couldn't read cached URL for %s: %v", key, err)
+	}
+	if ok {
+		var cached signedURL
+		if err := json.Unmarshal(data, &cached); err == nil && cached.Expires.Sub(now) >= policy.TTL/2 {
+			return cached, nil
+		}
+	}
+
+	signed, err := sign()
+	if err != nil {
+		return signed, err
+	}
+	if data, err := json.Marshal(signed); err == nil {
+		if err := c.redis.Set(ctx, cacheKey, data, policy.TTL/2); err != nil {
+			log.Printf("Couldn't cache URL for %s: %v", key, err)
+		}
+	}
+	return signed, nil
 }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxChapterTitleLength = 100
+
+// storeProbedChapters records the chapter markers embedded in the
+// processed video at path. Failing to read them only costs the chapters,
+// so errors are logged rather than failing the upload.
+func (cfg *apiConfig) storeProbedChapters(ctx context.Context, videoID uuid.UUID, path string) {
+	metadata, err := cfg.media.Probe(ctx, path)
+	if err != nil {
+		log.Printf("Couldn't probe chapters of video %s: %v", videoID, err)
+		return
+	}
+	embedded := metadata.EmbeddedChapters()
+	chapters := make([]database.CreateChapterParams, 0, len(embedded))
+	for _, c := range embedded {
+		// Container metadata isn't always valid UTF-8, and cutting it
+		// by bytes could split a character.
+		title := strings.ToValidUTF8(c.Title, "\uFFFD")
+		if runes := []rune(title); len(runes) > maxChapterTitleLength {
+			title = string(runes[:maxChapterTitleLength])
+		}
+		chapters = append(chapters, database.CreateChapterParams{
+			VideoID:      videoID,
+			StartSeconds: c.StartSeconds,
+			Title:        title,
+		})
+	}
+	if err := cfg.db.ReplaceProbedChapters(videoID, chapters); err != nil {
+		log.Printf("Couldn't save chapters of video %s: %v", videoID, err)
+	}
+}
+
+type chapterParameters struct {
+	StartSeconds *float64 `json:"start_seconds"`
+	Title        string   `json:"title"`
+}
+
+// decodeChapter reads and validates a chapter body, responding and
+// returning false if it's invalid.
+func decodeChapter(w http.ResponseWriter, r *http.Request) (chapterParameters, bool) {
+	params := chapterParameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return params, false
+	}
+	params.Title = strings.TrimSpace(params.Title)
+
+	var details []errorDetail
+	switch {
+	case params.StartSeconds == nil:
+		details = append(details, errorDetail{Field: "start_seconds", Message: "is required"})
+	case *params.StartSeconds < 0 || math.IsNaN(*params.StartSeconds) || math.IsInf(*params.StartSeconds, 0):
+		details = append(details, errorDetail{Field: "start_seconds", Message: "must be zero or more"})
+	}
+	switch {
+	case params.Title == "":
+		details = append(details, errorDetail{Field: "title", Message: "is required"})
+	case utf8.RuneCountInString(params.Title) > maxChapterTitleLength:
+		details = append(details, errorDetail{Field: "title", Message: "must be at most 100 characters"})
+	}
+	if len(details) > 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid chapter",
+			Details: details,
+		})
+		return params, false
+	}
+	return params, true
+}
+
+// ownedChapter loads the chapter named in the path and checks it belongs
+// to the caller's video, responding if not.
+func (cfg *apiConfig) ownedChapter(w http.ResponseWriter, r *http.Request) (database.Chapter, bool) {
+	video, ok := cfg.authorizedVideo(w, r, permEdit)
+	if !ok {
+		return database.Chapter{}, false
+	}
+	chapterID, err := uuid.Parse(r.PathValue("chapterID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid chapter ID", err)
+		return database.Chapter{}, false
+	}
+	chapter, err := cfg.db.GetChapter(chapterID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chapter", err)
+		return database.Chapter{}, false
+	}
+	if chapter.ID == uuid.Nil || chapter.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Chapter not found", nil)
+		return database.Chapter{}, false
+	}
+	return chapter, true
+}
+
+func (cfg *apiConfig) handlerVideoChaptersGet(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.watchableVideo(w, r)
+	if !ok {
+		return
+	}
+
+	chapters, err := cfg.db.GetChapters(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chapters", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, chapters)
+}
+
+func (cfg *apiConfig) handlerVideoChapterCreate(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizedVideo(w, r, permEdit)
+	if !ok {
+		return
+	}
+	params, ok := decodeChapter(w, r)
+	if !ok {
+		return
+	}
+
+	chapter, err := cfg.db.CreateChapter(database.CreateChapterParams{
+		VideoID:      video.ID,
+		StartSeconds: *params.StartSeconds,
+		Title:        params.Title,
+		Source:       database.ChapterManual,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chapter", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, chapter)
+}
+
+func (cfg *apiConfig) handlerVideoChapterUpdate(w http.ResponseWriter, r *http.Request) {
+	chapter, ok := cfg.ownedChapter(w, r)
+	if !ok {
+		return
+	}
+	params, ok := decodeChapter(w, r)
+	if !ok {
+		return
+	}
+
+	chapter.StartSeconds = *params.StartSeconds
+	chapter.Title = params.Title
+	chapter, err := cfg.db.UpdateChapter(chapter)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chapter", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, chapter)
+}
+
+func (cfg *apiConfig) handlerVideoChapterDelete(w http.ResponseWriter, r *http.Request) {
+	chapter, ok := cfg.ownedChapter(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.DeleteChapter(chapter.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chapter", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+type credentials struct {
+	Server       string `json:"server"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type video struct {
+	ID           string    `json:"id"`
+	Title        string    `json:"title"`
+	Description  string    `json:"description"`
+	ThumbnailURL *string   `json:"thumbnail_url"`
+	VideoURL     *string   `json:"video_url"`
+	VideoSize    int64     `json:"video_size"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type apiError struct {
+	Status  int
+	Message string `json:"error"`
+	Code    string `json:"code"`
+}
+
+func (e *apiError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("%s (%s, HTTP %d)", e.Message, e.Code, e.Status)
+	}
+	return fmt.Sprintf("%s (HTTP %d)", e.Message, e.Status)
+}
+
+type apiClient struct {
+	baseURL string
+	creds   *credentials
+	http    http.Client
+}
+
+func defaultServer() string {
+	if server := os.Getenv("TUBELY_SERVER"); server != "" {
+		return server
+	}
+	return "http://localhost:8091"
+}
+
+func credentialsPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "tubely", "credentials.json")
+}
+
+func saveCredentials(creds credentials) error {
+	path := credentialsPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	dat, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, dat, 0600)
+}
+
+func newAuthenticatedClient() (*apiClient, error) {
+	dat, err := os.ReadFile(credentialsPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errors.New("not logged in, run `tubely login` first")
+		}
+		return nil, err
+	}
+	creds := credentials{}
+	if err := json.Unmarshal(dat, &creds); err != nil {
+		return nil, fmt.Errorf("couldn't read credentials: %w", err)
+	}
+	return &apiClient{baseURL: creds.Server, creds: &creds}, nil
+}
+
+func (c *apiClient) login(email, password string) (credentials, error) {
+	body, _ := json.Marshal(map[string]string{"email": email, "password": password})
+	creds := credentials{}
+	err := c.doJSON(http.MethodPost, "/api/login", bytes.NewReader(body), "application/json", &creds)
+	return creds, err
+}
+
+func (c *apiClient) createVideo(title, description string) (video, error) {
+	body, _ := json.Marshal(map[string]string{"title": title, "description": description})
+	v := video{}
+	err := c.doJSON(http.MethodPost, "/api/videos", bytes.NewReader(body), "application/json", &v)
+	return v, err
+}
+
+func (c *apiClient) listVideos(limit int, cursor string) ([]video, string, error) {
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(limit))
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	videos := []video{}
+	resp, err := c.do(http.MethodGet, "/api/videos?"+query.Encode(), nil, "")
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&videos); err != nil {
+		return nil, "", err
+	}
+	return videos, resp.Header.Get("X-Next-Cursor"), nil
+}
+
+func (c *apiClient) deleteVideo(id string) error {
+	resp, err := c.do(http.MethodDelete, "/api/videos/"+id, nil, "")
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// uploadFile streams a file as a single multipart form field, so large
+// videos are never buffered in memory.
+func (c *apiClient) uploadFile(path, field, filePath, contentType string, showProgress bool) (video, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return video{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return video{}, err
+	}
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(filePath))
+	}
+	if contentType == "" {
+		return video{}, errors.New("couldn't guess media type, pass -type")
+	}
+
+	var src io.Reader = file
+	if showProgress {
+		progress := newProgressReader(file, info.Size())
+		defer progress.finish()
+		src = progress
+	}
+
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, field, filepath.Base(filePath)))
+		header.Set("Content-Type", contentType)
+		part, err := form.CreatePart(header)
+		if err == nil {
+			_, err = io.Copy(part, src)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	v := video{}
+	err = c.doJSON(http.MethodPost, path, pr, form.FormDataContentType(), &v)
+	return v, err
+}
+
+func (c *apiClient) doJSON(method, path string, body io.Reader, contentType string, out any) error {
+	resp, err := c.do(method, path, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *apiClient) do(method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return c.send(req)
+}
+
+func (c *apiClient) doRequestJSON(req *http.Request, out any) error {
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send authenticates req and sends it, turning error responses into
+// *apiError.
+func (c *apiClient) send(req *http.Request) (*http.Response, error) {
+	if c.creds != nil {
+		req.Header.Set("Authorization", "Bearer "+c.creds.Token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		apiErr := &apiError{Status: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(apiErr); err != nil || apiErr.Message == "" {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
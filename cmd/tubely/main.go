@@ -0,0 +1,230 @@
+// Command tubely is a command line client for the Tubely API.
+//
+//	tubely login -email me@example.com -password secret
+//	tubely upload -title "Launch" launch.mp4
+//	tubely upload -id <videoID> -resumable launch.mp4
+//	tubely thumbnail -id <videoID> poster.png
+//	tubely list
+//	tubely delete -id <videoID>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	commands := map[string]func(args []string) error{
+		"login":     runLogin,
+		"logout":    runLogout,
+		"create":    runCreate,
+		"upload":    runUpload,
+		"thumbnail": runThumbnail,
+		"list":      runList,
+		"delete":    runDelete,
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := run(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "tubely:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: tubely <command> [flags]
+
+commands:
+  login      log in and store credentials
+  logout     forget stored credentials
+  create     create a video draft
+  upload     upload a video file (creating the video unless -id is given)
+  thumbnail  upload a thumbnail image for a video
+  list       list your videos
+  delete     delete a video`)
+}
+
+func runLogin(args []string) error {
+	flags := flag.NewFlagSet("login", flag.ExitOnError)
+	server := flags.String("server", defaultServer(), "API base URL")
+	email := flags.String("email", "", "account email")
+	password := flags.String("password", "", "account password")
+	flags.Parse(args)
+
+	if *email == "" || *password == "" {
+		return fmt.Errorf("-email and -password are required")
+	}
+
+	client := &apiClient{baseURL: *server}
+	creds, err := client.login(*email, *password)
+	if err != nil {
+		return err
+	}
+	creds.Server = *server
+	if err := saveCredentials(creds); err != nil {
+		return err
+	}
+	fmt.Println("logged in as", *email)
+	return nil
+}
+
+func runLogout(args []string) error {
+	err := os.Remove(credentialsPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func runCreate(args []string) error {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	title := flags.String("title", "", "video title")
+	description := flags.String("description", "", "video description")
+	flags.Parse(args)
+
+	client, err := newAuthenticatedClient()
+	if err != nil {
+		return err
+	}
+	video, err := client.createVideo(*title, *description)
+	if err != nil {
+		return err
+	}
+	fmt.Println(video.ID)
+	return nil
+}
+
+func runUpload(args []string) error {
+	flags := flag.NewFlagSet("upload", flag.ExitOnError)
+	videoID := flags.String("id", "", "existing video ID")
+	title := flags.String("title", "", "title for the new video")
+	description := flags.String("description", "", "description for the new video")
+	contentType := flags.String("type", "", "media type (guessed from the extension by default)")
+	quiet := flags.Bool("quiet", false, "don't show progress")
+	resumable := flags.Bool("resumable", false, "upload in chunks through an upload session, resuming an interrupted one")
+	chunkSize := flags.Int64("chunk", 16<<20, "chunk size in bytes for -resumable")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: tubely upload [-id ID | -title TITLE] [-resumable] FILE")
+	}
+	path := flags.Arg(0)
+
+	client, err := newAuthenticatedClient()
+	if err != nil {
+		return err
+	}
+
+	if *videoID == "" {
+		if *title == "" {
+			return fmt.Errorf("-title is required when creating a new video")
+		}
+		video, err := client.createVideo(*title, *description)
+		if err != nil {
+			return err
+		}
+		*videoID = video.ID
+		if *resumable {
+			// Interrupted uploads resume into the same video, so say
+			// which one that is.
+			fmt.Fprintln(os.Stderr, "created video", video.ID, "(pass -id to resume)")
+		}
+	}
+
+	var video video
+	if *resumable {
+		video, err = client.uploadResumable(*videoID, path, *contentType, *chunkSize, !*quiet)
+	} else {
+		video, err = client.uploadFile("/api/video_upload/"+*videoID, "video", path, *contentType, !*quiet)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(video.ID, valueOr(video.VideoURL, ""))
+	return nil
+}
+
+func runThumbnail(args []string) error {
+	flags := flag.NewFlagSet("thumbnail", flag.ExitOnError)
+	videoID := flags.String("id", "", "video ID")
+	contentType := flags.String("type", "", "media type (guessed from the extension by default)")
+	flags.Parse(args)
+
+	if *videoID == "" || flags.NArg() != 1 {
+		return fmt.Errorf("usage: tubely thumbnail -id ID FILE")
+	}
+
+	client, err := newAuthenticatedClient()
+	if err != nil {
+		return err
+	}
+	video, err := client.uploadFile("/api/thumbnail_upload/"+*videoID, "thumbnail", flags.Arg(0), *contentType, false)
+	if err != nil {
+		return err
+	}
+	fmt.Println(video.ID, valueOr(video.ThumbnailURL, ""))
+	return nil
+}
+
+func runList(args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	all := flags.Bool("all", false, "follow cursors and list every video")
+	limit := flags.Int("limit", 50, "videos per page")
+	flags.Parse(args)
+
+	client, err := newAuthenticatedClient()
+	if err != nil {
+		return err
+	}
+
+	cursor := ""
+	for {
+		videos, next, err := client.listVideos(*limit, cursor)
+		if err != nil {
+			return err
+		}
+		for _, video := range videos {
+			status := "no video"
+			if video.VideoURL != nil {
+				status = fmt.Sprintf("%d bytes", video.VideoSize)
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\n", video.ID, video.CreatedAt.Format("2006-01-02 15:04"), status, video.Title)
+		}
+		if !*all || next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func runDelete(args []string) error {
+	flags := flag.NewFlagSet("delete", flag.ExitOnError)
+	videoID := flags.String("id", "", "video ID")
+	flags.Parse(args)
+
+	if *videoID == "" {
+		return fmt.Errorf("-id is required")
+	}
+
+	client, err := newAuthenticatedClient()
+	if err != nil {
+		return err
+	}
+	return client.deleteVideo(*videoID)
+}
+
+func valueOr(s *string, def string) string {
+	if s == nil {
+		return def
+	}
+	return *s
+}
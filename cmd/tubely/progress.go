@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// progressReader draws a progress bar on stderr as the wrapped reader is
+// consumed.
+type progressReader struct {
+	r         io.Reader
+	total     int64
+	read      int64
+	started   time.Time
+	lastDrawn time.Time
+}
+
+func newProgressReader(r io.Reader, total int64) *progressReader {
+	return &progressReader{r: r, total: total, started: time.Now()}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if time.Since(p.lastDrawn) > 100*time.Millisecond {
+		p.draw()
+	}
+	return n, err
+}
+
+func (p *progressReader) draw() {
+	p.lastDrawn = time.Now()
+	const width = 30
+	fraction := 1.0
+	if p.total > 0 {
+		fraction = float64(p.read) / float64(p.total)
+	}
+	filled := int(fraction * width)
+	rate := float64(p.read) / max(time.Since(p.started).Seconds(), 0.001)
+	fmt.Fprintf(os.Stderr, "\r[%s%s] %3.0f%% %s/%s %s/s ",
+		strings.Repeat("=", filled),
+		strings.Repeat(" ", width-filled),
+		fraction*100,
+		formatBytes(p.read),
+		formatBytes(p.total),
+		formatBytes(int64(rate)),
+	)
+}
+
+func (p *progressReader) finish() {
+	p.draw()
+	fmt.Fprintln(os.Stderr)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
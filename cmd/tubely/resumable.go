@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// uploadOffsetHeader carries a chunk's byte offset to the server and the
+// session's committed offset back.
+const uploadOffsetHeader = "Upload-Offset"
+
+// chunkRetries is how many times a chunk is retried, after re-reading the
+// session's offset, before the upload gives up. The session is kept, so a
+// later run picks up where this one stopped.
+const chunkRetries = 5
+
+type uploadSession struct {
+	ID          string `json:"id"`
+	VideoID     string `json:"video_id"`
+	Offset      int64  `json:"offset"`
+	Size        int64  `json:"size"`
+	MinPartSize int64  `json:"min_part_size"`
+	Completed   bool   `json:"completed"`
+	Video       *video `json:"video"`
+}
+
+func (c *apiClient) createUploadSession(videoID, contentType, fileName string, size int64) (uploadSession, error) {
+	body, _ := json.Marshal(map[string]any{"content_type": contentType, "size": size, "file_name": fileName})
+	session := uploadSession{}
+	err := c.doJSON(http.MethodPost, "/api/video_upload/"+videoID+"/sessions", bytes.NewReader(body), "application/json", &session)
+	return session, err
+}
+
+func (c *apiClient) getUploadSession(id string) (uploadSession, error) {
+	session := uploadSession{}
+	err := c.doJSON(http.MethodGet, "/api/upload_sessions/"+id, nil, "", &session)
+	return session, err
+}
+
+// putChunk sends chunk as the bytes of the session starting at offset.
+func (c *apiClient) putChunk(id string, offset int64, chunk []byte) (uploadSession, error) {
+	req, err := http.NewRequest(http.MethodPut, c.baseURL+"/api/upload_sessions/"+id, bytes.NewReader(chunk))
+	if err != nil {
+		return uploadSession{}, err
+	}
+	sum := md5.Sum(chunk)
+	req.Header.Set(uploadOffsetHeader, strconv.FormatInt(offset, 10))
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	session := uploadSession{}
+	err = c.doRequestJSON(req, &session)
+	return session, err
+}
+
+// uploadResumable uploads a file through an upload session, in chunks of
+// at least chunkSize bytes. The session's ID is kept in the state file
+// until the upload completes, so running the same upload again after a
+// crash or a dropped connection resumes it from the server's offset
+// instead of starting over.
+func (c *apiClient) uploadResumable(videoID, filePath, contentType string, chunkSize int64, showProgress bool) (video, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return video{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return video{}, err
+	}
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(filePath))
+	}
+	if contentType == "" {
+		return video{}, errors.New("couldn't guess media type, pass -type")
+	}
+
+	stateKey, err := uploadStateKey(videoID, filePath, info)
+	if err != nil {
+		return video{}, err
+	}
+	session, err := c.resumeUploadSession(stateKey)
+	if err != nil {
+		return video{}, err
+	}
+	if session.ID == "" {
+		session, err = c.createUploadSession(videoID, contentType, filepath.Base(filePath), info.Size())
+		if err != nil {
+			return video{}, err
+		}
+		if err := saveUploadState(stateKey, session.ID); err != nil {
+			return video{}, err
+		}
+	} else if showProgress {
+		fmt.Fprintf(os.Stderr, "resuming upload at %s\n", formatBytes(session.Offset))
+	}
+
+	chunkSize = max(chunkSize, session.MinPartSize)
+	buf := make([]byte, chunkSize)
+	var progress *progressReader
+	if showProgress {
+		progress = newProgressReader(nil, info.Size())
+		defer progress.finish()
+	}
+
+	failures := 0
+	for !session.Completed {
+		if session.Offset >= info.Size() {
+			return video{}, errors.New("the server has the whole file but couldn't complete the upload, run the upload again to retry")
+		}
+		n, err := file.ReadAt(buf[:min(chunkSize, info.Size()-session.Offset)], session.Offset)
+		if err != nil && err != io.EOF {
+			return video{}, err
+		}
+		next, err := c.putChunk(session.ID, session.Offset, buf[:n])
+		if err != nil {
+			var apiErr *apiError
+			if errors.As(err, &apiErr) && apiErr.Status < 500 && apiErr.Status != http.StatusConflict {
+				return video{}, err
+			}
+			if failures++; failures > chunkRetries {
+				return video{}, fmt.Errorf("%w (run the upload again to resume)", err)
+			}
+			time.Sleep(time.Duration(failures) * time.Second)
+			// The chunk may have been committed before the response was
+			// lost, or another client moved the session on: the server's
+			// offset is the one to continue from.
+			if next, err = c.getUploadSession(session.ID); err != nil {
+				continue
+			}
+		} else {
+			failures = 0
+		}
+		session = next
+		if progress != nil {
+			progress.read = session.Offset
+			progress.draw()
+		}
+	}
+
+	if err := saveUploadState(stateKey, ""); err != nil {
+		return video{}, err
+	}
+	if session.Video == nil {
+		return video{}, errors.New("upload completed without a video")
+	}
+	return *session.Video, nil
+}
+
+// resumeUploadSession returns the session recorded for key, or a zero
+// session if there is none or the server no longer has it.
+func (c *apiClient) resumeUploadSession(key string) (uploadSession, error) {
+	states, err := loadUploadStates()
+	if err != nil || states[key] == "" {
+		return uploadSession{}, err
+	}
+	session, err := c.getUploadSession(states[key])
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && (apiErr.Status == http.StatusNotFound || apiErr.Status == http.StatusGone) {
+		return uploadSession{}, nil
+	}
+	return session, err
+}
+
+// uploadStateKey identifies an upload of a file to a video. The file's
+// size and modification time are part of it so an edited file isn't
+// resumed into a session started with its old contents.
+func uploadStateKey(videoID, filePath string, info os.FileInfo) (string, error) {
+	abs, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%s:%d:%d", videoID, abs, info.Size(), info.ModTime().UnixNano()), nil
+}
+
+func uploadStatePath() string {
+	return filepath.Join(filepath.Dir(credentialsPath()), "uploads.json")
+}
+
+func loadUploadStates() (map[string]string, error) {
+	states := map[string]string{}
+	dat, err := os.ReadFile(uploadStatePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return states, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(dat, &states); err != nil {
+		return nil, fmt.Errorf("couldn't read upload state: %w", err)
+	}
+	return states, nil
+}
+
+// saveUploadState records sessionID as the session for key, or forgets
+// key if sessionID is empty.
+func saveUploadState(key, sessionID string) error {
+	states, err := loadUploadStates()
+	if err != nil {
+		return err
+	}
+	if sessionID == "" {
+		delete(states, key)
+	} else {
+		states[key] = sessionID
+	}
+	path := uploadStatePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	dat, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, dat, 0600)
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// runCommand dispatches one-off maintenance subcommands, e.g.
+// `go run . normalize-extensions -dry-run`.
+func (cfg *apiConfig) runCommand(args []string) error {
+	switch args[0] {
+	case "normalize-extensions":
+		return cfg.commandNormalizeExtensions(args[1:])
+	case "migrate-bucket":
+		return cfg.commandMigrateBucket(args[1:])
+	case "setup":
+		return cfg.commandSetup(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// commandNormalizeExtensions renames thumbnails and video objects stored
+// under the old naive extension mapping (".jpeg", ".quicktime", ...) to the
+// extension mediaTypeToExtension now produces.
+func (cfg *apiConfig) commandNormalizeExtensions(args []string) error {
+	flags := flag.NewFlagSet("normalize-extensions", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only print what would be renamed")
+	flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return fmt.Errorf("couldn't list videos: %w", err)
+	}
+
+	renamed := 0
+	for _, video := range videos {
+		changed := false
+		// Renames already made for this video, undone if a later step fails
+		// so the row never points at a file that isn't there.
+		var undo []func()
+		rollback := func() {
+			for i := len(undo) - 1; i >= 0; i-- {
+				undo[i]()
+			}
+		}
+
+		if video.ThumbnailURL != nil {
+			oldName := path.Base(*video.ThumbnailURL)
+			newName := normalizeFilename(oldName, "image")
+			if newName != oldName {
+				log.Printf("thumbnail %s -> %s", oldName, newName)
+				if !*dryRun {
+					oldPath, newPath := cfg.getAssetDiskPath(oldName), cfg.getAssetDiskPath(newName)
+					if err := os.Rename(oldPath, newPath); err != nil {
+						return fmt.Errorf("couldn't rename thumbnail for video %s: %w", video.ID, err)
+					}
+					undo = append(undo, func() {
+						if err := os.Rename(newPath, oldPath); err != nil {
+							log.Printf("couldn't restore thumbnail %s for video %s: %v", oldName, video.ID, err)
+						}
+					})
+					thumbnailURL := strings.TrimSuffix(*video.ThumbnailURL, oldName) + newName
+					video.ThumbnailURL = &thumbnailURL
+				}
+				changed = true
+			}
+		}
+
+		if video.VideoKey != nil {
+			oldKey := *video.VideoKey
+			newKey := path.Join(path.Dir(oldKey), normalizeFilename(path.Base(oldKey), "video"))
+			if newKey != oldKey {
+				log.Printf("video %s -> %s", oldKey, newKey)
+				if !*dryRun {
+					if err := storage.Move(ctx, cfg.store, oldKey, newKey); err != nil {
+						rollback()
+						return fmt.Errorf("couldn't rename object for video %s: %w", video.ID, err)
+					}
+					undo = append(undo, func() {
+						// The interrupt may be what failed the update.
+						if err := storage.Move(context.Background(), cfg.store, newKey, oldKey); err != nil {
+							log.Printf("couldn't restore object %s for video %s: %v", oldKey, video.ID, err)
+						}
+					})
+					videoURL := cfg.store.URL(newKey)
+					video.VideoKey = &newKey
+					video.VideoURL = &videoURL
+				}
+				changed = true
+			}
+		}
+
+		if changed {
+			renamed++
+			if !*dryRun {
+				if err := cfg.db.UpdateVideo(video); err != nil {
+					rollback()
+					return fmt.Errorf("couldn't update video %s: %w", video.ID, err)
+				}
+			}
+		}
+	}
+
+	log.Printf("normalize-extensions: %d of %d videos affected (dry run: %t)", renamed, len(videos), *dryRun)
+	return nil
+}
+
+// normalizeFilename maps a filename's extension back to a media type and
+// returns the name with the canonical extension for that type. Extensions
+// the system doesn't know were produced by appending the subtype, so the
+// given top-level type is used to rebuild the media type.
+func normalizeFilename(name, topLevelType string) string {
+	ext := path.Ext(name)
+	if ext == "" {
+		return name
+	}
+
+	mediaType, _, _ := strings.Cut(mime.TypeByExtension(ext), ";")
+	if mediaType == "" {
+		mediaType = topLevelType + "/" + strings.TrimPrefix(ext, ".")
+	}
+	return strings.TrimSuffix(name, ext) + mediaTypeToExtension(mediaType)
+}
+
+// commandMigrateBucket copies video objects into another bucket, possibly
+// in another region, and repoints their rows, e.g.
+// `go run . migrate-bucket -bucket tubely-eu -region eu-west-1 -cdn d123.cloudfront.net`.
+// Every object a video refers to moves with it: the video itself, its
+// renditions and its caption tracks. Thumbnails and their renditions are
+// served from the assets directory, not the bucket. Keys stay the same.
+// Each video's rows are updated in the same transaction that records it as
+// migrated, so rerunning after an interruption skips what's done. Once it
+// finishes, switch S3_BUCKET, S3_REGION and S3_CF_DISTRO over to the new
+// bucket.
+func (cfg *apiConfig) commandMigrateBucket(args []string) error {
+	flags := flag.NewFlagSet("migrate-bucket", flag.ExitOnError)
+	bucket := flags.String("bucket", "", "destination bucket (required)")
+	region := flags.String("region", cfg.s3Region, "destination region")
+	cdn := flags.String("cdn", cfg.s3CfDistribution, "CloudFront distribution in front of the destination bucket")
+	prefix := flags.String("prefix", "", "only migrate objects whose key starts with this")
+	user := flags.String("user", "", "only migrate this user's videos")
+	deleteSource := flags.Bool("delete-source", false, "delete each source object once its row points at the copy")
+	dryRun := flags.Bool("dry-run", false, "only print what would be migrated")
+	flags.Parse(args)
+
+	if *bucket == "" {
+		return fmt.Errorf("migrate-bucket: -bucket is required")
+	}
+	src, ok := cfg.store.(*storage.S3Store)
+	if !ok {
+		return fmt.Errorf("migrate-bucket: only supported with the %s storage backend", storageBackendS3)
+	}
+	if *bucket == src.Bucket() {
+		return fmt.Errorf("migrate-bucket: destination is the current bucket")
+	}
+	var userID uuid.UUID
+	if *user != "" {
+		var err error
+		if userID, err = uuid.Parse(*user); err != nil {
+			return fmt.Errorf("migrate-bucket: invalid -user: %w", err)
+		}
+	}
+
+	dst, err := newS3Store(*bucket, *region, *cdn, cfg.urlSigner)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	migrated, err := cfg.db.GetMigratedVideoIDs(*bucket)
+	if err != nil {
+		return fmt.Errorf("couldn't load migration progress: %w", err)
+	}
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return fmt.Errorf("couldn't list videos: %w", err)
+	}
+
+	var copied, skipped int
+	for _, video := range videos {
+		if video.VideoKey == nil || !strings.HasPrefix(*video.VideoKey, *prefix) {
+			continue
+		}
+		if userID != uuid.Nil && video.UserID != userID {
+			continue
+		}
+		if migrated[video.ID] {
+			skipped++
+			continue
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("migrate-bucket: interrupted after %d videos, rerun to resume: %w", copied, ctx.Err())
+		}
+
+		key := *video.VideoKey
+		log.Printf("video %s: %s -> s3://%s/%s", video.ID, key, *bucket, key)
+		if *dryRun {
+			copied++
+			continue
+		}
+
+		if err := dst.CopyFrom(ctx, src, key, key); err != nil {
+			return fmt.Errorf("couldn't copy object for video %s: %w", video.ID, err)
+		}
+		info, err := dst.Stat(ctx, key)
+		if err != nil {
+			return fmt.Errorf("couldn't stat copy for video %s: %w", video.ID, err)
+		}
+		if video.VideoSize > 0 && info.Size != video.VideoSize {
+			return fmt.Errorf("copy for video %s is %d bytes, expected %d", video.ID, info.Size, video.VideoSize)
+		}
+		renditions, err := cfg.db.GetVideoRenditions(video.ID)
+		if err != nil {
+			return fmt.Errorf("couldn't get renditions of video %s: %w", video.ID, err)
+		}
+		for _, r := range renditions {
+			if err := copyMigratedObject(ctx, src, dst, r.Key, r.Size); err != nil {
+				return fmt.Errorf("couldn't copy %s rendition of video %s: %w", r.Name, video.ID, err)
+			}
+		}
+		captions, err := cfg.db.GetCaptions(video.ID)
+		if err != nil {
+			return fmt.Errorf("couldn't get captions of video %s: %w", video.ID, err)
+		}
+		for i, caption := range captions {
+			if err := copyMigratedObject(ctx, src, dst, caption.Key, 0); err != nil {
+				return fmt.Errorf("couldn't copy %s captions of video %s: %w", caption.Language, video.ID, err)
+			}
+			captions[i].URL = dst.URL(caption.Key)
+		}
+
+		videoURL := dst.URL(key)
+		video.VideoURL = &videoURL
+		video.VideoChecksum = info.ChecksumSHA256
+		video.VideoETag = info.ETag
+		video.VideoVersionID = info.VersionID
+		if err := cfg.db.CompleteVideoMigration(*bucket, video, captions); err != nil {
+			return fmt.Errorf("couldn't update video %s: %w", video.ID, err)
+		}
+		copied++
+		migrated[video.ID] = true
+
+		if *deleteSource {
+			cfg.deleteMigratedSource(ctx, src, video, renditions, captions, migrated)
+		}
+	}
+
+	log.Printf("migrate-bucket: %d videos migrated, %d already done (dry run: %t)", copied, skipped, *dryRun)
+	if !*dryRun {
+		log.Printf("migrate-bucket: point S3_BUCKET, S3_REGION and S3_CF_DISTRO at the new bucket and restart")
+	}
+	return nil
+}
+
+// copyMigratedObject copies key from src to dst, checking the copy's size
+// when it's known.
+func copyMigratedObject(ctx context.Context, src, dst *storage.S3Store, key string, size int64) error {
+	if err := dst.CopyFrom(ctx, src, key, key); err != nil {
+		return err
+	}
+	if size == 0 {
+		return nil
+	}
+	info, err := dst.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+	if info.Size != size {
+		return fmt.Errorf("copy of %s is %d bytes, expected %d", key, info.Size, size)
+	}
+	return nil
+}
+
+// deleteMigratedSource deletes the source objects of a migrated video once
+// nothing still reads them from there. A content-hash object and the
+// renditions named after it are shared, so they stay until every video
+// pointing at them has been migrated; a locked video's objects stay for
+// good.
+func (cfg *apiConfig) deleteMigratedSource(ctx context.Context, src *storage.S3Store, video database.Video, renditions []database.VideoRendition, captions []database.Caption, migrated map[uuid.UUID]bool) {
+	if video.Locked(time.Now()) {
+		return
+	}
+	deleteSource := func(key string) {
+		if err := src.Delete(ctx, key); err != nil {
+			log.Printf("couldn't delete source object %s: %v", key, err)
+		}
+	}
+	for _, caption := range captions {
+		deleteSource(caption.Key)
+	}
+
+	key := *video.VideoKey
+	others, err := cfg.videosSharingKey(key, video.ID)
+	if err != nil {
+		log.Printf("couldn't check whether source object %s is shared: %v", key, err)
+		return
+	}
+	for _, other := range others {
+		if !migrated[other.ID] || other.Locked(time.Now()) {
+			return
+		}
+	}
+	for _, r := range renditions {
+		deleteSource(r.Key)
+	}
+	deleteSource(key)
+}
+
+// commandSetup prepares the configured bucket for the server, e.g.
+// `go run . setup -cors-origins https://tubely.example.com`: it creates the
+// bucket if it's missing, blocks public access, allows direct uploads from
+// the app's origins, aborts abandoned multipart uploads and checks that
+// the server's credentials can write and delete objects. Every step can
+// be run again safely.
+func (cfg *apiConfig) commandSetup(args []string) error {
+	flags := flag.NewFlagSet("setup", flag.ExitOnError)
+	origins := flags.String("cors-origins", cfg.getBaseURL(), "comma separated origins allowed to upload directly to the bucket")
+	abortDays := flags.Int("abort-multipart-days", 7, "days after which incomplete multipart uploads are aborted")
+	flags.Parse(args)
+
+	store, ok := cfg.store.(*storage.S3Store)
+	if !ok {
+		return fmt.Errorf("setup: only supported with the %s storage backend", storageBackendS3)
+	}
+	if *abortDays <= 0 {
+		return fmt.Errorf("setup: -abort-multipart-days must be positive")
+	}
+	var allowed []string
+	for _, origin := range strings.Split(*origins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowed = append(allowed, origin)
+		}
+	}
+	if len(allowed) == 0 {
+		return fmt.Errorf("setup: -cors-origins is empty")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	created, err := store.EnsureBucket(ctx, cfg.s3Region, cfg.objectLock)
+	if err != nil {
+		return fmt.Errorf("setup: %w", err)
+	}
+	if created {
+		log.Printf("setup: created bucket %s in %s", store.Bucket(), cfg.s3Region)
+	} else {
+		log.Printf("setup: bucket %s already exists", store.Bucket())
+	}
+
+	if err := store.BlockPublicAccess(ctx); err != nil {
+		return fmt.Errorf("setup: couldn't block public access: %w", err)
+	}
+	log.Printf("setup: blocked public access")
+
+	if err := store.SetUploadCORS(ctx, allowed); err != nil {
+		return fmt.Errorf("setup: couldn't set CORS rules: %w", err)
+	}
+	log.Printf("setup: allowed direct uploads from %s", strings.Join(allowed, ", "))
+
+	if err := store.EnsureAbortIncompleteMultipart(ctx, int32(*abortDays)); err != nil {
+		return fmt.Errorf("setup: couldn't set lifecycle rules: %w", err)
+	}
+	log.Printf("setup: incomplete multipart uploads are aborted after %d days", *abortDays)
+
+	if err := store.CheckAccess(ctx, "setup-check/"+uuid.NewString()); err != nil {
+		return fmt.Errorf("setup: permission check failed: %w", err)
+	}
+	log.Printf("setup: put, head and delete work; the bucket is ready")
+	return nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/toml"
+)
+
+// configFileKeys maps the settings of the config file to the environment
+// variables they stand for. Anything else can go in its [env] table under
+// the variable's own name.
+var configFileKeys = map[string]string{
+	"server.port":              "PORT",
+	"server.platform":          "PLATFORM",
+	"server.filepath_root":     "FILEPATH_ROOT",
+	"server.external_base_url": "EXTERNAL_BASE_URL",
+	"server.grpc_port":         "GRPC_PORT",
+	"server.trust_proxy":       "TRUST_PROXY_HEADERS",
+	"server.debug_errors":      "DEBUG_ERRORS",
+	"database.path":            "DB_PATH",
+	"cache.redis_url":          "REDIS_URL",
+	"cache.video_ttl":          "CACHE_VIDEO_TTL",
+
+	"storage.backend":              "STORAGE_BACKEND",
+	"storage.root":                 "STORAGE_ROOT",
+	"storage.bucket":               "S3_BUCKET",
+	"storage.region":               "S3_REGION",
+	"storage.cf_distribution":      "S3_CF_DISTRO",
+	"storage.put_timeout":          "S3_PUT_TIMEOUT",
+	"storage.request_timeout":      "S3_REQUEST_TIMEOUT",
+	"storage.object_lock":          "OBJECT_LOCK_ENABLED",
+	"storage.key_strategy":         "KEY_STRATEGY",
+	"storage.scratch_mode":         "SCRATCH_MODE",
+	"storage.assets_root":          "ASSETS_ROOT",
+	"storage.assets_cache_control": "ASSETS_CACHE_CONTROL",
+
+	"limits.upload_rate_per_connection":   "UPLOAD_RATE_PER_CONNECTION",
+	"limits.upload_rate_per_user":         "UPLOAD_RATE_PER_USER",
+	"limits.download_rate_per_connection": "DOWNLOAD_RATE_PER_CONNECTION",
+	"limits.download_rate_per_user":       "DOWNLOAD_RATE_PER_USER",
+	"limits.reprocess_rate_per_minute":    "REPROCESS_RATE_PER_MINUTE",
+	"limits.channel_rate_per_client":      "CHANNEL_RATE_PER_CLIENT",
+	"limits.archive_max_members":          "ARCHIVE_MAX_MEMBERS",
+	"limits.search_max_results":           "SEARCH_MAX_RESULTS",
+	"limits.upload_session_ttl":           "UPLOAD_SESSION_TTL",
+	"limits.presign_upload_ttl":           "PRESIGN_UPLOAD_TTL",
+
+	"auth.jwt_secret":          "JWT_SECRET",
+	"auth.admin_api_key":       "ADMIN_API_KEY",
+	"auth.assets_require_auth": "ASSETS_REQUIRE_AUTH",
+}
+
+var envNamePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// configPreset is a transcoding preset from the config file.
+type configPreset struct {
+	ID string `json:"id"`
+	presetParameters
+}
+
+// loadConfigFile reads the TOML config file at path and sets the
+// environment variables its settings stand for. Variables that are already
+// set, including the ones from .env, override the file. Presets are
+// returned rather than applied, since they live in the database.
+func loadConfigFile(path string) ([]configPreset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := toml.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var (
+		problems configProblems
+		presets  []configPreset
+		settings = map[string]string{}
+	)
+	for section, v := range doc {
+		switch section {
+		case "presets":
+			presets = decodeConfigPresets(&problems, v)
+			continue
+		case "env":
+			table, ok := v.(map[string]any)
+			if !ok {
+				problems.add("env must be a table")
+				continue
+			}
+			for name, value := range table {
+				if !envNamePattern.MatchString(name) {
+					problems.add("env.%s isn't an environment variable name", name)
+					continue
+				}
+				settings[name] = configValue(&problems, "env."+name, value)
+			}
+			continue
+		}
+		table, ok := v.(map[string]any)
+		if !ok {
+			problems.add("unknown setting %s", section)
+			continue
+		}
+		for key, value := range table {
+			name, ok := configFileKeys[section+"."+key]
+			if !ok {
+				problems.add("unknown setting %s.%s", section, key)
+				continue
+			}
+			settings[name] = configValue(&problems, section+"."+key, value)
+		}
+	}
+	if err := problems.err(); err != nil {
+		return nil, fmt.Errorf("%s:\n%w", path, err)
+	}
+
+	for name, value := range settings {
+		if _, ok := os.LookupEnv(name); !ok {
+			os.Setenv(name, value)
+		}
+	}
+	return presets, nil
+}
+
+// configValue formats a setting the way its environment variable is
+// written. Arrays become comma separated lists.
+func configValue(problems *configProblems, key string, v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []any:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item.(type) {
+			case []any, map[string]any:
+				problems.add("%s can only list plain values", key)
+				return ""
+			}
+			parts = append(parts, configValue(problems, key, item))
+		}
+		return strings.Join(parts, ",")
+	}
+	problems.add("%s must be a plain value or a list", key)
+	return ""
+}
+
+// decodeConfigPresets reads the [[presets]] tables, checking them the same
+// way the admin API checks a new preset.
+func decodeConfigPresets(problems *configProblems, v any) []configPreset {
+	list, ok := v.([]any)
+	if !ok {
+		problems.add("presets must be an array of tables, written [[presets]]")
+		return nil
+	}
+	var presets []configPreset
+	seen := map[string]bool{}
+	for i, item := range list {
+		prefix := fmt.Sprintf("presets[%d]", i)
+		// The tables have the same shape as the API's JSON, so they go
+		// through the same decoder.
+		dat, err := json.Marshal(item)
+		if err != nil {
+			problems.add("%s: %v", prefix, err)
+			continue
+		}
+		decoder := json.NewDecoder(strings.NewReader(string(dat)))
+		decoder.DisallowUnknownFields()
+		var preset configPreset
+		if err := decoder.Decode(&preset); err != nil {
+			problems.add("%s: %v", prefix, err)
+			continue
+		}
+		if !presetIDPattern.MatchString(preset.ID) {
+			problems.add("%s.id must be lower case letters, digits, '-' or '_'", prefix)
+		} else if seen[preset.ID] {
+			problems.add("%s.id %s is used by another preset", prefix, preset.ID)
+		}
+		seen[preset.ID] = true
+		for _, detail := range preset.validate() {
+			problems.add("%s.%s %s", prefix, detail.Field, detail.Message)
+		}
+		presets = append(presets, preset)
+	}
+	return presets
+}
+
+// syncConfigPresets creates the presets from the config file, or updates
+// the stored ones to match. A preset whose new ladder is too long for a
+// plan using it is an error, as it is through the admin API.
+func (cfg *apiConfig) syncConfigPresets(presets []configPreset) error {
+	for _, p := range presets {
+		preset := database.TranscodingPreset{
+			ID:         p.ID,
+			Name:       p.Name,
+			VideoCodec: p.VideoCodec,
+			AudioCodec: p.AudioCodec,
+			Renditions: p.Renditions,
+		}
+		existing, err := cfg.db.GetPreset(p.ID)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			if _, err := cfg.db.CreatePreset(preset); err != nil {
+				return fmt.Errorf("couldn't create preset %s: %w", p.ID, err)
+			}
+			continue
+		}
+		details, err := cfg.presetPlanConflicts(p.ID, len(p.Renditions))
+		if err != nil {
+			return err
+		}
+		if len(details) > 0 {
+			return fmt.Errorf("preset %s: %s %s", p.ID, details[0].Field, details[0].Message)
+		}
+		if _, err := cfg.db.UpdatePreset(preset); err != nil {
+			return fmt.Errorf("couldn't update preset %s: %w", p.ID, err)
+		}
+	}
+	return nil
+}
+
+// configProblems collects everything wrong with the configuration, so it
+// can all be reported at once instead of one fix and restart at a time.
+type configProblems []string
+
+func (p *configProblems) add(format string, args ...any) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+// require returns the environment variable, noting a problem when it's
+// empty.
+func (p *configProblems) require(name string) string {
+	return p.required(name, os.Getenv(name))
+}
+
+// required notes a problem when the setting's value is empty.
+func (p *configProblems) required(name, value string) string {
+	if value == "" {
+		p.add("%s is not set%s", name, configKeyHint(name))
+	}
+	return value
+}
+
+// writableDir checks that the directory exists and files can be created in
+// it. A missing directory is fine when it can be created.
+func (p *configProblems) writableDir(name, dir string) {
+	info, err := os.Stat(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		dir = filepath.Dir(filepath.Clean(dir))
+		info, err = os.Stat(dir)
+	}
+	if err != nil {
+		p.add("%s: %v", name, err)
+		return
+	}
+	if !info.IsDir() {
+		p.add("%s: %s is not a directory", name, dir)
+		return
+	}
+	f, err := os.CreateTemp(dir, ".tubely-check-*")
+	if err != nil {
+		p.add("%s: %s is not writable: %v", name, dir, err)
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// binary checks that the program is on the PATH.
+func (p *configProblems) binary(name string) {
+	if _, err := exec.LookPath(name); err != nil {
+		p.add("%s was not found on the PATH; it's needed to process uploads", name)
+	}
+}
+
+func (p configProblems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	slices.Sort(p)
+	return errors.New("  - " + strings.Join(p, "\n  - "))
+}
+
+// configKeyHint names the config file setting for an environment variable,
+// if it has one.
+func configKeyHint(name string) string {
+	for key, env := range configFileKeys {
+		if env == name {
+			return " (" + key + " in the config file)"
+		}
+	}
+	return ""
+}
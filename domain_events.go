@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/eventbus"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/webhook"
+	"github.com/google/uuid"
+)
+
+// Types of the video lifecycle events published to the event bus.
+const (
+	domainVideoCreated   = "video.created"
+	domainVideoProcessed = "video.processed"
+	domainVideoDeleted   = "video.deleted"
+	domainVideoViewed    = "video.viewed"
+)
+
+type videoDomainEvent struct {
+	VideoID    uuid.UUID `json:"video_id"`
+	UserID     uuid.UUID `json:"user_id"`
+	Title      string    `json:"title,omitempty"`
+	Visibility string    `json:"visibility,omitempty"`
+}
+
+type videoViewedEvent struct {
+	VideoID  uuid.UUID `json:"video_id"`
+	ViewerID uuid.UUID `json:"viewer_id"`
+	Position float64   `json:"position"`
+}
+
+func newVideoDomainEvent(video database.Video) videoDomainEvent {
+	return videoDomainEvent{
+		VideoID:    video.ID,
+		UserID:     video.UserID,
+		Title:      video.Title,
+		Visibility: video.Visibility,
+	}
+}
+
+// domainEvents publishes events to the broker from a background goroutine,
+// so a slow or unreachable broker never holds up a request. When the queue
+// is full, events are dropped and logged.
+type domainEvents struct {
+	publisher eventbus.Publisher
+	queue     chan domainEvent
+}
+
+type domainEvent struct {
+	eventType string
+	key       string
+	body      []byte
+}
+
+// newDomainEvents returns nil when EVENT_PUBLISHER isn't set, which turns
+// publishing off.
+func newDomainEvents() (*domainEvents, error) {
+	timeout := envDuration("EVENT_PUBLISH_TIMEOUT", 10*time.Second)
+	var publisher eventbus.Publisher
+	switch kind := os.Getenv("EVENT_PUBLISHER"); kind {
+	case "":
+		return nil, nil
+	case "kafka":
+		brokers := strings.FieldsFunc(os.Getenv("KAFKA_BROKERS"), func(r rune) bool { return r == ',' || r == ' ' })
+		if len(brokers) == 0 {
+			return nil, fmt.Errorf("KAFKA_BROKERS must be set for the kafka publisher")
+		}
+		topic := os.Getenv("KAFKA_TOPIC")
+		if topic == "" {
+			topic = "tubely.videos"
+		}
+		tlsConfig, err := kafkaTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		mechanism := strings.ToUpper(os.Getenv("KAFKA_SASL_MECHANISM"))
+		if mechanism == eventbus.SASLPlain && !envBool("KAFKA_TLS", false) {
+			return nil, fmt.Errorf("KAFKA_SASL_MECHANISM=PLAIN sends the password in the clear; set KAFKA_TLS too")
+		}
+		publisher = &eventbus.Kafka{
+			Brokers:       brokers,
+			Topic:         topic,
+			TLS:           envBool("KAFKA_TLS", false),
+			TLSConfig:     tlsConfig,
+			SASLMechanism: mechanism,
+			Username:      os.Getenv("KAFKA_SASL_USERNAME"),
+			Password:      os.Getenv("KAFKA_SASL_PASSWORD"),
+			Timeout:       timeout,
+		}
+	case "nats":
+		url := os.Getenv("NATS_URL")
+		if url == "" {
+			return nil, fmt.Errorf("NATS_URL must be set for the nats publisher")
+		}
+		prefix, ok := os.LookupEnv("NATS_SUBJECT_PREFIX")
+		if !ok {
+			prefix = "tubely"
+		}
+		publisher = &eventbus.NATS{
+			URL:           url,
+			SubjectPrefix: prefix,
+			Timeout:       timeout,
+		}
+	default:
+		return nil, fmt.Errorf("unknown event publisher %q", kind)
+	}
+
+	d := &domainEvents{
+		publisher: publisher,
+		queue:     make(chan domainEvent, max(1, envInt("EVENT_QUEUE_SIZE", 1024))),
+	}
+	go d.run(timeout)
+	return d, nil
+}
+
+// kafkaTLSConfig loads the CA bundle and client certificate named by
+// KAFKA_TLS_CA_FILE, KAFKA_TLS_CERT_FILE and KAFKA_TLS_KEY_FILE, or returns
+// nil when none are set, leaving the system roots.
+func kafkaTLSConfig() (*tls.Config, error) {
+	caFile := os.Getenv("KAFKA_TLS_CA_FILE")
+	certFile := os.Getenv("KAFKA_TLS_CERT_FILE")
+	keyFile := os.Getenv("KAFKA_TLS_KEY_FILE")
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	config := &tls.Config{}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read KAFKA_TLS_CA_FILE: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("KAFKA_TLS_CA_FILE holds no PEM certificates")
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load the Kafka client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// Publish queues the event, keyed by the video it's about so a consumer
+// sees each video's events in order. A nil domainEvents drops it.
+func (d *domainEvents) Publish(eventType string, videoID uuid.UUID, data any) {
+	if d == nil {
+		return
+	}
+	body, err := webhook.Encode(eventType, data)
+	if err != nil {
+		log.Printf("Couldn't encode %s event: %v", eventType, err)
+		return
+	}
+	select {
+	case d.queue <- domainEvent{eventType: eventType, key: videoID.String(), body: body}:
+	default:
+		log.Printf("Event queue is full, dropped %s event for video %s", eventType, videoID)
+	}
+}
+
+func (d *domainEvents) run(timeout time.Duration) {
+	for e := range d.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := d.publisher.Publish(ctx, e.eventType, e.key, e.body)
+		cancel()
+		if err != nil {
+			log.Printf("Couldn't publish %s event for video %s: %v", e.eventType, e.key, err)
+		}
+	}
+}
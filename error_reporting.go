@@ -0,0 +1,99 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/errreport"
+)
+
+// errorReports sends 5xx responses and failed background work to the
+// error tracker ERROR_REPORTING_DSN points at. It's nil when none does.
+var errorReports *errreport.Queue
+
+// newErrorReports reads the error reporting configuration. Sentry is the
+// only tracker supported so far.
+func newErrorReports(platform string) (*errreport.Queue, error) {
+	dsn := os.Getenv("ERROR_REPORTING_DSN")
+	if dsn == "" {
+		return nil, nil
+	}
+	sentry, err := errreport.NewSentry(dsn)
+	if err != nil {
+		return nil, err
+	}
+	sentry.Environment = cmp.Or(os.Getenv("ERROR_REPORTING_ENVIRONMENT"), platform)
+	sentry.Release = os.Getenv("ERROR_REPORTING_RELEASE")
+	return errreport.NewQueue(sentry, envInt("ERROR_REPORTING_QUEUE_SIZE", 100), 10*time.Second), nil
+}
+
+// reportError queues e when error reporting is on.
+func reportError(e errreport.Event) {
+	if errorReports != nil {
+		errorReports.Report(e)
+	}
+}
+
+// reportTaskError reports a scheduled task that failed.
+func reportTaskError(task string, err error) {
+	reportError(errreport.Event{
+		Err:     err,
+		Message: fmt.Sprintf("%s task failed", task),
+		Tags:    map[string]string{"task": task},
+	})
+}
+
+// reportResponseError reports an error response, with the request it
+// answered when w came through errorReportingMiddleware.
+func reportResponseError(w http.ResponseWriter, apiErr apiError) {
+	reportError(errreport.Event{
+		Err:     apiErr.Err,
+		Message: apiErr.Message,
+		Request: requestOf(w),
+		Tags: map[string]string{
+			"request_id": w.Header().Get(requestIDHeader),
+			"status":     strconv.Itoa(apiErr.Status),
+			"code":       apiErr.Code,
+		},
+	})
+}
+
+// reportingWriter carries the request a response is for, so
+// respondWithAPIError can attach it to the errors it reports.
+type reportingWriter struct {
+	http.ResponseWriter
+	r *http.Request
+}
+
+func (w *reportingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// errorReportingMiddleware lets errors reported while serving a request
+// say which request it was. It goes innermost, so handlers get its
+// writer as is.
+func errorReportingMiddleware(next http.Handler) http.Handler {
+	if errorReports == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&reportingWriter{ResponseWriter: w, r: r}, r)
+	})
+}
+
+func requestOf(w http.ResponseWriter) *http.Request {
+	for {
+		if rw, ok := w.(*reportingWriter); ok {
+			return rw.r
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil
+		}
+		w = u.Unwrap()
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type countryContextKey struct{}
+
+// geoMiddleware works out the requester's country once and stores it in
+// the request context for checkGeoRestrictions. A country header set by a
+// trusted proxy or CDN wins over the GeoIP database.
+func (cfg *apiConfig) geoMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		country := ""
+		if cfg.trustProxyHeaders && cfg.geoCountryHeader != "" {
+			country = strings.ToUpper(strings.TrimSpace(r.Header.Get(cfg.geoCountryHeader)))
+		}
+		if country == "" && cfg.geoLocator != nil {
+			if addr, err := netip.ParseAddr(cfg.clientIP(r)); err == nil {
+				country = cfg.geoLocator.Country(addr)
+			}
+		}
+		ctx := context.WithValue(r.Context(), countryContextKey{}, country)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func requestCountry(ctx context.Context) string {
+	country, _ := ctx.Value(countryContextKey{}).(string)
+	return country
+}
+
+// checkGeoRestrictions applies the owner's restriction and then the
+// video's own, responding and returning false when playback isn't
+// allowed from the requester's country.
+func (cfg *apiConfig) checkGeoRestrictions(w http.ResponseWriter, r *http.Request, video database.Video) bool {
+	country := requestCountry(r.Context())
+	scopes := []struct {
+		scope   string
+		subject uuid.UUID
+	}{
+		{database.GeoScopeUser, video.UserID},
+		{database.GeoScopeVideo, video.ID},
+	}
+	for _, s := range scopes {
+		restriction, err := cfg.db.GetGeoRestriction(s.scope, s.subject)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check geo restrictions", err)
+			return false
+		}
+
+		var status int
+		var reason string
+		switch {
+		case country == "" && len(restriction.Allowed) > 0:
+			status, reason = http.StatusForbidden, "country could not be determined"
+		case slices.Contains(restriction.Blocked, country):
+			status, reason = http.StatusUnavailableForLegalReasons, fmt.Sprintf("not available in %s", country)
+		case len(restriction.Allowed) > 0 && !slices.Contains(restriction.Allowed, country):
+			status, reason = http.StatusUnavailableForLegalReasons, fmt.Sprintf("not available in %s", country)
+		default:
+			continue
+		}
+		respondWithAPIError(w, apiError{
+			Status:  status,
+			Code:    errCodeGeoRestricted,
+			Message: "This video isn't available in your location",
+			Details: []errorDetail{{Field: s.scope, Message: reason}},
+		})
+		return false
+	}
+	return true
+}
+
+// geoRestrictedObjects guards the objects served by the store itself,
+// which is how the filesystem and memory backends stream video.
+func (cfg *apiConfig) geoRestrictedObjects(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		video, err := cfg.db.GetVideoByKey(strings.TrimPrefix(r.URL.Path, "/"))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		if video.ID != uuid.Nil && !cfg.checkGeoRestrictions(w, r, video) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// parseGeoRestriction decodes and normalizes a restriction, responding
+// when a country code is malformed.
+func parseGeoRestriction(w http.ResponseWriter, r *http.Request) (database.GeoRestriction, bool) {
+	restriction := database.GeoRestriction{}
+	if err := json.NewDecoder(r.Body).Decode(&restriction); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return restriction, false
+	}
+
+	var details []errorDetail
+	normalize := func(field string, codes []string) []string {
+		out := []string{}
+		for _, code := range codes {
+			code = strings.ToUpper(strings.TrimSpace(code))
+			if len(code) != 2 || strings.Trim(code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+				details = append(details, errorDetail{Field: field, Message: fmt.Sprintf("%q isn't a two letter country code", code)})
+				continue
+			}
+			if !slices.Contains(out, code) {
+				out = append(out, code)
+			}
+		}
+		return out
+	}
+	restriction.Allowed = normalize("allowed_countries", restriction.Allowed)
+	restriction.Blocked = normalize("blocked_countries", restriction.Blocked)
+	if len(details) > 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid geo restriction",
+			Details: details,
+		})
+		return restriction, false
+	}
+	return restriction, true
+}
+
+func (cfg *apiConfig) handlerUserGeoGet(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+	restriction, err := cfg.db.GetGeoRestriction(database.GeoScopeUser, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get geo restriction", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, restriction)
+}
+
+func (cfg *apiConfig) handlerUserGeoUpdate(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+	restriction, ok := parseGeoRestriction(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.db.SetGeoRestriction(database.GeoScopeUser, userID, restriction); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save geo restriction", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, restriction)
+}
+
+func (cfg *apiConfig) handlerVideoGeoGet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizedVideo(w, r, permManage)
+	if !ok {
+		return
+	}
+	restriction, err := cfg.db.GetGeoRestriction(database.GeoScopeVideo, video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get geo restriction", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, restriction)
+}
+
+func (cfg *apiConfig) handlerVideoGeoUpdate(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizedVideo(w, r, permManage)
+	if !ok {
+		return
+	}
+	restriction, ok := parseGeoRestriction(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.db.SetGeoRestriction(database.GeoScopeVideo, video.ID, restriction); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save geo restriction", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, restriction)
+}
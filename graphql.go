@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/errreport"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
+	"github.com/google/uuid"
+	"github.com/graph-gophers/graphql-go"
+)
+
+const graphqlSchema = `
+schema {
+	query: Query
+}
+
+type Query {
+	# The authenticated user.
+	me: User!
+	video(id: ID!): Video
+	videos(limit: Int, cursor: String): VideoConnection!
+}
+
+type User {
+	id: ID!
+	email: String!
+	createdAt: String!
+	plan: Plan!
+	usage: Usage!
+}
+
+type Plan {
+	id: ID!
+	name: String!
+	maxFileSize: Float!
+	maxTotalStorage: Float!
+	maxRenditions: Int!
+	priority: Int!
+	maxUploadRate: Float!
+}
+
+type Usage {
+	bytesUsed: Float!
+	objectCount: Float!
+	updatedAt: String!
+}
+
+type Video {
+	id: ID!
+	title: String!
+	description: String!
+	thumbnailUrl: String
+	videoUrl: String
+	createdAt: String!
+	updatedAt: String!
+	# Lower resolution encodings, largest first.
+	renditions: [Rendition!]!
+	captions: [Caption!]!
+	# Owner-only fields.
+	videoSize: Float!
+	thumbnailSize: Float!
+	processing: ProcessingStatus!
+}
+
+type Rendition {
+	name: String!
+	url: String!
+	width: Int!
+	height: Int!
+	hdr: Boolean!
+	size: Float!
+}
+
+type Caption {
+	language: String!
+	source: String!
+	url: String!
+}
+
+type ProcessingStatus {
+	# "processing" while a job runs on this instance, otherwise "idle".
+	state: String!
+	stage: String
+	percent: Int!
+	startedAt: String
+	processedAt: String
+}
+
+type VideoConnection {
+	items: [Video!]!
+	nextCursor: String
+	prevCursor: String
+}
+`
+
+// graphqlError is an error resolvers return for the client to see. Any
+// other error they return is logged and replaced with a generic message,
+// unless DEBUG_ERRORS is on.
+type graphqlError string
+
+func (e graphqlError) Error() string { return string(e) }
+
+const (
+	errGraphQLUnauthenticated = graphqlError("authentication required")
+	errGraphQLForbidden       = graphqlError("only the owner can read this field")
+)
+
+type graphqlViewerKey struct{}
+
+func graphqlViewer(ctx context.Context) (uuid.UUID, bool) {
+	userID, ok := ctx.Value(graphqlViewerKey{}).(uuid.UUID)
+	return userID, ok
+}
+
+// handlerGraphQL executes GraphQL queries against the same database layer as
+// the REST handlers. Authentication is optional at the transport level;
+// resolvers decide per field whether a viewer is required.
+func (cfg *apiConfig) handlerGraphQL(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+
+	ctx := r.Context()
+	if token, err := auth.GetBearerToken(r.Header); err == nil {
+		userID, err := cfg.validateJWT(token)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+		ctx = context.WithValue(ctx, graphqlViewerKey{}, userID)
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+
+	response := cfg.graphqlSchema.Exec(ctx, params.Query, params.OperationName, params.Variables)
+	for _, queryErr := range response.Errors {
+		var public graphqlError
+		if queryErr.ResolverError == nil || errors.As(queryErr.ResolverError, &public) {
+			continue
+		}
+		requestID := w.Header().Get(requestIDHeader)
+		log.Printf("[%s] GraphQL resolver error at %v: %v", requestID, queryErr.Path, queryErr.ResolverError)
+		reportError(errreport.Event{
+			Err:     queryErr.ResolverError,
+			Request: requestOf(w),
+			Tags:    map[string]string{"request_id": requestID, "transport": "graphql"},
+			Extra:   map[string]any{"path": queryErr.Path},
+		})
+		if !exposeErrorDetails {
+			queryErr.Message = "internal error"
+		}
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) newGraphQLSchema() (*graphql.Schema, error) {
+	return graphql.ParseSchema(graphqlSchema, &graphqlQueryResolver{cfg: cfg}, graphql.UseFieldResolvers())
+}
+
+type graphqlQueryResolver struct {
+	cfg *apiConfig
+}
+
+func (q *graphqlQueryResolver) Me(ctx context.Context) (*graphqlUserResolver, error) {
+	userID, ok := graphqlViewer(ctx)
+	if !ok {
+		return nil, errGraphQLUnauthenticated
+	}
+	user, err := q.cfg.db.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errGraphQLUnauthenticated
+	}
+	return &graphqlUserResolver{cfg: q.cfg, user: *user}, nil
+}
+
+func (q *graphqlQueryResolver) Video(ctx context.Context, args struct{ ID graphql.ID }) (*graphqlVideoResolver, error) {
+	videoID, err := uuid.Parse(string(args.ID))
+	if err != nil {
+		return nil, graphqlError("invalid video ID")
+	}
+	video, err := q.cfg.db.GetVideo(videoID)
+	if err != nil {
+		return nil, err
+	}
+	if video.ID == uuid.Nil {
+		return nil, nil
+	}
+	// Private videos look missing to anyone who can't view them.
+	if video.Visibility == database.VisibilityPrivate {
+		userID, ok := graphqlViewer(ctx)
+		if !ok {
+			return nil, nil
+		}
+		allowed, err := q.cfg.authorizeVideo(video, userID, permView)
+		if err != nil || !allowed {
+			return nil, err
+		}
+	}
+	return &graphqlVideoResolver{cfg: q.cfg, video: video}, nil
+}
+
+func (q *graphqlQueryResolver) Videos(ctx context.Context, args struct {
+	Limit  *int32
+	Cursor *string
+}) (*graphqlVideoConnectionResolver, error) {
+	userID, ok := graphqlViewer(ctx)
+	if !ok {
+		return nil, errGraphQLUnauthenticated
+	}
+
+	params := pagination.Params{Limit: pagination.DefaultLimit}
+	if args.Limit != nil {
+		params.Limit = max(1, min(int(*args.Limit), pagination.MaxLimit))
+	}
+	if args.Cursor != nil && *args.Cursor != "" {
+		c, err := pagination.DecodeCursor(*args.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		params.Cursor = &c
+	}
+
+	videos, page, err := q.cfg.db.GetVideosPage(&userID, params)
+	if err != nil {
+		return nil, err
+	}
+	conn := &graphqlVideoConnectionResolver{}
+	for _, video := range videos {
+		conn.items = append(conn.items, &graphqlVideoResolver{cfg: q.cfg, video: video})
+	}
+	if page.NextCursor != "" {
+		conn.nextCursor = &page.NextCursor
+	}
+	if page.PrevCursor != "" {
+		conn.prevCursor = &page.PrevCursor
+	}
+	return conn, nil
+}
+
+type graphqlUserResolver struct {
+	cfg  *apiConfig
+	user database.User
+}
+
+func (u *graphqlUserResolver) ID() graphql.ID    { return graphql.ID(u.user.ID.String()) }
+func (u *graphqlUserResolver) Email() string     { return u.user.Email }
+func (u *graphqlUserResolver) CreatedAt() string { return u.user.CreatedAt.Format(time.RFC3339) }
+
+func (u *graphqlUserResolver) Plan() (*graphqlPlanResolver, error) {
+	plan, err := u.cfg.db.GetUserPlan(u.user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if plan == nil {
+		return nil, graphqlError("no plan configured")
+	}
+	return &graphqlPlanResolver{plan: *plan}, nil
+}
+
+func (u *graphqlUserResolver) Usage() (*graphqlUsageResolver, error) {
+	usage, err := u.cfg.db.GetUserUsage(u.user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &graphqlUsageResolver{usage: usage}, nil
+}
+
+type graphqlPlanResolver struct {
+	plan database.Plan
+}
+
+func (p *graphqlPlanResolver) ID() graphql.ID           { return graphql.ID(p.plan.ID) }
+func (p *graphqlPlanResolver) Name() string             { return p.plan.Name }
+func (p *graphqlPlanResolver) MaxFileSize() float64     { return float64(p.plan.MaxFileSize) }
+func (p *graphqlPlanResolver) MaxTotalStorage() float64 { return float64(p.plan.MaxTotalStorage) }
+func (p *graphqlPlanResolver) MaxRenditions() int32     { return int32(p.plan.MaxRenditions) }
+func (p *graphqlPlanResolver) Priority() int32          { return int32(p.plan.Priority) }
+func (p *graphqlPlanResolver) MaxUploadRate() float64   { return float64(p.plan.MaxUploadRate) }
+
+type graphqlUsageResolver struct {
+	usage database.UserUsage
+}
+
+func (u *graphqlUsageResolver) BytesUsed() float64   { return float64(u.usage.BytesUsed) }
+func (u *graphqlUsageResolver) ObjectCount() float64 { return float64(u.usage.ObjectCount) }
+func (u *graphqlUsageResolver) UpdatedAt() string    { return u.usage.UpdatedAt.Format(time.RFC3339) }
+
+type graphqlVideoResolver struct {
+	cfg   *apiConfig
+	video database.Video
+}
+
+func (v *graphqlVideoResolver) ID() graphql.ID        { return graphql.ID(v.video.ID.String()) }
+func (v *graphqlVideoResolver) Title() string         { return v.video.Title }
+func (v *graphqlVideoResolver) Description() string   { return v.video.Description }
+func (v *graphqlVideoResolver) ThumbnailURL() *string { return v.video.ThumbnailURL }
+func (v *graphqlVideoResolver) VideoURL() *string     { return v.video.VideoURL }
+func (v *graphqlVideoResolver) CreatedAt() string     { return v.video.CreatedAt.Format(time.RFC3339) }
+func (v *graphqlVideoResolver) UpdatedAt() string     { return v.video.UpdatedAt.Format(time.RFC3339) }
+
+func (v *graphqlVideoResolver) VideoSize(ctx context.Context) (float64, error) {
+	if err := v.requireOwner(ctx); err != nil {
+		return 0, err
+	}
+	return float64(v.video.VideoSize), nil
+}
+
+func (v *graphqlVideoResolver) ThumbnailSize(ctx context.Context) (float64, error) {
+	if err := v.requireOwner(ctx); err != nil {
+		return 0, err
+	}
+	return float64(v.video.ThumbnailSize), nil
+}
+
+func (v *graphqlVideoResolver) Renditions() ([]*graphqlRenditionResolver, error) {
+	renditions, err := v.cfg.db.GetVideoRenditions(v.video.ID)
+	if err != nil {
+		return nil, err
+	}
+	resolvers := make([]*graphqlRenditionResolver, 0, len(renditions))
+	for _, rendition := range renditions {
+		resolvers = append(resolvers, &graphqlRenditionResolver{rendition: rendition, url: v.cfg.store.URL(rendition.Key)})
+	}
+	return resolvers, nil
+}
+
+func (v *graphqlVideoResolver) Captions() ([]*graphqlCaptionResolver, error) {
+	captions, err := v.cfg.db.GetCaptions(v.video.ID)
+	if err != nil {
+		return nil, err
+	}
+	resolvers := make([]*graphqlCaptionResolver, 0, len(captions))
+	for _, caption := range captions {
+		resolvers = append(resolvers, &graphqlCaptionResolver{caption: caption})
+	}
+	return resolvers, nil
+}
+
+func (v *graphqlVideoResolver) Processing(ctx context.Context) (*graphqlProcessingResolver, error) {
+	if err := v.requireOwner(ctx); err != nil {
+		return nil, err
+	}
+	return &graphqlProcessingResolver{status: v.cfg.processingStatus(v.video)}, nil
+}
+
+func (v *graphqlVideoResolver) requireOwner(ctx context.Context) error {
+	userID, ok := graphqlViewer(ctx)
+	if !ok {
+		return errGraphQLUnauthenticated
+	}
+	if userID != v.video.UserID {
+		return errGraphQLForbidden
+	}
+	return nil
+}
+
+type graphqlVideoConnectionResolver struct {
+	items      []*graphqlVideoResolver
+	nextCursor *string
+	prevCursor *string
+}
+
+func (c *graphqlVideoConnectionResolver) Items() []*graphqlVideoResolver { return c.items }
+func (c *graphqlVideoConnectionResolver) NextCursor() *string            { return c.nextCursor }
+func (c *graphqlVideoConnectionResolver) PrevCursor() *string            { return c.prevCursor }
+
+type graphqlRenditionResolver struct {
+	rendition database.VideoRendition
+	url       string
+}
+
+func (r *graphqlRenditionResolver) Name() string  { return r.rendition.Name }
+func (r *graphqlRenditionResolver) URL() string   { return r.url }
+func (r *graphqlRenditionResolver) Width() int32  { return int32(r.rendition.Width) }
+func (r *graphqlRenditionResolver) Height() int32 { return int32(r.rendition.Height) }
+func (r *graphqlRenditionResolver) HDR() bool     { return r.rendition.HDR }
+func (r *graphqlRenditionResolver) Size() float64 { return float64(r.rendition.Size) }
+
+type graphqlCaptionResolver struct {
+	caption database.Caption
+}
+
+func (c *graphqlCaptionResolver) Language() string { return c.caption.Language }
+func (c *graphqlCaptionResolver) Source() string   { return c.caption.Source }
+func (c *graphqlCaptionResolver) URL() string      { return c.caption.URL }
+
+type graphqlProcessingResolver struct {
+	status processingStatus
+}
+
+func (p *graphqlProcessingResolver) State() string  { return p.status.State }
+func (p *graphqlProcessingResolver) Percent() int32 { return int32(p.status.Percent) }
+
+func (p *graphqlProcessingResolver) Stage() *string {
+	if p.status.Stage == "" {
+		return nil
+	}
+	return &p.status.Stage
+}
+
+func (p *graphqlProcessingResolver) StartedAt() *string {
+	return formatOptionalTime(p.status.StartedAt)
+}
+
+func (p *graphqlProcessingResolver) ProcessedAt() *string {
+	return formatOptionalTime(p.status.ProcessedAt)
+}
+
+func formatOptionalTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := t.Format(time.RFC3339)
+	return &s
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/errreport"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/throttle"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tubelypb"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type grpcUserKey struct{}
+
+type videoServiceServer struct {
+	tubelypb.UnimplementedVideoServiceServer
+	cfg *apiConfig
+}
+
+func (cfg *apiConfig) newGRPCServer() *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(cfg.grpcAuthUnary),
+		grpc.StreamInterceptor(cfg.grpcAuthStream),
+	)
+	tubelypb.RegisterVideoServiceServer(srv, &videoServiceServer{cfg: cfg})
+	return srv
+}
+
+// grpcAuthenticate validates the bearer token in the call metadata, the
+// gRPC counterpart of auth.GetBearerToken + auth.ValidateJWT, and turns
+// away suspended accounts.
+func (cfg *apiConfig) grpcAuthenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "couldn't find JWT")
+	}
+	token, ok := strings.CutPrefix(values[0], "Bearer ")
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "malformed authorization metadata")
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "couldn't validate JWT")
+	}
+	// The same check rejectSuspendedUsers makes for HTTP.
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return nil, grpcInternalError("couldn't get user", err)
+	}
+	if user != nil && user.SuspendedAt != nil {
+		return nil, status.Error(codes.PermissionDenied, "account is suspended")
+	}
+	return context.WithValue(ctx, grpcUserKey{}, userID), nil
+}
+
+func (cfg *apiConfig) grpcAuthUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := cfg.grpcAuthenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (cfg *apiConfig) grpcAuthStream(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := cfg.grpcAuthenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+func grpcUserID(ctx context.Context) uuid.UUID {
+	userID, _ := ctx.Value(grpcUserKey{}).(uuid.UUID)
+	return userID
+}
+
+// authorizedVideo loads a video and checks the caller has the permission
+// on it.
+func (s *videoServiceServer) authorizedVideo(ctx context.Context, id string, p permission) (database.Video, error) {
+	videoID, err := uuid.Parse(id)
+	if err != nil {
+		return database.Video{}, status.Error(codes.InvalidArgument, "invalid video ID")
+	}
+	video, err := s.cfg.db.GetVideo(videoID)
+	if err != nil {
+		return database.Video{}, grpcInternalError("couldn't get video", err)
+	}
+	if video.ID == uuid.Nil {
+		return database.Video{}, status.Error(codes.NotFound, "video not found")
+	}
+	allowed, err := s.cfg.authorizeVideo(video, grpcUserID(ctx), p)
+	if err != nil {
+		return database.Video{}, grpcInternalError("couldn't check access", err)
+	}
+	if !allowed {
+		return database.Video{}, status.Errorf(codes.PermissionDenied, "can't %s this video", p)
+	}
+	return video, nil
+}
+
+func (s *videoServiceServer) UploadVideo(stream grpc.ClientStreamingServer[tubelypb.UploadVideoRequest, tubelypb.Video]) error {
+	ctx := stream.Context()
+
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	meta := first.GetMetadata()
+	if meta == nil {
+		return status.Error(codes.InvalidArgument, "first message must carry upload metadata")
+	}
+
+	video, err := s.authorizedVideo(ctx, meta.VideoId, permEdit)
+	if err != nil {
+		return err
+	}
+
+	mediaType, _, err := mime.ParseMediaType(meta.ContentType)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "couldn't parse media type")
+	}
+	rule, ok := s.cfg.mediaTypes.lookup(mediaKindVideo, mediaType)
+	if !ok {
+		return status.Errorf(codes.InvalidArgument, "only accepts %s", s.cfg.mediaTypes.allowed(mediaKindVideo))
+	}
+
+	plan, err := s.cfg.videoPlan(video)
+	if err != nil {
+		return grpcInternalError("couldn't get plan", err)
+	}
+	maxSize := plan.MaxFileSize
+	if rule.MaxSize > 0 {
+		maxSize = min(maxSize, rule.MaxSize)
+	}
+
+	upload := uploadEvent{VideoID: video.ID, UserID: video.UserID, Method: uploadMethodGRPC}
+	s.cfg.events.Publish(eventUploadStarted, upload)
+	job := s.cfg.newProcessingJob(video)
+	job.uploaderID = grpcUserID(ctx)
+	// Chunks are read at the plan's upload rate, like the HTTP uploads.
+	var chunks io.Reader = &uploadChunks{stream: stream, max: maxSize}
+	if buckets := s.cfg.uploadBuckets(video, plan); len(buckets) > 0 {
+		chunks = throttle.NewReader(ctx, chunks, buckets...)
+	}
+	var tempPath string
+	if s.cfg.scratchMode == scratchModeStaging {
+		body := bufio.NewReaderSize(chunks, 512)
+		head, err := body.Peek(512)
+		if err != nil && err != io.EOF {
+			return grpcChunkError(err)
+		}
+		if matches, _ := rule.sniff(bytes.NewReader(head)); !matches {
+			return status.Errorf(codes.InvalidArgument, "file content isn't valid %s", mediaType)
+		}
+		doneCopy := job.stage(stageCopy)
+		key, size, err := s.cfg.stageUpload(ctx, video.ID, body, mediaType)
+		doneCopy()
+		if err != nil {
+			return grpcChunkError(err)
+		}
+		job.size = size
+		if err := s.cfg.checkVideoQuota(plan, video, video.VideoSize, size); err != nil {
+			s.cfg.discardStagedUpload(ctx, key)
+			return grpcQuotaError(err)
+		}
+		job.sourceKey = key
+		job.staged = true
+	} else {
+		tempFile, err := os.CreateTemp("", tempUploadPattern)
+		if err != nil {
+			return grpcInternalError("couldn't create temporary file", err)
+		}
+		defer os.Remove(tempFile.Name())
+		defer tempFile.Close()
+
+		doneCopy := job.stage(stageCopy)
+		job.size, err = io.Copy(tempFile, chunks)
+		doneCopy()
+		if err != nil {
+			return grpcChunkError(err)
+		}
+		if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+			return grpcInternalError("couldn't reset file pointer", err)
+		}
+
+		matches, err := rule.sniff(tempFile)
+		if err != nil {
+			return grpcInternalError("couldn't read upload", err)
+		}
+		if !matches {
+			return status.Errorf(codes.InvalidArgument, "file content isn't valid %s", mediaType)
+		}
+
+		if err := s.cfg.checkVideoQuota(plan, video, video.VideoSize, job.size); err != nil {
+			return grpcQuotaError(err)
+		}
+		tempPath = tempFile.Name()
+	}
+
+	video, err = s.cfg.storeVideo(ctx, job, video, tempPath)
+	job.finish(err)
+	var corrupt *media.CorruptError
+	if errors.As(err, &corrupt) {
+		return status.Error(codes.InvalidArgument, corrupt.Reason)
+	}
+	var limit *mediaLimitError
+	if errors.As(err, &limit) {
+		return status.Error(codes.FailedPrecondition, limit.Error())
+	}
+	if err != nil {
+		return grpcInternalError("couldn't store video", err)
+	}
+	upload.Size = video.VideoSize
+	s.cfg.events.Publish(eventUploadFinished, upload)
+
+	return stream.SendAndClose(videoToProto(video))
+}
+
+// uploadChunks reads the chunks of an upload stream as one body, failing
+// once more than max bytes have come in.
+type uploadChunks struct {
+	stream   grpc.ClientStreamingServer[tubelypb.UploadVideoRequest, tubelypb.Video]
+	max      int64
+	received int64
+	chunk    []byte
+}
+
+func (c *uploadChunks) Read(p []byte) (int, error) {
+	for len(c.chunk) == 0 {
+		req, err := c.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		c.chunk = req.GetChunk()
+		c.received += int64(len(c.chunk))
+		if c.received > c.max {
+			return 0, status.Errorf(codes.ResourceExhausted, "video exceeds the %d byte limit", c.max)
+		}
+	}
+	n := copy(p, c.chunk)
+	c.chunk = c.chunk[n:]
+	return n, nil
+}
+
+// grpcChunkError passes on errors from the stream, which already carry a
+// status, and reports the rest as internal.
+func grpcChunkError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return grpcInternalError("couldn't store upload", err)
+}
+
+func grpcQuotaError(err error) error {
+	if errors.Is(err, errStorageQuotaExceeded) {
+		return status.Error(codes.ResourceExhausted, "storage quota exceeded")
+	}
+	return grpcInternalError("couldn't check storage quota", err)
+}
+
+func (s *videoServiceServer) GetVideo(ctx context.Context, req *tubelypb.GetVideoRequest) (*tubelypb.Video, error) {
+	videoID, err := uuid.Parse(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid video ID")
+	}
+	video, err := s.cfg.db.GetVideo(videoID)
+	if err != nil {
+		return nil, grpcInternalError("couldn't get video", err)
+	}
+	if video.ID == uuid.Nil {
+		return nil, status.Error(codes.NotFound, "video not found")
+	}
+	if video.Visibility == database.VisibilityPrivate {
+		allowed, err := s.cfg.authorizeVideo(video, grpcUserID(ctx), permView)
+		if err != nil {
+			return nil, grpcInternalError("couldn't check access", err)
+		}
+		if !allowed {
+			return nil, status.Error(codes.PermissionDenied, "video is private")
+		}
+	}
+	return videoToProto(video), nil
+}
+
+func (s *videoServiceServer) ListVideos(ctx context.Context, req *tubelypb.ListVideosRequest) (*tubelypb.ListVideosResponse, error) {
+	params := pagination.Params{Limit: pagination.DefaultLimit}
+	if req.Limit > 0 {
+		params.Limit = min(int(req.Limit), pagination.MaxLimit)
+	}
+	if req.Cursor != "" {
+		c, err := pagination.DecodeCursor(req.Cursor)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid cursor")
+		}
+		params.Cursor = &c
+	}
+
+	userID := grpcUserID(ctx)
+	videos, page, err := s.cfg.db.GetVideosPage(&userID, params)
+	if err != nil {
+		return nil, grpcInternalError("couldn't retrieve videos", err)
+	}
+
+	resp := &tubelypb.ListVideosResponse{
+		NextCursor: page.NextCursor,
+		PrevCursor: page.PrevCursor,
+	}
+	for _, video := range videos {
+		resp.Videos = append(resp.Videos, videoToProto(video))
+	}
+	return resp, nil
+}
+
+func (s *videoServiceServer) DeleteVideo(ctx context.Context, req *tubelypb.DeleteVideoRequest) (*tubelypb.DeleteVideoResponse, error) {
+	video, err := s.authorizedVideo(ctx, req.Id, permManage)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.cfg.deleteVideo(ctx, video); errors.Is(err, errVideoLocked) {
+		return nil, status.Error(codes.FailedPrecondition, errVideoLocked.Error())
+	} else if err != nil {
+		return nil, grpcInternalError("couldn't delete video", err)
+	}
+	return &tubelypb.DeleteVideoResponse{}, nil
+}
+
+func videoToProto(video database.Video) *tubelypb.Video {
+	pb := &tubelypb.Video{
+		Id:          video.ID.String(),
+		UserId:      video.UserID.String(),
+		Title:       video.Title,
+		Description: video.Description,
+		VideoSize:   video.VideoSize,
+		CreatedAt:   timestamppb.New(video.CreatedAt),
+		UpdatedAt:   timestamppb.New(video.UpdatedAt),
+	}
+	if video.ThumbnailURL != nil {
+		pb.ThumbnailUrl = *video.ThumbnailURL
+	}
+	if video.VideoURL != nil {
+		pb.VideoUrl = *video.VideoURL
+	}
+	return pb
+}
+
+// grpcInternalError logs and reports err and returns an Internal status
+// that only says what failed, the gRPC counterpart of respondWithError for
+// 5xx responses. The error itself is only included with DEBUG_ERRORS on.
+func grpcInternalError(msg string, err error) error {
+	log.Printf("gRPC: %s: %v", msg, err)
+	reportError(errreport.Event{Err: err, Message: msg, Tags: map[string]string{"transport": "grpc"}})
+	if exposeErrorDetails {
+		return status.Errorf(codes.Internal, "%s: %v", msg, err)
+	}
+	return status.Error(codes.Internal, msg)
+}
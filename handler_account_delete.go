@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const jobAccountDeletion = "account_deletion"
+
+type accountDeletionReport struct {
+	VideosDeleted         int `json:"videos_deleted"`
+	UploadSessionsAborted int `json:"upload_sessions_aborted"`
+	// VideosRetained lists videos kept because of retention or a legal
+	// hold. Their rows and objects stay until the lock ends.
+	VideosRetained []uuid.UUID `json:"videos_retained"`
+	AccountDeleted bool        `json:"account_deleted"`
+}
+
+// handlerUserDelete erases the caller's account and everything in it. It
+// answers straight away with the job doing the work.
+func (cfg *apiConfig) handlerUserDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+	cfg.startAccountDeletion(w, userID)
+}
+
+func (cfg *apiConfig) handlerAdminUserDelete(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid user ID", err)
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	cfg.startAccountDeletion(w, userID)
+}
+
+func (cfg *apiConfig) startAccountDeletion(w http.ResponseWriter, userID uuid.UUID) {
+	active, err := cfg.db.GetActiveJob(userID, jobAccountDeletion)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check jobs", err)
+		return
+	}
+	if active.ID != uuid.Nil {
+		respondWithJSON(w, http.StatusAccepted, active)
+		return
+	}
+
+	job, err := cfg.db.CreateJob(jobAccountDeletion, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create job", err)
+		return
+	}
+	cfg.startJob(job, func(ctx context.Context) (any, error) {
+		return cfg.deleteAccount(ctx, userID)
+	})
+
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// deleteAccount removes the user's videos with their objects and
+// thumbnails, aborts unfinished uploads, then deletes the user's exports,
+// rows and profile images.
+// The account itself is kept while any video is locked.
+func (cfg *apiConfig) deleteAccount(ctx context.Context, userID uuid.UUID) (accountDeletionReport, error) {
+	report := accountDeletionReport{VideosRetained: []uuid.UUID{}}
+
+	sessions, err := cfg.db.GetUserUploadSessions(userID)
+	if err != nil {
+		return report, fmt.Errorf("couldn't list upload sessions: %w", err)
+	}
+	for _, session := range sessions {
+		if err := cfg.store.AbortMultipart(ctx, session.ObjectKey, session.UploadID); err != nil {
+			log.Printf("account_deletion: couldn't abort upload %s: %v", session.ID, err)
+		}
+		if err := cfg.db.DeleteUploadSession(session.ID); err != nil {
+			return report, fmt.Errorf("couldn't delete upload session %s: %w", session.ID, err)
+		}
+		report.UploadSessionsAborted++
+	}
+
+	videos, err := cfg.db.GetVideos(userID)
+	if err != nil {
+		return report, fmt.Errorf("couldn't list videos: %w", err)
+	}
+	for _, video := range videos {
+		// Organization videos belong to the organization and stay in its
+		// library.
+		if video.OrgID != nil {
+			continue
+		}
+		err := cfg.deleteVideo(ctx, video)
+		if errors.Is(err, errVideoLocked) {
+			report.VideosRetained = append(report.VideosRetained, video.ID)
+			continue
+		}
+		if err != nil {
+			return report, fmt.Errorf("couldn't delete video %s: %w", video.ID, err)
+		}
+		report.VideosDeleted++
+	}
+
+	if len(report.VideosRetained) > 0 {
+		return report, nil
+	}
+	// Export archives hold copies of everything above.
+	exports, err := cfg.db.GetUserJobs(userID, jobExport)
+	if err != nil {
+		return report, fmt.Errorf("couldn't list exports: %w", err)
+	}
+	for _, job := range exports {
+		key := exportKey(userID, job.ID)
+		if err := cfg.store.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return report, fmt.Errorf("couldn't delete export %s: %w", job.ID, err)
+		}
+		if err := cfg.db.MarkJobPurged(job.ID); err != nil {
+			return report, fmt.Errorf("couldn't record deleted export %s: %w", job.ID, err)
+		}
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return report, fmt.Errorf("couldn't get user: %w", err)
+	}
+	if err := cfg.db.DeleteUserData(userID); err != nil {
+		return report, fmt.Errorf("couldn't delete account: %w", err)
+	}
+	if user != nil {
+		cfg.removeUserImage(user.AvatarURL)
+		cfg.removeUserImage(user.BannerURL)
+	}
+	report.AccountDeleted = true
+	return report, nil
+}
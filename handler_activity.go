@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
+)
+
+// maxThroughputHours is how far back the throughput endpoint reaches.
+const maxThroughputHours = 30 * 24
+
+// handlerAdminRecentUploads lists the latest uploads to finish
+// processing, newest first, whether they succeeded or not. Reprocessing
+// runs are left out.
+func (cfg *apiConfig) handlerAdminRecentUploads(w http.ResponseWriter, r *http.Request) {
+	cfg.respondWithProcessingRuns(w, r, true, false)
+}
+
+// handlerAdminRecentFailures lists the latest processing runs that
+// failed, newest first, with the stage they stopped in and the end of
+// ffmpeg's output when ffmpeg was what failed.
+func (cfg *apiConfig) handlerAdminRecentFailures(w http.ResponseWriter, r *http.Request) {
+	cfg.respondWithProcessingRuns(w, r, false, true)
+}
+
+func (cfg *apiConfig) respondWithProcessingRuns(w http.ResponseWriter, r *http.Request, uploadsOnly, failedOnly bool) {
+	params, err := pagination.ParseRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid pagination parameters", err)
+		return
+	}
+
+	runs, page, err := cfg.db.GetProcessingRunsPage(uploadsOnly, failedOnly, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve processing runs", err)
+		return
+	}
+
+	pagination.WriteHeaders(w, page)
+	respondWithJSON(w, http.StatusOK, runs)
+}
+
+// handlerAdminThroughput reports how many videos finished processing in
+// each of the last hours, 24 unless the hours parameter asks for more.
+func (cfg *apiConfig) handlerAdminThroughput(w http.ResponseWriter, r *http.Request) {
+	hours := 24
+	if raw := r.URL.Query().Get("hours"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxThroughputHours {
+			respondWithErrorCode(w, http.StatusBadRequest, errCodeValidation, "hours must be between 1 and 720", err)
+			return
+		}
+		hours = n
+	}
+
+	since := time.Now().Add(-time.Duration(hours-1) * time.Hour)
+	throughput, err := cfg.db.GetProcessingHours(since)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get processing throughput", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, throughput)
+}
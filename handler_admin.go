@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/scheduler"
+)
+
+func (cfg *apiConfig) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.adminAPIKey == "" {
+			respondWithError(w, http.StatusForbidden, "Admin API is disabled", nil)
+			return
+		}
+
+		apiKey, err := auth.GetAPIKey(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find API key", err)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.adminAPIKey)) != 1 {
+			respondWithError(w, http.StatusUnauthorized, "Invalid API key", nil)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func (cfg *apiConfig) handlerAdminTasksList(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, cfg.scheduler.Statuses())
+}
+
+func (cfg *apiConfig) handlerAdminTaskRun(w http.ResponseWriter, r *http.Request) {
+	err := cfg.scheduler.RunNow(r.PathValue("taskName"))
+	if errors.Is(err, scheduler.ErrNotLeader) {
+		respondWithError(w, http.StatusConflict, "Task runs on the leader instance", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Task not found", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handlerAdminVideosList(w http.ResponseWriter, r *http.Request) {
+	params, err := pagination.ParseRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid pagination parameters", err)
+		return
+	}
+
+	videos, page, err := cfg.db.GetVideosPage(nil, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	pagination.WriteHeaders(w, page)
+	respondWithJSON(w, http.StatusOK, videos)
+}
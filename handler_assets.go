@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerAssets serves files from the assets directory. http.ServeContent
+// takes care of Range requests and of answering If-None-Match and
+// If-Modified-Since with 304 once ETag and Last-Modified are known.
+// Thumbnails of private videos are only served to callers who can view
+// the video, and with ASSETS_REQUIRE_AUTH nothing is served to callers who
+// aren't signed in.
+func (cfg *apiConfig) handlerAssets(w http.ResponseWriter, r *http.Request) {
+	filename := r.PathValue("filename")
+	if filename == "" || strings.ContainsAny(filename, `/\`) || strings.HasPrefix(filename, ".") {
+		respondWithError(w, http.StatusNotFound, "Asset not found", nil)
+		return
+	}
+
+	// <img> tags can't set headers, so the token may come as a query param.
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token, _ = auth.GetBearerToken(r.Header)
+	}
+	userID, authErr := uuid.Nil, errors.New("no JWT in the request")
+	if token != "" {
+		userID, authErr = cfg.validateJWT(token)
+	}
+	if cfg.assetsRequireAuth && authErr != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", authErr)
+		return
+	}
+	private, ok := cfg.checkAssetAccess(w, filename, userID, authErr)
+	if !ok {
+		return
+	}
+
+	file, err := os.Open(cfg.getAssetDiskPath(filename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			respondWithError(w, http.StatusNotFound, "Asset not found", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open asset", err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't stat asset", err)
+		return
+	}
+	if info.IsDir() {
+		respondWithError(w, http.StatusNotFound, "Asset not found", nil)
+		return
+	}
+
+	cacheControl := cfg.assetsCacheControl
+	if cfg.assetsRequireAuth || private {
+		cacheControl = "private, " + cacheControl
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+
+	http.ServeContent(w, r, filename, info.ModTime(), file)
+}
+
+// checkAssetAccess holds the thumbnails of private videos back from
+// callers who can't view the video, responding if the caller can't. An
+// asset several videos share is served when any of them can be viewed.
+// private reports whether the asset is only served to some callers, so
+// shared caches don't keep it.
+func (cfg *apiConfig) checkAssetAccess(w http.ResponseWriter, filename string, userID uuid.UUID, authErr error) (private bool, ok bool) {
+	ids, err := cfg.db.GetAssetVideoIDs(filename)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up asset", err)
+		return false, false
+	}
+	for _, id := range ids {
+		video, err := cfg.db.GetVideo(id)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return false, false
+		}
+		if video.ID == uuid.Nil {
+			continue
+		}
+		if video.Visibility != database.VisibilityPrivate {
+			return false, true
+		}
+		private = true
+		if authErr != nil {
+			continue
+		}
+		allowed, err := cfg.authorizeVideo(video, userID, permView)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check access", err)
+			return false, false
+		}
+		if allowed {
+			return true, true
+		}
+	}
+	if !private {
+		return false, true
+	}
+	if authErr != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", authErr)
+		return true, false
+	}
+	respondWithErrorCode(w, http.StatusForbidden, errCodeNotOwner, "This video is private", nil)
+	return true, false
+}
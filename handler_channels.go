@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
+	"github.com/google/uuid"
+)
+
+// channelMaxAge is how long clients and shared caches may reuse a channel
+// response before asking again.
+const channelMaxAge = 60 * time.Second
+
+// channelProfile is the part of a user that's shown to anyone. The email
+// stays private.
+type channelProfile struct {
+	ID        uuid.UUID `json:"id"`
+	JoinedAt  time.Time `json:"joined_at"`
+	AvatarURL *string   `json:"avatar_url"`
+	BannerURL *string   `json:"banner_url"`
+}
+
+// channelVideo is a public video as the channel lists it, without the
+// owner-only fields of database.Video.
+type channelVideo struct {
+	ID           uuid.UUID `json:"id"`
+	Title        string    `json:"title"`
+	Description  string    `json:"description"`
+	ThumbnailURL *string   `json:"thumbnail_url"`
+	// VideoURL is nil until the video is uploaded, and while it's
+	// archived.
+	VideoURL  *string   `json:"video_url"`
+	VideoSize int64     `json:"video_size"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type channelResponse struct {
+	Profile channelProfile        `json:"profile"`
+	Stats   database.ChannelStats `json:"stats"`
+	Videos  []channelVideo        `json:"videos"`
+}
+
+// handlerChannelGet is a user's public channel: their profile, public
+// videos newest first and totals. It needs no token, so responses are
+// cacheable and carry an ETag of their content.
+func (cfg *apiConfig) handlerChannelGet(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid user ID", err)
+		return
+	}
+	params, err := pagination.ParseRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid pagination parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	// Suspended accounts have no channel.
+	if user == nil || user.SuspendedAt != nil {
+		respondWithError(w, http.StatusNotFound, "Channel not found", nil)
+		return
+	}
+
+	stats, err := cfg.db.GetChannelStats(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get channel stats", err)
+		return
+	}
+	videos, page, err := cfg.db.GetPublicVideosPage(userID, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	resp := channelResponse{
+		Profile: channelProfile{
+			ID:        user.ID,
+			JoinedAt:  user.CreatedAt,
+			AvatarURL: user.AvatarURL,
+			BannerURL: user.BannerURL,
+		},
+		Stats:  stats,
+		Videos: make([]channelVideo, 0, len(videos)),
+	}
+	now := time.Now()
+	for _, video := range videos {
+		cv := channelVideo{
+			ID:           video.ID,
+			Title:        video.Title,
+			Description:  video.Description,
+			ThumbnailURL: video.ThumbnailURL,
+			VideoSize:    video.VideoSize,
+			CreatedAt:    video.CreatedAt,
+			UpdatedAt:    video.UpdatedAt,
+		}
+		if video.VideoKey != nil && video.Playable(now) {
+			url := cfg.store.URL(*video.VideoKey)
+			cv.VideoURL = &url
+		}
+		resp.Videos = append(resp.Videos, cv)
+	}
+
+	dat, err := json.Marshal(resp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't encode channel", err)
+		return
+	}
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(dat))
+
+	pagination.WriteHeaders(w, page)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(channelMaxAge.Seconds())))
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dat)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, using
+// the weak comparison the header calls for.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
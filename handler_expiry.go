@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const webhookVideoExpired = "video.expired"
+
+// handlerVideoExpirySet schedules the video, its objects and thumbnail for
+// deletion at expires_at.
+func (cfg *apiConfig) handlerVideoExpirySet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	video, ok := cfg.authorizedVideo(w, r, permManage)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+	if !params.ExpiresAt.After(time.Now()) {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid expiry",
+			Details: []errorDetail{{Field: "expires_at", Message: "must be in the future"}},
+		})
+		return
+	}
+
+	expiresAt := params.ExpiresAt.UTC()
+	video.ExpiresAt = &expiresAt
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func (cfg *apiConfig) handlerVideoExpiryClear(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizedVideo(w, r, permManage)
+	if !ok {
+		return
+	}
+	if video.ExpiresAt == nil {
+		respondWithError(w, http.StatusNotFound, "Video doesn't expire", nil)
+		return
+	}
+
+	video.ExpiresAt = nil
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// purgeExpiredVideos deletes expired videos and tells their owners through
+// the webhook. A failed notification doesn't bring the video back.
+func (cfg *apiConfig) purgeExpiredVideos(ctx context.Context) error {
+	type expiredVideo struct {
+		VideoID   uuid.UUID `json:"video_id"`
+		UserID    uuid.UUID `json:"user_id"`
+		Title     string    `json:"title"`
+		ExpiredAt time.Time `json:"expired_at"`
+	}
+
+	videos, err := cfg.db.GetExpiredVideos(time.Now(), envInt("EXPIRED_PURGE_BATCH", 100))
+	if err != nil {
+		return err
+	}
+
+	for _, video := range videos {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := cfg.deleteVideo(ctx, video); err != nil {
+			log.Printf("expired_purge: couldn't delete video %s: %v", video.ID, err)
+			continue
+		}
+		log.Printf("expired_purge: deleted video %s", video.ID)
+
+		err := cfg.webhooks.Send(ctx, webhookVideoExpired, expiredVideo{
+			VideoID:   video.ID,
+			UserID:    video.UserID,
+			Title:     video.Title,
+			ExpiredAt: *video.ExpiresAt,
+		})
+		if err != nil {
+			log.Printf("expired_purge: couldn't notify owner of video %s: %v", video.ID, err)
+		}
+	}
+	return nil
+}
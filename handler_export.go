@@ -0,0 +1,264 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const jobExport = "export"
+
+type exportReport struct {
+	DownloadURL string `json:"download_url"`
+	// ExpiresAt is when DownloadURL stops working, if it does.
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	Size       int64      `json:"size"`
+	Videos     int        `json:"videos"`
+	Thumbnails int        `json:"thumbnails"`
+	Captions   int        `json:"captions"`
+	// ArchivedVideos counts videos left out because they're archived.
+	ArchivedVideos int `json:"archived_videos"`
+}
+
+// exportMetadata is written to metadata.json at the root of the archive.
+type exportMetadata struct {
+	ExportedAt   time.Time                `json:"exported_at"`
+	User         *database.User           `json:"user"`
+	Plan         *database.Plan           `json:"plan"`
+	Settings     database.UserSettings    `json:"settings"`
+	Usage        database.UserUsage       `json:"usage"`
+	Videos       []database.Video         `json:"videos"`
+	WatchHistory []database.WatchPosition `json:"watch_history"`
+}
+
+// handlerUserExport starts a takeout of everything the caller has stored.
+// The finished job's report holds the download link.
+func (cfg *apiConfig) handlerUserExport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	active, err := cfg.db.GetActiveJob(userID, jobExport)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check jobs", err)
+		return
+	}
+	if active.ID != uuid.Nil {
+		respondWithJSON(w, http.StatusAccepted, active)
+		return
+	}
+
+	job, err := cfg.db.CreateJob(jobExport, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create job", err)
+		return
+	}
+	cfg.startJob(job, func(ctx context.Context) (any, error) {
+		return cfg.exportUserData(ctx, userID, job.ID)
+	})
+
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// exportUserData zips the user's originals, caption tracks, thumbnails and
+// a metadata document in a temp file, then stages the archive in the
+// store, where purgeExports deletes it once its link has expired.
+func (cfg *apiConfig) exportUserData(ctx context.Context, userID, jobID uuid.UUID) (exportReport, error) {
+	var report exportReport
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return report, fmt.Errorf("couldn't get user: %w", err)
+	}
+	if user == nil {
+		return report, errors.New("user no longer exists")
+	}
+	user.Password = ""
+	metadata := exportMetadata{ExportedAt: time.Now().UTC(), User: user}
+	if metadata.Plan, err = cfg.db.GetUserPlan(userID); err != nil {
+		return report, fmt.Errorf("couldn't get plan: %w", err)
+	}
+	if metadata.Settings, err = cfg.db.GetUserSettings(userID); err != nil {
+		return report, fmt.Errorf("couldn't get settings: %w", err)
+	}
+	if metadata.Usage, err = cfg.db.GetUserUsage(userID); err != nil {
+		return report, fmt.Errorf("couldn't get usage: %w", err)
+	}
+	if metadata.Videos, err = cfg.db.GetVideos(userID); err != nil {
+		return report, fmt.Errorf("couldn't list videos: %w", err)
+	}
+	if metadata.WatchHistory, err = cfg.db.GetWatchHistory(userID); err != nil {
+		return report, fmt.Errorf("couldn't get watch history: %w", err)
+	}
+
+	tempFile, err := os.CreateTemp("", tempUploadPattern+"-export-*.zip")
+	if err != nil {
+		return report, err
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	archive := zip.NewWriter(tempFile)
+	for _, video := range metadata.Videos {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+		dir := path.Join("videos", video.ID.String())
+		if video.VideoKey != nil && !video.Playable(time.Now()) {
+			report.ArchivedVideos++
+		} else if video.VideoKey != nil {
+			if err := cfg.exportObject(ctx, archive, dir+"/video"+path.Ext(*video.VideoKey), *video.VideoKey); err != nil {
+				return report, fmt.Errorf("couldn't export video %s: %w", video.ID, err)
+			}
+			report.Videos++
+		}
+		captions, err := cfg.db.GetCaptions(video.ID)
+		if err != nil {
+			return report, fmt.Errorf("couldn't list captions of video %s: %w", video.ID, err)
+		}
+		for _, caption := range captions {
+			if err := cfg.exportObject(ctx, archive, dir+"/captions/"+caption.Language+".vtt", caption.Key); err != nil {
+				return report, fmt.Errorf("couldn't export %s captions of video %s: %w", caption.Language, video.ID, err)
+			}
+			report.Captions++
+		}
+		if video.ThumbnailURL != nil {
+			filename := path.Base(*video.ThumbnailURL)
+			err := exportFile(archive, dir+"/thumbnail"+path.Ext(filename), cfg.getAssetDiskPath(filename))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return report, fmt.Errorf("couldn't export thumbnail of video %s: %w", video.ID, err)
+			}
+			if err == nil {
+				report.Thumbnails++
+			}
+		}
+	}
+
+	w, err := archive.Create("metadata.json")
+	if err != nil {
+		return report, err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(metadata); err != nil {
+		return report, err
+	}
+	if err := archive.Close(); err != nil {
+		return report, err
+	}
+
+	if report.Size, err = tempFile.Seek(0, io.SeekCurrent); err != nil {
+		return report, err
+	}
+	if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+		return report, err
+	}
+	key := exportKey(userID, jobID)
+	if _, err := cfg.store.Put(ctx, key, tempFile, storage.PutOptions{ContentType: "application/zip"}); err != nil {
+		return report, fmt.Errorf("couldn't upload archive: %w", err)
+	}
+
+	ttl := exportTTL()
+	report.DownloadURL, err = cfg.store.PresignGet(ctx, key, storage.PresignGetOptions{
+		Expires:  ttl,
+		Filename: "tubely-export.zip",
+	})
+	switch {
+	case errors.Is(err, storage.ErrPresignNotSupported):
+		// The key has the job ID in it, so the plain URL can't be guessed.
+		report.DownloadURL = cfg.store.URL(key)
+	case err != nil:
+		return report, fmt.Errorf("couldn't presign download: %w", err)
+	default:
+		expiresAt := time.Now().UTC().Add(ttl)
+		report.ExpiresAt = &expiresAt
+	}
+	return report, nil
+}
+
+// exportTTL is how long an export's download link works, and so how long
+// its archive is kept.
+func exportTTL() time.Duration {
+	return envDuration("EXPORT_URL_TTL", 24*time.Hour)
+}
+
+// purgeExports deletes the archives of exports whose link has expired.
+func (cfg *apiConfig) purgeExports(ctx context.Context) error {
+	jobs, err := cfg.db.GetUnpurgedJobs(jobExport)
+	if err != nil {
+		return fmt.Errorf("couldn't list exports: %w", err)
+	}
+	cutoff := time.Now().Add(-exportTTL())
+	purged := 0
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if job.FinishedAt == nil || job.FinishedAt.After(cutoff) {
+			continue
+		}
+		key := exportKey(job.UserID, job.ID)
+		if err := cfg.store.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("export_purge: couldn't delete %s: %v", key, err)
+			continue
+		}
+		if err := cfg.db.MarkJobPurged(job.ID); err != nil {
+			return fmt.Errorf("couldn't record deleted export %s: %w", job.ID, err)
+		}
+		purged++
+	}
+	if purged > 0 {
+		log.Printf("export_purge: deleted %d expired exports", purged)
+	}
+	return nil
+}
+
+// exportKey is where the archive of an export job is staged.
+func exportKey(userID, jobID uuid.UUID) string {
+	return fmt.Sprintf("exports/%s/%s.zip", userID, jobID)
+}
+
+// exportObject copies a stored object into the archive uncompressed, since
+// video doesn't compress any further.
+func (cfg *apiConfig) exportObject(ctx context.Context, archive *zip.Writer, name, key string) error {
+	body, err := cfg.store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, body)
+	return err
+}
+
+func exportFile(archive *zip.Writer, name, diskPath string) error {
+	f, err := os.Open(diskPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
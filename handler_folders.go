@@ -0,0 +1,545 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
+	"github.com/google/uuid"
+)
+
+const (
+	maxFolderNameLength = 100
+	// maxBulkVideos caps how many videos one bulk request touches, since
+	// the work happens while the request waits.
+	maxBulkVideos = 500
+)
+
+const (
+	bulkActionMove          = "move"
+	bulkActionSetVisibility = "set_visibility"
+	bulkActionDelete        = "delete"
+)
+
+type bulkVideoResult struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Status  int       `json:"status"`
+	Code    string    `json:"code,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// ownedFolder loads the folder named in the path, responding with an error
+// unless it belongs to the authenticated user.
+func (cfg *apiConfig) ownedFolder(w http.ResponseWriter, r *http.Request) (database.Folder, bool) {
+	folderID, err := uuid.Parse(r.PathValue("folderID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid ID", err)
+		return database.Folder{}, false
+	}
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return database.Folder{}, false
+	}
+	folder, err := cfg.db.GetFolder(folderID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get folder", err)
+		return database.Folder{}, false
+	}
+	// Someone else's folder is reported as missing, so IDs can't be probed.
+	if folder.ID == uuid.Nil || folder.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Folder not found", nil)
+		return database.Folder{}, false
+	}
+	return folder, true
+}
+
+// viewableFolder is ownedFolder for endpoints that only read the folder,
+// which users it was shared with can use too.
+func (cfg *apiConfig) viewableFolder(w http.ResponseWriter, r *http.Request) (database.Folder, bool) {
+	folderID, err := uuid.Parse(r.PathValue("folderID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid ID", err)
+		return database.Folder{}, false
+	}
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return database.Folder{}, false
+	}
+	folder, err := cfg.db.GetFolder(folderID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get folder", err)
+		return database.Folder{}, false
+	}
+	role := ""
+	if folder.ID != uuid.Nil {
+		role, err = cfg.folderRole(folder, userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check access", err)
+			return database.Folder{}, false
+		}
+	}
+	if !roleAllows(role, permView) {
+		respondWithError(w, http.StatusNotFound, "Folder not found", nil)
+		return database.Folder{}, false
+	}
+	return folder, true
+}
+
+// checkFolder returns a validation message if folderID isn't one of the
+// user's folders. A nil folderID, meaning the top of the library, is fine.
+func (cfg *apiConfig) checkFolder(userID uuid.UUID, folderID *uuid.UUID) (string, error) {
+	if folderID == nil {
+		return "", nil
+	}
+	folder, err := cfg.db.GetFolder(*folderID)
+	if err != nil {
+		return "", err
+	}
+	if folder.ID == uuid.Nil || folder.UserID != userID {
+		return "folder not found", nil
+	}
+	return "", nil
+}
+
+func validateFolderName(name string) (string, string) {
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "":
+		return "", "can't be empty"
+	case len(name) > maxFolderNameLength:
+		return "", fmt.Sprintf("must be at most %d characters", maxFolderNameLength)
+	}
+	return name, ""
+}
+
+func respondInvalidFolder(w http.ResponseWriter, details []errorDetail) {
+	respondWithAPIError(w, apiError{
+		Status:  http.StatusBadRequest,
+		Code:    errCodeValidation,
+		Message: "Invalid folder",
+		Details: details,
+	})
+}
+
+func (cfg *apiConfig) handlerFolderCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name     string     `json:"name"`
+		ParentID *uuid.UUID `json:"parent_id"`
+	}
+
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+
+	var details []errorDetail
+	name, problem := validateFolderName(params.Name)
+	if problem != "" {
+		details = append(details, errorDetail{Field: "name", Message: problem})
+	}
+	problem, err := cfg.checkFolder(userID, params.ParentID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get folder", err)
+		return
+	}
+	if problem != "" {
+		details = append(details, errorDetail{Field: "parent_id", Message: problem})
+	}
+	if len(details) > 0 {
+		respondInvalidFolder(w, details)
+		return
+	}
+
+	folder, err := cfg.db.CreateFolder(database.CreateFolderParams{
+		UserID:   userID,
+		ParentID: params.ParentID,
+		Name:     name,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create folder", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, folder)
+}
+
+// handlerFoldersList lists the folders inside ?parent_id=, or at the top of
+// the library when it's left out.
+func (cfg *apiConfig) handlerFoldersList(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	var parentID *uuid.UUID
+	if s := r.URL.Query().Get("parent_id"); s != "" {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid parent ID", err)
+			return
+		}
+		parentID = &id
+	}
+
+	folders, err := cfg.db.GetFolders(userID, parentID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get folders", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, folders)
+}
+
+// handlerFolderGet returns the folder with the path leading to it and the
+// folders directly inside it.
+func (cfg *apiConfig) handlerFolderGet(w http.ResponseWriter, r *http.Request) {
+	folder, ok := cfg.viewableFolder(w, r)
+	if !ok {
+		return
+	}
+
+	path, err := cfg.db.GetFolderPath(folder.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get folder path", err)
+		return
+	}
+	children, err := cfg.db.GetFolders(folder.UserID, &folder.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get folders", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		database.Folder
+		Path    []database.Folder `json:"path"`
+		Folders []database.Folder `json:"folders"`
+	}{
+		Folder:  folder,
+		Path:    path,
+		Folders: children,
+	})
+}
+
+func (cfg *apiConfig) handlerFolderRename(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+
+	folder, ok := cfg.ownedFolder(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+	name, problem := validateFolderName(params.Name)
+	if problem != "" {
+		respondInvalidFolder(w, []errorDetail{{Field: "name", Message: problem}})
+		return
+	}
+
+	folder.Name = name
+	folder, err := cfg.db.UpdateFolder(folder)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update folder", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, folder)
+}
+
+// handlerFolderMove puts the folder, with everything in it, inside
+// parent_id, or at the top of the library when parent_id is null.
+func (cfg *apiConfig) handlerFolderMove(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ParentID *uuid.UUID `json:"parent_id"`
+	}
+
+	folder, ok := cfg.ownedFolder(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+
+	problem, err := cfg.checkFolder(folder.UserID, params.ParentID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get folder", err)
+		return
+	}
+	if problem == "" && params.ParentID != nil {
+		path, err := cfg.db.GetFolderPath(*params.ParentID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get folder path", err)
+			return
+		}
+		for _, ancestor := range path {
+			if ancestor.ID == folder.ID {
+				problem = "can't move a folder inside itself"
+				break
+			}
+		}
+	}
+	if problem != "" {
+		respondInvalidFolder(w, []errorDetail{{Field: "parent_id", Message: problem}})
+		return
+	}
+
+	folder.ParentID = params.ParentID
+	folder, err = cfg.db.UpdateFolder(folder)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update folder", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, folder)
+}
+
+// handlerFolderDelete only removes empty folders, so deleting one never
+// takes videos with it by surprise.
+func (cfg *apiConfig) handlerFolderDelete(w http.ResponseWriter, r *http.Request) {
+	folder, ok := cfg.ownedFolder(w, r)
+	if !ok {
+		return
+	}
+
+	empty, err := cfg.db.FolderEmpty(folder.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check folder", err)
+		return
+	}
+	if !empty {
+		respondWithError(w, http.StatusConflict, "Folder isn't empty", nil)
+		return
+	}
+
+	if err := cfg.db.DeleteFolder(folder.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete folder", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerFolderVideos(w http.ResponseWriter, r *http.Request) {
+	folder, ok := cfg.viewableFolder(w, r)
+	if !ok {
+		return
+	}
+
+	params, err := pagination.ParseRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid pagination parameters", err)
+		return
+	}
+
+	videos, page, err := cfg.db.GetFolderVideosPage(folder.ID, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	pagination.WriteHeaders(w, page)
+	respondWithJSON(w, http.StatusOK, videos)
+}
+
+// handlerFolderVideosAdd files the given videos in the folder, wherever
+// they were before.
+func (cfg *apiConfig) handlerFolderVideosAdd(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoIDs []uuid.UUID `json:"video_ids"`
+	}
+
+	folder, ok := cfg.ownedFolder(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.VideoIDs) == 0 || len(params.VideoIDs) > maxBulkVideos {
+		respondInvalidFolder(w, []errorDetail{{Field: "video_ids", Message: fmt.Sprintf("must list between 1 and %d videos", maxBulkVideos)}})
+		return
+	}
+
+	results := make([]bulkVideoResult, 0, len(params.VideoIDs))
+	for _, videoID := range params.VideoIDs {
+		video, err := cfg.db.GetVideo(videoID)
+		if err != nil {
+			results = append(results, bulkFailure(videoID, &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't get video", Err: err}))
+			continue
+		}
+		if video.ID == uuid.Nil || video.UserID != folder.UserID {
+			results = append(results, bulkFailure(videoID, &apiError{Status: http.StatusNotFound, Code: errCodeVideoNotFound, Message: "Video not found"}))
+			continue
+		}
+		results = append(results, cfg.moveVideoToFolder(video, &folder.ID))
+	}
+	respondWithJSON(w, bulkStatus(results), results)
+}
+
+// handlerFolderBulk applies one action to the videos in a folder: all of
+// them, only those listed in video_ids, or with recursive set, those in
+// every folder below it too. Each video succeeds or fails on its own.
+func (cfg *apiConfig) handlerFolderBulk(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Action         string      `json:"action"`
+		VideoIDs       []uuid.UUID `json:"video_ids"`
+		Recursive      bool        `json:"recursive"`
+		Visibility     string      `json:"visibility"`
+		TargetFolderID *uuid.UUID  `json:"target_folder_id"`
+	}
+
+	folder, ok := cfg.ownedFolder(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+
+	var details []errorDetail
+	switch params.Action {
+	case bulkActionMove:
+		problem, err := cfg.checkFolder(folder.UserID, params.TargetFolderID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get folder", err)
+			return
+		}
+		if problem != "" {
+			details = append(details, errorDetail{Field: "target_folder_id", Message: problem})
+		}
+	case bulkActionSetVisibility:
+		if !database.ValidVisibility(params.Visibility) {
+			details = append(details, errorDetail{Field: "visibility", Message: "must be public, unlisted or private"})
+		}
+	case bulkActionDelete:
+	default:
+		details = append(details, errorDetail{Field: "action", Message: "must be move, set_visibility or delete"})
+	}
+	if len(details) > 0 {
+		respondInvalidFolder(w, details)
+		return
+	}
+
+	folderIDs := []uuid.UUID{folder.ID}
+	if params.Recursive {
+		tree, err := cfg.db.GetFolderTree(folder.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get folders", err)
+			return
+		}
+		folderIDs = tree
+	}
+	videos, err := cfg.db.GetFolderVideos(folderIDs)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	if len(params.VideoIDs) > 0 {
+		videos = selectVideos(videos, params.VideoIDs)
+	}
+	if len(videos) > maxBulkVideos {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Bulk actions are limited to %d videos; list video_ids to narrow it down", maxBulkVideos), nil)
+		return
+	}
+
+	results := make([]bulkVideoResult, 0, len(videos))
+	for _, video := range videos {
+		var result bulkVideoResult
+		switch params.Action {
+		case bulkActionMove:
+			result = cfg.moveVideoToFolder(video, params.TargetFolderID)
+		case bulkActionSetVisibility:
+			result = cfg.setVideoVisibility(video, params.Visibility, folder.UserID)
+		case bulkActionDelete:
+			result = bulkSuccess(video.ID)
+			err := cfg.deleteVideo(r.Context(), video)
+			if errors.Is(err, errVideoLocked) {
+				result = bulkFailure(video.ID, &apiError{Status: http.StatusConflict, Code: errCodeObjectLocked, Message: "Video is under retention or legal hold", Err: err})
+			} else if err != nil {
+				result = bulkFailure(video.ID, &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't delete video", Err: err})
+			}
+		}
+		results = append(results, result)
+	}
+	respondWithJSON(w, bulkStatus(results), results)
+}
+
+func (cfg *apiConfig) moveVideoToFolder(video database.Video, folderID *uuid.UUID) bulkVideoResult {
+	video.FolderID = folderID
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		return bulkFailure(video.ID, &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't update video", Err: err})
+	}
+	return bulkSuccess(video.ID)
+}
+
+func (cfg *apiConfig) setVideoVisibility(video database.Video, visibility string, callerID uuid.UUID) bulkVideoResult {
+	changes, _ := videoEdits{Visibility: &visibility}.apply(&video)
+	if len(changes) == 0 {
+		return bulkSuccess(video.ID)
+	}
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		return bulkFailure(video.ID, &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't update video", Err: err})
+	}
+	cfg.audit(auditUser(callerID), "video.update", auditSubjectVideo, video.ID.String(), changes)
+	return bulkSuccess(video.ID)
+}
+
+// selectVideos keeps the videos whose IDs are listed, in listing order.
+// IDs that aren't among videos are dropped.
+func selectVideos(videos []database.Video, ids []uuid.UUID) []database.Video {
+	byID := make(map[uuid.UUID]database.Video, len(videos))
+	for _, video := range videos {
+		byID[video.ID] = video
+	}
+	selected := []database.Video{}
+	for _, id := range ids {
+		if video, ok := byID[id]; ok {
+			selected = append(selected, video)
+			delete(byID, id)
+		}
+	}
+	return selected
+}
+
+func bulkSuccess(videoID uuid.UUID) bulkVideoResult {
+	return bulkVideoResult{VideoID: videoID, Status: http.StatusOK}
+}
+
+func bulkFailure(videoID uuid.UUID, apiErr *apiError) bulkVideoResult {
+	if apiErr.Err != nil {
+		log.Printf("Bulk action on video %s failed: %v", videoID, apiErr.Err)
+	}
+	return bulkVideoResult{VideoID: videoID, Status: apiErr.Status, Code: apiErr.Code, Error: apiErr.Message}
+}
+
+// bulkStatus is 200 when every video succeeded and 207 otherwise.
+func bulkStatus(results []bulkVideoResult) int {
+	for _, result := range results {
+		if result.Status != http.StatusOK {
+			return http.StatusMultiStatus
+		}
+	}
+	return http.StatusOK
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
+	"github.com/google/uuid"
+)
+
+// grantSubject is the video or folder whose grants a request manages,
+// along with the user it belongs to, who can't be given a grant on it.
+type grantSubject struct {
+	kind    string
+	id      uuid.UUID
+	ownerID uuid.UUID
+}
+
+func (cfg *apiConfig) videoGrantSubject(w http.ResponseWriter, r *http.Request) (grantSubject, bool) {
+	video, ok := cfg.authorizedVideo(w, r, permManage)
+	if !ok {
+		return grantSubject{}, false
+	}
+	return grantSubject{kind: database.GrantSubjectVideo, id: video.ID, ownerID: video.UserID}, true
+}
+
+func (cfg *apiConfig) folderGrantSubject(w http.ResponseWriter, r *http.Request) (grantSubject, bool) {
+	folder, ok := cfg.ownedFolder(w, r)
+	if !ok {
+		return grantSubject{}, false
+	}
+	return grantSubject{kind: database.GrantSubjectFolder, id: folder.ID, ownerID: folder.UserID}, true
+}
+
+func (cfg *apiConfig) handlerVideoGrantsList(w http.ResponseWriter, r *http.Request) {
+	if subject, ok := cfg.videoGrantSubject(w, r); ok {
+		cfg.listGrants(w, subject)
+	}
+}
+
+func (cfg *apiConfig) handlerVideoGrantSet(w http.ResponseWriter, r *http.Request) {
+	if subject, ok := cfg.videoGrantSubject(w, r); ok {
+		cfg.setGrant(w, r, subject)
+	}
+}
+
+func (cfg *apiConfig) handlerVideoGrantDelete(w http.ResponseWriter, r *http.Request) {
+	if subject, ok := cfg.videoGrantSubject(w, r); ok {
+		cfg.deleteGrant(w, r, subject)
+	}
+}
+
+func (cfg *apiConfig) handlerFolderGrantsList(w http.ResponseWriter, r *http.Request) {
+	if subject, ok := cfg.folderGrantSubject(w, r); ok {
+		cfg.listGrants(w, subject)
+	}
+}
+
+func (cfg *apiConfig) handlerFolderGrantSet(w http.ResponseWriter, r *http.Request) {
+	if subject, ok := cfg.folderGrantSubject(w, r); ok {
+		cfg.setGrant(w, r, subject)
+	}
+}
+
+func (cfg *apiConfig) handlerFolderGrantDelete(w http.ResponseWriter, r *http.Request) {
+	if subject, ok := cfg.folderGrantSubject(w, r); ok {
+		cfg.deleteGrant(w, r, subject)
+	}
+}
+
+func (cfg *apiConfig) listGrants(w http.ResponseWriter, subject grantSubject) {
+	grants, err := cfg.db.GetAccessGrants(subject.kind, subject.id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get grants", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, grants)
+}
+
+// setGrant gives the user with the given email a role on the subject,
+// replacing the one they had.
+func (cfg *apiConfig) setGrant(w http.ResponseWriter, r *http.Request, subject grantSubject) {
+	type parameters struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+	callerID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(strings.TrimSpace(params.Email))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	var details []errorDetail
+	switch {
+	case user.ID == uuid.Nil:
+		details = append(details, errorDetail{Field: "email", Message: "no user has this email"})
+	case user.ID == subject.ownerID:
+		details = append(details, errorDetail{Field: "email", Message: "already owns this " + subject.kind})
+	}
+	if !database.ValidRole(params.Role) {
+		details = append(details, errorDetail{Field: "role", Message: "must be owner, editor or viewer"})
+	}
+	if len(details) > 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid grant",
+			Details: details,
+		})
+		return
+	}
+
+	err = cfg.db.SetAccessGrant(database.SetAccessGrantParams{
+		SubjectType: subject.kind,
+		SubjectID:   subject.id,
+		UserID:      user.ID,
+		Role:        params.Role,
+		GrantedBy:   callerID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save grant", err)
+		return
+	}
+	cfg.audit(auditUser(callerID), subject.kind+".grant", subject.kind, subject.id.String(), map[string]any{
+		"user_id": user.ID,
+		"role":    params.Role,
+	})
+
+	grant, err := cfg.db.GetAccessGrant(subject.kind, subject.id, user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get grant", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, grant)
+}
+
+func (cfg *apiConfig) deleteGrant(w http.ResponseWriter, r *http.Request, subject grantSubject) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid user ID", err)
+		return
+	}
+	callerID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := cfg.db.DeleteAccessGrant(subject.kind, subject.id, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete grant", err)
+		return
+	}
+	if !deleted {
+		respondWithError(w, http.StatusNotFound, "Grant not found", nil)
+		return
+	}
+	cfg.audit(auditUser(callerID), subject.kind+".revoke", subject.kind, subject.id.String(), map[string]any{
+		"user_id": userID,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type sharedVideo struct {
+	database.Video
+	Role string `json:"role"`
+}
+
+// handlerVideosShared is the caller's "shared with me" list: videos other
+// users granted them a role on, with the role each one gives them.
+func (cfg *apiConfig) handlerVideosShared(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	params, err := pagination.ParseRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid pagination parameters", err)
+		return
+	}
+
+	videos, page, err := cfg.db.GetSharedVideosPage(userID, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	shared := make([]sharedVideo, 0, len(videos))
+	for _, video := range videos {
+		role, err := cfg.videoRole(video, userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check access", err)
+			return
+		}
+		shared = append(shared, sharedVideo{Video: video, Role: role})
+	}
+
+	pagination.WriteHeaders(w, page)
+	respondWithJSON(w, http.StatusOK, shared)
+}
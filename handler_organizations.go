@@ -0,0 +1,462 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
+	"github.com/google/uuid"
+)
+
+const maxOrgNameLength = 100
+
+// checkOrganization returns a validation message if the user isn't a
+// member of orgID. A nil orgID, meaning the user's personal library, is
+// fine.
+func (cfg *apiConfig) checkOrganization(userID uuid.UUID, orgID *uuid.UUID) (string, error) {
+	if orgID == nil {
+		return "", nil
+	}
+	member, err := cfg.db.GetOrganizationMember(*orgID, userID)
+	if err != nil {
+		return "", err
+	}
+	if member.UserID == uuid.Nil {
+		return "organization not found", nil
+	}
+	return "", nil
+}
+
+// memberOrganization loads the organization named in the path along with
+// the caller's membership, responding with an error unless they belong to
+// it.
+func (cfg *apiConfig) memberOrganization(w http.ResponseWriter, r *http.Request) (database.Organization, database.OrganizationMember, bool) {
+	orgID, err := uuid.Parse(r.PathValue("orgID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid ID", err)
+		return database.Organization{}, database.OrganizationMember{}, false
+	}
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return database.Organization{}, database.OrganizationMember{}, false
+	}
+	member, err := cfg.db.GetOrganizationMember(orgID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get membership", err)
+		return database.Organization{}, database.OrganizationMember{}, false
+	}
+	// Organizations the caller isn't in are reported as missing, like
+	// other users' folders.
+	if member.UserID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Organization not found", nil)
+		return database.Organization{}, database.OrganizationMember{}, false
+	}
+	org, err := cfg.db.GetOrganization(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization", err)
+		return database.Organization{}, database.OrganizationMember{}, false
+	}
+	return org, member, true
+}
+
+func (cfg *apiConfig) handlerOrgCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+
+	name := strings.TrimSpace(params.Name)
+	problem := ""
+	switch {
+	case name == "":
+		problem = "can't be empty"
+	case len(name) > maxOrgNameLength:
+		problem = fmt.Sprintf("must be at most %d characters", maxOrgNameLength)
+	}
+	if problem != "" {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid organization",
+			Details: []errorDetail{{Field: "name", Message: problem}},
+		})
+		return
+	}
+
+	org, err := cfg.db.CreateOrganization(name, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create organization", err)
+		return
+	}
+	cfg.audit(auditUser(userID), "organization.create", auditSubjectOrganization, org.ID.String(), map[string]any{"name": org.Name})
+
+	respondWithJSON(w, http.StatusCreated, org)
+}
+
+// handlerOrgsList lists the organizations the caller belongs to, with
+// their role in each.
+func (cfg *apiConfig) handlerOrgsList(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	orgs, err := cfg.db.GetUserOrganizations(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organizations", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, orgs)
+}
+
+// handlerOrgGet returns the organization with its plan and the caller's
+// role, so members can see how much of the shared quota is left.
+func (cfg *apiConfig) handlerOrgGet(w http.ResponseWriter, r *http.Request) {
+	org, member, ok := cfg.memberOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	plan, err := cfg.db.GetOrganizationPlan(org.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, struct {
+		database.Organization
+		Role string         `json:"role"`
+		Plan *database.Plan `json:"plan"`
+	}{org, member.Role, plan})
+}
+
+func (cfg *apiConfig) handlerOrgMembersList(w http.ResponseWriter, r *http.Request) {
+	org, _, ok := cfg.memberOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := cfg.db.GetOrganizationMembers(org.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get members", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+// handlerOrgMemberSet adds the user with the given email to the
+// organization, or changes their role if they're already in it. Only
+// admins can manage members.
+func (cfg *apiConfig) handlerOrgMemberSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	org, caller, ok := cfg.memberOrganization(w, r)
+	if !ok {
+		return
+	}
+	if caller.Role != database.OrgRoleAdmin {
+		respondWithErrorCode(w, http.StatusForbidden, errCodeForbidden, "Only organization admins can manage members", nil)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Role == "" {
+		params.Role = database.OrgRoleMember
+	}
+
+	user, err := cfg.db.GetUserByEmail(strings.TrimSpace(params.Email))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	var details []errorDetail
+	if user.ID == uuid.Nil {
+		details = append(details, errorDetail{Field: "email", Message: "no user has this email"})
+	}
+	if !database.ValidOrgRole(params.Role) {
+		details = append(details, errorDetail{Field: "role", Message: "must be admin or member"})
+	}
+	if len(details) > 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid member",
+			Details: details,
+		})
+		return
+	}
+
+	current, err := cfg.db.GetOrganizationMember(org.ID, user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get membership", err)
+		return
+	}
+	if current.Role == database.OrgRoleAdmin && params.Role != database.OrgRoleAdmin {
+		if !cfg.checkOtherAdmins(w, org.ID) {
+			return
+		}
+	}
+
+	if err := cfg.db.SetOrganizationMember(org.ID, user.ID, params.Role); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save member", err)
+		return
+	}
+	cfg.audit(auditUser(caller.UserID), "organization.member_set", auditSubjectOrganization, org.ID.String(), map[string]any{
+		"user_id": user.ID,
+		"role":    params.Role,
+	})
+
+	member, err := cfg.db.GetOrganizationMember(org.ID, user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get membership", err)
+		return
+	}
+	status := http.StatusOK
+	if current.UserID == uuid.Nil {
+		status = http.StatusCreated
+	}
+	respondWithJSON(w, status, member)
+}
+
+// handlerOrgMemberRemove takes a user out of the organization. Admins can
+// remove anyone and members can remove themselves. The videos they
+// uploaded stay in the organization's library.
+func (cfg *apiConfig) handlerOrgMemberRemove(w http.ResponseWriter, r *http.Request) {
+	org, caller, ok := cfg.memberOrganization(w, r)
+	if !ok {
+		return
+	}
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid user ID", err)
+		return
+	}
+	if caller.Role != database.OrgRoleAdmin && userID != caller.UserID {
+		respondWithErrorCode(w, http.StatusForbidden, errCodeForbidden, "Only organization admins can remove other members", nil)
+		return
+	}
+
+	member, err := cfg.db.GetOrganizationMember(org.ID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get membership", err)
+		return
+	}
+	if member.UserID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Member not found", nil)
+		return
+	}
+	if member.Role == database.OrgRoleAdmin && !cfg.checkOtherAdmins(w, org.ID) {
+		return
+	}
+
+	if err := cfg.db.RemoveOrganizationMember(org.ID, userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove member", err)
+		return
+	}
+	cfg.audit(auditUser(caller.UserID), "organization.member_remove", auditSubjectOrganization, org.ID.String(), map[string]any{
+		"user_id": userID,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkOtherAdmins responds with a conflict if an admin is about to stop
+// being one and nobody else could manage the organization.
+func (cfg *apiConfig) checkOtherAdmins(w http.ResponseWriter, orgID uuid.UUID) bool {
+	admins, err := cfg.db.CountOrganizationAdmins(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count admins", err)
+		return false
+	}
+	if admins <= 1 {
+		respondWithErrorCode(w, http.StatusConflict, errCodeConflict, "An organization needs at least one admin", nil)
+		return false
+	}
+	return true
+}
+
+// handlerOrgVideos lists the organization's library, newest first.
+func (cfg *apiConfig) handlerOrgVideos(w http.ResponseWriter, r *http.Request) {
+	org, _, ok := cfg.memberOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	params, err := pagination.ParseRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid pagination parameters", err)
+		return
+	}
+
+	videos, page, err := cfg.db.GetOrganizationVideosPage(org.ID, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	pagination.WriteHeaders(w, page)
+	respondWithJSON(w, http.StatusOK, videos)
+}
+
+// handlerVideoOrgUpdate moves a video into one of the caller's
+// organizations, or back to its owner's personal library when org_id is
+// null. The storage the video takes up moves with it, so the destination
+// has to have room.
+func (cfg *apiConfig) handlerVideoOrgUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		OrgID *uuid.UUID `json:"org_id"`
+	}
+
+	video, ok := cfg.authorizedVideo(w, r, permManage)
+	if !ok {
+		return
+	}
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+
+	problem, err := cfg.checkOrganization(userID, params.OrgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization", err)
+		return
+	}
+	// Leaving an organization puts the video on its owner's quota, so
+	// only they can do it.
+	if params.OrgID == nil && video.OrgID != nil && video.UserID != userID {
+		problem = "only the video's owner can move it to their personal library"
+	}
+	if problem != "" {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid organization",
+			Details: []errorDetail{{Field: "org_id", Message: problem}},
+		})
+		return
+	}
+	if sameOrganization(video.OrgID, params.OrgID) {
+		respondWithJSON(w, http.StatusOK, video)
+		return
+	}
+
+	bytes, objects, err := cfg.videoUsage(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video usage", err)
+		return
+	}
+	moved := video
+	moved.OrgID = params.OrgID
+	plan, err := cfg.videoPlan(moved)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	if err := cfg.checkVideoQuota(plan, moved, 0, bytes); err != nil {
+		respondWithAPIError(w, *quotaError(err))
+		return
+	}
+
+	err = cfg.db.SetVideoOrganization(video, params.OrgID, bytes, objects)
+	if errors.Is(err, database.ErrVideoChanged) {
+		respondWithErrorCode(w, http.StatusConflict, errCodeVideoChanged, "Video changed while it was being moved", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't move video", err)
+		return
+	}
+	cfg.audit(auditUser(userID), "video.org_move", auditSubjectVideo, video.ID.String(), map[string]any{
+		"from_org_id": video.OrgID,
+		"to_org_id":   params.OrgID,
+	})
+
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func sameOrganization(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (cfg *apiConfig) handlerAdminOrgPlanUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		PlanID string `json:"plan_id"`
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid organization ID", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+
+	org, err := cfg.db.GetOrganization(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization", err)
+		return
+	}
+	if org.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Organization not found", nil)
+		return
+	}
+
+	plan, err := cfg.db.GetPlan(params.PlanID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	if plan == nil {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Unknown plan",
+			Details: []errorDetail{{Field: "plan_id", Message: "doesn't match any plan"}},
+		})
+		return
+	}
+
+	if err := cfg.db.SetOrganizationPlan(org.ID, plan.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update plan", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, plan)
+}
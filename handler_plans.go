@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+var errStorageQuotaExceeded = errors.New("storage quota exceeded")
+
+// checkStorageQuota reports whether storing newBytes for the user, in place
+// of replacedBytes they already had, keeps them within their plan.
+func (cfg *apiConfig) checkStorageQuota(plan *database.Plan, userID uuid.UUID, replacedBytes, newBytes int64) error {
+	usage, err := cfg.db.GetUserUsage(userID)
+	if err != nil {
+		return err
+	}
+	err = withinQuota(plan, usage.BytesUsed, replacedBytes, newBytes)
+	cfg.reportQuotaBreach(err, plan, userID, nil, usage.BytesUsed, newBytes)
+	return err
+}
+
+func withinQuota(plan *database.Plan, bytesUsed, replacedBytes, newBytes int64) error {
+	if bytesUsed-replacedBytes+newBytes > plan.MaxTotalStorage {
+		return fmt.Errorf("%w: %d of %d bytes used", errStorageQuotaExceeded, bytesUsed, plan.MaxTotalStorage)
+	}
+	return nil
+}
+
+// mediaLimitError is returned by processing for a video longer or of a
+// higher resolution than its plan allows.
+type mediaLimitError struct {
+	planID  string
+	details []errorDetail
+}
+
+func (e *mediaLimitError) Error() string {
+	return fmt.Sprintf("video exceeds the limits of plan %s: %s %s", e.planID, e.details[0].Field, e.details[0].Message)
+}
+
+func (e *mediaLimitError) apiError() *apiError {
+	return &apiError{
+		Status:  http.StatusUnprocessableEntity,
+		Code:    errCodeMediaLimitExceeded,
+		Message: "Video exceeds your plan's duration or resolution limit",
+		Err:     e,
+		Details: e.details,
+	}
+}
+
+// mediaLimitDetails checks a video's duration in seconds and picture size
+// against the plan. Unknown values, zero, pass.
+func mediaLimitDetails(plan *database.Plan, duration float64, width, height int) []errorDetail {
+	var details []errorDetail
+	if plan.MaxDurationSeconds > 0 && duration > float64(plan.MaxDurationSeconds) {
+		details = append(details, errorDetail{
+			Field:   "duration",
+			Message: fmt.Sprintf("is %.0f seconds, the plan allows at most %d", duration, plan.MaxDurationSeconds),
+		})
+	}
+	if plan.MaxResolution > 0 && min(width, height) > plan.MaxResolution {
+		details = append(details, errorDetail{
+			Field:   "resolution",
+			Message: fmt.Sprintf("is %dx%d, the plan allows at most %dp", width, height, plan.MaxResolution),
+		})
+	}
+	return details
+}
+
+// videoPlan returns the plan that uploads to the video are held to: its
+// organization's for an organization video, its owner's otherwise.
+func (cfg *apiConfig) videoPlan(video database.Video) (*database.Plan, error) {
+	if video.OrgID != nil {
+		return cfg.db.GetOrganizationPlan(*video.OrgID)
+	}
+	return cfg.db.GetUserPlan(video.UserID)
+}
+
+// videoBytesUsed is the storage usage of whoever pays for the video.
+func (cfg *apiConfig) videoBytesUsed(video database.Video) (int64, error) {
+	if video.OrgID != nil {
+		org, err := cfg.db.GetOrganization(*video.OrgID)
+		return org.BytesUsed, err
+	}
+	usage, err := cfg.db.GetUserUsage(video.UserID)
+	return usage.BytesUsed, err
+}
+
+// checkVideoQuota is checkStorageQuota for storage added to the video,
+// charged to its organization when it has one.
+func (cfg *apiConfig) checkVideoQuota(plan *database.Plan, video database.Video, replacedBytes, newBytes int64) error {
+	bytesUsed, err := cfg.videoBytesUsed(video)
+	if err != nil {
+		return err
+	}
+	err = withinQuota(plan, bytesUsed, replacedBytes, newBytes)
+	cfg.reportQuotaBreach(err, plan, video.UserID, video.OrgID, bytesUsed, newBytes)
+	return err
+}
+
+// reportQuotaBreach puts a rejected upload on the admin event stream when
+// err is a quota failure.
+func (cfg *apiConfig) reportQuotaBreach(err error, plan *database.Plan, userID uuid.UUID, orgID *uuid.UUID, bytesUsed, newBytes int64) {
+	if !errors.Is(err, errStorageQuotaExceeded) {
+		return
+	}
+	cfg.events.Publish(eventQuotaExceeded, quotaExceededEvent{
+		UserID:  userID,
+		OrgID:   orgID,
+		PlanID:  plan.ID,
+		Used:    bytesUsed,
+		Limit:   plan.MaxTotalStorage,
+		Request: newBytes,
+	})
+}
+
+// adjustVideoUsage applies a change in the video's storage to whoever pays
+// for it.
+func (cfg *apiConfig) adjustVideoUsage(video database.Video, bytesDelta, objectsDelta int64) error {
+	if video.OrgID != nil {
+		return cfg.db.AdjustOrganizationUsage(*video.OrgID, bytesDelta, objectsDelta)
+	}
+	return cfg.db.AdjustUserUsage(video.UserID, bytesDelta, objectsDelta)
+}
+
+// quotaError maps a checkStorageQuota failure to its API error.
+func quotaError(err error) *apiError {
+	if errors.Is(err, errStorageQuotaExceeded) {
+		return &apiError{Status: http.StatusForbidden, Code: errCodeQuotaExceeded, Message: "Storage quota exceeded", Err: err}
+	}
+	return &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't check storage quota", Err: err}
+}
+
+func (cfg *apiConfig) handlerPlanGet(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	plan, err := cfg.db.GetUserPlan(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, plan)
+}
+
+func (cfg *apiConfig) handlerAdminPlansList(w http.ResponseWriter, r *http.Request) {
+	plans, err := cfg.db.GetPlans()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plans", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, plans)
+}
+
+func (cfg *apiConfig) handlerAdminUserPlanUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		PlanID string `json:"plan_id"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid user ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	plan, err := cfg.db.GetPlan(params.PlanID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	if plan == nil {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Unknown plan",
+			Details: []errorDetail{{Field: "plan_id", Message: "doesn't match any plan"}},
+		})
+		return
+	}
+
+	err = cfg.db.SetUserPlan(userID, plan.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update plan", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, plan)
+}
+
+func (cfg *apiConfig) handlerAdminPlanLimitsUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MaxDurationSeconds int `json:"max_duration_seconds"`
+		MaxResolution      int `json:"max_resolution"`
+	}
+
+	plan, err := cfg.db.GetPlan(r.PathValue("planID"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	if plan == nil {
+		respondWithError(w, http.StatusNotFound, "Plan not found", nil)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+	var details []errorDetail
+	if params.MaxDurationSeconds < 0 {
+		details = append(details, errorDetail{Field: "max_duration_seconds", Message: "can't be negative"})
+	}
+	if params.MaxResolution < 0 {
+		details = append(details, errorDetail{Field: "max_resolution", Message: "can't be negative"})
+	}
+	if len(details) > 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid plan limits",
+			Details: details,
+		})
+		return
+	}
+
+	if err := cfg.db.SetPlanMediaLimits(plan.ID, params.MaxDurationSeconds, params.MaxResolution); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update plan", err)
+		return
+	}
+	plan.MaxDurationSeconds = params.MaxDurationSeconds
+	plan.MaxResolution = params.MaxResolution
+
+	respondWithJSON(w, http.StatusOK, plan)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+type playbackRendition struct {
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type,omitempty"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	HDR         bool   `json:"hdr,omitempty"`
+	Size        int64  `json:"size"`
+}
+
+type playbackCaption struct {
+	Language string `json:"language"`
+	Source   string `json:"source"`
+	URL      string `json:"url"`
+}
+
+type playbackResponse struct {
+	VideoID uuid.UUID `json:"video_id"`
+	URL     string    `json:"url"`
+	// ExpiresAt is nil when the store can't sign URLs and the plain URL
+	// doesn't expire.
+	ExpiresAt  *time.Time          `json:"expires_at"`
+	Renditions []playbackRendition `json:"renditions"`
+	Captions   []playbackCaption   `json:"captions"`
+	Chapters   []database.Chapter  `json:"chapters"`
+}
+
+// handlerVideoPlayback hands out fresh playback URLs for a video. Players
+// call it again before the URLs expire instead of refetching the video.
+func (cfg *apiConfig) handlerVideoPlayback(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.getVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithErrorCode(w, http.StatusNotFound, errCodeVideoNotFound, "Video not found", nil)
+		return
+	}
+	if video.VideoKey == nil {
+		respondWithErrorCode(w, http.StatusConflict, errCodeConflict, "Video has no uploaded file yet", nil)
+		return
+	}
+	if !cfg.checkPrivateVideo(w, r, video) {
+		return
+	}
+	if !cfg.checkGeoRestrictions(w, r, video) {
+		return
+	}
+	if !video.Playable(time.Now()) {
+		respondVideoArchived(w, video)
+		return
+	}
+	key := *video.VideoKey
+	cfg.recordVideoAccess(video)
+
+	resp := playbackResponse{VideoID: video.ID}
+	now := time.Now().UTC().Truncate(time.Second)
+	clientIP := cfg.clientIP(r)
+	// The response expires with the first of its URLs to.
+	expires := func(signed signedURL) {
+		if resp.ExpiresAt == nil || signed.Expires.Before(*resp.ExpiresAt) {
+			resp.ExpiresAt = &signed.Expires
+		}
+	}
+	signed, err := cfg.signPlaybackURL(r.Context(), key, video.Visibility, now, clientIP)
+	switch {
+	case errors.Is(err, storage.ErrSigningNotSupported):
+		resp.URL = cfg.store.URL(key)
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign playback URL", err)
+		return
+	default:
+		resp.URL = signed.URL
+		expires(signed)
+	}
+
+	resp.Renditions = []playbackRendition{{
+		Name:        "source",
+		URL:         resp.URL,
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+		Size:        video.VideoSize,
+	}}
+	renditions, err := cfg.db.GetVideoRenditions(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get renditions", err)
+		return
+	}
+	for _, rendition := range renditions {
+		url := cfg.store.URL(rendition.Key)
+		if signed, err := cfg.signPlaybackURL(r.Context(), rendition.Key, video.Visibility, now, clientIP); err == nil {
+			url = signed.URL
+			expires(signed)
+		}
+		resp.Renditions = append(resp.Renditions, playbackRendition{
+			Name:        rendition.Name,
+			URL:         url,
+			ContentType: "video/mp4",
+			Width:       rendition.Width,
+			Height:      rendition.Height,
+			HDR:         rendition.HDR,
+			Size:        rendition.Size,
+		})
+	}
+
+	captions, err := cfg.db.GetCaptions(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get captions", err)
+		return
+	}
+	resp.Captions = make([]playbackCaption, 0, len(captions))
+	for _, c := range captions {
+		// Caption tracks are signed like the video, under the same
+		// policy, so they stop working around the same time.
+		url := c.URL
+		if signed, err := cfg.signPlaybackURL(r.Context(), c.Key, video.Visibility, now, clientIP); err == nil {
+			url = signed.URL
+			expires(signed)
+		}
+		resp.Captions = append(resp.Captions, playbackCaption{Language: c.Language, Source: c.Source, URL: url})
+	}
+	resp.Chapters, err = cfg.db.GetChapters(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chapters", err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, resp)
+}
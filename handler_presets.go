@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+var presetIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+var (
+	presetVideoCodecs = map[string]bool{"h264": true, "h265": true, "vp9": true, "av1": true}
+	presetAudioCodecs = map[string]bool{"aac": true, "opus": true}
+)
+
+// maxPresetFrameRate is the highest frame rate a preset can cap
+// renditions at.
+const maxPresetFrameRate = 240
+
+type presetParameters struct {
+	Name       string               `json:"name"`
+	VideoCodec string               `json:"video_codec"`
+	AudioCodec string               `json:"audio_codec"`
+	Renditions []database.Rendition `json:"renditions"`
+	// MaxFrameRate is optional; zero keeps the source's frame rate.
+	MaxFrameRate int `json:"max_frame_rate"`
+}
+
+func (p presetParameters) validate() []errorDetail {
+	var details []errorDetail
+	if p.Name == "" {
+		details = append(details, errorDetail{Field: "name", Message: "is required"})
+	}
+	if !presetVideoCodecs[p.VideoCodec] {
+		details = append(details, errorDetail{Field: "video_codec", Message: "must be h264, h265, vp9 or av1"})
+	}
+	if !presetAudioCodecs[p.AudioCodec] {
+		details = append(details, errorDetail{Field: "audio_codec", Message: "must be aac or opus"})
+	}
+	if p.MaxFrameRate < 0 || p.MaxFrameRate > maxPresetFrameRate {
+		details = append(details, errorDetail{Field: "max_frame_rate", Message: fmt.Sprintf("must be between 0 and %d", maxPresetFrameRate)})
+	}
+	if len(p.Renditions) == 0 {
+		details = append(details, errorDetail{Field: "renditions", Message: "must have at least one rendition"})
+	}
+	seen := map[string]bool{}
+	for i, rendition := range p.Renditions {
+		field := fmt.Sprintf("renditions[%d]", i)
+		if rendition.Name == "" {
+			details = append(details, errorDetail{Field: field + ".name", Message: "is required"})
+		} else if seen[rendition.Name] {
+			details = append(details, errorDetail{Field: field + ".name", Message: "is used by another rendition"})
+		}
+		seen[rendition.Name] = true
+		if rendition.Height <= 0 || rendition.Height%2 != 0 {
+			details = append(details, errorDetail{Field: field + ".height", Message: "must be a positive even number"})
+		}
+		if rendition.VideoBitrateKbps <= 0 {
+			details = append(details, errorDetail{Field: field + ".video_bitrate_kbps", Message: "must be positive"})
+		}
+		if rendition.AudioBitrateKbps <= 0 {
+			details = append(details, errorDetail{Field: field + ".audio_bitrate_kbps", Message: "must be positive"})
+		}
+	}
+	return details
+}
+
+func (cfg *apiConfig) handlerAdminPresetsList(w http.ResponseWriter, r *http.Request) {
+	presets, err := cfg.db.GetPresets()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get presets", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, presets)
+}
+
+func (cfg *apiConfig) handlerAdminPresetGet(w http.ResponseWriter, r *http.Request) {
+	preset, err := cfg.db.GetPreset(r.PathValue("presetID"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get preset", err)
+		return
+	}
+	if preset == nil {
+		respondWithError(w, http.StatusNotFound, "Preset not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, preset)
+}
+
+func (cfg *apiConfig) handlerAdminPresetCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ID string `json:"id"`
+		presetParameters
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+	details := params.validate()
+	if !presetIDPattern.MatchString(params.ID) {
+		details = append([]errorDetail{{Field: "id", Message: "must be lower case letters, digits, '-' or '_'"}}, details...)
+	}
+	if len(details) > 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid preset",
+			Details: details,
+		})
+		return
+	}
+
+	existing, err := cfg.db.GetPreset(params.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get preset", err)
+		return
+	}
+	if existing != nil {
+		respondWithError(w, http.StatusConflict, "A preset with that ID already exists", nil)
+		return
+	}
+
+	preset, err := cfg.db.CreatePreset(database.TranscodingPreset{
+		ID:           params.ID,
+		Name:         params.Name,
+		VideoCodec:   params.VideoCodec,
+		AudioCodec:   params.AudioCodec,
+		Renditions:   params.Renditions,
+		MaxFrameRate: params.MaxFrameRate,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create preset", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, preset)
+}
+
+func (cfg *apiConfig) handlerAdminPresetUpdate(w http.ResponseWriter, r *http.Request) {
+	existing, err := cfg.db.GetPreset(r.PathValue("presetID"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get preset", err)
+		return
+	}
+	if existing == nil {
+		respondWithError(w, http.StatusNotFound, "Preset not found", nil)
+		return
+	}
+
+	params := presetParameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+	details := params.validate()
+	if len(details) == 0 {
+		details, err = cfg.presetPlanConflicts(existing.ID, len(params.Renditions))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check plans", err)
+			return
+		}
+	}
+	if len(details) > 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid preset",
+			Details: details,
+		})
+		return
+	}
+
+	preset, err := cfg.db.UpdatePreset(database.TranscodingPreset{
+		ID:           existing.ID,
+		Name:         params.Name,
+		VideoCodec:   params.VideoCodec,
+		AudioCodec:   params.AudioCodec,
+		Renditions:   params.Renditions,
+		MaxFrameRate: params.MaxFrameRate,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update preset", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, preset)
+}
+
+func (cfg *apiConfig) handlerAdminPresetDelete(w http.ResponseWriter, r *http.Request) {
+	presetID := r.PathValue("presetID")
+	preset, err := cfg.db.GetPreset(presetID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get preset", err)
+		return
+	}
+	if preset == nil {
+		respondWithError(w, http.StatusNotFound, "Preset not found", nil)
+		return
+	}
+
+	planIDs, err := cfg.db.GetPresetPlans(presetID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check plans", err)
+		return
+	}
+	if len(planIDs) > 0 {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Preset is assigned to plans %v", planIDs), nil)
+		return
+	}
+
+	if err := cfg.db.DeletePreset(presetID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete preset", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerAdminPlanPresetUpdate assigns a preset to a plan, or clears it
+// when preset_id is empty.
+func (cfg *apiConfig) handlerAdminPlanPresetUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		PresetID string `json:"preset_id"`
+	}
+
+	plan, err := cfg.db.GetPlan(r.PathValue("planID"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	if plan == nil {
+		respondWithError(w, http.StatusNotFound, "Plan not found", nil)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+
+	if params.PresetID != "" {
+		preset, err := cfg.db.GetPreset(params.PresetID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get preset", err)
+			return
+		}
+		var detail string
+		switch {
+		case preset == nil:
+			detail = "doesn't match any preset"
+		case len(preset.Renditions) > plan.MaxRenditions:
+			detail = fmt.Sprintf("has %d renditions but the plan allows %d", len(preset.Renditions), plan.MaxRenditions)
+		}
+		if detail != "" {
+			respondWithAPIError(w, apiError{
+				Status:  http.StatusBadRequest,
+				Code:    errCodeValidation,
+				Message: "Invalid preset",
+				Details: []errorDetail{{Field: "preset_id", Message: detail}},
+			})
+			return
+		}
+	}
+
+	if err := cfg.db.SetPlanPreset(plan.ID, params.PresetID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update plan", err)
+		return
+	}
+	plan.PresetID = params.PresetID
+
+	respondWithJSON(w, http.StatusOK, plan)
+}
+
+// presetPlanConflicts reports the plans that would be left with more
+// renditions than they allow if the preset had the given number.
+func (cfg *apiConfig) presetPlanConflicts(presetID string, renditions int) ([]errorDetail, error) {
+	planIDs, err := cfg.db.GetPresetPlans(presetID)
+	if err != nil {
+		return nil, err
+	}
+	var details []errorDetail
+	for _, planID := range planIDs {
+		plan, err := cfg.db.GetPlan(planID)
+		if err != nil {
+			return nil, err
+		}
+		if plan != nil && renditions > plan.MaxRenditions {
+			details = append(details, errorDetail{
+				Field:   "renditions",
+				Message: fmt.Sprintf("plan %s allows at most %d", plan.ID, plan.MaxRenditions),
+			})
+		}
+	}
+	return details, nil
+}
@@ -23,10 +23,14 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
 		return
 	}
+	if user != nil && user.SuspendedAt != nil {
+		respondWithErrorCode(w, http.StatusForbidden, errCodeAccountSuspended, "Account is suspended", nil)
+		return
+	}
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
-		cfg.jwtSecret,
+		cfg.jwtSecret.Get(),
 		time.Hour,
 	)
 	if err != nil {
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
+	"github.com/google/uuid"
+)
+
+const maxReportMessageLength = 1000
+
+// Moderation actions an admin can resolve a report with.
+const (
+	moderationDismiss = "dismiss"
+	moderationUnlist  = "unlist"
+	moderationDelete  = "delete"
+	moderationSuspend = "suspend"
+)
+
+// handlerVideoReport files an abuse report against a video the caller can
+// watch. Each user has at most one open report per video.
+func (cfg *apiConfig) handlerVideoReport(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	}
+
+	video, userID, ok := cfg.watchableVideo(w, r)
+	if !ok {
+		return
+	}
+	if video.UserID == userID {
+		respondWithError(w, http.StatusBadRequest, "You can't report your own video", nil)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+	params.Message = strings.TrimSpace(params.Message)
+
+	var details []errorDetail
+	if !slices.Contains(database.ReportReasons, params.Reason) {
+		details = append(details, errorDetail{Field: "reason", Message: "must be one of " + strings.Join(database.ReportReasons, ", ")})
+	}
+	if params.Reason == "other" && params.Message == "" {
+		details = append(details, errorDetail{Field: "message", Message: "is required when the reason is other"})
+	}
+	if utf8.RuneCountInString(params.Message) > maxReportMessageLength {
+		details = append(details, errorDetail{Field: "message", Message: "is too long"})
+	}
+	if len(details) > 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid report",
+			Details: details,
+		})
+		return
+	}
+
+	report, err := cfg.db.CreateReport(database.CreateReportParams{
+		VideoID:    video.ID,
+		ReporterID: userID,
+		Reason:     params.Reason,
+		Message:    params.Message,
+	})
+	if errors.Is(err, database.ErrAlreadyReported) {
+		respondWithError(w, http.StatusConflict, "You already reported this video", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create report", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, report)
+}
+
+// handlerAdminReportsList is the moderation queue: reports in the given
+// status, open ones by default, each with the video they're about.
+func (cfg *apiConfig) handlerAdminReportsList(w http.ResponseWriter, r *http.Request) {
+	type queueEntry struct {
+		database.Report
+		Video *database.Video `json:"video,omitempty"`
+		// OpenReports counts every open report on the video, this one
+		// included.
+		OpenReports int `json:"open_reports"`
+	}
+
+	params, err := pagination.ParseRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid pagination parameters", err)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = database.ReportOpen
+	case database.ReportOpen, database.ReportDismissed, database.ReportActioned:
+	default:
+		respondWithError(w, http.StatusBadRequest, "Unknown report status", nil)
+		return
+	}
+
+	reports, page, err := cfg.db.GetReportsPage(status, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve reports", err)
+		return
+	}
+
+	entries := make([]queueEntry, 0, len(reports))
+	for _, report := range reports {
+		entry := queueEntry{Report: report}
+		video, err := cfg.db.GetVideo(report.VideoID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		if video.ID != uuid.Nil {
+			entry.Video = &video
+		}
+		entry.OpenReports, err = cfg.db.CountOpenReports(report.VideoID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't count reports", err)
+			return
+		}
+		entries = append(entries, entry)
+	}
+
+	pagination.WriteHeaders(w, page)
+	respondWithJSON(w, http.StatusOK, entries)
+}
+
+// handlerAdminReportResolve applies a moderation action to the reported
+// video and closes every open report on it. Actions are recorded in the
+// audit log.
+func (cfg *apiConfig) handlerAdminReportResolve(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Action string `json:"action"`
+		Note   string `json:"note"`
+	}
+	type response struct {
+		Action          string `json:"action"`
+		ReportsResolved int64  `json:"reports_resolved"`
+	}
+
+	reportID, err := uuid.Parse(r.PathValue("reportID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid report ID", err)
+		return
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+
+	report, err := cfg.db.GetReport(reportID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get report", err)
+		return
+	}
+	if report.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Report not found", nil)
+		return
+	}
+	if report.Status != database.ReportOpen {
+		respondWithError(w, http.StatusConflict, "Report is already resolved", nil)
+		return
+	}
+	video, err := cfg.db.GetVideo(report.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil && params.Action != moderationDismiss {
+		respondWithErrorCode(w, http.StatusNotFound, errCodeVideoNotFound, "The reported video no longer exists", nil)
+		return
+	}
+
+	status := database.ReportActioned
+	action, subjectType, subjectID := "", auditSubjectVideo, report.VideoID.String()
+	switch params.Action {
+	case moderationDismiss:
+		status = database.ReportDismissed
+		action, subjectType, subjectID = "report.dismiss", auditSubjectReport, report.ID.String()
+	case moderationUnlist:
+		if video.Visibility == database.VisibilityPublic {
+			video.Visibility = database.VisibilityUnlisted
+			if err := cfg.db.UpdateVideo(video); err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+				return
+			}
+		}
+		action = "video.unlist"
+	case moderationDelete:
+		if video.Locked(time.Now()) {
+			respondWithErrorCode(w, http.StatusConflict, errCodeObjectLocked, "Video is under retention or legal hold", nil)
+			return
+		}
+		action = "video.delete"
+	case moderationSuspend:
+		if err := cfg.db.SuspendUser(video.UserID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't suspend user", err)
+			return
+		}
+		action, subjectType, subjectID = "user.suspend", auditSubjectUser, video.UserID.String()
+	default:
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid moderation action",
+			Details: []errorDetail{{Field: "action", Message: "must be one of dismiss, unlist, delete, suspend"}},
+		})
+		return
+	}
+
+	// Resolved before deleting, since deleting the video dismisses
+	// whatever reports are still open on it.
+	resolved, err := cfg.db.ResolveVideoReports(report.VideoID, status, params.Action)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve reports", err)
+		return
+	}
+	if params.Action == moderationDelete {
+		if err := cfg.deleteVideo(r.Context(), video); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+			return
+		}
+	}
+
+	cfg.audit(database.ActorAdmin, action, subjectType, subjectID, map[string]any{
+		"report_id":        report.ID,
+		"video_id":         report.VideoID,
+		"reports_resolved": resolved,
+		"note":             params.Note,
+	})
+	respondWithJSON(w, http.StatusOK, response{Action: params.Action, ReportsResolved: resolved})
+}
+
+// rejectSuspendedUsers refuses requests made with a suspended user's
+// access token. Tokens are long lived, so revoking refresh tokens alone
+// wouldn't lock the user out.
+func (cfg *apiConfig) rejectSuspendedUsers(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Refresh tokens aren't JWTs and get checked by their handlers.
+		userID, err := cfg.validateJWT(token)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, err := cfg.db.GetUser(userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+			return
+		}
+		if user != nil && user.SuspendedAt != nil {
+			respondWithErrorCode(w, http.StatusForbidden, errCodeAccountSuspended, "Account is suspended", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const webhookVideoRestored = "video.restored"
+
+// handlerVideoRestore starts restoring an archived video. The restore runs
+// in the background for hours; clients poll the video's storage_tier or
+// wait for the video.restored webhook.
+func (cfg *apiConfig) handlerVideoRestore(w http.ResponseWriter, r *http.Request) {
+	archiver, ok := cfg.store.(storage.Archiver)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "The storage backend doesn't archive videos", nil)
+		return
+	}
+
+	video, ok := cfg.authorizedVideo(w, r, permEdit)
+	if !ok {
+		return
+	}
+
+	switch {
+	case video.StorageTier == database.TierRestoring:
+		respondWithJSON(w, http.StatusAccepted, video)
+		return
+	case video.Playable(time.Now()) || video.VideoKey == nil:
+		respondWithError(w, http.StatusConflict, "Video isn't archived", nil)
+		return
+	}
+
+	days := int32(envInt("RESTORE_DAYS", 7))
+	if err := archiver.Restore(r.Context(), *video.VideoKey, days); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore video", err)
+		return
+	}
+
+	video.StorageTier = database.TierRestoring
+	video.RestoredUntil = nil
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, video)
+}
+
+// recordVideoAccess keeps a played video out of the archive tier. It's
+// best effort: playback goes ahead if the write fails.
+func (cfg *apiConfig) recordVideoAccess(video database.Video) {
+	if err := cfg.db.RecordVideoAccess(video.ID, time.Now()); err != nil {
+		log.Printf("couldn't record access to video %s: %v", video.ID, err)
+	}
+}
+
+// archiveColdVideos moves videos that haven't been created, played or
+// watched for COLD_TIER_AFTER into the archive tier. It does nothing
+// unless that's set.
+func (cfg *apiConfig) archiveColdVideos(ctx context.Context) error {
+	after := envDuration("COLD_TIER_AFTER", 0)
+	archiver, ok := cfg.store.(storage.Archiver)
+	if after <= 0 || !ok {
+		return nil
+	}
+
+	videos, err := cfg.db.GetColdVideos(time.Now().Add(-after), envInt("COLD_TIER_BATCH", 100))
+	if err != nil {
+		return fmt.Errorf("couldn't list cold videos: %w", err)
+	}
+
+	cold := make(map[uuid.UUID]bool, len(videos))
+	for _, video := range videos {
+		cold[video.ID] = true
+	}
+	archived := map[string]bool{}
+	for _, video := range videos {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		key := *video.VideoKey
+		if archived[key] {
+			continue
+		}
+		// A content-hash object is archived for every video sharing it, so
+		// it waits until they've all gone cold.
+		others, err := cfg.videosSharingKey(key, video.ID)
+		if err != nil {
+			log.Printf("cold_tiering: couldn't check whether video %s is shared: %v", video.ID, err)
+			continue
+		}
+		if slices.ContainsFunc(others, func(v database.Video) bool { return !cold[v.ID] }) {
+			continue
+		}
+		info, err := archiver.Archive(ctx, key)
+		if err != nil {
+			log.Printf("cold_tiering: couldn't archive video %s: %v", video.ID, err)
+			continue
+		}
+		archived[key] = true
+
+		// Rewriting the object gives it a new ETag and version.
+		for _, v := range append(others, video) {
+			v.StorageTier = database.TierArchived
+			v.RestoredUntil = nil
+			v.VideoETag = info.ETag
+			v.VideoVersionID = info.VersionID
+			if info.ChecksumSHA256 != "" {
+				v.VideoChecksum = info.ChecksumSHA256
+			}
+			if err := cfg.db.UpdateVideo(v); err != nil {
+				return fmt.Errorf("couldn't update video %s: %w", v.ID, err)
+			}
+			log.Printf("cold_tiering: archived video %s", v.ID)
+		}
+	}
+	return nil
+}
+
+// pollRestores marks finished restores as playable and tells the owners,
+// and moves restored videos back to archived once their copy expires.
+func (cfg *apiConfig) pollRestores(ctx context.Context) error {
+	type restoredVideo struct {
+		VideoID       uuid.UUID `json:"video_id"`
+		UserID        uuid.UUID `json:"user_id"`
+		Title         string    `json:"title"`
+		RestoredUntil time.Time `json:"restored_until"`
+	}
+
+	restoring, err := cfg.db.GetVideosInTier(database.TierRestoring)
+	if err != nil {
+		return fmt.Errorf("couldn't list restoring videos: %w", err)
+	}
+	for _, video := range restoring {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		info, err := cfg.store.Stat(ctx, *video.VideoKey)
+		if err != nil {
+			log.Printf("restore_poll: couldn't check video %s: %v", video.ID, err)
+			continue
+		}
+		if info.Restoring || info.RestoredUntil.IsZero() {
+			continue
+		}
+
+		restoredUntil := info.RestoredUntil.UTC()
+		video.StorageTier = database.TierRestored
+		video.RestoredUntil = &restoredUntil
+		if err := cfg.db.UpdateVideo(video); err != nil {
+			return fmt.Errorf("couldn't update video %s: %w", video.ID, err)
+		}
+		log.Printf("restore_poll: video %s is playable until %s", video.ID, restoredUntil)
+
+		err = cfg.webhooks.Send(ctx, webhookVideoRestored, restoredVideo{
+			VideoID:       video.ID,
+			UserID:        video.UserID,
+			Title:         video.Title,
+			RestoredUntil: restoredUntil,
+		})
+		if err != nil {
+			log.Printf("restore_poll: couldn't notify owner of video %s: %v", video.ID, err)
+		}
+	}
+
+	restored, err := cfg.db.GetVideosInTier(database.TierRestored)
+	if err != nil {
+		return fmt.Errorf("couldn't list restored videos: %w", err)
+	}
+	now := time.Now()
+	for _, video := range restored {
+		if video.Playable(now) {
+			continue
+		}
+		video.StorageTier = database.TierArchived
+		video.RestoredUntil = nil
+		if err := cfg.db.UpdateVideo(video); err != nil {
+			return fmt.Errorf("couldn't update video %s: %w", video.ID, err)
+		}
+	}
+	return nil
+}
+
+// respondVideoArchived tells the client to restore the video before
+// reading it.
+func respondVideoArchived(w http.ResponseWriter, video database.Video) {
+	msg := "Video is archived and has to be restored first"
+	if video.StorageTier == database.TierRestoring {
+		msg = "Video is being restored from the archive"
+	}
+	respondWithErrorCode(w, http.StatusConflict, errCodeVideoArchived, msg, nil)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+var errVideoLocked = errors.New("video is under retention or legal hold")
+
+// videoRetention is the Object Lock configuration new objects for the
+// video are stored with.
+func (cfg *apiConfig) videoRetention(video database.Video) storage.Retention {
+	if !cfg.objectLock {
+		return storage.Retention{}
+	}
+	r := storage.Retention{Mode: video.RetentionMode, LegalHold: video.LegalHold}
+	if video.RetainUntil != nil {
+		r.RetainUntil = *video.RetainUntil
+	}
+	return r
+}
+
+// handlerVideoRetentionUpdate sets the retention period and legal hold of
+// a video. They're applied to the stored object right away and to every
+// object uploaded for the video later. Retention can only be extended, and
+// only in GOVERNANCE mode: COMPLIANCE can't be lifted by anyone, so it's
+// left to handlerAdminVideoRetentionUpdate.
+func (cfg *apiConfig) handlerVideoRetentionUpdate(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizedVideo(w, r, permManage)
+	if !ok {
+		return
+	}
+	cfg.updateVideoRetention(w, r, video, false)
+}
+
+// handlerAdminVideoRetentionUpdate is handlerVideoRetentionUpdate for
+// admins, who may also put a video under COMPLIANCE retention.
+func (cfg *apiConfig) handlerAdminVideoRetentionUpdate(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid ID", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithErrorCode(w, http.StatusNotFound, errCodeVideoNotFound, "Video not found", nil)
+		return
+	}
+	cfg.updateVideoRetention(w, r, video, true)
+}
+
+func (cfg *apiConfig) updateVideoRetention(w http.ResponseWriter, r *http.Request, video database.Video, admin bool) {
+	type parameters struct {
+		Mode        string     `json:"mode"`
+		RetainUntil *time.Time `json:"retain_until"`
+		LegalHold   bool       `json:"legal_hold"`
+	}
+
+	if !cfg.objectLock {
+		respondWithError(w, http.StatusNotImplemented, "Object Lock isn't enabled on this deployment", nil)
+		return
+	}
+	locker, ok := cfg.store.(storage.Locker)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "The storage backend doesn't support Object Lock", nil)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+
+	var details []errorDetail
+	switch params.Mode {
+	case storage.RetentionGovernance, storage.RetentionCompliance:
+		if params.RetainUntil == nil || !params.RetainUntil.After(time.Now()) {
+			details = append(details, errorDetail{Field: "retain_until", Message: "must be in the future"})
+		} else if cfg.maxRetention > 0 && params.RetainUntil.After(time.Now().Add(cfg.maxRetention)) {
+			details = append(details, errorDetail{Field: "retain_until", Message: fmt.Sprintf("can't be more than %s away", cfg.maxRetention)})
+		}
+	case "":
+		if params.RetainUntil != nil {
+			details = append(details, errorDetail{Field: "retain_until", Message: "needs a mode"})
+		}
+	default:
+		details = append(details, errorDetail{Field: "mode", Message: "must be GOVERNANCE or COMPLIANCE"})
+	}
+	if len(details) > 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid retention",
+			Details: details,
+		})
+		return
+	}
+
+	if params.Mode == storage.RetentionCompliance && !admin {
+		respondWithErrorCode(w, http.StatusForbidden, errCodeForbidden, "Only an admin can set COMPLIANCE retention", nil)
+		return
+	}
+
+	if video.RetainUntil != nil && time.Now().Before(*video.RetainUntil) {
+		shortened := params.RetainUntil == nil || params.RetainUntil.Before(*video.RetainUntil)
+		downgraded := video.RetentionMode == storage.RetentionCompliance && params.Mode != storage.RetentionCompliance
+		if shortened || downgraded {
+			respondWithErrorCode(w, http.StatusConflict, errCodeObjectLocked, "Retention can only be extended", nil)
+			return
+		}
+	}
+
+	video.RetentionMode = params.Mode
+	video.RetainUntil = nil
+	if params.RetainUntil != nil {
+		retainUntil := params.RetainUntil.UTC()
+		video.RetainUntil = &retainUntil
+	}
+	video.LegalHold = params.LegalHold
+
+	if video.VideoKey != nil {
+		retention, err := cfg.keyRetention(*video.VideoKey, video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check whether the video's object is shared", err)
+			return
+		}
+		if err := locker.SetRetention(r.Context(), *video.VideoKey, retention); err != nil {
+			if errors.Is(err, storage.ErrObjectLocked) {
+				respondWithErrorCode(w, http.StatusConflict, errCodeObjectLocked, "The stored object's lock can't be changed that way", err)
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Couldn't apply retention", err)
+			return
+		}
+	}
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// keyRetention is the Object Lock configuration for the object at key as
+// video would have it. A content-hash object shared with other videos
+// keeps the strictest of their settings, so one owner's change can't
+// lift a lock another owner set.
+func (cfg *apiConfig) keyRetention(key string, video database.Video) (storage.Retention, error) {
+	retention := cfg.videoRetention(video)
+	others, err := cfg.videosSharingKey(key, video.ID)
+	if err != nil {
+		return retention, err
+	}
+	now := time.Now()
+	for _, other := range others {
+		r := cfg.videoRetention(other)
+		retention.LegalHold = retention.LegalHold || r.LegalHold
+		if r.Mode == "" || !now.Before(r.RetainUntil) {
+			continue
+		}
+		if r.RetainUntil.After(retention.RetainUntil) {
+			retention.RetainUntil = r.RetainUntil
+		}
+		if retention.Mode != storage.RetentionCompliance {
+			retention.Mode = r.Mode
+		}
+	}
+	return retention, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// handlerVideoScheduleSet keeps the video private until publish_at, when the
+// scheduled_publish task makes it public.
+func (cfg *apiConfig) handlerVideoScheduleSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		PublishAt time.Time `json:"publish_at"`
+	}
+
+	video, ok := cfg.authorizedVideo(w, r, permEdit)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+	if !params.PublishAt.After(time.Now()) {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid schedule",
+			Details: []errorDetail{{Field: "publish_at", Message: "must be in the future"}},
+		})
+		return
+	}
+
+	publishAt := params.PublishAt.UTC()
+	video.PublishAt = &publishAt
+	video.Visibility = database.VisibilityPrivate
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerVideoScheduleCancel stops a scheduled publication. The video stays
+// private.
+func (cfg *apiConfig) handlerVideoScheduleCancel(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizedVideo(w, r, permEdit)
+	if !ok {
+		return
+	}
+	if video.PublishAt == nil {
+		respondWithError(w, http.StatusNotFound, "Video isn't scheduled for publication", nil)
+		return
+	}
+
+	video.PublishAt = nil
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// publishScheduledVideos makes public the videos whose publication time
+// has come.
+func (cfg *apiConfig) publishScheduledVideos(ctx context.Context) error {
+	ids, err := cfg.db.PublishDueVideos(time.Now())
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		log.Printf("scheduled_publish: published video %s", id)
+	}
+	if len(ids) > 0 {
+		cfg.refreshSitemap()
+	}
+	return nil
+}
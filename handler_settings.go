@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerUserSettingsGet(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	settings, err := cfg.db.GetUserSettings(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get settings", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, settings)
+}
+
+func (cfg *apiConfig) handlerUserSettingsUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Visibility    string `json:"visibility"`
+		PresetID      string `json:"preset_id"`
+		AutoThumbnail bool   `json:"auto_thumbnail"`
+		Watermark     bool   `json:"watermark"`
+	}
+
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+
+	var details []errorDetail
+	if !database.ValidVisibility(params.Visibility) {
+		details = append(details, errorDetail{Field: "visibility", Message: "must be public, unlisted or private"})
+	}
+	if params.PresetID != "" {
+		problem, err := cfg.checkUserPreset(userID, params.PresetID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check preset", err)
+			return
+		}
+		if problem != "" {
+			details = append(details, errorDetail{Field: "preset_id", Message: problem})
+		}
+	}
+	if params.Watermark && cfg.watermarkImage == "" {
+		details = append(details, errorDetail{Field: "watermark", Message: watermarkDisabledMessage})
+	}
+	if len(details) > 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid settings",
+			Details: details,
+		})
+		return
+	}
+
+	err := cfg.db.SetUserSettings(userID, database.UserSettings{
+		Visibility:    params.Visibility,
+		PresetID:      params.PresetID,
+		AutoThumbnail: params.AutoThumbnail,
+		Watermark:     params.Watermark,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update settings", err)
+		return
+	}
+
+	settings, err := cfg.db.GetUserSettings(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get settings", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, settings)
+}
+
+// watermarkDisabledMessage is the validation message for turning the
+// watermark on when no WATERMARK_IMAGE is configured.
+const watermarkDisabledMessage = "watermarking isn't enabled on this server"
+
+// checkUserPreset describes why the user can't process videos with the
+// preset, or returns "" when they can.
+func (cfg *apiConfig) checkUserPreset(userID uuid.UUID, presetID string) (string, error) {
+	preset, err := cfg.db.GetPreset(presetID)
+	if err != nil {
+		return "", err
+	}
+	if preset == nil {
+		return "doesn't match any preset", nil
+	}
+	plan, err := cfg.db.GetUserPlan(userID)
+	if err != nil {
+		return "", err
+	}
+	if len(preset.Renditions) > plan.MaxRenditions {
+		return fmt.Sprintf("has %d renditions but your plan allows %d", len(preset.Renditions), plan.MaxRenditions), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// statsTopUsers is how many of the users storing the most are listed.
+const statsTopUsers = 10
+
+type processingStats struct {
+	// Queued and Running are this instance's jobs only.
+	Queued  int `json:"queued"`
+	Running int `json:"running"`
+	// Succeeded and Failed count runs that finished in the last 24 hours,
+	// to the hour.
+	Succeeded   int64   `json:"succeeded_24h"`
+	Failed      int64   `json:"failed_24h"`
+	FailureRate float64 `json:"failure_rate_24h"`
+}
+
+type adminStats struct {
+	database.StorageStats
+	ByPrefix   []database.StorageTotal       `json:"by_prefix"`
+	Processing processingStats               `json:"processing"`
+	TopUsers   []database.UserUsageBreakdown `json:"top_users"`
+}
+
+// handlerAdminStats reports totals across the whole service. Everything
+// comes from counters kept as objects are written and deleted, not from
+// listing the bucket, so it's cheap enough to poll.
+func (cfg *apiConfig) handlerAdminStats(w http.ResponseWriter, r *http.Request) {
+	storage, err := cfg.db.GetStorageStats()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage totals", err)
+		return
+	}
+	byPrefix, err := cfg.db.GetStorageTotals()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage totals", err)
+		return
+	}
+	topUsers, err := cfg.db.GetTopUsage(statsTopUsers)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+
+	processing := processingStats{}
+	processing.Queued, processing.Running = cfg.processing.counts()
+	processing.Succeeded, processing.Failed, err = cfg.db.GetProcessingOutcomes(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get processing outcomes", err)
+		return
+	}
+	if finished := processing.Succeeded + processing.Failed; finished > 0 {
+		processing.FailureRate = float64(processing.Failed) / float64(finished)
+	}
+
+	respondWithJSON(w, http.StatusOK, adminStats{
+		StorageStats: storage,
+		ByPrefix:     byPrefix,
+		Processing:   processing,
+		TopUsers:     topUsers,
+	})
+}
+
+// purgeProcessingOutcomes deletes the processing runs and hourly counts
+// older than PROCESSING_OUTCOME_RETENTION.
+func (cfg *apiConfig) purgeProcessingOutcomes(ctx context.Context) error {
+	before := time.Now().Add(-envDuration("PROCESSING_OUTCOME_RETENTION", 30*24*time.Hour))
+	runs, err := cfg.db.DeleteProcessingRunsBefore(before)
+	if err != nil {
+		return fmt.Errorf("couldn't delete processing runs: %w", err)
+	}
+	hours, err := cfg.db.DeleteProcessingOutcomesBefore(before)
+	if err != nil {
+		return fmt.Errorf("couldn't delete processing outcomes: %w", err)
+	}
+	if runs > 0 || hours > 0 {
+		log.Printf("processing_outcome_purge: removed %d processing runs and %d hours of processing outcomes", runs, hours)
+	}
+	return nil
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/archive"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	archiveRoleVideo     = "video"
+	archiveRoleThumbnail = "thumbnail"
+	archiveRoleCaptions  = "captions"
+	archiveRoleMetadata  = "metadata"
+
+	archiveMetadataName = "metadata.json"
+)
+
+type archiveMemberResult struct {
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	Language string `json:"language,omitempty"`
+}
+
+// archiveFile is an extracted member that passed its media type check.
+type archiveFile struct {
+	member archive.Member
+	rule   mediaTypeRule
+}
+
+func (f archiveFile) header() *multipart.FileHeader {
+	return &multipart.FileHeader{
+		Filename: path.Base(f.member.Name),
+		Size:     f.member.Size,
+		Header:   textproto.MIMEHeader{"Content-Type": {f.rule.MediaType}},
+	}
+}
+
+type archiveCaptions struct {
+	member   archive.Member
+	language string
+	vtt      []byte
+	text     string
+}
+
+// archiveUpload is everything found in an archive, checked and ready to be
+// stored.
+type archiveUpload struct {
+	video     *archiveFile
+	thumbnail *archiveFile
+	captions  []archiveCaptions
+	metadata  *videoEdits
+	results   []archiveMemberResult
+}
+
+// handlerUploadArchive takes a zip, tar or gzipped tar holding a video and
+// optionally a thumbnail, WebVTT caption tracks named after their language
+// and a metadata.json with the title, description and visibility. Every
+// member is checked before anything is stored, so an archive with a bad
+// member changes nothing.
+func (cfg *apiConfig) handlerUploadArchive(w http.ResponseWriter, r *http.Request) {
+	video, userID, ok := cfg.authorizedVideoUser(w, r, permEdit)
+	if !ok {
+		return
+	}
+
+	plan, err := cfg.videoPlan(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, plan.MaxFileSize)
+	cfg.throttleUpload(r, video, plan)
+
+	framing, details := thumbnailFraming(r)
+	if len(details) > 0 {
+		respondInvalidFraming(w, details)
+		return
+	}
+
+	file, header, err := r.FormFile("archive")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithErrorCode(w, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, "Archive exceeds your plan's file size limit", err)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return
+	}
+	defer file.Close()
+
+	dir, err := os.MkdirTemp("", "tubely-archive-")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create extraction directory", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// Decompressed contents get the same budget as the upload itself, so
+	// a small archive can't unpack into something the plan wouldn't allow.
+	members, err := archive.Extract(file, header.Size, dir, archive.Limits{
+		MaxMembers:   envInt("ARCHIVE_MAX_MEMBERS", 32),
+		MaxTotalSize: plan.MaxFileSize,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, archive.ErrUnsupportedFormat):
+			respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidMediaType, "Only accepts zip, tar and gzipped tar archives", err)
+		case errors.Is(err, archive.ErrTooLarge):
+			respondWithErrorCode(w, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, "Archive contents exceed your plan's file size limit", err)
+		case errors.Is(err, archive.ErrTooManyMembers), errors.Is(err, archive.ErrUnsafeEntry):
+			respondWithErrorCode(w, http.StatusBadRequest, errCodeValidation, "Archive can't be extracted safely", err)
+		default:
+			respondWithError(w, http.StatusBadRequest, "Couldn't read archive", err)
+		}
+		return
+	}
+
+	upload, details := cfg.checkArchive(video, members)
+	if len(details) > 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid archive",
+			Details: details,
+		})
+		return
+	}
+
+	var newBytes, replacedBytes int64
+	newBytes += upload.video.member.Size
+	replacedBytes += video.VideoSize
+	if upload.thumbnail != nil {
+		newBytes += upload.thumbnail.member.Size
+		replacedBytes += video.ThumbnailSize
+	}
+	if err := cfg.checkVideoQuota(plan, video, replacedBytes, newBytes); err != nil {
+		respondWithAPIError(w, *quotaError(err))
+		return
+	}
+
+	var thumbnailFile *os.File
+	var verdict thumbnailVerdict
+	if upload.thumbnail != nil {
+		thumbnailFile, err = os.Open(upload.thumbnail.member.Path)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't open thumbnail", err)
+			return
+		}
+		defer thumbnailFile.Close()
+
+		var apiErr *apiError
+		verdict, apiErr = cfg.screenThumbnail(r.Context(), video, upload.thumbnail.rule, thumbnailFile, upload.thumbnail.member.Size)
+		if apiErr != nil {
+			respondWithAPIError(w, *apiErr)
+			return
+		}
+	}
+
+	// Only the pipeline can still turn the video down, and it replaces the
+	// video's file as its last step. The thumbnail and caption tracks are
+	// staged before it runs and attached after it succeeds, so a rejected
+	// video leaves the old ones in place and a failed stage leaves the old
+	// video. Whatever is still staged on the way out is thrown away.
+	var thumbnail *stagedThumbnail
+	stagedCaptions := make([]string, len(upload.captions))
+	defer func() {
+		if thumbnail != nil {
+			cfg.discardThumbnail(*thumbnail)
+		}
+		for _, key := range stagedCaptions {
+			if key != "" {
+				cfg.discardStagedUpload(r.Context(), key)
+			}
+		}
+	}()
+
+	if upload.thumbnail != nil {
+		fileName, size, apiErr := cfg.writeThumbnailAsset(upload.thumbnail.rule, thumbnailFile)
+		if apiErr != nil {
+			respondWithAPIError(w, *apiErr)
+			return
+		}
+		staged, err := cfg.stageThumbnail(r.Context(), cfg.getAssetURL(fileName), size, framing)
+		if err != nil {
+			os.Remove(cfg.getAssetDiskPath(fileName))
+			respondWithError(w, http.StatusInternalServerError, "Couldn't set thumbnail", err)
+			return
+		}
+		thumbnail = &staged
+	}
+	for i, track := range upload.captions {
+		key, err := cfg.stageCaptionTrack(r.Context(), video.ID, track.language, bytes.NewReader(track.vtt))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save captions", err)
+			return
+		}
+		stagedCaptions[i] = key
+	}
+
+	videoFile, err := os.Open(upload.video.member.Path)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open video", err)
+		return
+	}
+	defer videoFile.Close()
+	video, apiErr := cfg.storeVideoUpload(r.Context(), plan, video, userID, videoFile, upload.video.header())
+	if apiErr != nil {
+		respondWithAPIError(w, *apiErr)
+		return
+	}
+
+	if thumbnail != nil {
+		staged := *thumbnail
+		thumbnail = nil
+		video, err = cfg.attachStagedThumbnail(video, staged)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't set thumbnail", err)
+			return
+		}
+		cfg.flagThumbnail(video, verdict)
+	}
+	for i, track := range upload.captions {
+		stagedKey := stagedCaptions[i]
+		stagedCaptions[i] = ""
+		if err := cfg.commitCaptionTrack(r.Context(), video.ID, track.language, captionSourceUpload, stagedKey, track.text); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save captions", err)
+			return
+		}
+	}
+
+	if upload.metadata != nil {
+		changes, _ := upload.metadata.apply(&video)
+		if len(changes) > 0 {
+			if err := cfg.db.UpdateVideo(video); err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+				return
+			}
+			cfg.audit(auditUser(userID), "video.update", auditSubjectVideo, video.ID.String(), changes)
+		}
+	}
+
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, struct {
+		Video   database.Video        `json:"video"`
+		Members []archiveMemberResult `json:"members"`
+	}{
+		Video:   video,
+		Members: upload.results,
+	})
+}
+
+// checkArchive sorts the extracted members by what they're for and
+// validates each one, reporting problems against the member's name.
+func (cfg *apiConfig) checkArchive(video database.Video, members []archive.Member) (archiveUpload, []errorDetail) {
+	var upload archiveUpload
+	var details []errorDetail
+	languages := map[string]bool{}
+
+	for _, member := range members {
+		base := path.Base(member.Name)
+		if archiveJunk(member.Name) {
+			continue
+		}
+		ext := strings.ToLower(path.Ext(base))
+
+		switch {
+		case strings.EqualFold(base, archiveMetadataName):
+			if upload.metadata != nil {
+				details = append(details, errorDetail{Field: member.Name, Message: "only one metadata.json is allowed"})
+				continue
+			}
+			edits, memberDetails := checkArchiveMetadata(video, member)
+			for _, d := range memberDetails {
+				details = append(details, errorDetail{Field: member.Name, Message: d})
+			}
+			upload.metadata = &edits
+			upload.results = append(upload.results, archiveMemberResult{Name: member.Name, Role: archiveRoleMetadata})
+
+		case ext == ".vtt":
+			language := captionLanguage(base)
+			if language == "" {
+				details = append(details, errorDetail{Field: member.Name, Message: "caption files must be named after their language, like en.vtt"})
+				continue
+			}
+			if languages[language] {
+				details = append(details, errorDetail{Field: member.Name, Message: fmt.Sprintf("more than one %s caption track", language)})
+				continue
+			}
+			languages[language] = true
+			if member.Size > maxCaptionSize {
+				details = append(details, errorDetail{Field: member.Name, Message: fmt.Sprintf("caption files are limited to %d bytes", maxCaptionSize)})
+				continue
+			}
+			data, err := os.ReadFile(member.Path)
+			if err != nil {
+				details = append(details, errorDetail{Field: member.Name, Message: "couldn't be read"})
+				continue
+			}
+			text, ok := webVTTText(data)
+			if !ok {
+				details = append(details, errorDetail{Field: member.Name, Message: "isn't valid text/vtt"})
+				continue
+			}
+			upload.captions = append(upload.captions, archiveCaptions{member: member, language: language, vtt: data, text: text})
+			upload.results = append(upload.results, archiveMemberResult{Name: member.Name, Role: archiveRoleCaptions, Language: language})
+
+		default:
+			kind, rule, ok := cfg.mediaTypes.byExtension(ext)
+			if !ok {
+				details = append(details, errorDetail{Field: member.Name, Message: "isn't a video, thumbnail, caption track or metadata.json"})
+				continue
+			}
+			slot, role := &upload.video, archiveRoleVideo
+			if kind == mediaKindThumbnail {
+				slot, role = &upload.thumbnail, archiveRoleThumbnail
+			}
+			if *slot != nil {
+				details = append(details, errorDetail{Field: member.Name, Message: fmt.Sprintf("only one %s is allowed", role)})
+				continue
+			}
+			f := archiveFile{member: member, rule: rule}
+			if apiErr := cfg.checkArchiveMedia(kind, f); apiErr != nil {
+				details = append(details, errorDetail{Field: member.Name, Message: apiErr.Message})
+				continue
+			}
+			*slot = &f
+			upload.results = append(upload.results, archiveMemberResult{Name: member.Name, Role: role})
+		}
+	}
+
+	if upload.video == nil && len(details) == 0 {
+		details = append(details, errorDetail{Field: "archive", Message: "must contain a video"})
+	}
+	return upload, details
+}
+
+func (cfg *apiConfig) checkArchiveMedia(kind mediaKind, f archiveFile) *apiError {
+	file, err := os.Open(f.member.Path)
+	if err != nil {
+		return &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "couldn't be read", Err: err}
+	}
+	defer file.Close()
+	_, apiErr := cfg.checkMediaUpload(kind, file, f.header())
+	return apiErr
+}
+
+// checkArchiveMetadata decodes a metadata.json and validates it against a
+// copy of the video, so nothing is changed yet.
+func checkArchiveMetadata(video database.Video, member archive.Member) (videoEdits, []string) {
+	var edits videoEdits
+	data, err := os.ReadFile(member.Path)
+	if err != nil {
+		return edits, []string{"couldn't be read"}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&edits); err != nil {
+		return edits, []string{fmt.Sprintf("isn't valid: %v", err)}
+	}
+	var messages []string
+	_, details := edits.apply(&video)
+	for _, d := range details {
+		messages = append(messages, fmt.Sprintf("%s %s", d.Field, d.Message))
+	}
+	return edits, messages
+}
+
+// archiveJunk reports whether a member is one of the files archivers and
+// desktops add on their own, which are skipped rather than rejected.
+func archiveJunk(name string) bool {
+	base := path.Base(name)
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(base, "._") || base == ".DS_Store" || base == "Thumbs.db"
+}
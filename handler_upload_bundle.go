@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	bundlePartVideo     = "video"
+	bundlePartThumbnail = "thumbnail"
+	bundlePartCaptions  = "captions"
+
+	// captionSourceUpload marks tracks the owner supplied themselves.
+	captionSourceUpload = "upload"
+	maxCaptionSize      = 2 << 20
+)
+
+var (
+	captionLanguagePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+	webVTTTagPattern       = regexp.MustCompile(`<[^>]*>`)
+)
+
+type bundlePartResult struct {
+	Part     string `json:"part"`
+	FileName string `json:"file_name"`
+	Language string `json:"language,omitempty"`
+	Status   int    `json:"status"`
+	Code     string `json:"code,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// handlerUploadBundle takes a video, a thumbnail and any number of caption
+// tracks in one multipart request. Each part goes through the same pipeline
+// as its single-file endpoint and a failed part doesn't stop the others, so
+// the response reports on every part and is a 207 if any of them failed.
+func (cfg *apiConfig) handlerUploadBundle(w http.ResponseWriter, r *http.Request) {
+	video, userID, ok := cfg.authorizedVideoUser(w, r, permEdit)
+	if !ok {
+		return
+	}
+
+	plan, err := cfg.videoPlan(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, plan.MaxFileSize)
+	cfg.throttleUpload(r, video, plan)
+
+	framing, details := thumbnailFraming(r)
+	if len(details) > 0 {
+		respondInvalidFraming(w, details)
+		return
+	}
+
+	const maxMemory = 10 << 20
+	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithErrorCode(w, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, "Upload exceeds your plan's file size limit", err)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Unable to parse multipart form", err)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	files := r.MultipartForm.File
+	if len(files) == 0 {
+		respondWithError(w, http.StatusBadRequest, "No files in request", nil)
+		return
+	}
+
+	var results []bundlePartResult
+	record := func(part string, header *multipart.FileHeader, language string, apiErr *apiError) {
+		result := bundlePartResult{Part: part, FileName: header.Filename, Language: language, Status: http.StatusOK}
+		if apiErr != nil {
+			if apiErr.Err != nil {
+				log.Printf("[%s] bundle %s part %q: %v", w.Header().Get(requestIDHeader), part, header.Filename, apiErr.Err)
+			}
+			result.Status = apiErr.Status
+			result.Code = apiErr.Code
+			result.Error = apiErr.Message
+		}
+		results = append(results, result)
+	}
+
+	// The video goes first so an explicit thumbnail replaces whatever was
+	// picked from its frames.
+	for i, header := range files[bundlePartVideo] {
+		if i > 0 {
+			record(bundlePartVideo, header, "", &apiError{Status: http.StatusBadRequest, Code: errCodeBadRequest, Message: "Only one video per request"})
+			continue
+		}
+		record(bundlePartVideo, header, "", withPartFile(header, func(file multipart.File) *apiError {
+			updated, apiErr := cfg.storeVideoUpload(r.Context(), plan, video, userID, file, header)
+			if apiErr == nil {
+				video = updated
+			}
+			return apiErr
+		}))
+	}
+
+	for i, header := range files[bundlePartThumbnail] {
+		if i > 0 {
+			record(bundlePartThumbnail, header, "", &apiError{Status: http.StatusBadRequest, Code: errCodeBadRequest, Message: "Only one thumbnail per request"})
+			continue
+		}
+		record(bundlePartThumbnail, header, "", withPartFile(header, func(file multipart.File) *apiError {
+			rule, apiErr := cfg.checkMediaUpload(mediaKindThumbnail, file, header)
+			if apiErr != nil {
+				return apiErr
+			}
+			updated, apiErr := cfg.storeThumbnailUpload(r, plan, video, rule, file, header, framing)
+			if apiErr == nil {
+				video = updated
+			}
+			return apiErr
+		}))
+	}
+
+	for _, header := range files[bundlePartCaptions] {
+		language := captionLanguage(header.Filename)
+		if language == "" {
+			record(bundlePartCaptions, header, "", &apiError{Status: http.StatusBadRequest, Code: errCodeValidation, Message: "Caption files must be named after their language, like en.vtt"})
+			continue
+		}
+		record(bundlePartCaptions, header, language, withPartFile(header, func(file multipart.File) *apiError {
+			return cfg.storeCaptionUpload(r.Context(), video, language, file, header)
+		}))
+	}
+
+	unknown := []string{}
+	for part := range files {
+		if part != bundlePartVideo && part != bundlePartThumbnail && part != bundlePartCaptions {
+			unknown = append(unknown, part)
+		}
+	}
+	sort.Strings(unknown)
+	for _, part := range unknown {
+		for _, header := range files[part] {
+			record(part, header, "", &apiError{Status: http.StatusBadRequest, Code: errCodeBadRequest, Message: fmt.Sprintf("Unknown part %q", part)})
+		}
+	}
+
+	// Parts may have changed the row in ways the copy here doesn't show,
+	// such as captions or an auto-picked thumbnail.
+	latest, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+
+	status := http.StatusOK
+	for _, result := range results {
+		if result.Status != http.StatusOK {
+			status = http.StatusMultiStatus
+			break
+		}
+	}
+	respondWithJSON(w, status, struct {
+		Video database.Video     `json:"video"`
+		Parts []bundlePartResult `json:"parts"`
+	}{
+		Video: latest,
+		Parts: results,
+	})
+}
+
+// withPartFile opens an uploaded part for fn and closes it afterwards.
+func withPartFile(header *multipart.FileHeader, fn func(multipart.File) *apiError) *apiError {
+	file, err := header.Open()
+	if err != nil {
+		return &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't read uploaded file", Err: err}
+	}
+	defer file.Close()
+	return fn(file)
+}
+
+// captionLanguage takes the language of a caption track from its file
+// name, so en.vtt and pt-BR.vtt become en and pt-br. It returns "" if the
+// name isn't a language tag.
+func captionLanguage(fileName string) string {
+	base := filepath.Base(fileName)
+	language := strings.ToLower(strings.TrimSuffix(base, filepath.Ext(base)))
+	if !captionLanguagePattern.MatchString(language) {
+		return ""
+	}
+	return language
+}
+
+// storeCaptionUpload checks an uploaded WebVTT track and saves it in place
+// of any track the video has in the same language.
+func (cfg *apiConfig) storeCaptionUpload(ctx context.Context, video database.Video, language string, file multipart.File, header *multipart.FileHeader) *apiError {
+	if header.Size > maxCaptionSize {
+		return &apiError{Status: http.StatusRequestEntityTooLarge, Code: errCodePayloadTooLarge, Message: fmt.Sprintf("Caption files are limited to %d bytes", maxCaptionSize)}
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxCaptionSize))
+	if err != nil {
+		return &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't read uploaded file", Err: err}
+	}
+	text, ok := webVTTText(data)
+	if !ok {
+		return &apiError{Status: http.StatusBadRequest, Code: errCodeInvalidMediaType, Message: "File content isn't valid text/vtt"}
+	}
+
+	if err := cfg.saveCaptionTrack(ctx, video.ID, language, captionSourceUpload, bytes.NewReader(data), text); err != nil {
+		return &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't save captions", Err: err}
+	}
+	return nil
+}
+
+// webVTTText returns the cue text of a WebVTT file, without timings or
+// markup, for the search index. It reports false if data doesn't start
+// with the WEBVTT signature.
+func webVTTText(data []byte) (string, bool) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !bytes.HasPrefix(data, []byte("WEBVTT")) {
+		return "", false
+	}
+	if len(data) > 6 && data[6] != ' ' && data[6] != '\t' && data[6] != '\n' && data[6] != '\r' {
+		return "", false
+	}
+
+	var lines []string
+	inCue := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			inCue = false
+		case strings.Contains(line, "-->"):
+			inCue = true
+		case inCue:
+			if text := strings.TrimSpace(webVTTTagPattern.ReplaceAllString(line, "")); text != "" {
+				lines = append(lines, text)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", false
+	}
+	return strings.Join(lines, " "), true
+}
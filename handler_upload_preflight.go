@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// preflightSessionSize is the declared size from which the preflight
+// steers clients to a resumable session instead of a single form POST.
+const preflightSessionSize = 100 << 20
+
+type uploadInstructions struct {
+	Method     string `json:"method"`
+	HTTPMethod string `json:"http_method"`
+	URL        string `json:"url"`
+	// Field is the multipart form field the file goes in.
+	Field       string `json:"field,omitempty"`
+	MinPartSize int64  `json:"min_part_size,omitempty"`
+}
+
+type uploadPreflightResponse struct {
+	Approved        bool                 `json:"approved"`
+	VideoID         uuid.UUID            `json:"video_id"`
+	MediaType       string               `json:"media_type"`
+	Size            int64                `json:"size"`
+	DurationSeconds float64              `json:"duration_seconds,omitempty"`
+	MaxSize         int64                `json:"max_size"`
+	Recommended     string               `json:"recommended"`
+	Methods         []uploadInstructions `json:"methods"`
+}
+
+// handlerUploadPreflight checks an upload the client is about to make
+// against the media type rules, the plan's file size limit and the
+// storage quota, without any of the file being sent. It answers with the
+// ways the file can be uploaded, or with the error the upload itself
+// would get.
+func (cfg *apiConfig) handlerUploadPreflight(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ContentType     string  `json:"content_type"`
+		Size            int64   `json:"size"`
+		DurationSeconds float64 `json:"duration_seconds"`
+	}
+
+	video, ok := cfg.authorizedVideo(w, r, permEdit)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+	var details []errorDetail
+	if params.Size <= 0 {
+		details = append(details, errorDetail{Field: "size", Message: "must be greater than zero"})
+	}
+	if params.DurationSeconds < 0 {
+		details = append(details, errorDetail{Field: "duration_seconds", Message: "can't be negative"})
+	}
+	if len(details) > 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid upload preflight",
+			Details: details,
+		})
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(params.ContentType)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidMediaType, "Couldn't parse media type", err)
+		return
+	}
+	rule, ok := cfg.mediaTypes.lookup(mediaKindVideo, mediaType)
+	if !ok {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeInvalidMediaType,
+			Message: fmt.Sprintf("Only accepts %s", cfg.mediaTypes.allowed(mediaKindVideo)),
+			Details: []errorDetail{{Field: "content_type", Message: "isn't an accepted video type"}},
+		})
+		return
+	}
+
+	plan, err := cfg.videoPlan(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	maxSize := plan.MaxFileSize
+	if rule.MaxSize > 0 {
+		maxSize = min(maxSize, rule.MaxSize)
+	}
+	if params.Size > maxSize {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusRequestEntityTooLarge,
+			Code:    errCodeVideoTooLarge,
+			Message: "Video exceeds your plan's file size limit",
+			Details: []errorDetail{{Field: "size", Message: fmt.Sprintf("must be at most %d bytes", maxSize)}},
+		})
+		return
+	}
+	// Only the duration is known before upload; resolution is checked once
+	// the file is probed.
+	if details := mediaLimitDetails(plan, params.DurationSeconds, 0, 0); len(details) > 0 {
+		limit := &mediaLimitError{planID: plan.ID, details: details}
+		respondWithAPIError(w, *limit.apiError())
+		return
+	}
+	if err := cfg.checkVideoQuota(plan, video, video.VideoSize, params.Size); err != nil {
+		apiErr := quotaError(err)
+		if errors.Is(err, errStorageQuotaExceeded) {
+			apiErr.Details = []errorDetail{{Field: "size", Message: "doesn't fit in the remaining storage"}}
+		}
+		respondWithAPIError(w, *apiErr)
+		return
+	}
+
+	resp := uploadPreflightResponse{
+		Approved:        true,
+		VideoID:         video.ID,
+		MediaType:       mediaType,
+		Size:            params.Size,
+		DurationSeconds: params.DurationSeconds,
+		MaxSize:         maxSize,
+		Methods:         cfg.uploadMethods(r, video.ID),
+	}
+	resp.Recommended = uploadMethodForm
+	if params.Size >= preflightSessionSize {
+		resp.Recommended = uploadMethodSession
+	}
+	if _, ok := cfg.store.(*storage.S3Store); ok {
+		resp.Recommended = uploadMethodDirect
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// uploadMethods lists the endpoints a video can be uploaded through. Direct
+// uploads need a store that can presign them. The URLs are under the API
+// version the request was made with.
+func (cfg *apiConfig) uploadMethods(r *http.Request, videoID uuid.UUID) []uploadInstructions {
+	base := apiPath(r, "/video_upload/"+videoID.String())
+	methods := []uploadInstructions{
+		{Method: uploadMethodForm, HTTPMethod: http.MethodPost, URL: base, Field: "video"},
+		{Method: uploadMethodSession, HTTPMethod: http.MethodPost, URL: base + "/sessions", MinPartSize: storage.MinPartSize},
+	}
+	if _, ok := cfg.store.(*storage.S3Store); ok {
+		methods = append(methods, uploadInstructions{Method: uploadMethodDirect, HTTPMethod: http.MethodPost, URL: base + "/presign"})
+	}
+	return methods
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/awschunked"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// uploadOffsetHeader carries the byte offset of a chunk on the way in and
+// the session's committed offset on the way out.
+const uploadOffsetHeader = "Upload-Offset"
+
+// checksumSHA256Header is where AWS SDKs put a part's base64 SHA-256,
+// either as a plain header or as an aws-chunked trailer.
+const checksumSHA256Header = "X-Amz-Checksum-Sha256"
+
+// maxUploadParts is S3's limit on parts per multipart upload.
+const maxUploadParts = 10000
+
+type uploadSessionResponse struct {
+	database.UploadSession
+	MinPartSize int64           `json:"min_part_size"`
+	Completed   bool            `json:"completed"`
+	Video       *database.Video `json:"video,omitempty"`
+}
+
+func newUploadSessionResponse(session database.UploadSession) uploadSessionResponse {
+	return uploadSessionResponse{
+		UploadSession: session,
+		MinPartSize:   storage.MinPartSize,
+	}
+}
+
+// handlerUploadSessionCreate starts a resumable upload. The session, its
+// multipart upload ID and every committed part live in the database, so
+// any instance can accept the next chunk even after a restart.
+func (cfg *apiConfig) handlerUploadSessionCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ContentType string `json:"content_type"`
+		Size        int64  `json:"size"`
+		// FileName is the name of the file on the client, kept with the
+		// object.
+		FileName string `json:"file_name"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Size <= 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid upload session",
+			Details: []errorDetail{{Field: "size", Message: "must be greater than zero"}},
+		})
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(params.ContentType)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidMediaType, "Couldn't parse media type", err)
+		return
+	}
+	rule, ok := cfg.mediaTypes.lookup(mediaKindVideo, mediaType)
+	if !ok {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidMediaType, fmt.Sprintf("Only accepts %s", cfg.mediaTypes.allowed(mediaKindVideo)), nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithErrorCode(w, http.StatusNotFound, errCodeVideoNotFound, "Video not found", nil)
+		return
+	}
+	if !cfg.checkVideoAccess(w, video, userID, permEdit, "You can't upload to this video") {
+		return
+	}
+
+	plan, err := cfg.videoPlan(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	maxSize := plan.MaxFileSize
+	if rule.MaxSize > 0 {
+		maxSize = min(maxSize, rule.MaxSize)
+	}
+	if params.Size > maxSize {
+		respondWithErrorCode(w, http.StatusRequestEntityTooLarge, errCodeVideoTooLarge, "Video exceeds your plan's file size limit", nil)
+		return
+	}
+	if err := cfg.checkVideoQuota(plan, video, video.VideoSize, params.Size); err != nil {
+		if errors.Is(err, errStorageQuotaExceeded) {
+			respondWithErrorCode(w, http.StatusForbidden, errCodeQuotaExceeded, "Storage quota exceeded", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check storage quota", err)
+		return
+	}
+
+	key := cfg.keys.objectKey(objectKeyParams{
+		kind:    "resumable",
+		userID:  userID,
+		videoID: video.ID,
+		ext:     rule.Extension,
+		now:     time.Now(),
+	})
+	putOptions := cfg.videoPutOptions(video, mediaType)
+	putOptions.Metadata = videoObjectMetadata(video.ID, userID, params.FileName, time.Now())
+	uploadID, err := cfg.store.CreateMultipart(r.Context(), key, putOptions)
+	if err != nil {
+		cfg.reportStorageError("create_multipart", key, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start upload", err)
+		return
+	}
+
+	session, err := cfg.db.CreateUploadSession(database.CreateUploadSessionParams{
+		VideoID:     video.ID,
+		UserID:      userID,
+		ObjectKey:   key,
+		UploadID:    uploadID,
+		ContentType: mediaType,
+		TotalSize:   params.Size,
+		ExpiresAt:   time.Now().UTC().Add(cfg.uploadSessionTTL),
+	})
+	if err != nil {
+		cfg.store.AbortMultipart(r.Context(), key, uploadID)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload session", err)
+		return
+	}
+
+	cfg.events.Publish(eventUploadStarted, uploadEvent{
+		VideoID: video.ID,
+		UserID:  video.UserID,
+		Method:  uploadMethodSession,
+		Size:    session.TotalSize,
+	})
+	w.Header().Set(uploadOffsetHeader, "0")
+	respondWithJSON(w, http.StatusCreated, newUploadSessionResponse(session))
+}
+
+func (cfg *apiConfig) handlerUploadSessionGet(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.authorizedUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(session.BytesReceived, 10))
+	respondWithJSON(w, http.StatusOK, newUploadSessionResponse(session))
+}
+
+// handlerUploadSessionPut accepts the chunk starting at the Upload-Offset
+// header and streams it to the store as the next part. Every chunk but the
+// last must be at least storage.MinPartSize. The chunk that reaches the
+// declared size completes the upload and attaches it to the video.
+func (cfg *apiConfig) handlerUploadSessionPut(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.authorizedUploadSession(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(session.BytesReceived, 10))
+
+	offset, err := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeBadRequest, "Missing or invalid Upload-Offset header", err)
+		return
+	}
+	if offset != session.BytesReceived {
+		respondWithErrorCode(w, http.StatusConflict, errCodeUploadOffsetMismatch, fmt.Sprintf("Upload is at offset %d", session.BytesReceived), nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(session.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		cfg.abortUploadSession(r, session)
+		respondWithErrorCode(w, http.StatusNotFound, errCodeVideoNotFound, "Video not found", nil)
+		return
+	}
+	plan, err := cfg.videoPlan(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	cfg.throttleUpload(r, video, plan)
+
+	var src io.Reader = r.Body
+	size := r.ContentLength
+	var chunked *awschunked.Reader
+	if awschunked.IsChunked(r) {
+		size, err = awschunked.DecodedLength(r)
+		if err != nil {
+			respondWithErrorCode(w, http.StatusBadRequest, errCodeBadRequest, "Invalid aws-chunked body", err)
+			return
+		}
+		chunked = awschunked.NewReader(r.Body)
+		src = chunked
+	}
+	if size <= 0 {
+		respondWithError(w, http.StatusLengthRequired, "Chunks need a Content-Length", nil)
+		return
+	}
+	end := offset + size
+	if end > session.TotalSize {
+		respondWithErrorCode(w, http.StatusRequestEntityTooLarge, errCodeVideoTooLarge, "Chunk runs past the declared upload size", nil)
+		return
+	}
+	if end < session.TotalSize && size < storage.MinPartSize {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Chunks other than the last must be at least %d bytes", storage.MinPartSize), nil)
+		return
+	}
+	partNumber := int32(len(session.Parts) + 1)
+	if partNumber > maxUploadParts || (end < session.TotalSize && partNumber == maxUploadParts) {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeBadRequest, "Too many chunks, send larger ones", nil)
+		return
+	}
+
+	body := src
+	if offset == 0 {
+		rule, ok := cfg.mediaTypes.lookup(mediaKindVideo, session.ContentType)
+		if !ok {
+			respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidMediaType, fmt.Sprintf("Only accepts %s", cfg.mediaTypes.allowed(mediaKindVideo)), nil)
+			return
+		}
+		head := make([]byte, 512)
+		n, err := io.ReadFull(src, head)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			respondWithError(w, http.StatusBadRequest, "Couldn't read chunk", err)
+			return
+		}
+		head = head[:n]
+		if rule.Sniff != nil && !rule.Sniff(head) {
+			respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidMediaType, fmt.Sprintf("File content isn't valid %s", session.ContentType), nil)
+			return
+		}
+		body = io.MultiReader(bytes.NewReader(head), src)
+	}
+
+	stored, err := cfg.store.UploadPart(r.Context(), session.ObjectKey, session.UploadID, partNumber, body, size, r.Header.Get("Content-MD5"))
+	if errors.Is(err, storage.ErrBadDigest) {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeChecksumMismatch, "Chunk doesn't match its Content-MD5", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store chunk", err)
+		return
+	}
+
+	// The store hashed the part as it streamed through, so it only has to
+	// agree with what the client hashed on its side. A mismatched part is
+	// left unrecorded and gets overwritten when the chunk is retried.
+	expected := r.Header.Get(checksumSHA256Header)
+	if chunked != nil {
+		if _, err := io.Copy(io.Discard, chunked); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't read chunk trailer", err)
+			return
+		}
+		if v := chunked.Trailer.Get(checksumSHA256Header); v != "" {
+			expected = v
+		}
+	}
+	if expected != "" && expected != stored.ChecksumSHA256 {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeChecksumMismatch, "Chunk doesn't match its SHA-256 checksum", nil)
+		return
+	}
+
+	part := database.UploadSessionPart{
+		PartNumber:     partNumber,
+		ETag:           stored.ETag,
+		Size:           size,
+		ChecksumSHA256: stored.ChecksumSHA256,
+	}
+	err = cfg.db.AddUploadSessionPart(session.ID, offset, part)
+	if errors.Is(err, database.ErrUploadOffsetMismatch) {
+		respondWithErrorCode(w, http.StatusConflict, errCodeUploadOffsetMismatch, "Another chunk was committed at this offset", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record chunk", err)
+		return
+	}
+	session.BytesReceived = end
+	session.Parts = append(session.Parts, part)
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(end, 10))
+
+	if end < session.TotalSize {
+		respondWithJSON(w, http.StatusOK, newUploadSessionResponse(session))
+		return
+	}
+
+	video, apiErr := cfg.completeUploadSession(r, session)
+	if apiErr != nil {
+		respondWithAPIError(w, *apiErr)
+		return
+	}
+
+	resp := newUploadSessionResponse(session)
+	resp.Completed = true
+	resp.Video = &video
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// completeUploadSession assembles the object, re-checks the quota since
+// usage may have moved while the upload was in flight, and runs the
+// assembled object through the processing pipeline like any other staged
+// upload: it's only attached once it passed validation and processing.
+func (cfg *apiConfig) completeUploadSession(r *http.Request, session database.UploadSession) (database.Video, *apiError) {
+	internal := func(err error) *apiError {
+		return &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't complete upload", Err: err}
+	}
+	ctx, unlock, err := cfg.lockVideo(r.Context(), session.VideoID)
+	if err != nil {
+		return database.Video{}, internal(err)
+	}
+	defer unlock()
+	video, err := cfg.db.GetVideo(session.VideoID)
+	if err != nil {
+		return database.Video{}, internal(err)
+	}
+	if video.ID == uuid.Nil {
+		cfg.abortUploadSession(r, session)
+		return database.Video{}, internal(errors.New("video was deleted during the upload"))
+	}
+
+	plan, err := cfg.videoPlan(video)
+	if err != nil {
+		return video, internal(err)
+	}
+	if err := cfg.checkVideoQuota(plan, video, video.VideoSize, session.TotalSize); err != nil {
+		if errors.Is(err, errStorageQuotaExceeded) {
+			cfg.abortUploadSession(r, session)
+		}
+		return video, quotaError(err)
+	}
+
+	parts := make([]storage.CompletedPart, 0, len(session.Parts))
+	for _, p := range session.Parts {
+		parts = append(parts, storage.CompletedPart{
+			PartNumber:     p.PartNumber,
+			ETag:           p.ETag,
+			ChecksumSHA256: p.ChecksumSHA256,
+		})
+	}
+	info, err := cfg.store.CompleteMultipart(ctx, session.ObjectKey, session.UploadID, parts)
+	if err != nil {
+		cfg.reportStorageError("complete_multipart", session.ObjectKey, err)
+		return video, internal(err)
+	}
+	if err := cfg.db.DeleteUploadSession(session.ID); err != nil {
+		log.Printf("Couldn't delete completed upload session %s: %v", session.ID, err)
+	}
+
+	// The assembled object is the raw upload; the pipeline stores its own
+	// output and deletes it afterwards, whether processing succeeded or not.
+	job := cfg.newProcessingJob(video)
+	job.uploaderID = session.UserID
+	job.sourceKey = session.ObjectKey
+	job.staged = true
+	job.size = info.Size
+	video, err = cfg.storeVideo(ctx, job, video, "")
+	job.finish(err)
+	return cfg.uploadProcessed(video, uploadEvent{
+		VideoID: video.ID,
+		UserID:  video.UserID,
+		Method:  uploadMethodSession,
+	}, err)
+}
+
+func (cfg *apiConfig) handlerUploadSessionDelete(w http.ResponseWriter, r *http.Request) {
+	session, ok := cfg.authorizedUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.abortUploadSession(r, session); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't cancel upload", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) abortUploadSession(r *http.Request, session database.UploadSession) error {
+	if err := cfg.store.AbortMultipart(r.Context(), session.ObjectKey, session.UploadID); err != nil {
+		return err
+	}
+	return cfg.db.DeleteUploadSession(session.ID)
+}
+
+// authorizedUploadSession loads the session named in the path and checks
+// it belongs to the caller and hasn't expired, responding if not.
+func (cfg *apiConfig) authorizedUploadSession(w http.ResponseWriter, r *http.Request) (database.UploadSession, bool) {
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid ID", err)
+		return database.UploadSession{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.UploadSession{}, false
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.UploadSession{}, false
+	}
+
+	session, err := cfg.db.GetUploadSession(sessionID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload session", err)
+		return database.UploadSession{}, false
+	}
+	if session.ID == uuid.Nil {
+		respondWithErrorCode(w, http.StatusNotFound, errCodeUploadSessionNotFound, "Upload session not found", nil)
+		return database.UploadSession{}, false
+	}
+	if session.UserID != userID {
+		respondWithErrorCode(w, http.StatusForbidden, errCodeNotOwner, "You can't access this upload session", nil)
+		return database.UploadSession{}, false
+	}
+	if time.Now().After(session.ExpiresAt) {
+		respondWithErrorCode(w, http.StatusGone, errCodeUploadSessionExpired, "Upload session expired", nil)
+		return database.UploadSession{}, false
+	}
+	return session, true
+}
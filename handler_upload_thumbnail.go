@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"errors"
 	"io"
-	"mime"
+	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
 	"github.com/google/uuid"
 )
 
@@ -16,7 +20,7 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid ID", err)
 		return
 	}
 
@@ -26,71 +30,152 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := cfg.validateJWT(token)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
 
-	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
+	videoDb, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithErrorCode(w, http.StatusNotFound, errCodeVideoNotFound, "Video not found", nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+
+	allowed, err := cfg.authorizeVideo(videoDb, userID, permEdit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check access", err)
+		return
+	}
+	if !allowed {
+		respondWithErrorCode(w, http.StatusUnauthorized, errCodeNotOwner, "Unauthorized", nil)
+		return
+	}
+
+	plan, err := cfg.videoPlan(videoDb)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, plan.MaxFileSize)
+	cfg.throttleUpload(r, videoDb, plan)
 
 	const maxMemory = 10 << 20
 	r.ParseMultipartForm(maxMemory)
 
 	file, header, err := r.FormFile("thumbnail")
 	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithErrorCode(w, http.StatusRequestEntityTooLarge, errCodeThumbnailTooLarge, "Thumbnail exceeds your plan's file size limit", err)
+			return
+		}
 		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
 		return
 	}
 	defer file.Close()
 
-	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Media type can't be parsed", err)
+	rule, ok := cfg.validateMediaUpload(w, mediaKindThumbnail, file, header)
+	if !ok {
 		return
 	}
-	if mediaType != "image/jpeg" && mediaType != "image/png" {
-		respondWithError(w, http.StatusBadRequest, "Only accepts image/png or image/jpeg", nil)
+	framing, details := thumbnailFraming(r)
+	if len(details) > 0 {
+		respondInvalidFraming(w, details)
 		return
 	}
 
-	videoDb, err := cfg.db.GetVideo(videoID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			respondWithError(w, http.StatusNotFound, "Video not found", nil)
-			return
-		}
-		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+	videoDb, apiErr := cfg.storeThumbnailUpload(r, plan, videoDb, rule, file, header, framing)
+	if apiErr != nil {
+		respondWithAPIError(w, *apiErr)
 		return
 	}
 
-	if videoDb.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized", nil)
-		return
+	respondWithJSON(w, http.StatusOK, videoDb)
+}
+
+// storeThumbnailUpload checks a validated thumbnail against the owner's quota
+// and the content policy, saves it as an asset and points the video at it.
+func (cfg *apiConfig) storeThumbnailUpload(r *http.Request, plan *database.Plan, video database.Video, rule mediaTypeRule, file multipart.File, header *multipart.FileHeader, framing media.Framing) (database.Video, *apiError) {
+	if err := cfg.checkVideoQuota(plan, video, video.ThumbnailSize, header.Size); err != nil {
+		return video, quotaError(err)
 	}
 
-	fileName := getAssetPath(mediaType)
-	assetDiskPath := cfg.getAssetDiskPath(fileName)
+	verdict, apiErr := cfg.screenThumbnail(r.Context(), video, rule, file, header.Size)
+	if apiErr != nil {
+		return video, apiErr
+	}
+	return cfg.saveThumbnailUpload(r, video, rule, verdict, file, framing)
+}
 
-	filePath, err := os.Create(assetDiskPath)
+// screenThumbnail runs a thumbnail past moderation, failing if it's
+// rejected outright.
+func (cfg *apiConfig) screenThumbnail(ctx context.Context, video database.Video, rule mediaTypeRule, file io.ReadSeeker, size int64) (thumbnailVerdict, *apiError) {
+	verdict, err := cfg.moderateThumbnail(ctx, rule.MediaType, file, size)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create asset file", err)
-		return
+		return verdict, &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't check thumbnail", Err: err}
+	}
+	if verdict.reject {
+		cfg.audit(database.ActorSystem, "thumbnail.reject", auditSubjectVideo, video.ID.String(), map[string]any{
+			"reason": verdict.reason,
+			"detail": verdict.detail,
+		})
+		return verdict, &apiError{Status: http.StatusUnprocessableEntity, Code: errCodeThumbnailRejected, Message: "Thumbnail violates the content policy"}
 	}
+	return verdict, nil
+}
 
-	if _, err := io.Copy(filePath, file); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't copy data", err)
-		return
+// saveThumbnailUpload stores a screened thumbnail as an asset, points the
+// video at it and flags the video if moderation asked for a second look.
+func (cfg *apiConfig) saveThumbnailUpload(r *http.Request, video database.Video, rule mediaTypeRule, verdict thumbnailVerdict, file io.Reader, framing media.Framing) (database.Video, *apiError) {
+	fileName, written, apiErr := cfg.writeThumbnailAsset(rule, file)
+	if apiErr != nil {
+		return video, apiErr
 	}
 
-	thumbnailURL := cfg.getAssetURL(fileName)
-	videoDb.ThumbnailURL = &thumbnailURL
+	video, err := cfg.attachThumbnail(r.Context(), video, cfg.getAssetURL(fileName), written, framing)
+	if err != nil {
+		return video, &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't set thumbnail", Err: err}
+	}
+	cfg.flagThumbnail(video, verdict)
+	return video, nil
+}
 
-	err = cfg.db.UpdateVideo(videoDb)
+// writeThumbnailAsset saves a thumbnail under a fresh asset name and
+// returns the name and its size.
+func (cfg *apiConfig) writeThumbnailAsset(rule mediaTypeRule, file io.Reader) (string, int64, *apiError) {
+	fileName := rule.assetPath()
+	assetDiskPath := cfg.getAssetDiskPath(fileName)
+
+	filePath, err := os.Create(assetDiskPath)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
-		return
+		return "", 0, &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't create asset file", Err: err}
 	}
+	defer filePath.Close()
 
-	respondWithJSON(w, http.StatusOK, videoDb)
+	written, err := io.Copy(filePath, file)
+	if err != nil {
+		return "", 0, &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't copy data", Err: err}
+	}
+	return fileName, written, nil
+}
+
+// flagThumbnail flags the video if moderation asked for a second look at
+// its new thumbnail.
+func (cfg *apiConfig) flagThumbnail(video database.Video, verdict thumbnailVerdict) {
+	if !verdict.flag {
+		return
+	}
+	if err := cfg.flagVideo(video.ID, verdict.reason, verdict.detail); err != nil {
+		log.Printf("Couldn't flag thumbnail of video %s: %v", video.ID, err)
+		return
+	}
+	cfg.audit(database.ActorSystem, "thumbnail.flag", auditSubjectVideo, video.ID.String(), map[string]any{
+		"reason": verdict.reason,
+		"detail": verdict.detail,
+	})
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// defaultUploadTokenTTL is how long an upload token works when the request
+// doesn't say.
+const defaultUploadTokenTTL = 15 * time.Minute
+
+type uploadTokenResponse struct {
+	database.UploadToken
+	UploadURL string `json:"upload_url"`
+}
+
+// handlerUploadTokenCreate mints a token that uploads a single file to the
+// video, for widgets and tools that shouldn't hold the user's JWT. It's
+// sent as "Authorization: UploadToken <token>" to the regular upload
+// endpoint and stops working once an upload has started with it.
+func (cfg *apiConfig) handlerUploadTokenCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ExpiresInSeconds int    `json:"expires_in_seconds"`
+		MaxSize          int64  `json:"max_size"`
+		ContentType      string `json:"content_type"`
+	}
+
+	video, userID, ok := cfg.authorizedVideoUser(w, r, permEdit)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+
+	ttl := defaultUploadTokenTTL
+	if params.ExpiresInSeconds != 0 {
+		ttl = time.Duration(params.ExpiresInSeconds) * time.Second
+	}
+	maxTTL := envDuration("UPLOAD_TOKEN_MAX_TTL", 24*time.Hour)
+	var details []errorDetail
+	if ttl <= 0 || ttl > maxTTL {
+		details = append(details, errorDetail{Field: "expires_in_seconds", Message: fmt.Sprintf("must be between 1 and %d", int(maxTTL.Seconds()))})
+	}
+	if params.MaxSize < 0 {
+		details = append(details, errorDetail{Field: "max_size", Message: "can't be negative"})
+	}
+	var mediaType string
+	if params.ContentType != "" {
+		parsed, _, err := mime.ParseMediaType(params.ContentType)
+		if _, ok := cfg.mediaTypes.lookup(mediaKindVideo, parsed); err != nil || !ok {
+			details = append(details, errorDetail{Field: "content_type", Message: fmt.Sprintf("must be one of %s", cfg.mediaTypes.allowed(mediaKindVideo))})
+		}
+		mediaType = parsed
+	}
+	if len(details) > 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid upload token",
+			Details: details,
+		})
+		return
+	}
+
+	secret, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload token", err)
+		return
+	}
+	token, err := cfg.db.CreateUploadToken(database.UploadToken{
+		Token:       secret,
+		VideoID:     video.ID,
+		UserID:      userID,
+		MaxSize:     params.MaxSize,
+		ContentType: mediaType,
+		ExpiresAt:   time.Now().UTC().Add(ttl),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload token", err)
+		return
+	}
+	cfg.audit(auditUser(userID), "video.upload_token_create", auditSubjectVideo, video.ID.String(), map[string]any{
+		"expires_at":   token.ExpiresAt,
+		"max_size":     token.MaxSize,
+		"content_type": token.ContentType,
+	})
+
+	respondWithJSON(w, http.StatusCreated, uploadTokenResponse{
+		UploadToken: token,
+		UploadURL:   apiPath(r, "/video_upload/"+video.ID.String()),
+	})
+}
+
+// redeemUploadToken checks the upload token is for the video and uses it
+// up, so it can't start a second upload even if this one fails.
+func (cfg *apiConfig) redeemUploadToken(secret string, videoID uuid.UUID) (database.UploadToken, *apiError) {
+	token, err := cfg.db.GetUploadToken(secret)
+	if err != nil {
+		return token, &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't get upload token", Err: err}
+	}
+	if token.Token == "" {
+		return token, &apiError{Status: http.StatusUnauthorized, Code: errCodeUnauthorized, Message: "Invalid upload token"}
+	}
+	if token.VideoID != videoID {
+		return token, &apiError{Status: http.StatusForbidden, Code: errCodeForbidden, Message: "Upload token is for a different video"}
+	}
+
+	user, err := cfg.db.GetUser(token.UserID)
+	if err != nil {
+		return token, &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't get user", Err: err}
+	}
+	if user == nil {
+		return token, &apiError{Status: http.StatusUnauthorized, Code: errCodeUnauthorized, Message: "Invalid upload token"}
+	}
+	if user.SuspendedAt != nil {
+		return token, &apiError{Status: http.StatusForbidden, Code: errCodeAccountSuspended, Message: "Account is suspended"}
+	}
+
+	used, err := cfg.db.UseUploadToken(secret, time.Now().UTC())
+	if err != nil {
+		return token, &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't use upload token", Err: err}
+	}
+	if !used {
+		if !time.Now().Before(token.ExpiresAt) {
+			return token, &apiError{Status: http.StatusUnauthorized, Code: errCodeUnauthorized, Message: "Upload token expired"}
+		}
+		return token, &apiError{Status: http.StatusUnauthorized, Code: errCodeUnauthorized, Message: "Upload token was already used"}
+	}
+	return token, nil
+}
+
+// purgeUploadTokens removes upload tokens that expired a day ago or more,
+// keeping recent ones around to tell a reused token from an unknown one.
+func (cfg *apiConfig) purgeUploadTokens(ctx context.Context) error {
+	n, err := cfg.db.DeleteUploadTokensBefore(time.Now().UTC().Add(-24 * time.Hour))
+	if err != nil {
+		return fmt.Errorf("couldn't delete expired upload tokens: %w", err)
+	}
+	if n > 0 {
+		log.Printf("upload_token_purge: removed %d expired upload tokens", n)
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// handlerVideoUploadURL issues a presigned POST policy for uploading a video
+// straight to S3. The policy carries the same size and MIME restrictions
+// handlerUploadVideo enforces for proxied uploads, so S3 rejects anything
+// the API itself would have refused. Once the POST succeeds the client
+// reports it to handlerVideoUploadComplete.
+func (cfg *apiConfig) handlerVideoUploadURL(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ContentType string `json:"content_type"`
+		// FileName is the name of the file on the client, kept with the
+		// object.
+		FileName string `json:"file_name"`
+	}
+	type response struct {
+		URL       string            `json:"url"`
+		Fields    map[string]string `json:"fields"`
+		Key       string            `json:"key"`
+		MaxSize   int64             `json:"max_size"`
+		ExpiresAt time.Time         `json:"expires_at"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(params.ContentType)
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidMediaType, "Couldn't parse media type", err)
+		return
+	}
+	rule, ok := cfg.mediaTypes.lookup(mediaKindVideo, mediaType)
+	if !ok {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidMediaType, fmt.Sprintf("Only accepts %s", cfg.mediaTypes.allowed(mediaKindVideo)), nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithErrorCode(w, http.StatusNotFound, errCodeVideoNotFound, "Video not found", nil)
+		return
+	}
+	if !cfg.checkVideoAccess(w, video, userID, permEdit, "You can't upload to this video") {
+		return
+	}
+
+	plan, err := cfg.videoPlan(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	bytesUsed, err := cfg.videoBytesUsed(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+	maxSize := min(plan.MaxFileSize, plan.MaxTotalStorage-bytesUsed+video.VideoSize)
+	if rule.MaxSize > 0 {
+		maxSize = min(maxSize, rule.MaxSize)
+	}
+	if maxSize <= 0 {
+		respondWithErrorCode(w, http.StatusForbidden, errCodeQuotaExceeded, "Storage quota exceeded", nil)
+		return
+	}
+
+	key := cfg.keys.objectKey(objectKeyParams{
+		kind:    "direct",
+		userID:  userID,
+		videoID: video.ID,
+		ext:     rule.Extension,
+		now:     time.Now(),
+	})
+	headers := cfg.videoPutOptions(video, mediaType)
+	presigned, err := cfg.store.PresignPost(r.Context(), key, storage.PresignPostOptions{
+		ContentType:        mediaType,
+		MaxSize:            maxSize,
+		Expires:            cfg.presignUploadTTL,
+		CacheControl:       headers.CacheControl,
+		ContentDisposition: headers.ContentDisposition,
+		Metadata:           videoObjectMetadata(video.ID, userID, params.FileName, time.Now()),
+	})
+	if errors.Is(err, storage.ErrPresignNotSupported) {
+		respondWithErrorCode(w, http.StatusNotImplemented, errCodeNotImplemented, "Direct uploads aren't available with this storage backend", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't presign upload", err)
+		return
+	}
+
+	video.PendingVideoKey = &key
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		URL:       presigned.URL,
+		Fields:    presigned.Fields,
+		Key:       key,
+		MaxSize:   maxSize,
+		ExpiresAt: time.Now().UTC().Add(cfg.presignUploadTTL),
+	})
+}
+
+// jobDirectUpload processes a video that was uploaded straight to the
+// store.
+const jobDirectUpload = "direct_upload"
+
+// handlerVideoUploadComplete is called by the browser once its presigned
+// POST went through. The object has to match the size and SHA-256 the
+// client declares, or it's deleted; only then is it processed, in a
+// background job, and becomes the video's file if it's within the plan's
+// limits.
+func (cfg *apiConfig) handlerVideoUploadComplete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Key  string `json:"key"`
+		Size int64  `json:"size"`
+		// ChecksumSHA256 is base64 encoded, as S3 reports it.
+		ChecksumSHA256 string `json:"checksum_sha256"`
+	}
+	type response struct {
+		Video database.Video `json:"video"`
+		Job   database.Job   `json:"job"`
+	}
+
+	video, userID, ok := cfg.authorizedVideoUser(w, r, permEdit)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+	var details []errorDetail
+	if params.Size <= 0 {
+		details = append(details, errorDetail{Field: "size", Message: "must be greater than zero"})
+	}
+	if sum, err := base64.StdEncoding.DecodeString(params.ChecksumSHA256); err != nil || len(sum) != sha256.Size {
+		details = append(details, errorDetail{Field: "checksum_sha256", Message: "must be a base64 encoded SHA-256"})
+	}
+	if len(details) > 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid upload completion",
+			Details: details,
+		})
+		return
+	}
+
+	if video.PendingVideoKey == nil || (params.Key != "" && params.Key != *video.PendingVideoKey) {
+		respondWithErrorCode(w, http.StatusConflict, errCodeConflict, "No direct upload is pending for this key", nil)
+		return
+	}
+	key := *video.PendingVideoKey
+
+	info, err := cfg.objectAttributes(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithErrorCode(w, http.StatusNotFound, errCodeNotFound, "The upload hasn't reached the store", err)
+		return
+	}
+	if err != nil {
+		cfg.reportStorageError("attributes", key, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check upload", err)
+		return
+	}
+
+	details = nil
+	if info.Size != params.Size {
+		details = append(details, errorDetail{Field: "size", Message: fmt.Sprintf("the store has %d bytes", info.Size)})
+	}
+	if info.ChecksumSHA256 != params.ChecksumSHA256 {
+		details = append(details, errorDetail{Field: "checksum_sha256", Message: "doesn't match the stored object"})
+	}
+	if len(details) > 0 {
+		cfg.discardDirectUpload(r.Context(), video, key)
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusUnprocessableEntity,
+			Code:    errCodeChecksumMismatch,
+			Message: "Uploaded file doesn't match what was declared",
+			Details: details,
+		})
+		return
+	}
+
+	plan, err := cfg.videoPlan(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	// Usage may have moved since the upload was presigned.
+	if err := cfg.checkVideoQuota(plan, video, video.VideoSize, info.Size); err != nil {
+		if errors.Is(err, errStorageQuotaExceeded) {
+			cfg.discardDirectUpload(r.Context(), video, key)
+		}
+		respondWithAPIError(w, *quotaError(err))
+		return
+	}
+
+	// Another upload may have finished while this one was checked.
+	_, unlock, err := cfg.lockVideo(r.Context(), video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't lock video", err)
+		return
+	}
+	defer unlock()
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.PendingVideoKey == nil || *video.PendingVideoKey != key {
+		respondWithErrorCode(w, http.StatusConflict, errCodeConflict, "No direct upload is pending for this key", nil)
+		return
+	}
+
+	job, err := cfg.db.CreateJob(jobDirectUpload, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create job", err)
+		return
+	}
+	// The job owns the upload from here on: it's validated against the
+	// plan's limits before anything is attached, and deleted either way.
+	video.PendingVideoKey = nil
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.startJob(job, func(ctx context.Context) (any, error) {
+		processing := cfg.newProcessingJob(video)
+		processing.uploaderID = userID
+		processing.sourceKey = key
+		processing.staged = true
+		processing.size = info.Size
+		processed, err := cfg.storeVideo(ctx, processing, video, "")
+		processing.finish(err)
+		if err != nil {
+			return nil, err
+		}
+		cfg.events.Publish(eventUploadFinished, uploadEvent{
+			VideoID: processed.ID,
+			UserID:  processed.UserID,
+			Method:  uploadMethodDirect,
+			Size:    processed.VideoSize,
+		})
+		return nil, nil
+	})
+
+	respondWithJSON(w, http.StatusAccepted, response{Video: video, Job: job})
+}
+
+// objectAttributes describes the object with its SHA-256, reading the
+// whole object to work it out when the store didn't keep one.
+func (cfg *apiConfig) objectAttributes(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	var info storage.ObjectInfo
+	var err error
+	if reader, ok := cfg.store.(storage.AttributeReader); ok {
+		info, err = reader.Attributes(ctx, key)
+	} else {
+		info, err = cfg.store.Stat(ctx, key)
+	}
+	if err != nil || info.ChecksumSHA256 != "" {
+		return info, err
+	}
+
+	body, err := cfg.store.Get(ctx, key)
+	if err != nil {
+		return info, err
+	}
+	defer body.Close()
+	hash := sha256.New()
+	if info.Size, err = io.Copy(hash, body); err != nil {
+		return info, err
+	}
+	info.ChecksumSHA256 = base64.StdEncoding.EncodeToString(hash.Sum(nil))
+	return info, nil
+}
+
+// discardDirectUpload deletes a direct upload that was turned away, so it
+// doesn't linger in the bucket unaccounted for.
+func (cfg *apiConfig) discardDirectUpload(ctx context.Context, video database.Video, key string) {
+	if err := cfg.store.Delete(ctx, key); err != nil {
+		cfg.reportStorageError("delete", key, err)
+	}
+	video.PendingVideoKey = nil
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		log.Printf("Couldn't clear pending upload of video %s: %v", video.ID, err)
+	}
+}
@@ -1,221 +1,337 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
 	"database/sql"
-	"encoding/json"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"math"
 	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"os"
-	"os/exec"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 	"github.com/google/uuid"
 )
 
-type VideoMetadata struct {
-	Streams []struct {
-		Width  int `json:"width"`
-		Height int `json:"height"`
-	} `json:"streams"`
-}
-
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	r.Body = http.MaxBytesReader(w, r.Body, 10<<30)
-
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid ID", err)
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
+	// Widgets upload with a one-time token instead of the user's JWT.
+	var userID uuid.UUID
+	var uploadToken *database.UploadToken
+	if secret, err := auth.GetUploadToken(r.Header); err == nil {
+		token, apiErr := cfg.redeemUploadToken(secret, videoID)
+		if apiErr != nil {
+			respondWithAPIError(w, *apiErr)
+			return
+		}
+		userID = token.UserID
+		uploadToken = &token
+	} else {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+			return
+		}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "COuldn't validate JWT", err)
-		return
+		userID, err = cfg.validateJWT(token)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "COuldn't validate JWT", err)
+			return
+		}
 	}
 
-	fmt.Println("uploading video for video", videoID, "by user", userID)
-
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			respondWithError(w, http.StatusNotFound, "Video not found", err)
+			respondWithErrorCode(w, http.StatusNotFound, errCodeVideoNotFound, "Video not found", err)
 			return
 		}
 		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
 		return
 	}
-
-	file, header, err := r.FormFile("video")
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+	if !cfg.checkVideoAccess(w, video, userID, permEdit, "You can't upload to this video") {
 		return
 	}
-	defer file.Close()
 
-	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
+	plan, err := cfg.videoPlan(video)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't parse media type", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
 		return
 	}
-	if mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Only accept video/mp4", nil)
+	maxSize := plan.MaxFileSize
+	if uploadToken != nil && uploadToken.MaxSize > 0 {
+		maxSize = min(maxSize, uploadToken.MaxSize)
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	cfg.throttleUpload(r, video, plan)
+	tooLarge := func(err error) bool {
+		var maxBytesErr *http.MaxBytesError
+		if !errors.As(err, &maxBytesErr) {
+			return false
+		}
+		msg := "Video exceeds your plan's file size limit"
+		if maxSize < plan.MaxFileSize {
+			msg = "Video exceeds the upload token's file size limit"
+		}
+		respondWithErrorCode(w, http.StatusRequestEntityTooLarge, errCodeVideoTooLarge, msg, err)
+		return true
+	}
+	tokenRejects := func(header textproto.MIMEHeader) bool {
+		if uploadToken == nil || uploadToken.ContentType == "" {
+			return false
+		}
+		mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+		if mediaType == uploadToken.ContentType {
+			return false
+		}
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidMediaType, fmt.Sprintf("The upload token only accepts %s", uploadToken.ContentType), nil)
+		return true
+	}
+
+	var apiErr *apiError
+	if cfg.scratchMode == scratchModeStaging {
+		part, err := formFilePart(r, "video")
+		if err != nil {
+			if !tooLarge(err) {
+				respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+			}
+			return
+		}
+		defer part.Close()
+		if tokenRejects(part.Header) {
+			return
+		}
+		video, apiErr = cfg.stageVideoUpload(r.Context(), plan, video, userID, part)
+	} else {
+		file, header, err := r.FormFile("video")
+		if err != nil {
+			if !tooLarge(err) {
+				respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+			}
+			return
+		}
+		defer file.Close()
+		if tokenRejects(header.Header) {
+			return
+		}
+		video, apiErr = cfg.storeVideoUpload(r.Context(), plan, video, userID, file, header)
+	}
+	if apiErr != nil {
+		if apiErr.Err == nil || !tooLarge(apiErr.Err) {
+			respondWithAPIError(w, *apiErr)
+		}
 		return
 	}
 
-	tempFile, err := os.CreateTemp("", "tubely-upload.mp4")
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// storeVideoUpload runs an uploaded form file through quota and media type
+// checks, then the processing pipeline.
+func (cfg *apiConfig) storeVideoUpload(ctx context.Context, plan *database.Plan, video database.Video, uploaderID uuid.UUID, file multipart.File, header *multipart.FileHeader) (database.Video, *apiError) {
+	if err := cfg.checkVideoQuota(plan, video, video.VideoSize, header.Size); err != nil {
+		return video, quotaError(err)
+	}
+
+	if _, apiErr := cfg.checkMediaUpload(mediaKindVideo, file, header); apiErr != nil {
+		return video, apiErr
+	}
+
+	upload := uploadEvent{VideoID: video.ID, UserID: video.UserID, Method: uploadMethodForm, Size: header.Size}
+	if cfg.scratchMode == scratchModeStaging {
+		cfg.events.Publish(eventUploadStarted, upload)
+		job := cfg.newProcessingJob(video)
+		job.uploaderID = uploaderID
+		job.filename = header.Filename
+		return cfg.storeStagedVideo(ctx, job, video, upload, file, header.Header.Get("Content-Type"), nil)
+	}
+
+	tempFile, err := os.CreateTemp("", tempUploadPattern)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create temporrary file", err)
-		return
+		return video, &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't create temporrary file", Err: err}
 	}
 	defer os.Remove(tempFile.Name())
 	defer tempFile.Close()
 
-	if _, err := io.Copy(tempFile, file); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't copy data", err)
-		return
+	cfg.events.Publish(eventUploadStarted, upload)
+	job := cfg.newProcessingJob(video)
+	job.uploaderID = uploaderID
+	job.filename = header.Filename
+	doneCopy := job.stage(stageCopy)
+	job.size, err = io.Copy(tempFile, file)
+	doneCopy()
+	if err != nil {
+		job.finish(err)
+		return video, &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't copy data", Err: err}
 	}
 	if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't reset file pointer", err)
-		return
+		return video, &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't reset file pointer", Err: err}
 	}
 
-	processedVideoPath, err := processVideoForFastStart(tempFile.Name())
-	if err != nil {
-		respondWithError(
-			w,
-			http.StatusInternalServerError,
-			"Couldn't process video for fast start",
-			err,
-		)
-		return
+	video, err = cfg.storeVideo(ctx, job, video, tempFile.Name())
+	job.finish(err)
+	return cfg.uploadProcessed(video, upload, err)
+}
+
+// uploadProcessed reports how processing an upload went, as the error to
+// respond with or the upload finished event.
+func (cfg *apiConfig) uploadProcessed(video database.Video, upload uploadEvent, err error) (database.Video, *apiError) {
+	var corrupt *media.CorruptError
+	if errors.As(err, &corrupt) {
+		return video, &apiError{Status: http.StatusUnprocessableEntity, Code: errCodeVideoCorrupt, Message: corrupt.Reason, Err: err}
+	}
+	var limit *mediaLimitError
+	if errors.As(err, &limit) {
+		return video, limit.apiError()
 	}
-	processedVideoFile, err := os.Open(processedVideoPath)
 	if err != nil {
-		respondWithError(
-			w,
-			http.StatusInternalServerError,
-			"Couldn't open processed video file",
-			err,
-		)
-		return
+		return video, &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't store video", Err: err}
+	}
+	upload.Size = video.VideoSize
+	cfg.events.Publish(eventUploadFinished, upload)
+	return video, nil
+}
+
+// storeVideo runs the upload at tempPath through the processing pipeline:
+// it's remuxed for fast start, uploaded and recorded on the video row and
+// in the owner's usage. It waits for any other upload or processing run
+// of the video to finish first, then starts from the row as that left it.
+// A job with a sourceKey has no tempPath; its upload is downloaded once a
+// worker is free.
+func (cfg *apiConfig) storeVideo(ctx context.Context, job *processingJob, video database.Video, tempPath string) (database.Video, error) {
+	cfg.processing.add(job)
+	defer cfg.processing.remove(job)
+	if job.staged {
+		defer cfg.discardStagedUpload(ctx, job.sourceKey)
 	}
-	defer processedVideoFile.Close()
 
-	aspectRatio, err := getVideoAspectRatio(processedVideoFile.Name())
+	doneQueued := job.stage(stageQueued)
+	ctx, unlock, err := cfg.lockVideo(ctx, video.ID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't get video aspect ratio", err)
-		return
+		doneQueued()
+		return video, err
 	}
-	if aspectRatio == "16:9" {
-		aspectRatio = "landscape"
-	} else if aspectRatio == "9:16" {
-		aspectRatio = "portrait"
+	defer unlock()
+	plan, err := cfg.videoPlan(video)
+	if err != nil {
+		doneQueued()
+		return video, fmt.Errorf("couldn't get plan: %w", err)
 	}
-
-	key := fmt.Sprintf("%s/%s", aspectRatio, getAssetPath(mediaType))
-	_, err = cfg.s3Client.PutObject(r.Context(), &s3.PutObjectInput{
-		Bucket:      aws.String(cfg.s3Bucket),
-		Key:         aws.String(key),
-		Body:        processedVideoFile,
-		ContentType: aws.String(mediaType),
-	})
+	job.priority = plan.Priority
+	release, err := cfg.videoWorkers.acquirePriority(ctx, job.priority)
+	doneQueued()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't upload video to S3", err)
-		return
+		return video, err
 	}
-
-	videoURL := cfg.getObjectURL(key)
-	video.VideoURL = &videoURL
-
-	if err := cfg.db.UpdateVideo(video); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
-		return
+	defer release()
+
+	if job.sourceKey != "" {
+		doneFetch := job.stage(stageFetch)
+		tempPath, err = cfg.fetchSource(ctx, job.sourceKey)
+		doneFetch()
+		if err != nil {
+			return video, err
+		}
+		defer os.Remove(tempPath)
 	}
 
-	respondWithJSON(w, http.StatusOK, video)
-}
-
-func processVideoForFastStart(filepath string) (string, error) {
-	outputFilePath := filepath + ".processing"
-	cmd := exec.Command("ffmpeg",
-		"-i",
-		filepath,
-		"-c",
-		"copy",
-		"-movflags",
-		"faststart",
-		"-f",
-		"mp4",
-		outputFilePath,
-	)
-
-	var out bytes.Buffer
-	cmd.Stdout = &out
-
-	if err := cmd.Run(); err != nil {
-		return "", err
+	latest, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		return video, err
+	}
+	if latest.ID == uuid.Nil {
+		return video, errors.New("video was deleted before it was processed")
 	}
+	video = latest
 
-	return outputFilePath, nil
+	state := &pipelineState{job: job, video: video, inputPath: tempPath}
+	defer state.close()
+	err = cfg.videoPipeline().run(ctx, state)
+	return state.video, err
 }
 
-func getVideoAspectRatio(filepath string) (string, error) {
-	cmd := exec.Command(
-		"ffprobe",
-		"-v",
-		"error",
-		"-print_format",
-		"json",
-		"-show_streams",
-		filepath,
-	)
-
-	var out bytes.Buffer
-	cmd.Stdout = &out
+type fileDigests struct {
+	size       int64
+	contentMD5 string
+	// sha256 is hex encoded, for use in keys.
+	sha256 string
+}
 
-	if err := cmd.Run(); err != nil {
-		return "", err
+// hashFile returns the size and digests of f, then rewinds it so the same
+// handle can be uploaded with a matching Content-MD5.
+func hashFile(f *os.File) (fileDigests, error) {
+	md5Hash := md5.New()
+	sha256Hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), f)
+	if err != nil {
+		return fileDigests{}, err
 	}
-
-	metadata := &VideoMetadata{}
-	if err := json.Unmarshal(out.Bytes(), metadata); err != nil {
-		return "", err
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fileDigests{}, err
 	}
+	return fileDigests{
+		size:       size,
+		contentMD5: base64.StdEncoding.EncodeToString(md5Hash.Sum(nil)),
+		sha256:     hex.EncodeToString(sha256Hash.Sum(nil)),
+	}, nil
+}
 
-	width := metadata.Streams[0].Width
-	height := metadata.Streams[0].Height
-	tolerance := 0.01
-
-	aspectRatio := checkAspectRatioType(width, height, tolerance)
+// attachVideoObject points the video row at a freshly stored object, moves
+// the owner's usage by the size difference and removes the object it
+// replaces, if any.
+func (cfg *apiConfig) attachVideoObject(ctx context.Context, video database.Video, key string, info storage.ObjectInfo) (database.Video, error) {
+	previousKey := video.VideoKey
 
-	return aspectRatio, nil
-}
+	var objectsDelta int64 = 1
+	if previousKey != nil {
+		objectsDelta = 0
+	}
+	bytesDelta := info.Size - video.VideoSize
 
-func checkAspectRatioType(width, height int, tolerance float64) string {
-	sixteenNineRatio := 16.0 / 9.0
-	nineSixteenRatio := 9.0 / 16.0
+	videoURL := cfg.keys.versionedURL(cfg.store.URL(key), info)
+	video.VideoURL = &videoURL
+	video.VideoKey = &key
+	video.VideoSize = info.Size
+	video.VideoChecksum = info.ChecksumSHA256
+	video.VideoETag = info.ETag
+	video.VideoVersionID = info.VersionID
+	video.StorageTier = database.TierStandard
+	video.RestoredUntil = nil
 
-	ratio := float64(width) / float64(height)
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		return video, fmt.Errorf("couldn't update video: %w", err)
+	}
+	if err := cfg.adjustVideoUsage(video, bytesDelta, objectsDelta); err != nil {
+		return video, fmt.Errorf("couldn't update storage usage: %w", err)
+	}
+	cfg.recordVideoVersion(video)
 
-	if math.Abs(ratio-sixteenNineRatio) <= tolerance {
-		return "16:9"
+	// A locked object has to stay until its retention runs out, even once
+	// nothing points at it.
+	if previousKey != nil && *previousKey != key && !video.Locked(time.Now()) {
+		cfg.deleteVideoObject(context.WithoutCancel(ctx), *previousKey)
 	}
-	if math.Abs(ratio-nineSixteenRatio) <= tolerance {
-		return "9:16"
+	// Under video-hash naming a reprocessed or re-uploaded video can land
+	// on the key it already had.
+	if previousKey != nil && *previousKey == key {
+		cfg.invalidateCDN(key)
 	}
-	return "other"
+	return video, nil
 }
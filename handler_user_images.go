@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+// userImageSpec is what an avatar or banner is cut to. Uploads go through
+// the same validation, moderation and cropping as thumbnails, then are
+// stored under their own prefix in the assets directory.
+type userImageSpec struct {
+	image  database.UserImage
+	prefix string
+	// width and height are the size it's stored at. The part of the upload
+	// that's kept must be at least minWidth by minHeight.
+	width          int
+	height         int
+	minWidth       int
+	minHeight      int
+	maxSizeEnv     string
+	defaultMaxSize int
+}
+
+var (
+	avatarSpec = userImageSpec{
+		image:          database.UserImageAvatar,
+		prefix:         "avatar-",
+		width:          400,
+		height:         400,
+		minWidth:       98,
+		minHeight:      98,
+		maxSizeEnv:     "AVATAR_MAX_SIZE",
+		defaultMaxSize: 2 << 20,
+	}
+	bannerSpec = userImageSpec{
+		image:          database.UserImageBanner,
+		prefix:         "banner-",
+		width:          2048,
+		height:         512,
+		minWidth:       1024,
+		minHeight:      256,
+		maxSizeEnv:     "BANNER_MAX_SIZE",
+		defaultMaxSize: 6 << 20,
+	}
+)
+
+func (spec userImageSpec) maxSize() int64 {
+	return int64(envInt(spec.maxSizeEnv, spec.defaultMaxSize))
+}
+
+func (cfg *apiConfig) handlerUserAvatarUpload(w http.ResponseWriter, r *http.Request) {
+	cfg.uploadUserImage(w, r, avatarSpec)
+}
+
+func (cfg *apiConfig) handlerUserBannerUpload(w http.ResponseWriter, r *http.Request) {
+	cfg.uploadUserImage(w, r, bannerSpec)
+}
+
+func (cfg *apiConfig) handlerUserAvatarDelete(w http.ResponseWriter, r *http.Request) {
+	cfg.deleteUserImage(w, r, avatarSpec)
+}
+
+func (cfg *apiConfig) handlerUserBannerDelete(w http.ResponseWriter, r *http.Request) {
+	cfg.deleteUserImage(w, r, bannerSpec)
+}
+
+// uploadUserImage replaces the caller's avatar or banner with the image in
+// the form field named after it. The crop or focal_point query parameter
+// picks the part that's kept, as for thumbnails.
+func (cfg *apiConfig) uploadUserImage(w http.ResponseWriter, r *http.Request, spec userImageSpec) {
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	maxSize := spec.maxSize()
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+1<<20)
+	const maxMemory = 10 << 20
+	r.ParseMultipartForm(maxMemory)
+
+	file, header, err := r.FormFile(string(spec.image))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithErrorCode(w, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, fmt.Sprintf("Images are limited to %d bytes", maxSize), err)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return
+	}
+	defer file.Close()
+	if header.Size > maxSize {
+		respondWithErrorCode(w, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, fmt.Sprintf("Images are limited to %d bytes", maxSize), nil)
+		return
+	}
+
+	rule, ok := cfg.validateMediaUpload(w, mediaKindThumbnail, file, header)
+	if !ok {
+		return
+	}
+	framing, details := thumbnailFraming(r)
+	if len(details) > 0 {
+		respondInvalidFraming(w, details)
+		return
+	}
+
+	verdict, err := cfg.moderateThumbnail(r.Context(), rule.MediaType, file, header.Size)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check image", err)
+		return
+	}
+	if verdict.reject {
+		cfg.audit(database.ActorSystem, "user."+string(spec.image)+"_reject", auditSubjectUser, userID.String(), map[string]any{
+			"reason": verdict.reason,
+			"detail": verdict.detail,
+		})
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, errCodeImageRejected, "Image violates the content policy", nil)
+		return
+	}
+
+	source, err := os.CreateTemp("", "tubely-"+string(spec.image)+"-*"+rule.Extension)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create temp file", err)
+		return
+	}
+	defer os.Remove(source.Name())
+	defer source.Close()
+	if _, err := io.Copy(source, file); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't copy data", err)
+		return
+	}
+
+	fileName, apiErr := cfg.renderUserImage(r, spec, source.Name(), framing)
+	if apiErr != nil {
+		respondWithAPIError(w, *apiErr)
+		return
+	}
+	url := cfg.getAssetURL(fileName)
+	previous, err := cfg.db.SetUserImage(userID, spec.image, &url)
+	if err != nil {
+		os.Remove(cfg.getAssetDiskPath(fileName))
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+	cfg.removeUserImage(previous)
+	if verdict.flag {
+		cfg.audit(database.ActorSystem, "user."+string(spec.image)+"_flag", auditSubjectUser, userID.String(), map[string]any{
+			"reason": verdict.reason,
+			"detail": verdict.detail,
+		})
+	}
+	cfg.audit(auditUser(userID), "user."+string(spec.image)+"_update", auditSubjectUser, userID.String(), nil)
+
+	cfg.respondWithUserImages(w, userID)
+}
+
+// renderUserImage crops the image at source to the spec's size and aspect
+// ratio, returning the name of the asset it wrote.
+func (cfg *apiConfig) renderUserImage(r *http.Request, spec userImageSpec, source string, framing media.Framing) (string, *apiError) {
+	release, err := cfg.thumbnailWorkers.acquire(r.Context())
+	if err != nil {
+		return "", &apiError{Status: http.StatusServiceUnavailable, Code: errCodeTimeout, Message: "Image processing is busy, try again later", Err: err}
+	}
+	defer release()
+
+	metadata, err := cfg.media.Probe(r.Context(), source)
+	if err != nil || len(metadata.Streams) == 0 || metadata.Streams[0].Width == 0 || metadata.Streams[0].Height == 0 {
+		return "", &apiError{Status: http.StatusBadRequest, Code: errCodeInvalidMediaType, Message: "Couldn't read image dimensions", Err: err}
+	}
+	width, height := metadata.Streams[0].Width, metadata.Streams[0].Height
+
+	region := framing.Region(width, height, float64(spec.width)/float64(spec.height))
+	if region.Dx() < spec.minWidth || region.Dy() < spec.minHeight {
+		return "", &apiError{
+			Status:  http.StatusUnprocessableEntity,
+			Code:    errCodeImageTooSmall,
+			Message: fmt.Sprintf("%s images must be at least %dx%d", string(spec.image), spec.minWidth, spec.minHeight),
+			Details: []errorDetail{{Field: string(spec.image), Message: fmt.Sprintf("the area used is %dx%d", region.Dx(), region.Dy())}},
+		}
+	}
+
+	fileName := spec.prefix + newAssetName() + ".jpg"
+	if err := cfg.media.Crop(r.Context(), source, region, spec.width, spec.height, cfg.getAssetDiskPath(fileName)); err != nil {
+		os.Remove(cfg.getAssetDiskPath(fileName))
+		return "", &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't resize image", Err: err}
+	}
+	return fileName, nil
+}
+
+func (cfg *apiConfig) deleteUserImage(w http.ResponseWriter, r *http.Request, spec userImageSpec) {
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+	previous, err := cfg.db.SetUserImage(userID, spec.image, nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+	cfg.removeUserImage(previous)
+	if previous != nil {
+		cfg.audit(auditUser(userID), "user."+string(spec.image)+"_delete", auditSubjectUser, userID.String(), nil)
+	}
+	cfg.respondWithUserImages(w, userID)
+}
+
+// removeUserImage deletes the asset behind an avatar or banner URL.
+func (cfg *apiConfig) removeUserImage(url *string) {
+	if url == nil {
+		return
+	}
+	err := os.Remove(cfg.getAssetDiskPath(path.Base(*url)))
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Couldn't delete user image %s: %v", path.Base(*url), err)
+	}
+}
+
+type userImagesResponse struct {
+	AvatarURL *string `json:"avatar_url"`
+	BannerURL *string `json:"banner_url"`
+}
+
+func (cfg *apiConfig) respondWithUserImages(w http.ResponseWriter, userID uuid.UUID) {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, userImagesResponse{AvatarURL: user.AvatarURL, BannerURL: user.BannerURL})
+}
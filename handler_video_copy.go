@@ -0,0 +1,239 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerVideoCopy clones one of the caller's videos into a new video. The
+// video object is copied inside the store rather than downloaded and
+// uploaded again.
+func (cfg *apiConfig) handlerVideoCopy(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title string `json:"title"`
+	}
+
+	video, ok := cfg.authorizedVideo(w, r, permManage)
+	if !ok {
+		return
+	}
+	if !video.Playable(time.Now()) {
+		respondVideoArchived(w, video)
+		return
+	}
+
+	params := parameters{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil && !errors.Is(err, io.EOF) {
+			respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+			return
+		}
+	}
+	if params.Title == "" {
+		params.Title = "Copy of " + video.Title
+	}
+
+	plan, err := cfg.videoPlan(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	renditions, err := cfg.db.GetVideoRenditions(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get renditions", err)
+		return
+	}
+	size := video.VideoSize + video.ThumbnailSize
+	for _, r := range renditions {
+		size += r.Size
+	}
+	if err := cfg.checkVideoQuota(plan, video, 0, size); err != nil {
+		if errors.Is(err, errStorageQuotaExceeded) {
+			respondWithErrorCode(w, http.StatusForbidden, errCodeQuotaExceeded, "Storage quota exceeded", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check storage quota", err)
+		return
+	}
+
+	video.Title = params.Title
+	copied, err := cfg.copyVideo(r, video, renditions)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't copy video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, copied)
+}
+
+// copyVideo creates a new video row from src, copying its video object,
+// renditions, caption tracks, chapters and thumbnail under new names.
+// Scheduling, expiry and retention aren't carried over.
+func (cfg *apiConfig) copyVideo(r *http.Request, src database.Video, renditions []database.VideoRendition) (database.Video, error) {
+	ctx := r.Context()
+	dst, err := cfg.db.CreateVideo(src.CreateVideoParams)
+	if err != nil {
+		return database.Video{}, fmt.Errorf("couldn't create video: %w", err)
+	}
+	cfg.domainEvents.Publish(domainVideoCreated, dst.ID, newVideoDomainEvent(dst))
+
+	var objects int64
+	var copiedRenditions []database.VideoRendition
+	if src.VideoKey != nil {
+		// No content hash: a copy gets objects of its own rather than
+		// sharing the original's, which its renditions couldn't do.
+		key := cfg.keys.objectKey(objectKeyParams{
+			kind:    cmp.Or(src.AspectRatio, path.Base(path.Dir(*src.VideoKey))),
+			userID:  dst.UserID,
+			videoID: dst.ID,
+			ext:     path.Ext(*src.VideoKey),
+			now:     time.Now(),
+		})
+		if err := cfg.store.Copy(ctx, *src.VideoKey, key); err != nil {
+			cfg.abandonCopy(ctx, dst)
+			return database.Video{}, fmt.Errorf("couldn't copy video object: %w", err)
+		}
+		info, err := cfg.store.Stat(ctx, key)
+		if err != nil {
+			cfg.store.Delete(context.WithoutCancel(ctx), key)
+			cfg.abandonCopy(ctx, dst)
+			return database.Video{}, fmt.Errorf("couldn't stat copied object: %w", err)
+		}
+		videoURL := cfg.store.URL(key)
+		dst.VideoKey = &key
+		dst.VideoURL = &videoURL
+		dst.VideoSize = info.Size
+		dst.VideoChecksum = info.ChecksumSHA256
+		dst.VideoETag = info.ETag
+		dst.VideoVersionID = info.VersionID
+		objects++
+
+		for _, rendition := range renditions {
+			renditionKey := renditionKey(key, renditionTarget{width: rendition.Width, height: rendition.Height, hdr: rendition.HDR})
+			if err := cfg.store.Copy(ctx, rendition.Key, renditionKey); err != nil {
+				ctx := context.WithoutCancel(ctx)
+				for _, copied := range copiedRenditions {
+					cfg.deleteRenditionObject(ctx, dst.ID, copied.Key)
+				}
+				cfg.store.Delete(ctx, key)
+				cfg.abandonCopy(ctx, dst)
+				return database.Video{}, fmt.Errorf("couldn't copy %s rendition: %w", rendition.Name, err)
+			}
+			rendition.VideoID = dst.ID
+			rendition.Key = renditionKey
+			copiedRenditions = append(copiedRenditions, rendition)
+		}
+	}
+
+	if src.ThumbnailURL != nil {
+		srcName := path.Base(*src.ThumbnailURL)
+		dstName := newAssetName() + path.Ext(srcName)
+		size, err := copyFile(cfg.getAssetDiskPath(srcName), cfg.getAssetDiskPath(dstName))
+		if err != nil {
+			log.Printf("Couldn't copy thumbnail of video %s: %v", src.ID, err)
+		} else {
+			thumbnailURL := cfg.getAssetURL(dstName)
+			dst.ThumbnailURL = &thumbnailURL
+			dst.ThumbnailSize = size
+			objects++
+		}
+	}
+
+	if err := cfg.db.UpdateVideo(dst); err != nil {
+		return database.Video{}, fmt.Errorf("couldn't update video: %w", err)
+	}
+	cfg.recordVideoVersion(dst)
+	if err := cfg.adjustVideoUsage(dst, dst.VideoSize+dst.ThumbnailSize, objects); err != nil {
+		return database.Video{}, fmt.Errorf("couldn't update storage usage: %w", err)
+	}
+	if len(copiedRenditions) > 0 {
+		if err := cfg.saveVideoRenditions(ctx, dst, copiedRenditions); err != nil {
+			return database.Video{}, err
+		}
+	}
+	if err := cfg.copyCaptions(ctx, src.ID, dst.ID); err != nil {
+		return database.Video{}, err
+	}
+
+	chapters, err := cfg.db.GetChapters(src.ID)
+	if err != nil {
+		return database.Video{}, fmt.Errorf("couldn't get chapters: %w", err)
+	}
+	for _, chapter := range chapters {
+		_, err := cfg.db.CreateChapter(database.CreateChapterParams{
+			VideoID:      dst.ID,
+			StartSeconds: chapter.StartSeconds,
+			Title:        chapter.Title,
+			Source:       chapter.Source,
+		})
+		if err != nil {
+			return database.Video{}, fmt.Errorf("couldn't copy chapter: %w", err)
+		}
+	}
+	return dst, nil
+}
+
+// copyCaptions gives the video dstID its own copy of each of srcID's
+// caption tracks, searchable like the originals.
+func (cfg *apiConfig) copyCaptions(ctx context.Context, srcID, dstID uuid.UUID) error {
+	captions, err := cfg.db.GetCaptions(srcID)
+	if err != nil {
+		return fmt.Errorf("couldn't get captions: %w", err)
+	}
+	for _, caption := range captions {
+		text, err := cfg.db.GetCaptionText(srcID, caption.Language)
+		if err != nil {
+			return fmt.Errorf("couldn't get %s captions: %w", caption.Language, err)
+		}
+		body, err := cfg.store.Get(ctx, caption.Key)
+		if err != nil {
+			return fmt.Errorf("couldn't read %s captions: %w", caption.Language, err)
+		}
+		err = cfg.saveCaptionTrack(ctx, dstID, caption.Language, caption.Source, body, text)
+		body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// abandonCopy removes the row of a copy that couldn't be completed.
+func (cfg *apiConfig) abandonCopy(ctx context.Context, video database.Video) {
+	if err := cfg.deleteVideo(context.WithoutCancel(ctx), video); err != nil {
+		log.Printf("Couldn't remove incomplete copy %s: %v", video.ID, err)
+	}
+}
+
+func copyFile(src, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return n, err
+}
@@ -1,17 +1,36 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
 	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Request) {
+	// Fields left out fall back to the user's upload settings.
 	type parameters struct {
-		database.CreateVideoParams
+		Title         string     `json:"title"`
+		Description   string     `json:"description"`
+		Visibility    string     `json:"visibility"`
+		PresetID      string     `json:"preset_id"`
+		AutoThumbnail *bool      `json:"auto_thumbnail"`
+		Watermark     *bool      `json:"watermark"`
+		FolderID      *uuid.UUID `json:"folder_id"`
+		OrgID         *uuid.UUID `json:"org_id"`
 	}
 
 	token, err := auth.GetBearerToken(r.Header)
@@ -19,7 +38,7 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := cfg.validateJWT(token)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
@@ -29,16 +48,84 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 	params := parameters{}
 	err = decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
 		return
 	}
-	params.UserID = userID
 
-	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
+	settings, err := cfg.db.GetUserSettings(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get settings", err)
+		return
+	}
+	// A watermark default saved before WATERMARK_IMAGE was unset is
+	// dropped rather than failing every upload.
+	create := database.CreateVideoParams{
+		Title:         params.Title,
+		Description:   params.Description,
+		UserID:        userID,
+		Visibility:    cmp.Or(params.Visibility, settings.Visibility),
+		PresetID:      cmp.Or(params.PresetID, settings.PresetID),
+		AutoThumbnail: settings.AutoThumbnail,
+		Watermark:     settings.Watermark && cfg.watermarkImage != "",
+		FolderID:      params.FolderID,
+		OrgID:         params.OrgID,
+	}
+	if params.AutoThumbnail != nil {
+		create.AutoThumbnail = *params.AutoThumbnail
+	}
+	if params.Watermark != nil {
+		create.Watermark = *params.Watermark
+	}
+
+	var details []errorDetail
+	if !database.ValidVisibility(create.Visibility) {
+		details = append(details, errorDetail{Field: "visibility", Message: "must be public, unlisted or private"})
+	}
+	if create.PresetID != "" {
+		problem, err := cfg.checkUserPreset(userID, create.PresetID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check preset", err)
+			return
+		}
+		if problem != "" {
+			details = append(details, errorDetail{Field: "preset_id", Message: problem})
+		}
+	}
+	problem, err := cfg.checkFolder(userID, create.FolderID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get folder", err)
+		return
+	}
+	if problem != "" {
+		details = append(details, errorDetail{Field: "folder_id", Message: problem})
+	}
+	problem, err = cfg.checkOrganization(userID, create.OrgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization", err)
+		return
+	}
+	if problem != "" {
+		details = append(details, errorDetail{Field: "org_id", Message: problem})
+	}
+	if create.Watermark && cfg.watermarkImage == "" {
+		details = append(details, errorDetail{Field: "watermark", Message: watermarkDisabledMessage})
+	}
+	if len(details) > 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid video",
+			Details: details,
+		})
+		return
+	}
+
+	video, err := cfg.db.CreateVideo(create)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
 	}
+	cfg.domainEvents.Publish(domainVideoCreated, video.ID, newVideoDomainEvent(video))
 
 	respondWithJSON(w, http.StatusCreated, video)
 }
@@ -47,7 +134,7 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid ID", err)
 		return
 	}
 
@@ -56,7 +143,7 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := cfg.validateJWT(token)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
@@ -67,12 +154,15 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
+	if !cfg.checkVideoAccess(w, video, userID, permManage, "You can't delete this video") {
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	err = cfg.deleteVideo(r.Context(), video)
+	if errors.Is(err, errVideoLocked) {
+		respondWithErrorCode(w, http.StatusConflict, errCodeObjectLocked, "Video is under retention or legal hold", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
@@ -85,16 +175,199 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid video ID", err)
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.getVideo(r.Context(), videoID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
+	if video.ID == uuid.Nil {
+		respondWithErrorCode(w, http.StatusNotFound, errCodeVideoNotFound, "Video not found", nil)
+		return
+	}
+	if !cfg.checkPrivateVideo(w, r, video) {
+		return
+	}
+
+	chapters, err := cfg.db.GetChapters(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chapters", err)
+		return
+	}
+	renditions, err := cfg.db.GetThumbnailRenditions(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail renditions", err)
+		return
+	}
+	framing, err := cfg.db.GetThumbnailFraming(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail framing", err)
+		return
+	}
+
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusOK, struct {
+		database.Video
+		Chapters            []database.Chapter           `json:"chapters"`
+		ThumbnailFraming    json.RawMessage              `json:"thumbnail_framing,omitempty"`
+		ThumbnailRenditions []thumbnailRenditionResponse `json:"thumbnail_renditions"`
+	}{video, chapters, framing, cfg.thumbnailRenditionResponses(renditions)})
+}
+
+const (
+	maxVideoTitleLength       = 200
+	maxVideoDescriptionLength = 5000
+)
 
+// videoETag identifies the revision of the video row. It's weak because
+// the responses it's sent with also carry data kept outside the row.
+func videoETag(video database.Video) string {
+	return fmt.Sprintf(`W/"%d"`, video.Revision)
+}
+
+// ifMatchRevision returns the revision named in the If-Match header, if
+// there is one. "*" matches any revision.
+func ifMatchRevision(h http.Header) (revision int64, ok bool, err error) {
+	value := strings.TrimSpace(h.Get("If-Match"))
+	if value == "" || value == "*" {
+		return 0, false, nil
+	}
+	// Only one revision can be current, so a list is no more useful than
+	// its first entry.
+	tag, _, _ := strings.Cut(value, ",")
+	tag = strings.Trim(strings.TrimPrefix(strings.TrimSpace(tag), "W/"), `"`)
+	revision, err = strconv.ParseInt(tag, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid If-Match header %q", value)
+	}
+	return revision, true, nil
+}
+
+// videoEdits are the owner-editable fields of a video, as sent to PATCH
+// /api/videos/{videoID} or in the metadata.json of an archive upload.
+type videoEdits struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Visibility  *string `json:"visibility"`
+}
+
+// apply validates the edits and makes them on video, returning what
+// changed for the audit log.
+func (e videoEdits) apply(video *database.Video) (map[string]any, []errorDetail) {
+	changes := map[string]any{}
+	var details []errorDetail
+	if e.Title != nil {
+		title := strings.TrimSpace(*e.Title)
+		switch {
+		case title == "":
+			details = append(details, errorDetail{Field: "title", Message: "can't be empty"})
+		case len(title) > maxVideoTitleLength:
+			details = append(details, errorDetail{Field: "title", Message: fmt.Sprintf("must be at most %d characters", maxVideoTitleLength)})
+		case title != video.Title:
+			changes["title"] = map[string]string{"from": video.Title, "to": title}
+			video.Title = title
+		}
+	}
+	if e.Description != nil {
+		switch {
+		case len(*e.Description) > maxVideoDescriptionLength:
+			details = append(details, errorDetail{Field: "description", Message: fmt.Sprintf("must be at most %d characters", maxVideoDescriptionLength)})
+		case *e.Description != video.Description:
+			// Descriptions can be long, so only the fact it changed is
+			// recorded.
+			changes["description"] = true
+			video.Description = *e.Description
+		}
+	}
+	if e.Visibility != nil {
+		switch {
+		case !database.ValidVisibility(*e.Visibility):
+			details = append(details, errorDetail{Field: "visibility", Message: "must be public, unlisted or private"})
+		case *e.Visibility != video.Visibility || video.PublishAt != nil:
+			changes["visibility"] = map[string]string{"from": video.Visibility, "to": *e.Visibility}
+			video.Visibility = *e.Visibility
+			// Choosing a visibility overrides a pending scheduled
+			// publication.
+			video.PublishAt = nil
+		}
+	}
+	return changes, details
+}
+
+// handlerVideoMetaUpdate changes a video's title, description and
+// visibility. Fields left out are kept. Clients guard against overwriting
+// someone else's change by sending the ETag they read in If-Match, or the
+// updated_at they read in the body; either way a stale write gets 412.
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		videoEdits
+		UpdatedAt *time.Time `json:"updated_at"`
+	}
+
+	video, userID, ok := cfg.authorizedVideoUser(w, r, permEdit)
+	if !ok {
+		return
+	}
+
+	revision, conditional, err := ifMatchRevision(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid If-Match header", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+
+	stale := conditional && revision != video.Revision
+	if params.UpdatedAt != nil && !params.UpdatedAt.Equal(video.UpdatedAt) {
+		stale = true
+	}
+	if stale {
+		w.Header().Set("ETag", videoETag(video))
+		respondWithErrorCode(w, http.StatusPreconditionFailed, errCodeVideoChanged, "Video was changed since it was read", nil)
+		return
+	}
+
+	changes, details := params.apply(&video)
+	if len(details) > 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid video",
+			Details: details,
+		})
+		return
+	}
+
+	if len(changes) > 0 {
+		err = cfg.db.UpdateVideoRevision(video, video.Revision)
+		if errors.Is(err, database.ErrVideoChanged) {
+			respondWithErrorCode(w, http.StatusPreconditionFailed, errCodeVideoChanged, "Video was changed since it was read", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+		cfg.audit(auditUser(userID), "video.update", auditSubjectVideo, video.ID.String(), changes)
+		if _, ok := changes["visibility"]; ok || video.Visibility == database.VisibilityPublic {
+			cfg.refreshSitemap()
+		}
+
+		video, err = cfg.db.GetVideo(video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+	}
+
+	w.Header().Set("ETag", videoETag(video))
 	respondWithJSON(w, http.StatusOK, video)
 }
 
@@ -104,17 +377,87 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := cfg.validateJWT(token)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	params, err := pagination.ParseRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid pagination parameters", err)
+		return
+	}
+
+	videos, page, err := cfg.db.GetVideosPage(&userID, params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
 
+	pagination.WriteHeaders(w, page)
 	respondWithJSON(w, http.StatusOK, videos)
 }
+
+// deleteVideo removes the video row along with its stored objects and
+// releases the storage usage of its owner or organization. Failing to remove an object is only
+// logged, so a missing file never blocks deleting the row. Once the row is
+// gone the cleanup runs to completion even if the caller disconnects.
+func (cfg *apiConfig) deleteVideo(ctx context.Context, video database.Video) error {
+	if video.Locked(time.Now()) {
+		return errVideoLocked
+	}
+	captions, err := cfg.db.GetCaptions(video.ID)
+	if err != nil {
+		return err
+	}
+	candidates, err := cfg.db.GetThumbnailCandidates(video.ID)
+	if err != nil {
+		return err
+	}
+	renditions, err := cfg.db.GetThumbnailRenditions(video.ID)
+	if err != nil {
+		return err
+	}
+	videoRenditions, err := cfg.db.GetVideoRenditions(video.ID)
+	if err != nil {
+		return err
+	}
+	if err := cfg.db.DeleteVideo(video.ID); err != nil {
+		return err
+	}
+	if video.Visibility == database.VisibilityPublic {
+		cfg.refreshSitemap()
+	}
+	cfg.domainEvents.Publish(domainVideoDeleted, video.ID, newVideoDomainEvent(video))
+	ctx = context.WithoutCancel(ctx)
+	cfg.removeThumbnailCandidates(candidates)
+	cfg.removeThumbnailRenditions(renditions)
+
+	for _, caption := range captions {
+		if err := cfg.store.Delete(ctx, caption.Key); err != nil {
+			log.Printf("Couldn't delete caption object %s: %v", caption.Key, err)
+		}
+	}
+
+	bytesFreed, objectsFreed := renditionsSize(renditions), int64(len(renditions))
+	for _, r := range videoRenditions {
+		cfg.deleteRenditionObject(ctx, video.ID, r.Key)
+		bytesFreed += r.Size
+		objectsFreed++
+	}
+	if video.VideoKey != nil {
+		cfg.deleteVideoObject(ctx, *video.VideoKey)
+		bytesFreed += video.VideoSize
+		objectsFreed++
+	}
+	if video.ThumbnailURL != nil {
+		err := os.Remove(cfg.getAssetDiskPath(path.Base(*video.ThumbnailURL)))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Couldn't delete thumbnail for video %s: %v", video.ID, err)
+		}
+		bytesFreed += video.ThumbnailSize
+		objectsFreed++
+	}
+	return cfg.adjustVideoUsage(video, -bytesFreed, -objectsFreed)
+}
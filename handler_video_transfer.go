@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerVideoTransferCreate offers the video to the user with the given
+// email. The video stays put until they accept. The response is the same
+// whether or not the email belongs to an account that can receive the
+// video, so it can't be used to find out who has one; the sender sees the
+// offer in their pending transfers when it was made.
+func (cfg *apiConfig) handlerVideoTransferCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+	type response struct {
+		VideoID   uuid.UUID `json:"video_id"`
+		Email     string    `json:"email"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	video, ok := cfg.authorizedVideo(w, r, permManage)
+	if !ok {
+		return
+	}
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+	// Granted owners can manage the video but it isn't theirs to give.
+	if userID != video.UserID {
+		respondWithErrorCode(w, http.StatusForbidden, errCodeNotOwner, "Only the video's uploader can transfer it", nil)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+	email := strings.TrimSpace(params.Email)
+	invalid := func(field, problem string) {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid transfer",
+			Details: []errorDetail{{Field: field, Message: problem}},
+		})
+	}
+	if email == "" {
+		invalid("email", "an email is required")
+		return
+	}
+	if video.OrgID != nil {
+		invalid("video_id", "videos in an organization belong to it and can't be transferred")
+		return
+	}
+
+	// Whether the video already has an offer out doesn't depend on the
+	// recipient, so it's checked before looking them up.
+	now := time.Now()
+	pending, err := cfg.db.GetPendingVideoTransfers(video.UserID, now)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get transfers", err)
+		return
+	}
+	for _, transfer := range pending {
+		if transfer.VideoID == video.ID {
+			respondWithError(w, http.StatusConflict, "Video already has a pending transfer", database.ErrTransferPending)
+			return
+		}
+	}
+
+	recipient, err := cfg.db.GetUserByEmail(email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	// The caller knows their own email, so this gives nothing away.
+	if recipient.ID == video.UserID {
+		invalid("email", "you already own this video")
+		return
+	}
+
+	resp := response{VideoID: video.ID, Email: email, ExpiresAt: now.Add(envDuration("VIDEO_TRANSFER_TTL", 7*24*time.Hour)).UTC()}
+	if recipient.ID == uuid.Nil || recipient.SuspendedAt != nil {
+		respondWithJSON(w, http.StatusAccepted, resp)
+		return
+	}
+
+	transfer, err := cfg.db.CreateVideoTransfer(database.CreateVideoTransferParams{
+		VideoID:    video.ID,
+		FromUserID: video.UserID,
+		ToUserID:   recipient.ID,
+		ExpiresAt:  resp.ExpiresAt,
+	})
+	if errors.Is(err, database.ErrTransferPending) {
+		respondWithError(w, http.StatusConflict, "Video already has a pending transfer", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create transfer", err)
+		return
+	}
+	cfg.audit(auditUser(video.UserID), "video.transfer_offer", auditSubjectVideo, video.ID.String(), map[string]any{
+		"transfer_id": transfer.ID,
+		"to_user_id":  recipient.ID,
+	})
+
+	respondWithJSON(w, http.StatusAccepted, resp)
+}
+
+// handlerVideoTransfersList lists the pending transfers the user has
+// offered or been offered.
+func (cfg *apiConfig) handlerVideoTransfersList(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	transfers, err := cfg.db.GetPendingVideoTransfers(userID, time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get transfers", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, transfers)
+}
+
+// userTransfer loads the transfer named in the path if the caller is the
+// sender or the recipient, responding if not.
+func (cfg *apiConfig) userTransfer(w http.ResponseWriter, r *http.Request) (database.VideoTransfer, uuid.UUID, bool) {
+	transferID, err := uuid.Parse(r.PathValue("transferID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid ID", err)
+		return database.VideoTransfer{}, uuid.Nil, false
+	}
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return database.VideoTransfer{}, uuid.Nil, false
+	}
+	transfer, err := cfg.db.GetVideoTransfer(transferID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get transfer", err)
+		return database.VideoTransfer{}, uuid.Nil, false
+	}
+	if transfer.ID == uuid.Nil || (transfer.FromUserID != userID && transfer.ToUserID != userID) {
+		respondWithError(w, http.StatusNotFound, "Transfer not found", nil)
+		return database.VideoTransfer{}, uuid.Nil, false
+	}
+	if transfer.Status != database.TransferPending || !time.Now().Before(transfer.ExpiresAt) {
+		respondWithError(w, http.StatusConflict, "Transfer is no longer pending", nil)
+		return database.VideoTransfer{}, uuid.Nil, false
+	}
+	return transfer, userID, true
+}
+
+// handlerVideoTransferAccept moves the video, and the storage it takes up,
+// to the recipient.
+func (cfg *apiConfig) handlerVideoTransferAccept(w http.ResponseWriter, r *http.Request) {
+	transfer, userID, ok := cfg.userTransfer(w, r)
+	if !ok {
+		return
+	}
+	if userID != transfer.ToUserID {
+		respondWithErrorCode(w, http.StatusForbidden, errCodeForbidden, "Only the recipient can accept a transfer", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(transfer.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.OrgID != nil {
+		respondWithErrorCode(w, http.StatusConflict, errCodeConflict, "Video was moved into an organization after the transfer was offered", nil)
+		return
+	}
+	bytes, objects, err := cfg.videoUsage(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video usage", err)
+		return
+	}
+
+	plan, err := cfg.db.GetUserPlan(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	if err := cfg.checkStorageQuota(plan, userID, 0, bytes); err != nil {
+		respondWithAPIError(w, *quotaError(err))
+		return
+	}
+
+	err = cfg.db.AcceptVideoTransfer(transfer, bytes, objects)
+	if errors.Is(err, database.ErrTransferResolved) || errors.Is(err, database.ErrVideoChanged) {
+		respondWithError(w, http.StatusConflict, "Transfer is no longer pending", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't accept transfer", err)
+		return
+	}
+	cfg.audit(auditUser(userID), "video.transfer", auditSubjectVideo, video.ID.String(), map[string]any{
+		"transfer_id":  transfer.ID,
+		"from_user_id": transfer.FromUserID,
+		"to_user_id":   transfer.ToUserID,
+	})
+
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	video = cfg.rekeyTransferredVideo(r.Context(), video, transfer.FromUserID)
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func (cfg *apiConfig) handlerVideoTransferDecline(w http.ResponseWriter, r *http.Request) {
+	transfer, userID, ok := cfg.userTransfer(w, r)
+	if !ok {
+		return
+	}
+	if userID != transfer.ToUserID {
+		respondWithErrorCode(w, http.StatusForbidden, errCodeForbidden, "Only the recipient can decline a transfer", nil)
+		return
+	}
+	cfg.resolveVideoTransfer(w, transfer, database.TransferDeclined)
+}
+
+func (cfg *apiConfig) handlerVideoTransferCancel(w http.ResponseWriter, r *http.Request) {
+	transfer, userID, ok := cfg.userTransfer(w, r)
+	if !ok {
+		return
+	}
+	if userID != transfer.FromUserID {
+		respondWithErrorCode(w, http.StatusForbidden, errCodeForbidden, "Only the sender can cancel a transfer", nil)
+		return
+	}
+	cfg.resolveVideoTransfer(w, transfer, database.TransferCancelled)
+}
+
+func (cfg *apiConfig) resolveVideoTransfer(w http.ResponseWriter, transfer database.VideoTransfer, status string) {
+	transfer, err := cfg.db.ResolveVideoTransfer(transfer.ID, status)
+	if errors.Is(err, database.ErrTransferResolved) {
+		respondWithError(w, http.StatusConflict, "Transfer is no longer pending", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update transfer", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, transfer)
+}
+
+// videoUsage is how much storage usage the video accounts for, whether
+// its owner or its organization pays for it, counted the same way
+// deleteVideo releases it.
+func (cfg *apiConfig) videoUsage(video database.Video) (bytes, objects int64, err error) {
+	renditions, err := cfg.db.GetThumbnailRenditions(video.ID)
+	if err != nil {
+		return 0, 0, err
+	}
+	bytes, objects = renditionsSize(renditions), int64(len(renditions))
+	videoRenditions, err := cfg.db.GetVideoRenditions(video.ID)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, r := range videoRenditions {
+		bytes += r.Size
+		objects++
+	}
+	if video.VideoKey != nil {
+		bytes += video.VideoSize
+		objects++
+	}
+	if video.ThumbnailURL != nil {
+		bytes += video.ThumbnailSize
+		objects++
+	}
+	return bytes, objects, nil
+}
+
+// rekeyTransferredVideo moves the objects of a video stored under the
+// previous owner's users/<id>/ prefix to the new owner's: the video, its
+// renditions and its caption tracks. Thumbnails, their renditions and
+// candidates are asset files whose names don't carry the owner, so they
+// stay where they are. It's best effort: anything that can't be moved
+// keeps working from its old key, and a locked or archived video is left
+// alone since a copy would lose the lock or can't be read.
+func (cfg *apiConfig) rekeyTransferredVideo(ctx context.Context, video database.Video, previousOwner uuid.UUID) database.Video {
+	oldPrefix := fmt.Sprintf("users/%s/", previousOwner)
+	newPrefix := fmt.Sprintf("users/%s/", video.UserID)
+	rekey := func(key string) (string, bool) {
+		if !strings.HasPrefix(key, oldPrefix) && !strings.Contains(key, "/"+oldPrefix) {
+			return key, false
+		}
+		return strings.Replace(key, oldPrefix, newPrefix, 1), true
+	}
+	if video.Locked(time.Now()) || !video.Playable(time.Now()) {
+		log.Printf("Leaving transferred video %s where it is: objects are locked or archived", video.ID)
+		return video
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	oldKey := video.VideoKey
+	updated := cfg.rekeyTransferredObject(ctx, video, rekey)
+	// Renditions are stored next to the video object; while another video
+	// still shares that, it shares them too.
+	keepOld := false
+	if oldKey != nil {
+		sharing, err := cfg.videosSharingKey(*oldKey, video.ID)
+		if err != nil {
+			log.Printf("Couldn't check whether video object %s is shared: %v", *oldKey, err)
+		}
+		keepOld = err != nil || len(sharing) > 0
+	}
+	cfg.rekeyTransferredRenditions(ctx, video.ID, rekey, keepOld)
+	cfg.rekeyTransferredCaptions(ctx, video.ID, rekey)
+
+	reloaded, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		log.Printf("Couldn't reload transferred video %s: %v", video.ID, err)
+		return updated
+	}
+	return reloaded
+}
+
+// rekeyTransferredObject moves the video object itself and points the row
+// at the new key.
+func (cfg *apiConfig) rekeyTransferredObject(ctx context.Context, video database.Video, rekey func(string) (string, bool)) database.Video {
+	if video.VideoKey == nil {
+		return video
+	}
+	oldKey := *video.VideoKey
+	newKey, ok := rekey(oldKey)
+	if !ok {
+		return video
+	}
+	if err := cfg.store.Copy(ctx, oldKey, newKey); err != nil {
+		log.Printf("Couldn't move transferred video %s to %s: %v", video.ID, newKey, err)
+		return video
+	}
+	info, err := cfg.store.Stat(ctx, newKey)
+	if err != nil {
+		log.Printf("Couldn't stat transferred video %s at %s: %v", video.ID, newKey, err)
+		return video
+	}
+
+	updated := video
+	videoURL := cfg.keys.versionedURL(cfg.store.URL(newKey), info)
+	updated.VideoKey = &newKey
+	updated.VideoURL = &videoURL
+	updated.VideoETag = info.ETag
+	updated.VideoVersionID = info.VersionID
+	if info.ChecksumSHA256 != "" {
+		updated.VideoChecksum = info.ChecksumSHA256
+	}
+	if err := cfg.db.UpdateVideo(updated); err != nil {
+		log.Printf("Couldn't point transferred video %s at %s: %v", video.ID, newKey, err)
+		cfg.deleteVideoObject(ctx, newKey)
+		return video
+	}
+	cfg.recordVideoVersion(updated)
+	cfg.deleteVideoObject(ctx, oldKey)
+	return updated
+}
+
+// rekeyTransferredRenditions moves the video's renditions, deleting the
+// old objects unless keepOld is set.
+func (cfg *apiConfig) rekeyTransferredRenditions(ctx context.Context, videoID uuid.UUID, rekey func(string) (string, bool), keepOld bool) {
+	renditions, err := cfg.db.GetVideoRenditions(videoID)
+	if err != nil {
+		log.Printf("Couldn't get renditions of transferred video %s: %v", videoID, err)
+		return
+	}
+	// moved maps the old key of each copied rendition to its new one.
+	moved := map[string]string{}
+	for i, r := range renditions {
+		newKey, ok := rekey(r.Key)
+		if !ok {
+			continue
+		}
+		if err := cfg.store.Copy(ctx, r.Key, newKey); err != nil {
+			log.Printf("Couldn't move rendition %s of transferred video %s: %v", r.Key, videoID, err)
+			continue
+		}
+		moved[r.Key] = newKey
+		renditions[i].Key = newKey
+	}
+	if len(moved) == 0 {
+		return
+	}
+	if _, err := cfg.db.SetVideoRenditions(videoID, renditions); err != nil {
+		log.Printf("Couldn't point renditions of transferred video %s at their new keys: %v", videoID, err)
+		for _, newKey := range moved {
+			cfg.deleteRenditionObject(ctx, videoID, newKey)
+		}
+		return
+	}
+	if keepOld {
+		return
+	}
+	for oldKey := range moved {
+		cfg.deleteRenditionObject(ctx, videoID, oldKey)
+	}
+}
+
+// rekeyTransferredCaptions moves the video's caption tracks one by one,
+// keeping each track's text.
+func (cfg *apiConfig) rekeyTransferredCaptions(ctx context.Context, videoID uuid.UUID, rekey func(string) (string, bool)) {
+	captions, err := cfg.db.GetCaptions(videoID)
+	if err != nil {
+		log.Printf("Couldn't get captions of transferred video %s: %v", videoID, err)
+		return
+	}
+	for _, c := range captions {
+		newKey, ok := rekey(c.Key)
+		if !ok {
+			continue
+		}
+		text, err := cfg.db.GetCaptionText(videoID, c.Language)
+		if err != nil {
+			log.Printf("Couldn't get %s captions of transferred video %s: %v", c.Language, videoID, err)
+			continue
+		}
+		if err := cfg.store.Copy(ctx, c.Key, newKey); err != nil {
+			log.Printf("Couldn't move %s captions of transferred video %s: %v", c.Language, videoID, err)
+			continue
+		}
+		oldKey := c.Key
+		c.Key, c.URL = newKey, cfg.store.URL(newKey)
+		if err := cfg.db.SetCaption(c, text); err != nil {
+			log.Printf("Couldn't point %s captions of transferred video %s at %s: %v", c.Language, videoID, newKey, err)
+			if err := cfg.store.Delete(ctx, newKey); err != nil {
+				log.Printf("Couldn't delete caption object %s: %v", newKey, err)
+			}
+			continue
+		}
+		if err := cfg.store.Delete(ctx, oldKey); err != nil {
+			log.Printf("Couldn't delete caption object %s: %v", oldKey, err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+type videoVersionResponse struct {
+	database.VideoVersion
+	Current bool `json:"current"`
+}
+
+// recordVideoVersion remembers the object version the video points at, so
+// it can be restored after the video is replaced. Stores without
+// versioning report no version and nothing is recorded.
+func (cfg *apiConfig) recordVideoVersion(video database.Video) {
+	if video.VideoKey == nil || video.VideoVersionID == "" {
+		return
+	}
+	err := cfg.db.AddVideoVersion(database.VideoVersion{
+		VideoID:   video.ID,
+		VersionID: video.VideoVersionID,
+		Key:       *video.VideoKey,
+		ETag:      video.VideoETag,
+		Checksum:  video.VideoChecksum,
+		Size:      video.VideoSize,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("Couldn't record version %s of video %s: %v", video.VideoVersionID, video.ID, err)
+	}
+}
+
+// handlerVideoVersionsList lists the object versions the video has had,
+// newest first.
+func (cfg *apiConfig) handlerVideoVersionsList(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizedVideo(w, r, permEdit)
+	if !ok {
+		return
+	}
+
+	versions, err := cfg.db.GetVideoVersions(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get versions", err)
+		return
+	}
+	resp := make([]videoVersionResponse, 0, len(versions))
+	for _, v := range versions {
+		current := video.VideoKey != nil && *video.VideoKey == v.Key && video.VideoVersionID == v.VersionID
+		resp = append(resp, videoVersionResponse{VideoVersion: v, Current: current})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerVideoVersionRestore points the video back at an earlier version
+// of its file without anyone uploading it again. The version is copied
+// into place as a new current version of its key, and the object the
+// video pointed at until now is retired like on any other replacement.
+func (cfg *apiConfig) handlerVideoVersionRestore(w http.ResponseWriter, r *http.Request) {
+	versioner, ok := cfg.store.(storage.Versioner)
+	if !ok {
+		respondWithErrorCode(w, http.StatusNotImplemented, errCodeNotImplemented, "The storage backend doesn't keep versions", nil)
+		return
+	}
+
+	video, userID, ok := cfg.authorizedVideoUser(w, r, permEdit)
+	if !ok {
+		return
+	}
+
+	version, err := cfg.db.GetVideoVersion(video.ID, r.PathValue("versionID"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get version", err)
+		return
+	}
+	if version.VideoID == uuid.Nil {
+		respondWithErrorCode(w, http.StatusNotFound, errCodeNotFound, "Version not found", nil)
+		return
+	}
+	if video.VideoKey != nil && *video.VideoKey == version.Key && video.VideoVersionID == version.VersionID {
+		respondWithJSON(w, http.StatusOK, video)
+		return
+	}
+
+	plan, err := cfg.videoPlan(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	if err := cfg.checkVideoQuota(plan, video, video.VideoSize, version.Size); err != nil {
+		respondWithAPIError(w, *quotaError(err))
+		return
+	}
+
+	info, err := versioner.RestoreVersion(r.Context(), version.Key, version.VersionID)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		respondWithErrorCode(w, http.StatusGone, errCodeNotFound, "Version no longer exists in the bucket", err)
+		return
+	case errors.Is(err, storage.ErrArchived):
+		respondWithErrorCode(w, http.StatusConflict, errCodeVideoArchived, "Version is archived and can't be copied", err)
+		return
+	case err != nil:
+		cfg.reportStorageError("restore_version", version.Key, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore version", err)
+		return
+	}
+
+	video, err = cfg.attachVideoObject(r.Context(), video, version.Key, info)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.audit(auditUser(userID), "video.version_restore", auditSubjectVideo, video.ID.String(), map[string]any{
+		"version_id":     version.VersionID,
+		"new_version_id": info.VersionID,
+	})
+	respondWithJSON(w, http.StatusOK, video)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
+	"github.com/google/uuid"
+)
+
+// Playback event types players report.
+const (
+	playbackEventPlay     = "play"
+	playbackEventProgress = "progress"
+	playbackEventPause    = "pause"
+	playbackEventSeek     = "seek"
+	playbackEventEnded    = "ended"
+)
+
+// completedFraction is how far into a video counts as having finished it,
+// so skipping the credits still clears it from "continue watching".
+const completedFraction = 0.95
+
+// handlerVideoEvents records playback events for the caller. Only the
+// latest position per video is kept.
+func (cfg *apiConfig) handlerVideoEvents(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Type            string  `json:"type"`
+		PositionSeconds float64 `json:"position_seconds"`
+		DurationSeconds float64 `json:"duration_seconds"`
+	}
+
+	video, userID, ok := cfg.watchableVideo(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+	var details []errorDetail
+	switch params.Type {
+	case playbackEventPlay, playbackEventProgress, playbackEventPause, playbackEventSeek, playbackEventEnded:
+	default:
+		details = append(details, errorDetail{Field: "type", Message: "must be play, progress, pause, seek or ended"})
+	}
+	if params.PositionSeconds < 0 {
+		details = append(details, errorDetail{Field: "position_seconds", Message: "must not be negative"})
+	}
+	if params.DurationSeconds < 0 {
+		details = append(details, errorDetail{Field: "duration_seconds", Message: "must not be negative"})
+	}
+	if len(details) > 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid playback event",
+			Details: details,
+		})
+		return
+	}
+
+	pos := database.WatchPosition{
+		VideoID:         video.ID,
+		PositionSeconds: params.PositionSeconds,
+		DurationSeconds: params.DurationSeconds,
+	}
+	if params.Type == playbackEventEnded {
+		pos.Completed = true
+		pos.PositionSeconds = max(pos.PositionSeconds, pos.DurationSeconds)
+	} else if pos.DurationSeconds > 0 && pos.PositionSeconds >= completedFraction*pos.DurationSeconds {
+		pos.Completed = true
+	}
+
+	if err := cfg.db.RecordWatchPosition(userID, pos); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record playback event", err)
+		return
+	}
+	if params.Type == playbackEventPlay {
+		cfg.domainEvents.Publish(domainVideoViewed, video.ID, videoViewedEvent{
+			VideoID:  video.ID,
+			ViewerID: userID,
+			Position: params.PositionSeconds,
+		})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerVideoPositionGet(w http.ResponseWriter, r *http.Request) {
+	video, userID, ok := cfg.watchableVideo(w, r)
+	if !ok {
+		return
+	}
+
+	pos, err := cfg.db.GetWatchPosition(userID, video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get watch position", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, pos)
+}
+
+func (cfg *apiConfig) handlerWatchHistory(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	params, err := pagination.ParseRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid pagination parameters", err)
+		return
+	}
+
+	// Videos that went private since they were watched drop out.
+	history, page, err := cfg.db.GetWatchHistoryPage(userID, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve watch history", err)
+		return
+	}
+
+	pagination.WriteHeaders(w, page)
+	respondWithJSON(w, http.StatusOK, history)
+}
+
+// watchableVideo loads the video named in the path for an authenticated
+// caller who is allowed to watch it, responding if not.
+func (cfg *apiConfig) watchableVideo(w http.ResponseWriter, r *http.Request) (database.Video, uuid.UUID, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid ID", err)
+		return database.Video{}, uuid.Nil, false
+	}
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return database.Video{}, uuid.Nil, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, uuid.Nil, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithErrorCode(w, http.StatusNotFound, errCodeVideoNotFound, "Video not found", nil)
+		return database.Video{}, uuid.Nil, false
+	}
+	if video.Visibility == database.VisibilityPrivate {
+		if !cfg.checkVideoAccess(w, video, userID, permView, "This video is private") {
+			return database.Video{}, uuid.Nil, false
+		}
+	}
+	return video, userID, true
+}
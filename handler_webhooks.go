@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerAdminWebhooksList(w http.ResponseWriter, r *http.Request) {
+	endpoints, err := cfg.webhooks.endpoints()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list webhook endpoints", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, endpoints)
+}
+
+// handlerAdminWebhookCreate adds an endpoint with a freshly generated
+// signing secret. The secret is only ever returned here.
+func (cfg *apiConfig) handlerAdminWebhookCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+
+	var details []errorDetail
+	if u, err := url.Parse(params.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		details = append(details, errorDetail{Field: "url", Message: "must be an absolute http or https URL"})
+	}
+	events := []string{}
+	for _, event := range params.Events {
+		event = strings.TrimSpace(event)
+		if event == "" || strings.Contains(event, ",") {
+			details = append(details, errorDetail{Field: "events", Message: "event types can't be empty or contain commas"})
+			break
+		}
+		events = append(events, event)
+	}
+	if len(details) > 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid webhook endpoint",
+			Details: details,
+		})
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate secret", err)
+		return
+	}
+	endpoint, err := cfg.db.CreateWebhookEndpoint(params.URL, hex.EncodeToString(secret), events)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook endpoint", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, struct {
+		database.WebhookEndpoint
+		Secret string `json:"secret"`
+	}{endpoint, endpoint.Secret})
+}
+
+func (cfg *apiConfig) handlerAdminWebhookDelete(w http.ResponseWriter, r *http.Request) {
+	endpointID, err := uuid.Parse(r.PathValue("endpointID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid endpoint ID", err)
+		return
+	}
+	if endpointID == envWebhookEndpointID {
+		respondWithError(w, http.StatusBadRequest, "This endpoint is configured with WEBHOOK_URL", nil)
+		return
+	}
+
+	deleted, err := cfg.db.DeleteWebhookEndpoint(endpointID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete webhook endpoint", err)
+		return
+	}
+	if !deleted {
+		respondWithError(w, http.StatusNotFound, "Webhook endpoint not found", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// webhookEndpoint loads the endpoint named in the path, responding if it
+// doesn't exist.
+func (cfg *apiConfig) webhookEndpoint(w http.ResponseWriter, r *http.Request) (database.WebhookEndpoint, bool) {
+	endpointID, err := uuid.Parse(r.PathValue("endpointID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid endpoint ID", err)
+		return database.WebhookEndpoint{}, false
+	}
+	endpoint, err := cfg.webhooks.endpoint(endpointID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhook endpoint", err)
+		return database.WebhookEndpoint{}, false
+	}
+	if endpoint.URL == "" {
+		respondWithError(w, http.StatusNotFound, "Webhook endpoint not found", nil)
+		return database.WebhookEndpoint{}, false
+	}
+	return endpoint, true
+}
+
+// handlerAdminWebhookDeliveries is an endpoint's delivery log, newest
+// first, optionally only deliveries in the given status.
+func (cfg *apiConfig) handlerAdminWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := cfg.webhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+	params, err := pagination.ParseRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid pagination parameters", err)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", database.DeliveryPending, database.DeliverySucceeded, database.DeliveryFailed:
+	default:
+		respondWithError(w, http.StatusBadRequest, "Unknown delivery status", nil)
+		return
+	}
+
+	deliveries, page, err := cfg.db.GetWebhookDeliveriesPage(endpoint.ID, status, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve deliveries", err)
+		return
+	}
+
+	pagination.WriteHeaders(w, page)
+	respondWithJSON(w, http.StatusOK, deliveries)
+}
+
+// handlerAdminWebhookDelivery shows one delivery with every attempt made
+// at it, including the response codes and errors.
+func (cfg *apiConfig) handlerAdminWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := cfg.webhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+	deliveryID, err := uuid.Parse(r.PathValue("deliveryID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid delivery ID", err)
+		return
+	}
+
+	delivery, err := cfg.db.GetWebhookDelivery(deliveryID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get delivery", err)
+		return
+	}
+	if delivery.ID == uuid.Nil || delivery.EndpointID != endpoint.ID {
+		respondWithError(w, http.StatusNotFound, "Delivery not found", nil)
+		return
+	}
+	attempts, err := cfg.db.GetWebhookAttempts(delivery.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get delivery attempts", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		database.WebhookDelivery
+		AttemptLog []database.WebhookAttempt `json:"attempt_log"`
+	}{delivery, attempts})
+}
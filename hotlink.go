@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// hotlinkPolicy decides which pages may embed assets and streams. An empty
+// pattern list turns protection off.
+type hotlinkPolicy struct {
+	// patterns are host globs such as "tubely.example.com" or
+	// "*.example.com".
+	patterns   []string
+	allowEmpty bool
+}
+
+// loadHotlinkPolicy reads HOTLINK_ALLOWED_REFERERS, a comma separated list
+// of host patterns. Requests without a Referer or Origin, such as direct
+// visits and privacy-conscious browsers, are let through unless
+// HOTLINK_ALLOW_EMPTY is false.
+func loadHotlinkPolicy() hotlinkPolicy {
+	policy := hotlinkPolicy{allowEmpty: envBool("HOTLINK_ALLOW_EMPTY", true)}
+	for _, pattern := range strings.Split(os.Getenv("HOTLINK_ALLOWED_REFERERS"), ",") {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern != "" {
+			policy.patterns = append(policy.patterns, pattern)
+		}
+	}
+	return policy
+}
+
+func (p hotlinkPolicy) allows(host string) bool {
+	for _, pattern := range p.patterns {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hotlinkProtection refuses requests embedded from pages on hosts that
+// aren't allowed. The server's own origin is always allowed so the bundled
+// web app keeps working.
+func (cfg *apiConfig) hotlinkProtection(next http.Handler) http.Handler {
+	if len(cfg.hotlink.patterns) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		source := r.Header.Get("Referer")
+		if source == "" {
+			source = r.Header.Get("Origin")
+		}
+		if source == "" {
+			if cfg.hotlink.allowEmpty {
+				next.ServeHTTP(w, r)
+				return
+			}
+			respondWithErrorCode(w, http.StatusForbidden, errCodeHotlinkForbidden, "Embedding isn't allowed from this page", nil)
+			return
+		}
+
+		u, err := url.Parse(source)
+		if err != nil || u.Host == "" {
+			respondWithErrorCode(w, http.StatusForbidden, errCodeHotlinkForbidden, "Embedding isn't allowed from this page", err)
+			return
+		}
+		own, err := url.Parse(cfg.getBaseURL())
+		if (err == nil && strings.EqualFold(u.Host, own.Host)) || cfg.hotlink.allows(strings.ToLower(u.Hostname())) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		respondWithErrorCode(w, http.StatusForbidden, errCodeHotlinkForbidden, "Embedding isn't allowed from this page", nil)
+	})
+}
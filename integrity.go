@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	integrityMissing          = "missing"
+	integritySizeMismatch     = "size_mismatch"
+	integrityChecksumMismatch = "checksum_mismatch"
+	integrityVersionMismatch  = "version_mismatch"
+	integrityStatFailed       = "stat_failed"
+)
+
+type integrityIssue struct {
+	VideoID  uuid.UUID `json:"video_id"`
+	Key      string    `json:"key"`
+	Problem  string    `json:"problem"`
+	Expected string    `json:"expected,omitempty"`
+	Actual   string    `json:"actual,omitempty"`
+}
+
+type integrityReport struct {
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+	Checked    int              `json:"checked"`
+	Issues     []integrityIssue `json:"issues"`
+}
+
+// integrityAuditTask is the name the audit is registered under, and its
+// report stored under.
+const integrityAuditTask = "integrity_audit"
+
+// auditIntegrity checks a random sample of stored videos against the size
+// and checksum recorded when they were uploaded. Videos uploaded before
+// checksums were recorded only have their size checked. It runs on the
+// leader and stores its report in the database, so the admin endpoint
+// serves the same one from every instance.
+func (cfg *apiConfig) auditIntegrity(ctx context.Context) error {
+	videos, err := cfg.db.GetVideoSample(envInt("INTEGRITY_AUDIT_SAMPLE", 100))
+	if err != nil {
+		return fmt.Errorf("couldn't sample videos: %w", err)
+	}
+
+	report := integrityReport{StartedAt: time.Now().UTC(), Issues: []integrityIssue{}}
+	for _, video := range videos {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		key := *video.VideoKey
+		issue := integrityIssue{VideoID: video.ID, Key: key}
+
+		info, err := cfg.store.Stat(ctx, key)
+		report.Checked++
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			issue.Problem = integrityMissing
+		case err != nil:
+			issue.Problem = integrityStatFailed
+			issue.Actual = err.Error()
+		case info.Size != video.VideoSize:
+			issue.Problem = integritySizeMismatch
+			issue.Expected = fmt.Sprint(video.VideoSize)
+			issue.Actual = fmt.Sprint(info.Size)
+		case video.VideoChecksum != "" && info.ChecksumSHA256 != video.VideoChecksum:
+			issue.Problem = integrityChecksumMismatch
+			issue.Expected = video.VideoChecksum
+			issue.Actual = info.ChecksumSHA256
+		case video.VideoVersionID != "" && info.VersionID != video.VideoVersionID:
+			// Same bytes, but the object was rewritten after the row
+			// was saved.
+			issue.Problem = integrityVersionMismatch
+			issue.Expected = video.VideoVersionID
+			issue.Actual = info.VersionID
+		default:
+			continue
+		}
+		log.Printf("integrity_audit: video %s (%s): %s", video.ID, key, issue.Problem)
+		report.Issues = append(report.Issues, issue)
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	if err := cfg.db.SetTaskReport(integrityAuditTask, report); err != nil {
+		return fmt.Errorf("couldn't store report: %w", err)
+	}
+	if len(report.Issues) > 0 {
+		log.Printf("integrity_audit: %d of %d sampled videos have problems", len(report.Issues), report.Checked)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) handlerAdminIntegrityReport(w http.ResponseWriter, r *http.Request) {
+	report, err := cfg.db.GetTaskReport(integrityAuditTask)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get integrity report", err)
+		return
+	}
+	if report == nil {
+		respondWithError(w, http.StatusNotFound, "No integrity audit has run yet", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, report)
+}
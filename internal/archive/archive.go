@@ -0,0 +1,181 @@
+// Package archive unpacks zip and tar uploads into a scratch directory
+// without trusting the names, types or sizes the archive claims.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("not a zip, tar or gzipped tar archive")
+	ErrUnsafeEntry       = errors.New("unsafe archive entry")
+	ErrTooManyMembers    = errors.New("archive has too many files")
+	ErrTooLarge          = errors.New("archive contents are too large")
+)
+
+// Limits bounds what Extract writes to disk. Sizes are checked against the
+// bytes actually decompressed, not the sizes recorded in the archive.
+type Limits struct {
+	MaxMembers   int
+	MaxTotalSize int64
+}
+
+// Member is a regular file unpacked from an archive. Name is its cleaned,
+// slash separated path inside the archive; Path is where it was written,
+// which never depends on Name.
+type Member struct {
+	Name string
+	Path string
+	Size int64
+}
+
+// Extract unpacks the archive in r into dir. Directories are skipped, and
+// links, devices and names that are absolute or climb out of the archive
+// root fail the whole extraction.
+func Extract(r io.ReaderAt, size int64, dir string, limits Limits) ([]Member, error) {
+	magic := make([]byte, 512)
+	n, err := r.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	magic = magic[:n]
+
+	x := &extractor{dir: dir, limits: limits}
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		err = x.zip(r, size)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, gzErr := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if gzErr != nil {
+			return nil, gzErr
+		}
+		defer gz.Close()
+		err = x.tar(bufio.NewReader(gz))
+	case len(magic) >= 262 && bytes.HasPrefix(magic[257:], []byte("ustar")):
+		err = x.tar(io.NewSectionReader(r, 0, size))
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	return x.members, nil
+}
+
+type extractor struct {
+	dir     string
+	limits  Limits
+	written int64
+	members []Member
+}
+
+func (x *extractor) zip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		mode := f.Mode()
+		if mode.IsDir() {
+			continue
+		}
+		if !mode.IsRegular() {
+			return fmt.Errorf("%w: %s isn't a regular file", ErrUnsafeEntry, f.Name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("couldn't open %s: %w", f.Name, err)
+		}
+		err = x.add(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) tar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			continue
+		case tar.TypeReg:
+		default:
+			return fmt.Errorf("%w: %s isn't a regular file", ErrUnsafeEntry, h.Name)
+		}
+		if err := x.add(h.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// add copies one entry to a file named after its position in the archive,
+// stopping as soon as the total passes the size limit.
+func (x *extractor) add(name string, r io.Reader) error {
+	cleaned, err := cleanName(name)
+	if err != nil {
+		return err
+	}
+	if x.limits.MaxMembers > 0 && len(x.members) >= x.limits.MaxMembers {
+		return fmt.Errorf("%w: more than %d", ErrTooManyMembers, x.limits.MaxMembers)
+	}
+
+	diskPath := filepath.Join(x.dir, fmt.Sprintf("member-%d", len(x.members)))
+	f, err := os.OpenFile(diskPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var src io.Reader = r
+	if x.limits.MaxTotalSize > 0 {
+		src = io.LimitReader(r, x.limits.MaxTotalSize-x.written+1)
+	}
+	n, err := io.Copy(f, src)
+	if err != nil {
+		return fmt.Errorf("couldn't extract %s: %w", cleaned, err)
+	}
+	x.written += n
+	if x.limits.MaxTotalSize > 0 && x.written > x.limits.MaxTotalSize {
+		return fmt.Errorf("%w: more than %d bytes", ErrTooLarge, x.limits.MaxTotalSize)
+	}
+	x.members = append(x.members, Member{Name: cleaned, Path: diskPath, Size: n})
+	return nil
+}
+
+// cleanName normalises an entry name and rejects the ones a naive
+// extractor would write outside its target directory.
+func cleanName(name string) (string, error) {
+	slashed := strings.ReplaceAll(name, `\`, "/")
+	if slashed == "" || strings.ContainsRune(slashed, 0) || strings.HasPrefix(slashed, "/") || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("%w: %q", ErrUnsafeEntry, name)
+	}
+	if len(slashed) >= 2 && slashed[1] == ':' {
+		return "", fmt.Errorf("%w: %q", ErrUnsafeEntry, name)
+	}
+	cleaned := path.Clean(slashed)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%w: %q", ErrUnsafeEntry, name)
+	}
+	return cleaned, nil
+}
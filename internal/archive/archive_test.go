@@ -0,0 +1,243 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type entry struct {
+	name string
+	body string
+	// typeflag is only used for tar entries; zero means a regular file.
+	typeflag byte
+}
+
+func zipArchive(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: e.name, Method: zip.Deflate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarArchive(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.body)), Typeflag: e.typeflag, Format: tar.FormatUSTAR}
+		switch e.typeflag {
+		case 0:
+			h.Typeflag = tar.TypeReg
+		case tar.TypeSymlink, tar.TypeLink:
+			h.Linkname, h.Size = e.body, 0
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Size > 0 {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// extract unpacks b into a directory of its own inside a parent that
+// should stay empty, and fails the test if anything lands outside it.
+func extract(t *testing.T, b []byte, limits Limits) ([]Member, error) {
+	t.Helper()
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "out")
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	members, err := Extract(bytes.NewReader(b), int64(len(b)), dir, limits)
+	entries, readErr := os.ReadDir(parent)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("extraction wrote outside its directory: %v", entries)
+	}
+	return members, err
+}
+
+func TestExtractFormats(t *testing.T) {
+	entries := []entry{
+		{name: "video.mp4", body: "not really a video"},
+		{name: "captions/en.vtt", body: "WEBVTT\n"},
+	}
+	archives := map[string][]byte{
+		"zip":    zipArchive(t, entries...),
+		"tar":    tarArchive(t, entries...),
+		"tar.gz": gzipped(t, tarArchive(t, entries...)),
+	}
+	for format, b := range archives {
+		members, err := extract(t, b, Limits{})
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if len(members) != len(entries) {
+			t.Errorf("%s: got %d members, want %d", format, len(members), len(entries))
+			continue
+		}
+		for i, m := range members {
+			body, err := os.ReadFile(m.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Name != entries[i].name || string(body) != entries[i].body || m.Size != int64(len(body)) {
+				t.Errorf("%s: member %d is %q (%d bytes) = %q, want %q = %q", format, i, m.Name, m.Size, body, entries[i].name, entries[i].body)
+			}
+		}
+	}
+}
+
+func TestExtractRejectsUnsafeNames(t *testing.T) {
+	names := []string{
+		"../evil.mp4",
+		"a/../../evil.mp4",
+		"/etc/passwd",
+		`..\evil.mp4`,
+		`a\..\..\evil.mp4`,
+		"C:/evil.mp4",
+		`C:\evil.mp4`,
+		"..",
+		"evil\x00.mp4",
+	}
+	for _, name := range names {
+		entries := []entry{{name: "ok.txt", body: "ok"}, {name: name, body: "evil"}}
+		archives := map[string][]byte{"zip": zipArchive(t, entries...)}
+		// A NUL can't even be written into a tar header.
+		if !strings.ContainsRune(name, 0) {
+			archives["tar"] = tarArchive(t, entries...)
+		}
+		for format, b := range archives {
+			_, err := extract(t, b, Limits{})
+			if !errors.Is(err, ErrUnsafeEntry) {
+				t.Errorf("%s with %q: got %v, want ErrUnsafeEntry", format, name, err)
+			}
+		}
+	}
+}
+
+func TestCleanNameKeepsSafeNames(t *testing.T) {
+	cases := map[string]string{
+		"./video.mp4":        "video.mp4",
+		"a/b/../video.mp4":   "a/video.mp4",
+		`captions\en.vtt`:    "captions/en.vtt",
+		"dir//video.mp4":     "dir/video.mp4",
+		"..video.mp4":        "..video.mp4",
+		"dir/..hidden/x.vtt": "dir/..hidden/x.vtt",
+	}
+	for name, want := range cases {
+		got, err := cleanName(name)
+		if err != nil || got != want {
+			t.Errorf("cleanName(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestExtractRejectsLinks(t *testing.T) {
+	for _, typeflag := range []byte{tar.TypeSymlink, tar.TypeLink} {
+		b := tarArchive(t, entry{name: "video.mp4", body: "/etc/passwd", typeflag: typeflag})
+		if _, err := extract(t, b, Limits{}); !errors.Is(err, ErrUnsafeEntry) {
+			t.Errorf("typeflag %q: got %v, want ErrUnsafeEntry", typeflag, err)
+		}
+	}
+}
+
+func TestExtractSizeLimit(t *testing.T) {
+	big := strings.Repeat("0", 1<<20)
+	archives := map[string][]byte{
+		"zip":    zipArchive(t, entry{name: "bomb.mp4", body: big}),
+		"tar.gz": gzipped(t, tarArchive(t, entry{name: "bomb.mp4", body: big})),
+		// The limit covers all members together, not each one.
+		"split": zipArchive(t, entry{name: "a.mp4", body: big[:600]}, entry{name: "b.mp4", body: big[:600]}),
+	}
+	for format, b := range archives {
+		if format != "split" && len(b) > 64<<10 {
+			t.Fatalf("%s: %d bytes doesn't compress like a bomb", format, len(b))
+		}
+		_, err := extract(t, b, Limits{MaxTotalSize: 1000})
+		if !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%s: got %v, want ErrTooLarge", format, err)
+		}
+	}
+
+	members, err := extract(t, zipArchive(t, entry{name: "a.mp4", body: big[:1000]}), Limits{MaxTotalSize: 1000})
+	if err != nil || len(members) != 1 || members[0].Size != 1000 {
+		t.Errorf("archive at the limit: %v, %v", members, err)
+	}
+}
+
+// A bomb is cut off right after the limit, not written out in full first.
+func TestExtractStopsAtSizeLimit(t *testing.T) {
+	b := gzipped(t, tarArchive(t, entry{name: "bomb.mp4", body: strings.Repeat("0", 8<<20)}))
+	dir := t.TempDir()
+	if _, err := Extract(bytes.NewReader(b), int64(len(b)), dir, Limits{MaxTotalSize: 1000}); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("got %v, want ErrTooLarge", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "member-0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 1001 {
+		t.Errorf("wrote %d bytes past a 1000 byte limit", info.Size())
+	}
+}
+
+func TestExtractMemberLimit(t *testing.T) {
+	b := zipArchive(t, entry{name: "a"}, entry{name: "b"}, entry{name: "c"})
+	if _, err := extract(t, b, Limits{MaxMembers: 2}); !errors.Is(err, ErrTooManyMembers) {
+		t.Errorf("got %v, want ErrTooManyMembers", err)
+	}
+	if members, err := extract(t, b, Limits{MaxMembers: 3}); err != nil || len(members) != 3 {
+		t.Errorf("at the limit: %v, %v", members, err)
+	}
+}
+
+func TestExtractUnsupportedFormat(t *testing.T) {
+	for _, b := range [][]byte{[]byte("plain text"), {}, []byte("PK")} {
+		if _, err := extract(t, b, Limits{}); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("%q: got %v, want ErrUnsupportedFormat", b, err)
+		}
+	}
+}
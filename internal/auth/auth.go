@@ -114,3 +114,18 @@ func GetAPIKey(headers http.Header) (string, error) {
 
 	return splitAuth[1], nil
 }
+
+// GetUploadToken returns the one-time upload token from an
+// "Authorization: UploadToken <token>" header.
+func GetUploadToken(headers http.Header) (string, error) {
+	authHeader := headers.Get("Authorization")
+	if authHeader == "" {
+		return "", ErrNoAuthHeaderIncluded
+	}
+	splitAuth := strings.Split(authHeader, " ")
+	if len(splitAuth) < 2 || splitAuth[0] != "UploadToken" {
+		return "", errors.New("malformed authorization header")
+	}
+
+	return splitAuth[1], nil
+}
@@ -0,0 +1,147 @@
+// Package awschunked decodes request bodies sent with
+// "Content-Encoding: aws-chunked", the framing AWS SDKs use to stream a
+// payload with a checksum trailer after the data.
+package awschunked
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// Encoding is the Content-Encoding token that marks a chunked body.
+const Encoding = "aws-chunked"
+
+// IsChunked reports whether the request body uses aws-chunked framing.
+func IsChunked(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Content-Encoding"), ",") {
+		if strings.EqualFold(strings.TrimSpace(enc), Encoding) {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodedLength returns the payload size declared in
+// X-Amz-Decoded-Content-Length.
+func DecodedLength(r *http.Request) (int64, error) {
+	n, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("aws-chunked bodies need a valid X-Amz-Decoded-Content-Length")
+	}
+	return n, nil
+}
+
+// Reader yields the payload of an aws-chunked body. Chunk signatures are
+// skipped: requests are authenticated separately, and integrity comes
+// from the trailing checksum. Trailer is filled in once Read returns EOF.
+type Reader struct {
+	r         *bufio.Reader
+	remaining int64
+	done      bool
+	Trailer   http.Header
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r), Trailer: http.Header{}}
+}
+
+func (c *Reader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+	if c.remaining == 0 {
+		size, err := c.chunkHeader()
+		if err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			if err := c.readTrailer(); err != nil {
+				return 0, err
+			}
+			c.done = true
+			return 0, io.EOF
+		}
+		c.remaining = size
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return n, err
+	}
+	if c.remaining == 0 {
+		if err := c.expectCRLF(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (c *Reader) chunkHeader() (int64, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return 0, err
+	}
+	sizeField, _, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("awschunked: malformed chunk header %q", line)
+	}
+	return size, nil
+}
+
+func (c *Reader) readTrailer() error {
+	for {
+		// The final CRLF is sometimes left off, so EOF ends the trailer
+		// just like a blank line does.
+		line, err := c.r.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			name, value, ok := strings.Cut(line, ":")
+			if !ok {
+				return fmt.Errorf("awschunked: malformed trailer %q", line)
+			}
+			c.Trailer.Add(textproto.TrimString(name), textproto.TrimString(value))
+		}
+		if line == "" || err != nil {
+			return nil
+		}
+	}
+}
+
+func (c *Reader) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		if errors.Is(err, io.EOF) && line != "" {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *Reader) expectCRLF() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if line != "" {
+		return errors.New("awschunked: missing CRLF after chunk data")
+	}
+	return nil
+}
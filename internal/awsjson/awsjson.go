@@ -0,0 +1,177 @@
+// Package awsjson calls AWS services that speak the JSON 1.1 protocol,
+// signing requests with the SDK's SigV4 signer. It covers the few calls
+// the server makes to services whose SDK modules it doesn't depend on,
+// and resolves their endpoints the way those modules would.
+package awsjson
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// Error is an error response from the service.
+type Error struct {
+	Status  int
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Type, e.Message)
+}
+
+// Service names a service the way the SDK does. ID is its SDK service ID,
+// which AWS_ENDPOINT_URL_<ID> and the services section of the shared
+// config file are keyed by; SigningName signs its requests and starts
+// its default host name.
+type Service struct {
+	ID          string
+	SigningName string
+}
+
+var (
+	Rekognition    = Service{ID: "Rekognition", SigningName: "rekognition"}
+	Transcribe     = Service{ID: "Transcribe", SigningName: "transcribe"}
+	SecretsManager = Service{ID: "Secrets Manager", SigningName: "secretsmanager"}
+	SSM            = Service{ID: "SSM", SigningName: "ssm"}
+)
+
+// The config sources LoadDefaultConfig records, the environment and the
+// shared config file, answer these for the SDK's own clients.
+type serviceEndpointSource interface {
+	GetServiceBaseEndpoint(ctx context.Context, sdkID string) (string, bool, error)
+}
+
+type fipsSource interface {
+	GetUseFIPSEndpoint(ctx context.Context) (aws.FIPSEndpointState, bool, error)
+}
+
+// Endpoint returns the URL requests to service go to. Like the SDK's
+// clients, it takes an endpoint configured for the service first, then
+// cfg.BaseEndpoint (AWS_ENDPOINT_URL), then the service's endpoint in the
+// region's partition, its FIPS one when AWS_USE_FIPS_ENDPOINT or the
+// shared config asks for that.
+func Endpoint(ctx context.Context, cfg aws.Config, service Service) (string, error) {
+	for _, source := range cfg.ConfigSources {
+		s, ok := source.(serviceEndpointSource)
+		if !ok {
+			continue
+		}
+		endpoint, found, err := s.GetServiceBaseEndpoint(ctx, service.ID)
+		if err != nil {
+			return "", err
+		}
+		if found {
+			return endpoint, nil
+		}
+	}
+	if cfg.BaseEndpoint != nil {
+		return *cfg.BaseEndpoint, nil
+	}
+
+	if cfg.Region == "" {
+		return "", fmt.Errorf("no region to call %s in", service.ID)
+	}
+	host := service.SigningName
+	fips, err := useFIPS(ctx, cfg)
+	if err != nil {
+		return "", err
+	}
+	if fips {
+		host += "-fips"
+	}
+	return fmt.Sprintf("https://%s.%s.%s/", host, cfg.Region, dnsSuffix(cfg.Region)), nil
+}
+
+func useFIPS(ctx context.Context, cfg aws.Config) (bool, error) {
+	for _, source := range cfg.ConfigSources {
+		s, ok := source.(fipsSource)
+		if !ok {
+			continue
+		}
+		state, found, err := s.GetUseFIPSEndpoint(ctx)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			return state == aws.FIPSEndpointStateEnabled, nil
+		}
+	}
+	return false, nil
+}
+
+// dnsSuffix returns the domain of the partition region is in.
+func dnsSuffix(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "amazonaws.com.cn"
+	case strings.HasPrefix(region, "us-isob-"):
+		return "sc2s.sgov.gov"
+	case strings.HasPrefix(region, "us-iso-"):
+		return "c2s.ic.gov"
+	}
+	return "amazonaws.com"
+}
+
+// Call invokes target, e.g. "RekognitionService.DetectModerationLabels", on
+// service's endpoint and decodes the response into out.
+func Call(ctx context.Context, client *http.Client, cfg aws.Config, service Service, target string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	endpoint, err := Endpoint(ctx, cfg, service)
+	if err != nil {
+		return fmt.Errorf("couldn't resolve %s endpoint: %w", service.ID, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't get AWS credentials: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), service.SigningName, cfg.Region, time.Now()); err != nil {
+		return fmt.Errorf("couldn't sign request: %w", err)
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &Error{Status: resp.StatusCode}
+		dat, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		json.Unmarshal(dat, apiErr)
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("couldn't decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
+	"github.com/google/uuid"
+)
+
+// Actors that aren't users. Actions taken through the admin API key are
+// all recorded as ActorAdmin, since the key doesn't identify a person.
+const (
+	ActorAdmin  = "admin"
+	ActorSystem = "system"
+)
+
+// ActorDeletedUserPrefix starts the actor of entries made by a user who
+// has since deleted their account, followed by the random ID standing in
+// for theirs.
+const ActorDeletedUserPrefix = "deleted_user:"
+
+// AuditEntry records who did what to which subject. Entries are never
+// deleted, not even when the subject is, and only updated to anonymise
+// a user who deletes their account.
+type AuditEntry struct {
+	ID          uuid.UUID       `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Actor       string          `json:"actor"`
+	Action      string          `json:"action"`
+	SubjectType string          `json:"subject_type"`
+	SubjectID   string          `json:"subject_id"`
+	Details     json.RawMessage `json:"details,omitempty"`
+}
+
+type CreateAuditEntryParams struct {
+	Actor       string
+	Action      string
+	SubjectType string
+	SubjectID   string
+	// Details is marshalled to JSON when set.
+	Details any
+}
+
+func (c Client) CreateAuditEntry(params CreateAuditEntryParams) error {
+	details := ""
+	if params.Details != nil {
+		dat, err := json.Marshal(params.Details)
+		if err != nil {
+			return err
+		}
+		details = string(dat)
+	}
+
+	query := `
+	INSERT INTO audit_log (id, created_at, actor, action, subject_type, subject_id, details)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, uuid.New(), params.Actor, params.Action, params.SubjectType, params.SubjectID, details)
+	return err
+}
+
+// GetAuditLogPage lists entries newest first, optionally only those about
+// one subject.
+func (c Client) GetAuditLogPage(subjectType, subjectID string, params pagination.Params) ([]AuditEntry, pagination.Page, error) {
+	where, order, args := keyset(params)
+	if subjectType != "" {
+		where += " AND subject_type = ?"
+		args = append(args, subjectType)
+	}
+	if subjectID != "" {
+		where += " AND subject_id = ?"
+		args = append(args, subjectID)
+	}
+	args = append(args, params.Limit+1)
+
+	query := `
+	SELECT id, created_at, actor, action, subject_type, subject_id, details
+	FROM audit_log
+	WHERE ` + where + `
+	ORDER BY ` + order + `
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, pagination.Page{}, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var (
+			entry   AuditEntry
+			details string
+		)
+		err := rows.Scan(
+			&entry.ID,
+			&entry.CreatedAt,
+			&entry.Actor,
+			&entry.Action,
+			&entry.SubjectType,
+			&entry.SubjectID,
+			&details,
+		)
+		if err != nil {
+			return nil, pagination.Page{}, err
+		}
+		if details != "" {
+			entry.Details = json.RawMessage(details)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pagination.Page{}, err
+	}
+
+	entries, page := pagination.Paginate(entries, params, func(e AuditEntry) pagination.Cursor {
+		return pagination.Cursor{CreatedAt: e.CreatedAt, ID: e.ID.String()}
+	})
+	return entries, page, nil
+}
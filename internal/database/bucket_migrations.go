@@ -0,0 +1,80 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// GetMigratedVideoIDs returns the videos already moved to destBucket, so an
+// interrupted migration can pick up where it stopped.
+func (c Client) GetMigratedVideoIDs(destBucket string) (map[uuid.UUID]bool, error) {
+	rows, err := c.db.Query("SELECT video_id FROM bucket_migrations WHERE dest_bucket = ?", destBucket)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := map[uuid.UUID]bool{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// CompleteVideoMigration points the video and its caption tracks at their
+// copies in destBucket and records it as migrated, all or nothing.
+func (c Client) CompleteVideoMigration(destBucket string, video Video, captions []Caption) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE videos
+	SET
+		video_url = ?,
+		video_checksum = ?,
+		video_etag = ?,
+		video_version_id = ?,
+		revision = revision + 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err = tx.Exec(
+		query,
+		video.VideoURL,
+		video.VideoChecksum,
+		video.VideoETag,
+		video.VideoVersionID,
+		video.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, caption := range captions {
+		if _, err := tx.Exec("UPDATE captions SET url = ? WHERE video_id = ? AND language = ?", caption.URL, video.ID, caption.Language); err != nil {
+			return err
+		}
+	}
+
+	query = `
+	INSERT INTO bucket_migrations (dest_bucket, video_id, video_key, migrated_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(dest_bucket, video_id) DO UPDATE SET
+		video_key = excluded.video_key,
+		migrated_at = CURRENT_TIMESTAMP
+	`
+	if _, err := tx.Exec(query, destBucket, video.ID.String(), video.VideoKey); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	c.videoChanged(video.ID)
+	return nil
+}
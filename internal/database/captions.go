@@ -0,0 +1,123 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Caption is a WebVTT caption track stored next to the video. A video has
+// at most one track per language.
+type Caption struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	Language  string    `json:"language"`
+	Source    string    `json:"source"`
+	Key       string    `json:"-"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SetCaption saves the track and replaces its text in the search index.
+func (c Client) SetCaption(caption Caption, text string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO captions (video_id, language, source, key, url, created_at)
+	VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(video_id, language) DO UPDATE SET
+		source = excluded.source,
+		key = excluded.key,
+		url = excluded.url,
+		created_at = excluded.created_at
+	`
+	if _, err := tx.Exec(query, caption.VideoID, caption.Language, caption.Source, caption.Key, caption.URL); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM caption_search WHERE video_id = ? AND language = ?", caption.VideoID.String(), caption.Language); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO caption_search (video_id, language, body) VALUES (?, ?, ?)", caption.VideoID.String(), caption.Language, text); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c Client) GetCaptions(videoID uuid.UUID) ([]Caption, error) {
+	query := `
+	SELECT video_id, language, source, key, url, created_at
+	FROM captions
+	WHERE video_id = ?
+	ORDER BY language
+	`
+
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	captions := []Caption{}
+	for rows.Next() {
+		var caption Caption
+		if err := rows.Scan(&caption.VideoID, &caption.Language, &caption.Source, &caption.Key, &caption.URL, &caption.CreatedAt); err != nil {
+			return nil, err
+		}
+		captions = append(captions, caption)
+	}
+
+	return captions, rows.Err()
+}
+
+// GetCaptionText returns the indexed text of the video's track in the
+// language, or "" when there's none.
+func (c Client) GetCaptionText(videoID uuid.UUID, language string) (string, error) {
+	var text string
+	err := c.db.QueryRow("SELECT body FROM caption_search WHERE video_id = ? AND language = ?", videoID.String(), language).Scan(&text)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return text, err
+}
+
+// CaptionMatch is a video whose captions matched a search, with a snippet
+// of the matching text. Matched terms are wrapped in [ and ].
+type CaptionMatch struct {
+	VideoID  uuid.UUID `json:"video_id"`
+	Language string    `json:"language"`
+	Snippet  string    `json:"snippet"`
+}
+
+// SearchCaptions runs an FTS query over caption text, only returning
+// videos that are public or belong to userID.
+func (c Client) SearchCaptions(match string, userID uuid.UUID, limit int) ([]CaptionMatch, error) {
+	query := `
+	SELECT s.video_id, s.language, snippet(caption_search, '[', ']', '…', 2, 12)
+	FROM caption_search s
+	JOIN videos v ON v.id = s.video_id
+	WHERE caption_search MATCH ? AND (v.visibility = ? OR v.user_id = ?)
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, match, VisibilityPublic, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []CaptionMatch{}
+	for rows.Next() {
+		var m CaptionMatch
+		if err := rows.Scan(&m.VideoID, &m.Language, &m.Snippet); err != nil {
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+
+	return matches, rows.Err()
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Chapter sources. Probed chapters come from the uploaded file's own
+// metadata and are replaced when a new file is uploaded; manual ones are
+// kept.
+const (
+	ChapterProbed = "probe"
+	ChapterManual = "manual"
+)
+
+// Chapter marks a named section of a video starting at StartSeconds. It
+// runs until the next chapter starts or the video ends.
+type Chapter struct {
+	ID           uuid.UUID `json:"id"`
+	VideoID      uuid.UUID `json:"video_id"`
+	StartSeconds float64   `json:"start_seconds"`
+	Title        string    `json:"title"`
+	Source       string    `json:"source"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+const chapterColumns = `
+		id,
+		video_id,
+		start_seconds,
+		title,
+		source,
+		created_at
+`
+
+func scanChapter(row rowScanner) (Chapter, error) {
+	var chapter Chapter
+	err := row.Scan(
+		&chapter.ID,
+		&chapter.VideoID,
+		&chapter.StartSeconds,
+		&chapter.Title,
+		&chapter.Source,
+		&chapter.CreatedAt,
+	)
+	return chapter, err
+}
+
+type CreateChapterParams struct {
+	VideoID      uuid.UUID
+	StartSeconds float64
+	Title        string
+	Source       string
+}
+
+func (c Client) CreateChapter(params CreateChapterParams) (Chapter, error) {
+	query := `
+	INSERT INTO video_chapters (id, video_id, start_seconds, title, source, created_at)
+	VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	RETURNING` + chapterColumns
+	return scanChapter(c.db.QueryRow(query, uuid.New(), params.VideoID, params.StartSeconds, params.Title, params.Source))
+}
+
+// GetChapters returns the video's chapters in playback order.
+func (c Client) GetChapters(videoID uuid.UUID) ([]Chapter, error) {
+	query := `
+	SELECT` + chapterColumns + `
+	FROM video_chapters
+	WHERE video_id = ?
+	ORDER BY start_seconds, created_at
+	`
+
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chapters := []Chapter{}
+	for rows.Next() {
+		chapter, err := scanChapter(rows)
+		if err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, chapter)
+	}
+	return chapters, rows.Err()
+}
+
+func (c Client) GetChapter(id uuid.UUID) (Chapter, error) {
+	query := `
+	SELECT` + chapterColumns + `
+	FROM video_chapters
+	WHERE id = ?
+	`
+	chapter, err := scanChapter(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chapter{}, nil
+	}
+	return chapter, err
+}
+
+// UpdateChapter saves an edited chapter. Editing a probed chapter makes it
+// manual so the next upload doesn't overwrite the change.
+func (c Client) UpdateChapter(chapter Chapter) (Chapter, error) {
+	query := `
+	UPDATE video_chapters
+	SET start_seconds = ?, title = ?, source = ?
+	WHERE id = ?
+	RETURNING` + chapterColumns
+	return scanChapter(c.db.QueryRow(query, chapter.StartSeconds, chapter.Title, ChapterManual, chapter.ID))
+}
+
+func (c Client) DeleteChapter(id uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM video_chapters WHERE id = ?", id)
+	return err
+}
+
+// ReplaceProbedChapters swaps the video's probed chapters for the ones
+// found in a newly uploaded file, leaving manual chapters alone.
+func (c Client) ReplaceProbedChapters(videoID uuid.UUID, chapters []CreateChapterParams) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM video_chapters WHERE video_id = ? AND source = ?", videoID, ChapterProbed); err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO video_chapters (id, video_id, start_seconds, title, source, created_at)
+	VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	for _, chapter := range chapters {
+		if _, err := tx.Exec(query, uuid.New(), videoID, chapter.StartSeconds, chapter.Title, ChapterProbed); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
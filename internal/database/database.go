@@ -4,11 +4,18 @@ import (
 	"database/sql"
 	"fmt"
 
+	"github.com/google/uuid"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Client struct {
 	db *sql.DB
+	// videosChanged is called with the videos whose rows were just
+	// written, so copies kept outside the database can be dropped.
+	videosChanged func(ids ...uuid.UUID)
+	// instance names the server the client belongs to, which owns the
+	// jobs it creates.
+	instance string
 }
 
 func NewClient(pathToDB string) (Client, error) {
@@ -16,7 +23,7 @@ func NewClient(pathToDB string) (Client, error) {
 	if err != nil {
 		return Client{}, err
 	}
-	c := Client{db}
+	c := Client{db: db}
 	err = c.autoMigrate()
 	if err != nil {
 		return Client{}, err
@@ -25,6 +32,27 @@ func NewClient(pathToDB string) (Client, error) {
 
 }
 
+// ForInstance returns a client that records name as the owner of the jobs
+// it creates, so FailInterruptedJobs only fails this instance's.
+func (c Client) ForInstance(name string) Client {
+	c.instance = name
+	return c
+}
+
+// OnVideoChange returns a client that calls f after it updates or deletes
+// videos' rows. Changes to their renditions, chapters and other data kept
+// in other tables don't count.
+func (c Client) OnVideoChange(f func(ids ...uuid.UUID)) Client {
+	c.videosChanged = f
+	return c
+}
+
+func (c Client) videoChanged(ids ...uuid.UUID) {
+	if c.videosChanged != nil && len(ids) > 0 {
+		c.videosChanged(ids...)
+	}
+}
+
 func (c *Client) autoMigrate() error {
 	userTable := `
 	CREATE TABLE IF NOT EXISTS users (
@@ -72,10 +100,779 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+	videoColumns := map[string]string{
+		"video_key":          "TEXT",
+		"video_size":         "INTEGER NOT NULL DEFAULT 0",
+		"thumbnail_size":     "INTEGER NOT NULL DEFAULT 0",
+		"pending_video_key":  "TEXT",
+		"video_checksum":     "TEXT NOT NULL DEFAULT ''",
+		"video_etag":         "TEXT NOT NULL DEFAULT ''",
+		"video_version_id":   "TEXT NOT NULL DEFAULT ''",
+		"visibility":         "TEXT NOT NULL DEFAULT 'public'",
+		"preset_id":          "TEXT NOT NULL DEFAULT ''",
+		"auto_thumbnail":     "BOOLEAN NOT NULL DEFAULT FALSE",
+		"watermark":          "BOOLEAN NOT NULL DEFAULT FALSE",
+		"publish_at":         "TIMESTAMP",
+		"expires_at":         "TIMESTAMP",
+		"retention_mode":     "TEXT NOT NULL DEFAULT ''",
+		"retain_until":       "TIMESTAMP",
+		"legal_hold":         "BOOLEAN NOT NULL DEFAULT FALSE",
+		"processing_version": "INTEGER NOT NULL DEFAULT 0",
+		"processed_at":       "TIMESTAMP",
+		"storage_tier":       "TEXT NOT NULL DEFAULT 'standard'",
+		"restored_until":     "TIMESTAMP",
+		"revision":           "INTEGER NOT NULL DEFAULT 1",
+		"folder_id":          "TEXT REFERENCES folders(id)",
+		"org_id":             "TEXT REFERENCES organizations(id)",
+		"aspect_ratio":       "TEXT NOT NULL DEFAULT ''",
+		"pixel_format":       "TEXT NOT NULL DEFAULT ''",
+		"color_transfer":     "TEXT NOT NULL DEFAULT ''",
+		"color_primaries":    "TEXT NOT NULL DEFAULT ''",
+		"hdr":                "BOOLEAN NOT NULL DEFAULT FALSE",
+		"last_accessed_at":   "TIMESTAMP",
+		"thumbnail_file":     "TEXT",
+	}
+	for column, definition := range videoColumns {
+		if err := c.addColumnIfMissing("videos", column, definition); err != nil {
+			return err
+		}
+	}
+	// Object requests and deletes look videos up by key.
+	_, err = c.db.Exec("CREATE INDEX IF NOT EXISTS videos_video_key ON videos(video_key)")
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec("CREATE INDEX IF NOT EXISTS videos_folder_id ON videos(folder_id, created_at)")
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec("CREATE INDEX IF NOT EXISTS videos_org_id ON videos(org_id, created_at)")
+	if err != nil {
+		return err
+	}
+	// Asset requests look the video up by the thumbnail's file name.
+	_, err = c.db.Exec("CREATE INDEX IF NOT EXISTS videos_thumbnail_file ON videos(thumbnail_file)")
+	if err != nil {
+		return err
+	}
+	if err := c.backfillThumbnailFiles(); err != nil {
+		return err
+	}
+
+	usageTable := `
+	CREATE TABLE IF NOT EXISTS user_usage (
+		user_id TEXT PRIMARY KEY,
+		bytes_used INTEGER NOT NULL DEFAULT 0,
+		object_count INTEGER NOT NULL DEFAULT 0,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(usageTable)
+	if err != nil {
+		return err
+	}
+
+	planTable := `
+	CREATE TABLE IF NOT EXISTS plans (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name TEXT NOT NULL,
+		max_file_size INTEGER NOT NULL,
+		max_total_storage INTEGER NOT NULL,
+		max_renditions INTEGER NOT NULL,
+		priority INTEGER NOT NULL DEFAULT 0
+	);
+	`
+	_, err = c.db.Exec(planTable)
+	if err != nil {
+		return err
+	}
+	if err := c.addColumnIfMissing("plans", "max_upload_rate", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfMissing("plans", "max_duration_seconds", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfMissing("plans", "max_resolution", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := c.seedPlans(); err != nil {
+		return err
+	}
+	if err := c.addColumnIfMissing("users", "plan_id", "TEXT REFERENCES plans(id)"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfMissing("users", "suspended_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfMissing("users", "avatar_url", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfMissing("users", "banner_url", "TEXT"); err != nil {
+		return err
+	}
+
+	presetTable := `
+	CREATE TABLE IF NOT EXISTS transcoding_presets (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name TEXT NOT NULL,
+		video_codec TEXT NOT NULL,
+		audio_codec TEXT NOT NULL,
+		renditions TEXT NOT NULL
+	);
+	`
+	_, err = c.db.Exec(presetTable)
+	if err != nil {
+		return err
+	}
+	if err := c.addColumnIfMissing("plans", "preset_id", "TEXT REFERENCES transcoding_presets(id)"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfMissing("transcoding_presets", "max_frame_rate", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	uploadSessionTable := `
+	CREATE TABLE IF NOT EXISTS upload_sessions (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		object_key TEXT NOT NULL,
+		upload_id TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		total_size INTEGER NOT NULL,
+		bytes_received INTEGER NOT NULL DEFAULT 0,
+		expires_at TIMESTAMP NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(uploadSessionTable)
+	if err != nil {
+		return err
+	}
+
+	uploadSessionPartTable := `
+	CREATE TABLE IF NOT EXISTS upload_session_parts (
+		session_id TEXT NOT NULL,
+		part_number INTEGER NOT NULL,
+		etag TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		PRIMARY KEY(session_id, part_number),
+		FOREIGN KEY(session_id) REFERENCES upload_sessions(id)
+	);
+	`
+	_, err = c.db.Exec(uploadSessionPartTable)
+	if err != nil {
+		return err
+	}
+	if err := c.addColumnIfMissing("upload_session_parts", "checksum_sha256", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	geoRestrictionTable := `
+	CREATE TABLE IF NOT EXISTS geo_restrictions (
+		scope TEXT NOT NULL,
+		subject_id TEXT NOT NULL,
+		allowed TEXT NOT NULL DEFAULT '',
+		blocked TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(scope, subject_id)
+	);
+	`
+	_, err = c.db.Exec(geoRestrictionTable)
+	if err != nil {
+		return err
+	}
+
+	userSettingsTable := `
+	CREATE TABLE IF NOT EXISTS user_settings (
+		user_id TEXT PRIMARY KEY,
+		visibility TEXT NOT NULL,
+		preset_id TEXT NOT NULL DEFAULT '',
+		auto_thumbnail BOOLEAN NOT NULL,
+		watermark BOOLEAN NOT NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userSettingsTable)
+	if err != nil {
+		return err
+	}
+
+	watchHistoryTable := `
+	CREATE TABLE IF NOT EXISTS watch_history (
+		user_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		position_seconds REAL NOT NULL DEFAULT 0,
+		duration_seconds REAL NOT NULL DEFAULT 0,
+		completed BOOLEAN NOT NULL DEFAULT FALSE,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(user_id, video_id),
+		FOREIGN KEY(user_id) REFERENCES users(id),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(watchHistoryTable)
+	if err != nil {
+		return err
+	}
+
+	bucketMigrationTable := `
+	CREATE TABLE IF NOT EXISTS bucket_migrations (
+		dest_bucket TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		video_key TEXT NOT NULL,
+		migrated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(dest_bucket, video_id)
+	);
+	`
+	_, err = c.db.Exec(bucketMigrationTable)
+	if err != nil {
+		return err
+	}
+
+	jobTable := `
+	CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		finished_at TIMESTAMP,
+		type TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		status TEXT NOT NULL,
+		report TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT ''
+	);
+	`
+	_, err = c.db.Exec(jobTable)
+	if err != nil {
+		return err
+	}
+	if err := c.addColumnIfMissing("jobs", "instance", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfMissing("jobs", "purged_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+
+	reportTable := `
+	CREATE TABLE IF NOT EXISTS video_reports (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		reporter_id TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		message TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		resolution TEXT NOT NULL DEFAULT '',
+		resolved_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS video_reports_video_id ON video_reports(video_id, status);
+	`
+	_, err = c.db.Exec(reportTable)
+	if err != nil {
+		return err
+	}
+
+	moderationTable := `
+	CREATE TABLE IF NOT EXISTS video_moderation (
+		video_id TEXT PRIMARY KEY,
+		checked_at TIMESTAMP NOT NULL,
+		provider TEXT NOT NULL,
+		status TEXT NOT NULL,
+		labels TEXT NOT NULL DEFAULT '[]',
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(moderationTable)
+	if err != nil {
+		return err
+	}
+
+	captionTable := `
+	CREATE TABLE IF NOT EXISTS captions (
+		video_id TEXT NOT NULL,
+		language TEXT NOT NULL,
+		source TEXT NOT NULL,
+		key TEXT NOT NULL,
+		url TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(video_id, language),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE VIRTUAL TABLE IF NOT EXISTS caption_search USING fts4(
+		video_id,
+		language,
+		body,
+		notindexed=video_id,
+		notindexed=language
+	);
+	`
+	_, err = c.db.Exec(captionTable)
+	if err != nil {
+		return err
+	}
+
+	auditTable := `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		actor TEXT NOT NULL,
+		action TEXT NOT NULL,
+		subject_type TEXT NOT NULL,
+		subject_id TEXT NOT NULL,
+		details TEXT NOT NULL DEFAULT ''
+	);
+	`
+	_, err = c.db.Exec(auditTable)
+	if err != nil {
+		return err
+	}
+
+	chapterTable := `
+	CREATE TABLE IF NOT EXISTS video_chapters (
+		id TEXT PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		start_seconds REAL NOT NULL,
+		title TEXT NOT NULL,
+		source TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX IF NOT EXISTS video_chapters_video_id ON video_chapters(video_id, start_seconds);
+	`
+	_, err = c.db.Exec(chapterTable)
+	if err != nil {
+		return err
+	}
+
+	thumbnailCandidateTable := `
+	CREATE TABLE IF NOT EXISTS thumbnail_candidates (
+		id TEXT PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		seconds REAL NOT NULL,
+		file_name TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX IF NOT EXISTS thumbnail_candidates_video_id ON thumbnail_candidates(video_id, position);
+	CREATE INDEX IF NOT EXISTS thumbnail_candidates_file_name ON thumbnail_candidates(file_name);
+	`
+	_, err = c.db.Exec(thumbnailCandidateTable)
+	if err != nil {
+		return err
+	}
+
+	thumbnailRenditionTable := `
+	CREATE TABLE IF NOT EXISTS thumbnail_renditions (
+		video_id TEXT NOT NULL,
+		width INTEGER NOT NULL,
+		height INTEGER NOT NULL,
+		file_name TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		PRIMARY KEY(video_id, width, height),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX IF NOT EXISTS thumbnail_renditions_file_name ON thumbnail_renditions(file_name);
+	CREATE TABLE IF NOT EXISTS thumbnail_framing (
+		video_id TEXT PRIMARY KEY,
+		framing TEXT NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(thumbnailRenditionTable)
+	if err != nil {
+		return err
+	}
+
+	videoRenditionTable := `
+	CREATE TABLE IF NOT EXISTS video_renditions (
+		video_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		width INTEGER NOT NULL,
+		height INTEGER NOT NULL,
+		key TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		PRIMARY KEY(video_id, name),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(videoRenditionTable)
+	if err != nil {
+		return err
+	}
+	if err := c.addColumnIfMissing("video_renditions", "hdr", "BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+		return err
+	}
+
+	folderTable := `
+	CREATE TABLE IF NOT EXISTS folders (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		parent_id TEXT,
+		name TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id),
+		FOREIGN KEY(parent_id) REFERENCES folders(id)
+	);
+	CREATE INDEX IF NOT EXISTS folders_user_id ON folders(user_id, parent_id);
+	`
+	_, err = c.db.Exec(folderTable)
+	if err != nil {
+		return err
+	}
+
+	transferTable := `
+	CREATE TABLE IF NOT EXISTS video_transfers (
+		id TEXT PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		from_user_id TEXT NOT NULL,
+		to_user_id TEXT NOT NULL,
+		status TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		resolved_at TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(from_user_id) REFERENCES users(id),
+		FOREIGN KEY(to_user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS video_transfers_video_id ON video_transfers(video_id, status);
+	CREATE INDEX IF NOT EXISTS video_transfers_to_user_id ON video_transfers(to_user_id, status);
+	CREATE INDEX IF NOT EXISTS video_transfers_from_user_id ON video_transfers(from_user_id, status);
+	`
+	_, err = c.db.Exec(transferTable)
+	if err != nil {
+		return err
+	}
+
+	organizationTable := `
+	CREATE TABLE IF NOT EXISTS organizations (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		plan_id TEXT REFERENCES plans(id),
+		bytes_used INTEGER NOT NULL DEFAULT 0,
+		object_count INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS organization_members (
+		org_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(org_id, user_id),
+		FOREIGN KEY(org_id) REFERENCES organizations(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS organization_members_user_id ON organization_members(user_id);
+	`
+	_, err = c.db.Exec(organizationTable)
+	if err != nil {
+		return err
+	}
+
+	grantTable := `
+	CREATE TABLE IF NOT EXISTS access_grants (
+		subject_type TEXT NOT NULL,
+		subject_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		granted_by TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(subject_type, subject_id, user_id),
+		FOREIGN KEY(user_id) REFERENCES users(id),
+		FOREIGN KEY(granted_by) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS access_grants_user_id ON access_grants(user_id, subject_type);
+	`
+	_, err = c.db.Exec(grantTable)
+	if err != nil {
+		return err
+	}
+
+	webhookTables := `
+	CREATE TABLE IF NOT EXISTS webhook_endpoints (
+		id TEXT PRIMARY KEY,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id TEXT PRIMARY KEY,
+		endpoint_id TEXT NOT NULL,
+		event_type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP,
+		last_status_code INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_endpoint_id ON webhook_deliveries(endpoint_id, created_at);
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+	CREATE TABLE IF NOT EXISTS webhook_attempts (
+		delivery_id TEXT NOT NULL,
+		attempt INTEGER NOT NULL,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(delivery_id, attempt)
+	);
+	`
+	_, err = c.db.Exec(webhookTables)
+	if err != nil {
+		return err
+	}
+
+	videoVersionTable := `
+	CREATE TABLE IF NOT EXISTS video_versions (
+		video_id TEXT NOT NULL,
+		version_id TEXT NOT NULL,
+		key TEXT NOT NULL,
+		etag TEXT NOT NULL DEFAULT '',
+		checksum TEXT NOT NULL DEFAULT '',
+		size INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(video_id, version_id),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(videoVersionTable)
+	if err != nil {
+		return err
+	}
+
+	uploadTokenTable := `
+	CREATE TABLE IF NOT EXISTS upload_tokens (
+		token TEXT PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		max_size INTEGER NOT NULL DEFAULT 0,
+		content_type TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(uploadTokenTable)
+	if err != nil {
+		return err
+	}
+
+	processingOutcomeTable := `
+	CREATE TABLE IF NOT EXISTS processing_outcomes (
+		hour TIMESTAMP PRIMARY KEY,
+		succeeded INTEGER NOT NULL DEFAULT 0,
+		failed INTEGER NOT NULL DEFAULT 0
+	);
+	`
+	_, err = c.db.Exec(processingOutcomeTable)
+	if err != nil {
+		return err
+	}
+	if err := c.addColumnIfMissing("processing_outcomes", "bytes", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	processingRunTable := `
+	CREATE TABLE IF NOT EXISTS processing_runs (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		started_at TIMESTAMP NOT NULL,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		filename TEXT NOT NULL DEFAULT '',
+		size INTEGER NOT NULL DEFAULT 0,
+		reprocess BOOLEAN NOT NULL DEFAULT FALSE,
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		status TEXT NOT NULL,
+		stage TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		stderr TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS processing_runs_created_at ON processing_runs(created_at, id);
+	`
+	_, err = c.db.Exec(processingRunTable)
+	if err != nil {
+		return err
+	}
+
+	videoLockTable := `
+	CREATE TABLE IF NOT EXISTS video_locks (
+		video_id TEXT PRIMARY KEY,
+		owner TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(videoLockTable)
+	if err != nil {
+		return err
+	}
+
+	leaseTable := `
+	CREATE TABLE IF NOT EXISTS leases (
+		name TEXT PRIMARY KEY,
+		holder TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(leaseTable)
+	if err != nil {
+		return err
+	}
+
+	taskReportTable := `
+	CREATE TABLE IF NOT EXISTS task_reports (
+		task TEXT PRIMARY KEY,
+		report TEXT NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(taskReportTable)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
+// addColumnIfMissing lets autoMigrate grow tables that already exist, since
+// SQLite has no ADD COLUMN IF NOT EXISTS.
+func (c *Client) addColumnIfMissing(table, column, definition string) error {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 func (c Client) Reset() error {
+	if _, err := c.db.Exec("DELETE FROM task_reports"); err != nil {
+		return fmt.Errorf("failed to reset table task_reports: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM leases"); err != nil {
+		return fmt.Errorf("failed to reset table leases: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_locks"); err != nil {
+		return fmt.Errorf("failed to reset table video_locks: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM processing_outcomes"); err != nil {
+		return fmt.Errorf("failed to reset table processing_outcomes: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM processing_runs"); err != nil {
+		return fmt.Errorf("failed to reset table processing_runs: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM upload_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table upload_tokens: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_versions"); err != nil {
+		return fmt.Errorf("failed to reset table video_versions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webhook_attempts"); err != nil {
+		return fmt.Errorf("failed to reset table webhook_attempts: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webhook_deliveries"); err != nil {
+		return fmt.Errorf("failed to reset table webhook_deliveries: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM access_grants"); err != nil {
+		return fmt.Errorf("failed to reset table access_grants: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_transfers"); err != nil {
+		return fmt.Errorf("failed to reset table video_transfers: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM audit_log"); err != nil {
+		return fmt.Errorf("failed to reset table audit_log: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM thumbnail_framing"); err != nil {
+		return fmt.Errorf("failed to reset table thumbnail_framing: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_renditions"); err != nil {
+		return fmt.Errorf("failed to reset table video_renditions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM thumbnail_renditions"); err != nil {
+		return fmt.Errorf("failed to reset table thumbnail_renditions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM thumbnail_candidates"); err != nil {
+		return fmt.Errorf("failed to reset table thumbnail_candidates: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_chapters"); err != nil {
+		return fmt.Errorf("failed to reset table video_chapters: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM caption_search"); err != nil {
+		return fmt.Errorf("failed to reset table caption_search: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM captions"); err != nil {
+		return fmt.Errorf("failed to reset table captions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_moderation"); err != nil {
+		return fmt.Errorf("failed to reset table video_moderation: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_reports"); err != nil {
+		return fmt.Errorf("failed to reset table video_reports: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM bucket_migrations"); err != nil {
+		return fmt.Errorf("failed to reset table bucket_migrations: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM jobs"); err != nil {
+		return fmt.Errorf("failed to reset table jobs: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM watch_history"); err != nil {
+		return fmt.Errorf("failed to reset table watch_history: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_settings"); err != nil {
+		return fmt.Errorf("failed to reset table user_settings: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM geo_restrictions"); err != nil {
+		return fmt.Errorf("failed to reset table geo_restrictions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM upload_session_parts"); err != nil {
+		return fmt.Errorf("failed to reset table upload_session_parts: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM upload_sessions"); err != nil {
+		return fmt.Errorf("failed to reset table upload_sessions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_usage"); err != nil {
+		return fmt.Errorf("failed to reset table user_usage: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
@@ -85,5 +882,14 @@ func (c Client) Reset() error {
 	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM folders"); err != nil {
+		return fmt.Errorf("failed to reset table folders: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM organization_members"); err != nil {
+		return fmt.Errorf("failed to reset table organization_members: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM organizations"); err != nil {
+		return fmt.Errorf("failed to reset table organizations: %w", err)
+	}
 	return nil
 }
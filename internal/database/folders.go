@@ -0,0 +1,187 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Folder groups a user's videos. Folders nest; a nil ParentID puts the
+// folder at the top of the user's library.
+type Folder struct {
+	ID        uuid.UUID  `json:"id"`
+	UserID    uuid.UUID  `json:"user_id"`
+	ParentID  *uuid.UUID `json:"parent_id"`
+	Name      string     `json:"name"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+const folderColumns = `
+		id,
+		user_id,
+		parent_id,
+		name,
+		created_at,
+		updated_at
+`
+
+func scanFolder(row rowScanner) (Folder, error) {
+	var folder Folder
+	err := row.Scan(
+		&folder.ID,
+		&folder.UserID,
+		&folder.ParentID,
+		&folder.Name,
+		&folder.CreatedAt,
+		&folder.UpdatedAt,
+	)
+	return folder, err
+}
+
+func scanFolders(rows *sql.Rows) ([]Folder, error) {
+	defer rows.Close()
+	folders := []Folder{}
+	for rows.Next() {
+		folder, err := scanFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	return folders, rows.Err()
+}
+
+type CreateFolderParams struct {
+	UserID   uuid.UUID
+	ParentID *uuid.UUID
+	Name     string
+}
+
+func (c Client) CreateFolder(params CreateFolderParams) (Folder, error) {
+	query := `
+	INSERT INTO folders (id, user_id, parent_id, name, created_at, updated_at)
+	VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	RETURNING` + folderColumns
+	return scanFolder(c.db.QueryRow(query, uuid.New(), params.UserID, params.ParentID, params.Name))
+}
+
+func (c Client) GetFolder(id uuid.UUID) (Folder, error) {
+	query := `
+	SELECT` + folderColumns + `
+	FROM folders
+	WHERE id = ?
+	`
+	folder, err := scanFolder(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Folder{}, nil
+	}
+	return folder, err
+}
+
+// GetFolders lists the user's folders directly inside parentID, or at the
+// top of their library when parentID is nil.
+func (c Client) GetFolders(userID uuid.UUID, parentID *uuid.UUID) ([]Folder, error) {
+	query := `
+	SELECT` + folderColumns + `
+	FROM folders
+	WHERE user_id = ? AND parent_id IS ?
+	ORDER BY name, created_at
+	`
+	rows, err := c.db.Query(query, userID, parentID)
+	if err != nil {
+		return nil, err
+	}
+	return scanFolders(rows)
+}
+
+// GetFolderPath returns the folder and its ancestors, from the top of the
+// library down to the folder itself.
+func (c Client) GetFolderPath(id uuid.UUID) ([]Folder, error) {
+	query := `
+	WITH RECURSIVE path(id, depth) AS (
+		SELECT id, 0 FROM folders WHERE id = ?
+		UNION ALL
+		SELECT folders.parent_id, path.depth + 1
+		FROM folders JOIN path ON folders.id = path.id
+		WHERE folders.parent_id IS NOT NULL
+	)
+	SELECT` + folderColumns + `
+	FROM folders JOIN path USING (id)
+	ORDER BY path.depth DESC
+	`
+	rows, err := c.db.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	return scanFolders(rows)
+}
+
+// GetFolderTree returns the IDs of the folder and every folder nested
+// anywhere inside it.
+func (c Client) GetFolderTree(id uuid.UUID) ([]uuid.UUID, error) {
+	query := `
+	WITH RECURSIVE tree(id) AS (
+		SELECT id FROM folders WHERE id = ?
+		UNION ALL
+		SELECT folders.id FROM folders JOIN tree ON folders.parent_id = tree.id
+	)
+	SELECT id FROM tree
+	`
+	rows, err := c.db.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var folderID uuid.UUID
+		if err := rows.Scan(&folderID); err != nil {
+			return nil, err
+		}
+		ids = append(ids, folderID)
+	}
+	return ids, rows.Err()
+}
+
+// UpdateFolder saves a renamed or moved folder. Callers check the move
+// doesn't put the folder inside itself.
+func (c Client) UpdateFolder(folder Folder) (Folder, error) {
+	query := `
+	UPDATE folders
+	SET parent_id = ?, name = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	RETURNING` + folderColumns
+	return scanFolder(c.db.QueryRow(query, folder.ParentID, folder.Name, folder.ID))
+}
+
+// FolderEmpty reports whether the folder holds no videos or folders.
+func (c Client) FolderEmpty(id uuid.UUID) (bool, error) {
+	query := `
+	SELECT NOT EXISTS (SELECT 1 FROM folders WHERE parent_id = ?)
+		AND NOT EXISTS (SELECT 1 FROM videos WHERE folder_id = ?)
+	`
+	var empty bool
+	err := c.db.QueryRow(query, id, id).Scan(&empty)
+	return empty, err
+}
+
+// DeleteFolder removes the folder and the access granted to it.
+func (c Client) DeleteFolder(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM access_grants WHERE subject_type = ? AND subject_id = ?", GrantSubjectFolder, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM folders WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
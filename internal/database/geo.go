@@ -0,0 +1,72 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Geo restrictions apply either to one video or to everything a user owns.
+const (
+	GeoScopeVideo = "video"
+	GeoScopeUser  = "user"
+)
+
+// GeoRestriction lists upper case ISO 3166-1 alpha-2 country codes. An
+// empty Allowed list allows every country that isn't Blocked.
+type GeoRestriction struct {
+	Allowed []string `json:"allowed_countries"`
+	Blocked []string `json:"blocked_countries"`
+}
+
+// GetGeoRestriction returns an empty restriction when none is set.
+func (c Client) GetGeoRestriction(scope string, subjectID uuid.UUID) (GeoRestriction, error) {
+	query := `
+	SELECT allowed, blocked
+	FROM geo_restrictions
+	WHERE scope = ? AND subject_id = ?
+	`
+	var allowed, blocked string
+	err := c.db.QueryRow(query, scope, subjectID.String()).Scan(&allowed, &blocked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return GeoRestriction{Allowed: []string{}, Blocked: []string{}}, nil
+	}
+	if err != nil {
+		return GeoRestriction{}, err
+	}
+	return GeoRestriction{Allowed: splitCountries(allowed), Blocked: splitCountries(blocked)}, nil
+}
+
+// SetGeoRestriction replaces the restriction, removing it entirely when
+// both lists are empty.
+func (c Client) SetGeoRestriction(scope string, subjectID uuid.UUID, restriction GeoRestriction) error {
+	if len(restriction.Allowed) == 0 && len(restriction.Blocked) == 0 {
+		_, err := c.db.Exec("DELETE FROM geo_restrictions WHERE scope = ? AND subject_id = ?", scope, subjectID.String())
+		return err
+	}
+	query := `
+	INSERT INTO geo_restrictions (scope, subject_id, allowed, blocked, updated_at)
+	VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(scope, subject_id) DO UPDATE SET
+		allowed = excluded.allowed,
+		blocked = excluded.blocked,
+		updated_at = CURRENT_TIMESTAMP
+	`
+	_, err := c.db.Exec(
+		query,
+		scope,
+		subjectID.String(),
+		strings.Join(restriction.Allowed, ","),
+		strings.Join(restriction.Blocked, ","),
+	)
+	return err
+}
+
+func splitCountries(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
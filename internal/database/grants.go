@@ -0,0 +1,190 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Access roles, from most to least capable. Owners can do anything to a
+// video, editors can change it but not delete or give it away, and viewers
+// can only watch it.
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+func ValidRole(role string) bool {
+	return role == RoleOwner || role == RoleEditor || role == RoleViewer
+}
+
+// RoleRank orders roles so the strongest of several can be picked. Unknown
+// roles, including no role at all, rank lowest.
+func RoleRank(role string) int {
+	switch role {
+	case RoleOwner:
+		return 3
+	case RoleEditor:
+		return 2
+	case RoleViewer:
+		return 1
+	}
+	return 0
+}
+
+// What an access grant applies to. A folder grant covers every video filed
+// in the folder or any folder inside it.
+const (
+	GrantSubjectVideo  = "video"
+	GrantSubjectFolder = "folder"
+)
+
+// AccessGrant gives a user a role on a video or folder they don't own.
+type AccessGrant struct {
+	SubjectType string    `json:"subject_type"`
+	SubjectID   uuid.UUID `json:"subject_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	Email       string    `json:"email"`
+	Role        string    `json:"role"`
+	GrantedBy   uuid.UUID `json:"granted_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+const grantColumns = `
+		g.subject_type,
+		g.subject_id,
+		g.user_id,
+		u.email,
+		g.role,
+		g.granted_by,
+		g.created_at
+`
+
+func scanGrant(row rowScanner) (AccessGrant, error) {
+	var grant AccessGrant
+	err := row.Scan(
+		&grant.SubjectType,
+		&grant.SubjectID,
+		&grant.UserID,
+		&grant.Email,
+		&grant.Role,
+		&grant.GrantedBy,
+		&grant.CreatedAt,
+	)
+	return grant, err
+}
+
+type SetAccessGrantParams struct {
+	SubjectType string
+	SubjectID   uuid.UUID
+	UserID      uuid.UUID
+	Role        string
+	GrantedBy   uuid.UUID
+}
+
+// SetAccessGrant gives the user the role on the subject, replacing any
+// role they already had on it.
+func (c Client) SetAccessGrant(params SetAccessGrantParams) error {
+	query := `
+	INSERT INTO access_grants (subject_type, subject_id, user_id, role, granted_by, created_at)
+	VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(subject_type, subject_id, user_id) DO UPDATE SET
+		role = excluded.role,
+		granted_by = excluded.granted_by
+	`
+	_, err := c.db.Exec(query, params.SubjectType, params.SubjectID, params.UserID, params.Role, params.GrantedBy)
+	return err
+}
+
+// GetAccessGrant returns the user's grant on the subject, or a zero value
+// if they have none.
+func (c Client) GetAccessGrant(subjectType string, subjectID, userID uuid.UUID) (AccessGrant, error) {
+	query := `
+	SELECT` + grantColumns + `
+	FROM access_grants g
+	JOIN users u ON u.id = g.user_id
+	WHERE g.subject_type = ? AND g.subject_id = ? AND g.user_id = ?
+	`
+	grant, err := scanGrant(c.db.QueryRow(query, subjectType, subjectID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return AccessGrant{}, nil
+	}
+	return grant, err
+}
+
+func (c Client) GetAccessGrants(subjectType string, subjectID uuid.UUID) ([]AccessGrant, error) {
+	query := `
+	SELECT` + grantColumns + `
+	FROM access_grants g
+	JOIN users u ON u.id = g.user_id
+	WHERE g.subject_type = ? AND g.subject_id = ?
+	ORDER BY u.email
+	`
+	rows, err := c.db.Query(query, subjectType, subjectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := []AccessGrant{}
+	for rows.Next() {
+		grant, err := scanGrant(rows)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	return grants, rows.Err()
+}
+
+// DeleteAccessGrant revokes the user's role on the subject, reporting
+// whether they had one.
+func (c Client) DeleteAccessGrant(subjectType string, subjectID, userID uuid.UUID) (bool, error) {
+	res, err := c.db.Exec("DELETE FROM access_grants WHERE subject_type = ? AND subject_id = ? AND user_id = ?", subjectType, subjectID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetGrantedRole returns the strongest role the user was granted on the
+// video or on any of folderIDs, or "" if they have none. Pass uuid.Nil as
+// the video to only look at folders.
+func (c Client) GetGrantedRole(userID, videoID uuid.UUID, folderIDs []uuid.UUID) (string, error) {
+	query := `
+	SELECT role
+	FROM access_grants
+	WHERE user_id = ? AND ((subject_type = ? AND subject_id = ?)`
+	args := []any{userID, GrantSubjectVideo, videoID}
+	if len(folderIDs) > 0 {
+		query += ` OR (subject_type = ? AND subject_id IN (?` + strings.Repeat(", ?", len(folderIDs)-1) + `))`
+		args = append(args, GrantSubjectFolder)
+		for _, id := range folderIDs {
+			args = append(args, id)
+		}
+	}
+	query += `)`
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	best := ""
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return "", err
+		}
+		if RoleRank(role) > RoleRank(best) {
+			best = role
+		}
+	}
+	return best, rows.Err()
+}
@@ -0,0 +1,240 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Job states. Queued and running jobs that were cut off by a restart are
+// failed when the instance that started them starts again.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Job tracks a long running background operation done on a user's
+// behalf. UserID isn't a foreign key so the job outlives an account it
+// deletes.
+type Job struct {
+	ID         uuid.UUID       `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	FinishedAt *time.Time      `json:"finished_at"`
+	Type       string          `json:"type"`
+	UserID     uuid.UUID       `json:"user_id"`
+	Status     string          `json:"status"`
+	Report     json.RawMessage `json:"report,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+const jobColumns = `
+		id,
+		created_at,
+		updated_at,
+		finished_at,
+		type,
+		user_id,
+		status,
+		report,
+		error
+`
+
+func scanJob(row rowScanner) (Job, error) {
+	var (
+		job    Job
+		report string
+	)
+	err := row.Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.FinishedAt,
+		&job.Type,
+		&job.UserID,
+		&job.Status,
+		&report,
+		&job.Error,
+	)
+	if report != "" {
+		job.Report = json.RawMessage(report)
+	}
+	return job, err
+}
+
+func (c Client) CreateJob(jobType string, userID uuid.UUID) (Job, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO jobs (id, created_at, updated_at, type, user_id, status, instance)
+	VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id.String(), jobType, userID.String(), JobQueued, c.instance)
+	if err != nil {
+		return Job{}, err
+	}
+	return c.GetJob(id)
+}
+
+// GetJob returns a zero job when there is none with that ID.
+func (c Client) GetJob(id uuid.UUID) (Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE id = ?
+	`
+	job, err := scanJob(c.db.QueryRow(query, id.String()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Job{}, nil
+	}
+	return job, err
+}
+
+// GetActiveJob returns the user's queued or running job of the given type,
+// or a zero job when there is none.
+func (c Client) GetActiveJob(userID uuid.UUID, jobType string) (Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE user_id = ? AND type = ? AND status IN (?, ?)
+	ORDER BY created_at DESC
+	LIMIT 1
+	`
+	job, err := scanJob(c.db.QueryRow(query, userID.String(), jobType, JobQueued, JobRunning))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Job{}, nil
+	}
+	return job, err
+}
+
+func (c Client) StartJob(id uuid.UUID) error {
+	query := `
+	UPDATE jobs
+	SET status = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobRunning, id.String())
+	return err
+}
+
+// UpdateJobReport stores the progress of a running job.
+func (c Client) UpdateJobReport(id uuid.UUID, report any) error {
+	encoded, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	query := `
+	UPDATE jobs
+	SET report = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err = c.db.Exec(query, string(encoded), id.String())
+	return err
+}
+
+// FinishJob records the outcome of a job. The report is kept even when the
+// job failed, so partial progress stays visible.
+func (c Client) FinishJob(id uuid.UUID, report any, jobErr error) error {
+	var encoded string
+	if report != nil {
+		b, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+		encoded = string(b)
+	}
+	status, message := JobSucceeded, ""
+	if jobErr != nil {
+		status, message = JobFailed, jobErr.Error()
+	}
+	query := `
+	UPDATE jobs
+	SET status = ?, report = ?, error = ?, finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, status, encoded, message, id.String())
+	return err
+}
+
+// FailInterruptedJobs marks the jobs this instance started that never
+// finished as failed. It's meant to run at startup, before any new job is
+// started; other instances' jobs are still running in them. Jobs from
+// before instances were recorded belong to whichever starts first.
+func (c Client) FailInterruptedJobs() (int64, error) {
+	query := `
+	UPDATE jobs
+	SET status = ?, error = 'interrupted by a server restart', finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE status IN (?, ?) AND instance IN (?, '')
+	`
+	res, err := c.db.Exec(query, JobFailed, JobQueued, JobRunning, c.instance)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetUserJobs returns the user's jobs of the given type, newest first.
+func (c Client) GetUserJobs(userID uuid.UUID, jobType string) ([]Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE user_id = ? AND type = ?
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, userID.String(), jobType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// GetUnpurgedJobs returns the jobs of the given type whose output hasn't
+// been deleted yet, oldest first.
+func (c Client) GetUnpurgedJobs(jobType string) ([]Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE type = ? AND purged_at IS NULL
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, jobType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// MarkJobPurged records that the job's output was deleted.
+func (c Client) MarkJobPurged(id uuid.UUID) error {
+	query := `
+	UPDATE jobs
+	SET purged_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"time"
+)
+
+// AcquireLease takes the named lease for holder until expiresAt, reporting
+// false when someone else holds it. A holder that already has the lease
+// extends it, and a lease that has expired can be taken over.
+func (c Client) AcquireLease(name, holder string, now, expiresAt time.Time) (bool, error) {
+	query := `
+	INSERT INTO leases (name, holder, expires_at)
+	VALUES (?, ?, ?)
+	ON CONFLICT(name) DO UPDATE SET
+		holder = excluded.holder,
+		expires_at = excluded.expires_at
+	WHERE leases.holder = excluded.holder OR leases.expires_at <= ?
+	`
+	res, err := c.db.Exec(query, name, holder, expiresAt.UTC(), now.UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// ReleaseLease gives up the lease if holder still has it.
+func (c Client) ReleaseLease(name, holder string) error {
+	_, err := c.db.Exec("DELETE FROM leases WHERE name = ? AND holder = ?", name, holder)
+	return err
+}
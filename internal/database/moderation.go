@@ -0,0 +1,63 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Outcomes of automatic moderation. Flagged videos get a report in the
+// moderation queue; unlisted ones were also taken out of public listings.
+const (
+	ModerationPassed   = "passed"
+	ModerationFlagged  = "flagged"
+	ModerationUnlisted = "unlisted"
+)
+
+// ModerationResult is the latest automatic moderation run on a video.
+// Labels is kept as the provider-neutral JSON the server produced.
+type ModerationResult struct {
+	VideoID   uuid.UUID       `json:"video_id"`
+	CheckedAt time.Time       `json:"checked_at"`
+	Provider  string          `json:"provider"`
+	Status    string          `json:"status"`
+	Labels    json.RawMessage `json:"labels"`
+}
+
+func (c Client) SetModerationResult(result ModerationResult) error {
+	query := `
+	INSERT INTO video_moderation (video_id, checked_at, provider, status, labels)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(video_id) DO UPDATE SET
+		checked_at = excluded.checked_at,
+		provider = excluded.provider,
+		status = excluded.status,
+		labels = excluded.labels
+	`
+	_, err := c.db.Exec(query, result.VideoID, result.CheckedAt.UTC(), result.Provider, result.Status, string(result.Labels))
+	return err
+}
+
+func (c Client) GetModerationResult(videoID uuid.UUID) (*ModerationResult, error) {
+	query := `
+	SELECT video_id, checked_at, provider, status, labels
+	FROM video_moderation
+	WHERE video_id = ?
+	`
+	var (
+		result ModerationResult
+		labels string
+	)
+	err := c.db.QueryRow(query, videoID).Scan(&result.VideoID, &result.CheckedAt, &result.Provider, &result.Status, &labels)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	result.Labels = json.RawMessage(labels)
+	return &result, nil
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StoredVideo is the object a video points at, for checking against a
+// listing of the bucket.
+type StoredVideo struct {
+	VideoID   uuid.UUID
+	Key       string
+	Size      int64
+	UpdatedAt time.Time
+}
+
+func (c Client) GetStoredVideos() ([]StoredVideo, error) {
+	rows, err := c.db.Query("SELECT id, video_key, video_size, updated_at FROM videos WHERE video_key IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []StoredVideo{}
+	for rows.Next() {
+		var v StoredVideo
+		if err := rows.Scan(&v.VideoID, &v.Key, &v.Size, &v.UpdatedAt); err != nil {
+			return nil, err
+		}
+		videos = append(videos, v)
+	}
+	return videos, rows.Err()
+}
+
+// GetReferencedObjectKeys returns the keys rows point at other than
+// videos' current objects: pending direct uploads, earlier versions,
+// resumable uploads and captions.
+func (c Client) GetReferencedObjectKeys() ([]string, error) {
+	query := `
+	SELECT pending_video_key FROM videos WHERE pending_video_key IS NOT NULL
+	UNION SELECT key FROM video_versions
+	UNION SELECT object_key FROM upload_sessions
+	UNION SELECT key FROM captions
+	UNION SELECT key FROM video_renditions
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
@@ -0,0 +1,325 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Organization roles. Admins manage the members and the library; members
+// upload to and manage the library.
+const (
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+func ValidOrgRole(role string) bool {
+	return role == OrgRoleAdmin || role == OrgRoleMember
+}
+
+// Organization is a shared library. Its videos count against the
+// organization's plan and usage instead of their uploader's.
+type Organization struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	PlanID      string    `json:"plan_id"`
+	BytesUsed   int64     `json:"bytes_used"`
+	ObjectCount int64     `json:"object_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+const organizationColumns = `
+		id,
+		name,
+		COALESCE(plan_id, ''),
+		bytes_used,
+		object_count,
+		created_at,
+		updated_at
+`
+
+func scanOrganization(row rowScanner) (Organization, error) {
+	var org Organization
+	err := row.Scan(
+		&org.ID,
+		&org.Name,
+		&org.PlanID,
+		&org.BytesUsed,
+		&org.ObjectCount,
+		&org.CreatedAt,
+		&org.UpdatedAt,
+	)
+	return org, err
+}
+
+// OrganizationMember is a user's membership of an organization, with the
+// user's email for listings.
+type OrganizationMember struct {
+	OrgID     uuid.UUID `json:"org_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserOrganization is an organization along with the user's role in it.
+type UserOrganization struct {
+	Organization
+	Role string `json:"role"`
+}
+
+// CreateOrganization creates the organization with its creator as the
+// first admin.
+func (c Client) CreateOrganization(name string, creatorID uuid.UUID) (Organization, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Organization{}, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO organizations (id, name, created_at, updated_at)
+	VALUES (?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	RETURNING` + organizationColumns
+	org, err := scanOrganization(tx.QueryRow(query, uuid.New(), name))
+	if err != nil {
+		return Organization{}, err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO organization_members (org_id, user_id, role, created_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`, org.ID, creatorID, OrgRoleAdmin)
+	if err != nil {
+		return Organization{}, err
+	}
+	return org, tx.Commit()
+}
+
+func (c Client) GetOrganization(id uuid.UUID) (Organization, error) {
+	query := `
+	SELECT` + organizationColumns + `
+	FROM organizations
+	WHERE id = ?
+	`
+	org, err := scanOrganization(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Organization{}, nil
+	}
+	return org, err
+}
+
+// GetUserOrganizations lists the organizations the user belongs to, by
+// name.
+func (c Client) GetUserOrganizations(userID uuid.UUID) ([]UserOrganization, error) {
+	query := `
+	SELECT` + organizationColumns + `, m.role
+	FROM organizations
+	JOIN (SELECT org_id, role FROM organization_members WHERE user_id = ?) m ON m.org_id = organizations.id
+	ORDER BY name, created_at
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []UserOrganization{}
+	for rows.Next() {
+		var org UserOrganization
+		err := rows.Scan(
+			&org.ID,
+			&org.Name,
+			&org.PlanID,
+			&org.BytesUsed,
+			&org.ObjectCount,
+			&org.CreatedAt,
+			&org.UpdatedAt,
+			&org.Role,
+		)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
+}
+
+func (c Client) SetOrganizationPlan(id uuid.UUID, planID string) error {
+	query := `
+	UPDATE organizations
+	SET plan_id = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, planID, id)
+	return err
+}
+
+// GetOrganizationPlan returns the plan assigned to an organization,
+// falling back to the default plan like GetUserPlan does.
+func (c Client) GetOrganizationPlan(id uuid.UUID) (*Plan, error) {
+	var planID sql.NullString
+	err := c.db.QueryRow(`SELECT plan_id FROM organizations WHERE id = ?`, id).Scan(&planID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if planID.Valid && planID.String != "" {
+		plan, err := c.GetPlan(planID.String)
+		if err != nil || plan != nil {
+			return plan, err
+		}
+	}
+	return c.GetPlan(DefaultPlanID)
+}
+
+// AdjustOrganizationUsage applies a delta to an organization's running
+// storage totals.
+func (c Client) AdjustOrganizationUsage(id uuid.UUID, bytesDelta, objectsDelta int64) error {
+	return adjustOrganizationUsage(c.db, id, bytesDelta, objectsDelta)
+}
+
+func adjustOrganizationUsage(db execer, id uuid.UUID, bytesDelta, objectsDelta int64) error {
+	query := `
+	UPDATE organizations
+	SET bytes_used = MAX(bytes_used + ?, 0),
+		object_count = MAX(object_count + ?, 0),
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := db.Exec(query, bytesDelta, objectsDelta, id)
+	return err
+}
+
+const memberColumns = `
+		m.org_id,
+		m.user_id,
+		u.email,
+		m.role,
+		m.created_at
+`
+
+func scanMember(row rowScanner) (OrganizationMember, error) {
+	var member OrganizationMember
+	err := row.Scan(
+		&member.OrgID,
+		&member.UserID,
+		&member.Email,
+		&member.Role,
+		&member.CreatedAt,
+	)
+	return member, err
+}
+
+// GetOrganizationMember returns the user's membership, or a zero value if
+// they aren't a member.
+func (c Client) GetOrganizationMember(orgID, userID uuid.UUID) (OrganizationMember, error) {
+	query := `
+	SELECT` + memberColumns + `
+	FROM organization_members m
+	JOIN users u ON u.id = m.user_id
+	WHERE m.org_id = ? AND m.user_id = ?
+	`
+	member, err := scanMember(c.db.QueryRow(query, orgID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return OrganizationMember{}, nil
+	}
+	return member, err
+}
+
+func (c Client) GetOrganizationMembers(orgID uuid.UUID) ([]OrganizationMember, error) {
+	query := `
+	SELECT` + memberColumns + `
+	FROM organization_members m
+	JOIN users u ON u.id = m.user_id
+	WHERE m.org_id = ?
+	ORDER BY u.email
+	`
+	rows, err := c.db.Query(query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []OrganizationMember{}
+	for rows.Next() {
+		member, err := scanMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// SetOrganizationMember adds the user to the organization, or changes
+// their role if they're already in it.
+func (c Client) SetOrganizationMember(orgID, userID uuid.UUID, role string) error {
+	query := `
+	INSERT INTO organization_members (org_id, user_id, role, created_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(org_id, user_id) DO UPDATE SET role = excluded.role
+	`
+	_, err := c.db.Exec(query, orgID, userID, role)
+	return err
+}
+
+func (c Client) RemoveOrganizationMember(orgID, userID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM organization_members WHERE org_id = ? AND user_id = ?", orgID, userID)
+	return err
+}
+
+func (c Client) CountOrganizationAdmins(orgID uuid.UUID) (int, error) {
+	var count int
+	err := c.db.QueryRow("SELECT COUNT(*) FROM organization_members WHERE org_id = ? AND role = ?", orgID, OrgRoleAdmin).Scan(&count)
+	return count, err
+}
+
+// SetVideoOrganization moves the video between its owner's personal
+// library and an organization's, nil orgID being the personal library,
+// and moves bytes and objects of storage usage with it. The video has to
+// still be at the revision it was read at.
+func (c Client) SetVideoOrganization(video Video, orgID *uuid.UUID, bytes, objects int64) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+	UPDATE videos
+	SET org_id = ?, revision = revision + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND revision = ?
+	`, orgID, video.ID, video.Revision)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrVideoChanged
+	}
+
+	if err := adjustAccountUsage(tx, video.UserID, video.OrgID, -bytes, -objects); err != nil {
+		return err
+	}
+	if err := adjustAccountUsage(tx, video.UserID, orgID, bytes, objects); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	c.videoChanged(video.ID)
+	return nil
+}
+
+// adjustAccountUsage charges the organization when there is one, and the
+// user otherwise.
+func adjustAccountUsage(db execer, userID uuid.UUID, orgID *uuid.UUID, bytesDelta, objectsDelta int64) error {
+	if orgID != nil {
+		return adjustOrganizationUsage(db, *orgID, bytesDelta, objectsDelta)
+	}
+	return adjustUserUsage(db, userID, bytesDelta, objectsDelta)
+}
@@ -0,0 +1,29 @@
+package database
+
+import (
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
+)
+
+// sqliteTimeLayout matches how CURRENT_TIMESTAMP values are stored, so
+// cursor timestamps compare correctly as text.
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
+// keyset returns the WHERE condition, ORDER BY clause and arguments for
+// fetching one page (plus one lookahead row) of a created_at/id ordered
+// table.
+func keyset(params pagination.Params) (string, string, []any) {
+	return keysetOn(params, "created_at", "id")
+}
+
+// keysetOn is keyset for tables ordered by other timestamp and ID columns.
+func keysetOn(params pagination.Params, timeColumn, idColumn string) (string, string, []any) {
+	cols := "(" + timeColumn + ", " + idColumn + ")"
+	if params.Cursor == nil {
+		return "1 = 1", timeColumn + " DESC, " + idColumn + " DESC", nil
+	}
+	args := []any{params.Cursor.CreatedAt.UTC().Format(sqliteTimeLayout), params.Cursor.ID}
+	if params.Backward() {
+		return cols + " > (?, ?)", timeColumn + " ASC, " + idColumn + " ASC", args
+	}
+	return cols + " < (?, ?)", timeColumn + " DESC, " + idColumn + " DESC", args
+}
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+const DefaultPlanID = "free"
+
+type Plan struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	MaxFileSize     int64  `json:"max_file_size"`
+	MaxTotalStorage int64  `json:"max_total_storage"`
+	MaxRenditions   int    `json:"max_renditions"`
+	Priority        int    `json:"priority"`
+	// MaxUploadRate caps how many bytes per second the server reads from
+	// all uploads to videos on the plan together, whoever sends them; zero
+	// means no cap.
+	MaxUploadRate int64 `json:"max_upload_rate"`
+	// MaxDurationSeconds and MaxResolution limit what's accepted for
+	// processing; zero means no limit. MaxResolution is the short side of
+	// the picture in pixels, so 1080 allows 1920x1080 and 1080x1920.
+	MaxDurationSeconds int `json:"max_duration_seconds"`
+	MaxResolution      int `json:"max_resolution"`
+	// PresetID names the transcoding preset uploads on this plan use.
+	PresetID string `json:"preset_id,omitempty"`
+}
+
+var defaultPlans = []Plan{
+	{
+		ID:                 DefaultPlanID,
+		Name:               "Free",
+		MaxFileSize:        1 << 30,
+		MaxTotalStorage:    5 << 30,
+		MaxRenditions:      2,
+		Priority:           0,
+		MaxUploadRate:      5 << 20,
+		MaxDurationSeconds: 60 * 60,
+		MaxResolution:      1080,
+	},
+	{
+		ID:                 "pro",
+		Name:               "Pro",
+		MaxFileSize:        10 << 30,
+		MaxTotalStorage:    500 << 30,
+		MaxRenditions:      5,
+		Priority:           10,
+		MaxDurationSeconds: 12 * 60 * 60,
+		MaxResolution:      2160,
+	},
+}
+
+func (c *Client) seedPlans() error {
+	query := `
+	INSERT OR IGNORE INTO plans (
+		id,
+		name,
+		max_file_size,
+		max_total_storage,
+		max_renditions,
+		priority,
+		max_upload_rate,
+		max_duration_seconds,
+		max_resolution
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	for _, plan := range defaultPlans {
+		_, err := c.db.Exec(
+			query,
+			plan.ID,
+			plan.Name,
+			plan.MaxFileSize,
+			plan.MaxTotalStorage,
+			plan.MaxRenditions,
+			plan.Priority,
+			plan.MaxUploadRate,
+			plan.MaxDurationSeconds,
+			plan.MaxResolution,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c Client) GetPlans() ([]Plan, error) {
+	query := `
+	SELECT id, name, max_file_size, max_total_storage, max_renditions, priority, max_upload_rate, max_duration_seconds, max_resolution, COALESCE(preset_id, '')
+	FROM plans
+	ORDER BY priority
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plans := []Plan{}
+	for rows.Next() {
+		var plan Plan
+		if err := rows.Scan(
+			&plan.ID,
+			&plan.Name,
+			&plan.MaxFileSize,
+			&plan.MaxTotalStorage,
+			&plan.MaxRenditions,
+			&plan.Priority,
+			&plan.MaxUploadRate,
+			&plan.MaxDurationSeconds,
+			&plan.MaxResolution,
+			&plan.PresetID,
+		); err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+
+	return plans, rows.Err()
+}
+
+func (c Client) GetPlan(id string) (*Plan, error) {
+	query := `
+	SELECT id, name, max_file_size, max_total_storage, max_renditions, priority, max_upload_rate, max_duration_seconds, max_resolution, COALESCE(preset_id, '')
+	FROM plans
+	WHERE id = ?
+	`
+	var plan Plan
+	err := c.db.QueryRow(query, id).Scan(
+		&plan.ID,
+		&plan.Name,
+		&plan.MaxFileSize,
+		&plan.MaxTotalStorage,
+		&plan.MaxRenditions,
+		&plan.Priority,
+		&plan.MaxUploadRate,
+		&plan.MaxDurationSeconds,
+		&plan.MaxResolution,
+		&plan.PresetID,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// GetUserPlan returns the plan assigned to a user, falling back to the
+// default plan for users without one.
+func (c Client) GetUserPlan(userID uuid.UUID) (*Plan, error) {
+	var planID sql.NullString
+	err := c.db.QueryRow(`SELECT plan_id FROM users WHERE id = ?`, userID.String()).Scan(&planID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if planID.Valid && planID.String != "" {
+		plan, err := c.GetPlan(planID.String)
+		if err != nil || plan != nil {
+			return plan, err
+		}
+	}
+	return c.GetPlan(DefaultPlanID)
+}
+
+// SetPlanMediaLimits sets the longest and highest resolution videos the
+// plan accepts. Zero removes a limit.
+func (c Client) SetPlanMediaLimits(planID string, maxDurationSeconds, maxResolution int) error {
+	query := `
+	UPDATE plans
+	SET max_duration_seconds = ?, max_resolution = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, maxDurationSeconds, maxResolution, planID)
+	return err
+}
+
+func (c Client) SetUserPlan(userID uuid.UUID, planID string) error {
+	query := `
+	UPDATE users
+	SET plan_id = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, planID, userID.String())
+	return err
+}
@@ -0,0 +1,199 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Rendition is one rung of a preset's output ladder.
+type Rendition struct {
+	Name             string `json:"name"`
+	Height           int    `json:"height"`
+	VideoBitrateKbps int    `json:"video_bitrate_kbps"`
+	AudioBitrateKbps int    `json:"audio_bitrate_kbps"`
+}
+
+// TranscodingPreset is a named rendition ladder and the encoder options
+// used to produce it.
+type TranscodingPreset struct {
+	ID         string      `json:"id"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+	Name       string      `json:"name"`
+	VideoCodec string      `json:"video_codec"`
+	AudioCodec string      `json:"audio_codec"`
+	Renditions []Rendition `json:"renditions"`
+	// MaxFrameRate caps the frame rate of the renditions, so a 120fps
+	// screen recording is encoded at 60fps; zero keeps the source's.
+	MaxFrameRate int `json:"max_frame_rate"`
+}
+
+const presetColumns = `
+		id,
+		created_at,
+		updated_at,
+		name,
+		video_codec,
+		audio_codec,
+		renditions,
+		max_frame_rate
+`
+
+func scanPreset(row rowScanner) (TranscodingPreset, error) {
+	var (
+		preset     TranscodingPreset
+		renditions string
+	)
+	err := row.Scan(
+		&preset.ID,
+		&preset.CreatedAt,
+		&preset.UpdatedAt,
+		&preset.Name,
+		&preset.VideoCodec,
+		&preset.AudioCodec,
+		&renditions,
+		&preset.MaxFrameRate,
+	)
+	if err != nil {
+		return preset, err
+	}
+	err = json.Unmarshal([]byte(renditions), &preset.Renditions)
+	return preset, err
+}
+
+func (c Client) GetPresets() ([]TranscodingPreset, error) {
+	query := `
+	SELECT` + presetColumns + `
+	FROM transcoding_presets
+	ORDER BY id
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	presets := []TranscodingPreset{}
+	for rows.Next() {
+		preset, err := scanPreset(rows)
+		if err != nil {
+			return nil, err
+		}
+		presets = append(presets, preset)
+	}
+
+	return presets, rows.Err()
+}
+
+// GetPreset returns nil when there is no preset with that ID.
+func (c Client) GetPreset(id string) (*TranscodingPreset, error) {
+	query := `
+	SELECT` + presetColumns + `
+	FROM transcoding_presets
+	WHERE id = ?
+	`
+	preset, err := scanPreset(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &preset, nil
+}
+
+func (c Client) CreatePreset(preset TranscodingPreset) (*TranscodingPreset, error) {
+	renditions, err := json.Marshal(preset.Renditions)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+	INSERT INTO transcoding_presets (
+		id,
+		created_at,
+		updated_at,
+		name,
+		video_codec,
+		audio_codec,
+		renditions,
+		max_frame_rate
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err = c.db.Exec(query, preset.ID, preset.Name, preset.VideoCodec, preset.AudioCodec, string(renditions), preset.MaxFrameRate)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetPreset(preset.ID)
+}
+
+func (c Client) UpdatePreset(preset TranscodingPreset) (*TranscodingPreset, error) {
+	renditions, err := json.Marshal(preset.Renditions)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+	UPDATE transcoding_presets
+	SET
+		name = ?,
+		video_codec = ?,
+		audio_codec = ?,
+		renditions = ?,
+		max_frame_rate = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err = c.db.Exec(query, preset.Name, preset.VideoCodec, preset.AudioCodec, string(renditions), preset.MaxFrameRate, preset.ID)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetPreset(preset.ID)
+}
+
+func (c Client) DeletePreset(id string) error {
+	_, err := c.db.Exec("DELETE FROM transcoding_presets WHERE id = ?", id)
+	return err
+}
+
+// GetPresetPlans returns the IDs of the plans that use a preset.
+func (c Client) GetPresetPlans(presetID string) ([]string, error) {
+	rows, err := c.db.Query("SELECT id FROM plans WHERE preset_id = ? ORDER BY priority", presetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	planIDs := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		planIDs = append(planIDs, id)
+	}
+	return planIDs, rows.Err()
+}
+
+// SetPlanPreset assigns a preset to a plan. An empty presetID clears it.
+func (c Client) SetPlanPreset(planID, presetID string) error {
+	var preset sql.NullString
+	if presetID != "" {
+		preset = sql.NullString{String: presetID, Valid: true}
+	}
+	_, err := c.db.Exec("UPDATE plans SET preset_id = ? WHERE id = ?", preset, planID)
+	return err
+}
+
+// GetUserPreset returns the preset of the user's plan, or nil when the plan
+// doesn't have one.
+func (c Client) GetUserPreset(userID uuid.UUID) (*TranscodingPreset, error) {
+	plan, err := c.GetUserPlan(userID)
+	if err != nil || plan == nil || plan.PresetID == "" {
+		return nil, err
+	}
+	return c.GetPreset(plan.PresetID)
+}
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
+	"github.com/google/uuid"
+)
+
+// Outcomes of a processing run.
+const (
+	ProcessingSucceeded = "succeeded"
+	ProcessingFailed    = "failed"
+)
+
+// ProcessingRun is one pass of a video through the processing pipeline,
+// recorded when it finishes.
+type ProcessingRun struct {
+	ID uuid.UUID `json:"id"`
+	// CreatedAt is when the run finished.
+	CreatedAt time.Time `json:"created_at"`
+	StartedAt time.Time `json:"started_at"`
+	VideoID   uuid.UUID `json:"video_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Filename  string    `json:"filename,omitempty"`
+	Size      int64     `json:"size"`
+	// Reprocess is set for runs of videos that were already stored.
+	Reprocess  bool   `json:"reprocess"`
+	DurationMS int64  `json:"duration_ms"`
+	Status     string `json:"status"`
+	// Stage, Error and Stderr say where and why a failed run stopped.
+	// Stderr is the end of ffmpeg's output, when ffmpeg was what failed.
+	Stage  string `json:"stage,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Stderr string `json:"stderr,omitempty"`
+}
+
+func (c Client) CreateProcessingRun(run ProcessingRun) error {
+	query := `
+	INSERT INTO processing_runs (
+		id,
+		created_at,
+		started_at,
+		video_id,
+		user_id,
+		filename,
+		size,
+		reprocess,
+		duration_ms,
+		status,
+		stage,
+		error,
+		stderr
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		uuid.New().String(),
+		run.StartedAt.UTC(),
+		run.VideoID.String(),
+		run.UserID.String(),
+		run.Filename,
+		run.Size,
+		run.Reprocess,
+		run.DurationMS,
+		run.Status,
+		run.Stage,
+		run.Error,
+		run.Stderr,
+	)
+	return err
+}
+
+// GetProcessingRunsPage lists runs newest first. uploadsOnly leaves out
+// reprocessing runs, failedOnly the runs that succeeded.
+func (c Client) GetProcessingRunsPage(uploadsOnly, failedOnly bool, params pagination.Params) ([]ProcessingRun, pagination.Page, error) {
+	where, order, args := keyset(params)
+	if uploadsOnly {
+		where += " AND reprocess = FALSE"
+	}
+	if failedOnly {
+		where += " AND status = ?"
+		args = append(args, ProcessingFailed)
+	}
+	args = append(args, params.Limit+1)
+
+	query := `
+	SELECT id, created_at, started_at, video_id, user_id, filename, size, reprocess, duration_ms, status, stage, error, stderr
+	FROM processing_runs
+	WHERE ` + where + `
+	ORDER BY ` + order + `
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, pagination.Page{}, err
+	}
+	defer rows.Close()
+
+	runs := []ProcessingRun{}
+	for rows.Next() {
+		var run ProcessingRun
+		err := rows.Scan(
+			&run.ID,
+			&run.CreatedAt,
+			&run.StartedAt,
+			&run.VideoID,
+			&run.UserID,
+			&run.Filename,
+			&run.Size,
+			&run.Reprocess,
+			&run.DurationMS,
+			&run.Status,
+			&run.Stage,
+			&run.Error,
+			&run.Stderr,
+		)
+		if err != nil {
+			return nil, pagination.Page{}, err
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pagination.Page{}, err
+	}
+
+	runs, page := pagination.Paginate(runs, params, func(r ProcessingRun) pagination.Cursor {
+		return pagination.Cursor{CreatedAt: r.CreatedAt, ID: r.ID.String()}
+	})
+	return runs, page, nil
+}
+
+// DeleteProcessingRunsBefore removes runs that finished before the given
+// time and returns how many there were.
+func (c Client) DeleteProcessingRunsBefore(before time.Time) (int64, error) {
+	res, err := c.db.Exec("DELETE FROM processing_runs WHERE created_at < ?", before.UTC().Format(sqliteTimeLayout))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
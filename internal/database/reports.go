@@ -0,0 +1,186 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
+	"github.com/google/uuid"
+)
+
+// Report reasons users can pick from.
+var ReportReasons = []string{
+	"spam",
+	"harassment",
+	"hate",
+	"violence",
+	"sexual",
+	"copyright",
+	"other",
+}
+
+// Report states. Open reports make up the moderation queue; resolving one
+// resolves every open report on the same video.
+const (
+	ReportOpen      = "open"
+	ReportDismissed = "dismissed"
+	ReportActioned  = "actioned"
+)
+
+type Report struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	VideoID    uuid.UUID  `json:"video_id"`
+	ReporterID uuid.UUID  `json:"reporter_id"`
+	Reason     string     `json:"reason"`
+	Message    string     `json:"message"`
+	Status     string     `json:"status"`
+	Resolution string     `json:"resolution,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+const reportColumns = `
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		reporter_id,
+		reason,
+		message,
+		status,
+		resolution,
+		resolved_at
+`
+
+func scanReport(row rowScanner) (Report, error) {
+	var report Report
+	err := row.Scan(
+		&report.ID,
+		&report.CreatedAt,
+		&report.UpdatedAt,
+		&report.VideoID,
+		&report.ReporterID,
+		&report.Reason,
+		&report.Message,
+		&report.Status,
+		&report.Resolution,
+		&report.ResolvedAt,
+	)
+	return report, err
+}
+
+// ErrAlreadyReported is returned when the reporter already has an open
+// report on the video.
+var ErrAlreadyReported = errors.New("video already reported")
+
+type CreateReportParams struct {
+	VideoID    uuid.UUID
+	ReporterID uuid.UUID
+	Reason     string
+	Message    string
+}
+
+func (c Client) CreateReport(params CreateReportParams) (Report, error) {
+	query := `
+	INSERT INTO video_reports (id, created_at, updated_at, video_id, reporter_id, reason, message, status)
+	SELECT ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?
+	WHERE NOT EXISTS (
+		SELECT 1 FROM video_reports WHERE video_id = ? AND reporter_id = ? AND status = ?
+	)
+	RETURNING` + reportColumns
+
+	report, err := scanReport(c.db.QueryRow(
+		query,
+		uuid.New(),
+		params.VideoID,
+		params.ReporterID,
+		params.Reason,
+		params.Message,
+		ReportOpen,
+		params.VideoID,
+		params.ReporterID,
+		ReportOpen,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Report{}, ErrAlreadyReported
+	}
+	return report, err
+}
+
+func (c Client) GetReport(id uuid.UUID) (Report, error) {
+	query := `
+	SELECT` + reportColumns + `
+	FROM video_reports
+	WHERE id = ?
+	`
+	report, err := scanReport(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Report{}, nil
+	}
+	return report, err
+}
+
+// GetReportsPage lists reports in the given status, newest first.
+func (c Client) GetReportsPage(status string, params pagination.Params) ([]Report, pagination.Page, error) {
+	where, order, args := keyset(params)
+	where += " AND status = ?"
+	args = append(args, status, params.Limit+1)
+
+	query := `
+	SELECT` + reportColumns + `
+	FROM video_reports
+	WHERE ` + where + `
+	ORDER BY ` + order + `
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, pagination.Page{}, err
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, pagination.Page{}, err
+		}
+		reports = append(reports, report)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pagination.Page{}, err
+	}
+
+	reports, page := pagination.Paginate(reports, params, func(r Report) pagination.Cursor {
+		return pagination.Cursor{CreatedAt: r.CreatedAt, ID: r.ID.String()}
+	})
+	return reports, page, nil
+}
+
+// CountOpenReports returns how many open reports the video has.
+func (c Client) CountOpenReports(videoID uuid.UUID) (int, error) {
+	var n int
+	err := c.db.QueryRow(
+		"SELECT COUNT(*) FROM video_reports WHERE video_id = ? AND status = ?",
+		videoID, ReportOpen,
+	).Scan(&n)
+	return n, err
+}
+
+// ResolveVideoReports closes every open report on the video with the given
+// status and resolution, returning how many it closed.
+func (c Client) ResolveVideoReports(videoID uuid.UUID, status, resolution string) (int64, error) {
+	query := `
+	UPDATE video_reports
+	SET status = ?, resolution = ?, resolved_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE video_id = ? AND status = ?
+	`
+	res, err := c.db.Exec(query, status, resolution, videoID, ReportOpen)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserSettings are the defaults applied to a user's new videos when the
+// create request doesn't say otherwise.
+type UserSettings struct {
+	Visibility    string    `json:"visibility"`
+	PresetID      string    `json:"preset_id"`
+	AutoThumbnail bool      `json:"auto_thumbnail"`
+	Watermark     bool      `json:"watermark"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// DefaultUserSettings are used for users who never saved any.
+var DefaultUserSettings = UserSettings{
+	Visibility:    VisibilityPublic,
+	AutoThumbnail: true,
+}
+
+func (c Client) GetUserSettings(userID uuid.UUID) (UserSettings, error) {
+	query := `
+	SELECT visibility, preset_id, auto_thumbnail, watermark, updated_at
+	FROM user_settings
+	WHERE user_id = ?
+	`
+	var settings UserSettings
+	err := c.db.QueryRow(query, userID.String()).Scan(
+		&settings.Visibility,
+		&settings.PresetID,
+		&settings.AutoThumbnail,
+		&settings.Watermark,
+		&settings.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DefaultUserSettings, nil
+	}
+	if err != nil {
+		return UserSettings{}, err
+	}
+	return settings, nil
+}
+
+func (c Client) SetUserSettings(userID uuid.UUID, settings UserSettings) error {
+	query := `
+	INSERT INTO user_settings (user_id, visibility, preset_id, auto_thumbnail, watermark, updated_at)
+	VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(user_id) DO UPDATE SET
+		visibility = excluded.visibility,
+		preset_id = excluded.preset_id,
+		auto_thumbnail = excluded.auto_thumbnail,
+		watermark = excluded.watermark,
+		updated_at = CURRENT_TIMESTAMP
+	`
+	_, err := c.db.Exec(
+		query,
+		userID.String(),
+		settings.Visibility,
+		settings.PresetID,
+		settings.AutoThumbnail,
+		settings.Watermark,
+	)
+	return err
+}
@@ -0,0 +1,150 @@
+package database
+
+import "time"
+
+// StorageStats are the running totals the usage counters keep, so they
+// cost nothing to read however big the bucket gets.
+type StorageStats struct {
+	Videos int64 `json:"videos"`
+	// Bytes and Objects are what users' and organizations' usage adds up
+	// to, thumbnails and renditions included.
+	Bytes   int64 `json:"bytes"`
+	Objects int64 `json:"objects"`
+}
+
+func (c Client) GetStorageStats() (StorageStats, error) {
+	query := `
+	SELECT
+		(SELECT COUNT(*) FROM videos),
+		(SELECT COALESCE(SUM(bytes_used), 0) FROM user_usage) + (SELECT COALESCE(SUM(bytes_used), 0) FROM organizations),
+		(SELECT COALESCE(SUM(object_count), 0) FROM user_usage) + (SELECT COALESCE(SUM(object_count), 0) FROM organizations)
+	`
+	var stats StorageStats
+	err := c.db.QueryRow(query).Scan(&stats.Videos, &stats.Bytes, &stats.Objects)
+	return stats, err
+}
+
+// StorageTotal is what's stored under one top-level key prefix in one
+// storage tier.
+type StorageTotal struct {
+	Prefix  string `json:"prefix"`
+	Tier    string `json:"tier"`
+	Objects int64  `json:"objects"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// GetStorageTotals adds up the video objects and renditions the database
+// refers to by the first directory of their key and the tier of their
+// video.
+func (c Client) GetStorageTotals() ([]StorageTotal, error) {
+	query := `
+	WITH objects AS (
+		SELECT video_key AS key, video_size AS size, storage_tier AS tier
+		FROM videos
+		WHERE video_key IS NOT NULL
+		UNION ALL
+		SELECT r.key, r.size, v.storage_tier
+		FROM video_renditions r
+		JOIN videos v ON v.id = r.video_id
+	)
+	SELECT
+		CASE WHEN instr(key, '/') > 0 THEN substr(key, 1, instr(key, '/') - 1) ELSE '' END AS prefix,
+		tier,
+		COUNT(*),
+		SUM(size)
+	FROM objects
+	GROUP BY prefix, tier
+	ORDER BY prefix, tier
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []StorageTotal{}
+	for rows.Next() {
+		var t StorageTotal
+		if err := rows.Scan(&t.Prefix, &t.Tier, &t.Objects, &t.Bytes); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
+
+// RecordProcessingOutcome counts a finished processing run of size bytes
+// in the hour it finished.
+func (c Client) RecordProcessingOutcome(at time.Time, failed bool, size int64) error {
+	succeeded, failures := 1, 0
+	if failed {
+		succeeded, failures = 0, 1
+	}
+	query := `
+	INSERT INTO processing_outcomes (hour, succeeded, failed, bytes)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(hour) DO UPDATE SET
+		succeeded = succeeded + excluded.succeeded,
+		failed = failed + excluded.failed,
+		bytes = bytes + excluded.bytes
+	`
+	_, err := c.db.Exec(query, at.UTC().Truncate(time.Hour), succeeded, failures, size)
+	return err
+}
+
+// ProcessingHour is how many runs finished in an hour, and how many bytes
+// of uploads they read.
+type ProcessingHour struct {
+	Hour      time.Time `json:"hour"`
+	Succeeded int64     `json:"succeeded"`
+	Failed    int64     `json:"failed"`
+	Bytes     int64     `json:"bytes"`
+}
+
+// GetProcessingHours lists the hours since the given time, oldest first.
+// Hours nothing finished in are left out.
+func (c Client) GetProcessingHours(since time.Time) ([]ProcessingHour, error) {
+	query := `
+	SELECT hour, succeeded, failed, bytes
+	FROM processing_outcomes
+	WHERE hour >= ?
+	ORDER BY hour
+	`
+	rows, err := c.db.Query(query, since.UTC().Truncate(time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hours := []ProcessingHour{}
+	for rows.Next() {
+		var h ProcessingHour
+		if err := rows.Scan(&h.Hour, &h.Succeeded, &h.Failed, &h.Bytes); err != nil {
+			return nil, err
+		}
+		hours = append(hours, h)
+	}
+	return hours, rows.Err()
+}
+
+// GetProcessingOutcomes adds up the runs that finished since the start of
+// the hour since falls in.
+func (c Client) GetProcessingOutcomes(since time.Time) (succeeded, failed int64, err error) {
+	query := `
+	SELECT COALESCE(SUM(succeeded), 0), COALESCE(SUM(failed), 0)
+	FROM processing_outcomes
+	WHERE hour >= ?
+	`
+	err = c.db.QueryRow(query, since.UTC().Truncate(time.Hour)).Scan(&succeeded, &failed)
+	return succeeded, failed, err
+}
+
+// DeleteProcessingOutcomesBefore removes the counts of the hours before
+// the given time and returns how many hours there were.
+func (c Client) DeleteProcessingOutcomesBefore(before time.Time) (int64, error) {
+	res, err := c.db.Exec("DELETE FROM processing_outcomes WHERE hour < ?", before.UTC().Truncate(time.Hour))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
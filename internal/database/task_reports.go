@@ -0,0 +1,39 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+)
+
+// SetTaskReport stores the report of a background task's latest run,
+// replacing the one before, so whichever instance is asked can serve it.
+func (c Client) SetTaskReport(task string, report any) error {
+	dat, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO task_reports (task, report, updated_at)
+	VALUES (?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(task) DO UPDATE SET
+		report = excluded.report,
+		updated_at = excluded.updated_at
+	`
+	_, err = c.db.Exec(query, task, string(dat))
+	return err
+}
+
+// GetTaskReport returns the report of the task's latest run, or nil if it
+// hasn't stored one.
+func (c Client) GetTaskReport(task string) (json.RawMessage, error) {
+	var report string
+	err := c.db.QueryRow("SELECT report FROM task_reports WHERE task = ?", task).Scan(&report)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(report), nil
+}
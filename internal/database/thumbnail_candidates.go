@@ -0,0 +1,132 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ThumbnailCandidate is a frame grabbed from the video while it was
+// processed, kept as an asset the owner can promote to the thumbnail.
+type ThumbnailCandidate struct {
+	ID        uuid.UUID `json:"id"`
+	VideoID   uuid.UUID `json:"video_id"`
+	Position  int       `json:"position"`
+	Seconds   float64   `json:"seconds"`
+	FileName  string    `json:"-"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const thumbnailCandidateColumns = `
+		id,
+		video_id,
+		position,
+		seconds,
+		file_name,
+		size,
+		created_at
+`
+
+func scanThumbnailCandidate(row rowScanner) (ThumbnailCandidate, error) {
+	var candidate ThumbnailCandidate
+	err := row.Scan(
+		&candidate.ID,
+		&candidate.VideoID,
+		&candidate.Position,
+		&candidate.Seconds,
+		&candidate.FileName,
+		&candidate.Size,
+		&candidate.CreatedAt,
+	)
+	return candidate, err
+}
+
+// ReplaceThumbnailCandidates swaps the video's candidates for a new set
+// and returns the ones it replaced, whose files the caller removes.
+func (c Client) ReplaceThumbnailCandidates(videoID uuid.UUID, candidates []ThumbnailCandidate) ([]ThumbnailCandidate, error) {
+	replaced, err := c.GetThumbnailCandidates(videoID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM thumbnail_candidates WHERE video_id = ?", videoID); err != nil {
+		return nil, err
+	}
+	query := `
+	INSERT INTO thumbnail_candidates (id, video_id, position, seconds, file_name, size, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	for _, candidate := range candidates {
+		_, err := tx.Exec(query, uuid.New(), videoID, candidate.Position, candidate.Seconds, candidate.FileName, candidate.Size)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return replaced, tx.Commit()
+}
+
+func (c Client) GetThumbnailCandidates(videoID uuid.UUID) ([]ThumbnailCandidate, error) {
+	query := `
+	SELECT` + thumbnailCandidateColumns + `
+	FROM thumbnail_candidates
+	WHERE video_id = ?
+	ORDER BY position
+	`
+
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []ThumbnailCandidate{}
+	for rows.Next() {
+		candidate, err := scanThumbnailCandidate(rows)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, rows.Err()
+}
+
+func (c Client) GetThumbnailCandidate(id uuid.UUID) (ThumbnailCandidate, error) {
+	query := `
+	SELECT` + thumbnailCandidateColumns + `
+	FROM thumbnail_candidates
+	WHERE id = ?
+	`
+	candidate, err := scanThumbnailCandidate(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ThumbnailCandidate{}, nil
+	}
+	return candidate, err
+}
+
+// GetThumbnailCandidateFiles lists the asset files of every candidate.
+func (c Client) GetThumbnailCandidateFiles() ([]string, error) {
+	rows, err := c.db.Query("SELECT file_name FROM thumbnail_candidates")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []string{}
+	for rows.Next() {
+		var file string
+		if err := rows.Scan(&file); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
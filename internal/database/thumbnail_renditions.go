@@ -0,0 +1,116 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// ThumbnailRendition is the thumbnail cut and scaled to one of the
+// configured sizes, following the framing chosen for the video.
+type ThumbnailRendition struct {
+	VideoID  uuid.UUID `json:"video_id"`
+	Width    int       `json:"width"`
+	Height   int       `json:"height"`
+	FileName string    `json:"-"`
+	Size     int64     `json:"size"`
+}
+
+// SetThumbnailRenditions replaces the video's renditions and the framing
+// they were cut with, returning the renditions it replaced so the caller
+// can remove their files.
+func (c Client) SetThumbnailRenditions(videoID uuid.UUID, framing json.RawMessage, renditions []ThumbnailRendition) ([]ThumbnailRendition, error) {
+	replaced, err := c.GetThumbnailRenditions(videoID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM thumbnail_renditions WHERE video_id = ?", videoID); err != nil {
+		return nil, err
+	}
+	query := `
+	INSERT INTO thumbnail_renditions (video_id, width, height, file_name, size)
+	VALUES (?, ?, ?, ?, ?)
+	`
+	for _, r := range renditions {
+		if _, err := tx.Exec(query, videoID, r.Width, r.Height, r.FileName, r.Size); err != nil {
+			return nil, err
+		}
+	}
+	query = `
+	INSERT INTO thumbnail_framing (video_id, framing)
+	VALUES (?, ?)
+	ON CONFLICT(video_id) DO UPDATE SET framing = excluded.framing
+	`
+	if _, err := tx.Exec(query, videoID, string(framing)); err != nil {
+		return nil, err
+	}
+	return replaced, tx.Commit()
+}
+
+// GetThumbnailRenditions returns the video's renditions, largest first.
+func (c Client) GetThumbnailRenditions(videoID uuid.UUID) ([]ThumbnailRendition, error) {
+	query := `
+	SELECT video_id, width, height, file_name, size
+	FROM thumbnail_renditions
+	WHERE video_id = ?
+	ORDER BY width DESC, height DESC
+	`
+
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	renditions := []ThumbnailRendition{}
+	for rows.Next() {
+		var r ThumbnailRendition
+		if err := rows.Scan(&r.VideoID, &r.Width, &r.Height, &r.FileName, &r.Size); err != nil {
+			return nil, err
+		}
+		renditions = append(renditions, r)
+	}
+	return renditions, rows.Err()
+}
+
+// GetThumbnailFraming returns the framing the renditions were cut with,
+// or nil if the video has none.
+func (c Client) GetThumbnailFraming(videoID uuid.UUID) (json.RawMessage, error) {
+	var framing string
+	err := c.db.QueryRow("SELECT framing FROM thumbnail_framing WHERE video_id = ?", videoID).Scan(&framing)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return json.RawMessage(framing), nil
+}
+
+// GetThumbnailRenditionFiles lists the asset files of every rendition.
+func (c Client) GetThumbnailRenditionFiles() ([]string, error) {
+	rows, err := c.db.Query("SELECT file_name FROM thumbnail_renditions")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []string{}
+	for rows.Next() {
+		var file string
+		if err := rows.Scan(&file); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
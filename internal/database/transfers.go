@@ -0,0 +1,218 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Transfer statuses. A pending transfer waits for the recipient; the
+// others are final.
+const (
+	TransferPending   = "pending"
+	TransferAccepted  = "accepted"
+	TransferDeclined  = "declined"
+	TransferCancelled = "cancelled"
+	TransferExpired   = "expired"
+)
+
+var (
+	// ErrTransferPending is returned when a video already has a transfer
+	// waiting for an answer.
+	ErrTransferPending = errors.New("video already has a pending transfer")
+	// ErrTransferResolved is returned when a transfer was answered,
+	// cancelled or expired before the change could be made.
+	ErrTransferResolved = errors.New("transfer is no longer pending")
+)
+
+// VideoTransfer offers a video to another user. Nothing moves until the
+// recipient accepts.
+type VideoTransfer struct {
+	ID         uuid.UUID  `json:"id"`
+	VideoID    uuid.UUID  `json:"video_id"`
+	FromUserID uuid.UUID  `json:"from_user_id"`
+	ToUserID   uuid.UUID  `json:"to_user_id"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+}
+
+const transferColumns = `
+		id,
+		video_id,
+		from_user_id,
+		to_user_id,
+		status,
+		created_at,
+		expires_at,
+		resolved_at
+`
+
+func scanTransfer(row rowScanner) (VideoTransfer, error) {
+	var t VideoTransfer
+	err := row.Scan(
+		&t.ID,
+		&t.VideoID,
+		&t.FromUserID,
+		&t.ToUserID,
+		&t.Status,
+		&t.CreatedAt,
+		&t.ExpiresAt,
+		&t.ResolvedAt,
+	)
+	return t, err
+}
+
+type CreateVideoTransferParams struct {
+	VideoID    uuid.UUID
+	FromUserID uuid.UUID
+	ToUserID   uuid.UUID
+	ExpiresAt  time.Time
+}
+
+// CreateVideoTransfer records a pending transfer, first expiring any
+// pending one for the video whose time has run out.
+func (c Client) CreateVideoTransfer(params CreateVideoTransferParams) (VideoTransfer, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return VideoTransfer{}, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	_, err = tx.Exec(`
+	UPDATE video_transfers
+	SET status = ?, resolved_at = ?
+	WHERE video_id = ? AND status = ? AND expires_at <= ?
+	`, TransferExpired, now, params.VideoID, TransferPending, now)
+	if err != nil {
+		return VideoTransfer{}, err
+	}
+
+	var pending bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM video_transfers WHERE video_id = ? AND status = ?)", params.VideoID, TransferPending).Scan(&pending)
+	if err != nil {
+		return VideoTransfer{}, err
+	}
+	if pending {
+		return VideoTransfer{}, ErrTransferPending
+	}
+
+	query := `
+	INSERT INTO video_transfers (id, video_id, from_user_id, to_user_id, status, created_at, expires_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	RETURNING` + transferColumns
+	transfer, err := scanTransfer(tx.QueryRow(query, uuid.New(), params.VideoID, params.FromUserID, params.ToUserID, TransferPending, now, params.ExpiresAt.UTC()))
+	if err != nil {
+		return VideoTransfer{}, err
+	}
+	return transfer, tx.Commit()
+}
+
+func (c Client) GetVideoTransfer(id uuid.UUID) (VideoTransfer, error) {
+	query := `
+	SELECT` + transferColumns + `
+	FROM video_transfers
+	WHERE id = ?
+	`
+	transfer, err := scanTransfer(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return VideoTransfer{}, nil
+	}
+	return transfer, err
+}
+
+// GetPendingVideoTransfers lists the unexpired transfers the user has
+// offered or been offered, newest first.
+func (c Client) GetPendingVideoTransfers(userID uuid.UUID, now time.Time) ([]VideoTransfer, error) {
+	query := `
+	SELECT` + transferColumns + `
+	FROM video_transfers
+	WHERE (from_user_id = ? OR to_user_id = ?) AND status = ? AND expires_at > ?
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, userID, userID, TransferPending, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []VideoTransfer{}
+	for rows.Next() {
+		transfer, err := scanTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, transfer)
+	}
+	return transfers, rows.Err()
+}
+
+// ResolveVideoTransfer closes a pending transfer without moving the video.
+func (c Client) ResolveVideoTransfer(id uuid.UUID, status string) (VideoTransfer, error) {
+	query := `
+	UPDATE video_transfers
+	SET status = ?, resolved_at = ?
+	WHERE id = ? AND status = ?
+	RETURNING` + transferColumns
+	transfer, err := scanTransfer(c.db.QueryRow(query, status, time.Now().UTC(), id, TransferPending))
+	if errors.Is(err, sql.ErrNoRows) {
+		return VideoTransfer{}, ErrTransferResolved
+	}
+	return transfer, err
+}
+
+// AcceptVideoTransfer hands the video to the recipient and moves bytes and
+// objects of storage usage with it, all at once. The video leaves its
+// folder, which belonged to the previous owner.
+func (c Client) AcceptVideoTransfer(transfer VideoTransfer, bytes, objects int64) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	res, err := tx.Exec(`
+	UPDATE video_transfers
+	SET status = ?, resolved_at = ?
+	WHERE id = ? AND status = ? AND expires_at > ?
+	`, TransferAccepted, now, transfer.ID, TransferPending, now)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrTransferResolved
+	}
+
+	res, err = tx.Exec(`
+	UPDATE videos
+	SET user_id = ?, folder_id = NULL, revision = revision + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND user_id = ?
+	`, transfer.ToUserID, transfer.VideoID, transfer.FromUserID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrVideoChanged
+	}
+
+	if err := adjustUserUsage(tx, transfer.FromUserID, -bytes, -objects); err != nil {
+		return err
+	}
+	if err := adjustUserUsage(tx, transfer.ToUserID, bytes, objects); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	c.videoChanged(transfer.VideoID)
+	return nil
+}
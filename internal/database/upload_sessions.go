@@ -0,0 +1,223 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrUploadOffsetMismatch is returned when a part is recorded against an
+// offset the session has already moved past, e.g. a retried chunk.
+var ErrUploadOffsetMismatch = errors.New("upload offset mismatch")
+
+type UploadSession struct {
+	ID            uuid.UUID           `json:"id"`
+	CreatedAt     time.Time           `json:"created_at"`
+	UpdatedAt     time.Time           `json:"updated_at"`
+	BytesReceived int64               `json:"offset"`
+	Parts         []UploadSessionPart `json:"-"`
+	CreateUploadSessionParams
+}
+
+type CreateUploadSessionParams struct {
+	VideoID     uuid.UUID `json:"video_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	ObjectKey   string    `json:"-"`
+	UploadID    string    `json:"-"`
+	ContentType string    `json:"content_type"`
+	TotalSize   int64     `json:"size"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type UploadSessionPart struct {
+	PartNumber int32
+	ETag       string
+	Size       int64
+	// ChecksumSHA256 is the base64 SHA-256 the store reported for the
+	// part, needed again to complete a checksummed multipart upload.
+	ChecksumSHA256 string
+}
+
+func (c Client) CreateUploadSession(params CreateUploadSessionParams) (UploadSession, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO upload_sessions (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		object_key,
+		upload_id,
+		content_type,
+		total_size,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id.String(),
+		params.VideoID.String(),
+		params.UserID.String(),
+		params.ObjectKey,
+		params.UploadID,
+		params.ContentType,
+		params.TotalSize,
+		params.ExpiresAt,
+	)
+	if err != nil {
+		return UploadSession{}, err
+	}
+	return c.GetUploadSession(id)
+}
+
+// GetUploadSession returns the session with its recorded parts in order,
+// or a zero session when it doesn't exist.
+func (c Client) GetUploadSession(id uuid.UUID) (UploadSession, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		object_key,
+		upload_id,
+		content_type,
+		total_size,
+		bytes_received,
+		expires_at
+	FROM upload_sessions
+	WHERE id = ?
+	`
+	var session UploadSession
+	err := c.db.QueryRow(query, id.String()).Scan(
+		&session.ID,
+		&session.CreatedAt,
+		&session.UpdatedAt,
+		&session.VideoID,
+		&session.UserID,
+		&session.ObjectKey,
+		&session.UploadID,
+		&session.ContentType,
+		&session.TotalSize,
+		&session.BytesReceived,
+		&session.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UploadSession{}, nil
+		}
+		return UploadSession{}, err
+	}
+
+	rows, err := c.db.Query(`
+	SELECT part_number, etag, size, checksum_sha256
+	FROM upload_session_parts
+	WHERE session_id = ?
+	ORDER BY part_number
+	`, id.String())
+	if err != nil {
+		return UploadSession{}, err
+	}
+	defer rows.Close()
+
+	session.Parts = []UploadSessionPart{}
+	for rows.Next() {
+		var part UploadSessionPart
+		if err := rows.Scan(&part.PartNumber, &part.ETag, &part.Size, &part.ChecksumSHA256); err != nil {
+			return UploadSession{}, err
+		}
+		session.Parts = append(session.Parts, part)
+	}
+	return session, rows.Err()
+}
+
+// GetUploadSessionByUploadID returns the session that owns the store's
+// multipart upload, or a zero session when none does.
+func (c Client) GetUploadSessionByUploadID(uploadID string) (UploadSession, error) {
+	var id uuid.UUID
+	err := c.db.QueryRow("SELECT id FROM upload_sessions WHERE upload_id = ?", uploadID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UploadSession{}, nil
+		}
+		return UploadSession{}, err
+	}
+	return c.GetUploadSession(id)
+}
+
+// AddUploadSessionPart records an uploaded part and advances the session
+// offset, but only if the session is still at expectedOffset.
+func (c Client) AddUploadSessionPart(sessionID uuid.UUID, expectedOffset int64, part UploadSessionPart) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+	UPDATE upload_sessions
+	SET bytes_received = bytes_received + ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND bytes_received = ?
+	`, part.Size, sessionID.String(), expectedOffset)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUploadOffsetMismatch
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO upload_session_parts (session_id, part_number, etag, size, checksum_sha256)
+	VALUES (?, ?, ?, ?, ?)
+	`, sessionID.String(), part.PartNumber, part.ETag, part.Size, part.ChecksumSHA256)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c Client) DeleteUploadSession(id uuid.UUID) error {
+	if _, err := c.db.Exec("DELETE FROM upload_session_parts WHERE session_id = ?", id.String()); err != nil {
+		return err
+	}
+	_, err := c.db.Exec("DELETE FROM upload_sessions WHERE id = ?", id.String())
+	return err
+}
+
+func (c Client) GetUserUploadSessions(userID uuid.UUID) ([]UploadSession, error) {
+	rows, err := c.db.Query("SELECT id FROM upload_sessions WHERE user_id = ?", userID.String())
+	if err != nil {
+		return nil, err
+	}
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sessions := make([]UploadSession, 0, len(ids))
+	for _, id := range ids {
+		session, err := c.GetUploadSession(id)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
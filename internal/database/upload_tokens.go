@@ -0,0 +1,93 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadToken lets whoever holds it upload one file to one video, on
+// behalf of the user who created it.
+type UploadToken struct {
+	Token   string    `json:"token"`
+	VideoID uuid.UUID `json:"video_id"`
+	UserID  uuid.UUID `json:"user_id"`
+	// MaxSize caps the upload on top of the plan limit; zero means only
+	// the plan limit applies. ContentType, when set, is the only media
+	// type accepted.
+	MaxSize     int64      `json:"max_size,omitempty"`
+	ContentType string     `json:"content_type,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at,omitempty"`
+}
+
+func (c Client) CreateUploadToken(token UploadToken) (UploadToken, error) {
+	query := `
+		INSERT INTO upload_tokens (
+			token,
+			video_id,
+			user_id,
+			max_size,
+			content_type,
+			created_at,
+			expires_at
+		) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, ?)
+	`
+	_, err := c.db.Exec(query, token.Token, token.VideoID.String(), token.UserID.String(), token.MaxSize, token.ContentType, token.ExpiresAt)
+	if err != nil {
+		return UploadToken{}, err
+	}
+	return c.GetUploadToken(token.Token)
+}
+
+func (c Client) GetUploadToken(token string) (UploadToken, error) {
+	query := `
+		SELECT token, video_id, user_id, max_size, content_type, created_at, expires_at, used_at
+		FROM upload_tokens
+		WHERE token = ?
+	`
+	var t UploadToken
+	err := c.db.QueryRow(query, token).Scan(
+		&t.Token,
+		&t.VideoID,
+		&t.UserID,
+		&t.MaxSize,
+		&t.ContentType,
+		&t.CreatedAt,
+		&t.ExpiresAt,
+		&t.UsedAt,
+	)
+	if err == sql.ErrNoRows {
+		return UploadToken{}, nil
+	}
+	return t, err
+}
+
+// UseUploadToken marks the token used, reporting false if it already was
+// or has expired. Of several requests racing with the same token, only
+// one gets true.
+func (c Client) UseUploadToken(token string, now time.Time) (bool, error) {
+	query := `
+		UPDATE upload_tokens
+		SET used_at = ?
+		WHERE token = ? AND used_at IS NULL AND expires_at > ?
+	`
+	res, err := c.db.Exec(query, now, token, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// DeleteUploadTokensBefore removes tokens that expired before the given
+// time, used or not, and returns how many there were.
+func (c Client) DeleteUploadTokensBefore(before time.Time) (int64, error) {
+	res, err := c.db.Exec("DELETE FROM upload_tokens WHERE expires_at < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type UserUsage struct {
+	UserID      uuid.UUID `json:"user_id"`
+	BytesUsed   int64     `json:"bytes_used"`
+	ObjectCount int64     `json:"object_count"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type UserUsageBreakdown struct {
+	UserUsage
+	Email string `json:"email"`
+}
+
+// AdjustUserUsage applies a delta to a user's running storage totals,
+// creating the row on first use.
+func (c Client) AdjustUserUsage(userID uuid.UUID, bytesDelta, objectsDelta int64) error {
+	return adjustUserUsage(c.db, userID, bytesDelta, objectsDelta)
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// adjustUserUsage is AdjustUserUsage for callers that need it inside a
+// transaction.
+func adjustUserUsage(db execer, userID uuid.UUID, bytesDelta, objectsDelta int64) error {
+	query := `
+	INSERT INTO user_usage (user_id, bytes_used, object_count, updated_at)
+	VALUES (?, MAX(?, 0), MAX(?, 0), CURRENT_TIMESTAMP)
+	ON CONFLICT(user_id) DO UPDATE SET
+		bytes_used = MAX(bytes_used + ?, 0),
+		object_count = MAX(object_count + ?, 0),
+		updated_at = CURRENT_TIMESTAMP
+	`
+	_, err := db.Exec(
+		query,
+		userID.String(),
+		bytesDelta,
+		objectsDelta,
+		bytesDelta,
+		objectsDelta,
+	)
+	return err
+}
+
+func (c Client) GetUserUsage(userID uuid.UUID) (UserUsage, error) {
+	query := `
+	SELECT user_id, bytes_used, object_count, updated_at
+	FROM user_usage
+	WHERE user_id = ?
+	`
+	var usage UserUsage
+	var id string
+	err := c.db.QueryRow(query, userID.String()).
+		Scan(&id, &usage.BytesUsed, &usage.ObjectCount, &usage.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserUsage{UserID: userID}, nil
+		}
+		return UserUsage{}, err
+	}
+	usage.UserID, err = uuid.Parse(id)
+	if err != nil {
+		return UserUsage{}, err
+	}
+	return usage, nil
+}
+
+func (c Client) GetUsageBreakdown() ([]UserUsageBreakdown, error) {
+	return c.getUsageBreakdown(-1)
+}
+
+// GetTopUsage returns the usage of the limit users storing the most.
+func (c Client) GetTopUsage(limit int) ([]UserUsageBreakdown, error) {
+	return c.getUsageBreakdown(limit)
+}
+
+// getUsageBreakdown lists usage from the biggest user down. A negative
+// limit lists everyone.
+func (c Client) getUsageBreakdown(limit int) ([]UserUsageBreakdown, error) {
+	query := `
+	SELECT uu.user_id, u.email, uu.bytes_used, uu.object_count, uu.updated_at
+	FROM user_usage uu
+	JOIN users u ON u.id = uu.user_id
+	ORDER BY uu.bytes_used DESC
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	breakdown := []UserUsageBreakdown{}
+	for rows.Next() {
+		var usage UserUsageBreakdown
+		var id string
+		if err := rows.Scan(&id, &usage.Email, &usage.BytesUsed, &usage.ObjectCount, &usage.UpdatedAt); err != nil {
+			return nil, err
+		}
+		usage.UserID, err = uuid.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		breakdown = append(breakdown, usage)
+	}
+
+	return breakdown, rows.Err()
+}
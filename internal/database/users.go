@@ -3,6 +3,7 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,6 +13,10 @@ type User struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// SuspendedAt is set once moderation suspends the account.
+	SuspendedAt *time.Time `json:"suspended_at,omitempty"`
+	AvatarURL   *string    `json:"avatar_url"`
+	BannerURL   *string    `json:"banner_url"`
 	CreateUserParams
 }
 
@@ -53,13 +58,13 @@ func (c Client) GetUsers() ([]User, error) {
 
 func (c Client) GetUserByEmail(email string) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, suspended_at, avatar_url, banner_url, email, password
 		FROM users
 		WHERE email = ?
 	`
 	var user User
 	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.SuspendedAt, &user.AvatarURL, &user.BannerURL, &user.Email, &user.Password)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
@@ -75,7 +80,7 @@ func (c Client) GetUserByEmail(email string) (User, error) {
 
 func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
+		SELECT u.id, u.email, u.created_at, u.updated_at, u.suspended_at, u.avatar_url, u.banner_url, u.password
 		FROM users u
 		JOIN refresh_tokens rt ON u.id = rt.user_id
 		WHERE rt.token = ?
@@ -83,7 +88,7 @@ func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 
 	var user User
 	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.SuspendedAt, &user.AvatarURL, &user.BannerURL, &user.Password)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -117,13 +122,13 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, suspended_at, avatar_url, banner_url, email, password
 		FROM users
 		WHERE id = ?
 	`
 	var user User
 	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.SuspendedAt, &user.AvatarURL, &user.BannerURL, &user.Email, &user.Password)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -145,3 +150,137 @@ func (c Client) DeleteUser(id uuid.UUID) error {
 	_, err := c.db.Exec(query, id.String())
 	return err
 }
+
+// DeleteUserData removes the user and every row that refers to them, other
+// than videos and jobs. Videos have objects to clean up, so callers delete
+// them first. Audit entries are kept but anonymised: the user's ID is
+// swapped for a random one wherever it appears, so their entries still
+// read as one person's without saying who.
+func (c Client) DeleteUserData(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		"DELETE FROM upload_session_parts WHERE session_id IN (SELECT id FROM upload_sessions WHERE user_id = ?)",
+		"DELETE FROM upload_sessions WHERE user_id = ?",
+		"DELETE FROM upload_tokens WHERE user_id = ?",
+		"DELETE FROM watch_history WHERE user_id = ?",
+		"DELETE FROM video_reports WHERE reporter_id = ?",
+		"DELETE FROM user_settings WHERE user_id = ?",
+		"DELETE FROM access_grants WHERE subject_type = 'folder' AND subject_id IN (SELECT id FROM folders WHERE user_id = ?)",
+		"DELETE FROM folders WHERE user_id = ?",
+		"DELETE FROM video_transfers WHERE from_user_id = ?1 OR to_user_id = ?1",
+		"DELETE FROM organization_members WHERE user_id = ?",
+		"DELETE FROM access_grants WHERE user_id = ?",
+		"DELETE FROM user_usage WHERE user_id = ?",
+		"DELETE FROM refresh_tokens WHERE user_id = ?",
+		"DELETE FROM users WHERE id = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, id.String()); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM geo_restrictions WHERE scope = ? AND subject_id = ?", GeoScopeUser, id.String()); err != nil {
+		return err
+	}
+
+	pseudonym := uuid.NewString()
+	auditStatements := []string{
+		"UPDATE audit_log SET actor = ?3 || ?2 WHERE actor = 'user:' || ?1",
+		"UPDATE audit_log SET subject_id = ?2 WHERE subject_type = 'user' AND subject_id = ?1",
+		"UPDATE audit_log SET details = replace(details, ?1, ?2) WHERE instr(details, ?1) > 0",
+	}
+	for _, statement := range auditStatements {
+		if _, err := tx.Exec(statement, id.String(), pseudonym, ActorDeletedUserPrefix); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UserImage is a picture on the user's profile.
+type UserImage string
+
+const (
+	UserImageAvatar UserImage = "avatar"
+	UserImageBanner UserImage = "banner"
+)
+
+// SetUserImage points the user's avatar or banner at url, or clears it
+// when url is nil, and returns the URL it replaced.
+func (c Client) SetUserImage(id uuid.UUID, image UserImage, url *string) (*string, error) {
+	column := "avatar_url"
+	if image == UserImageBanner {
+		column = "banner_url"
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var previous *string
+	err = tx.QueryRow(fmt.Sprintf("SELECT %s FROM users WHERE id = ?", column), id.String()).Scan(&previous)
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf("UPDATE users SET %s = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", column)
+	if _, err := tx.Exec(query, url, id.String()); err != nil {
+		return nil, err
+	}
+	return previous, tx.Commit()
+}
+
+// GetUserImageURLs returns the URLs of every avatar and banner in use.
+func (c Client) GetUserImageURLs() ([]string, error) {
+	query := `
+	SELECT avatar_url FROM users WHERE avatar_url IS NOT NULL
+	UNION ALL
+	SELECT banner_url FROM users WHERE banner_url IS NOT NULL
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := []string{}
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+
+	return urls, rows.Err()
+}
+
+// SuspendUser marks the user suspended and revokes their refresh tokens
+// so they can't get new access tokens.
+func (c Client) SuspendUser(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+		SET suspended_at = COALESCE(suspended_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	if _, err := tx.Exec(query, id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM refresh_tokens WHERE user_id = ?", id.String()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,33 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AcquireVideoLock takes the lock on the video for owner until expiresAt,
+// reporting false when someone else holds it. An owner that already holds
+// the lock extends it, and a lock that has expired can be taken over.
+func (c Client) AcquireVideoLock(videoID uuid.UUID, owner string, now, expiresAt time.Time) (bool, error) {
+	query := `
+	INSERT INTO video_locks (video_id, owner, expires_at)
+	VALUES (?, ?, ?)
+	ON CONFLICT(video_id) DO UPDATE SET
+		owner = excluded.owner,
+		expires_at = excluded.expires_at
+	WHERE video_locks.owner = excluded.owner OR video_locks.expires_at <= ?
+	`
+	res, err := c.db.Exec(query, videoID, owner, expiresAt.UTC(), now.UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// ReleaseVideoLock gives up the lock if owner still holds it.
+func (c Client) ReleaseVideoLock(videoID uuid.UUID, owner string) error {
+	_, err := c.db.Exec("DELETE FROM video_locks WHERE video_id = ? AND owner = ?", videoID, owner)
+	return err
+}
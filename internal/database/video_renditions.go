@@ -0,0 +1,74 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// VideoRendition is the video encoded at a lower resolution from its
+// preset's ladder, stored next to the original.
+type VideoRendition struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Name    string    `json:"name"`
+	Width   int       `json:"width"`
+	Height  int       `json:"height"`
+	Key     string    `json:"-"`
+	Size    int64     `json:"size"`
+	// HDR renditions keep an HDR source's colour instead of tone mapping
+	// it.
+	HDR bool `json:"hdr"`
+}
+
+// SetVideoRenditions replaces the video's renditions, returning the ones
+// it replaced so the caller can delete their objects.
+func (c Client) SetVideoRenditions(videoID uuid.UUID, renditions []VideoRendition) ([]VideoRendition, error) {
+	replaced, err := c.GetVideoRenditions(videoID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM video_renditions WHERE video_id = ?", videoID); err != nil {
+		return nil, err
+	}
+	query := `
+	INSERT INTO video_renditions (video_id, name, width, height, key, size, hdr)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	for _, r := range renditions {
+		if _, err := tx.Exec(query, videoID, r.Name, r.Width, r.Height, r.Key, r.Size, r.HDR); err != nil {
+			return nil, err
+		}
+	}
+	return replaced, tx.Commit()
+}
+
+// GetVideoRenditions returns the video's renditions, largest first.
+func (c Client) GetVideoRenditions(videoID uuid.UUID) ([]VideoRendition, error) {
+	query := `
+	SELECT video_id, name, width, height, key, size, hdr
+	FROM video_renditions
+	WHERE video_id = ?
+	ORDER BY width * height DESC
+	`
+
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	renditions := []VideoRendition{}
+	for rows.Next() {
+		var r VideoRendition
+		if err := rows.Scan(&r.VideoID, &r.Name, &r.Width, &r.Height, &r.Key, &r.Size, &r.HDR); err != nil {
+			return nil, err
+		}
+		renditions = append(renditions, r)
+	}
+	return renditions, rows.Err()
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VideoVersion is an object version a video has pointed at. Versions are
+// only recorded for buckets with versioning, where replacing or deleting
+// an object leaves the old version behind to roll back to.
+type VideoVersion struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	VersionID string    `json:"version_id"`
+	Key       string    `json:"-"`
+	ETag      string    `json:"etag"`
+	Checksum  string    `json:"checksum"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const videoVersionColumns = `
+		video_id,
+		version_id,
+		key,
+		etag,
+		checksum,
+		size,
+		created_at
+`
+
+func scanVideoVersion(row interface{ Scan(...any) error }) (VideoVersion, error) {
+	var v VideoVersion
+	err := row.Scan(&v.VideoID, &v.VersionID, &v.Key, &v.ETag, &v.Checksum, &v.Size, &v.CreatedAt)
+	return v, err
+}
+
+// AddVideoVersion records a version the video now points at. Recording
+// the same version again, as restoring it does, only bumps its time.
+func (c Client) AddVideoVersion(v VideoVersion) error {
+	query := `
+	INSERT INTO video_versions (video_id, version_id, key, etag, checksum, size, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(video_id, version_id) DO UPDATE SET created_at = excluded.created_at
+	`
+	_, err := c.db.Exec(query, v.VideoID, v.VersionID, v.Key, v.ETag, v.Checksum, v.Size, v.CreatedAt.UTC())
+	return err
+}
+
+// GetVideoVersions returns the video's versions, newest first.
+func (c Client) GetVideoVersions(videoID uuid.UUID) ([]VideoVersion, error) {
+	query := `
+	SELECT` + videoVersionColumns + `
+	FROM video_versions
+	WHERE video_id = ?
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []VideoVersion{}
+	for rows.Next() {
+		v, err := scanVideoVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// GetVideoVersion returns a zero VideoVersion if the video never had the
+// version.
+func (c Client) GetVideoVersion(videoID uuid.UUID, versionID string) (VideoVersion, error) {
+	query := `
+	SELECT` + videoVersionColumns + `
+	FROM video_versions
+	WHERE video_id = ? AND version_id = ?
+	`
+	v, err := scanVideoVersion(c.db.QueryRow(query, videoID, versionID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return VideoVersion{}, nil
+	}
+	return v, err
+}
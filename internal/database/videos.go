@@ -3,17 +3,56 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"path"
+	"strings"
 	"time"
 
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
 	"github.com/google/uuid"
 )
 
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID              uuid.UUID `json:"id"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	ThumbnailURL    *string   `json:"thumbnail_url"`
+	VideoURL        *string   `json:"video_url"`
+	VideoKey        *string   `json:"-"`
+	VideoSize       int64     `json:"video_size"`
+	ThumbnailSize   int64     `json:"thumbnail_size"`
+	PendingVideoKey *string   `json:"-"`
+	// VideoChecksum is the base64 SHA-256 of the video object as its store
+	// reported it at upload time.
+	VideoChecksum string `json:"-"`
+	// VideoETag and VideoVersionID identify exactly which object version
+	// the row was written against.
+	VideoETag      string `json:"-"`
+	VideoVersionID string `json:"-"`
+	// PublishAt is when a scheduled private video becomes public.
+	PublishAt *time.Time `json:"publish_at"`
+	// ExpiresAt is when the video and its objects are purged.
+	ExpiresAt *time.Time `json:"expires_at"`
+	// RetentionMode, RetainUntil and LegalHold mirror the Object Lock
+	// settings applied to the video object.
+	RetentionMode string     `json:"retention_mode,omitempty"`
+	RetainUntil   *time.Time `json:"retain_until,omitempty"`
+	LegalHold     bool       `json:"legal_hold"`
+	// ProcessingVersion and ProcessedAt identify the pipeline run that
+	// produced the stored video, so older videos can be reprocessed.
+	ProcessingVersion int        `json:"-"`
+	ProcessedAt       *time.Time `json:"-"`
+	// StorageTier tracks whether the video object is archived, and
+	// RestoredUntil how long a restored copy stays readable.
+	StorageTier   string     `json:"storage_tier"`
+	RestoredUntil *time.Time `json:"restored_until,omitempty"`
+	// Revision goes up on every update, so clients can tell whether the
+	// row changed since they read it.
+	Revision int64 `json:"revision"`
+	// AspectRatio is the category processing sorted the video into:
+	// "landscape", "portrait" or "other". It picks the rendition ladder.
+	AspectRatio string `json:"aspect_ratio"`
+	// Color describes the stored video's pixels as ffprobe reported them.
+	Color ColorInfo `json:"color"`
 	CreateVideoParams
 }
 
@@ -21,11 +60,72 @@ type CreateVideoParams struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	UserID      uuid.UUID `json:"user_id"`
+	Visibility  string    `json:"visibility"`
+	// PresetID names the transcoding preset the video is processed with;
+	// empty means the owner's plan preset.
+	PresetID      string `json:"preset_id"`
+	AutoThumbnail bool   `json:"auto_thumbnail"`
+	Watermark     bool   `json:"watermark"`
+	// FolderID files the video in one of its owner's folders; nil leaves
+	// it at the top of their library.
+	FolderID *uuid.UUID `json:"folder_id"`
+	// OrgID puts the video in an organization's library, which then pays
+	// for its storage; nil keeps it in the owner's personal library.
+	OrgID *uuid.UUID `json:"org_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+// ColorInfo is the pixel format and colour signalling of a video. HDR is
+// set for PQ and HLG sources, which renditions are tone mapped from.
+type ColorInfo struct {
+	PixelFormat    string `json:"pixel_format"`
+	ColorTransfer  string `json:"color_transfer"`
+	ColorPrimaries string `json:"color_primaries"`
+	HDR            bool   `json:"hdr"`
+}
+
+// Visibility levels. Public videos are listed and playable by anyone,
+// unlisted ones by anyone with the link, private ones only by their owner.
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+)
+
+func ValidVisibility(v string) bool {
+	switch v {
+	case VisibilityPublic, VisibilityUnlisted, VisibilityPrivate:
+		return true
+	}
+	return false
+}
+
+// Storage tiers. An archived video has to be restored before it can be
+// played, and a restored one goes back to archived when its copy expires.
+const (
+	TierStandard  = "standard"
+	TierArchived  = "archived"
+	TierRestoring = "restoring"
+	TierRestored  = "restored"
+)
+
+// Playable reports whether the video object can be read at now.
+func (v Video) Playable(now time.Time) bool {
+	switch v.StorageTier {
+	case TierArchived, TierRestoring:
+		return false
+	case TierRestored:
+		return v.RestoredUntil != nil && now.Before(*v.RestoredUntil)
+	}
+	return true
+}
+
+// Locked reports whether retention or a legal hold keeps the video from
+// being deleted at now.
+func (v Video) Locked(now time.Time) bool {
+	return v.LegalHold || (v.RetainUntil != nil && now.Before(*v.RetainUntil))
+}
+
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
@@ -33,7 +133,87 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
-		user_id
+		user_id,
+		video_key,
+		video_size,
+		thumbnail_size,
+		pending_video_key,
+		video_checksum,
+		video_etag,
+		video_version_id,
+		visibility,
+		preset_id,
+		auto_thumbnail,
+		watermark,
+		publish_at,
+		expires_at,
+		retention_mode,
+		retain_until,
+		legal_hold,
+		processing_version,
+		processed_at,
+		storage_tier,
+		restored_until,
+		revision,
+		folder_id,
+		org_id,
+		aspect_ratio,
+		pixel_format,
+		color_transfer,
+		color_primaries,
+		hdr
+`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.VideoURL,
+		&video.UserID,
+		&video.VideoKey,
+		&video.VideoSize,
+		&video.ThumbnailSize,
+		&video.PendingVideoKey,
+		&video.VideoChecksum,
+		&video.VideoETag,
+		&video.VideoVersionID,
+		&video.Visibility,
+		&video.PresetID,
+		&video.AutoThumbnail,
+		&video.Watermark,
+		&video.PublishAt,
+		&video.ExpiresAt,
+		&video.RetentionMode,
+		&video.RetainUntil,
+		&video.LegalHold,
+		&video.ProcessingVersion,
+		&video.ProcessedAt,
+		&video.StorageTier,
+		&video.RestoredUntil,
+		&video.Revision,
+		&video.FolderID,
+		&video.OrgID,
+		&video.AspectRatio,
+		&video.Color.PixelFormat,
+		&video.Color.ColorTransfer,
+		&video.Color.ColorPrimaries,
+		&video.Color.HDR,
+	)
+	return video, err
+}
+
+func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE user_id = ?
 	ORDER BY created_at DESC
@@ -47,17 +227,8 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
@@ -75,10 +246,28 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		visibility,
+		preset_id,
+		auto_thumbnail,
+		watermark,
+		folder_id,
+		org_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	_, err := c.db.Exec(
+		query,
+		id,
+		params.Title,
+		params.Description,
+		params.UserID,
+		params.Visibility,
+		params.PresetID,
+		params.AutoThumbnail,
+		params.Watermark,
+		params.FolderID,
+		params.OrgID,
+	)
 	if err != nil {
 		return Video{}, err
 	}
@@ -88,29 +277,32 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Video{}, nil
+		}
+		return Video{}, err
+	}
+
+	return video, nil
+}
+
+// GetVideoByKey returns the video whose object is stored under key, or a
+// zero video when there is none.
+func (c Client) GetVideoByKey(key string) (Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE video_key = ?
+	`
+
+	video, err := scanVideo(c.db.QueryRow(query, key))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,35 +313,658 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
+// GetVideosByKey returns every video whose row points at key; under
+// content-hash naming that can be more than one.
+func (c Client) GetVideosByKey(key string) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE video_key = ?
+	ORDER BY created_at
+	`
+
+	rows, err := c.db.Query(query, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, rows.Err()
+}
+
+// ErrVideoChanged is returned by UpdateVideoRevision when the row was
+// updated after the given revision was read.
+var ErrVideoChanged = errors.New("video changed since it was read")
+
 func (c Client) UpdateVideo(video Video) error {
+	_, err := c.updateVideo(video, "")
+	return err
+}
+
+// UpdateVideoRevision saves the video only if it's still at revision,
+// returning ErrVideoChanged otherwise.
+func (c Client) UpdateVideoRevision(video Video, revision int64) error {
+	res, err := c.updateVideo(video, " AND revision = ?", revision)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrVideoChanged
+	}
+	return nil
+}
+
+func (c Client) updateVideo(video Video, where string, args ...any) (sql.Result, error) {
 	query := `
 	UPDATE videos
 	SET
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
+		thumbnail_file = ?,
 		video_url = ?,
-		user_id = ?
-	WHERE id = ?
-	`
+		user_id = ?,
+		video_key = ?,
+		video_size = ?,
+		thumbnail_size = ?,
+		pending_video_key = ?,
+		video_checksum = ?,
+		video_etag = ?,
+		video_version_id = ?,
+		visibility = ?,
+		preset_id = ?,
+		auto_thumbnail = ?,
+		watermark = ?,
+		publish_at = ?,
+		expires_at = ?,
+		retention_mode = ?,
+		retain_until = ?,
+		legal_hold = ?,
+		processing_version = ?,
+		processed_at = ?,
+		storage_tier = ?,
+		restored_until = ?,
+		folder_id = ?,
+		org_id = ?,
+		aspect_ratio = ?,
+		pixel_format = ?,
+		color_transfer = ?,
+		color_primaries = ?,
+		hdr = ?,
+		revision = revision + 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?` + where
 
-	_, err := c.db.Exec(
-		query,
+	values := []any{
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
+		thumbnailFile(video.ThumbnailURL),
 		&video.VideoURL,
 		video.UserID,
+		video.VideoKey,
+		video.VideoSize,
+		video.ThumbnailSize,
+		video.PendingVideoKey,
+		video.VideoChecksum,
+		video.VideoETag,
+		video.VideoVersionID,
+		video.Visibility,
+		video.PresetID,
+		video.AutoThumbnail,
+		video.Watermark,
+		video.PublishAt,
+		video.ExpiresAt,
+		video.RetentionMode,
+		video.RetainUntil,
+		video.LegalHold,
+		video.ProcessingVersion,
+		video.ProcessedAt,
+		video.StorageTier,
+		video.RestoredUntil,
+		video.FolderID,
+		video.OrgID,
+		video.AspectRatio,
+		video.Color.PixelFormat,
+		video.Color.ColorTransfer,
+		video.Color.ColorPrimaries,
+		video.Color.HDR,
 		video.ID,
-	)
-	return err
+	}
+	res, err := c.db.Exec(query, append(values, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	c.videoChanged(video.ID)
+	return res, nil
 }
 
+// DeleteVideo removes the video row along with the watch history and
+// geo restriction that refer to it.
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	if _, err := c.db.Exec("DELETE FROM watch_history WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM geo_restrictions WHERE scope = ? AND subject_id = ?", GeoScopeVideo, id.String()); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM video_moderation WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM thumbnail_framing WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM video_versions WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM upload_tokens WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM video_renditions WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM thumbnail_renditions WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM thumbnail_candidates WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM video_chapters WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM access_grants WHERE subject_type = ? AND subject_id = ?", GrantSubjectVideo, id.String()); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM caption_search WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM captions WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM video_transfers WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
+	// Reports are kept for the record, but nothing is left to moderate.
+	if _, err := c.ResolveVideoReports(id, ReportDismissed, "video_deleted"); err != nil {
+		return err
+	}
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
+	if _, err := c.db.Exec(query, id); err != nil {
+		return err
+	}
+	c.videoChanged(id)
+	return nil
+}
+
+func (c Client) GetThumbnailURLs() ([]string, error) {
+	query := `
+	SELECT thumbnail_url
+	FROM videos
+	WHERE thumbnail_url IS NOT NULL
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := []string{}
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+
+	return urls, rows.Err()
+}
+
+// thumbnailFile is the asset file name at the end of a thumbnail URL,
+// stored next to it so assets can be looked up by name.
+func thumbnailFile(thumbnailURL *string) *string {
+	if thumbnailURL == nil {
+		return nil
+	}
+	name := path.Base(*thumbnailURL)
+	return &name
+}
+
+// backfillThumbnailFiles fills in thumbnail_file for thumbnails set before
+// the column existed.
+func (c Client) backfillThumbnailFiles() error {
+	rows, err := c.db.Query("SELECT id, thumbnail_url FROM videos WHERE thumbnail_url IS NOT NULL AND thumbnail_file IS NULL")
+	if err != nil {
+		return err
+	}
+	urls := map[string]string{}
+	for rows.Next() {
+		var id, thumbnailURL string
+		if err := rows.Scan(&id, &thumbnailURL); err != nil {
+			rows.Close()
+			return err
+		}
+		urls[id] = thumbnailURL
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, thumbnailURL := range urls {
+		if _, err := c.db.Exec("UPDATE videos SET thumbnail_file = ? WHERE id = ?", thumbnailFile(&thumbnailURL), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAssetVideoIDs returns the videos a file in the assets directory
+// belongs to, as their thumbnail, a thumbnail candidate or a thumbnail
+// rendition. Files that are no video's, like avatars, have none.
+func (c Client) GetAssetVideoIDs(fileName string) ([]uuid.UUID, error) {
+	query := `
+	SELECT id FROM videos WHERE thumbnail_file = ?1
+	UNION
+	SELECT video_id FROM thumbnail_candidates WHERE file_name = ?1
+	UNION
+	SELECT video_id FROM thumbnail_renditions WHERE file_name = ?1
+	`
+
+	rows, err := c.db.Query(query, fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (c Client) GetAllVideos() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	ORDER BY created_at
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, rows.Err()
+}
+
+// GetVideoSample returns up to n random videos that have a stored video
+// object.
+func (c Client) GetVideoSample(n int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE video_key IS NOT NULL
+	ORDER BY RANDOM()
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, rows.Err()
+}
+
+// GetVideosPage lists videos newest first. A nil userID lists every user's
+// videos.
+func (c Client) GetVideosPage(userID *uuid.UUID, params pagination.Params) ([]Video, pagination.Page, error) {
+	if userID == nil {
+		return c.videosPage("", nil, params)
+	}
+	return c.videosPage(" AND user_id = ?", []any{*userID}, params)
+}
+
+// GetOrganizationVideosPage lists an organization's library, newest first.
+func (c Client) GetOrganizationVideosPage(orgID uuid.UUID, params pagination.Params) ([]Video, pagination.Page, error) {
+	return c.videosPage(" AND org_id = ?", []any{orgID}, params)
+}
+
+// GetSharedVideosPage lists the videos other users have shared with the
+// user, directly or through a folder grant covering the folder they're
+// filed in, newest first.
+func (c Client) GetSharedVideosPage(userID uuid.UUID, params pagination.Params) ([]Video, pagination.Page, error) {
+	filter := `
+	AND user_id != ?
+	AND (
+		id IN (SELECT subject_id FROM access_grants WHERE user_id = ? AND subject_type = ?)
+		OR folder_id IN (
+			WITH RECURSIVE shared(id) AS (
+				SELECT subject_id FROM access_grants WHERE user_id = ? AND subject_type = ?
+				UNION
+				SELECT folders.id FROM folders JOIN shared ON folders.parent_id = shared.id
+			)
+			SELECT id FROM shared
+		)
+	)`
+	return c.videosPage(filter, []any{userID, userID, GrantSubjectVideo, userID, GrantSubjectFolder}, params)
+}
+
+// GetPublicVideosPage lists the user's public videos, newest first.
+func (c Client) GetPublicVideosPage(userID uuid.UUID, params pagination.Params) ([]Video, pagination.Page, error) {
+	return c.videosPage(" AND user_id = ? AND visibility = ?", []any{userID, VisibilityPublic}, params)
+}
+
+// GetSitemapVideos returns up to limit public videos of accounts that
+// aren't suspended, most recently updated first.
+func (c Client) GetSitemapVideos(limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE visibility = ?
+		AND user_id NOT IN (SELECT id FROM users WHERE suspended_at IS NOT NULL)
+	ORDER BY updated_at DESC
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, VisibilityPublic, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// ChannelStats are the totals shown on a user's public channel.
+type ChannelStats struct {
+	VideoCount int64 `json:"video_count"`
+	// ViewerCount and CompletedCount count the signed-in users who started
+	// and finished watching any of the public videos.
+	ViewerCount    int64 `json:"viewer_count"`
+	CompletedCount int64 `json:"completed_count"`
+}
+
+func (c Client) GetChannelStats(userID uuid.UUID) (ChannelStats, error) {
+	query := `
+	SELECT
+		(SELECT COUNT(*) FROM videos WHERE user_id = ? AND visibility = ?),
+		COUNT(w.video_id),
+		COALESCE(SUM(w.completed), 0)
+	FROM watch_history w
+	JOIN videos v ON v.id = w.video_id
+	WHERE v.user_id = ? AND v.visibility = ?
+	`
+	var stats ChannelStats
+	err := c.db.QueryRow(query, userID, VisibilityPublic, userID, VisibilityPublic).Scan(
+		&stats.VideoCount,
+		&stats.ViewerCount,
+		&stats.CompletedCount,
+	)
+	return stats, err
+}
+
+// GetFolderVideosPage lists the videos filed directly in a folder, newest
+// first.
+func (c Client) GetFolderVideosPage(folderID uuid.UUID, params pagination.Params) ([]Video, pagination.Page, error) {
+	return c.videosPage(" AND folder_id = ?", []any{folderID}, params)
+}
+
+func (c Client) videosPage(filter string, filterArgs []any, params pagination.Params) ([]Video, pagination.Page, error) {
+	where, order, args := keyset(params)
+	where += filter
+	args = append(args, filterArgs...)
+	args = append(args, params.Limit+1)
+
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE ` + where + `
+	ORDER BY ` + order + `
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, pagination.Page{}, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, pagination.Page{}, err
+		}
+		videos = append(videos, video)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pagination.Page{}, err
+	}
+
+	videos, page := pagination.Paginate(videos, params, func(v Video) pagination.Cursor {
+		return pagination.Cursor{CreatedAt: v.CreatedAt, ID: v.ID.String()}
+	})
+	return videos, page, nil
+}
+
+// GetFolderVideos returns every video filed directly in any of the given
+// folders.
+func (c Client) GetFolderVideos(folderIDs []uuid.UUID) ([]Video, error) {
+	if len(folderIDs) == 0 {
+		return []Video{}, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(folderIDs)), ", ")
+	args := make([]any, len(folderIDs))
+	for i, id := range folderIDs {
+		args[i] = id
+	}
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE folder_id IN (` + placeholders + `)
+	ORDER BY created_at DESC
+	`
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// PublishDueVideos makes every video scheduled at or before now public and
+// returns their IDs.
+func (c Client) PublishDueVideos(now time.Time) ([]uuid.UUID, error) {
+	query := `
+	UPDATE videos
+	SET visibility = ?, publish_at = NULL, revision = revision + 1, updated_at = CURRENT_TIMESTAMP
+	WHERE publish_at IS NOT NULL AND publish_at <= ?
+	RETURNING id
+	`
+
+	rows, err := c.db.Query(query, VisibilityPublic, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	c.videoChanged(ids...)
+	return ids, nil
+}
+
+// GetExpiredVideos returns up to limit videos whose expiry is at or before
+// now, oldest expiry first. Locked videos are left out until their lock
+// ends.
+func (c Client) GetExpiredVideos(now time.Time, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE expires_at IS NOT NULL AND expires_at <= ?
+		AND NOT legal_hold
+		AND (retain_until IS NULL OR retain_until <= ?)
+	ORDER BY expires_at
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, now.UTC(), now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, rows.Err()
+}
+
+// RecordVideoAccess notes that the video was played at, whether or not
+// the viewer was logged in. Accesses within an hour of the last recorded
+// one aren't written, so a popular video doesn't cost a write per view.
+func (c Client) RecordVideoAccess(id uuid.UUID, at time.Time) error {
+	query := `
+	UPDATE videos SET last_accessed_at = ?
+	WHERE id = ? AND (last_accessed_at IS NULL OR last_accessed_at < ?)
+	`
+	_, err := c.db.Exec(query, at.UTC(), id.String(), at.Add(-time.Hour).UTC())
 	return err
 }
+
+// GetColdVideos returns up to limit standard tier videos created before
+// cutoff that nobody has played or watched since, oldest first.
+func (c Client) GetColdVideos(cutoff time.Time, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE storage_tier = ? AND video_key IS NOT NULL AND created_at < ?
+		AND (last_accessed_at IS NULL OR last_accessed_at < ?)
+		AND NOT EXISTS (
+			SELECT 1 FROM watch_history
+			WHERE watch_history.video_id = videos.id AND watch_history.updated_at >= ?
+		)
+	ORDER BY created_at
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, TierStandard, cutoff.UTC(), cutoff.UTC(), cutoff.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, rows.Err()
+}
+
+// GetVideosInTier returns every video in the given storage tier.
+func (c Client) GetVideosInTier(tier string) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE storage_tier = ?
+	ORDER BY updated_at
+	`
+
+	rows, err := c.db.Query(query, tier)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, rows.Err()
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
+	"github.com/google/uuid"
+)
+
+// WatchPosition is how far a user got in a video the last time they
+// watched it.
+type WatchPosition struct {
+	VideoID         uuid.UUID `json:"video_id"`
+	PositionSeconds float64   `json:"position_seconds"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Completed       bool      `json:"completed"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+func (c Client) RecordWatchPosition(userID uuid.UUID, pos WatchPosition) error {
+	query := `
+	INSERT INTO watch_history (user_id, video_id, position_seconds, duration_seconds, completed, updated_at)
+	VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(user_id, video_id) DO UPDATE SET
+		position_seconds = excluded.position_seconds,
+		duration_seconds = excluded.duration_seconds,
+		completed = excluded.completed,
+		updated_at = CURRENT_TIMESTAMP
+	`
+	_, err := c.db.Exec(query, userID.String(), pos.VideoID.String(), pos.PositionSeconds, pos.DurationSeconds, pos.Completed)
+	return err
+}
+
+// GetWatchPosition returns a zero position, with VideoID set, when the
+// user hasn't watched the video.
+func (c Client) GetWatchPosition(userID, videoID uuid.UUID) (WatchPosition, error) {
+	query := `
+	SELECT video_id, position_seconds, duration_seconds, completed, updated_at
+	FROM watch_history
+	WHERE user_id = ? AND video_id = ?
+	`
+	pos, err := scanWatchPosition(c.db.QueryRow(query, userID.String(), videoID.String()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return WatchPosition{VideoID: videoID}, nil
+	}
+	return pos, err
+}
+
+// WatchHistoryEntry is a position along with the video it's in.
+type WatchHistoryEntry struct {
+	WatchPosition
+	Video Video `json:"video"`
+}
+
+// GetWatchHistoryPage lists the user's positions in videos they can still
+// watch, most recently watched first. Private videos count when the user
+// owns them, belongs to their organization or has a grant on them or a
+// folder above them.
+func (c Client) GetWatchHistoryPage(userID uuid.UUID, params pagination.Params) ([]WatchHistoryEntry, pagination.Page, error) {
+	where, order, args := keysetOn(params, "w.watched_at", "w.watched_id")
+	args = append([]any{
+		userID.String(),
+		VisibilityPrivate,
+		userID,
+		userID,
+		userID, GrantSubjectVideo,
+		userID, GrantSubjectFolder,
+	}, args...)
+	args = append(args, params.Limit+1)
+
+	// watch_history's columns are renamed so the video's can stay
+	// unqualified.
+	query := `
+	SELECT w.watched_id, w.position_seconds, w.duration_seconds, w.completed, w.watched_at,` + videoColumns + `
+	FROM (
+		SELECT video_id AS watched_id, position_seconds, duration_seconds, completed, updated_at AS watched_at
+		FROM watch_history
+		WHERE user_id = ?
+	) w
+	JOIN videos ON videos.id = w.watched_id
+	WHERE (
+		visibility != ?
+		OR user_id = ?
+		OR org_id IN (SELECT org_id FROM organization_members WHERE user_id = ?)
+		OR id IN (SELECT subject_id FROM access_grants WHERE user_id = ? AND subject_type = ?)
+		OR folder_id IN (
+			WITH RECURSIVE shared(id) AS (
+				SELECT subject_id FROM access_grants WHERE user_id = ? AND subject_type = ?
+				UNION
+				SELECT folders.id FROM folders JOIN shared ON folders.parent_id = shared.id
+			)
+			SELECT id FROM shared
+		)
+	) AND ` + where + `
+	ORDER BY ` + order + `
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, pagination.Page{}, err
+	}
+	defer rows.Close()
+
+	history := []WatchHistoryEntry{}
+	for rows.Next() {
+		var entry WatchHistoryEntry
+		pos := &entry.WatchPosition
+		dest := []any{&pos.VideoID, &pos.PositionSeconds, &pos.DurationSeconds, &pos.Completed, &pos.UpdatedAt}
+		video, err := scanVideo(prefixedScanner{row: rows, dest: dest})
+		if err != nil {
+			return nil, pagination.Page{}, err
+		}
+		entry.Video = video
+		history = append(history, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pagination.Page{}, err
+	}
+
+	history, page := pagination.Paginate(history, params, func(e WatchHistoryEntry) pagination.Cursor {
+		return pagination.Cursor{CreatedAt: e.UpdatedAt, ID: e.VideoID.String()}
+	})
+	return history, page, nil
+}
+
+// prefixedScanner scans the columns selected before a video's into dest.
+type prefixedScanner struct {
+	row  rowScanner
+	dest []any
+}
+
+func (s prefixedScanner) Scan(dest ...any) error {
+	return s.row.Scan(append(s.dest, dest...)...)
+}
+
+func scanWatchPosition(row rowScanner) (WatchPosition, error) {
+	var pos WatchPosition
+	err := row.Scan(&pos.VideoID, &pos.PositionSeconds, &pos.DurationSeconds, &pos.Completed, &pos.UpdatedAt)
+	return pos, err
+}
+
+// GetWatchHistory returns all of the user's positions, most recently
+// watched first.
+func (c Client) GetWatchHistory(userID uuid.UUID) ([]WatchPosition, error) {
+	query := `
+	SELECT video_id, position_seconds, duration_seconds, completed, updated_at
+	FROM watch_history
+	WHERE user_id = ?
+	ORDER BY updated_at DESC, video_id DESC
+	`
+
+	rows, err := c.db.Query(query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []WatchPosition{}
+	for rows.Next() {
+		pos, err := scanWatchPosition(rows)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, pos)
+	}
+	return history, rows.Err()
+}
@@ -0,0 +1,353 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/pagination"
+	"github.com/google/uuid"
+)
+
+// WebhookEndpoint is a URL events are POSTed to, signed with its own
+// secret. An empty Events list subscribes it to every event.
+type WebhookEndpoint struct {
+	ID        uuid.UUID `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Subscribed reports whether the endpoint wants events of the type.
+func (e WebhookEndpoint) Subscribed(eventType string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, t := range e.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+const webhookEndpointColumns = `
+		id,
+		url,
+		secret,
+		events,
+		created_at
+`
+
+func scanWebhookEndpoint(row rowScanner) (WebhookEndpoint, error) {
+	var endpoint WebhookEndpoint
+	var events string
+	err := row.Scan(
+		&endpoint.ID,
+		&endpoint.URL,
+		&endpoint.Secret,
+		&events,
+		&endpoint.CreatedAt,
+	)
+	endpoint.Events = []string{}
+	if events != "" {
+		endpoint.Events = strings.Split(events, ",")
+	}
+	return endpoint, err
+}
+
+func (c Client) CreateWebhookEndpoint(url, secret string, events []string) (WebhookEndpoint, error) {
+	query := `
+	INSERT INTO webhook_endpoints (id, url, secret, events, created_at)
+	VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+	RETURNING` + webhookEndpointColumns
+	return scanWebhookEndpoint(c.db.QueryRow(query, uuid.New(), url, secret, strings.Join(events, ",")))
+}
+
+// GetWebhookEndpoint returns a zero value when the endpoint doesn't exist.
+func (c Client) GetWebhookEndpoint(id uuid.UUID) (WebhookEndpoint, error) {
+	query := `
+	SELECT` + webhookEndpointColumns + `
+	FROM webhook_endpoints
+	WHERE id = ?
+	`
+	endpoint, err := scanWebhookEndpoint(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return WebhookEndpoint{}, nil
+	}
+	return endpoint, err
+}
+
+func (c Client) GetWebhookEndpoints() ([]WebhookEndpoint, error) {
+	query := `
+	SELECT` + webhookEndpointColumns + `
+	FROM webhook_endpoints
+	ORDER BY created_at, id
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	endpoints := []WebhookEndpoint{}
+	for rows.Next() {
+		endpoint, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, rows.Err()
+}
+
+// DeleteWebhookEndpoint removes the endpoint along with its delivery log,
+// reporting whether it existed.
+func (c Client) DeleteWebhookEndpoint(id uuid.UUID) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM webhook_endpoints WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	_, err = tx.Exec(`
+	DELETE FROM webhook_attempts
+	WHERE delivery_id IN (SELECT id FROM webhook_deliveries WHERE endpoint_id = ?)
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("DELETE FROM webhook_deliveries WHERE endpoint_id = ?", id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Webhook delivery statuses. A pending delivery is retried at
+// NextAttemptAt until it succeeds or runs out of attempts and fails.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one event on its way to one endpoint.
+type WebhookDelivery struct {
+	ID             uuid.UUID  `json:"id"`
+	EndpointID     uuid.UUID  `json:"endpoint_id"`
+	EventType      string     `json:"event_type"`
+	Payload        string     `json:"payload"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at"`
+	LastStatusCode int        `json:"last_status_code"`
+	LastError      string     `json:"last_error"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+const webhookDeliveryColumns = `
+		id,
+		endpoint_id,
+		event_type,
+		payload,
+		status,
+		attempts,
+		next_attempt_at,
+		last_status_code,
+		last_error,
+		created_at,
+		updated_at
+`
+
+func scanWebhookDelivery(row rowScanner) (WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	err := row.Scan(
+		&delivery.ID,
+		&delivery.EndpointID,
+		&delivery.EventType,
+		&delivery.Payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.LastStatusCode,
+		&delivery.LastError,
+		&delivery.CreatedAt,
+		&delivery.UpdatedAt,
+	)
+	return delivery, err
+}
+
+// CreateWebhookDelivery queues the payload for the endpoint, due now.
+func (c Client) CreateWebhookDelivery(endpointID uuid.UUID, eventType, payload string) (WebhookDelivery, error) {
+	query := `
+	INSERT INTO webhook_deliveries (id, endpoint_id, event_type, payload, status, next_attempt_at, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	RETURNING` + webhookDeliveryColumns
+	return scanWebhookDelivery(c.db.QueryRow(query, uuid.New(), endpointID, eventType, payload, DeliveryPending, time.Now().UTC()))
+}
+
+// GetWebhookDelivery returns a zero value when the delivery doesn't exist.
+func (c Client) GetWebhookDelivery(id uuid.UUID) (WebhookDelivery, error) {
+	query := `
+	SELECT` + webhookDeliveryColumns + `
+	FROM webhook_deliveries
+	WHERE id = ?
+	`
+	delivery, err := scanWebhookDelivery(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return WebhookDelivery{}, nil
+	}
+	return delivery, err
+}
+
+// GetWebhookDeliveriesPage lists an endpoint's deliveries newest first,
+// only those in status unless it's empty.
+func (c Client) GetWebhookDeliveriesPage(endpointID uuid.UUID, status string, params pagination.Params) ([]WebhookDelivery, pagination.Page, error) {
+	where, order, args := keyset(params)
+	where += " AND endpoint_id = ?"
+	args = append(args, endpointID)
+	if status != "" {
+		where += " AND status = ?"
+		args = append(args, status)
+	}
+	args = append(args, params.Limit+1)
+
+	query := `
+	SELECT` + webhookDeliveryColumns + `
+	FROM webhook_deliveries
+	WHERE ` + where + `
+	ORDER BY ` + order + `
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, pagination.Page{}, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, pagination.Page{}, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pagination.Page{}, err
+	}
+
+	deliveries, page := pagination.Paginate(deliveries, params, func(d WebhookDelivery) pagination.Cursor {
+		return pagination.Cursor{CreatedAt: d.CreatedAt, ID: d.ID.String()}
+	})
+	return deliveries, page, nil
+}
+
+// GetDueWebhookDeliveries returns up to limit pending deliveries whose
+// next attempt is at or before now, oldest first.
+func (c Client) GetDueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	query := `
+	SELECT` + webhookDeliveryColumns + `
+	FROM webhook_deliveries
+	WHERE status = ? AND next_attempt_at <= ?
+	ORDER BY next_attempt_at
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, DeliveryPending, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// WebhookAttempt is one try at a delivery. StatusCode is 0 when no
+// response came back.
+type WebhookAttempt struct {
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"error"`
+	DurationMS int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// RecordWebhookAttempt logs the attempt and moves the delivery to status,
+// due again at nextAttemptAt if it's still pending.
+func (c Client) RecordWebhookAttempt(delivery WebhookDelivery, attempt WebhookAttempt, status string, nextAttemptAt *time.Time) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	INSERT INTO webhook_attempts (delivery_id, attempt, status_code, error, duration_ms, created_at)
+	VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`, delivery.ID, attempt.Attempt, attempt.StatusCode, attempt.Error, attempt.DurationMS)
+	if err != nil {
+		return err
+	}
+	if nextAttemptAt != nil {
+		utc := nextAttemptAt.UTC()
+		nextAttemptAt = &utc
+	}
+	_, err = tx.Exec(`
+	UPDATE webhook_deliveries
+	SET status = ?,
+		attempts = ?,
+		next_attempt_at = ?,
+		last_status_code = ?,
+		last_error = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`, status, attempt.Attempt, nextAttemptAt, attempt.StatusCode, attempt.Error, delivery.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c Client) GetWebhookAttempts(deliveryID uuid.UUID) ([]WebhookAttempt, error) {
+	query := `
+	SELECT attempt, status_code, error, duration_ms, created_at
+	FROM webhook_attempts
+	WHERE delivery_id = ?
+	ORDER BY attempt
+	`
+	rows, err := c.db.Query(query, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []WebhookAttempt{}
+	for rows.Next() {
+		var a WebhookAttempt
+		if err := rows.Scan(&a.Attempt, &a.StatusCode, &a.Error, &a.DurationMS, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
@@ -0,0 +1,74 @@
+// Package errreport sends errors to an error tracking service, so failures
+// are grouped and alerted on instead of found by reading logs.
+package errreport
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Event is one error and what it happened during.
+type Event struct {
+	Err error
+	// Message describes the failure when Err doesn't, such as the message
+	// a client was sent.
+	Message string
+	// Tags are short values events are grouped and searched by, such as a
+	// request ID or job type. Extra holds anything else worth seeing.
+	Tags  map[string]string
+	Extra map[string]any
+	// Request is the request being served, if any. Only its method, URL
+	// and a few headers are sent.
+	Request *http.Request
+	Time    time.Time
+}
+
+// Reporter sends events to one service.
+type Reporter interface {
+	Report(ctx context.Context, e Event) error
+}
+
+// Queue reports events in the background, one at a time, so reporting
+// never holds up the request or job that failed. When size events are
+// already waiting, new ones are dropped rather than piling up during an
+// outage.
+type Queue struct {
+	reporter Reporter
+	timeout  time.Duration
+	events   chan Event
+}
+
+func NewQueue(reporter Reporter, size int, timeout time.Duration) *Queue {
+	q := &Queue{
+		reporter: reporter,
+		timeout:  timeout,
+		events:   make(chan Event, max(1, size)),
+	}
+	go q.run()
+	return q
+}
+
+// Report queues e, reporting false if it was dropped.
+func (q *Queue) Report(e Event) bool {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	select {
+	case q.events <- e:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *Queue) run() {
+	for e := range q.events {
+		ctx, cancel := context.WithTimeout(context.Background(), q.timeout)
+		if err := q.reporter.Report(ctx, e); err != nil {
+			log.Printf("errreport: couldn't report error: %v", err)
+		}
+		cancel()
+	}
+}
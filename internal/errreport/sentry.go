@@ -0,0 +1,158 @@
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Sentry sends events to Sentry's store endpoint, which self-hosted
+// installs and sentry.io both accept, without the Sentry SDK.
+type Sentry struct {
+	Client      *http.Client
+	Environment string
+	Release     string
+
+	endpoint  string
+	publicKey string
+}
+
+// NewSentry reads a DSN of the form
+// "https://<public key>@<host>[/<path>]/<project ID>".
+func NewSentry(dsn string) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sentry: invalid DSN: %w", err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, errors.New("sentry: DSN must be an http or https URL")
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("sentry: DSN has no public key")
+	}
+	dir, project := path.Split(strings.TrimSuffix(u.Path, "/"))
+	if project == "" {
+		return nil, errors.New("sentry: DSN has no project ID")
+	}
+	endpoint := url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   path.Join(dir, "api", project, "store") + "/",
+	}
+	return &Sentry{
+		Client:    http.DefaultClient,
+		endpoint:  endpoint.String(),
+		publicKey: u.User.Username(),
+	}, nil
+}
+
+type sentryException struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sentryRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]any    `json:"extra,omitempty"`
+	Request     *sentryRequest    `json:"request,omitempty"`
+	Exception   *struct {
+		Values []sentryException `json:"values"`
+	} `json:"exception,omitempty"`
+}
+
+// sentryHeaders are the request headers sent with an event. Cookies and
+// credentials never are.
+var sentryHeaders = []string{"User-Agent", "Referer", "Content-Type", "Content-Length", "X-Request-ID"}
+
+func (s *Sentry) Report(ctx context.Context, e Event) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	event := sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   e.Time.UTC().Format(time.RFC3339Nano),
+		Level:       "error",
+		Platform:    "go",
+		Logger:      "tubely",
+		Environment: s.Environment,
+		Release:     s.Release,
+		Message:     e.Message,
+		Tags:        e.Tags,
+		Extra:       e.Extra,
+	}
+	if e.Err != nil {
+		// The innermost error's type is the most specific one, and what
+		// Sentry groups by along with the message.
+		root := e.Err
+		for errors.Unwrap(root) != nil {
+			root = errors.Unwrap(root)
+		}
+		event.Exception = &struct {
+			Values []sentryException `json:"values"`
+		}{Values: []sentryException{{Type: reflect.TypeOf(root).String(), Value: e.Err.Error()}}}
+	}
+	if r := e.Request; r != nil {
+		req := &sentryRequest{Method: r.Method, URL: r.URL.Path, Headers: map[string]string{}}
+		if r.Host != "" {
+			scheme := "http"
+			if r.TLS != nil {
+				scheme = "https"
+			}
+			req.URL = scheme + "://" + r.Host + r.URL.Path
+		}
+		for _, h := range sentryHeaders {
+			if v := r.Header.Get(h); v != "" {
+				req.Headers[h] = v
+			}
+		}
+		event.Request = req
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=tubely/1.0, sentry_key=%s", s.publicKey))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sentry: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("sentry: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
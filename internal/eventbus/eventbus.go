@@ -0,0 +1,19 @@
+// Package eventbus publishes domain events to a message broker for
+// downstream consumers. It speaks just enough of the Kafka and NATS
+// protocols to publish, so the server needs no client libraries for them.
+//
+// Since nothing else checks that speaking, the tests publish to real
+// brokers and read the events back when TUBELY_TEST_KAFKA_BROKERS or
+// TUBELY_TEST_NATS_URL point at one, and are skipped otherwise.
+package eventbus
+
+import (
+	"context"
+)
+
+type Publisher interface {
+	// Publish sends body as an event of the given type. Events with the
+	// same key are kept in order where the broker supports it.
+	Publish(ctx context.Context, eventType, key string, body []byte) error
+	Close() error
+}
@@ -0,0 +1,470 @@
+package eventbus
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Kafka publishes every event to Topic as a single record, keyed so that
+// events with the same key land on the same partition, with the event type
+// in a "type" header. It writes with acks=all and no idempotence, so a
+// retried publish can be delivered twice.
+//
+// Only the Metadata (v1) and Produce (v3) requests are implemented, over
+// plaintext or TLS, plus the SASL handshake when SASLMechanism is set.
+type Kafka struct {
+	Brokers  []string
+	Topic    string
+	ClientID string
+	TLS      bool
+	// TLSConfig, when set, supplies the CAs and client certificate to
+	// connect with. The server name is filled in for each broker.
+	TLSConfig *tls.Config
+	// SASLMechanism is SASLPlain, SASLScramSHA256 or SASLScramSHA512, to
+	// log in as Username with Password on every connection. PLAIN sends
+	// the password as is, so it wants TLS.
+	SASLMechanism string
+	Username      string
+	Password      string
+	Timeout       time.Duration
+
+	mu            sync.Mutex
+	conns         map[int32]*kafkaConn
+	leaders       []int32
+	brokers       map[int32]string
+	correlationID int32
+}
+
+const (
+	kafkaAPIProduce  = 0
+	kafkaAPIMetadata = 3
+
+	// Errors that mean the cached partition leaders are out of date.
+	kafkaErrUnknownTopicOrPartition = 3
+	kafkaErrLeaderNotAvailable      = 5
+	kafkaErrNotLeaderForPartition   = 6
+)
+
+type kafkaConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// KafkaError is an error code returned by the broker.
+type KafkaError struct {
+	Code int16
+}
+
+func (e KafkaError) Error() string {
+	return "kafka: broker returned error code " + strconv.Itoa(int(e.Code))
+}
+
+func (k *Kafka) Publish(ctx context.Context, eventType, key string, body []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	batch := recordBatch([]byte(key), body, [][2]string{{"type", eventType}}, time.Now())
+	err := k.produce(ctx, key, batch)
+	var kerr KafkaError
+	if err != nil && (!errors.As(err, &kerr) || staleMetadata(kerr.Code)) {
+		// The leader moved or the connection broke; look the leaders up
+		// again and retry once.
+		k.reset()
+		err = k.produce(ctx, key, batch)
+	}
+	return err
+}
+
+func (k *Kafka) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.reset()
+	return nil
+}
+
+func staleMetadata(code int16) bool {
+	switch code {
+	case kafkaErrUnknownTopicOrPartition, kafkaErrLeaderNotAvailable, kafkaErrNotLeaderForPartition:
+		return true
+	}
+	return false
+}
+
+func (k *Kafka) reset() {
+	for _, c := range k.conns {
+		c.conn.Close()
+	}
+	k.conns = nil
+	k.leaders = nil
+	k.brokers = nil
+}
+
+func (k *Kafka) timeout() time.Duration {
+	if k.Timeout > 0 {
+		return k.Timeout
+	}
+	return 10 * time.Second
+}
+
+func (k *Kafka) produce(ctx context.Context, key string, batch []byte) error {
+	if k.leaders == nil {
+		if err := k.loadMetadata(ctx); err != nil {
+			return err
+		}
+	}
+	partition := int32(murmur2([]byte(key))&0x7fffffff) % int32(len(k.leaders))
+	leader := k.leaders[partition]
+	if leader < 0 {
+		return KafkaError{Code: kafkaErrLeaderNotAvailable}
+	}
+	conn, err := k.conn(ctx, leader)
+	if err != nil {
+		return err
+	}
+
+	var req []byte
+	req = appendString16(req, "", true)              // no transactional ID
+	req = binary.BigEndian.AppendUint16(req, 0xffff) // acks=-1 (all)
+	req = binary.BigEndian.AppendUint32(req, uint32(k.timeout().Milliseconds()))
+	req = binary.BigEndian.AppendUint32(req, 1)
+	req = appendString16(req, k.Topic, false)
+	req = binary.BigEndian.AppendUint32(req, 1)
+	req = binary.BigEndian.AppendUint32(req, uint32(partition))
+	req = binary.BigEndian.AppendUint32(req, uint32(len(batch)))
+	req = append(req, batch...)
+
+	resp, err := k.roundTrip(ctx, conn, kafkaAPIProduce, 3, req)
+	if err != nil {
+		delete(k.conns, leader)
+		conn.conn.Close()
+		return err
+	}
+	// responses[1] { name, partitions[1] { index, error_code, ... } }
+	d := kafkaDecoder{b: resp}
+	d.int32()
+	d.string()
+	d.int32()
+	d.int32()
+	code := d.int16()
+	if d.err != nil {
+		return d.err
+	}
+	if code != 0 {
+		return KafkaError{Code: code}
+	}
+	return nil
+}
+
+// loadMetadata asks any broker for the topic's partition leaders.
+func (k *Kafka) loadMetadata(ctx context.Context) error {
+	var lastErr error
+	for _, addr := range k.Brokers {
+		c, err := k.dial(ctx, addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		req := binary.BigEndian.AppendUint32(nil, 1)
+		req = appendString16(req, k.Topic, false)
+		resp, err := k.roundTrip(ctx, c, kafkaAPIMetadata, 1, req)
+		c.conn.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return k.parseMetadata(resp)
+	}
+	if lastErr == nil {
+		lastErr = errors.New("kafka: no brokers configured")
+	}
+	return lastErr
+}
+
+func (k *Kafka) parseMetadata(resp []byte) error {
+	d := kafkaDecoder{b: resp}
+	brokers := map[int32]string{}
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller ID
+
+	var leaders []int32
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		code := d.int16()
+		name := d.string()
+		d.int8() // is_internal
+		partitions := map[int32]int32{}
+		for p := d.int32(); p > 0 && d.err == nil; p-- {
+			d.int16() // partition error
+			index := d.int32()
+			partitions[index] = d.int32()
+			for r := d.int32(); r > 0 && d.err == nil; r-- {
+				d.int32()
+			}
+			for r := d.int32(); r > 0 && d.err == nil; r-- {
+				d.int32()
+			}
+		}
+		if name != k.Topic {
+			continue
+		}
+		if code != 0 {
+			return KafkaError{Code: code}
+		}
+		leaders = make([]int32, len(partitions))
+		for i := range leaders {
+			leader, ok := partitions[int32(i)]
+			if !ok {
+				leader = -1
+			}
+			leaders[i] = leader
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if len(leaders) == 0 {
+		return fmt.Errorf("kafka: topic %q has no partitions", k.Topic)
+	}
+	k.brokers = brokers
+	k.leaders = leaders
+	return nil
+}
+
+func (k *Kafka) conn(ctx context.Context, broker int32) (*kafkaConn, error) {
+	if c, ok := k.conns[broker]; ok {
+		return c, nil
+	}
+	addr, ok := k.brokers[broker]
+	if !ok {
+		return nil, fmt.Errorf("kafka: unknown broker %d", broker)
+	}
+	c, err := k.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if k.conns == nil {
+		k.conns = map[int32]*kafkaConn{}
+	}
+	k.conns[broker] = c
+	return c, nil
+}
+
+func (k *Kafka) dial(ctx context.Context, addr string) (*kafkaConn, error) {
+	dialer := net.Dialer{Timeout: k.timeout()}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if k.TLS {
+		tlsConfig := &tls.Config{}
+		if k.TLSConfig != nil {
+			tlsConfig = k.TLSConfig.Clone()
+		}
+		tlsConfig.ServerName, _, _ = net.SplitHostPort(addr)
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	c := &kafkaConn{conn: conn, r: bufio.NewReader(conn)}
+	if k.SASLMechanism != "" {
+		if err := k.authenticate(ctx, c); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// roundTrip sends one request and returns the response body after the
+// correlation ID.
+func (k *Kafka) roundTrip(ctx context.Context, c *kafkaConn, apiKey, version int16, body []byte) ([]byte, error) {
+	k.correlationID++
+	id := k.correlationID
+	clientID := k.ClientID
+	if clientID == "" {
+		clientID = "tubely"
+	}
+
+	header := binary.BigEndian.AppendUint16(nil, uint16(apiKey))
+	header = binary.BigEndian.AppendUint16(header, uint16(version))
+	header = binary.BigEndian.AppendUint32(header, uint32(id))
+	header = appendString16(header, clientID, false)
+	msg := binary.BigEndian.AppendUint32(nil, uint32(len(header)+len(body)))
+	msg = append(msg, header...)
+	msg = append(msg, body...)
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(k.timeout())
+	}
+	c.conn.SetDeadline(deadline)
+	if _, err := c.conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(c.r, resp); err != nil {
+		return nil, err
+	}
+	if len(resp) < 4 || int32(binary.BigEndian.Uint32(resp)) != id {
+		return nil, errors.New("kafka: response doesn't match the request")
+	}
+	return resp[4:], nil
+}
+
+// recordBatch encodes a v2 record batch holding one record.
+func recordBatch(key, value []byte, headers [][2]string, now time.Time) []byte {
+	var rec []byte
+	rec = append(rec, 0)              // attributes
+	rec = binary.AppendVarint(rec, 0) // timestamp delta
+	rec = binary.AppendVarint(rec, 0) // offset delta
+	rec = binary.AppendVarint(rec, int64(len(key)))
+	rec = append(rec, key...)
+	rec = binary.AppendVarint(rec, int64(len(value)))
+	rec = append(rec, value...)
+	rec = binary.AppendVarint(rec, int64(len(headers)))
+	for _, h := range headers {
+		rec = binary.AppendVarint(rec, int64(len(h[0])))
+		rec = append(rec, h[0]...)
+		rec = binary.AppendVarint(rec, int64(len(h[1])))
+		rec = append(rec, h[1]...)
+	}
+
+	ts := uint64(now.UnixMilli())
+	// Everything the CRC covers, from the attributes on.
+	var tail []byte
+	tail = binary.BigEndian.AppendUint16(tail, 0)          // attributes
+	tail = binary.BigEndian.AppendUint32(tail, 0)          // last offset delta
+	tail = binary.BigEndian.AppendUint64(tail, ts)         // first timestamp
+	tail = binary.BigEndian.AppendUint64(tail, ts)         // max timestamp
+	tail = binary.BigEndian.AppendUint64(tail, ^uint64(0)) // producer ID -1
+	tail = binary.BigEndian.AppendUint16(tail, 0xffff)     // producer epoch -1
+	tail = binary.BigEndian.AppendUint32(tail, 0xffffffff) // base sequence -1
+	tail = binary.BigEndian.AppendUint32(tail, 1)          // record count
+	tail = binary.AppendVarint(tail, int64(len(rec)))
+	tail = append(tail, rec...)
+
+	var batch []byte
+	batch = binary.BigEndian.AppendUint64(batch, 0) // base offset
+	batch = binary.BigEndian.AppendUint32(batch, uint32(4+1+4+len(tail)))
+	batch = binary.BigEndian.AppendUint32(batch, 0xffffffff) // partition leader epoch
+	batch = append(batch, 2)                                 // magic
+	batch = binary.BigEndian.AppendUint32(batch, crc32.Checksum(tail, crc32.MakeTable(crc32.Castagnoli)))
+	return append(batch, tail...)
+}
+
+// appendString16 appends a string with an int16 length, or a null one.
+func appendString16(b []byte, s string, null bool) []byte {
+	if null {
+		return binary.BigEndian.AppendUint16(b, 0xffff)
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// murmur2 is the hash the Java client's default partitioner uses, so keyed
+// events land on the same partitions as they would from other producers.
+func murmur2(data []byte) uint32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+	length := len(data)
+	h := uint32(seed) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	rest := data[length&^3:]
+	switch len(rest) {
+	case 3:
+		h ^= uint32(rest[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(rest[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(rest[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+// kafkaDecoder reads big-endian fields, remembering the first short read.
+type kafkaDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *kafkaDecoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = errors.New("kafka: truncated response")
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if b := d.take(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+// string reads an int16 length prefixed string; null reads as "".
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
@@ -0,0 +1,200 @@
+package eventbus
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// SASL mechanisms Kafka.SASLMechanism can name.
+const (
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+const (
+	kafkaAPISaslHandshake    = 17
+	kafkaAPISaslAuthenticate = 36
+
+	// Brokers refuse SCRAM credentials stored with fewer iterations, so a
+	// server that asks for fewer isn't a real broker.
+	scramMinIterations = 4096
+)
+
+// authenticate runs the SASL exchange on a fresh connection: a
+// SaslHandshake (v1) naming the mechanism, then SaslAuthenticate (v0)
+// requests carrying its messages.
+func (k *Kafka) authenticate(ctx context.Context, c *kafkaConn) error {
+	mechanism := strings.ToUpper(k.SASLMechanism)
+	var hashFunc func() hash.Hash
+	switch mechanism {
+	case SASLPlain:
+	case SASLScramSHA256:
+		hashFunc = sha256.New
+	case SASLScramSHA512:
+		hashFunc = sha512.New
+	default:
+		return fmt.Errorf("kafka: unsupported SASL mechanism %q", k.SASLMechanism)
+	}
+
+	resp, err := k.roundTrip(ctx, c, kafkaAPISaslHandshake, 1, appendString16(nil, mechanism, false))
+	if err != nil {
+		return err
+	}
+	d := kafkaDecoder{b: resp}
+	code := d.int16()
+	var enabled []string
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		enabled = append(enabled, d.string())
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if code != 0 {
+		return fmt.Errorf("kafka: broker doesn't accept SASL %s, only %s: %w", mechanism, strings.Join(enabled, ", "), KafkaError{Code: code})
+	}
+
+	if hashFunc == nil {
+		_, err := k.saslAuthenticate(ctx, c, []byte("\x00"+k.Username+"\x00"+k.Password))
+		return err
+	}
+	return k.scram(ctx, c, hashFunc)
+}
+
+// saslAuthenticate sends one SASL message and returns the broker's reply.
+func (k *Kafka) saslAuthenticate(ctx context.Context, c *kafkaConn, msg []byte) ([]byte, error) {
+	req := binary.BigEndian.AppendUint32(nil, uint32(len(msg)))
+	req = append(req, msg...)
+	resp, err := k.roundTrip(ctx, c, kafkaAPISaslAuthenticate, 0, req)
+	if err != nil {
+		return nil, err
+	}
+	d := kafkaDecoder{b: resp}
+	code := d.int16()
+	message := d.string()
+	reply := d.take(max(0, int(d.int32())))
+	if d.err != nil {
+		return nil, d.err
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("kafka: SASL authentication failed: %s: %w", message, KafkaError{Code: code})
+	}
+	return reply, nil
+}
+
+// scram authenticates with SCRAM (RFC 5802) over h, checking the broker's
+// signature so a server that doesn't know the password can't pass for it.
+func (k *Kafka) scram(ctx context.Context, c *kafkaConn, h func() hash.Hash) error {
+	nonce := make([]byte, 24)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	s := &scramClient{hash: h, username: k.Username, password: k.Password, nonce: base64.RawStdEncoding.EncodeToString(nonce)}
+	serverFirst, err := k.saslAuthenticate(ctx, c, []byte(s.first()))
+	if err != nil {
+		return err
+	}
+	final, err := s.final(string(serverFirst))
+	if err != nil {
+		return err
+	}
+	serverFinal, err := k.saslAuthenticate(ctx, c, []byte(final))
+	if err != nil {
+		return err
+	}
+	return s.verify(string(serverFinal))
+}
+
+// scramClient holds one SCRAM exchange on the client's side, without
+// channel binding.
+type scramClient struct {
+	hash     func() hash.Hash
+	username string
+	password string
+	nonce    string
+
+	firstBare   string
+	authMessage string
+	salted      []byte
+}
+
+func (s *scramClient) first() string {
+	name := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(s.username)
+	s.firstBare = "n=" + name + ",r=" + s.nonce
+	return "n,," + s.firstBare
+}
+
+// final answers the server's challenge with the proof that the client
+// knows the password.
+func (s *scramClient) final(serverFirst string) (string, error) {
+	attrs := scramAttributes(serverFirst)
+	if e, ok := attrs["e"]; ok {
+		return "", fmt.Errorf("kafka: SCRAM authentication failed: %s", e)
+	}
+	serverNonce := attrs["r"]
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil || !strings.HasPrefix(serverNonce, s.nonce) || len(serverNonce) == len(s.nonce) {
+		return "", errors.New("kafka: malformed SCRAM challenge")
+	}
+	iterations, err := strconv.Atoi(attrs["i"])
+	if err != nil || iterations < scramMinIterations {
+		return "", fmt.Errorf("kafka: SCRAM challenge asks for %q iterations", attrs["i"])
+	}
+
+	s.salted = pbkdf2.Key([]byte(s.password), salt, iterations, s.hash().Size(), s.hash)
+	clientKey := scramHMAC(s.hash, s.salted, "Client Key")
+	storedKey := s.hash()
+	storedKey.Write(clientKey)
+	withoutProof := "c=biws,r=" + serverNonce
+	s.authMessage = s.firstBare + "," + serverFirst + "," + withoutProof
+	proof := scramHMAC(s.hash, storedKey.Sum(nil), s.authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// verify checks the server's signature over the exchange.
+func (s *scramClient) verify(serverFinal string) error {
+	attrs := scramAttributes(serverFinal)
+	if e, ok := attrs["e"]; ok {
+		return fmt.Errorf("kafka: SCRAM authentication failed: %s", e)
+	}
+	want := scramHMAC(s.hash, scramHMAC(s.hash, s.salted, "Server Key"), s.authMessage)
+	got, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil || !hmac.Equal(got, want) {
+		return errors.New("kafka: broker's SCRAM signature doesn't match")
+	}
+	return nil
+}
+
+func scramHMAC(h func() hash.Hash, key []byte, msg string) []byte {
+	mac := hmac.New(h, key)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
+
+// scramAttributes splits a SCRAM message into its a=value attributes.
+// Values can hold '=', as base64 does, but never ','.
+func scramAttributes(msg string) map[string]string {
+	attrs := map[string]string{}
+	for _, field := range strings.Split(msg, ",") {
+		name, value, ok := strings.Cut(field, "=")
+		if ok && len(name) == 1 {
+			attrs[name] = value
+		}
+	}
+	return attrs
+}
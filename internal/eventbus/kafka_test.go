@@ -0,0 +1,291 @@
+package eventbus
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Hashes from the Java client's own murmur2 tests, which the default
+// partitioner is built on.
+func TestMurmur2MatchesJavaClient(t *testing.T) {
+	cases := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for key, want := range cases {
+		if got := int32(murmur2([]byte(key))); got != want {
+			t.Errorf("murmur2(%q) = %d, want %d", key, got, want)
+		}
+	}
+}
+
+func TestRecordBatchDecodes(t *testing.T) {
+	batch := recordBatch([]byte("key"), []byte("value"), [][2]string{{"type", "video.created"}}, time.UnixMilli(1700000000000))
+	records, next, err := decodeRecordBatches(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != 1 {
+		t.Errorf("next offset = %d, want 1", next)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	r := records[0]
+	if string(r.key) != "key" || string(r.value) != "value" || r.headers["type"] != "video.created" {
+		t.Errorf("decoded %q = %q with headers %v", r.key, r.value, r.headers)
+	}
+}
+
+// The SCRAM-SHA-256 exchange from RFC 7677.
+func TestScramMatchesRFC7677(t *testing.T) {
+	s := &scramClient{hash: sha256.New, username: "user", password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO"}
+	if got, want := s.first(), "n,,n=user,r=rOprNGfwEbeRWgbNEkqO"; got != want {
+		t.Fatalf("first = %q, want %q", got, want)
+	}
+	final, err := s.final("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="; final != want {
+		t.Fatalf("final = %q, want %q", final, want)
+	}
+	if err := s.verify("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+	if err := s.verify("v=AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="); err == nil {
+		t.Error("verify accepted a forged server signature")
+	}
+}
+
+func TestScramRejectsBadChallenges(t *testing.T) {
+	cases := map[string]string{
+		"foreign nonce":   "r=someoneelse,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+		"unchanged nonce": "r=rOprNGfwEbeRWgbNEkqO,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+		"bad salt":        "r=rOprNGfwEbeRWgbNEkqOxyz,s=!!,i=4096",
+		"few iterations":  "r=rOprNGfwEbeRWgbNEkqOxyz,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=1",
+		"server error":    "e=unknown-user",
+	}
+	for name, challenge := range cases {
+		s := &scramClient{hash: sha256.New, username: "user", password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO"}
+		s.first()
+		if _, err := s.final(challenge); err == nil {
+			t.Errorf("%s: final accepted %q", name, challenge)
+		}
+	}
+}
+
+// TestKafkaRoundTrip publishes to a real broker and fetches the record
+// back. It runs when TUBELY_TEST_KAFKA_BROKERS lists one, e.g.
+// localhost:9092; the topic, TUBELY_TEST_KAFKA_TOPIC or tubely-test, has
+// to exist or be auto-created. TUBELY_TEST_KAFKA_SASL_MECHANISM,
+// _USERNAME and _PASSWORD log in first, and TUBELY_TEST_KAFKA_TLS=true
+// connects over TLS.
+func TestKafkaRoundTrip(t *testing.T) {
+	brokers := os.Getenv("TUBELY_TEST_KAFKA_BROKERS")
+	if brokers == "" {
+		t.Skip("TUBELY_TEST_KAFKA_BROKERS isn't set")
+	}
+	topic := os.Getenv("TUBELY_TEST_KAFKA_TOPIC")
+	if topic == "" {
+		topic = "tubely-test"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	k := &Kafka{
+		Brokers:       strings.Split(brokers, ","),
+		Topic:         topic,
+		TLS:           os.Getenv("TUBELY_TEST_KAFKA_TLS") == "true",
+		SASLMechanism: os.Getenv("TUBELY_TEST_KAFKA_SASL_MECHANISM"),
+		Username:      os.Getenv("TUBELY_TEST_KAFKA_SASL_USERNAME"),
+		Password:      os.Getenv("TUBELY_TEST_KAFKA_SASL_PASSWORD"),
+		Timeout:       10 * time.Second,
+	}
+	defer k.Close()
+	key := "round-trip-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	body := []byte(`{"video_id":"` + key + `"}`)
+	// An auto-created topic has no leaders for a moment.
+	var err error
+	for attempt := 0; attempt < 10; attempt++ {
+		if err = k.Publish(ctx, "video.created", key, body); err == nil {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	partition := int32(murmur2([]byte(key))&0x7fffffff) % int32(len(k.leaders))
+	conn, err := k.conn(ctx, k.leaders[partition])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offset int64
+	for {
+		records, next, err := k.fetch(ctx, conn, partition, offset)
+		if err != nil {
+			t.Fatalf("fetch at offset %d: %v", offset, err)
+		}
+		for _, r := range records {
+			if string(r.key) != key {
+				continue
+			}
+			if string(r.value) != string(body) || r.headers["type"] != "video.created" {
+				t.Fatalf("fetched %q with headers %v, want %q", r.value, r.headers, body)
+			}
+			return
+		}
+		if next <= offset {
+			t.Fatalf("record %s not found in partition %d", key, partition)
+		}
+		offset = next
+	}
+}
+
+type fetchedRecord struct {
+	key, value []byte
+	headers    map[string]string
+}
+
+// fetch reads the record batches of a partition from offset on with a
+// Fetch (v4) request, returning the records and the offset after them.
+func (k *Kafka) fetch(ctx context.Context, c *kafkaConn, partition int32, offset int64) ([]fetchedRecord, int64, error) {
+	req := binary.BigEndian.AppendUint32(nil, 0xffffffff) // replica ID -1
+	req = binary.BigEndian.AppendUint32(req, 500)         // max wait ms
+	req = binary.BigEndian.AppendUint32(req, 1)           // min bytes
+	req = binary.BigEndian.AppendUint32(req, 16<<20)      // max bytes
+	req = append(req, 0)                                  // read uncommitted
+	req = binary.BigEndian.AppendUint32(req, 1)
+	req = appendString16(req, k.Topic, false)
+	req = binary.BigEndian.AppendUint32(req, 1)
+	req = binary.BigEndian.AppendUint32(req, uint32(partition))
+	req = binary.BigEndian.AppendUint64(req, uint64(offset))
+	req = binary.BigEndian.AppendUint32(req, 16<<20)
+	resp, err := k.roundTrip(ctx, c, 1, 4, req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// throttle, responses[1] { name, partitions[1] { index, error_code,
+	// high_watermark, last_stable_offset, aborted[], records } }
+	d := kafkaDecoder{b: resp}
+	d.int32()
+	d.int32()
+	d.string()
+	d.int32()
+	d.int32()
+	code := d.int16()
+	d.take(16)
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		d.take(16)
+	}
+	records := d.take(max(0, int(d.int32()))) // null when there are none
+	if d.err != nil {
+		return nil, 0, d.err
+	}
+	if code != 0 {
+		return nil, 0, KafkaError{Code: code}
+	}
+	fetched, next, err := decodeRecordBatches(records)
+	return fetched, max(next, offset), err
+}
+
+// decodeRecordBatches decodes uncompressed v2 record batches, checking
+// their CRCs, and returns the offset after the last one. A batch cut off
+// at the end of the fetch is left for the next one.
+func decodeRecordBatches(b []byte) ([]fetchedRecord, int64, error) {
+	var records []fetchedRecord
+	var next int64
+	for len(b) >= 12 {
+		baseOffset := int64(binary.BigEndian.Uint64(b))
+		length := int(binary.BigEndian.Uint32(b[8:]))
+		if len(b) < 12+length {
+			break
+		}
+		batch := b[12 : 12+length]
+		b = b[12+length:]
+		if len(batch) < 49 || batch[4] != 2 {
+			return nil, 0, errors.New("not a v2 record batch")
+		}
+		tail := batch[9:]
+		if crc32.Checksum(tail, crc32.MakeTable(crc32.Castagnoli)) != binary.BigEndian.Uint32(batch[5:]) {
+			return nil, 0, errors.New("record batch CRC mismatch")
+		}
+		attributes := binary.BigEndian.Uint16(tail)
+		next = baseOffset + int64(binary.BigEndian.Uint32(tail[2:])) + 1
+		// Other producers may compress; none of their records are ours.
+		if attributes&0x7 != 0 {
+			continue
+		}
+		count := int(binary.BigEndian.Uint32(tail[36:]))
+		rest := tail[40:]
+		for i := 0; i < count; i++ {
+			r, n, err := decodeRecord(rest)
+			if err != nil {
+				return nil, 0, err
+			}
+			records = append(records, r)
+			rest = rest[n:]
+		}
+	}
+	return records, next, nil
+}
+
+func decodeRecord(b []byte) (fetchedRecord, int, error) {
+	var err error
+	pos := 0
+	varint := func() int64 {
+		v, n := binary.Varint(b[pos:])
+		if n <= 0 {
+			err = errors.New("bad varint in record")
+			return 0
+		}
+		pos += n
+		return v
+	}
+	bytes := func() []byte {
+		n := varint()
+		if err != nil || n < 0 {
+			return nil
+		}
+		if pos+int(n) > len(b) {
+			err = errors.New("truncated record")
+			return nil
+		}
+		v := b[pos : pos+int(n)]
+		pos += int(n)
+		return v
+	}
+
+	length := varint()
+	start := pos
+	if err != nil || pos >= len(b) {
+		return fetchedRecord{}, 0, errors.New("truncated record")
+	}
+	pos++ // attributes
+	varint()
+	varint()
+	r := fetchedRecord{key: bytes(), value: bytes(), headers: map[string]string{}}
+	for n := varint(); n > 0 && err == nil; n-- {
+		name := bytes()
+		r.headers[string(name)] = string(bytes())
+	}
+	if err == nil && pos-start != int(length) {
+		err = errors.New("record length mismatch")
+	}
+	return r, pos, err
+}
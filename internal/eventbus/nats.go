@@ -0,0 +1,201 @@
+package eventbus
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NATS publishes each event on the subject SubjectPrefix + "." + the event
+// type, e.g. "tubely.video.created". It connects on first use and
+// reconnects after a failure.
+type NATS struct {
+	// URL is nats://[user:pass@]host:port, or tls:// for TLS. A user
+	// without a password is sent as a token.
+	URL           string
+	SubjectPrefix string
+	Timeout       time.Duration
+
+	mu         sync.Mutex
+	conn       net.Conn
+	w          *bufio.Writer
+	maxPayload int
+}
+
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+	MaxPayload  int  `json:"max_payload"`
+}
+
+func (n *NATS) Publish(ctx context.Context, eventType, key string, body []byte) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.conn == nil {
+		if err := n.connect(ctx); err != nil {
+			return fmt.Errorf("nats: %w", err)
+		}
+	}
+	if n.maxPayload > 0 && len(body) > n.maxPayload {
+		return fmt.Errorf("nats: event is %d bytes, the server takes at most %d", len(body), n.maxPayload)
+	}
+	subject := eventType
+	if n.SubjectPrefix != "" {
+		subject = n.SubjectPrefix + "." + eventType
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		n.conn.SetWriteDeadline(deadline)
+	} else {
+		n.conn.SetWriteDeadline(time.Now().Add(n.timeout()))
+	}
+	fmt.Fprintf(n.w, "PUB %s %d\r\n", subject, len(body))
+	n.w.Write(body)
+	n.w.WriteString("\r\n")
+	if err := n.w.Flush(); err != nil {
+		n.closeLocked()
+		return fmt.Errorf("nats: %w", err)
+	}
+	return nil
+}
+
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.closeLocked()
+}
+
+func (n *NATS) closeLocked() error {
+	if n.conn == nil {
+		return nil
+	}
+	err := n.conn.Close()
+	n.conn = nil
+	n.w = nil
+	return err
+}
+
+func (n *NATS) timeout() time.Duration {
+	if n.Timeout > 0 {
+		return n.Timeout
+	}
+	return 10 * time.Second
+}
+
+// connect dials the server, reads its INFO and introduces the client.
+func (n *NATS) connect(ctx context.Context) error {
+	u, err := url.Parse(n.URL)
+	if err != nil {
+		return err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	dialer := net.Dialer{Timeout: n.timeout()}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(n.timeout()))
+	r := bufio.NewReader(conn)
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	infoJSON, ok := strings.CutPrefix(strings.TrimSpace(line), "INFO ")
+	if !ok {
+		conn.Close()
+		return fmt.Errorf("expected INFO, got %q", line)
+	}
+	var info natsInfo
+	if err := json.Unmarshal([]byte(infoJSON), &info); err != nil {
+		conn.Close()
+		return err
+	}
+	if info.TLSRequired || u.Scheme == "tls" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return err
+		}
+		conn = tlsConn
+		r = bufio.NewReader(conn)
+	}
+
+	opts := map[string]any{"verbose": false, "pedantic": false, "name": "tubely", "lang": "go", "version": "1"}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			opts["user"] = u.User.Username()
+			opts["pass"] = pass
+		} else {
+			opts["auth_token"] = u.User.Username()
+		}
+	}
+	connect, err := json.Marshal(opts)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	// The PING makes the server answer, so a rejected CONNECT shows up
+	// here as -ERR instead of on the first publish.
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", connect); err != nil {
+		conn.Close()
+		return err
+	}
+	line, err = r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if line = strings.TrimSpace(line); line != "PONG" {
+		conn.Close()
+		return errors.New(line)
+	}
+	conn.SetDeadline(time.Time{})
+
+	n.conn = conn
+	n.w = bufio.NewWriter(conn)
+	n.maxPayload = info.MaxPayload
+	go n.readLoop(conn, r)
+	return nil
+}
+
+// readLoop answers the server's keep-alive PINGs and logs its errors until
+// the connection closes.
+func (n *NATS) readLoop(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			n.mu.Lock()
+			if n.conn == conn {
+				n.closeLocked()
+			}
+			n.mu.Unlock()
+			return
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PING":
+			n.mu.Lock()
+			if n.conn == conn {
+				conn.SetWriteDeadline(time.Now().Add(n.timeout()))
+				n.w.WriteString("PONG\r\n")
+				n.w.Flush()
+			}
+			n.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			log.Printf("nats: %s", line)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package eventbus
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNATSRoundTrip publishes to a real server and receives the message on
+// a subscription of its own. It runs when TUBELY_TEST_NATS_URL is set,
+// e.g. nats://localhost:4222, to a server without TLS.
+func TestNATSRoundTrip(t *testing.T) {
+	serverURL := os.Getenv("TUBELY_TEST_NATS_URL")
+	if serverURL == "" {
+		t.Skip("TUBELY_TEST_NATS_URL isn't set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	prefix := "tubely-test." + strconv.FormatInt(time.Now().UnixNano(), 10)
+	sub := natsSubscribe(t, serverURL, prefix+".video.created")
+	defer sub.conn.Close()
+
+	n := &NATS{URL: serverURL, SubjectPrefix: prefix, Timeout: 10 * time.Second}
+	defer n.Close()
+	body := []byte(`{"video_id":"round-trip"}`)
+	if err := n.Publish(ctx, "video.created", "round-trip", body); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	// A second publish goes over the connection the first one opened.
+	if err := n.Publish(ctx, "video.created", "round-trip", body); err != nil {
+		t.Fatalf("second publish: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		subject, payload := sub.next(t)
+		if subject != prefix+".video.created" || string(payload) != string(body) {
+			t.Fatalf("received %q on %s, want %q on %s.video.created", payload, subject, body, prefix)
+		}
+	}
+}
+
+type natsSubscription struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// natsSubscribe opens a connection of its own and subscribes to subject,
+// waiting for the server to confirm it before returning.
+func natsSubscribe(t *testing.T, serverURL, subject string) *natsSubscription {
+	t.Helper()
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	conn, err := net.DialTimeout("tcp", host, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(20 * time.Second))
+	sub := &natsSubscription{conn: conn, r: bufio.NewReader(conn)}
+	if _, err := sub.r.ReadString('\n'); err != nil {
+		t.Fatalf("reading INFO: %v", err)
+	}
+
+	opts := map[string]any{"verbose": false, "pedantic": false}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			opts["user"] = u.User.Username()
+			opts["pass"] = pass
+		} else {
+			opts["auth_token"] = u.User.Username()
+		}
+	}
+	connect, _ := json.Marshal(opts)
+	fmt.Fprintf(conn, "CONNECT %s\r\nSUB %s 1\r\nPING\r\n", connect, subject)
+	if line, err := sub.r.ReadString('\n'); err != nil || strings.TrimSpace(line) != "PONG" {
+		t.Fatalf("subscribing: %q, %v", line, err)
+	}
+	return sub
+}
+
+// next returns the subject and payload of the next message, answering the
+// server's PINGs on the way.
+func (s *natsSubscription) next(t *testing.T) (string, []byte) {
+	t.Helper()
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("waiting for message: %v", err)
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 1 && fields[0] == "PING":
+			fmt.Fprint(s.conn, "PONG\r\n")
+		case len(fields) >= 4 && fields[0] == "MSG":
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				t.Fatalf("bad MSG line %q", line)
+			}
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(s.r, payload); err != nil {
+				t.Fatalf("reading message: %v", err)
+			}
+			return fields[1], payload[:size]
+		case len(fields) > 0 && fields[0] == "-ERR":
+			t.Fatalf("server error: %s", line)
+		}
+	}
+}
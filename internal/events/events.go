@@ -0,0 +1,89 @@
+// Package events fans server events out to live subscribers, such as the
+// admin event stream. Publishing never blocks: a subscriber that falls
+// behind misses events rather than slowing the server down.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+type Event struct {
+	// ID increases by one with every event published, so subscribers can
+	// resume after the last one they saw.
+	ID   uint64    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+// Hub keeps the last few events so a subscriber that reconnects can catch
+// up on what it missed.
+type Hub struct {
+	mu     sync.Mutex
+	nextID uint64
+	recent []Event
+	keep   int
+	subs   map[chan Event]struct{}
+}
+
+// NewHub returns a hub that remembers the last keep events.
+func NewHub(keep int) *Hub {
+	return &Hub{nextID: 1, keep: keep, subs: map[chan Event]struct{}{}}
+}
+
+// Publish sends the event to every subscriber with room for it. A nil Hub
+// drops it.
+func (h *Hub) Publish(eventType string, data any) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	e := Event{ID: h.nextID, Type: eventType, Time: time.Now().UTC(), Data: data}
+	h.nextID++
+	if h.keep > 0 {
+		if len(h.recent) == h.keep {
+			h.recent = append(h.recent[:0], h.recent[1:]...)
+		}
+		h.recent = append(h.recent, e)
+	}
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of events published from now on, preceded
+// by the remembered ones after afterID, and a func that unsubscribes. Pass
+// 0 to skip the remembered events.
+func (h *Hub) Subscribe(afterID uint64, buffer int) (<-chan Event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var backlog []Event
+	if afterID > 0 {
+		for _, e := range h.recent {
+			if e.ID > afterID {
+				backlog = append(backlog, e)
+			}
+		}
+	}
+	ch := make(chan Event, buffer+len(backlog))
+	for _, e := range backlog {
+		ch <- e
+	}
+	h.subs[ch] = struct{}{}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			delete(h.subs, ch)
+		})
+	}
+}
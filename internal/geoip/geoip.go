@@ -0,0 +1,96 @@
+// Package geoip maps client addresses to ISO 3166-1 alpha-2 country codes.
+package geoip
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+type Locator interface {
+	// Country returns the upper case country code for addr, or "" when
+	// it's unknown.
+	Country(addr netip.Addr) string
+}
+
+type ipRange struct {
+	start, end netip.Addr
+	country    string
+}
+
+// RangeDB looks addresses up in a sorted list of ranges, as found in the
+// CSV exports most GeoIP providers offer.
+type RangeDB struct {
+	ranges []ipRange
+}
+
+// LoadCSV reads "start_ip,end_ip,country" rows. A header row and any
+// extra columns are ignored. Ranges must not overlap.
+func LoadCSV(path string) (*RangeDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	db := &RangeDB{}
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 {
+			return nil, fmt.Errorf("geoip: line %d: expected start, end and country", line)
+		}
+		start, err := netip.ParseAddr(strings.TrimSpace(record[0]))
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("geoip: line %d: %w", line, err)
+		}
+		end, err := netip.ParseAddr(strings.TrimSpace(record[1]))
+		if err != nil {
+			return nil, fmt.Errorf("geoip: line %d: %w", line, err)
+		}
+		if start.Is4() != end.Is4() || end.Less(start) {
+			return nil, fmt.Errorf("geoip: line %d: invalid range", line)
+		}
+		db.ranges = append(db.ranges, ipRange{
+			start:   start,
+			end:     end,
+			country: strings.ToUpper(strings.TrimSpace(record[2])),
+		})
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return db.ranges[i].start.Less(db.ranges[j].start)
+	})
+	return db, nil
+}
+
+func (db *RangeDB) Country(addr netip.Addr) string {
+	addr = addr.Unmap()
+	// Find the last range starting at or before addr.
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return addr.Less(db.ranges[i].start)
+	}) - 1
+	if i < 0 {
+		return ""
+	}
+	rng := db.ranges[i]
+	if rng.start.Is4() != addr.Is4() || rng.end.Less(addr) {
+		return ""
+	}
+	return rng.country
+}
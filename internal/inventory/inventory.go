@@ -0,0 +1,222 @@
+// Package inventory reads S3 Inventory reports, which list every object in
+// a bucket once a day for a fraction of what listing it with ListObjects
+// costs. Only the CSV format is supported.
+package inventory
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ErrNoReport is returned by Latest when no report has been delivered yet.
+var ErrNoReport = errors.New("inventory: no report found")
+
+// reportDir matches the folder each report is delivered in, named after
+// when it was taken, e.g. "2024-05-01T01-00Z".
+var reportDir = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}-\d{2}Z$`)
+
+// Reader reads the reports an inventory configuration delivers to Bucket.
+type Reader struct {
+	Client *s3.Client
+	Bucket string
+	// Prefix is where the configuration's reports go:
+	// "<destination prefix>/<source bucket>/<configuration ID>".
+	Prefix string
+}
+
+type Manifest struct {
+	SourceBucket string `json:"sourceBucket"`
+	FileFormat   string `json:"fileFormat"`
+	// FileSchema names the CSV columns, e.g. "Bucket, Key, Size, ETag".
+	FileSchema string `json:"fileSchema"`
+	// CreationTimestamp is in milliseconds since the epoch.
+	CreationTimestamp string         `json:"creationTimestamp"`
+	Files             []ManifestFile `json:"files"`
+
+	// Key is where the manifest itself was read from.
+	Key string `json:"-"`
+}
+
+type ManifestFile struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+}
+
+// CreatedAt is when the report was taken. Objects written after it aren't
+// in it.
+func (m Manifest) CreatedAt() time.Time {
+	ms, err := strconv.ParseInt(m.CreationTimestamp, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms).UTC()
+}
+
+// Object is one row of a report. Columns the configuration doesn't
+// include are left at their zero value, except IsLatest, which is true
+// for reports of current versions only.
+type Object struct {
+	Key            string
+	VersionID      string
+	IsLatest       bool
+	IsDeleteMarker bool
+	Size           int64
+	ETag           string
+	StorageClass   string
+}
+
+// Latest returns the manifest of the newest complete report.
+func (r *Reader) Latest(ctx context.Context) (Manifest, error) {
+	prefix := strings.TrimSuffix(r.Prefix, "/") + "/"
+	var dirs []string
+	paginator := s3.NewListObjectsV2Paginator(r.Client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(r.Bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return Manifest{}, fmt.Errorf("inventory: couldn't list reports: %w", err)
+		}
+		for _, p := range page.CommonPrefixes {
+			dir := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(p.Prefix), prefix), "/")
+			if reportDir.MatchString(dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+
+	// The names sort by date. A report is complete once its manifest has
+	// been written, so one that's still being delivered is skipped.
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		m, err := r.manifest(ctx, prefix+dir+"/manifest.json")
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			continue
+		}
+		return m, err
+	}
+	return Manifest{}, ErrNoReport
+}
+
+func (r *Reader) manifest(ctx context.Context, key string) (Manifest, error) {
+	out, err := r.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer out.Body.Close()
+
+	m := Manifest{Key: key}
+	if err := json.NewDecoder(out.Body).Decode(&m); err != nil {
+		return Manifest{}, fmt.Errorf("inventory: couldn't decode %s: %w", key, err)
+	}
+	if m.FileFormat != "CSV" {
+		return Manifest{}, fmt.Errorf("inventory: %s reports aren't supported, only CSV", m.FileFormat)
+	}
+	return m, nil
+}
+
+// Walk calls fn with every object in the report, stopping at the first
+// error fn returns.
+func (r *Reader) Walk(ctx context.Context, m Manifest, fn func(Object) error) error {
+	columns := map[string]int{}
+	for i, name := range strings.Split(m.FileSchema, ",") {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["Key"]; !ok {
+		return fmt.Errorf("inventory: schema %q has no Key column", m.FileSchema)
+	}
+	for _, file := range m.Files {
+		if err := r.walkFile(ctx, file.Key, columns, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Reader) walkFile(ctx context.Context, key string, columns map[string]int, fn func(Object) error) error {
+	out, err := r.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("inventory: couldn't get %s: %w", key, err)
+	}
+	defer out.Body.Close()
+	gz, err := gzip.NewReader(out.Body)
+	if err != nil {
+		return fmt.Errorf("inventory: couldn't read %s: %w", key, err)
+	}
+	defer gz.Close()
+
+	rows := csv.NewReader(gz)
+	rows.FieldsPerRecord = -1
+	rows.ReuseRecord = true
+	for {
+		record, err := rows.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("inventory: couldn't read %s: %w", key, err)
+		}
+		obj, err := parseObject(record, columns)
+		if err != nil {
+			return fmt.Errorf("inventory: %s: %w", key, err)
+		}
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+}
+
+func parseObject(record []string, columns map[string]int) (Object, error) {
+	field := func(name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return record[i]
+	}
+
+	// Keys are URL encoded in CSV reports.
+	key, err := url.QueryUnescape(field("Key"))
+	if err != nil {
+		return Object{}, fmt.Errorf("bad key %q: %w", field("Key"), err)
+	}
+	obj := Object{
+		Key:            key,
+		VersionID:      field("VersionId"),
+		IsLatest:       field("IsLatest") != "false",
+		IsDeleteMarker: field("IsDeleteMarker") == "true",
+		ETag:           field("ETag"),
+		StorageClass:   field("StorageClass"),
+	}
+	if size := field("Size"); size != "" {
+		obj.Size, err = strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return Object{}, fmt.Errorf("bad size of %s: %w", key, err)
+		}
+	}
+	return obj, nil
+}
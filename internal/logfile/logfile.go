@@ -0,0 +1,135 @@
+// Package logfile provides an append-only log file that rotates itself
+// once it grows past a size limit and can be reopened after an external
+// tool such as logrotate has moved it.
+package logfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+type File struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	// limit is the size the next rotation happens at: maxSize, or further
+	// on after a rotation failed, so it isn't retried on every write.
+	limit int64
+}
+
+// Open opens path for appending. When maxSize is positive the file is
+// rotated to path.1, path.2, ... before a write would exceed it, keeping at
+// most maxBackups old files.
+func Open(path string, maxSize int64, maxBackups int) (*File, error) {
+	lf := &File{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		limit:      maxSize,
+	}
+	if err := lf.open(); err != nil {
+		return nil, err
+	}
+	return lf, nil
+}
+
+func (lf *File) open() error {
+	f, err := os.OpenFile(lf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	lf.f = f
+	lf.size = info.Size()
+	return nil
+}
+
+func (lf *File) Write(p []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	if lf.f == nil {
+		if err := lf.open(); err != nil {
+			return 0, err
+		}
+	}
+	var rotateErr error
+	if lf.maxSize > 0 && lf.size > 0 && lf.size+int64(len(p)) > lf.limit {
+		if rotateErr = lf.rotate(); rotateErr != nil {
+			if lf.f == nil {
+				return 0, rotateErr
+			}
+			lf.limit = lf.size + lf.maxSize
+		}
+	}
+	n, err := lf.f.Write(p)
+	lf.size += int64(n)
+	if err == nil && rotateErr != nil {
+		err = fmt.Errorf("logfile: couldn't rotate %s, still appending to it: %w", lf.path, rotateErr)
+	}
+	return n, err
+}
+
+// rotate moves the file aside and opens a new one. If it can't be moved,
+// the original is opened again so writes keep going to it; lf.f is only
+// left nil when that fails too.
+func (lf *File) rotate() error {
+	closeErr := lf.f.Close()
+	lf.f = nil
+	err := closeErr
+	if err == nil {
+		err = lf.moveAside()
+	}
+	if openErr := lf.open(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	if err == nil {
+		lf.limit = lf.maxSize
+	}
+	return err
+}
+
+func (lf *File) moveAside() error {
+	if lf.maxBackups > 0 {
+		os.Remove(backupName(lf.path, lf.maxBackups))
+		for i := lf.maxBackups - 1; i >= 1; i-- {
+			os.Rename(backupName(lf.path, i), backupName(lf.path, i+1))
+		}
+		return os.Rename(lf.path, backupName(lf.path, 1))
+	}
+	return os.Truncate(lf.path, 0)
+}
+
+// Reopen closes and reopens the file at its original path.
+func (lf *File) Reopen() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.f != nil {
+		lf.f.Close()
+		lf.f = nil
+	}
+	return lf.open()
+}
+
+func (lf *File) Close() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.f == nil {
+		return nil
+	}
+	return lf.f.Close()
+}
+
+func backupName(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
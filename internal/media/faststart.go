@@ -0,0 +1,68 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// IsFastStart reports whether the file at path is an MP4 whose moov box
+// comes before its mdat box, so players can start before they have the
+// whole file. Only the top-level box headers are read. QuickTime files
+// report false: they're remuxed anyway to be stored as MP4.
+func IsFastStart(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	var offset int64
+	header := make([]byte, 16)
+	for first := true; ; first = false {
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			if errors.Is(err, io.EOF) {
+				return false, nil
+			}
+			return false, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			// The box runs to the end of the file.
+			return boxType == "moov", nil
+		case 1:
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil {
+				return false, nil
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize {
+			return false, nil
+		}
+
+		if first {
+			if boxType != "ftyp" {
+				return false, nil
+			}
+			brand := make([]byte, 4)
+			if _, err := f.ReadAt(brand, offset+headerSize); err != nil {
+				return false, nil
+			}
+			if string(brand) == "qt  " {
+				return false, nil
+			}
+		}
+		switch boxType {
+		case "moov":
+			return true, nil
+		case "mdat":
+			return false, nil
+		}
+		offset += size
+	}
+}
@@ -0,0 +1,120 @@
+package media
+
+import (
+	"errors"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Rect is a rectangle in fractions of an image's width and height.
+type Rect struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// Point is a position in fractions of an image's width and height.
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Framing picks the part of an image that thumbnails of other shapes
+// show. Crop limits them to a rectangle; FocalPoint keeps a spot as close
+// to their centre as the image allows. The zero value centres them.
+type Framing struct {
+	Crop       *Rect  `json:"crop,omitempty"`
+	FocalPoint *Point `json:"focal_point,omitempty"`
+}
+
+// Validate checks the framing fits inside the image.
+func (f Framing) Validate() error {
+	if f.Crop != nil && f.FocalPoint != nil {
+		return errors.New("crop and focal point can't both be set")
+	}
+	if c := f.Crop; c != nil {
+		if c.X < 0 || c.Y < 0 || c.Width <= 0 || c.Height <= 0 || c.X+c.Width > 1 || c.Y+c.Height > 1 {
+			return errors.New("crop must be a non-empty rectangle inside the image")
+		}
+	}
+	if p := f.FocalPoint; p != nil {
+		if p.X < 0 || p.X > 1 || p.Y < 0 || p.Y > 1 {
+			return errors.New("focal point must be inside the image")
+		}
+	}
+	return nil
+}
+
+// ParseRect reads "x,y,width,height".
+func ParseRect(s string) (Rect, error) {
+	v, err := parseFractions(s, 4)
+	if err != nil {
+		return Rect{}, err
+	}
+	return Rect{X: v[0], Y: v[1], Width: v[2], Height: v[3]}, nil
+}
+
+// ParsePoint reads "x,y".
+func ParsePoint(s string) (Point, error) {
+	v, err := parseFractions(s, 2)
+	if err != nil {
+		return Point{}, err
+	}
+	return Point{X: v[0], Y: v[1]}, nil
+}
+
+func parseFractions(s string, n int) ([]float64, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != n {
+		return nil, errors.New("wrong number of values")
+	}
+	v := make([]float64, n)
+	for i, field := range fields {
+		f, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, errors.New("values must be numbers")
+		}
+		v[i] = f
+	}
+	return v, nil
+}
+
+// Region returns the largest rectangle with the given width to height
+// ratio that the framing allows in a width x height image.
+func (f Framing) Region(width, height int, aspect float64) image.Rectangle {
+	bounds := image.Rect(0, 0, width, height)
+	if c := f.Crop; c != nil {
+		bounds = image.Rect(
+			int(math.Round(c.X*float64(width))),
+			int(math.Round(c.Y*float64(height))),
+			int(math.Round((c.X+c.Width)*float64(width))),
+			int(math.Round((c.Y+c.Height)*float64(height))),
+		)
+		// Crops thinner than a pixel still keep one.
+		bounds.Max.X = max(bounds.Max.X, bounds.Min.X+1)
+		bounds.Max.Y = max(bounds.Max.Y, bounds.Min.Y+1)
+	}
+	focusX := float64(bounds.Min.X+bounds.Max.X) / 2
+	focusY := float64(bounds.Min.Y+bounds.Max.Y) / 2
+	if p := f.FocalPoint; p != nil {
+		focusX = p.X * float64(width)
+		focusY = p.Y * float64(height)
+	}
+
+	w, h := bounds.Dx(), bounds.Dy()
+	if float64(w)/float64(h) > aspect {
+		w = max(1, int(math.Round(float64(h)*aspect)))
+	} else {
+		h = max(1, int(math.Round(float64(w)/aspect)))
+	}
+	x := clamp(int(math.Round(focusX-float64(w)/2)), bounds.Min.X, bounds.Max.X-w)
+	y := clamp(int(math.Round(focusY-float64(h)/2)), bounds.Min.Y, bounds.Max.Y-h)
+	return image.Rect(x, y, x+w, y+h)
+}
+
+func clamp(v, lo, hi int) int {
+	return max(lo, min(v, hi))
+}
@@ -0,0 +1,741 @@
+// Package media wraps the ffmpeg/ffprobe steps of the upload pipeline.
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrNoFrame is returned by Frame when there's no frame at the offset.
+var ErrNoFrame = errors.New("no frame at offset")
+
+// CorruptError is returned by Validate for files that can't be played.
+// Reason is meant for the person who uploaded the file, Detail is what
+// ffprobe said.
+type CorruptError struct {
+	Reason string
+	Detail string
+}
+
+func (e *CorruptError) Error() string {
+	if e.Detail == "" {
+		return "corrupt video: " + e.Reason
+	}
+	return fmt.Sprintf("corrupt video: %s (%s)", e.Reason, e.Detail)
+}
+
+// corruptMessages are the ffprobe errors that mean the file itself is
+// broken, with what they mean to whoever uploaded it. Others, like a
+// missing reference frame at the start of a stream, still play.
+var corruptMessages = []struct {
+	match  string
+	reason string
+}{
+	{"moov atom not found", "The file is incomplete: its index is missing, which usually means the recording or copy was cut short"},
+	{"partial file", "The file is truncated: it ends before all of its video data"},
+	{"End of file", "The file is truncated: it ends before all of its video data"},
+	{"Invalid data found when processing input", "The file isn't a video format that can be read, or it's damaged"},
+	{"error reading header", "The file's header is damaged"},
+}
+
+type Metadata struct {
+	Streams []Stream `json:"streams"`
+	Format  struct {
+		// Duration is in seconds, as ffprobe formats it.
+		Duration string `json:"duration"`
+	} `json:"format"`
+	Chapters []struct {
+		// StartTime is in seconds, as ffprobe formats it.
+		StartTime string `json:"start_time"`
+		Tags      struct {
+			Title string `json:"title"`
+		} `json:"tags"`
+	} `json:"chapters"`
+}
+
+// Chapter is a chapter marker embedded in the container.
+type Chapter struct {
+	StartSeconds float64
+	Title        string
+}
+
+// EmbeddedChapters returns the container's chapter markers in order.
+// Untitled chapters are numbered.
+func (m Metadata) EmbeddedChapters() []Chapter {
+	chapters := make([]Chapter, 0, len(m.Chapters))
+	for i, c := range m.Chapters {
+		start, err := strconv.ParseFloat(c.StartTime, 64)
+		if err != nil {
+			continue
+		}
+		title := strings.TrimSpace(c.Tags.Title)
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", i+1)
+		}
+		chapters = append(chapters, Chapter{StartSeconds: start, Title: title})
+	}
+	return chapters
+}
+
+// Stream is one stream of a file as ffprobe describes it. The colour
+// fields are only set for video streams that carry them.
+type Stream struct {
+	CodecType      string `json:"codec_type"`
+	CodecName      string `json:"codec_name"`
+	Width          int    `json:"width"`
+	Height         int    `json:"height"`
+	PixelFormat    string `json:"pix_fmt"`
+	ColorSpace     string `json:"color_space"`
+	ColorTransfer  string `json:"color_transfer"`
+	ColorPrimaries string `json:"color_primaries"`
+	// AvgFrameRate is a fraction, as ffprobe formats it, e.g. "30000/1001".
+	AvgFrameRate string `json:"avg_frame_rate"`
+}
+
+// FrameRate returns the stream's average frames per second, or 0 if
+// ffprobe didn't report one.
+func (s Stream) FrameRate() float64 {
+	num, den, ok := strings.Cut(s.AvgFrameRate, "/")
+	if !ok {
+		den = "1"
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// highBitDepthFormat matches pixel formats with more than 8 bits per
+// sample, such as yuv420p10le and p010le.
+var highBitDepthFormat = regexp.MustCompile(`(p1[0246](le|be)$|^p01[06])`)
+
+// HDR reports whether the stream uses an HDR transfer function: PQ, as in
+// HDR10 and Dolby Vision, or HLG.
+func (s Stream) HDR() bool {
+	return s.ColorTransfer == "smpte2084" || s.ColorTransfer == "arib-std-b67"
+}
+
+// HighBitDepth reports whether the stream has 10 or more bits per sample.
+func (s Stream) HighBitDepth() bool {
+	return highBitDepthFormat.MatchString(s.PixelFormat)
+}
+
+// Video returns the first video stream, or the first stream of any kind
+// when ffprobe didn't say which is which.
+func (m Metadata) Video() (Stream, bool) {
+	for _, s := range m.Streams {
+		if s.CodecType == "video" {
+			return s, true
+		}
+	}
+	if len(m.Streams) > 0 && m.Streams[0].CodecType == "" {
+		return m.Streams[0], true
+	}
+	return Stream{}, false
+}
+
+// Duration returns the container duration in seconds, or 0 if ffprobe
+// didn't report one.
+func (m Metadata) Duration() float64 {
+	d, _ := strconv.ParseFloat(m.Format.Duration, 64)
+	return d
+}
+
+// ProgressFunc is told how far an ffmpeg run has got, as the fraction of
+// the input's duration written so far.
+type ProgressFunc func(done float64)
+
+type Processor interface {
+	// FastStart remuxes the file at path so the moov atom comes first and
+	// returns the path of the new file. Streams are copied, except audio
+	// browsers can't play in MP4, which is transcoded to AAC. A file that
+	// needs neither is left alone and path itself is returned. progress, if
+	// not nil, is called as the remux moves along.
+	FastStart(ctx context.Context, path string, progress ProgressFunc) (string, error)
+	Probe(ctx context.Context, path string) (Metadata, error)
+	// Validate reads every packet of the file at path without decoding
+	// them, returning a *CorruptError if it's truncated or unreadable.
+	Validate(ctx context.Context, path string) error
+	// Frame grabs the frame at the given offset in seconds and writes it
+	// to output as a JPEG no wider than maxWidth. The input may also be a
+	// URL, which ffmpeg seeks in with range requests.
+	Frame(ctx context.Context, input string, seconds float64, maxWidth int, output string) error
+	// Crop cuts rect out of the image at input, scales it to width x
+	// height and writes it to output as a JPEG.
+	Crop(ctx context.Context, input string, rect image.Rectangle, width, height int, output string) error
+	// ExtractAudio writes the audio track as a 16 kHz mono WAV file, the
+	// format speech recognition expects, and returns its path.
+	ExtractAudio(ctx context.Context, path string) (string, error)
+	// Transcode encodes the video at input as an MP4 with the given
+	// options and writes it to output.
+	Transcode(ctx context.Context, input string, opts TranscodeOptions, output string, progress ProgressFunc) error
+	// Watermark overlays the image at its own size in the bottom right
+	// corner of the video at input and writes the result to output as an
+	// MP4. The video is re-encoded as 8-bit H.264, the audio is copied.
+	Watermark(ctx context.Context, input, image, output string, progress ProgressFunc) error
+}
+
+// TranscodeOptions describes one encoding of a video. Codecs use the
+// preset names: h264, h265, vp9 or av1 for video and aac or opus for
+// audio.
+type TranscodeOptions struct {
+	Width            int
+	Height           int
+	VideoCodec       string
+	AudioCodec       string
+	VideoBitrateKbps int
+	AudioBitrateKbps int
+	// ToneMap maps an HDR source down to SDR BT.709, so it doesn't come
+	// out washed out. HDR instead keeps it HDR: 10-bit BT.2020 with the
+	// given transfer function, which needs h265, vp9 or av1.
+	ToneMap       bool
+	HDR           bool
+	ColorTransfer string
+	// FrameRate, when set, resamples the video to that many frames per
+	// second, dropping or repeating frames.
+	FrameRate int
+}
+
+// toneMapFilter linearises the picture, tone maps it with Hable's curve
+// and converts it to BT.709. It needs ffmpeg built with zimg.
+const toneMapFilter = "zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709,tonemap=tonemap=hable:desat=0,zscale=t=bt709:m=bt709:r=tv,format=yuv420p"
+
+var (
+	videoEncoders = map[string]string{"h264": "libx264", "h265": "libx265", "vp9": "libvpx-vp9", "av1": "libaom-av1"}
+	audioEncoders = map[string]string{"aac": "aac", "opus": "libopus"}
+)
+
+// browserAudioCodecs are the audio codecs every major browser plays in
+// MP4. PCM, FLAC, Opus and the rest copy into the container fine but fail
+// to play in some of them.
+var browserAudioCodecs = map[string]bool{"aac": true, "mp3": true}
+
+// fastStartAudioBitrate is what audio that has to be transcoded is
+// encoded at.
+const fastStartAudioBitrate = "192k"
+
+type FFmpeg struct{}
+
+func (f FFmpeg) FastStart(ctx context.Context, path string, progress ProgressFunc) (string, error) {
+	// Progress is reported against the input's duration, so without one
+	// there's nothing to report. If the probe fails, the streams are
+	// copied as they are.
+	var duration float64
+	transcodeAudio := false
+	metadata, err := f.Probe(ctx, path)
+	if err == nil {
+		if progress != nil {
+			duration = metadata.Duration()
+		}
+		for _, s := range metadata.Streams {
+			if s.CodecType == "audio" && !browserAudioCodecs[s.CodecName] {
+				transcodeAudio = true
+			}
+		}
+		// Remuxing reads and writes the whole file, which for an upload
+		// that's already fast start gains nothing.
+		if !transcodeAudio {
+			if ok, _ := IsFastStart(path); ok {
+				if progress != nil {
+					progress(1)
+				}
+				return path, nil
+			}
+		}
+	}
+
+	outputFilePath := path + ".processing"
+	var args []string
+	if duration > 0 {
+		args = append(args, "-progress", "pipe:1", "-nostats")
+	}
+	args = append(args,
+		"-i",
+		path,
+		"-c",
+		"copy",
+	)
+	if transcodeAudio {
+		args = append(args, "-c:a", "aac", "-b:a", fastStartAudioBitrate)
+	}
+	args = append(args,
+		"-movflags",
+		"faststart",
+		"-f",
+		"mp4",
+		outputFilePath,
+	)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err = runWithProgress(cmd, duration, progress)
+	if err != nil {
+		return "", ffmpegError(err, stderr.Bytes())
+	}
+
+	return outputFilePath, nil
+}
+
+// runWithProgress runs cmd, which was given -progress pipe:1 when duration
+// is known, and feeds what it reports on stdout to progress.
+func runWithProgress(cmd *exec.Cmd, duration float64, progress ProgressFunc) error {
+	if duration <= 0 || progress == nil {
+		return cmd.Run()
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	readProgress(stdout, duration, progress)
+	return cmd.Wait()
+}
+
+// readProgress parses ffmpeg's -progress output: blocks of key=value
+// lines, each ending with progress=continue, or progress=end for the last
+// one. It reads r to the end so ffmpeg never blocks writing to it.
+func readProgress(r io.Reader, duration float64, progress ProgressFunc) {
+	var outTime float64
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "out_time_us":
+			// It's N/A until the first packet is written.
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+				outTime = float64(us) / 1e6
+			}
+		case "progress":
+			if value == "end" {
+				progress(1)
+				continue
+			}
+			progress(min(outTime/duration, 1))
+		}
+	}
+	io.Copy(io.Discard, r)
+}
+
+func (FFmpeg) Probe(ctx context.Context, path string) (Metadata, error) {
+	cmd := exec.CommandContext(
+		ctx,
+		"ffprobe",
+		"-v",
+		"error",
+		"-print_format",
+		"json",
+		"-show_streams",
+		"-show_format",
+		"-show_chapters",
+		path,
+	)
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+
+	if err := cmd.Run(); err != nil {
+		return Metadata{}, fmt.Errorf("ffprobe: %w", err)
+	}
+
+	metadata := Metadata{}
+	if err := json.Unmarshal(out.Bytes(), &metadata); err != nil {
+		return Metadata{}, err
+	}
+	return metadata, nil
+}
+
+func (FFmpeg) Validate(ctx context.Context, path string) error {
+	cmd := exec.CommandContext(
+		ctx,
+		"ffprobe",
+		"-v",
+		"error",
+		"-count_packets",
+		"-print_format",
+		"json",
+		"-show_entries",
+		"stream=codec_type,nb_read_packets",
+		path,
+	)
+
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	runErr := cmd.Run()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		for _, m := range corruptMessages {
+			if strings.Contains(line, m.match) {
+				return &CorruptError{Reason: m.reason, Detail: strings.TrimSpace(line)}
+			}
+		}
+	}
+	if runErr != nil {
+		return &CorruptError{Reason: "The file couldn't be read as a video", Detail: lastLine(stderr.Bytes())}
+	}
+
+	var result struct {
+		Streams []struct {
+			CodecType     string `json:"codec_type"`
+			NbReadPackets string `json:"nb_read_packets"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		return fmt.Errorf("ffprobe: %w", err)
+	}
+	for _, s := range result.Streams {
+		if s.CodecType == "video" && s.NbReadPackets != "" && s.NbReadPackets != "0" {
+			return nil
+		}
+	}
+	return &CorruptError{Reason: "The file has no video frames"}
+}
+
+func (FFmpeg) Frame(ctx context.Context, input string, seconds float64, maxWidth int, output string) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y",
+		"-ss",
+		strconv.FormatFloat(seconds, 'f', 3, 64),
+		"-i",
+		input,
+		"-frames:v",
+		"1",
+		"-vf",
+		fmt.Sprintf("scale='min(%d,iw)':-2", maxWidth),
+		"-q:v",
+		"3",
+		output,
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return ffmpegError(err, stderr.Bytes())
+	}
+
+	// Seeking past the last frame succeeds without writing anything.
+	info, err := os.Stat(output)
+	if err != nil || info.Size() == 0 {
+		os.Remove(output)
+		return ErrNoFrame
+	}
+	return nil
+}
+
+func (FFmpeg) Crop(ctx context.Context, input string, rect image.Rectangle, width, height int, output string) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y",
+		"-i",
+		input,
+		"-vf",
+		fmt.Sprintf("crop=%d:%d:%d:%d,scale=%d:%d", rect.Dx(), rect.Dy(), rect.Min.X, rect.Min.Y, width, height),
+		"-frames:v",
+		"1",
+		"-q:v",
+		"3",
+		output,
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return ffmpegError(err, stderr.Bytes())
+	}
+	return nil
+}
+
+func (f FFmpeg) Transcode(ctx context.Context, input string, opts TranscodeOptions, output string, progress ProgressFunc) error {
+	videoEncoder, ok := videoEncoders[opts.VideoCodec]
+	if !ok {
+		return fmt.Errorf("unknown video codec %q", opts.VideoCodec)
+	}
+	audioEncoder, ok := audioEncoders[opts.AudioCodec]
+	if !ok {
+		return fmt.Errorf("unknown audio codec %q", opts.AudioCodec)
+	}
+
+	var duration float64
+	if progress != nil {
+		if metadata, err := f.Probe(ctx, input); err == nil {
+			duration = metadata.Duration()
+		}
+	}
+
+	args := []string{"-y"}
+	if duration > 0 {
+		args = append(args, "-progress", "pipe:1", "-nostats")
+	}
+	args = append(args,
+		"-i",
+		input,
+		"-map",
+		"0:v:0",
+		"-map",
+		"0:a:0?",
+	)
+	filter := fmt.Sprintf("scale=%d:%d", opts.Width, opts.Height)
+	if opts.FrameRate > 0 {
+		filter += fmt.Sprintf(",fps=%d", opts.FrameRate)
+	}
+	switch {
+	case opts.HDR:
+		if opts.VideoCodec == "h264" {
+			return errors.New("HDR needs h265, vp9 or av1")
+		}
+		args = append(args,
+			"-vf", filter,
+			"-pix_fmt", "yuv420p10le",
+			"-color_primaries", "bt2020",
+			"-color_trc", opts.ColorTransfer,
+			"-colorspace", "bt2020nc",
+		)
+	case opts.ToneMap:
+		args = append(args, "-vf", filter+","+toneMapFilter)
+	default:
+		args = append(args, "-vf", filter, "-pix_fmt", "yuv420p")
+	}
+	args = append(args,
+		"-c:v",
+		videoEncoder,
+		"-b:v",
+		fmt.Sprintf("%dk", opts.VideoBitrateKbps),
+	)
+	// Apple players only take HEVC in MP4 tagged as hvc1.
+	if opts.VideoCodec == "h265" {
+		args = append(args, "-tag:v", "hvc1")
+	}
+	args = append(args,
+		"-c:a",
+		audioEncoder,
+		"-b:a",
+		fmt.Sprintf("%dk", opts.AudioBitrateKbps),
+		"-movflags",
+		"faststart",
+		"-f",
+		"mp4",
+		output,
+	)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := runWithProgress(cmd, duration, progress); err != nil {
+		return ffmpegError(err, stderr.Bytes())
+	}
+	return nil
+}
+
+// watermarkMargin is the gap in pixels between a watermark and the edges
+// of the video.
+const watermarkMargin = 16
+
+func (f FFmpeg) Watermark(ctx context.Context, input, image, output string, progress ProgressFunc) error {
+	var duration float64
+	if progress != nil {
+		if metadata, err := f.Probe(ctx, input); err == nil {
+			duration = metadata.Duration()
+		}
+	}
+
+	args := []string{"-y"}
+	if duration > 0 {
+		args = append(args, "-progress", "pipe:1", "-nostats")
+	}
+	args = append(args,
+		"-i",
+		input,
+		"-i",
+		image,
+		"-filter_complex",
+		fmt.Sprintf("[0:v:0][1:v]overlay=W-w-%d:H-h-%d,format=yuv420p[v]", watermarkMargin, watermarkMargin),
+		"-map",
+		"[v]",
+		"-map",
+		"0:a:0?",
+		"-c:v",
+		"libx264",
+		"-crf",
+		"18",
+		"-c:a",
+		"copy",
+		"-movflags",
+		"faststart",
+		"-f",
+		"mp4",
+		output,
+	)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := runWithProgress(cmd, duration, progress); err != nil {
+		return ffmpegError(err, stderr.Bytes())
+	}
+	return nil
+}
+
+func (FFmpeg) ExtractAudio(ctx context.Context, path string) (string, error) {
+	outputFilePath := path + ".wav"
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y",
+		"-i",
+		path,
+		"-vn",
+		"-ac",
+		"1",
+		"-ar",
+		"16000",
+		"-c:a",
+		"pcm_s16le",
+		outputFilePath,
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", ffmpegError(err, stderr.Bytes())
+	}
+
+	return outputFilePath, nil
+}
+
+// Frame is an image grabbed from a video.
+type Frame struct {
+	Path    string
+	Seconds float64
+}
+
+// SampleFrames grabs n frames spread evenly over the video, skipping the
+// very start and end where there are often black frames. The caller
+// removes the returned files.
+func SampleFrames(ctx context.Context, p Processor, path string, n, maxWidth int) ([]Frame, error) {
+	metadata, err := p.Probe(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	duration := metadata.Duration()
+	if duration <= 0 {
+		return nil, errors.New("couldn't determine duration")
+	}
+
+	frames := make([]Frame, 0, n)
+	for i := range n {
+		seconds := duration * (float64(i) + 0.5) / float64(n)
+		frame := fmt.Sprintf("%s.%d.jpg", path, int64(seconds*1000))
+		if err := p.Frame(ctx, path, seconds, maxWidth, frame); err != nil {
+			for _, f := range frames {
+				os.Remove(f.Path)
+			}
+			return nil, err
+		}
+		frames = append(frames, Frame{Path: frame, Seconds: seconds})
+	}
+	return frames, nil
+}
+
+// AspectRatioCategory probes the file and sorts it into the storage prefix
+// used for its key: "landscape", "portrait" or "other".
+func AspectRatioCategory(ctx context.Context, p Processor, path string) (string, error) {
+	metadata, err := p.Probe(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	if len(metadata.Streams) == 0 {
+		return "", errors.New("no streams found")
+	}
+	stream, _ := metadata.Video()
+
+	switch AspectRatio(stream.Width, stream.Height, 0.01) {
+	case "16:9":
+		return "landscape", nil
+	case "9:16":
+		return "portrait", nil
+	default:
+		return "other", nil
+	}
+}
+
+func AspectRatio(width, height int, tolerance float64) string {
+	sixteenNineRatio := 16.0 / 9.0
+	nineSixteenRatio := 9.0 / 16.0
+
+	if height == 0 {
+		return "other"
+	}
+	ratio := float64(width) / float64(height)
+
+	if math.Abs(ratio-sixteenNineRatio) <= tolerance {
+		return "16:9"
+	}
+	if math.Abs(ratio-nineSixteenRatio) <= tolerance {
+		return "9:16"
+	}
+	return "other"
+}
+
+// FFmpegError is returned when an ffmpeg run fails. Stderr is the end of
+// what it wrote, which usually says why.
+type FFmpegError struct {
+	Err    error
+	Stderr string
+}
+
+func (e *FFmpegError) Error() string {
+	if msg := lastLine([]byte(e.Stderr)); msg != "" {
+		return fmt.Sprintf("ffmpeg: %v: %s", e.Err, msg)
+	}
+	return fmt.Sprintf("ffmpeg: %v", e.Err)
+}
+
+func (e *FFmpegError) Unwrap() error { return e.Err }
+
+// stderrExcerptLines is how much of ffmpeg's stderr an FFmpegError keeps.
+const stderrExcerptLines = 20
+
+func ffmpegError(err error, stderr []byte) error {
+	lines := bytes.Split(bytes.TrimSpace(stderr), []byte("\n"))
+	if len(lines) > stderrExcerptLines {
+		lines = lines[len(lines)-stderrExcerptLines:]
+	}
+	return &FFmpegError{Err: err, Stderr: string(bytes.Join(lines, []byte("\n")))}
+}
+
+func lastLine(b []byte) string {
+	b = bytes.TrimSpace(b)
+	if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+		return string(b[i+1:])
+	}
+	return string(b)
+}
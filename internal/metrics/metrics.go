@@ -0,0 +1,197 @@
+// Package metrics keeps in-process histograms and counters and renders
+// them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DurationBuckets are upper bounds in seconds suited to media processing,
+// which ranges from sub-second probes to multi-minute encodes.
+var DurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+type Collector interface {
+	Write(w io.Writer) error
+}
+
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// Default is the registry the server exposes.
+var Default = &Registry{}
+
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, c := range collectors {
+		if err := c.Write(w); err != nil {
+			return
+		}
+	}
+}
+
+type series struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec is a histogram partitioned by a single label. An empty label
+// name makes it a plain histogram; observe it with an empty label value.
+type HistogramVec struct {
+	name    string
+	help    string
+	label   string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// NewHistogramVec creates a histogram and registers it with Default.
+func NewHistogramVec(name, help, label string, buckets []float64) *HistogramVec {
+	h := &HistogramVec{
+		name:    name,
+		help:    help,
+		label:   label,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	Default.Register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(labelValue string, v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[labelValue]
+	if !ok {
+		s = &series{counts: make([]uint64, len(h.buckets))}
+		h.series[labelValue] = s
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *HistogramVec) Write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP %s %s\n", h.name, h.help)
+	fmt.Fprintf(&b, "# TYPE %s histogram\n", h.name)
+
+	values := make([]string, 0, len(h.series))
+	for v := range h.series {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+
+	for _, v := range values {
+		s := h.series[v]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(&b, "%s_bucket{%s} %d\n", h.name, h.labels(v, formatFloat(upper)), s.counts[i])
+		}
+		fmt.Fprintf(&b, "%s_bucket{%s} %d\n", h.name, h.labels(v, "+Inf"), s.count)
+		fmt.Fprintf(&b, "%s_sum%s %s\n", h.name, h.braces(v), formatFloat(s.sum))
+		fmt.Fprintf(&b, "%s_count%s %d\n", h.name, h.braces(v), s.count)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (h *HistogramVec) labels(value, le string) string {
+	le = fmt.Sprintf("le=%q", le)
+	if h.label == "" {
+		return le
+	}
+	return fmt.Sprintf("%s=%q,%s", h.label, value, le)
+}
+
+func (h *HistogramVec) braces(value string) string {
+	if h.label == "" {
+		return ""
+	}
+	return fmt.Sprintf("{%s=%q}", h.label, value)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// CounterVec is a counter partitioned by a single label.
+type CounterVec struct {
+	name  string
+	help  string
+	label string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec creates a counter and registers it with Default.
+func NewCounterVec(name, help, label string) *CounterVec {
+	c := &CounterVec{
+		name:   name,
+		help:   help,
+		label:  label,
+		values: map[string]float64{},
+	}
+	Default.Register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValue string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[labelValue]++
+}
+
+func (c *CounterVec) Write(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP %s %s\n", c.name, c.help)
+	fmt.Fprintf(&b, "# TYPE %s counter\n", c.name)
+
+	values := make([]string, 0, len(c.values))
+	for v := range c.values {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	for _, v := range values {
+		fmt.Fprintf(&b, "%s{%s=%q} %s\n", c.name, c.label, v, formatFloat(c.values[v]))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,85 @@
+// Package moderation submits images to a content moderation provider and
+// returns the labels it detects.
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/awsjson"
+)
+
+// Label is something the provider found in an image, such as
+// "Explicit Nudity" or "Graphic Violence", with its confidence in percent.
+type Label struct {
+	Name       string  `json:"name"`
+	ParentName string  `json:"parent_name,omitempty"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Category is the top level label, which is what policies are written
+// against.
+func (l Label) Category() string {
+	if l.ParentName != "" {
+		return l.ParentName
+	}
+	return l.Name
+}
+
+type Moderator interface {
+	// Name identifies the provider in stored results.
+	Name() string
+	// ModerateImage returns the labels detected in a JPEG or PNG image.
+	ModerateImage(ctx context.Context, image []byte) ([]Label, error)
+}
+
+// Rekognition calls AWS Rekognition's DetectModerationLabels through
+// awsjson, so the SDK's Rekognition client isn't needed.
+type Rekognition struct {
+	Config aws.Config
+	Client *http.Client
+	// MinConfidence leaves out labels the service is less sure of.
+	MinConfidence float64
+}
+
+// MaxImageSize is the largest image Rekognition accepts inline.
+const MaxImageSize = 5 << 20
+
+func (r *Rekognition) Name() string {
+	return "rekognition"
+}
+
+func (r *Rekognition) ModerateImage(ctx context.Context, image []byte) ([]Label, error) {
+	if len(image) > MaxImageSize {
+		return nil, fmt.Errorf("image is %d bytes, over the %d byte limit", len(image), MaxImageSize)
+	}
+
+	type request struct {
+		Image struct {
+			Bytes []byte
+		}
+		MinConfidence float64 `json:",omitempty"`
+	}
+	req := request{MinConfidence: r.MinConfidence}
+	req.Image.Bytes = image
+
+	var out struct {
+		ModerationLabels []struct {
+			Name       string
+			ParentName string
+			Confidence float64
+		}
+	}
+	err := awsjson.Call(ctx, r.Client, r.Config, awsjson.Rekognition, "RekognitionService.DetectModerationLabels", req, &out)
+	if err != nil {
+		return nil, fmt.Errorf("rekognition: %w", err)
+	}
+
+	labels := make([]Label, 0, len(out.ModerationLabels))
+	for _, l := range out.ModerationLabels {
+		labels = append(labels, Label{Name: l.Name, ParentName: l.ParentName, Confidence: l.Confidence})
+	}
+	return labels, nil
+}
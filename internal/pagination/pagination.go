@@ -0,0 +1,140 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 200
+
+	NextCursorHeader = "X-Next-Cursor"
+	PrevCursorHeader = "X-Prev-Cursor"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type Direction string
+
+const (
+	Next Direction = "next"
+	Prev Direction = "prev"
+)
+
+// Cursor marks a position in a list ordered by (created_at, id) descending.
+// Clients only ever see it in its encoded, opaque form.
+type Cursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"id"`
+	Direction Direction `json:"d"`
+}
+
+func (c Cursor) Encode() string {
+	dat, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(dat)
+}
+
+func DecodeCursor(s string) (Cursor, error) {
+	dat, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(dat, &c); err != nil || c.ID == "" {
+		return Cursor{}, ErrInvalidCursor
+	}
+	if c.Direction != Next && c.Direction != Prev {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+type Params struct {
+	Limit  int
+	Cursor *Cursor
+}
+
+// Backward reports whether the page is being fetched towards newer items.
+func (p Params) Backward() bool {
+	return p.Cursor != nil && p.Cursor.Direction == Prev
+}
+
+// ParseRequest reads the limit and cursor query parameters, clamping the
+// limit to [1, MaxLimit].
+func ParseRequest(r *http.Request) (Params, error) {
+	params := Params{Limit: DefaultLimit}
+
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil {
+			return Params{}, errors.New("limit must be an integer")
+		}
+		params.Limit = max(1, min(limit, MaxLimit))
+	}
+
+	if raw := r.URL.Query().Get("cursor"); raw != "" {
+		c, err := DecodeCursor(raw)
+		if err != nil {
+			return Params{}, err
+		}
+		params.Cursor = &c
+	}
+
+	return params, nil
+}
+
+type Page struct {
+	NextCursor string
+	PrevCursor string
+}
+
+// Paginate trims a result set fetched with Limit+1 rows down to Limit,
+// restores newest-first order for backward pages and works out the
+// cursors for the neighbouring pages.
+func Paginate[T any](items []T, params Params, cursorOf func(T) Cursor) ([]T, Page) {
+	hasMore := len(items) > params.Limit
+	if hasMore {
+		items = items[:params.Limit]
+	}
+	if params.Backward() {
+		slices.Reverse(items)
+	}
+
+	page := Page{}
+	if len(items) == 0 {
+		return items, page
+	}
+
+	hasNext := hasMore
+	hasPrev := params.Cursor != nil
+	if params.Backward() {
+		hasNext = true
+		hasPrev = hasMore
+	}
+	if hasNext {
+		c := cursorOf(items[len(items)-1])
+		c.Direction = Next
+		page.NextCursor = c.Encode()
+	}
+	if hasPrev {
+		c := cursorOf(items[0])
+		c.Direction = Prev
+		page.PrevCursor = c.Encode()
+	}
+	return items, page
+}
+
+func WriteHeaders(w http.ResponseWriter, page Page) {
+	if page.NextCursor != "" {
+		w.Header().Set(NextCursorHeader, page.NextCursor)
+	}
+	if page.PrevCursor != "" {
+		w.Header().Set(PrevCursorHeader, page.PrevCursor)
+	}
+}
@@ -0,0 +1,188 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+type TaskFunc func(ctx context.Context) error
+
+type Status struct {
+	Name         string     `json:"name"`
+	Interval     string     `json:"interval"`
+	Running      bool       `json:"running"`
+	RunCount     int        `json:"run_count"`
+	LastRunAt    *time.Time `json:"last_run_at"`
+	LastDuration string     `json:"last_duration"`
+	LastError    *string    `json:"last_error"`
+	NextRunAt    *time.Time `json:"next_run_at"`
+	// Local tasks run on every instance. The others only run on the
+	// leader, and Active is whether this instance is it.
+	Local  bool `json:"local"`
+	Active bool `json:"active"`
+}
+
+type task struct {
+	name     string
+	interval time.Duration
+	fn       TaskFunc
+	local    bool
+	trigger  chan struct{}
+	status   Status
+}
+
+// ErrNotLeader is returned when a task that only runs on the leader is
+// asked to run on another instance.
+var ErrNotLeader = errors.New("task only runs on the leader instance")
+
+type Scheduler struct {
+	// OnError, if set, is called with each error a task returns. It's
+	// meant to be set before Start.
+	OnError func(task string, err error)
+	// IsLeader, if set, reports whether this instance is the one that
+	// runs the tasks that aren't local. Without it every instance runs
+	// them.
+	IsLeader func() bool
+
+	mu    sync.Mutex
+	tasks []*task
+}
+
+func New() *Scheduler {
+	return &Scheduler{}
+}
+
+// Register adds a task that runs every interval once the scheduler is
+// started, on the leader only. Tasks with a non-positive interval are
+// treated as disabled.
+func (s *Scheduler) Register(name string, interval time.Duration, fn TaskFunc) {
+	s.register(name, interval, fn, false)
+}
+
+// RegisterLocal adds a task that runs on every instance, for work on
+// what each instance keeps to itself, such as its temp files or caches.
+func (s *Scheduler) RegisterLocal(name string, interval time.Duration, fn TaskFunc) {
+	s.register(name, interval, fn, true)
+}
+
+func (s *Scheduler) register(name string, interval time.Duration, fn TaskFunc, local bool) {
+	if interval <= 0 {
+		log.Printf("scheduler: task %s is disabled", name)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks = append(s.tasks, &task{
+		name:     name,
+		interval: interval,
+		fn:       fn,
+		local:    local,
+		trigger:  make(chan struct{}, 1),
+		status: Status{
+			Name:     name,
+			Interval: interval.String(),
+			Local:    local,
+		},
+	})
+}
+
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tasks {
+		go s.loop(ctx, t)
+	}
+}
+
+// RunNow queues an immediate run of the named task. A run that is already
+// queued is not queued twice.
+func (s *Scheduler) RunNow(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tasks {
+		if t.name == name {
+			if !s.active(t) {
+				return ErrNotLeader
+			}
+			select {
+			case t.trigger <- struct{}{}:
+			default:
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown task %q", name)
+}
+
+func (s *Scheduler) Statuses() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]Status, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		status := t.status
+		status.Active = s.active(t)
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// active reports whether the task runs on this instance right now.
+func (s *Scheduler) active(t *task) bool {
+	return t.local || s.IsLeader == nil || s.IsLeader()
+}
+
+func (s *Scheduler) loop(ctx context.Context, t *task) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	s.setNextRun(t, time.Now().UTC().Add(t.interval))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-t.trigger:
+		}
+		// Leadership can move between ticks, so it's checked on every
+		// one. A run already going when it's lost is left to finish.
+		if s.active(t) {
+			s.run(ctx, t)
+		}
+		s.setNextRun(t, time.Now().UTC().Add(t.interval))
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, t *task) {
+	start := time.Now().UTC()
+	s.mu.Lock()
+	t.status.Running = true
+	s.mu.Unlock()
+
+	err := t.fn(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t.status.Running = false
+	t.status.RunCount++
+	t.status.LastRunAt = &start
+	t.status.LastDuration = time.Since(start).String()
+	t.status.LastError = nil
+	if err != nil {
+		msg := err.Error()
+		t.status.LastError = &msg
+		log.Printf("scheduler: task %s failed: %v", t.name, err)
+		if s.OnError != nil {
+			s.OnError(t.name, err)
+		}
+	}
+}
+
+func (s *Scheduler) setNextRun(t *task, next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t.status.NextRunAt = &next
+}
@@ -0,0 +1,144 @@
+// Package secrets fetches settings from AWS Secrets Manager or SSM
+// Parameter Store, so they don't have to be kept in plaintext environment
+// variables.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/awsjson"
+)
+
+// Fetcher resolves references to secrets. A reference is either
+//
+//	secretsmanager:<secret name or ARN>[#<key>]
+//	ssm:<parameter name>
+//
+// where key picks one field of a secret stored as a JSON object. SSM
+// SecureString parameters are decrypted.
+type Fetcher struct {
+	Config aws.Config
+	Client *http.Client
+}
+
+// ValidRef reports whether ref names a source Fetch knows.
+func ValidRef(ref string) bool {
+	source, name, ok := strings.Cut(ref, ":")
+	return ok && name != "" && (source == "secretsmanager" || source == "ssm")
+}
+
+func (f Fetcher) Fetch(ctx context.Context, ref string) (string, error) {
+	source, name, _ := strings.Cut(ref, ":")
+	switch {
+	case name == "":
+	case source == "secretsmanager":
+		return f.secret(ctx, name)
+	case source == "ssm":
+		return f.parameter(ctx, name)
+	}
+	return "", fmt.Errorf("secrets: %q isn't a secretsmanager: or ssm: reference", ref)
+}
+
+func (f Fetcher) secret(ctx context.Context, name string) (string, error) {
+	id, key, hasKey := strings.Cut(name, "#")
+	var out struct {
+		SecretString string
+		SecretBinary []byte
+	}
+	err := awsjson.Call(ctx, f.Client, f.Config, awsjson.SecretsManager, "secretsmanager.GetSecretValue", map[string]string{"SecretId": id}, &out)
+	if err != nil {
+		return "", fmt.Errorf("secrets: couldn't get secret %s: %w", id, err)
+	}
+	value := out.SecretString
+	if value == "" {
+		value = string(out.SecretBinary)
+	}
+	if !hasKey {
+		return value, nil
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("secrets: secret %s isn't a JSON object: %w", id, err)
+	}
+	field, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("secrets: secret %s has no %q key", id, key)
+	}
+	if s, ok := field.(string); ok {
+		return s, nil
+	}
+	// Numbers and the like are returned as they're written.
+	dat, err := json.Marshal(field)
+	return string(dat), err
+}
+
+func (f Fetcher) parameter(ctx context.Context, name string) (string, error) {
+	in := map[string]any{"Name": name, "WithDecryption": true}
+	var out struct {
+		Parameter struct {
+			Value string
+		}
+	}
+	if err := awsjson.Call(ctx, f.Client, f.Config, awsjson.SSM, "AmazonSSM.GetParameter", in, &out); err != nil {
+		return "", fmt.Errorf("secrets: couldn't get parameter %s: %w", name, err)
+	}
+	return out.Parameter.Value, nil
+}
+
+// Value holds a secret that can be replaced while the server runs. After
+// Rotate, the value it replaced stays available from Previous for a
+// while, so what was signed with it can still be checked.
+type Value struct {
+	v atomic.Pointer[string]
+
+	mu            sync.Mutex
+	previous      string
+	previousUntil time.Time
+}
+
+func NewValue(s string) *Value {
+	v := &Value{}
+	v.Set(s)
+	return v
+}
+
+func (v *Value) Get() string {
+	if s := v.v.Load(); s != nil {
+		return *s
+	}
+	return ""
+}
+
+func (v *Value) Set(s string) {
+	v.v.Store(&s)
+}
+
+// Rotate replaces the value, keeping the one it replaces as Previous for
+// grace.
+func (v *Value) Rotate(s string, grace time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.previous = v.Get()
+	v.previousUntil = time.Now().Add(grace)
+	v.Set(s)
+}
+
+// Previous returns the value the last Rotate replaced, or "" once its
+// grace period is over.
+func (v *Value) Previous() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if time.Now().After(v.previousUntil) {
+		return ""
+	}
+	return v.previous
+}
@@ -0,0 +1,114 @@
+// Package sitemap writes sitemaps in the sitemaps.org format, using
+// Google's video extension for the pages that show a video.
+package sitemap
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+// MaxURLs is the most URLs one sitemap file may list.
+const MaxURLs = 50000
+
+// maxDescription is the longest video description the extension allows.
+const maxDescription = 2048
+
+type URL struct {
+	Loc     string
+	LastMod time.Time
+	// Video describes the video shown on the page, if there is one.
+	Video *Video
+}
+
+type Video struct {
+	ThumbnailLoc string
+	Title        string
+	Description  string
+	// ContentLoc is the URL of the media file itself.
+	ContentLoc      string
+	PlayerLoc       string
+	Duration        time.Duration
+	PublicationDate time.Time
+}
+
+type urlSet struct {
+	XMLName    xml.Name `xml:"urlset"`
+	Xmlns      string   `xml:"xmlns,attr"`
+	XmlnsVideo string   `xml:"xmlns:video,attr"`
+	URLs       []urlEntry
+}
+
+type urlEntry struct {
+	XMLName xml.Name    `xml:"url"`
+	Loc     string      `xml:"loc"`
+	LastMod string      `xml:"lastmod,omitempty"`
+	Video   *videoEntry `xml:"video:video,omitempty"`
+}
+
+type videoEntry struct {
+	ThumbnailLoc    string `xml:"video:thumbnail_loc"`
+	Title           string `xml:"video:title"`
+	Description     string `xml:"video:description"`
+	ContentLoc      string `xml:"video:content_loc,omitempty"`
+	PlayerLoc       string `xml:"video:player_loc,omitempty"`
+	Duration        int    `xml:"video:duration,omitempty"`
+	PublicationDate string `xml:"video:publication_date,omitempty"`
+}
+
+// Encode writes urls as one sitemap. Only the first MaxURLs are written,
+// and a video without a thumbnail or a title is left off its page's
+// entry, since the extension requires both.
+func Encode(w io.Writer, urls []URL) error {
+	if len(urls) > MaxURLs {
+		urls = urls[:MaxURLs]
+	}
+	set := urlSet{
+		Xmlns:      "http://www.sitemaps.org/schemas/sitemap/0.9",
+		XmlnsVideo: "http://www.google.com/schemas/sitemap-video/1.1",
+		URLs:       make([]urlEntry, 0, len(urls)),
+	}
+	for _, u := range urls {
+		entry := urlEntry{Loc: u.Loc}
+		if !u.LastMod.IsZero() {
+			entry.LastMod = u.LastMod.UTC().Format(time.RFC3339)
+		}
+		if v := u.Video; v != nil && v.ThumbnailLoc != "" && v.Title != "" {
+			entry.Video = &videoEntry{
+				ThumbnailLoc: v.ThumbnailLoc,
+				Title:        v.Title,
+				Description:  truncate(v.Description, maxDescription),
+				ContentLoc:   v.ContentLoc,
+				PlayerLoc:    v.PlayerLoc,
+				Duration:     int(v.Duration.Round(time.Second).Seconds()),
+			}
+			if entry.Video.Description == "" {
+				entry.Video.Description = v.Title
+			}
+			if !v.PublicationDate.IsZero() {
+				entry.Video.PublicationDate = v.PublicationDate.UTC().Format(time.RFC3339)
+			}
+		}
+		set.URLs = append(set.URLs, entry)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(set); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// truncate cuts s to at most n runes.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
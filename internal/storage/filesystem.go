@@ -0,0 +1,404 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FilesystemStore keeps objects as plain files below a root directory,
+// using the key as the relative path.
+type FilesystemStore struct {
+	root    string
+	baseURL string
+}
+
+// NewFilesystem returns a store rooted at dir, creating it if needed.
+// Objects are served by the store itself under baseURL.
+func NewFilesystem(dir, baseURL string) (*FilesystemStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FilesystemStore{
+		root:    dir,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}, nil
+}
+
+func (s *FilesystemStore) path(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) || strings.HasPrefix(key, ".") {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes to a temporary file next to the destination and renames it
+// into place, so readers never see a partially written object.
+func (s *FilesystemStore) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (ObjectInfo, error) {
+	dst, err := s.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return ObjectInfo{}, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".put-*")
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := newObjectHasher()
+	if _, err := io.Copy(io.MultiWriter(tmp, hasher), body); err != nil {
+		tmp.Close()
+		return ObjectInfo{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return ObjectInfo{}, err
+	}
+	if err := verifyMD5(opts.ContentMD5, hasher.md5.Sum(nil)); err != nil {
+		return ObjectInfo{}, err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return ObjectInfo{}, err
+	}
+	return hasher.info(), nil
+}
+
+// Stat hashes the whole file, as nothing about an object is stored beside
+// it.
+func (s *FilesystemStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ObjectInfo{}, ErrNotFound
+		}
+		return ObjectInfo{}, err
+	}
+	defer f.Close()
+
+	hasher := newObjectHasher()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return ObjectInfo{}, err
+	}
+	return hasher.info(), nil
+}
+
+func (s *FilesystemStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// objectHasher works out an object's ObjectInfo from its content as it's
+// written.
+type objectHasher struct {
+	size   int64
+	md5    hash.Hash
+	sha256 hash.Hash
+}
+
+func newObjectHasher() *objectHasher {
+	return &objectHasher{md5: md5.New(), sha256: sha256.New()}
+}
+
+func (h *objectHasher) Write(p []byte) (int, error) {
+	h.md5.Write(p)
+	h.sha256.Write(p)
+	h.size += int64(len(p))
+	return len(p), nil
+}
+
+func (h *objectHasher) info() ObjectInfo {
+	return ObjectInfo{
+		Size:           h.size,
+		ETag:           `"` + hex.EncodeToString(h.md5.Sum(nil)) + `"`,
+		ChecksumSHA256: base64.StdEncoding.EncodeToString(h.sha256.Sum(nil)),
+	}
+}
+
+func (s *FilesystemStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *FilesystemStore) Copy(ctx context.Context, srcKey, dstKey string) error {
+	src, err := s.path(srcKey)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ErrNotFound
+		}
+		return err
+	}
+	defer f.Close()
+	_, err = s.Put(ctx, dstKey, f, PutOptions{})
+	return err
+}
+
+func (s *FilesystemStore) URL(key string) string {
+	return s.baseURL + "/" + key
+}
+
+func (s *FilesystemStore) SignedURL(key string, opts SignOptions) (string, error) {
+	return "", ErrSigningNotSupported
+}
+
+func (s *FilesystemStore) PresignPost(ctx context.Context, key string, opts PresignPostOptions) (PresignedPost, error) {
+	return PresignedPost{}, ErrPresignNotSupported
+}
+
+func (s *FilesystemStore) PresignGet(ctx context.Context, key string, opts PresignGetOptions) (string, error) {
+	return "", ErrPresignNotSupported
+}
+
+// ServeHTTP serves the object named by the request path, which should
+// already have the URL prefix stripped. Content types come from the file
+// extension.
+func (s *FilesystemStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p, err := s.path(strings.TrimPrefix(r.URL.Path, "/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	info, err := os.Stat(p)
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, p)
+}
+
+// multipartDir holds in-progress multipart uploads below the root. Keys
+// can't start with a dot, so it never collides with an object.
+const multipartDir = ".multipart"
+
+func (s *FilesystemStore) uploadDir(key, uploadID string) (string, error) {
+	if _, err := s.path(key); err != nil {
+		return "", err
+	}
+	if !filepath.IsLocal(uploadID) || strings.ContainsAny(uploadID, `/\`) {
+		return "", fmt.Errorf("invalid upload ID %q", uploadID)
+	}
+	dir := filepath.Join(s.root, multipartDir, uploadID)
+	owner, err := os.ReadFile(filepath.Join(dir, "key"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	if string(owner) != key {
+		return "", ErrNotFound
+	}
+	return dir, nil
+}
+
+func (s *FilesystemStore) CreateMultipart(ctx context.Context, key string, opts PutOptions) (string, error) {
+	if _, err := s.path(key); err != nil {
+		return "", err
+	}
+	uploadID := newUploadID()
+	dir := filepath.Join(s.root, multipartDir, uploadID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "key"), []byte(key), 0644); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+func (s *FilesystemStore) UploadPart(ctx context.Context, key, uploadID string, partNumber int32, body io.Reader, size int64, contentMD5 string) (CompletedPart, error) {
+	dir, err := s.uploadDir(key, uploadID)
+	if err != nil {
+		return CompletedPart{}, err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".part-*")
+	if err != nil {
+		return CompletedPart{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	md5Hash, sha256Hash := md5.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, md5Hash, sha256Hash), body); err != nil {
+		tmp.Close()
+		return CompletedPart{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return CompletedPart{}, err
+	}
+	if err := verifyMD5(contentMD5, md5Hash.Sum(nil)); err != nil {
+		return CompletedPart{}, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, fmt.Sprintf("part-%05d", partNumber))); err != nil {
+		return CompletedPart{}, err
+	}
+	return CompletedPart{
+		PartNumber:     partNumber,
+		ETag:           `"` + hex.EncodeToString(md5Hash.Sum(nil)) + `"`,
+		ChecksumSHA256: base64.StdEncoding.EncodeToString(sha256Hash.Sum(nil)),
+	}, nil
+}
+
+func (s *FilesystemStore) CompleteMultipart(ctx context.Context, key, uploadID string, parts []CompletedPart) (ObjectInfo, error) {
+	dir, err := s.uploadDir(key, uploadID)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	paths := make([]string, 0, len(parts))
+	for _, p := range parts {
+		path := filepath.Join(dir, fmt.Sprintf("part-%05d", p.PartNumber))
+		if _, err := os.Stat(path); err != nil {
+			return ObjectInfo{}, fmt.Errorf("part %d is missing: %w", p.PartNumber, err)
+		}
+		paths = append(paths, path)
+	}
+	body := &concatReader{paths: paths}
+	defer body.Close()
+	info, err := s.Put(ctx, key, body, PutOptions{})
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return info, os.RemoveAll(dir)
+}
+
+// concatReader reads a list of files back to back, keeping only one open
+// at a time since an upload can have thousands of parts.
+type concatReader struct {
+	paths []string
+	cur   *os.File
+}
+
+func (c *concatReader) Read(p []byte) (int, error) {
+	for {
+		if c.cur == nil {
+			if len(c.paths) == 0 {
+				return 0, io.EOF
+			}
+			f, err := os.Open(c.paths[0])
+			if err != nil {
+				return 0, err
+			}
+			c.cur, c.paths = f, c.paths[1:]
+		}
+		n, err := c.cur.Read(p)
+		if err == io.EOF {
+			c.cur.Close()
+			c.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *concatReader) Close() error {
+	if c.cur != nil {
+		return c.cur.Close()
+	}
+	return nil
+}
+
+// ListMultipart dates uploads by their key file, which is written once
+// when the upload starts.
+func (s *FilesystemStore) ListMultipart(ctx context.Context, before time.Time) ([]PendingUpload, error) {
+	entries, err := os.ReadDir(filepath.Join(s.root, multipartDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var uploads []PendingUpload
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		keyPath := filepath.Join(s.root, multipartDir, entry.Name(), "key")
+		info, err := os.Stat(keyPath)
+		if err != nil || !info.ModTime().Before(before) {
+			continue
+		}
+		key, err := os.ReadFile(keyPath)
+		if err != nil {
+			continue
+		}
+		uploads = append(uploads, PendingUpload{Key: string(key), UploadID: entry.Name(), Initiated: info.ModTime()})
+	}
+	return uploads, nil
+}
+
+func (s *FilesystemStore) MultipartParts(ctx context.Context, key, uploadID string) (int, int64, error) {
+	dir, err := s.uploadDir(key, uploadID)
+	if err != nil {
+		return 0, 0, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var (
+		parts int
+		size  int64
+	)
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "part-") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return 0, 0, err
+		}
+		parts++
+		size += info.Size()
+	}
+	return parts, size, nil
+}
+
+func (s *FilesystemStore) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	dir, err := s.uploadDir(key, uploadID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
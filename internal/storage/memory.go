@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	data        []byte
+	contentType string
+	header      http.Header
+	modTime     time.Time
+}
+
+func (o memoryObject) info() ObjectInfo {
+	return ObjectInfo{
+		Size:           int64(len(o.data)),
+		ETag:           md5ETag(o.data),
+		ChecksumSHA256: checksumSHA256(o.data),
+	}
+}
+
+// MemoryStore keeps objects in process memory. Everything is lost on
+// restart, which makes it a good fit for tests and throwaway dev servers.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+	uploads map[string]*memoryUpload
+	baseURL string
+}
+
+// NewMemory returns an empty store whose objects are served by the store
+// itself under baseURL.
+func NewMemory(baseURL string) *MemoryStore {
+	return &MemoryStore{
+		objects: map[string]memoryObject{},
+		uploads: map[string]*memoryUpload{},
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+func (s *MemoryStore) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (ObjectInfo, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	sum := md5.Sum(data)
+	if err := verifyMD5(opts.ContentMD5, sum[:]); err != nil {
+		return ObjectInfo{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj := memoryObject{data: data, contentType: opts.ContentType, header: opts.headers(), modTime: time.Now()}
+	s.objects[key] = obj
+	return obj.info(), nil
+}
+
+func (s *MemoryStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return ObjectInfo{}, ErrNotFound
+	}
+	return obj.info(), nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *MemoryStore) Copy(ctx context.Context, srcKey, dstKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[srcKey]
+	if !ok {
+		return ErrNotFound
+	}
+	obj.modTime = time.Now()
+	s.objects[dstKey] = obj
+	return nil
+}
+
+func (s *MemoryStore) URL(key string) string {
+	return s.baseURL + "/" + key
+}
+
+func (s *MemoryStore) SignedURL(key string, opts SignOptions) (string, error) {
+	return "", ErrSigningNotSupported
+}
+
+func (s *MemoryStore) PresignPost(ctx context.Context, key string, opts PresignPostOptions) (PresignedPost, error) {
+	return PresignedPost{}, ErrPresignNotSupported
+}
+
+func (s *MemoryStore) PresignGet(ctx context.Context, key string, opts PresignGetOptions) (string, error) {
+	return "", ErrPresignNotSupported
+}
+
+// ServeHTTP serves the object named by the request path, which should
+// already have the URL prefix stripped.
+func (s *MemoryStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	obj, ok := s.objects[strings.TrimPrefix(r.URL.Path, "/")]
+	s.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if obj.contentType != "" {
+		w.Header().Set("Content-Type", obj.contentType)
+	}
+	for name, values := range obj.header {
+		w.Header()[name] = values
+	}
+	http.ServeContent(w, r, "", obj.modTime, bytes.NewReader(obj.data))
+}
+
+type memoryUpload struct {
+	key         string
+	contentType string
+	header      http.Header
+	parts       map[int32][]byte
+	initiated   time.Time
+}
+
+func (s *MemoryStore) CreateMultipart(ctx context.Context, key string, opts PutOptions) (string, error) {
+	uploadID := newUploadID()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads[uploadID] = &memoryUpload{key: key, contentType: opts.ContentType, header: opts.headers(), parts: map[int32][]byte{}, initiated: time.Now()}
+	return uploadID, nil
+}
+
+func (s *MemoryStore) UploadPart(ctx context.Context, key, uploadID string, partNumber int32, body io.Reader, size int64, contentMD5 string) (CompletedPart, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return CompletedPart{}, err
+	}
+	sum := md5.Sum(data)
+	if err := verifyMD5(contentMD5, sum[:]); err != nil {
+		return CompletedPart{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[uploadID]
+	if !ok || upload.key != key {
+		return CompletedPart{}, ErrNotFound
+	}
+	upload.parts[partNumber] = data
+	return CompletedPart{
+		PartNumber:     partNumber,
+		ETag:           md5ETag(data),
+		ChecksumSHA256: checksumSHA256(data),
+	}, nil
+}
+
+func (s *MemoryStore) CompleteMultipart(ctx context.Context, key, uploadID string, parts []CompletedPart) (ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[uploadID]
+	if !ok || upload.key != key {
+		return ObjectInfo{}, ErrNotFound
+	}
+
+	var buf bytes.Buffer
+	for _, p := range parts {
+		data, ok := upload.parts[p.PartNumber]
+		if !ok || md5ETag(data) != p.ETag {
+			return ObjectInfo{}, fmt.Errorf("part %d is missing or doesn't match its ETag", p.PartNumber)
+		}
+		buf.Write(data)
+	}
+	obj := memoryObject{data: buf.Bytes(), contentType: upload.contentType, header: upload.header, modTime: time.Now()}
+	s.objects[key] = obj
+	delete(s.uploads, uploadID)
+	return obj.info(), nil
+}
+
+func (s *MemoryStore) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.uploads, uploadID)
+	return nil
+}
+
+func (s *MemoryStore) ListMultipart(ctx context.Context, before time.Time) ([]PendingUpload, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var uploads []PendingUpload
+	for id, upload := range s.uploads {
+		if upload.initiated.Before(before) {
+			uploads = append(uploads, PendingUpload{Key: upload.key, UploadID: id, Initiated: upload.initiated})
+		}
+	}
+	return uploads, nil
+}
+
+func (s *MemoryStore) MultipartParts(ctx context.Context, key, uploadID string) (int, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	upload, ok := s.uploads[uploadID]
+	if !ok || upload.key != key {
+		return 0, 0, ErrNotFound
+	}
+	var size int64
+	for _, data := range upload.parts {
+		size += int64(len(data))
+	}
+	return len(upload.parts), size, nil
+}
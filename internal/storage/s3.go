@@ -0,0 +1,691 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cfsign"
+)
+
+type S3Options struct {
+	Bucket string
+	// CDNDomain is the CloudFront distribution objects are served through.
+	CDNDomain string
+	// URLSigner signs CDN URLs for SignedURL. Without it the store can't
+	// sign.
+	URLSigner *cfsign.Signer
+	// PutTimeout bounds a single upload, RequestTimeout every other call.
+	// Zero means no limit beyond the caller's context.
+	PutTimeout     time.Duration
+	RequestTimeout time.Duration
+	// ArchiveStorageClass is what Archive moves objects to, GLACIER when
+	// empty.
+	ArchiveStorageClass string
+}
+
+type S3Store struct {
+	client *s3.Client
+	opts   S3Options
+}
+
+func NewS3(client *s3.Client, opts S3Options) *S3Store {
+	return &S3Store{
+		client: client,
+		opts:   opts,
+	}
+}
+
+// Bucket is the name of the bucket the store writes to.
+func (s *S3Store) Bucket() string {
+	return s.opts.Bucket
+}
+
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// optionalString leaves empty values unset rather than sending them.
+func optionalString(v string) *string {
+	if v == "" {
+		return nil
+	}
+	return aws.String(v)
+}
+
+// translateError maps S3 error codes callers care about onto the
+// store-independent errors.
+func translateError(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "BadDigest", "InvalidDigest":
+			return fmt.Errorf("%w: %v", ErrBadDigest, err)
+		case "NotFound", "NoSuchKey", "NoSuchVersion":
+			return fmt.Errorf("%w: %v", ErrNotFound, err)
+		case "InvalidObjectState":
+			return fmt.Errorf("%w: %v", ErrArchived, err)
+		case "AccessDenied":
+			// Locked versions are refused with a plain AccessDenied, only
+			// the message tells them apart from missing permissions.
+			if strings.Contains(strings.ToLower(apiErr.ErrorMessage()), "object lock") {
+				return fmt.Errorf("%w: %v", ErrObjectLocked, err)
+			}
+		}
+	}
+	return err
+}
+
+// Put leaves ObjectInfo.Size zero unless S3 reports it, which it only
+// does for directory buckets.
+func (s *S3Store) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (ObjectInfo, error) {
+	ctx, cancel := withTimeout(ctx, s.opts.PutTimeout)
+	defer cancel()
+	input := &s3.PutObjectInput{
+		Bucket:             aws.String(s.opts.Bucket),
+		Key:                aws.String(key),
+		Body:               body,
+		ContentType:        aws.String(opts.ContentType),
+		ContentMD5:         optionalString(opts.ContentMD5),
+		CacheControl:       optionalString(opts.CacheControl),
+		ContentDisposition: optionalString(opts.ContentDisposition),
+		ContentLanguage:    optionalString(opts.ContentLanguage),
+		Metadata:           opts.Metadata,
+		// Have the SDK checksum the stream as it sends it, so S3 rejects
+		// bytes that changed on the way.
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	}
+	if r := opts.Retention; !r.IsZero() {
+		input.ObjectLockMode = types.ObjectLockMode(r.Mode)
+		input.ObjectLockRetainUntilDate = optionalTime(r.RetainUntil)
+		input.ObjectLockLegalHoldStatus = legalHoldStatus(r.LegalHold)
+	}
+	out, err := s.client.PutObject(ctx, input)
+	if err != nil {
+		return ObjectInfo{}, translateError(err)
+	}
+	return ObjectInfo{
+		Size:           aws.ToInt64(out.Size),
+		ETag:           aws.ToString(out.ETag),
+		VersionID:      aws.ToString(out.VersionId),
+		ChecksumSHA256: aws.ToString(out.ChecksumSHA256),
+	}, nil
+}
+
+func (s *S3Store) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	return s.head(ctx, key, "")
+}
+
+// head describes the given version of an object, or the current one when
+// versionID is empty.
+func (s *S3Store) head(ctx context.Context, key, versionID string) (ObjectInfo, error) {
+	ctx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+	defer cancel()
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(s.opts.Bucket),
+		Key:          aws.String(key),
+		VersionId:    optionalString(versionID),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return ObjectInfo{}, translateError(err)
+	}
+	info := ObjectInfo{
+		Size:           aws.ToInt64(out.ContentLength),
+		ETag:           aws.ToString(out.ETag),
+		VersionID:      aws.ToString(out.VersionId),
+		ChecksumSHA256: aws.ToString(out.ChecksumSHA256),
+		StorageClass:   string(out.StorageClass),
+	}
+	info.Restoring, info.RestoredUntil = parseRestore(aws.ToString(out.Restore))
+	return info, nil
+}
+
+// Attributes asks GetObjectAttributes for the checksum S3 verified when
+// the object was uploaded, which HeadObject only reports for some uploads.
+func (s *S3Store) Attributes(ctx context.Context, key string) (ObjectInfo, error) {
+	ctx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+	defer cancel()
+	out, err := s.client.GetObjectAttributes(ctx, &s3.GetObjectAttributesInput{
+		Bucket: aws.String(s.opts.Bucket),
+		Key:    aws.String(key),
+		ObjectAttributes: []types.ObjectAttributes{
+			types.ObjectAttributesEtag,
+			types.ObjectAttributesChecksum,
+			types.ObjectAttributesObjectSize,
+			types.ObjectAttributesStorageClass,
+		},
+	})
+	if err != nil {
+		return ObjectInfo{}, translateError(err)
+	}
+	info := ObjectInfo{
+		Size: aws.ToInt64(out.ObjectSize),
+		// Unlike HeadObject, GetObjectAttributes leaves the quotes off.
+		ETag:         `"` + strings.Trim(aws.ToString(out.ETag), `"`) + `"`,
+		VersionID:    aws.ToString(out.VersionId),
+		StorageClass: string(out.StorageClass),
+	}
+	if out.StorageClass == types.StorageClassStandard {
+		info.StorageClass = ""
+	}
+	if out.Checksum != nil {
+		info.ChecksumSHA256 = aws.ToString(out.Checksum.ChecksumSHA256)
+	}
+	return info, nil
+}
+
+// parseRestore reads the x-amz-restore header, e.g.
+// `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`.
+func parseRestore(header string) (ongoing bool, expiry time.Time) {
+	if header == "" {
+		return false, time.Time{}
+	}
+	ongoing = strings.Contains(header, `ongoing-request="true"`)
+	if _, rest, ok := strings.Cut(header, `expiry-date="`); ok {
+		if date, _, ok := strings.Cut(rest, `"`); ok {
+			expiry, _ = http.ParseTime(date)
+		}
+	}
+	return ongoing, expiry
+}
+
+// Get bounds the whole read by PutTimeout, since the body is streamed
+// after the call returns.
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	ctx, cancel := withTimeout(ctx, s.opts.PutTimeout)
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.opts.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		cancel()
+		return nil, translateError(err)
+	}
+	return &cancelOnClose{ReadCloser: out.Body, cancel: cancel}, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	ctx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+	defer cancel()
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.opts.Bucket),
+		Key:    aws.String(key),
+	})
+	return translateError(err)
+}
+
+// maxSingleCopySize is the largest object CopyObject accepts. Anything
+// bigger has to be copied part by part.
+const maxSingleCopySize = 5 << 30
+
+// copyPartSize is the range copied by each UploadPartCopy call.
+const copyPartSize = 512 << 20
+
+// Copy happens entirely inside S3, nothing is downloaded.
+func (s *S3Store) Copy(ctx context.Context, srcKey, dstKey string) error {
+	return s.CopyFrom(ctx, s, srcKey, dstKey)
+}
+
+// CopyFrom copies an object from another bucket, possibly in another
+// region, into this store. The copy runs server side using this store's
+// credentials, which need read access to the source bucket.
+func (s *S3Store) CopyFrom(ctx context.Context, src *S3Store, srcKey, dstKey string) error {
+	info, err := src.head(ctx, srcKey, "")
+	if err != nil {
+		return err
+	}
+	copySource := src.opts.Bucket + "/" + srcKey
+	if info.Size > maxSingleCopySize {
+		return s.copyMultipart(ctx, copySource, multipartInput(s.opts.Bucket, dstKey, PutOptions{}), info.Size)
+	}
+
+	ctx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+	defer cancel()
+	_, err = s.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(s.opts.Bucket),
+		CopySource:        aws.String(copySource),
+		Key:               aws.String(dstKey),
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	})
+	return translateError(err)
+}
+
+func (s *S3Store) copyMultipart(ctx context.Context, copySource string, create *s3.CreateMultipartUploadInput, size int64) error {
+	dstKey := aws.ToString(create.Key)
+	createCtx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+	out, err := s.client.CreateMultipartUpload(createCtx, create)
+	cancel()
+	if err != nil {
+		return translateError(err)
+	}
+	uploadID := aws.ToString(out.UploadId)
+
+	var parts []CompletedPart
+	for start, partNumber := int64(0), int32(1); start < size; start, partNumber = start+copyPartSize, partNumber+1 {
+		end := min(start+copyPartSize, size) - 1
+		partCtx, cancel := withTimeout(ctx, s.opts.PutTimeout)
+		out, err := s.client.UploadPartCopy(partCtx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(s.opts.Bucket),
+			Key:             aws.String(dstKey),
+			UploadId:        aws.String(uploadID),
+			PartNumber:      aws.Int32(partNumber),
+			CopySource:      aws.String(copySource),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		})
+		cancel()
+		if err != nil {
+			s.AbortMultipart(context.WithoutCancel(ctx), dstKey, uploadID)
+			return translateError(err)
+		}
+		parts = append(parts, CompletedPart{
+			PartNumber:     partNumber,
+			ETag:           aws.ToString(out.CopyPartResult.ETag),
+			ChecksumSHA256: aws.ToString(out.CopyPartResult.ChecksumSHA256),
+		})
+	}
+
+	if _, err := s.CompleteMultipart(ctx, dstKey, uploadID, parts); err != nil {
+		s.AbortMultipart(context.WithoutCancel(ctx), dstKey, uploadID)
+		return err
+	}
+	return nil
+}
+
+func (s *S3Store) URL(key string) string {
+	return fmt.Sprintf("https://%s/%s", s.opts.CDNDomain, key)
+}
+
+// SignedURL uses a canned policy when only an expiry is given, since it
+// makes for a much shorter URL.
+func (s *S3Store) SignedURL(key string, opts SignOptions) (string, error) {
+	if s.opts.URLSigner == nil {
+		return "", ErrSigningNotSupported
+	}
+	if opts.NotBefore.IsZero() && opts.IPRange == "" {
+		return s.opts.URLSigner.SignCanned(s.URL(key), opts.Expires)
+	}
+	return s.opts.URLSigner.SignCustom(s.URL(key), cfsign.Policy{
+		NotBefore: opts.NotBefore,
+		Expires:   opts.Expires,
+		IPRange:   opts.IPRange,
+	})
+}
+
+func (s *S3Store) PresignGet(ctx context.Context, key string, opts PresignGetOptions) (string, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.opts.Bucket),
+		Key:    aws.String(key),
+	}
+	if opts.VersionID != "" {
+		input.VersionId = aws.String(opts.VersionID)
+	}
+	if opts.Filename != "" {
+		input.ResponseContentDisposition = aws.String(mime.FormatMediaType("attachment", map[string]string{"filename": opts.Filename}))
+	}
+	presigned, err := s3.NewPresignClient(s.client).PresignGetObject(ctx, input, s3.WithPresignExpires(opts.Expires))
+	if err != nil {
+		return "", err
+	}
+	return presigned.URL, nil
+}
+
+// PresignPost embeds the size and content type limits in the POST policy,
+// so S3 itself rejects uploads that break them.
+func (s *S3Store) PresignPost(ctx context.Context, key string, opts PresignPostOptions) (PresignedPost, error) {
+	ctx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+	defer cancel()
+	presignClient := s3.NewPresignClient(s.client)
+	// Fields besides the key and the signature only end up on the object
+	// when the form sends them, so each one is pinned in the policy.
+	extra := map[string]string{"Content-Type": opts.ContentType}
+	if opts.CacheControl != "" {
+		extra["Cache-Control"] = opts.CacheControl
+	}
+	if opts.ContentDisposition != "" {
+		extra["Content-Disposition"] = opts.ContentDisposition
+	}
+	for name, value := range opts.Metadata {
+		extra["x-amz-meta-"+name] = value
+	}
+	conditions := []interface{}{
+		[]interface{}{"content-length-range", 1, opts.MaxSize},
+	}
+	for name, value := range extra {
+		conditions = append(conditions, []interface{}{"eq", "$" + name, value})
+	}
+	presigned, err := presignClient.PresignPostObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.opts.Bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(opts.ContentType),
+	}, func(po *s3.PresignPostOptions) {
+		po.Expires = opts.Expires
+		po.Conditions = conditions
+	})
+	if err != nil {
+		return PresignedPost{}, err
+	}
+
+	fields := presigned.Values
+	for name, value := range extra {
+		fields[name] = value
+	}
+	return PresignedPost{URL: presigned.URL, Fields: fields}, nil
+}
+
+func (s *S3Store) CreateMultipart(ctx context.Context, key string, opts PutOptions) (string, error) {
+	ctx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+	defer cancel()
+	out, err := s.client.CreateMultipartUpload(ctx, multipartInput(s.opts.Bucket, key, opts))
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.UploadId), nil
+}
+
+func multipartInput(bucket, key string, opts PutOptions) *s3.CreateMultipartUploadInput {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:             aws.String(bucket),
+		Key:                aws.String(key),
+		ContentType:        optionalString(opts.ContentType),
+		CacheControl:       optionalString(opts.CacheControl),
+		ContentDisposition: optionalString(opts.ContentDisposition),
+		ContentLanguage:    optionalString(opts.ContentLanguage),
+		Metadata:           opts.Metadata,
+		ChecksumAlgorithm:  types.ChecksumAlgorithmSha256,
+	}
+	if r := opts.Retention; !r.IsZero() {
+		input.ObjectLockMode = types.ObjectLockMode(r.Mode)
+		input.ObjectLockRetainUntilDate = optionalTime(r.RetainUntil)
+		input.ObjectLockLegalHoldStatus = legalHoldStatus(r.LegalHold)
+	}
+	return input
+}
+
+// headers returns the headers the object was stored with, which a
+// multipart copy has to set again since it doesn't carry them over.
+func (s *S3Store) headers(ctx context.Context, key string) (PutOptions, error) {
+	ctx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+	defer cancel()
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.opts.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return PutOptions{}, translateError(err)
+	}
+	return PutOptions{
+		ContentType:        aws.ToString(out.ContentType),
+		CacheControl:       aws.ToString(out.CacheControl),
+		ContentDisposition: aws.ToString(out.ContentDisposition),
+		ContentLanguage:    aws.ToString(out.ContentLanguage),
+		Metadata:           out.Metadata,
+	}, nil
+}
+
+func (s *S3Store) UploadPart(ctx context.Context, key, uploadID string, partNumber int32, body io.Reader, size int64, contentMD5 string) (CompletedPart, error) {
+	ctx, cancel := withTimeout(ctx, s.opts.PutTimeout)
+	defer cancel()
+	out, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:            aws.String(s.opts.Bucket),
+		Key:               aws.String(key),
+		UploadId:          aws.String(uploadID),
+		PartNumber:        aws.Int32(partNumber),
+		Body:              body,
+		ContentLength:     aws.Int64(size),
+		ContentMD5:        optionalString(contentMD5),
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	})
+	if err != nil {
+		return CompletedPart{}, translateError(err)
+	}
+	return CompletedPart{
+		PartNumber:     partNumber,
+		ETag:           aws.ToString(out.ETag),
+		ChecksumSHA256: aws.ToString(out.ChecksumSHA256),
+	}, nil
+}
+
+func (s *S3Store) CompleteMultipart(ctx context.Context, key, uploadID string, parts []CompletedPart) (ObjectInfo, error) {
+	ctx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+	defer cancel()
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, p := range parts {
+		part := types.CompletedPart{
+			PartNumber: aws.Int32(p.PartNumber),
+			ETag:       aws.String(p.ETag),
+		}
+		if p.ChecksumSHA256 != "" {
+			part.ChecksumSHA256 = aws.String(p.ChecksumSHA256)
+		}
+		completed = append(completed, part)
+	}
+	out, err := s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.opts.Bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	// The response doesn't carry the size, so ask for it along with the
+	// checksum in the form HeadObject reports it. Pinning the version
+	// keeps a concurrent overwrite from being described instead.
+	return s.head(ctx, key, aws.ToString(out.VersionId))
+}
+
+func (s *S3Store) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	ctx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+	defer cancel()
+	_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.opts.Bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	return err
+}
+
+func (s *S3Store) ListMultipart(ctx context.Context, before time.Time) ([]PendingUpload, error) {
+	var uploads []PendingUpload
+	paginator := s3.NewListMultipartUploadsPaginator(s.client, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.opts.Bucket),
+	})
+	for paginator.HasMorePages() {
+		pageCtx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range page.Uploads {
+			initiated := aws.ToTime(u.Initiated)
+			if !initiated.Before(before) {
+				continue
+			}
+			uploads = append(uploads, PendingUpload{
+				Key:       aws.ToString(u.Key),
+				UploadID:  aws.ToString(u.UploadId),
+				Initiated: initiated,
+			})
+		}
+	}
+	return uploads, nil
+}
+
+func (s *S3Store) MultipartParts(ctx context.Context, key, uploadID string) (int, int64, error) {
+	var (
+		parts int
+		size  int64
+	)
+	paginator := s3.NewListPartsPaginator(s.client, &s3.ListPartsInput{
+		Bucket:   aws.String(s.opts.Bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	for paginator.HasMorePages() {
+		pageCtx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			return 0, 0, translateError(err)
+		}
+		for _, p := range page.Parts {
+			parts++
+			size += aws.ToInt64(p.Size)
+		}
+	}
+	return parts, size, nil
+}
+
+// SetRetention needs a bucket created with Object Lock enabled. Retention
+// can be extended but S3 refuses to shorten it.
+func (s *S3Store) SetRetention(ctx context.Context, key string, r Retention) error {
+	ctx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+	defer cancel()
+	if r.Mode != "" {
+		_, err := s.client.PutObjectRetention(ctx, &s3.PutObjectRetentionInput{
+			Bucket: aws.String(s.opts.Bucket),
+			Key:    aws.String(key),
+			Retention: &types.ObjectLockRetention{
+				Mode:            types.ObjectLockRetentionMode(r.Mode),
+				RetainUntilDate: aws.Time(r.RetainUntil),
+			},
+		})
+		if err != nil {
+			return translateError(err)
+		}
+	}
+	_, err := s.client.PutObjectLegalHold(ctx, &s3.PutObjectLegalHoldInput{
+		Bucket:    aws.String(s.opts.Bucket),
+		Key:       aws.String(key),
+		LegalHold: &types.ObjectLockLegalHold{Status: legalHoldStatus(r.LegalHold)},
+	})
+	return translateError(err)
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return aws.Time(t)
+}
+
+func legalHoldStatus(on bool) types.ObjectLockLegalHoldStatus {
+	if on {
+		return types.ObjectLockLegalHoldStatusOn
+	}
+	return types.ObjectLockLegalHoldStatusOff
+}
+
+// Archive rewrites the object onto itself in the archive storage class.
+// Objects too large for a single copy are rewritten part by part, with
+// their headers and metadata set again on the new upload.
+func (s *S3Store) Archive(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := s.head(ctx, key, "")
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	storageClass := s.opts.ArchiveStorageClass
+	if storageClass == "" {
+		storageClass = string(types.StorageClassGlacier)
+	}
+	if info.Size > maxSingleCopySize {
+		opts, err := s.headers(ctx, key)
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+		create := multipartInput(s.opts.Bucket, key, opts)
+		create.StorageClass = types.StorageClass(storageClass)
+		if err := s.copyMultipart(ctx, s.opts.Bucket+"/"+key, create, info.Size); err != nil {
+			return ObjectInfo{}, err
+		}
+		return s.head(ctx, key, "")
+	}
+
+	copyCtx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+	defer cancel()
+	_, err = s.client.CopyObject(copyCtx, &s3.CopyObjectInput{
+		Bucket:            aws.String(s.opts.Bucket),
+		CopySource:        aws.String(s.opts.Bucket + "/" + key),
+		Key:               aws.String(key),
+		StorageClass:      types.StorageClass(storageClass),
+		MetadataDirective: types.MetadataDirectiveCopy,
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	})
+	if err != nil {
+		return ObjectInfo{}, translateError(err)
+	}
+	return s.head(ctx, key, "")
+}
+
+// RestoreVersion copies the version onto its own key, which S3 stores as
+// a new current version. The version being copied is kept.
+func (s *S3Store) RestoreVersion(ctx context.Context, key, versionID string) (ObjectInfo, error) {
+	info, err := s.head(ctx, key, versionID)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	copySource := s.opts.Bucket + "/" + key + "?versionId=" + url.QueryEscape(versionID)
+	if info.Size > maxSingleCopySize {
+		if err := s.copyMultipart(ctx, copySource, multipartInput(s.opts.Bucket, key, PutOptions{}), info.Size); err != nil {
+			return ObjectInfo{}, err
+		}
+		return s.head(ctx, key, "")
+	}
+
+	copyCtx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+	defer cancel()
+	_, err = s.client.CopyObject(copyCtx, &s3.CopyObjectInput{
+		Bucket:            aws.String(s.opts.Bucket),
+		CopySource:        aws.String(copySource),
+		Key:               aws.String(key),
+		MetadataDirective: types.MetadataDirectiveCopy,
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	})
+	if err != nil {
+		return ObjectInfo{}, translateError(err)
+	}
+	return s.head(ctx, key, "")
+}
+
+func (s *S3Store) Restore(ctx context.Context, key string, days int32) error {
+	ctx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+	defer cancel()
+	_, err := s.client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket: aws.String(s.opts.Bucket),
+		Key:    aws.String(key),
+		RestoreRequest: &types.RestoreRequest{
+			Days:                 aws.Int32(days),
+			GlacierJobParameters: &types.GlacierJobParameters{Tier: types.TierStandard},
+		},
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+		return nil
+	}
+	return translateError(err)
+}
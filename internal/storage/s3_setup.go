@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// AbortMultipartRuleID names the lifecycle rule EnsureAbortIncompleteMultipart
+// manages, so running it again replaces the rule instead of adding another.
+const AbortMultipartRuleID = "tubely-abort-incomplete-multipart"
+
+// EnsureBucket creates the bucket in region unless it already exists,
+// reporting whether it did. Object lock can only be turned on at creation.
+func (s *S3Store) EnsureBucket(ctx context.Context, region string, objectLock bool) (bool, error) {
+	ctx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+	defer cancel()
+
+	_, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.opts.Bucket)})
+	if err == nil {
+		return false, nil
+	}
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || (apiErr.ErrorCode() != "NotFound" && apiErr.ErrorCode() != "NoSuchBucket") {
+		return false, fmt.Errorf("couldn't check bucket: %w", err)
+	}
+
+	input := &s3.CreateBucketInput{
+		Bucket:                     aws.String(s.opts.Bucket),
+		ObjectLockEnabledForBucket: aws.Bool(objectLock),
+	}
+	// us-east-1 is the default, and S3 refuses it as a location constraint.
+	if region != "" && region != "us-east-1" {
+		input.CreateBucketConfiguration = &types.CreateBucketConfiguration{
+			LocationConstraint: types.BucketLocationConstraint(region),
+		}
+	}
+	if _, err := s.client.CreateBucket(ctx, input); err != nil {
+		return false, fmt.Errorf("couldn't create bucket: %w", err)
+	}
+	return true, nil
+}
+
+// BlockPublicAccess turns on all four public access blocks. Objects are
+// served through CloudFront or signed URLs, never straight from the
+// bucket.
+func (s *S3Store) BlockPublicAccess(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+	defer cancel()
+	_, err := s.client.PutPublicAccessBlock(ctx, &s3.PutPublicAccessBlockInput{
+		Bucket: aws.String(s.opts.Bucket),
+		PublicAccessBlockConfiguration: &types.PublicAccessBlockConfiguration{
+			BlockPublicAcls:       aws.Bool(true),
+			BlockPublicPolicy:     aws.Bool(true),
+			IgnorePublicAcls:      aws.Bool(true),
+			RestrictPublicBuckets: aws.Bool(true),
+		},
+	})
+	return err
+}
+
+// SetUploadCORS lets browsers on origins upload straight to the bucket
+// with presigned PUTs and POSTs, and read back the ETag of each part. It
+// replaces any CORS rules the bucket had.
+func (s *S3Store) SetUploadCORS(ctx context.Context, origins []string) error {
+	ctx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+	defer cancel()
+	_, err := s.client.PutBucketCors(ctx, &s3.PutBucketCorsInput{
+		Bucket: aws.String(s.opts.Bucket),
+		CORSConfiguration: &types.CORSConfiguration{
+			CORSRules: []types.CORSRule{{
+				AllowedOrigins: origins,
+				AllowedMethods: []string{"GET", "HEAD", "PUT", "POST"},
+				AllowedHeaders: []string{"*"},
+				ExposeHeaders:  []string{"ETag"},
+				MaxAgeSeconds:  aws.Int32(3600),
+			}},
+		},
+	})
+	return err
+}
+
+// EnsureAbortIncompleteMultipart adds a lifecycle rule that aborts
+// multipart uploads left incomplete for days, so abandoned parts stop
+// being billed. Other lifecycle rules are kept.
+func (s *S3Store) EnsureAbortIncompleteMultipart(ctx context.Context, days int32) error {
+	ctx, cancel := withTimeout(ctx, s.opts.RequestTimeout)
+	defer cancel()
+
+	var rules []types.LifecycleRule
+	out, err := s.client.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(s.opts.Bucket),
+	})
+	var apiErr smithy.APIError
+	switch {
+	case err == nil:
+		for _, rule := range out.Rules {
+			if aws.ToString(rule.ID) != AbortMultipartRuleID {
+				rules = append(rules, rule)
+			}
+		}
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchLifecycleConfiguration":
+	default:
+		return fmt.Errorf("couldn't get lifecycle rules: %w", err)
+	}
+
+	rules = append(rules, types.LifecycleRule{
+		ID:     aws.String(AbortMultipartRuleID),
+		Status: types.ExpirationStatusEnabled,
+		Filter: &types.LifecycleRuleFilter{Prefix: aws.String("")},
+		AbortIncompleteMultipartUpload: &types.AbortIncompleteMultipartUpload{
+			DaysAfterInitiation: aws.Int32(days),
+		},
+	})
+	_, err = s.client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(s.opts.Bucket),
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: rules},
+	})
+	return err
+}
+
+// CheckAccess writes, reads back and deletes an object under key, which
+// covers the permissions the server needs day to day.
+func (s *S3Store) CheckAccess(ctx context.Context, key string) error {
+	if _, err := s.Put(ctx, key, strings.NewReader("tubely"), PutOptions{ContentType: "text/plain"}); err != nil {
+		return fmt.Errorf("PutObject: %w", err)
+	}
+	if _, err := s.Stat(ctx, key); err != nil {
+		return fmt.Errorf("HeadObject: %w", err)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		return fmt.Errorf("DeleteObject: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+// Package storage abstracts where uploaded video objects live so handlers,
+// commands and background tasks don't talk to S3 directly.
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("object not found")
+	// ErrPresignNotSupported is returned by stores that can't hand out
+	// direct upload URLs.
+	ErrPresignNotSupported = errors.New("presigned uploads not supported by this store")
+	// ErrSigningNotSupported is returned by stores whose URLs can't be
+	// signed, so their plain URL is all there is.
+	ErrSigningNotSupported = errors.New("signed URLs not supported by this store")
+	// ErrBadDigest is returned when the bytes a store received don't
+	// match the Content-MD5 sent with them.
+	ErrBadDigest = errors.New("content MD5 mismatch")
+	// ErrObjectLocked is returned when retention or a legal hold keeps an
+	// object from being deleted.
+	ErrObjectLocked = errors.New("object is locked")
+	// ErrArchived is returned when reading an object that sits in an
+	// archive tier and hasn't been restored.
+	ErrArchived = errors.New("object is archived")
+)
+
+// Retention modes, as S3 Object Lock defines them. Governance retention
+// can be lifted by specially privileged callers, compliance retention by
+// no one until it runs out.
+const (
+	RetentionGovernance = "GOVERNANCE"
+	RetentionCompliance = "COMPLIANCE"
+)
+
+// Retention locks an object version against deletion until RetainUntil,
+// and for as long as LegalHold is set. The zero value locks nothing.
+type Retention struct {
+	Mode        string
+	RetainUntil time.Time
+	LegalHold   bool
+}
+
+func (r Retention) IsZero() bool {
+	return r.Mode == "" && !r.LegalHold
+}
+
+type PutOptions struct {
+	ContentType string
+	// CacheControl, ContentDisposition and ContentLanguage are sent back
+	// as headers when the object is fetched. Stores that serve files
+	// straight from disk don't keep them.
+	CacheControl       string
+	ContentDisposition string
+	ContentLanguage    string
+	// ContentMD5 is the base64 MD5 of the body. When set, the store
+	// refuses to keep bytes that hash differently.
+	ContentMD5 string
+	// Metadata is kept with the object as x-amz-meta-* headers. Keys are
+	// lower case and values must be ASCII.
+	Metadata map[string]string
+	// Retention is only honoured by stores that implement Locker.
+	Retention Retention
+}
+
+type PresignGetOptions struct {
+	Expires time.Duration
+	// Filename, when set, makes browsers save the download under that
+	// name.
+	Filename string
+	// VersionID pins the URL to one version of the object, so it keeps
+	// returning the same bytes after the key is overwritten. Stores
+	// without versioning ignore it.
+	VersionID string
+}
+
+type PresignPostOptions struct {
+	ContentType string
+	MaxSize     int64
+	Expires     time.Duration
+	// CacheControl and ContentDisposition are stored with the object, as
+	// in PutOptions. The upload has to send them unchanged.
+	CacheControl       string
+	ContentDisposition string
+	// Metadata is pinned in the policy along with the headers.
+	Metadata map[string]string
+}
+
+// SignOptions limits where and when a signed URL works. Expires is
+// required, the rest is optional.
+type SignOptions struct {
+	Expires   time.Time
+	NotBefore time.Time
+	// IPRange is a CIDR block the requester has to be in.
+	IPRange string
+}
+
+// PresignedPost is everything a browser needs to POST a file straight to
+// the store: the form action URL and the fields to send alongside the file.
+type PresignedPost struct {
+	URL    string
+	Fields map[string]string
+}
+
+// MinPartSize is the smallest part a multipart upload accepts, other than
+// the last one. It matches S3's limit so every store behaves the same.
+const MinPartSize = 5 << 20
+
+type CompletedPart struct {
+	PartNumber int32
+	ETag       string
+	// ChecksumSHA256 is the base64 SHA-256 of the part as the store
+	// received it.
+	ChecksumSHA256 string
+}
+
+// ObjectInfo describes a stored object as the store sees it.
+type ObjectInfo struct {
+	Size int64
+	ETag string
+	// VersionID is only set by stores with versioning, i.e. S3 buckets
+	// that have it enabled.
+	VersionID string
+	// ChecksumSHA256 is the base64 SHA-256 of the object. For S3
+	// multipart objects it's the composite checksum S3 reports instead.
+	ChecksumSHA256 string
+	// StorageClass is only set by stores with storage tiers, and left
+	// empty for S3's default STANDARD class.
+	StorageClass string
+	// Restoring is set while a restore of an archived object runs, and
+	// RestoredUntil once the restored copy can be read.
+	Restoring     bool
+	RestoredUntil time.Time
+}
+
+type Store interface {
+	Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (ObjectInfo, error)
+	// Stat returns ErrNotFound when the object doesn't exist.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// Get opens the object for reading. It returns ErrNotFound when the
+	// object doesn't exist.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	Copy(ctx context.Context, srcKey, dstKey string) error
+	// URL returns the public URL viewers fetch the object from.
+	URL(key string) string
+	// SignedURL returns a URL for the object that only works within the
+	// limits in opts.
+	SignedURL(key string, opts SignOptions) (string, error)
+	PresignPost(ctx context.Context, key string, opts PresignPostOptions) (PresignedPost, error)
+	// PresignGet returns a time limited URL that downloads the object
+	// straight from the store.
+	PresignGet(ctx context.Context, key string, opts PresignGetOptions) (string, error)
+
+	// Multipart uploads assemble an object from parts uploaded one at a
+	// time, possibly by different processes. The object only appears once
+	// CompleteMultipart succeeds.
+	CreateMultipart(ctx context.Context, key string, opts PutOptions) (uploadID string, err error)
+	// contentMD5 is optional and works like PutOptions.ContentMD5.
+	UploadPart(ctx context.Context, key, uploadID string, partNumber int32, body io.Reader, size int64, contentMD5 string) (CompletedPart, error)
+	CompleteMultipart(ctx context.Context, key, uploadID string, parts []CompletedPart) (ObjectInfo, error)
+	AbortMultipart(ctx context.Context, key, uploadID string) error
+}
+
+// Locker is implemented by stores that support object locking, so it can
+// be changed on objects that already exist.
+type Locker interface {
+	// SetRetention replaces the retention and legal hold of the current
+	// version of the object.
+	SetRetention(ctx context.Context, key string, r Retention) error
+}
+
+// Archiver is implemented by stores with a cheaper archive tier that
+// objects have to be restored from before they can be read.
+type Archiver interface {
+	// Archive moves the object into the archive tier and describes the
+	// object as it is afterwards.
+	Archive(ctx context.Context, key string) (ObjectInfo, error)
+	// Restore starts making a readable copy of an archived object, which
+	// lasts for the given number of days. Restoring an object that's
+	// already being restored is not an error.
+	Restore(ctx context.Context, key string, days int32) error
+}
+
+// PendingUpload is a multipart upload that was started and has been
+// neither completed nor aborted.
+type PendingUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// MultipartLister is implemented by stores that can list the multipart
+// uploads in progress, so abandoned ones can be found and aborted.
+type MultipartLister interface {
+	// ListMultipart returns the uploads started before the given time.
+	ListMultipart(ctx context.Context, before time.Time) ([]PendingUpload, error)
+	// MultipartParts counts the parts uploaded so far and their total
+	// size.
+	MultipartParts(ctx context.Context, key, uploadID string) (parts int, size int64, err error)
+}
+
+// Versioner is implemented by stores that keep earlier versions of
+// objects, i.e. S3 buckets with versioning enabled.
+type Versioner interface {
+	// RestoreVersion makes the given version the object's current one
+	// again by copying it over whatever is there now, which also undoes
+	// a delete, and describes the object as it is afterwards. It returns
+	// ErrNotFound when the version doesn't exist.
+	RestoreVersion(ctx context.Context, key, versionID string) (ObjectInfo, error)
+}
+
+// AttributeReader is implemented by stores that can describe an object,
+// including the checksum it was uploaded with, without anyone reading it.
+type AttributeReader interface {
+	// Attributes returns ErrNotFound when the object doesn't exist.
+	// ChecksumSHA256 is empty when the object was stored without one.
+	Attributes(ctx context.Context, key string) (ObjectInfo, error)
+}
+
+// newUploadID returns a random ID for stores that track multipart uploads
+// themselves.
+func newUploadID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("failed to generate random bytes")
+	}
+	return hex.EncodeToString(b)
+}
+
+// md5ETag mimics S3, whose ETags for parts and single-part objects are
+// the quoted MD5 of the content.
+func md5ETag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// verifyMD5 compares a computed MD5 against the base64 digest the caller
+// sent, if any.
+func verifyMD5(contentMD5 string, sum []byte) error {
+	if contentMD5 != "" && contentMD5 != base64.StdEncoding.EncodeToString(sum) {
+		return ErrBadDigest
+	}
+	return nil
+}
+
+func checksumSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Move copies an object to a new key and removes the original.
+func Move(ctx context.Context, store Store, srcKey, dstKey string) error {
+	if err := store.Copy(ctx, srcKey, dstKey); err != nil {
+		return err
+	}
+	return store.Delete(ctx, srcKey)
+}
+
+var (
+	_ Archiver        = (*S3Store)(nil)
+	_ AttributeReader = (*S3Store)(nil)
+	_ Locker          = (*S3Store)(nil)
+	_ MultipartLister = (*S3Store)(nil)
+	_ Store           = (*S3Store)(nil)
+	_ Versioner       = (*S3Store)(nil)
+	_ MultipartLister = (*FilesystemStore)(nil)
+	_ Store           = (*FilesystemStore)(nil)
+	_ MultipartLister = (*MemoryStore)(nil)
+	_ Store           = (*MemoryStore)(nil)
+)
+
+// headers returns the response headers opts asks for, other than the
+// content type.
+func (opts PutOptions) headers() http.Header {
+	h := http.Header{}
+	if opts.CacheControl != "" {
+		h.Set("Cache-Control", opts.CacheControl)
+	}
+	if opts.ContentDisposition != "" {
+		h.Set("Content-Disposition", opts.ContentDisposition)
+	}
+	if opts.ContentLanguage != "" {
+		h.Set("Content-Language", opts.ContentLanguage)
+	}
+	for name, value := range opts.Metadata {
+		h.Set("X-Amz-Meta-"+name, value)
+	}
+	return h
+}
@@ -0,0 +1,492 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testBaseURL = "http://store.test/objects"
+
+// TestMemoryStore and TestFilesystemStore run the same conformance suite,
+// so the stores behind STORAGE_BACKEND can't drift apart in what handlers
+// see.
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		return NewMemory(testBaseURL + "/")
+	})
+}
+
+func TestFilesystemStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		s, err := NewFilesystem(filepath.Join(t.TempDir(), "objects"), testBaseURL+"/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s Store)
+	}{
+		{"put and get", testPutGet},
+		{"overwrite", testOverwrite},
+		{"missing objects", testMissing},
+		{"delete", testDelete},
+		{"copy and move", testCopyMove},
+		{"content MD5", testContentMD5},
+		{"URLs", testURLs},
+		{"serve HTTP", testServeHTTP},
+		{"multipart", testMultipart},
+		{"multipart wrong key", testMultipartWrongKey},
+		{"multipart missing part", testMultipartMissingPart},
+		{"multipart abort", testMultipartAbort},
+		{"multipart list", testMultipartList},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.run(t, newStore(t))
+		})
+	}
+}
+
+func wantInfo(t *testing.T, what string, got ObjectInfo, data []byte) {
+	t.Helper()
+	md5Sum := md5.Sum(data)
+	shaSum := sha256.Sum256(data)
+	want := ObjectInfo{
+		Size:           int64(len(data)),
+		ETag:           `"` + hex.EncodeToString(md5Sum[:]) + `"`,
+		ChecksumSHA256: base64.StdEncoding.EncodeToString(shaSum[:]),
+	}
+	if got != want {
+		t.Errorf("%s = %+v, want %+v", what, got, want)
+	}
+}
+
+func readObject(t *testing.T, s Store, key string) []byte {
+	t.Helper()
+	r, err := s.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func putObject(t *testing.T, s Store, key, body string) {
+	t.Helper()
+	if _, err := s.Put(context.Background(), key, strings.NewReader(body), PutOptions{}); err != nil {
+		t.Fatalf("Put(%q): %v", key, err)
+	}
+}
+
+func testPutGet(t *testing.T, s Store) {
+	ctx := context.Background()
+	data := []byte("not really a video")
+	for _, key := range []string{"video.mp4", "landscape/2024/01/02/nested.mp4"} {
+		info, err := s.Put(ctx, key, bytes.NewReader(data), PutOptions{ContentType: "video/mp4"})
+		if err != nil {
+			t.Fatalf("Put(%q): %v", key, err)
+		}
+		wantInfo(t, "Put", info, data)
+		stat, err := s.Stat(ctx, key)
+		if err != nil {
+			t.Fatalf("Stat(%q): %v", key, err)
+		}
+		wantInfo(t, "Stat", stat, data)
+		if got := readObject(t, s, key); !bytes.Equal(got, data) {
+			t.Errorf("Get(%q) = %q, want %q", key, got, data)
+		}
+	}
+
+	info, err := s.Put(ctx, "empty.mp4", bytes.NewReader(nil), PutOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantInfo(t, "Put of an empty object", info, nil)
+}
+
+func testOverwrite(t *testing.T, s Store) {
+	putObject(t, s, "video.mp4", "first upload")
+	putObject(t, s, "video.mp4", "second")
+	if got := readObject(t, s, "video.mp4"); string(got) != "second" {
+		t.Errorf("Get after overwrite = %q, want %q", got, "second")
+	}
+	info, err := s.Stat(context.Background(), "video.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantInfo(t, "Stat after overwrite", info, []byte("second"))
+}
+
+func testMissing(t *testing.T, s Store) {
+	ctx := context.Background()
+	if _, err := s.Stat(ctx, "missing.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.Get(ctx, "missing.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get: got %v, want ErrNotFound", err)
+	}
+	if err := s.Copy(ctx, "missing.mp4", "copy.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Copy: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.Stat(ctx, "copy.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("failed Copy left an object behind: %v", err)
+	}
+}
+
+func testDelete(t *testing.T, s Store) {
+	ctx := context.Background()
+	putObject(t, s, "a/video.mp4", "data")
+	if err := s.Delete(ctx, "a/video.mp4"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Stat(ctx, "a/video.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat after Delete: got %v, want ErrNotFound", err)
+	}
+	// Deleting is idempotent, as it is in S3.
+	if err := s.Delete(ctx, "a/video.mp4"); err != nil {
+		t.Errorf("second Delete: %v", err)
+	}
+	if err := s.Delete(ctx, "never-existed.mp4"); err != nil {
+		t.Errorf("Delete of a missing object: %v", err)
+	}
+}
+
+func testCopyMove(t *testing.T, s Store) {
+	ctx := context.Background()
+	putObject(t, s, "src.mp4", "original")
+	if err := s.Copy(ctx, "src.mp4", "dir/copy.mp4"); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if got := readObject(t, s, "dir/copy.mp4"); string(got) != "original" {
+		t.Errorf("copy = %q, want %q", got, "original")
+	}
+	if got := readObject(t, s, "src.mp4"); string(got) != "original" {
+		t.Errorf("source after Copy = %q, want %q", got, "original")
+	}
+	// The copy doesn't follow later writes to the source.
+	putObject(t, s, "src.mp4", "changed")
+	if got := readObject(t, s, "dir/copy.mp4"); string(got) != "original" {
+		t.Errorf("copy after overwriting the source = %q, want %q", got, "original")
+	}
+
+	if err := Move(ctx, s, "src.mp4", "moved.mp4"); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	if got := readObject(t, s, "moved.mp4"); string(got) != "changed" {
+		t.Errorf("moved = %q, want %q", got, "changed")
+	}
+	if _, err := s.Stat(ctx, "src.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("source after Move: got %v, want ErrNotFound", err)
+	}
+}
+
+func testContentMD5(t *testing.T, s Store) {
+	ctx := context.Background()
+	data := []byte("checked upload")
+	sum := md5.Sum(data)
+	good := base64.StdEncoding.EncodeToString(sum[:])
+	bad := base64.StdEncoding.EncodeToString(make([]byte, md5.Size))
+
+	if _, err := s.Put(ctx, "bad.mp4", bytes.NewReader(data), PutOptions{ContentMD5: bad}); !errors.Is(err, ErrBadDigest) {
+		t.Errorf("Put with a wrong MD5: got %v, want ErrBadDigest", err)
+	}
+	if _, err := s.Stat(ctx, "bad.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("object with a wrong MD5 was kept: %v", err)
+	}
+	// A rejected overwrite leaves the existing object alone.
+	putObject(t, s, "kept.mp4", "existing")
+	if _, err := s.Put(ctx, "kept.mp4", bytes.NewReader(data), PutOptions{ContentMD5: bad}); !errors.Is(err, ErrBadDigest) {
+		t.Errorf("overwrite with a wrong MD5: got %v, want ErrBadDigest", err)
+	}
+	if got := readObject(t, s, "kept.mp4"); string(got) != "existing" {
+		t.Errorf("object after a rejected overwrite = %q, want %q", got, "existing")
+	}
+	if _, err := s.Put(ctx, "good.mp4", bytes.NewReader(data), PutOptions{ContentMD5: good}); err != nil {
+		t.Errorf("Put with the right MD5: %v", err)
+	}
+}
+
+func testURLs(t *testing.T, s Store) {
+	if got, want := s.URL("landscape/video.mp4"), testBaseURL+"/landscape/video.mp4"; got != want {
+		t.Errorf("URL = %q, want %q", got, want)
+	}
+	ctx := context.Background()
+	if _, err := s.SignedURL("video.mp4", SignOptions{Expires: time.Now().Add(time.Hour)}); !errors.Is(err, ErrSigningNotSupported) {
+		t.Errorf("SignedURL: got %v, want ErrSigningNotSupported", err)
+	}
+	if _, err := s.PresignGet(ctx, "video.mp4", PresignGetOptions{Expires: time.Hour}); !errors.Is(err, ErrPresignNotSupported) {
+		t.Errorf("PresignGet: got %v, want ErrPresignNotSupported", err)
+	}
+	if _, err := s.PresignPost(ctx, "video.mp4", PresignPostOptions{Expires: time.Hour}); !errors.Is(err, ErrPresignNotSupported) {
+		t.Errorf("PresignPost: got %v, want ErrPresignNotSupported", err)
+	}
+}
+
+// Both stores serve their own objects, under the prefix their URLs use.
+func testServeHTTP(t *testing.T, s Store) {
+	handler, ok := s.(http.Handler)
+	if !ok {
+		t.Fatalf("%T doesn't serve its objects", s)
+	}
+	data := "caption text"
+	if _, err := s.Put(context.Background(), "captions/en.txt", strings.NewReader(data), PutOptions{ContentType: "text/plain"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/captions/en.txt", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != data {
+		t.Errorf("GET = %d %q, want 200 %q", rec.Code, rec.Body.String(), data)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q, want text/plain", ct)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/captions/en.txt", nil)
+	req.Header.Set("Range", "bytes=0-6")
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != data[:7] {
+		t.Errorf("range GET = %d %q, want 206 %q", rec.Code, rec.Body.String(), data[:7])
+	}
+
+	for _, path := range []string{"/missing.mp4", "/captions", "/../etc/passwd"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path = path
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", path, rec.Code)
+		}
+	}
+}
+
+// uploadParts uploads each body as a part, numbered from 1, and returns
+// them in order.
+func uploadParts(t *testing.T, s Store, key, uploadID string, bodies ...string) []CompletedPart {
+	t.Helper()
+	parts := make([]CompletedPart, len(bodies))
+	// Parts can arrive in any order.
+	for i := len(bodies) - 1; i >= 0; i-- {
+		part, err := s.UploadPart(context.Background(), key, uploadID, int32(i+1), strings.NewReader(bodies[i]), int64(len(bodies[i])), "")
+		if err != nil {
+			t.Fatalf("UploadPart %d: %v", i+1, err)
+		}
+		sum := md5.Sum([]byte(bodies[i]))
+		if want := `"` + hex.EncodeToString(sum[:]) + `"`; part.PartNumber != int32(i+1) || part.ETag != want {
+			t.Errorf("part %d = %+v, want ETag %s", i+1, part, want)
+		}
+		parts[i] = part
+	}
+	return parts
+}
+
+func testMultipart(t *testing.T, s Store) {
+	ctx := context.Background()
+	lister := s.(MultipartLister)
+	key := "uploads/big.mp4"
+	uploadID, err := s.CreateMultipart(ctx, key, PutOptions{ContentType: "video/mp4"})
+	if err != nil {
+		t.Fatalf("CreateMultipart: %v", err)
+	}
+	if _, err := s.Stat(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("object exists before CompleteMultipart: %v", err)
+	}
+
+	bodies := []string{strings.Repeat("a", 100), strings.Repeat("b", 50), "c"}
+	parts := uploadParts(t, s, key, uploadID, bodies...)
+	if n, size, err := lister.MultipartParts(ctx, key, uploadID); err != nil || n != 3 || size != 151 {
+		t.Errorf("MultipartParts = %d, %d, %v, want 3, 151", n, size, err)
+	}
+	// Uploading a part again replaces it.
+	parts[2] = uploadParts(t, s, key, uploadID, bodies[0], bodies[1], "C")[2]
+	if n, _, err := lister.MultipartParts(ctx, key, uploadID); err != nil || n != 3 {
+		t.Errorf("MultipartParts after replacing a part = %d, %v, want 3", n, err)
+	}
+
+	sum := md5.Sum([]byte("x"))
+	if _, err := s.UploadPart(ctx, key, uploadID, 4, strings.NewReader("y"), 1, base64.StdEncoding.EncodeToString(sum[:])); !errors.Is(err, ErrBadDigest) {
+		t.Errorf("UploadPart with a wrong MD5: got %v, want ErrBadDigest", err)
+	}
+
+	info, err := s.CompleteMultipart(ctx, key, uploadID, parts)
+	if err != nil {
+		t.Fatalf("CompleteMultipart: %v", err)
+	}
+	want := []byte(bodies[0] + bodies[1] + "C")
+	wantInfo(t, "CompleteMultipart", info, want)
+	if got := readObject(t, s, key); !bytes.Equal(got, want) {
+		t.Errorf("assembled object is %d bytes, want %d", len(got), len(want))
+	}
+	if _, _, err := lister.MultipartParts(ctx, key, uploadID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("upload still pending after CompleteMultipart: %v", err)
+	}
+}
+
+func testMultipartWrongKey(t *testing.T, s Store) {
+	ctx := context.Background()
+	uploadID, err := s.CreateMultipart(ctx, "mine.mp4", PutOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UploadPart(ctx, "other.mp4", uploadID, 1, strings.NewReader("x"), 1, ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UploadPart under another key: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.UploadPart(ctx, "mine.mp4", "no-such-upload", 1, strings.NewReader("x"), 1, ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UploadPart to an unknown upload: got %v, want ErrNotFound", err)
+	}
+	parts := uploadParts(t, s, "mine.mp4", uploadID, "x")
+	if _, err := s.CompleteMultipart(ctx, "other.mp4", uploadID, parts); !errors.Is(err, ErrNotFound) {
+		t.Errorf("CompleteMultipart under another key: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.Stat(ctx, "other.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("CompleteMultipart under another key created it: %v", err)
+	}
+}
+
+func testMultipartMissingPart(t *testing.T, s Store) {
+	ctx := context.Background()
+	uploadID, err := s.CreateMultipart(ctx, "video.mp4", PutOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := uploadParts(t, s, "video.mp4", uploadID, "a", "b")
+	parts = append(parts, CompletedPart{PartNumber: 3, ETag: parts[0].ETag})
+	if _, err := s.CompleteMultipart(ctx, "video.mp4", uploadID, parts); err == nil {
+		t.Error("CompleteMultipart accepted a part that was never uploaded")
+	}
+	if _, err := s.Stat(ctx, "video.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("failed CompleteMultipart created the object: %v", err)
+	}
+	// The upload can still be finished with the parts it has.
+	if _, err := s.CompleteMultipart(ctx, "video.mp4", uploadID, parts[:2]); err != nil {
+		t.Errorf("CompleteMultipart after a failed attempt: %v", err)
+	}
+}
+
+func testMultipartAbort(t *testing.T, s Store) {
+	ctx := context.Background()
+	uploadID, err := s.CreateMultipart(ctx, "video.mp4", PutOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := uploadParts(t, s, "video.mp4", uploadID, "a")
+	if err := s.AbortMultipart(ctx, "video.mp4", uploadID); err != nil {
+		t.Fatalf("AbortMultipart: %v", err)
+	}
+	if _, err := s.CompleteMultipart(ctx, "video.mp4", uploadID, parts); !errors.Is(err, ErrNotFound) {
+		t.Errorf("CompleteMultipart after AbortMultipart: got %v, want ErrNotFound", err)
+	}
+	if _, _, err := s.(MultipartLister).MultipartParts(ctx, "video.mp4", uploadID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("MultipartParts after AbortMultipart: got %v, want ErrNotFound", err)
+	}
+	// Aborting is idempotent, so cleanup can run more than once.
+	if err := s.AbortMultipart(ctx, "video.mp4", uploadID); err != nil {
+		t.Errorf("second AbortMultipart: %v", err)
+	}
+}
+
+func testMultipartList(t *testing.T, s Store) {
+	ctx := context.Background()
+	lister := s.(MultipartLister)
+	uploadID, err := s.CreateMultipart(ctx, "a/video.mp4", PutOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err := s.CreateMultipart(ctx, "b/video.mp4", PutOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CompleteMultipart(ctx, "b/video.mp4", done, uploadParts(t, s, "b/video.mp4", done, "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	uploads, err := lister.ListMultipart(ctx, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("ListMultipart: %v", err)
+	}
+	if len(uploads) != 1 || uploads[0].Key != "a/video.mp4" || uploads[0].UploadID != uploadID || uploads[0].Initiated.IsZero() {
+		t.Errorf("ListMultipart = %+v, want only the pending upload of a/video.mp4", uploads)
+	}
+	uploads, err = lister.ListMultipart(ctx, time.Now().Add(-time.Minute))
+	if err != nil || len(uploads) != 0 {
+		t.Errorf("ListMultipart before the upload started = %+v, %v, want none", uploads, err)
+	}
+}
+
+// The filesystem store turns keys into paths, so it has to keep them
+// inside its root and away from its own bookkeeping.
+func TestFilesystemStoreRejectsUnsafeKeys(t *testing.T) {
+	parent := t.TempDir()
+	s, err := NewFilesystem(filepath.Join(parent, "objects"), testBaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, key := range []string{"../escape.mp4", "a/../../escape.mp4", "/etc/passwd", ".multipart/x", ".hidden.mp4", ""} {
+		if _, err := s.Put(ctx, key, strings.NewReader("x"), PutOptions{}); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+		if _, err := s.CreateMultipart(ctx, key, PutOptions{}); err == nil {
+			t.Errorf("CreateMultipart(%q) succeeded", key)
+		}
+	}
+	if _, err := s.UploadPart(ctx, "video.mp4", "../../x", 1, strings.NewReader("x"), 1, ""); err == nil {
+		t.Error("UploadPart accepted an upload ID outside the multipart directory")
+	}
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("store wrote outside its root: %v", entries)
+	}
+}
+
+// Put renames a finished temp file into place, so nothing but the object
+// is left next to it.
+func TestFilesystemStoreLeavesNoTempFiles(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "objects")
+	s, err := NewFilesystem(root, testBaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	putObject(t, s, "dir/video.mp4", "data")
+	if _, err := s.Put(ctx, "dir/bad.mp4", strings.NewReader("data"), PutOptions{ContentMD5: base64.StdEncoding.EncodeToString(make([]byte, md5.Size))}); !errors.Is(err, ErrBadDigest) {
+		t.Fatalf("got %v, want ErrBadDigest", err)
+	}
+	entries, err := os.ReadDir(filepath.Join(root, "dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "video.mp4" {
+		names := []string{}
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("dir holds %v, want only video.mp4", names)
+	}
+}
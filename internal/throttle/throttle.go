@@ -0,0 +1,206 @@
+// Package throttle limits how fast bytes move through readers and writers
+// using token buckets that can be shared between connections.
+package throttle
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// chunkSize caps how much is read or written per bucket wait, so a large
+// Write doesn't stall for seconds and then burst.
+const chunkSize = 32 * 1024
+
+// Bucket is a token bucket refilled at a fixed number of bytes per
+// second. It holds at most one second's worth of tokens. A nil Bucket
+// doesn't limit anything.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func NewBucket(bytesPerSecond int64) *Bucket {
+	return &Bucket{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// SetRate changes the refill rate, keeping the tokens already banked.
+func (b *Bucket) SetRate(bytesPerSecond int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.rate = float64(bytesPerSecond)
+}
+
+func (b *Bucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+}
+
+// idleSince reports how long ago the bucket was last drawn from.
+func (b *Bucket) idleSince(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return now.Sub(b.last)
+}
+
+// Wait takes n tokens, blocking until the bucket has paid them back or
+// ctx is done. Callers that share a bucket queue up behind each other's
+// debt, which keeps their combined rate at the limit.
+func (b *Bucket) Wait(ctx context.Context, n int) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	b.refill(time.Now())
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Allow takes n tokens if the bucket has them banked, without waiting.
+// Otherwise it takes nothing and reports how long until it would have
+// them.
+func (b *Bucket) Allow(n int) (bool, time.Duration) {
+	if b == nil {
+		return true, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if b.tokens >= float64(n) {
+		b.tokens -= float64(n)
+		return true, 0
+	}
+	return false, time.Duration((float64(n) - b.tokens) / b.rate * float64(time.Second))
+}
+
+func waitAll(ctx context.Context, buckets []*Bucket, n int) error {
+	for _, b := range buckets {
+		if err := b.Wait(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type reader struct {
+	ctx     context.Context
+	r       io.Reader
+	buckets []*Bucket
+}
+
+// NewReader returns a reader that doesn't read from r faster than any of
+// the buckets allow.
+func NewReader(ctx context.Context, r io.Reader, buckets ...*Bucket) io.Reader {
+	return &reader{ctx: ctx, r: r, buckets: buckets}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := waitAll(r.ctx, r.buckets, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+type writer struct {
+	ctx     context.Context
+	w       io.Writer
+	buckets []*Bucket
+}
+
+// NewWriter returns a writer that doesn't write to w faster than any of
+// the buckets allow.
+func NewWriter(ctx context.Context, w io.Writer, buckets ...*Bucket) io.Writer {
+	return &writer{ctx: ctx, w: w, buckets: buckets}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), chunkSize)]
+		if err := waitAll(w.ctx, w.buckets, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Registry hands out one shared bucket per key, e.g. per user, and forgets
+// buckets that haven't been used for a while.
+type Registry struct {
+	mu        sync.Mutex
+	idle      time.Duration
+	buckets   map[string]*Bucket
+	lastSweep time.Time
+}
+
+func NewRegistry(idle time.Duration) *Registry {
+	return &Registry{idle: idle, buckets: map[string]*Bucket{}}
+}
+
+// Bucket returns the bucket for key, refilling at bytesPerSecond. It
+// returns nil, meaning unlimited, when bytesPerSecond isn't positive.
+func (r *Registry) Bucket(key string, bytesPerSecond int64) *Bucket {
+	if r == nil || bytesPerSecond <= 0 {
+		return nil
+	}
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.lastSweep) > r.idle {
+		for k, b := range r.buckets {
+			if b.idleSince(now) > r.idle {
+				delete(r.buckets, k)
+			}
+		}
+		r.lastSweep = now
+	}
+
+	b, ok := r.buckets[key]
+	if !ok {
+		b = NewBucket(bytesPerSecond)
+		r.buckets[key] = b
+	} else {
+		b.SetRate(bytesPerSecond)
+	}
+	return b
+}
@@ -0,0 +1,455 @@
+// Package toml parses the subset of TOML used by the server's config file:
+// tables, arrays of tables, dotted keys, strings, integers, floats,
+// booleans, arrays and inline tables. Dates and multi-line strings aren't
+// supported.
+package toml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Parse decodes a document into nested maps. Values are string, int64,
+// float64, bool, []any or map[string]any.
+func Parse(data []byte) (map[string]any, error) {
+	p := &parser{src: string(data), line: 1}
+	root := map[string]any{}
+	if err := p.document(root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// Error is a syntax error, with the line it was found on.
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+type parser struct {
+	src  string
+	pos  int
+	line int
+	// defined holds the paths of the tables opened by a [header], so they
+	// can't be opened twice.
+	defined map[string]bool
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &Error{Line: p.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) peek() byte {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+// skipSpace skips blanks and, when newlines is set, line breaks and
+// comments too.
+func (p *parser) skipSpace(newlines bool) {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == '\n' && newlines:
+			p.pos++
+			p.line++
+		default:
+			return
+		}
+	}
+}
+
+// endLine expects nothing but a comment before the end of the line.
+func (p *parser) endLine() error {
+	p.skipSpace(false)
+	switch p.peek() {
+	case 0:
+		return nil
+	case '\n':
+		p.pos++
+		p.line++
+		return nil
+	}
+	return p.errorf("unexpected %q after value", p.peek())
+}
+
+func (p *parser) document(root map[string]any) error {
+	current := root
+	for {
+		p.skipSpace(true)
+		if p.pos >= len(p.src) {
+			return nil
+		}
+		if p.peek() == '[' {
+			table, err := p.header(root)
+			if err != nil {
+				return err
+			}
+			current = table
+		} else if err := p.keyValue(current); err != nil {
+			return err
+		}
+		if err := p.endLine(); err != nil {
+			return err
+		}
+	}
+}
+
+// header parses [a.b] or [[a.b]] and returns the table it opens.
+func (p *parser) header(root map[string]any) (map[string]any, error) {
+	p.pos++
+	array := p.peek() == '['
+	if array {
+		p.pos++
+	}
+	p.skipSpace(false)
+	keys, err := p.key()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace(false)
+	closing := "]"
+	if array {
+		closing = "]]"
+	}
+	if !strings.HasPrefix(p.src[p.pos:], closing) {
+		return nil, p.errorf("expected %q to close the table header", closing)
+	}
+	p.pos += len(closing)
+
+	parent, err := p.walk(root, keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+	if p.defined == nil {
+		p.defined = map[string]bool{}
+	}
+	path := strings.Join(keys, ".")
+	last := keys[len(keys)-1]
+	if array {
+		// Each element starts afresh, so its sub-tables can be opened again.
+		for k := range p.defined {
+			if strings.HasPrefix(k, path+".") {
+				delete(p.defined, k)
+			}
+		}
+		var list []any
+		switch v := parent[last].(type) {
+		case nil:
+		case []any:
+			if len(v) == 0 {
+				return nil, p.errorf("%s is already defined as an array", last)
+			}
+			if _, ok := v[0].(map[string]any); !ok {
+				return nil, p.errorf("%s is already defined as an array", last)
+			}
+			list = v
+		default:
+			return nil, p.errorf("%s is already defined", last)
+		}
+		table := map[string]any{}
+		parent[last] = append(list, table)
+		return table, nil
+	}
+
+	if p.defined[path] {
+		return nil, p.errorf("table %s is defined twice", path)
+	}
+	p.defined[path] = true
+	switch v := parent[last].(type) {
+	case nil:
+		table := map[string]any{}
+		parent[last] = table
+		return table, nil
+	case map[string]any:
+		return v, nil
+	default:
+		return nil, p.errorf("%s is already defined", last)
+	}
+}
+
+// walk returns the table at keys under root, creating missing ones. Going
+// through an array of tables picks its last element.
+func (p *parser) walk(root map[string]any, keys []string) (map[string]any, error) {
+	table := root
+	for _, k := range keys {
+		switch v := table[k].(type) {
+		case nil:
+			next := map[string]any{}
+			table[k] = next
+			table = next
+		case map[string]any:
+			table = v
+		case []any:
+			if len(v) == 0 {
+				return nil, p.errorf("%s is not a table", k)
+			}
+			last, ok := v[len(v)-1].(map[string]any)
+			if !ok {
+				return nil, p.errorf("%s is not a table", k)
+			}
+			table = last
+		default:
+			return nil, p.errorf("%s is not a table", k)
+		}
+	}
+	return table, nil
+}
+
+// key parses a possibly dotted key.
+func (p *parser) key() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace(false)
+		var k string
+		switch c := p.peek(); {
+		case c == '"':
+			s, err := p.basicString()
+			if err != nil {
+				return nil, err
+			}
+			k = s
+		case c == '\'':
+			s, err := p.literalString()
+			if err != nil {
+				return nil, err
+			}
+			k = s
+		default:
+			start := p.pos
+			for p.pos < len(p.src) && isBareKeyChar(p.src[p.pos]) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, p.errorf("expected a key")
+			}
+			k = p.src[start:p.pos]
+		}
+		keys = append(keys, k)
+		p.skipSpace(false)
+		if p.peek() != '.' {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *parser) keyValue(table map[string]any) error {
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	if p.peek() != '=' {
+		return p.errorf("expected '=' after %s", strings.Join(keys, "."))
+	}
+	p.pos++
+	p.skipSpace(false)
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+	parent, err := p.walk(table, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, ok := parent[last]; ok {
+		return p.errorf("%s is defined twice", strings.Join(keys, "."))
+	}
+	parent[last] = value
+	return nil
+}
+
+func (p *parser) value() (any, error) {
+	switch c := p.peek(); {
+	case c == '"':
+		return p.basicString()
+	case c == '\'':
+		return p.literalString()
+	case c == '[':
+		return p.array()
+	case c == '{':
+		return p.inlineTable()
+	case strings.HasPrefix(p.src[p.pos:], "true"):
+		p.pos += len("true")
+		return true, nil
+	case strings.HasPrefix(p.src[p.pos:], "false"):
+		p.pos += len("false")
+		return false, nil
+	case c == '+' || c == '-' || c >= '0' && c <= '9' || c == 'i' || c == 'n':
+		return p.number()
+	case c == 0 || c == '\n':
+		return nil, p.errorf("expected a value")
+	}
+	return nil, p.errorf("unexpected %q", p.peek())
+}
+
+func (p *parser) number() (any, error) {
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == ',' || c == ']' || c == '}' || c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '#' {
+			break
+		}
+		p.pos++
+	}
+	raw := p.src[start:p.pos]
+	clean := strings.ReplaceAll(raw, "_", "")
+	unsigned := strings.TrimLeft(clean, "+-")
+	if unsigned == "inf" || unsigned == "nan" {
+		return strconv.ParseFloat(clean, 64)
+	}
+	if strings.ContainsAny(clean, ".eE") && !strings.HasPrefix(unsigned, "0x") {
+		f, err := strconv.ParseFloat(clean, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", raw)
+		}
+		return f, nil
+	}
+	// Base 0 handles the 0x, 0o and 0b prefixes, but would read a leading
+	// zero as octal, which TOML doesn't allow.
+	if len(unsigned) > 1 && unsigned[0] == '0' && unsigned[1] >= '0' && unsigned[1] <= '9' {
+		return nil, p.errorf("invalid number %q", raw)
+	}
+	n, err := strconv.ParseInt(clean, 0, 64)
+	if err != nil {
+		return nil, p.errorf("invalid number %q", raw)
+	}
+	return n, nil
+}
+
+func (p *parser) basicString() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.src[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.pos >= len(p.src) {
+				return "", p.errorf("unterminated string")
+			}
+			e := p.src[p.pos]
+			p.pos++
+			switch e {
+			case 'b':
+				b.WriteByte('\b')
+			case 't':
+				b.WriteByte('\t')
+			case 'n':
+				b.WriteByte('\n')
+			case 'f':
+				b.WriteByte('\f')
+			case 'r':
+				b.WriteByte('\r')
+			case '"', '\\':
+				b.WriteByte(e)
+			case 'u', 'U':
+				n := 4
+				if e == 'U' {
+					n = 8
+				}
+				if p.pos+n > len(p.src) {
+					return "", p.errorf("invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(code)) {
+					return "", p.errorf("invalid unicode escape")
+				}
+				p.pos += n
+				b.WriteRune(rune(code))
+			default:
+				return "", p.errorf("invalid escape \\%c", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func (p *parser) literalString() (string, error) {
+	p.pos++
+	end := strings.IndexAny(p.src[p.pos:], "'\n")
+	if end < 0 || p.src[p.pos+end] != '\'' {
+		return "", p.errorf("unterminated string")
+	}
+	s := p.src[p.pos : p.pos+end]
+	p.pos += end + 1
+	return s, nil
+}
+
+// array parses [v, ...], which may span lines and end with a comma.
+func (p *parser) array() ([]any, error) {
+	p.pos++
+	values := []any{}
+	for {
+		p.skipSpace(true)
+		if p.peek() == ']' {
+			p.pos++
+			return values, nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		p.skipSpace(true)
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+			p.pos++
+			return values, nil
+		default:
+			return nil, p.errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+// inlineTable parses {k = v, ...} on a single line.
+func (p *parser) inlineTable() (map[string]any, error) {
+	p.pos++
+	table := map[string]any{}
+	p.skipSpace(false)
+	if p.peek() == '}' {
+		p.pos++
+		return table, nil
+	}
+	for {
+		if err := p.keyValue(table); err != nil {
+			return nil, err
+		}
+		p.skipSpace(false)
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return table, nil
+		default:
+			return nil, p.errorf("expected ',' or '}' in inline table")
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package toml
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name string
+		doc  string
+		want map[string]any
+	}{
+		{
+			name: "empty",
+			doc:  "",
+			want: map[string]any{},
+		},
+		{
+			name: "comments and blank lines",
+			doc:  "# header\n\n  # indented\r\nport = 8091 # trailing\n\n",
+			want: map[string]any{"port": int64(8091)},
+		},
+		{
+			name: "scalars",
+			doc: `
+s = "text"
+lit = 'C:\path'
+yes = true
+no = false
+i = -42
+f = 1.5
+`,
+			want: map[string]any{"s": "text", "lit": `C:\path`, "yes": true, "no": false, "i": int64(-42), "f": 1.5},
+		},
+		{
+			name: "integers",
+			doc:  "plus = +7\nunder = 1_000_000\nhex = 0xff\noct = 0o17\nbin = 0b101\nzero = 0\n",
+			want: map[string]any{"plus": int64(7), "under": int64(1000000), "hex": int64(255), "oct": int64(15), "bin": int64(5), "zero": int64(0)},
+		},
+		{
+			name: "floats",
+			doc:  "exp = 5e+2\nneg = -0.25\nunder = 1_000.5\ninf = inf\nninf = -inf\n",
+			want: map[string]any{"exp": 500.0, "neg": -0.25, "under": 1000.5, "inf": math.Inf(1), "ninf": math.Inf(-1)},
+		},
+		{
+			name: "string escapes",
+			doc:  `s = "tab\there \"quoted\" back\\slash \u00e9 \U0001F600 nl\n"`,
+			want: map[string]any{"s": "tab\there \"quoted\" back\\slash é 😀 nl\n"},
+		},
+		{
+			name: "unicode in strings",
+			doc:  "s = \"Grüße 東京\"\n",
+			want: map[string]any{"s": "Grüße 東京"},
+		},
+		{
+			name: "hash inside a string",
+			doc:  `color = "#fff" # comment`,
+			want: map[string]any{"color": "#fff"},
+		},
+		{
+			name: "tables",
+			doc: `
+[server]
+port = 8091
+
+[server.tls]
+cert = "a.pem"
+
+[storage]
+bucket = "tubely"
+`,
+			want: map[string]any{
+				"server":  map[string]any{"port": int64(8091), "tls": map[string]any{"cert": "a.pem"}},
+				"storage": map[string]any{"bucket": "tubely"},
+			},
+		},
+		{
+			name: "dotted and quoted keys",
+			doc:  "a.b.c = 1\na.d = 2\n\"x.y\" = 3\n'lit key' = 4\nsite . name = \"t\"\n",
+			want: map[string]any{
+				"a":       map[string]any{"b": map[string]any{"c": int64(1)}, "d": int64(2)},
+				"x.y":     int64(3),
+				"lit key": int64(4),
+				"site":    map[string]any{"name": "t"},
+			},
+		},
+		{
+			name: "super-table after sub-table",
+			doc:  "[a.b]\nx = 1\n[a]\ny = 2\n",
+			want: map[string]any{"a": map[string]any{"b": map[string]any{"x": int64(1)}, "y": int64(2)}},
+		},
+		{
+			name: "arrays",
+			doc: `
+empty = []
+nums = [1, 2, 3]
+mixed = ["a", 1, true]
+nested = [[1, 2], ["x"]]
+multiline = [
+  "one", # first
+  "two",
+]
+`,
+			want: map[string]any{
+				"empty":     []any{},
+				"nums":      []any{int64(1), int64(2), int64(3)},
+				"mixed":     []any{"a", int64(1), true},
+				"nested":    []any{[]any{int64(1), int64(2)}, []any{"x"}},
+				"multiline": []any{"one", "two"},
+			},
+		},
+		{
+			name: "inline tables",
+			doc:  "empty = {}\npoint = { x = 1, y = 2 }\nnested = { a.b = \"c\", list = [1] }\n",
+			want: map[string]any{
+				"empty":  map[string]any{},
+				"point":  map[string]any{"x": int64(1), "y": int64(2)},
+				"nested": map[string]any{"a": map[string]any{"b": "c"}, "list": []any{int64(1)}},
+			},
+		},
+		{
+			name: "arrays of tables",
+			doc: `
+[[webhooks]]
+url = "https://a.example"
+
+[[webhooks]]
+url = "https://b.example"
+events = ["video.created"]
+
+[webhooks.retry]
+max = 3
+`,
+			want: map[string]any{
+				"webhooks": []any{
+					map[string]any{"url": "https://a.example"},
+					map[string]any{
+						"url":    "https://b.example",
+						"events": []any{"video.created"},
+						"retry":  map[string]any{"max": int64(3)},
+					},
+				},
+			},
+		},
+		{
+			name: "sub-table reopened in each array element",
+			doc:  "[[p]]\n[p.q]\nx = 1\n[[p]]\n[p.q]\nx = 2\n",
+			want: map[string]any{"p": []any{
+				map[string]any{"q": map[string]any{"x": int64(1)}},
+				map[string]any{"q": map[string]any{"x": int64(2)}},
+			}},
+		},
+		{
+			name: "nested arrays of tables",
+			doc:  "[[a]]\n[[a.b]]\nx = 1\n[[a.b]]\nx = 2\n",
+			want: map[string]any{"a": []any{
+				map[string]any{"b": []any{map[string]any{"x": int64(1)}, map[string]any{"x": int64(2)}}},
+			}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Parse([]byte(tc.doc))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Parse = %#v\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseNaN(t *testing.T) {
+	got, err := Parse([]byte("n = nan\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := got["n"].(float64); !ok || !math.IsNaN(f) {
+		t.Errorf("n = %#v, want NaN", got["n"])
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		doc  string
+		line int
+	}{
+		{"missing equals", "key 1", 1},
+		{"missing value", "key =", 1},
+		{"missing value before newline", "a = 1\nkey =\n", 2},
+		{"empty key", "= 1", 1},
+		{"junk after value", "a = 1 2", 1},
+		{"junk after bool", "a = trueish", 1},
+		{"bare word value", "a = yes", 1},
+		{"duplicate key", "a = 1\na = 2", 2},
+		{"duplicate dotted key", "a.b = 1\na.b = 2", 2},
+		{"duplicate table", "[a]\n[a]", 2},
+		{"table over value", "a = 1\n[a]", 2},
+		{"key through value", "a = 1\na.b = 2", 2},
+		{"array of tables over array", "a = [1]\n[[a]]", 2},
+		{"array of tables over table", "[a]\n[[a]]", 2},
+		{"unclosed header", "[a", 1},
+		{"unclosed array header", "[[a]", 1},
+		{"junk after header", "[a] b", 1},
+		{"unterminated string", `a = "abc`, 1},
+		{"string across lines", "a = \"abc\ndef\"", 1},
+		{"unterminated literal", "a = 'abc", 1},
+		{"bad escape", `a = "\q"`, 1},
+		{"short unicode escape", `a = "\u12"`, 1},
+		{"surrogate escape", `a = "\uD800"`, 1},
+		{"leading zero", "a = 012", 1},
+		{"bad number", "a = 1.2.3", 1},
+		{"bad hex", "a = 0xzz", 1},
+		{"unclosed array", "a = [1, 2", 1},
+		{"array missing comma", "a = [1 2]", 1},
+		{"unclosed inline table", "a = { x = 1", 1},
+		{"inline table missing comma", "a = { x = 1 y = 2 }", 1},
+		{"duplicate inline key", "a = { x = 1, x = 2 }", 1},
+		{"error line counts comments", "# one\n# two\n\na = ", 4},
+		{"error line counts multiline arrays", "a = [\n1,\n2,\n]\nb = ?", 5},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse([]byte(tc.doc))
+			var syntaxErr *Error
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Parse(%q) = %v, want a syntax error", tc.doc, err)
+			}
+			if syntaxErr.Line != tc.line {
+				t.Errorf("Parse(%q) failed on line %d (%v), want line %d", tc.doc, syntaxErr.Line, err, tc.line)
+			}
+		})
+	}
+}
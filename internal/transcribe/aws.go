@@ -0,0 +1,184 @@
+package transcribe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/awsjson"
+	"github.com/google/uuid"
+)
+
+// AWSTranscribe runs batch jobs on Amazon Transcribe. Transcribe only
+// reads media from S3, so the audio is staged there first.
+type AWSTranscribe struct {
+	Config aws.Config
+	Client *http.Client
+	// Stage uploads the file at path where Transcribe can read it and
+	// returns its s3:// URI along with a func that removes it again.
+	Stage        func(ctx context.Context, path string) (string, func(), error)
+	PollInterval time.Duration
+}
+
+func (t *AWSTranscribe) Name() string {
+	return "aws-transcribe"
+}
+
+type transcriptionJob struct {
+	TranscriptionJobStatus string
+	FailureReason          string
+	LanguageCode           string
+	Transcript             struct {
+		TranscriptFileUri string
+	}
+}
+
+func (t *AWSTranscribe) Transcribe(ctx context.Context, path, language string) (Transcript, error) {
+	uri, cleanup, err := t.Stage(ctx, path)
+	if err != nil {
+		return Transcript{}, fmt.Errorf("couldn't stage audio: %w", err)
+	}
+	defer cleanup()
+
+	name := "tubely-" + uuid.NewString()
+	start := map[string]any{
+		"TranscriptionJobName": name,
+		"Media":                map[string]string{"MediaFileUri": uri},
+		"MediaFormat":          "wav",
+	}
+	if language != "" {
+		start["LanguageCode"] = language
+	} else {
+		start["IdentifyLanguage"] = true
+	}
+	if err := t.call(ctx, "StartTranscriptionJob", start, nil); err != nil {
+		return Transcript{}, err
+	}
+	defer t.call(context.WithoutCancel(ctx), "DeleteTranscriptionJob", map[string]string{"TranscriptionJobName": name}, nil)
+
+	interval := t.PollInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	var job transcriptionJob
+	for {
+		var out struct {
+			TranscriptionJob transcriptionJob
+		}
+		if err := t.call(ctx, "GetTranscriptionJob", map[string]string{"TranscriptionJobName": name}, &out); err != nil {
+			return Transcript{}, err
+		}
+		job = out.TranscriptionJob
+		if job.TranscriptionJobStatus == "COMPLETED" {
+			break
+		}
+		if job.TranscriptionJobStatus == "FAILED" {
+			return Transcript{}, fmt.Errorf("transcribe: job failed: %s", job.FailureReason)
+		}
+		select {
+		case <-ctx.Done():
+			return Transcript{}, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+
+	segments, err := t.fetchSegments(ctx, job.Transcript.TranscriptFileUri)
+	if err != nil {
+		return Transcript{}, err
+	}
+	return Transcript{Language: job.LanguageCode, Segments: segments}, nil
+}
+
+func (t *AWSTranscribe) call(ctx context.Context, action string, in, out any) error {
+	if err := awsjson.Call(ctx, t.Client, t.Config, awsjson.Transcribe, "Transcribe."+action, in, out); err != nil {
+		return fmt.Errorf("transcribe: %s: %w", action, err)
+	}
+	return nil
+}
+
+// Segments are cut at the end of a sentence or once they run this long,
+// so captions stay readable.
+const (
+	maxSegmentSeconds = 6.0
+	maxSegmentChars   = 84
+)
+
+// fetchSegments downloads the transcript, which comes as a presigned URL,
+// and groups its words into caption sized segments.
+func (t *AWSTranscribe) fetchSegments(ctx context.Context, url string) ([]Segment, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("transcribe: couldn't fetch transcript: %s", resp.Status)
+	}
+
+	var out struct {
+		Results struct {
+			Items []struct {
+				Type         string `json:"type"`
+				StartTime    string `json:"start_time"`
+				EndTime      string `json:"end_time"`
+				Alternatives []struct {
+					Content string `json:"content"`
+				} `json:"alternatives"`
+			} `json:"items"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("transcribe: couldn't decode transcript: %w", err)
+	}
+
+	segments := []Segment{}
+	var current *Segment
+	flush := func() {
+		if current != nil && current.Text != "" {
+			segments = append(segments, *current)
+		}
+		current = nil
+	}
+	for _, item := range out.Results.Items {
+		if len(item.Alternatives) == 0 {
+			continue
+		}
+		content := item.Alternatives[0].Content
+		if item.Type == "punctuation" {
+			if current != nil {
+				current.Text += content
+				if strings.ContainsAny(content, ".?!") {
+					flush()
+				}
+			}
+			continue
+		}
+
+		start, _ := strconv.ParseFloat(item.StartTime, 64)
+		end, _ := strconv.ParseFloat(item.EndTime, 64)
+		if current != nil && (end-current.Start > maxSegmentSeconds || len(current.Text)+len(content) > maxSegmentChars) {
+			flush()
+		}
+		if current == nil {
+			current = &Segment{Start: start, Text: content}
+		} else {
+			current.Text += " " + content
+		}
+		current.End = end
+	}
+	flush()
+	return segments, nil
+}
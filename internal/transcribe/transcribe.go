@@ -0,0 +1,69 @@
+// Package transcribe turns speech in an audio file into timed text and
+// formats it as WebVTT captions.
+package transcribe
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Segment is a stretch of speech, with offsets in seconds.
+type Segment struct {
+	Start float64
+	End   float64
+	Text  string
+}
+
+// Transcript is what a backend heard. Language is a BCP 47 tag such as
+// "en" or "en-US", as detected or as requested.
+type Transcript struct {
+	Language string
+	Segments []Segment
+}
+
+type Transcriber interface {
+	// Name identifies the backend in stored captions.
+	Name() string
+	// Transcribe transcribes the 16 kHz mono WAV file at path. An empty
+	// language asks the backend to detect it.
+	Transcribe(ctx context.Context, path, language string) (Transcript, error)
+}
+
+// Text returns the transcript as plain text for indexing.
+func (t Transcript) Text() string {
+	parts := make([]string, 0, len(t.Segments))
+	for _, s := range t.Segments {
+		parts = append(parts, s.Text)
+	}
+	return strings.Join(parts, " ")
+}
+
+// WebVTT formats the transcript as a WebVTT caption file.
+func (t Transcript) WebVTT() string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for _, s := range t.Segments {
+		text := strings.TrimSpace(s.Text)
+		if text == "" {
+			continue
+		}
+		// A blank line would end the cue early.
+		text = strings.ReplaceAll(text, "\n\n", "\n")
+		text = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+		fmt.Fprintf(&b, "\n%s --> %s\n%s\n", vttTimestamp(s.Start), vttTimestamp(s.End), text)
+	}
+	return b.String()
+}
+
+func vttTimestamp(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second)).Round(time.Millisecond)
+	h := d / time.Hour
+	d -= h * time.Hour
+	m := d / time.Minute
+	d -= m * time.Minute
+	s := d / time.Second
+	d -= s * time.Second
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, d/time.Millisecond)
+}
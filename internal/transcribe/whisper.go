@@ -0,0 +1,91 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Whisper runs a local whisper.cpp binary and reads its JSON output.
+type Whisper struct {
+	// Binary defaults to "whisper-cli".
+	Binary string
+	// Model is the path of the ggml model file.
+	Model   string
+	Threads int
+}
+
+func (w Whisper) Name() string {
+	return "whisper"
+}
+
+func (w Whisper) Transcribe(ctx context.Context, path, language string) (Transcript, error) {
+	binary := w.Binary
+	if binary == "" {
+		binary = "whisper-cli"
+	}
+	if language == "" {
+		language = "auto"
+	}
+	outputBase := path + ".transcript"
+	args := []string{
+		"-m", w.Model,
+		"-f", path,
+		"-l", language,
+		"-oj",
+		"-of", outputBase,
+		"-np",
+	}
+	if w.Threads > 0 {
+		args = append(args, "-t", fmt.Sprint(w.Threads))
+	}
+	cmd := exec.CommandContext(ctx, binary, args...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := bytes.TrimSpace(stderr.Bytes())
+		if i := bytes.LastIndexByte(msg, '\n'); i >= 0 {
+			msg = msg[i+1:]
+		}
+		return Transcript{}, fmt.Errorf("whisper: %w: %s", err, msg)
+	}
+	defer os.Remove(outputBase + ".json")
+
+	dat, err := os.ReadFile(outputBase + ".json")
+	if err != nil {
+		return Transcript{}, err
+	}
+	var out struct {
+		Result struct {
+			Language string `json:"language"`
+		} `json:"result"`
+		Transcription []struct {
+			Offsets struct {
+				From int64 `json:"from"`
+				To   int64 `json:"to"`
+			} `json:"offsets"`
+			Text string `json:"text"`
+		} `json:"transcription"`
+	}
+	if err := json.Unmarshal(dat, &out); err != nil {
+		return Transcript{}, fmt.Errorf("whisper: couldn't decode output: %w", err)
+	}
+
+	transcript := Transcript{Language: out.Result.Language}
+	if transcript.Language == "" || transcript.Language == "auto" {
+		transcript.Language = language
+	}
+	for _, s := range out.Transcription {
+		transcript.Segments = append(transcript.Segments, Segment{
+			Start: float64(s.Offsets.From) / 1000,
+			End:   float64(s.Offsets.To) / 1000,
+			Text:  strings.TrimSpace(s.Text),
+		})
+	}
+	return transcript, nil
+}
@@ -0,0 +1,5 @@
+// Package tubelypb holds the generated code for the gRPC API defined in
+// proto/tubely/v1/tubely.proto.
+package tubelypb
+
+//go:generate protoc -I ../../proto --go_out=. --go_opt=module=github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tubelypb --go-grpc_out=. --go-grpc_opt=module=github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tubelypb tubely/v1/tubely.proto
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cloudfront"
+)
+
+// newInvalidator returns nil when CLOUDFRONT_DISTRIBUTION_ID isn't set, in
+// which case objects overwritten in place stay cached until their TTL runs
+// out. S3_CF_DISTRO is the distribution's domain, which the API doesn't
+// take.
+func newInvalidator() (*cloudfront.Invalidator, error) {
+	id := os.Getenv("CLOUDFRONT_DISTRIBUTION_ID")
+	if id == "" {
+		return nil, nil
+	}
+	awsConfig, err := loadAWSConfig(context.Background(), "us-east-1")
+	if err != nil {
+		return nil, err
+	}
+	return &cloudfront.Invalidator{
+		Config:         awsConfig,
+		Client:         &http.Client{Timeout: 30 * time.Second},
+		DistributionID: id,
+	}, nil
+}
+
+// invalidateCDN drops the edge cached copies of objects that were just
+// overwritten at the same key. It runs in the background; a failure only
+// means viewers may see the old copy until it expires.
+func (cfg *apiConfig) invalidateCDN(keys ...string) {
+	if cfg.invalidator == nil || len(keys) == 0 {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		id, err := cfg.invalidator.Invalidate(ctx, keys)
+		if err != nil {
+			log.Printf("Couldn't invalidate %s: %v", strings.Join(keys, ", "), err)
+			return
+		}
+		log.Printf("Invalidated %s (%s)", strings.Join(keys, ", "), id)
+	}()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/inventory"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const jobInventoryReconcile = "inventory_reconcile"
+
+// maxReportedObjects caps the orphans and missing objects listed in an
+// inventory report; the counts cover all of them.
+const maxReportedObjects = 1000
+
+// unmanagedPrefixes hold objects no row points at by design: audio on its
+// way to transcription, setup checks and staged uploads. Exports are
+// tracked by their jobs.
+var unmanagedPrefixes = []string{"transcription/", "setup-check/", stagingPrefix}
+
+type inventoryObject struct {
+	Key     string     `json:"key"`
+	Size    int64      `json:"size"`
+	VideoID *uuid.UUID `json:"video_id,omitempty"`
+}
+
+type inventoryReport struct {
+	Manifest           string    `json:"manifest"`
+	InventoryCreatedAt time.Time `json:"inventory_created_at"`
+	Scanned            int64     `json:"scanned"`
+	// Orphans are objects in the bucket that nothing refers to.
+	OrphanCount int               `json:"orphan_count"`
+	OrphanBytes int64             `json:"orphan_bytes"`
+	Orphans     []inventoryObject `json:"orphans"`
+	// Missing are videos whose object isn't in the bucket.
+	MissingCount int               `json:"missing_count"`
+	Missing      []inventoryObject `json:"missing"`
+}
+
+// newInventoryReader returns nil when INVENTORY_BUCKET isn't set.
+// INVENTORY_PREFIX is where the inventory configuration of the video
+// bucket delivers its reports.
+func newInventoryReader(region string) (*inventory.Reader, error) {
+	bucket := os.Getenv("INVENTORY_BUCKET")
+	if bucket == "" {
+		return nil, nil
+	}
+	awsConfig, err := loadAWSConfig(context.Background(), region)
+	if err != nil {
+		return nil, err
+	}
+	return &inventory.Reader{
+		Client: s3.NewFromConfig(awsConfig),
+		Bucket: bucket,
+		Prefix: os.Getenv("INVENTORY_PREFIX"),
+	}, nil
+}
+
+// handlerAdminInventoryReconcile starts a job that compares the latest S3
+// Inventory report of the bucket with the database. It's the way to find
+// orphaned and missing objects in buckets too big to list.
+func (cfg *apiConfig) handlerAdminInventoryReconcile(w http.ResponseWriter, r *http.Request) {
+	if cfg.inventory == nil {
+		respondWithErrorCode(w, http.StatusNotImplemented, errCodeNotImplemented, "INVENTORY_BUCKET isn't configured", nil)
+		return
+	}
+
+	active, err := cfg.db.GetActiveJob(uuid.Nil, jobInventoryReconcile)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check jobs", err)
+		return
+	}
+	if active.ID != uuid.Nil {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Inventory reconcile job %s is still running", active.ID), nil)
+		return
+	}
+
+	job, err := cfg.db.CreateJob(jobInventoryReconcile, uuid.Nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create job", err)
+		return
+	}
+	cfg.startJob(job, func(ctx context.Context) (any, error) {
+		return cfg.reconcileInventory(ctx)
+	})
+
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// reconcileInventory streams the latest inventory report against the keys
+// the database refers to. Videos changed since the report was taken are
+// left out, and objects that look missing are checked in the bucket
+// before they're reported, since the report is up to a day old. Orphans
+// aren't: one deleted since the report was taken is listed until the
+// next report.
+func (cfg *apiConfig) reconcileInventory(ctx context.Context) (inventoryReport, error) {
+	report := inventoryReport{Orphans: []inventoryObject{}, Missing: []inventoryObject{}}
+
+	manifest, err := cfg.inventory.Latest(ctx)
+	if err != nil {
+		return report, err
+	}
+	report.Manifest = manifest.Key
+	report.InventoryCreatedAt = manifest.CreatedAt()
+
+	videos, err := cfg.db.GetStoredVideos()
+	if err != nil {
+		return report, fmt.Errorf("couldn't list videos: %w", err)
+	}
+	expected := make(map[string]database.StoredVideo, len(videos))
+	for _, v := range videos {
+		if v.UpdatedAt.Before(report.InventoryCreatedAt) {
+			expected[v.Key] = v
+		}
+	}
+	keys, err := cfg.db.GetReferencedObjectKeys()
+	if err != nil {
+		return report, fmt.Errorf("couldn't list referenced keys: %w", err)
+	}
+	referenced := make(map[string]bool, len(videos)+len(keys))
+	for _, v := range videos {
+		referenced[v.Key] = true
+	}
+	for _, key := range keys {
+		referenced[key] = true
+	}
+	exports, err := cfg.db.GetUnpurgedJobs(jobExport)
+	if err != nil {
+		return report, fmt.Errorf("couldn't list exports: %w", err)
+	}
+	for _, job := range exports {
+		referenced[exportKey(job.UserID, job.ID)] = true
+	}
+
+	err = cfg.inventory.Walk(ctx, manifest, func(obj inventory.Object) error {
+		if !obj.IsLatest || obj.IsDeleteMarker {
+			return nil
+		}
+		report.Scanned++
+		delete(expected, obj.Key)
+		if referenced[obj.Key] || hasUnmanagedPrefix(obj.Key) {
+			return nil
+		}
+		report.OrphanCount++
+		report.OrphanBytes += obj.Size
+		if len(report.Orphans) < maxReportedObjects {
+			report.Orphans = append(report.Orphans, inventoryObject{Key: obj.Key, Size: obj.Size})
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for key, video := range expected {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+		_, err := cfg.store.Stat(ctx, key)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("inventory_reconcile: couldn't check %s: %v", key, err)
+			continue
+		}
+		report.MissingCount++
+		if len(report.Missing) < maxReportedObjects {
+			report.Missing = append(report.Missing, inventoryObject{Key: key, Size: video.Size, VideoID: &video.VideoID})
+		}
+	}
+
+	log.Printf("inventory_reconcile: %d objects in %s, %d orphaned (%d bytes), %d videos missing",
+		report.Scanned, manifest.Key, report.OrphanCount, report.OrphanBytes, report.MissingCount)
+	return report, nil
+}
+
+func hasUnmanagedPrefix(key string) bool {
+	for _, prefix := range unmanagedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
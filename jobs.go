@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/errreport"
+	"github.com/google/uuid"
+)
+
+// jobFunc does the work of a background job and returns the report stored
+// with it, which may be partial when it also returns an error.
+type jobFunc func(ctx context.Context) (any, error)
+
+// startJob runs fn for a queued job in the background, recording its
+// progress on the job row.
+func (cfg *apiConfig) startJob(job database.Job, fn jobFunc) {
+	go func() {
+		if err := cfg.db.StartJob(job.ID); err != nil {
+			log.Printf("job %s (%s): couldn't mark as running: %v", job.ID, job.Type, err)
+		}
+		report, err := fn(context.Background())
+		if err != nil {
+			log.Printf("job %s (%s) failed: %v", job.ID, job.Type, err)
+			reportError(errreport.Event{
+				Err:     err,
+				Message: fmt.Sprintf("%s job failed", job.Type),
+				Tags:    map[string]string{"job_id": job.ID.String(), "job_type": job.Type},
+				Extra:   map[string]any{"user_id": job.UserID.String()},
+			})
+		}
+		if err := cfg.db.FinishJob(job.ID, report, err); err != nil {
+			log.Printf("job %s (%s): couldn't record result: %v", job.ID, job.Type, err)
+		}
+	}()
+}
+
+func (cfg *apiConfig) handlerJobGet(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid ID", err)
+		return
+	}
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := cfg.db.GetJob(jobID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get job", err)
+		return
+	}
+	if job.ID == uuid.Nil || job.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Job not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, job)
+}
+
+func (cfg *apiConfig) handlerAdminJobGet(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid ID", err)
+		return
+	}
+
+	job, err := cfg.db.GetJob(jobID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get job", err)
+		return
+	}
+	if job.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Job not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, job)
+}
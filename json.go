@@ -4,21 +4,132 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"strings"
 )
 
+// Machine-readable error codes returned alongside the human message.
+const (
+	errCodeBadRequest         = "BAD_REQUEST"
+	errCodeUnauthorized       = "UNAUTHORIZED"
+	errCodeForbidden          = "FORBIDDEN"
+	errCodeNotFound           = "NOT_FOUND"
+	errCodeConflict           = "CONFLICT"
+	errCodePayloadTooLarge    = "PAYLOAD_TOO_LARGE"
+	errCodeInternal           = "INTERNAL_ERROR"
+	errCodeNotImplemented     = "NOT_IMPLEMENTED"
+	errCodeInvalidID          = "INVALID_ID"
+	errCodeInvalidBody        = "INVALID_REQUEST_BODY"
+	errCodeValidation         = "VALIDATION_FAILED"
+	errCodeInvalidMediaType   = "INVALID_MEDIA_TYPE"
+	errCodeVideoTooLarge      = "VIDEO_TOO_LARGE"
+	errCodeVideoCorrupt       = "VIDEO_CORRUPT"
+	errCodeMediaLimitExceeded = "MEDIA_LIMIT_EXCEEDED"
+	errCodeThumbnailTooLarge  = "THUMBNAIL_TOO_LARGE"
+	errCodeThumbnailRejected  = "THUMBNAIL_REJECTED"
+	errCodeImageTooSmall      = "IMAGE_TOO_SMALL"
+	errCodeImageRejected      = "IMAGE_REJECTED"
+	errCodeQuotaExceeded      = "STORAGE_QUOTA_EXCEEDED"
+	errCodeNotOwner           = "NOT_OWNER"
+	errCodeVideoNotFound      = "VIDEO_NOT_FOUND"
+
+	errCodeUploadSessionNotFound = "UPLOAD_SESSION_NOT_FOUND"
+	errCodeUploadSessionExpired  = "UPLOAD_SESSION_EXPIRED"
+	errCodeUploadOffsetMismatch  = "UPLOAD_OFFSET_MISMATCH"
+	errCodeChecksumMismatch      = "CHECKSUM_MISMATCH"
+	errCodeGeoRestricted         = "GEO_RESTRICTED"
+	errCodeHotlinkForbidden      = "HOTLINK_FORBIDDEN"
+	errCodeObjectLocked          = "OBJECT_LOCKED"
+	errCodeVideoArchived         = "VIDEO_ARCHIVED"
+	errCodeAccountSuspended      = "ACCOUNT_SUSPENDED"
+	errCodeVideoChanged          = "VIDEO_CHANGED"
+	errCodeRateLimited           = "RATE_LIMITED"
+	errCodeTimeout               = "TIMEOUT"
+	errCodeUnsupportedAPIVersion = "UNSUPPORTED_API_VERSION"
+)
+
+// exposeErrorDetails controls whether raw internal error strings are sent
+// to clients, over REST, gRPC and GraphQL. It's only meant to be switched
+// on in development; otherwise they're only logged.
+var exposeErrorDetails bool
+
+type errorDetail struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+	Err     error
+	Details []errorDetail
+}
+
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
-	if err != nil {
-		log.Println(err)
+	respondWithAPIError(w, apiError{
+		Status:  code,
+		Code:    defaultErrorCode(code),
+		Message: msg,
+		Err:     err,
+	})
+}
+
+func respondWithErrorCode(w http.ResponseWriter, status int, code, msg string, err error) {
+	respondWithAPIError(w, apiError{
+		Status:  status,
+		Code:    code,
+		Message: msg,
+		Err:     err,
+	})
+}
+
+func respondWithAPIError(w http.ResponseWriter, apiErr apiError) {
+	requestID := w.Header().Get(requestIDHeader)
+	if apiErr.Err != nil {
+		log.Printf("[%s] %v", requestID, apiErr.Err)
 	}
-	if code > 499 {
-		log.Printf("Responding with 5XX error: %s", msg)
+	if apiErr.Status > 499 {
+		log.Printf("[%s] Responding with 5XX error: %s", requestID, apiErr.Message)
+		reportResponseError(w, apiErr)
 	}
 	type errorResponse struct {
-		Error string `json:"error"`
+		Error     string        `json:"error"`
+		Code      string        `json:"code"`
+		RequestID string        `json:"request_id,omitempty"`
+		Details   []errorDetail `json:"details,omitempty"`
+		Debug     string        `json:"debug,omitempty"`
 	}
-	respondWithJSON(w, code, errorResponse{
-		Error: msg,
-	})
+	resp := errorResponse{
+		Error:     apiErr.Message,
+		Code:      apiErr.Code,
+		RequestID: requestID,
+		Details:   apiErr.Details,
+	}
+	if exposeErrorDetails && apiErr.Err != nil {
+		resp.Debug = apiErr.Err.Error()
+	}
+	respondWithJSON(w, apiErr.Status, resp)
+}
+
+func defaultErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return errCodeBadRequest
+	case http.StatusUnauthorized:
+		return errCodeUnauthorized
+	case http.StatusForbidden:
+		return errCodeForbidden
+	case http.StatusNotFound:
+		return errCodeNotFound
+	case http.StatusConflict:
+		return errCodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return errCodePayloadTooLarge
+	}
+	if status > 499 {
+		return errCodeInternal
+	}
+	return strings.ToUpper(strings.ReplaceAll(http.StatusText(status), " ", "_"))
 }
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	neturl "net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// Parts of an object key layout. Prefix parts are laid out in this order,
+// before the kind of upload; naming parts pick the final file name.
+const (
+	keyPartTenant      = "tenant"
+	keyPartUser        = "user"
+	keyPartDate        = "date"
+	keyPartContentHash = "content-hash"
+	keyPartVideoHash   = "video-hash"
+)
+
+// keyStrategy lays out the keys new video objects are stored under. Keys
+// stay where they were written, so changing the layout only affects new
+// uploads.
+type keyStrategy struct {
+	tenant      string
+	byUser      bool
+	byDate      bool
+	contentHash bool
+	videoHash   bool
+}
+
+// objectKeyParams is what a key can be built from. contentHash is the hex
+// SHA-256 of the object and is only known for uploads the server
+// processes; without it content-hash and video-hash naming fall back to a
+// random name.
+type objectKeyParams struct {
+	// kind is the first directory the server has always used: the
+	// aspect ratio for processed videos, or how the upload arrived.
+	kind        string
+	userID      uuid.UUID
+	videoID     uuid.UUID
+	ext         string
+	contentHash string
+	now         time.Time
+}
+
+var validTenant = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]{0,62}$`)
+
+// loadKeyStrategy reads KEY_STRATEGY, a comma separated list of layout
+// parts such as "tenant,user,date". An empty list keeps the flat
+// "<kind>/<random>.<ext>" layout.
+func loadKeyStrategy() (keyStrategy, error) {
+	var s keyStrategy
+	for _, part := range strings.Split(os.Getenv("KEY_STRATEGY"), ",") {
+		switch part = strings.TrimSpace(part); part {
+		case "":
+		case keyPartTenant:
+			s.tenant = os.Getenv("KEY_TENANT")
+			if !validTenant.MatchString(s.tenant) {
+				return s, fmt.Errorf("KEY_TENANT must be set to letters, digits, - and _ for the %s layout", keyPartTenant)
+			}
+		case keyPartUser:
+			s.byUser = true
+		case keyPartDate:
+			s.byDate = true
+		case keyPartContentHash:
+			s.contentHash = true
+		case keyPartVideoHash:
+			s.videoHash = true
+		default:
+			return s, fmt.Errorf("unknown key layout part %q", part)
+		}
+	}
+	if s.contentHash && s.videoHash {
+		return s, fmt.Errorf("%s and %s naming can't be combined", keyPartContentHash, keyPartVideoHash)
+	}
+	return s, nil
+}
+
+func (s keyStrategy) objectKey(p objectKeyParams) string {
+	parts := []string{}
+	if s.tenant != "" {
+		parts = append(parts, "tenants", s.tenant)
+	}
+	if s.byUser {
+		parts = append(parts, "users", p.userID.String())
+	}
+	parts = append(parts, p.kind)
+	if s.byDate {
+		parts = append(parts, p.now.UTC().Format("2006/01/02"))
+	}
+	name := newAssetName()
+	if s.contentHash && p.contentHash != "" {
+		// The first byte spreads hashes over 256 directories, so no
+		// single prefix gets all the traffic.
+		parts = append(parts, p.contentHash[:2])
+		name = p.contentHash
+	}
+	if s.videoHash && p.contentHash != "" {
+		// A retried upload of the same file lands on the same key and
+		// overwrites whatever the failed attempt left behind.
+		name = p.videoID.String() + "-" + p.contentHash[:32]
+	}
+	return path.Join(append(parts, name+p.ext)...)
+}
+
+// versionedURL adds the object's version to its URL when keys are reused
+// for the same video, so a CDN that cached an earlier response for the
+// key, such as an error from a failed attempt, doesn't keep serving it.
+func (s keyStrategy) versionedURL(url string, info storage.ObjectInfo) string {
+	if !s.videoHash {
+		return url
+	}
+	version := cmp.Or(info.VersionID, strings.Trim(info.ETag, `"`))
+	if version == "" {
+		return url
+	}
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	return url + sep + "v=" + neturl.QueryEscape(version)
+}
+
+// deleteVideoObject removes a video object nothing points at anymore.
+// Under content-hash naming identical uploads share an object, so it's
+// kept while another video still uses it. The check runs whatever the
+// current layout is, since objects written under an earlier one stay
+// shared.
+func (cfg *apiConfig) deleteVideoObject(ctx context.Context, key string) {
+	other, err := cfg.db.GetVideoByKey(key)
+	if err != nil {
+		log.Printf("Couldn't check whether video object %s is shared: %v", key, err)
+		return
+	}
+	if other.ID != uuid.Nil {
+		return
+	}
+	if err := cfg.store.Delete(ctx, key); err != nil {
+		log.Printf("Couldn't delete video object %s: %v", key, err)
+		cfg.reportStorageError("delete", key, err)
+	}
+}
+
+// videosSharingKey returns the videos other than videoID whose row points
+// at key. Whatever is done to a content-hash object, deleting, archiving
+// or locking it, is done to every one of them.
+func (cfg *apiConfig) videosSharingKey(key string, videoID uuid.UUID) ([]database.Video, error) {
+	videos, err := cfg.db.GetVideosByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(videos, func(v database.Video) bool { return v.ID == videoID }), nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// schedulerLease is the lease whose holder runs the background tasks that
+// aren't local to an instance.
+const schedulerLease = "scheduler"
+
+// leaderElection makes one of the instances sharing the database the
+// leader, by having each try to take a lease that the leader keeps
+// renewing. When the leader stops, another instance takes over once the
+// lease runs out.
+type leaderElection struct {
+	db     database.Client
+	name   string
+	holder string
+	ttl    time.Duration
+	leader atomic.Bool
+}
+
+// instanceName is INSTANCE_ID, or the host name and port, so an instance
+// restarted in place is recognised as the same one: it gets its lease
+// straight back instead of waiting for it to run out, and fails the jobs
+// the restart cut off.
+func instanceName(port string) string {
+	hostname, _ := os.Hostname()
+	return cmp.Or(os.Getenv("INSTANCE_ID"), hostname+":"+port)
+}
+
+func newLeaderElection(db database.Client, port string) *leaderElection {
+	return &leaderElection{
+		db:     db,
+		name:   schedulerLease,
+		holder: instanceName(port),
+		ttl:    max(time.Second, envDuration("LEADER_LEASE_TTL", 30*time.Second)),
+	}
+}
+
+// IsLeader reports whether this instance held the lease when it last
+// checked.
+func (e *leaderElection) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Run takes or renews the lease every third of its lifetime until ctx is
+// done, then gives it up. The first try is made before Run returns, so
+// the scheduler knows whether it leads from its first tick.
+func (e *leaderElection) Run(ctx context.Context) {
+	e.try()
+	go func() {
+		ticker := time.NewTicker(e.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				if e.leader.Swap(false) {
+					if err := e.db.ReleaseLease(e.name, e.holder); err != nil {
+						log.Printf("Couldn't release the %s lease: %v", e.name, err)
+					}
+				}
+				return
+			case <-ticker.C:
+			}
+			e.try()
+		}
+	}()
+}
+
+func (e *leaderElection) try() {
+	now := time.Now()
+	ok, err := e.db.AcquireLease(e.name, e.holder, now, now.Add(e.ttl))
+	if err != nil {
+		// Another instance may take the lease over once it runs out, so
+		// leading stops at the first failure rather than the last renewal.
+		log.Printf("Couldn't renew the %s lease: %v", e.name, err)
+		ok = false
+	}
+	if was := e.leader.Swap(ok); was != ok {
+		if ok {
+			log.Printf("%s is now the leader and runs the cluster-wide tasks", e.holder)
+		} else {
+			log.Printf("%s is no longer the leader", e.holder)
+		}
+	}
+}
@@ -1,30 +1,107 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"runtime"
+	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cfsign"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cloudfront"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
-
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/events"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/geoip"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/inventory"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/moderation"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/scheduler"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/secrets"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcribe"
+	"github.com/graph-gophers/graphql-go"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
-	db               database.Client
-	jwtSecret        string
-	platform         string
-	filepathRoot     string
-	assetsRoot       string
-	s3Bucket         string
-	s3Region         string
-	s3CfDistribution string
-	port             string
-	s3Client         *s3.Client
+	db        database.Client
+	jwtSecret *secrets.Value
+	// secrets holds the settings fetched from Secrets Manager or SSM.
+	secrets *secretStore
+	// urlSigner signs CloudFront URLs, when a key pair is configured.
+	urlSigner          *cfsign.Signer
+	invalidator        *cloudfront.Invalidator
+	inventory          *inventory.Reader
+	platform           string
+	filepathRoot       string
+	assetsRoot         string
+	s3Bucket           string
+	s3Region           string
+	s3CfDistribution   string
+	port               string
+	store              storage.Store
+	media              media.Processor
+	adminAPIKey        string
+	tempMaxAge         time.Duration
+	presignUploadTTL   time.Duration
+	mediaTypes         mediaRegistry
+	externalBaseURL    string
+	trustProxyHeaders  bool
+	assetsCacheControl string
+	assetsRequireAuth  bool
+	scheduler          *scheduler.Scheduler
+	graphqlSchema      *graphql.Schema
+	slowJobThreshold   time.Duration
+	slowStageThreshold time.Duration
+	uploadSessionTTL   time.Duration
+	playbackPolicies   map[string]playbackPolicy
+	geoLocator         geoip.Locator
+	geoCountryHeader   string
+	hotlink            hotlinkPolicy
+	webhooks           *webhookDispatcher
+	// events feeds the admin event stream.
+	events *events.Hub
+	// processing tracks the video processing jobs running here.
+	processing *processingJobs
+	// domainEvents publishes video lifecycle events to EVENT_PUBLISHER.
+	domainEvents   *domainEvents
+	objectLock     bool
+	maxRetention   time.Duration
+	downloadLimits throttleLimits
+	uploadLimits   throttleLimits
+	moderator      moderation.Moderator
+	moderation     moderationPolicy
+	transcriber    transcribe.Transcriber
+	// Media work runs on separate bounded pools, so a burst of thumbnail
+	// work can't starve video processing or the other way around.
+	videoWorkers         *workerPool
+	thumbnailWorkers     *workerPool
+	renditionWorkers     *workerPool
+	transcriptionWorkers *workerPool
+	thumbnailSizes       []thumbnailSize
+	keys                 keyStrategy
+	objectHeaders        objectHeaders
+	sitemap              *sitemapCache
+	// sitemapPageTemplate is SITEMAP_PAGE_URL.
+	sitemapPageTemplate string
+	// cache holds hot video rows and playback URLs in Redis, when
+	// REDIS_URL is set.
+	cache *appCache
+	// videoLocks keeps uploads and processing runs of the same video from
+	// overlapping, across instances.
+	videoLocks *videoLocks
+	// scratchMode is where raw uploads wait for a worker: scratchModeLocal
+	// or scratchModeStaging.
+	scratchMode string
+	// watermarkImage is WATERMARK_IMAGE, stamped onto videos made with the
+	// watermark setting. Without it the setting can't be turned on.
+	watermarkImage string
 }
 
 type thumbnail struct {
@@ -35,74 +112,244 @@ type thumbnail struct {
 func main() {
 	godotenv.Load(".env")
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
-		log.Fatal("DB_URL must be set")
+	// Optional: settings from a TOML file, under whatever environment
+	// variables are set.
+	var configPresets []configPreset
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		var err error
+		configPresets, err = loadConfigFile(path)
+		if err != nil {
+			log.Fatalf("Couldn't load config file: %v", err)
+		}
+	}
+
+	secretStore, err := loadSecrets(context.Background())
+	if err != nil {
+		log.Fatalf("Couldn't load secrets: %v", err)
+	}
+
+	var problems configProblems
+	pathToDB := problems.required("DB_PATH", secretStore.get("DB_PATH"))
+	jwtSecret := problems.required("JWT_SECRET", secretStore.get("JWT_SECRET"))
+	platform := problems.require("PLATFORM")
+	filepathRoot := problems.require("FILEPATH_ROOT")
+	assetsRoot := problems.require("ASSETS_ROOT")
+	port := problems.require("PORT")
+
+	// Optional: "s3" (default), "filesystem" or "memory". The last two
+	// need no AWS credentials and are meant for development and tests.
+	storageBackend := os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = storageBackendS3
+	}
+
+	var s3Bucket, s3Region, s3CfDistribution string
+	if storageBackend == storageBackendS3 {
+		s3Bucket = problems.require("S3_BUCKET")
+		s3Region = problems.require("S3_REGION")
+		s3CfDistribution = problems.require("S3_CF_DISTRO")
+	} else {
+		s3Bucket = os.Getenv("S3_BUCKET")
+		s3Region = os.Getenv("S3_REGION")
+		s3CfDistribution = os.Getenv("S3_CF_DISTRO")
+	}
+
+	if filepathRoot != "" {
+		if info, err := os.Stat(filepathRoot); err != nil {
+			problems.add("FILEPATH_ROOT: %v", err)
+		} else if !info.IsDir() {
+			problems.add("FILEPATH_ROOT: %s is not a directory", filepathRoot)
+		}
+	}
+	if assetsRoot != "" {
+		problems.writableDir("ASSETS_ROOT", assetsRoot)
+	}
+
+	// Optional: "local" (default) or "staging", which keeps raw uploads
+	// in the store until a worker takes them, for hosts with little disk.
+	scratchMode := cmp.Or(os.Getenv("SCRATCH_MODE"), scratchModeLocal)
+	if scratchMode != scratchModeLocal && scratchMode != scratchModeStaging {
+		problems.add("SCRATCH_MODE must be %q or %q, not %q", scratchModeLocal, scratchModeStaging, scratchMode)
+	}
+	problems.binary("ffmpeg")
+	problems.binary("ffprobe")
+	if err := problems.err(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
 	}
 
 	db, err := database.NewClient(pathToDB)
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
+	db = db.ForInstance(instanceName(port))
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	// Optional: without Redis every view reads the database and signs
+	// fresh URLs.
+	appCache, err := newAppCache()
+	if err != nil {
+		log.Fatalf("Invalid REDIS_URL: %v", err)
 	}
-
-	platform := os.Getenv("PLATFORM")
-	if platform == "" {
-		log.Fatal("PLATFORM environment variable is not set")
+	if appCache != nil {
+		db = db.OnVideoChange(appCache.invalidateVideos)
 	}
 
-	filepathRoot := os.Getenv("FILEPATH_ROOT")
-	if filepathRoot == "" {
-		log.Fatal("FILEPATH_ROOT environment variable is not set")
+	// Public origin used in generated URLs, e.g. https://tubely.example.com.
+	// Without it they point at localhost.
+	externalBaseURL := strings.TrimSuffix(os.Getenv("EXTERNAL_BASE_URL"), "/")
+	if externalBaseURL == "" {
+		log.Printf("EXTERNAL_BASE_URL isn't set, generated URLs will point at localhost")
 	}
 
-	assetsRoot := os.Getenv("ASSETS_ROOT")
-	if assetsRoot == "" {
-		log.Fatal("ASSETS_ROOT environment variable is not set")
+	// Asset names are random and never rewritten, so they can be cached
+	// for as long as clients like.
+	assetsCacheControl := os.Getenv("ASSETS_CACHE_CONTROL")
+	if assetsCacheControl == "" {
+		assetsCacheControl = "max-age=31536000, immutable"
 	}
 
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	exposeErrorDetails = envBool("DEBUG_ERRORS", platform == "dev")
+	if exposeErrorDetails && platform != "dev" {
+		log.Printf("DEBUG_ERRORS is on: error responses include internal error details")
 	}
+	errorReports, err = newErrorReports(platform)
+	if err != nil {
+		log.Fatalf("Invalid ERROR_REPORTING_DSN: %v", err)
+	}
+	sortableAssetNames = envBool("SORTABLE_ASSET_NAMES", false)
+
+	// Optional: the gRPC API is only served when a port is configured.
+	grpcPort := os.Getenv("GRPC_PORT")
+
+	// Optional: admin endpoints are disabled when no key is configured.
+	adminAPIKey := os.Getenv("ADMIN_API_KEY")
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	mediaTypes, err := loadMediaRegistry()
+	if err != nil {
+		log.Fatalf("Invalid media type configuration: %v", err)
 	}
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	playbackPolicies, err := loadPlaybackPolicies(envDuration("PLAYBACK_URL_TTL", time.Hour))
+	if err != nil {
+		log.Fatalf("Invalid PLAYBACK_URL_POLICIES: %v", err)
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	thumbnailSizes, err := loadThumbnailSizes()
+	if err != nil {
+		log.Fatalf("Invalid THUMBNAIL_RENDITIONS: %v", err)
 	}
 
-	s3Config, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(s3Region))
+	keys, err := loadKeyStrategy()
 	if err != nil {
-		log.Fatalf("Couldn't load default config: %s", err)
+		log.Fatalf("Invalid KEY_STRATEGY: %v", err)
 	}
 
-	s3Client := s3.NewFromConfig(s3Config)
+	objectHeaders, err := loadObjectHeaders()
+	if err != nil {
+		log.Fatalf("Invalid object headers: %v", err)
+	}
 
 	cfg := apiConfig{
-		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		port:             port,
-		s3Client:         s3Client,
+		db:                   db,
+		jwtSecret:            secrets.NewValue(jwtSecret),
+		secrets:              secretStore,
+		platform:             platform,
+		filepathRoot:         filepathRoot,
+		assetsRoot:           assetsRoot,
+		s3Bucket:             s3Bucket,
+		s3Region:             s3Region,
+		s3CfDistribution:     s3CfDistribution,
+		port:                 port,
+		media:                media.FFmpeg{},
+		adminAPIKey:          adminAPIKey,
+		tempMaxAge:           envDuration("TEMP_MAX_AGE", 24*time.Hour),
+		scratchMode:          scratchMode,
+		presignUploadTTL:     envDuration("PRESIGN_UPLOAD_TTL", 15*time.Minute),
+		mediaTypes:           mediaTypes,
+		externalBaseURL:      externalBaseURL,
+		trustProxyHeaders:    envBool("TRUST_PROXY_HEADERS", false),
+		assetsCacheControl:   assetsCacheControl,
+		assetsRequireAuth:    envBool("ASSETS_REQUIRE_AUTH", false),
+		scheduler:            scheduler.New(),
+		slowJobThreshold:     envDuration("SLOW_JOB_THRESHOLD", 5*time.Minute),
+		slowStageThreshold:   envDuration("SLOW_STAGE_THRESHOLD", 2*time.Minute),
+		uploadSessionTTL:     envDuration("UPLOAD_SESSION_TTL", 24*time.Hour),
+		playbackPolicies:     playbackPolicies,
+		geoCountryHeader:     os.Getenv("GEO_COUNTRY_HEADER"),
+		hotlink:              loadHotlinkPolicy(),
+		objectLock:           envBool("OBJECT_LOCK_ENABLED", false),
+		maxRetention:         envDuration("OBJECT_LOCK_MAX_RETENTION", 10*365*24*time.Hour),
+		downloadLimits:       loadThrottleLimits("DOWNLOAD"),
+		uploadLimits:         loadThrottleLimits("UPLOAD"),
+		moderation:           loadModerationPolicy(),
+		videoWorkers:         newWorkerPool("video", envInt("VIDEO_PROCESSING_CONCURRENCY", runtime.NumCPU())),
+		thumbnailWorkers:     newWorkerPool("thumbnail", envInt("THUMBNAIL_CONCURRENCY", runtime.NumCPU())),
+		renditionWorkers:     newWorkerPool("rendition", envInt("RENDITION_CONCURRENCY", runtime.NumCPU())),
+		transcriptionWorkers: newWorkerPool("transcription", envInt("TRANSCRIPTION_CONCURRENCY", 1)),
+		thumbnailSizes:       thumbnailSizes,
+		keys:                 keys,
+		objectHeaders:        objectHeaders,
+		sitemap:              &sitemapCache{},
+		sitemapPageTemplate:  os.Getenv("SITEMAP_PAGE_URL"),
+		webhooks:             newWebhookDispatcher(db),
+		events:               events.NewHub(envInt("ADMIN_EVENTS_BACKLOG", 256)),
+		processing:           newProcessingJobs(),
+		cache:                appCache,
+		videoLocks:           newVideoLocks(db, appCache),
+		watermarkImage:       os.Getenv("WATERMARK_IMAGE"),
+	}
+
+	if cfg.watermarkImage != "" {
+		if _, err := os.Stat(cfg.watermarkImage); err != nil {
+			log.Fatalf("Couldn't read WATERMARK_IMAGE: %v", err)
+		}
+	}
+
+	// Optional: without a GeoIP database only the country header is used.
+	if path := os.Getenv("GEOIP_CSV"); path != "" {
+		cfg.geoLocator, err = geoip.LoadCSV(path)
+		if err != nil {
+			log.Fatalf("Couldn't load GeoIP database: %v", err)
+		}
+	}
+
+	cfg.moderator, err = newModerator(s3Region)
+	if err != nil {
+		log.Fatalf("Couldn't set up moderation: %v", err)
+	}
+
+	cfg.store, err = cfg.newStore(storageBackend, os.Getenv("STORAGE_ROOT"))
+	if err != nil {
+		log.Fatalf("Couldn't set up %s storage: %v", storageBackend, err)
+	}
+
+	if storageBackend == storageBackendS3 {
+		cfg.invalidator, err = newInvalidator()
+		if err != nil {
+			log.Fatalf("Couldn't set up CloudFront invalidation: %v", err)
+		}
+		cfg.inventory, err = newInventoryReader(s3Region)
+		if err != nil {
+			log.Fatalf("Couldn't set up S3 Inventory: %v", err)
+		}
+	}
+
+	cfg.transcriber, err = cfg.newTranscriber()
+	if err != nil {
+		log.Fatalf("Couldn't set up transcription: %v", err)
+	}
+
+	if err := cfg.syncConfigPresets(configPresets); err != nil {
+		log.Fatalf("Couldn't apply presets from the config file: %v", err)
+	}
+
+	cfg.domainEvents, err = newDomainEvents()
+	if err != nil {
+		log.Fatalf("Couldn't set up event publishing: %v", err)
+	}
+
+	cfg.graphqlSchema, err = cfg.newGraphQLSchema()
+	if err != nil {
+		log.Fatalf("Couldn't parse GraphQL schema: %v", err)
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -110,33 +357,205 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	if len(os.Args) > 1 {
+		if err := cfg.runCommand(os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if n, err := cfg.db.FailInterruptedJobs(); err != nil {
+		log.Fatalf("Couldn't clean up interrupted jobs: %v", err)
+	} else if n > 0 {
+		log.Printf("Marked %d interrupted jobs as failed", n)
+	}
+
+	cfg.scheduler.OnError = reportTaskError
+	if envBool("LEADER_ELECTION", true) {
+		election := newLeaderElection(cfg.db, port)
+		election.Run(context.Background())
+		cfg.scheduler.IsLeader = election.IsLeader
+	}
+	cfg.registerTasks()
+	cfg.scheduler.Start(context.Background())
+
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	mux.Handle("GET /assets/{filename}", longRequest(cfg.hotlinkProtection(cfg.throttleDownloads(http.HandlerFunc(cfg.handlerAssets)))))
+	if h, ok := cfg.store.(http.Handler); ok {
+		mux.Handle("GET "+objectsPath+"/", longRequest(cfg.hotlinkProtection(http.StripPrefix(objectsPath, cfg.privateObjects(cfg.geoMiddleware(cfg.geoRestrictedObjects(cfg.throttleDownloads(h))))))))
+	}
+
+	api := newAPIRouter()
+	api.HandleFunc("POST /login", cfg.handlerLogin)
+	api.HandleFunc("POST /refresh", cfg.handlerRefresh)
+	api.HandleFunc("POST /revoke", cfg.handlerRevoke)
+
+	api.HandleFunc("POST /users", cfg.handlerUsersCreate)
+	api.HandleFunc("GET /users/me/usage", cfg.handlerUsageGet)
+	api.HandleFunc("GET /users/me/plan", cfg.handlerPlanGet)
+	api.HandleFunc("GET /users/me/geo", cfg.handlerUserGeoGet)
+	api.HandleFunc("PUT /users/me/geo", cfg.handlerUserGeoUpdate)
+	api.HandleFunc("DELETE /users/me", cfg.handlerUserDelete)
+	api.HandleFunc("POST /users/me/export", cfg.handlerUserExport)
+	api.HandleFunc("GET /users/me/history", cfg.handlerWatchHistory)
+	api.HandleFunc("GET /users/me/settings", cfg.handlerUserSettingsGet)
+	api.HandleFunc("PUT /users/me/settings", cfg.handlerUserSettingsUpdate)
+	api.Handle("POST /users/me/avatar", longRequest(http.HandlerFunc(cfg.handlerUserAvatarUpload)))
+	api.HandleFunc("DELETE /users/me/avatar", cfg.handlerUserAvatarDelete)
+	api.Handle("POST /users/me/banner", longRequest(http.HandlerFunc(cfg.handlerUserBannerUpload)))
+	api.HandleFunc("DELETE /users/me/banner", cfg.handlerUserBannerDelete)
+
+	mux.HandleFunc("GET /sitemap.xml", cfg.handlerSitemap)
+	api.HandleFunc("GET /channels/{userID}", cfg.rateLimit(envInt("CHANNEL_RATE_PER_CLIENT", 5), cfg.handlerChannelGet))
+
+	api.HandleFunc("POST /videos", cfg.handlerVideoMetaCreate)
+	api.Handle("POST /thumbnail_upload/{videoID}", longRequest(http.HandlerFunc(cfg.handlerUploadThumbnail)))
+	api.HandleFunc("POST /videos/{videoID}/upload/preflight", cfg.handlerUploadPreflight)
+	api.HandleFunc("POST /videos/{videoID}/upload_tokens", cfg.handlerUploadTokenCreate)
+	api.Handle("POST /video_upload/{videoID}", longRequest(http.HandlerFunc(cfg.handlerUploadVideo)))
+	api.Handle("POST /video_upload/{videoID}/bundle", longRequest(http.HandlerFunc(cfg.handlerUploadBundle)))
+	api.Handle("POST /video_upload/{videoID}/archive", longRequest(http.HandlerFunc(cfg.handlerUploadArchive)))
+	api.HandleFunc("POST /video_upload/{videoID}/presign", cfg.handlerVideoUploadURL)
+	api.Handle("POST /video_upload/{videoID}/presign/complete", longRequest(http.HandlerFunc(cfg.handlerVideoUploadComplete)))
+	api.HandleFunc("POST /video_upload/{videoID}/sessions", cfg.handlerUploadSessionCreate)
+	api.HandleFunc("GET /upload_sessions/{sessionID}", cfg.handlerUploadSessionGet)
+	api.Handle("PUT /upload_sessions/{sessionID}", longRequest(http.HandlerFunc(cfg.handlerUploadSessionPut)))
+	api.HandleFunc("DELETE /upload_sessions/{sessionID}", cfg.handlerUploadSessionDelete)
+	api.HandleFunc("GET /videos", cfg.handlerVideosRetrieve)
+	api.HandleFunc("GET /videos/search", cfg.handlerVideoSearch)
+	api.HandleFunc("GET /videos/shared", cfg.handlerVideosShared)
+	api.HandleFunc("GET /videos/{videoID}", cfg.handlerVideoGet)
+	api.HandleFunc("GET /videos/{videoID}/captions", cfg.handlerVideoCaptions)
+	api.HandleFunc("GET /videos/{videoID}/chapters", cfg.handlerVideoChaptersGet)
+	api.HandleFunc("POST /videos/{videoID}/chapters", cfg.handlerVideoChapterCreate)
+	api.HandleFunc("PUT /videos/{videoID}/chapters/{chapterID}", cfg.handlerVideoChapterUpdate)
+	api.HandleFunc("DELETE /videos/{videoID}/chapters/{chapterID}", cfg.handlerVideoChapterDelete)
+	api.Handle("POST /videos/{videoID}/playback", cfg.hotlinkProtection(cfg.geoMiddleware(http.HandlerFunc(cfg.handlerVideoPlayback))))
+	api.HandleFunc("GET /videos/{videoID}/geo", cfg.handlerVideoGeoGet)
+	api.HandleFunc("PUT /videos/{videoID}/geo", cfg.handlerVideoGeoUpdate)
+	api.HandleFunc("POST /videos/{videoID}/events", cfg.handlerVideoEvents)
+	api.HandleFunc("GET /videos/{videoID}/position", cfg.handlerVideoPositionGet)
+	api.HandleFunc("PUT /videos/{videoID}/schedule", cfg.handlerVideoScheduleSet)
+	api.HandleFunc("DELETE /videos/{videoID}/schedule", cfg.handlerVideoScheduleCancel)
+	api.HandleFunc("PUT /videos/{videoID}/expiry", cfg.handlerVideoExpirySet)
+	api.HandleFunc("DELETE /videos/{videoID}/expiry", cfg.handlerVideoExpiryClear)
+	api.Handle("POST /videos/{videoID}/restore", longRequest(http.HandlerFunc(cfg.handlerVideoRestore)))
+	api.HandleFunc("PUT /videos/{videoID}/retention", cfg.handlerVideoRetentionUpdate)
+	api.HandleFunc("GET /videos/{videoID}/processing", cfg.handlerVideoProcessingStatus)
+	api.HandleFunc("GET /videos/{videoID}/versions", cfg.handlerVideoVersionsList)
+	api.Handle("POST /videos/{videoID}/versions/{versionID}/restore", longRequest(http.HandlerFunc(cfg.handlerVideoVersionRestore)))
+	api.Handle("POST /videos/{videoID}/copy", longRequest(http.HandlerFunc(cfg.handlerVideoCopy)))
+	api.HandleFunc("POST /videos/{videoID}/report", cfg.handlerVideoReport)
+	api.HandleFunc("GET /videos/{videoID}/thumbnail_candidates", cfg.handlerThumbnailCandidates)
+	api.HandleFunc("POST /videos/{videoID}/thumbnail/select", cfg.handlerThumbnailSelect)
+	api.Handle("POST /videos/{videoID}/thumbnail/from_frame", longRequest(http.HandlerFunc(cfg.handlerThumbnailFromFrame)))
+	api.HandleFunc("PUT /videos/{videoID}/thumbnail/framing", cfg.handlerThumbnailFraming)
+	api.HandleFunc("PATCH /videos/{videoID}", cfg.handlerVideoMetaUpdate)
+	api.HandleFunc("DELETE /videos/{videoID}", cfg.handlerVideoMetaDelete)
+
+	api.HandleFunc("POST /videos/{videoID}/transfer", cfg.handlerVideoTransferCreate)
+	api.HandleFunc("GET /transfers", cfg.handlerVideoTransfersList)
+	api.Handle("POST /transfers/{transferID}/accept", longRequest(http.HandlerFunc(cfg.handlerVideoTransferAccept)))
+	api.HandleFunc("POST /transfers/{transferID}/decline", cfg.handlerVideoTransferDecline)
+	api.HandleFunc("DELETE /transfers/{transferID}", cfg.handlerVideoTransferCancel)
 
-	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
-	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
-	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	api.HandleFunc("GET /videos/{videoID}/grants", cfg.handlerVideoGrantsList)
+	api.HandleFunc("PUT /videos/{videoID}/grants", cfg.handlerVideoGrantSet)
+	api.HandleFunc("DELETE /videos/{videoID}/grants/{userID}", cfg.handlerVideoGrantDelete)
 
-	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	api.HandleFunc("PUT /videos/{videoID}/org", cfg.handlerVideoOrgUpdate)
+	api.HandleFunc("GET /orgs", cfg.handlerOrgsList)
+	api.HandleFunc("POST /orgs", cfg.handlerOrgCreate)
+	api.HandleFunc("GET /orgs/{orgID}", cfg.handlerOrgGet)
+	api.HandleFunc("GET /orgs/{orgID}/members", cfg.handlerOrgMembersList)
+	api.HandleFunc("POST /orgs/{orgID}/members", cfg.handlerOrgMemberSet)
+	api.HandleFunc("DELETE /orgs/{orgID}/members/{userID}", cfg.handlerOrgMemberRemove)
+	api.HandleFunc("GET /orgs/{orgID}/videos", cfg.handlerOrgVideos)
 
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	api.HandleFunc("GET /folders", cfg.handlerFoldersList)
+	api.HandleFunc("POST /folders", cfg.handlerFolderCreate)
+	api.HandleFunc("GET /folders/{folderID}", cfg.handlerFolderGet)
+	api.HandleFunc("PATCH /folders/{folderID}", cfg.handlerFolderRename)
+	api.HandleFunc("DELETE /folders/{folderID}", cfg.handlerFolderDelete)
+	api.HandleFunc("POST /folders/{folderID}/move", cfg.handlerFolderMove)
+	api.HandleFunc("GET /folders/{folderID}/videos", cfg.handlerFolderVideos)
+	api.HandleFunc("POST /folders/{folderID}/videos", cfg.handlerFolderVideosAdd)
+	api.HandleFunc("POST /folders/{folderID}/bulk", cfg.handlerFolderBulk)
+	api.HandleFunc("GET /folders/{folderID}/grants", cfg.handlerFolderGrantsList)
+	api.HandleFunc("PUT /folders/{folderID}/grants", cfg.handlerFolderGrantSet)
+	api.HandleFunc("DELETE /folders/{folderID}/grants/{userID}", cfg.handlerFolderGrantDelete)
+
+	api.HandleFunc("GET /jobs/{jobID}", cfg.handlerJobGet)
+
+	api.Mount(mux)
+
+	mux.HandleFunc("POST /graphql", cfg.handlerGraphQL)
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.HandleFunc("GET /admin/metrics", cfg.requireAdmin(metrics.Default.ServeHTTP))
+	mux.HandleFunc("GET /admin/tasks", cfg.requireAdmin(cfg.handlerAdminTasksList))
+	mux.HandleFunc("POST /admin/tasks/{taskName}/run", cfg.requireAdmin(cfg.handlerAdminTaskRun))
+	mux.HandleFunc("GET /admin/videos", cfg.requireAdmin(cfg.handlerAdminVideosList))
+	mux.HandleFunc("GET /admin/integrity", cfg.requireAdmin(cfg.handlerAdminIntegrityReport))
+	mux.HandleFunc("POST /admin/inventory/reconcile", cfg.requireAdmin(cfg.handlerAdminInventoryReconcile))
+	mux.HandleFunc("GET /admin/usage", cfg.requireAdmin(cfg.handlerAdminUsage))
+	mux.HandleFunc("GET /admin/stats", cfg.requireAdmin(cfg.handlerAdminStats))
+	mux.HandleFunc("GET /admin/activity/uploads", cfg.requireAdmin(cfg.handlerAdminRecentUploads))
+	mux.HandleFunc("GET /admin/activity/failures", cfg.requireAdmin(cfg.handlerAdminRecentFailures))
+	mux.HandleFunc("GET /admin/activity/throughput", cfg.requireAdmin(cfg.handlerAdminThroughput))
+	mux.HandleFunc("GET /admin/plans", cfg.requireAdmin(cfg.handlerAdminPlansList))
+	mux.HandleFunc("PUT /admin/plans/{planID}/preset", cfg.requireAdmin(cfg.handlerAdminPlanPresetUpdate))
+	mux.HandleFunc("PUT /admin/plans/{planID}/limits", cfg.requireAdmin(cfg.handlerAdminPlanLimitsUpdate))
+	mux.HandleFunc("PUT /admin/users/{userID}/plan", cfg.requireAdmin(cfg.handlerAdminUserPlanUpdate))
+	mux.HandleFunc("PUT /admin/orgs/{orgID}/plan", cfg.requireAdmin(cfg.handlerAdminOrgPlanUpdate))
+	mux.HandleFunc("DELETE /admin/users/{userID}", cfg.requireAdmin(cfg.handlerAdminUserDelete))
+	mux.HandleFunc("PUT /admin/videos/{videoID}/retention", cfg.requireAdmin(cfg.handlerAdminVideoRetentionUpdate))
+	mux.HandleFunc("GET /admin/jobs/{jobID}", cfg.requireAdmin(cfg.handlerAdminJobGet))
+	mux.HandleFunc("POST /admin/reprocess", cfg.requireAdmin(cfg.handlerAdminReprocess))
+	mux.HandleFunc("GET /admin/reports", cfg.requireAdmin(cfg.handlerAdminReportsList))
+	mux.HandleFunc("POST /admin/reports/{reportID}/resolve", cfg.requireAdmin(cfg.handlerAdminReportResolve))
+	mux.HandleFunc("GET /admin/videos/{videoID}/moderation", cfg.requireAdmin(cfg.handlerAdminVideoModeration))
+	mux.HandleFunc("GET /admin/audit", cfg.requireAdmin(cfg.handlerAdminAuditLog))
+	mux.HandleFunc("GET /admin/presets", cfg.requireAdmin(cfg.handlerAdminPresetsList))
+	mux.HandleFunc("POST /admin/presets", cfg.requireAdmin(cfg.handlerAdminPresetCreate))
+	mux.HandleFunc("GET /admin/presets/{presetID}", cfg.requireAdmin(cfg.handlerAdminPresetGet))
+	mux.HandleFunc("PUT /admin/presets/{presetID}", cfg.requireAdmin(cfg.handlerAdminPresetUpdate))
+	mux.HandleFunc("DELETE /admin/presets/{presetID}", cfg.requireAdmin(cfg.handlerAdminPresetDelete))
+	mux.Handle("GET /admin/events", streamingRequest(cfg.requireAdmin(cfg.handlerAdminEvents)))
+	mux.HandleFunc("GET /admin/webhooks", cfg.requireAdmin(cfg.handlerAdminWebhooksList))
+	mux.HandleFunc("POST /admin/webhooks", cfg.requireAdmin(cfg.handlerAdminWebhookCreate))
+	mux.HandleFunc("DELETE /admin/webhooks/{endpointID}", cfg.requireAdmin(cfg.handlerAdminWebhookDelete))
+	mux.HandleFunc("GET /admin/webhooks/{endpointID}/deliveries", cfg.requireAdmin(cfg.handlerAdminWebhookDeliveries))
+	mux.HandleFunc("GET /admin/webhooks/{endpointID}/deliveries/{deliveryID}", cfg.requireAdmin(cfg.handlerAdminWebhookDelivery))
+
+	if grpcPort != "" {
+		lis, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			log.Fatalf("Couldn't listen on gRPC port: %v", err)
+		}
+		go func() {
+			log.Printf("Serving gRPC on: :%s\n", grpcPort)
+			log.Fatal(cfg.newGRPCServer().Serve(lis))
+		}()
+	}
+
+	accessLog, err := newAccessLogger()
+	if err != nil {
+		log.Fatalf("Couldn't set up access log: %v", err)
+	}
 
+	timeouts := loadRouteTimeouts()
 	srv := &http.Server{
 		Addr:    ":" + port,
-		Handler: mux,
+		Handler: requestIDMiddleware(cfg.accessLogMiddleware(accessLog, recoverMiddleware(timeouts.middleware(mux, errorReportingMiddleware(cfg.rejectSuspendedUsers(mux)))))),
+		// Handlers set their own deadlines once routed; this only stops
+		// clients that never finish sending headers.
+		ReadHeaderTimeout: envDuration("READ_HEADER_TIMEOUT", 10*time.Second),
 	}
 
-	log.Printf("Serving on: http://localhost:%s/app/\n", port)
+	log.Printf("Serving on: %s/app/\n", cfg.getBaseURL())
 	log.Fatal(srv.ListenAndServe())
 }
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type mediaKind string
+
+const (
+	mediaKindVideo     mediaKind = "video"
+	mediaKindThumbnail mediaKind = "thumbnail"
+)
+
+type mediaTypeRule struct {
+	MediaType string
+	Extension string
+	// MaxSize caps uploads of this type on top of the user's plan limit.
+	// Zero means only the plan limit applies.
+	MaxSize int64
+	// Sniff checks the leading bytes of an upload really are this type.
+	// Nil skips content sniffing.
+	Sniff func(header []byte) bool
+}
+
+type mediaRegistry map[mediaKind]map[string]mediaTypeRule
+
+// knownMediaTypes are the types operators can enable without providing
+// anything but the type name.
+var knownMediaTypes = map[string]mediaTypeRule{
+	"video/mp4":       {MediaType: "video/mp4", Sniff: sniffISOBMFF},
+	"video/quicktime": {MediaType: "video/quicktime", Sniff: sniffISOBMFF},
+	"video/webm":      {MediaType: "video/webm", Sniff: sniffDetected("video/webm")},
+	"image/jpeg":      {MediaType: "image/jpeg", MaxSize: 10 << 20, Sniff: sniffDetected("image/jpeg")},
+	"image/png":       {MediaType: "image/png", MaxSize: 10 << 20, Sniff: sniffDetected("image/png")},
+	"image/gif":       {MediaType: "image/gif", MaxSize: 10 << 20, Sniff: sniffDetected("image/gif")},
+	"image/webp":      {MediaType: "image/webp", MaxSize: 10 << 20, Sniff: sniffDetected("image/webp")},
+}
+
+// loadMediaRegistry builds the allowlist from env vars holding comma
+// separated entries of the form "type" or "type:maxBytes".
+func loadMediaRegistry() (mediaRegistry, error) {
+	defaults := map[mediaKind]string{
+		mediaKindVideo:     "video/mp4",
+		mediaKindThumbnail: "image/jpeg,image/png",
+	}
+	envVars := map[mediaKind]string{
+		mediaKindVideo:     "VIDEO_MEDIA_TYPES",
+		mediaKindThumbnail: "THUMBNAIL_MEDIA_TYPES",
+	}
+
+	registry := mediaRegistry{}
+	for kind, envVar := range envVars {
+		raw := os.Getenv(envVar)
+		if raw == "" {
+			raw = defaults[kind]
+		}
+		rules, err := parseMediaTypeRules(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", envVar, err)
+		}
+		registry[kind] = rules
+	}
+	return registry, nil
+}
+
+func parseMediaTypeRules(raw string) (map[string]mediaTypeRule, error) {
+	rules := map[string]mediaTypeRule{}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		mediaType, maxSize, hasMaxSize := strings.Cut(entry, ":")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		if !strings.Contains(mediaType, "/") {
+			return nil, fmt.Errorf("invalid media type %q", mediaType)
+		}
+
+		rule, ok := knownMediaTypes[mediaType]
+		if !ok {
+			rule = mediaTypeRule{MediaType: mediaType}
+		}
+		rule.Extension = mediaTypeToExtension(mediaType)
+		if hasMaxSize {
+			size, err := strconv.ParseInt(strings.TrimSpace(maxSize), 10, 64)
+			if err != nil || size < 0 {
+				return nil, fmt.Errorf("invalid max size for %s: %q", mediaType, maxSize)
+			}
+			rule.MaxSize = size
+		}
+		rules[mediaType] = rule
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no media types configured")
+	}
+	return rules, nil
+}
+
+func (reg mediaRegistry) lookup(kind mediaKind, mediaType string) (mediaTypeRule, bool) {
+	rule, ok := reg[kind][mediaType]
+	return rule, ok
+}
+
+// byExtension finds the enabled type a file extension stands for, checking
+// videos before thumbnails.
+func (reg mediaRegistry) byExtension(ext string) (mediaKind, mediaTypeRule, bool) {
+	ext = strings.ToLower(ext)
+	if ext == ".jpeg" {
+		ext = ".jpg"
+	}
+	for _, kind := range []mediaKind{mediaKindVideo, mediaKindThumbnail} {
+		for _, rule := range reg[kind] {
+			if rule.Extension == ext {
+				return kind, rule, true
+			}
+		}
+	}
+	return "", mediaTypeRule{}, false
+}
+
+func (reg mediaRegistry) allowed(kind mediaKind) string {
+	types := make([]string, 0, len(reg[kind]))
+	for mediaType := range reg[kind] {
+		types = append(types, mediaType)
+	}
+	sort.Strings(types)
+	return strings.Join(types, ", ")
+}
+
+// sniff validates the start of r against the rule and rewinds it so the
+// caller can read the whole upload afterwards.
+func (rule mediaTypeRule) sniff(r io.ReadSeeker) (bool, error) {
+	if rule.Sniff == nil {
+		return true, nil
+	}
+	header := make([]byte, 512)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	return rule.Sniff(header[:n]), nil
+}
+
+func (rule mediaTypeRule) assetPath() string {
+	return newAssetName() + rule.Extension
+}
+
+func sniffDetected(mediaType string) func([]byte) bool {
+	return func(header []byte) bool {
+		return http.DetectContentType(header) == mediaType
+	}
+}
+
+// sniffISOBMFF accepts MP4/QuickTime style files, which start with a box
+// whose type is usually ftyp (older QuickTime files may lead with others).
+func sniffISOBMFF(header []byte) bool {
+	if len(header) < 8 {
+		return false
+	}
+	boxType := header[4:8]
+	for _, known := range [][]byte{[]byte("ftyp"), []byte("moov"), []byte("mdat"), []byte("free"), []byte("wide")} {
+		if bytes.Equal(boxType, known) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateMediaUpload checks an uploaded form file against the registry,
+// responding with an error and returning false if it isn't acceptable.
+func (cfg *apiConfig) validateMediaUpload(w http.ResponseWriter, kind mediaKind, file multipart.File, header *multipart.FileHeader) (mediaTypeRule, bool) {
+	rule, apiErr := cfg.checkMediaUpload(kind, file, header)
+	if apiErr != nil {
+		respondWithAPIError(w, *apiErr)
+		return mediaTypeRule{}, false
+	}
+	return rule, true
+}
+
+// checkMediaUpload is validateMediaUpload for callers that report the
+// failure themselves.
+func (cfg *apiConfig) checkMediaUpload(kind mediaKind, file io.ReadSeeker, header *multipart.FileHeader) (mediaTypeRule, *apiError) {
+	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
+	if err != nil {
+		return mediaTypeRule{}, &apiError{Status: http.StatusBadRequest, Code: errCodeInvalidMediaType, Message: "Couldn't parse media type", Err: err}
+	}
+
+	rule, ok := cfg.mediaTypes.lookup(kind, mediaType)
+	if !ok {
+		return mediaTypeRule{}, &apiError{Status: http.StatusBadRequest, Code: errCodeInvalidMediaType, Message: fmt.Sprintf("Only accepts %s", cfg.mediaTypes.allowed(kind))}
+	}
+	if rule.MaxSize > 0 && header.Size > rule.MaxSize {
+		return mediaTypeRule{}, &apiError{Status: http.StatusRequestEntityTooLarge, Code: errCodePayloadTooLarge, Message: fmt.Sprintf("Files of type %s are limited to %d bytes", mediaType, rule.MaxSize)}
+	}
+
+	matches, err := rule.sniff(file)
+	if err != nil {
+		return mediaTypeRule{}, &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't read uploaded file", Err: err}
+	}
+	if !matches {
+		return mediaTypeRule{}, &apiError{Status: http.StatusBadRequest, Code: errCodeInvalidMediaType, Message: fmt.Sprintf("File content isn't valid %s", mediaType)}
+	}
+	return rule, nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// requestIDMiddleware tags every request with an ID, reusing one supplied
+// by an upstream proxy when it looks sane. The ID is set on the response
+// headers up front so respondWithError can include it.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic("failed to generate random bytes")
+	}
+	return hex.EncodeToString(b)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// recoverMiddleware turns a panicking handler into a 500 with the usual
+// error body, so one bad request doesn't take the connection down with no
+// explanation. If the handler had already started its response, the
+// connection is cut instead, since a 500 can't follow it anymore.
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			stack := debug.Stack()
+			if p, ok := v.(*handlerPanic); ok {
+				v, stack = p.value, p.stack
+			}
+			log.Printf("[%s] panic serving %s %s: %v\n%s", w.Header().Get(requestIDHeader), r.Method, r.URL.Path, v, stack)
+			if rec.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			respondWithErrorCode(w, http.StatusInternalServerError, errCodeInternal, "Internal server error", nil)
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// handlerPanic carries a panic out of the goroutine a handler ran in,
+// along with the stack where it happened.
+type handlerPanic struct {
+	value any
+	stack []byte
+}
+
+// routeTimeouts bounds how long a request may take: the short timeout
+// unless its route was registered with longRequest or streamingRequest.
+type routeTimeouts struct {
+	short time.Duration
+	long  time.Duration
+}
+
+// timeoutClass is which of the timeouts a route gets.
+type timeoutClass int
+
+const (
+	timeoutShort timeoutClass = iota
+	// timeoutLong is for uploads, downloads and other requests that move
+	// whole videos.
+	timeoutLong
+	// timeoutNone is for streams that stay open as long as the client
+	// listens.
+	timeoutNone
+)
+
+// classedHandler is a handler registered with a timeout class other than
+// the short one.
+type classedHandler struct {
+	http.Handler
+	class timeoutClass
+}
+
+// longRequest registers next under the long timeout.
+func longRequest(next http.Handler) http.Handler {
+	return withTimeoutClass(timeoutLong, next)
+}
+
+// streamingRequest registers next without a timeout.
+func streamingRequest(next http.Handler) http.Handler {
+	return withTimeoutClass(timeoutNone, next)
+}
+
+func withTimeoutClass(class timeoutClass, next http.Handler) http.Handler {
+	if class == timeoutShort {
+		return next
+	}
+	return classedHandler{Handler: next, class: class}
+}
+
+func timeoutClassOf(h http.Handler) timeoutClass {
+	if c, ok := h.(classedHandler); ok {
+		return c.class
+	}
+	return timeoutShort
+}
+
+func loadRouteTimeouts() routeTimeouts {
+	return routeTimeouts{
+		short: envDuration("REQUEST_TIMEOUT", 30*time.Second),
+		long:  envDuration("LONG_REQUEST_TIMEOUT", 2*time.Hour),
+	}
+}
+
+// forRequest looks up the route mux serves the request with and returns
+// its timeout, zero for none.
+func (t routeTimeouts) forRequest(mux *http.ServeMux, r *http.Request) (d time.Duration, long bool) {
+	h, _ := mux.Handler(r)
+	switch timeoutClassOf(h) {
+	case timeoutNone:
+		return 0, true
+	case timeoutLong:
+		return t.long, true
+	}
+	return t.short, false
+}
+
+// middleware puts the route's deadline on the request context. Long
+// requests also get it as the connection's read and write deadline, so a
+// client that stalls mid-upload is cut off. Short requests are buffered,
+// which lets a handler that overruns be answered with a 503 while it
+// winds down.
+func (t routeTimeouts) middleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, long := t.forRequest(mux, r)
+		if d <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		if long {
+			deadline := time.Now().Add(d)
+			rc := http.NewResponseController(w)
+			rc.SetReadDeadline(deadline)
+			rc.SetWriteDeadline(deadline)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tw := &timeoutWriter{w: w, h: w.Header().Clone()}
+		done := make(chan struct{})
+		panicked := make(chan *handlerPanic, 1)
+		go func() {
+			defer func() {
+				if v := recover(); v != nil {
+					panicked <- &handlerPanic{value: v, stack: debug.Stack()}
+				}
+			}()
+			next.ServeHTTP(tw, r)
+			close(done)
+		}()
+		select {
+		case p := <-panicked:
+			if p.value == http.ErrAbortHandler {
+				panic(p.value)
+			}
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			dst := w.Header()
+			for k, vv := range tw.h {
+				dst[k] = vv
+			}
+			if !tw.wroteHeader {
+				tw.code = http.StatusOK
+			}
+			w.WriteHeader(tw.code)
+			w.Write(tw.buf.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				respondWithErrorCode(w, http.StatusServiceUnavailable, errCodeTimeout, "Request took too long", fmt.Errorf("%s %s timed out after %s", r.Method, r.URL.Path, d))
+			}
+		}
+	})
+}
+
+// timeoutWriter buffers a short request's response until the handler
+// returns, and drops it if the request timed out first.
+type timeoutWriter struct {
+	w           http.ResponseWriter
+	h           http.Header
+	buf         bytes.Buffer
+	mu          sync.Mutex
+	code        int
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.h }
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	return tw.buf.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.writeHeaderLocked(code)
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(code int) {
+	tw.wroteHeader = true
+	tw.code = code
+}
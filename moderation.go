@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/moderation"
+	"github.com/google/uuid"
+)
+
+const moderationFrameWidth = 640
+
+// moderationPolicy decides what happens to a video from the most
+// confident label found in any of its frames. Confidences are percentages;
+// a zero threshold is never reached.
+type moderationPolicy struct {
+	frames           int
+	flagConfidence   float64
+	unlistConfidence float64
+	// Thumbnails are checked while the upload waits, so only small ones
+	// are sent and the provider gets a short deadline.
+	thumbnailRejectConfidence float64
+	thumbnailMaxSize          int64
+	thumbnailTimeout          time.Duration
+}
+
+func loadModerationPolicy() moderationPolicy {
+	return moderationPolicy{
+		frames:                    envInt("MODERATION_FRAMES", 5),
+		flagConfidence:            float64(envInt("MODERATION_FLAG_CONFIDENCE", 80)),
+		unlistConfidence:          float64(envInt("MODERATION_UNLIST_CONFIDENCE", 95)),
+		thumbnailRejectConfidence: float64(envInt("MODERATION_THUMBNAIL_REJECT_CONFIDENCE", 90)),
+		thumbnailMaxSize:          min(int64(envInt("MODERATION_THUMBNAIL_MAX_SIZE", 1<<20)), moderation.MaxImageSize),
+		thumbnailTimeout:          envDuration("MODERATION_THUMBNAIL_TIMEOUT", 5*time.Second),
+	}
+}
+
+// newModerator returns nil when MODERATION_PROVIDER isn't set, which
+// turns automatic moderation off.
+func newModerator(region string) (moderation.Moderator, error) {
+	switch provider := os.Getenv("MODERATION_PROVIDER"); provider {
+	case "":
+		return nil, nil
+	case "rekognition":
+		if r := os.Getenv("MODERATION_REGION"); r != "" {
+			region = r
+		}
+		awsConfig, err := loadAWSConfig(context.Background(), region)
+		if err != nil {
+			return nil, err
+		}
+		return &moderation.Rekognition{
+			Config:        awsConfig,
+			Client:        &http.Client{Timeout: envDuration("MODERATION_TIMEOUT", 30*time.Second)},
+			MinConfidence: float64(envInt("MODERATION_MIN_CONFIDENCE", 50)),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown moderation provider %q", provider)
+	}
+}
+
+// moderatedLabel is a label along with where it was found.
+type moderatedLabel struct {
+	moderation.Label
+	Source string `json:"source"`
+}
+
+type moderationImage struct {
+	source string
+	data   []byte
+}
+
+// moderateVideoFile samples frames from the processed video at path and
+// moderates them in the background, so uploads don't wait on the provider.
+func (cfg *apiConfig) moderateVideoFile(ctx context.Context, videoID uuid.UUID, path string) {
+	if cfg.moderator == nil {
+		return
+	}
+	images, err := cfg.sampleModerationFrames(ctx, path)
+	if err != nil {
+		log.Printf("moderation: couldn't sample frames of video %s: %v", videoID, err)
+		return
+	}
+	go cfg.moderateVideo(context.WithoutCancel(ctx), videoID, images)
+}
+
+// downloadObject copies a stored object to a temp file and returns its
+// path.
+func (cfg *apiConfig) downloadObject(ctx context.Context, key string) (string, error) {
+	body, err := cfg.store.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	tempFile, err := os.CreateTemp("", tempUploadPattern)
+	if err != nil {
+		return "", err
+	}
+	defer tempFile.Close()
+	if _, err := io.Copy(tempFile, body); err != nil {
+		os.Remove(tempFile.Name())
+		return "", err
+	}
+	return tempFile.Name(), nil
+}
+
+func (cfg *apiConfig) sampleModerationFrames(ctx context.Context, path string) ([]moderationImage, error) {
+	frames, err := media.SampleFrames(ctx, cfg.media, path, cfg.moderation.frames, moderationFrameWidth)
+	if err != nil {
+		return nil, err
+	}
+	images := make([]moderationImage, 0, len(frames))
+	for i, frame := range frames {
+		data, err := os.ReadFile(frame.Path)
+		os.Remove(frame.Path)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, moderationImage{source: fmt.Sprintf("frame %d/%d", i+1, len(frames)), data: data})
+	}
+	return images, nil
+}
+
+// moderateVideo submits the images to the provider, stores the labels and
+// flags or unlists the video when the policy says so.
+func (cfg *apiConfig) moderateVideo(ctx context.Context, videoID uuid.UUID, images []moderationImage) {
+	labels := []moderatedLabel{}
+	for _, image := range images {
+		found, err := cfg.moderator.ModerateImage(ctx, image.data)
+		if err != nil {
+			log.Printf("moderation: couldn't moderate %s of video %s: %v", image.source, videoID, err)
+			return
+		}
+		for _, label := range found {
+			labels = append(labels, moderatedLabel{Label: label, Source: image.source})
+		}
+	}
+
+	// The row is loaded only now since the provider can take a while.
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		if err != nil {
+			log.Printf("moderation: couldn't get video %s: %v", videoID, err)
+		}
+		return
+	}
+	if err := cfg.applyModeration(video, labels); err != nil {
+		log.Printf("moderation: video %s: %v", videoID, err)
+	}
+}
+
+// topLabel returns the label found with the most confidence, or nil.
+func topLabel(labels []moderatedLabel) *moderatedLabel {
+	var top *moderatedLabel
+	for i := range labels {
+		if top == nil || labels[i].Confidence > top.Confidence {
+			top = &labels[i]
+		}
+	}
+	return top
+}
+
+func (cfg *apiConfig) applyModeration(video database.Video, labels []moderatedLabel) error {
+	top := topLabel(labels)
+
+	status := database.ModerationPassed
+	if top != nil {
+		switch {
+		case cfg.moderation.unlistConfidence > 0 && top.Confidence >= cfg.moderation.unlistConfidence:
+			status = database.ModerationUnlisted
+		case cfg.moderation.flagConfidence > 0 && top.Confidence >= cfg.moderation.flagConfidence:
+			status = database.ModerationFlagged
+		}
+	}
+
+	dat, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	err = cfg.db.SetModerationResult(database.ModerationResult{
+		VideoID:   video.ID,
+		CheckedAt: time.Now(),
+		Provider:  cfg.moderator.Name(),
+		Status:    status,
+		Labels:    dat,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't store result: %w", err)
+	}
+	if status == database.ModerationPassed {
+		return nil
+	}
+
+	if status == database.ModerationUnlisted && video.Visibility == database.VisibilityPublic {
+		video.Visibility = database.VisibilityUnlisted
+		if err := cfg.db.UpdateVideo(video); err != nil {
+			return fmt.Errorf("couldn't unlist: %w", err)
+		}
+		cfg.refreshSitemap()
+	}
+
+	if err := cfg.flagVideo(video.ID, reportReasonFor(top.Category()), top.describe()); err != nil {
+		return err
+	}
+
+	action := "video.flag"
+	if status == database.ModerationUnlisted {
+		action = "video.unlist"
+	}
+	cfg.audit(database.ActorSystem, action, auditSubjectVideo, video.ID.String(), map[string]any{
+		"provider":   cfg.moderator.Name(),
+		"label":      top.Name,
+		"confidence": top.Confidence,
+	})
+	log.Printf("moderation: video %s %s for %s (%.1f%%)", video.ID, status, top.Name, top.Confidence)
+	return nil
+}
+
+func (l moderatedLabel) describe() string {
+	return fmt.Sprintf("Automatic moderation found %s (%.1f%%) in %s", l.Name, l.Confidence, l.Source)
+}
+
+// flagVideo puts the video in the moderation queue. Flags go through the
+// same queue as user reports, filed by the nil user, so a video has at
+// most one open automatic report.
+func (cfg *apiConfig) flagVideo(videoID uuid.UUID, reason, message string) error {
+	_, err := cfg.db.CreateReport(database.CreateReportParams{
+		VideoID:    videoID,
+		ReporterID: uuid.Nil,
+		Reason:     reason,
+		Message:    message,
+	})
+	if err != nil && !errors.Is(err, database.ErrAlreadyReported) {
+		return fmt.Errorf("couldn't file report: %w", err)
+	}
+	return nil
+}
+
+// thumbnailVerdict is the outcome of checking a thumbnail before it's
+// published. A flagged thumbnail is published and queued for review.
+type thumbnailVerdict struct {
+	reject bool
+	flag   bool
+	reason string
+	detail string
+}
+
+// moderateThumbnail checks an uploaded thumbnail while the request waits.
+// Thumbnails the provider can't take inline, and provider failures, don't
+// block the upload; they're flagged for a moderator to look at instead.
+func (cfg *apiConfig) moderateThumbnail(ctx context.Context, mediaType string, file io.ReadSeeker, size int64) (thumbnailVerdict, error) {
+	unchecked := thumbnailVerdict{flag: true, reason: "other", detail: "Thumbnail couldn't be checked automatically"}
+	if cfg.moderator == nil {
+		return thumbnailVerdict{}, nil
+	}
+	if size > cfg.moderation.thumbnailMaxSize || (mediaType != "image/jpeg" && mediaType != "image/png") {
+		return unchecked, nil
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return thumbnailVerdict{}, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return thumbnailVerdict{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.moderation.thumbnailTimeout)
+	defer cancel()
+	found, err := cfg.moderator.ModerateImage(ctx, data)
+	if err != nil {
+		log.Printf("moderation: couldn't moderate thumbnail: %v", err)
+		return unchecked, nil
+	}
+
+	labels := make([]moderatedLabel, 0, len(found))
+	for _, label := range found {
+		labels = append(labels, moderatedLabel{Label: label, Source: "thumbnail"})
+	}
+	top := topLabel(labels)
+	switch {
+	case top == nil:
+		return thumbnailVerdict{}, nil
+	case cfg.moderation.thumbnailRejectConfidence > 0 && top.Confidence >= cfg.moderation.thumbnailRejectConfidence:
+		return thumbnailVerdict{reject: true, reason: reportReasonFor(top.Category()), detail: top.describe()}, nil
+	case cfg.moderation.flagConfidence > 0 && top.Confidence >= cfg.moderation.flagConfidence:
+		return thumbnailVerdict{flag: true, reason: reportReasonFor(top.Category()), detail: top.describe()}, nil
+	}
+	return thumbnailVerdict{}, nil
+}
+
+// reportReasonFor maps a provider's top level category onto the report
+// reasons users pick from.
+func reportReasonFor(category string) string {
+	switch category {
+	case "Explicit Nudity", "Explicit", "Suggestive", "Non-Explicit Nudity of Intimate parts and Kissing", "Swimwear or Underwear":
+		return "sexual"
+	case "Violence", "Visually Disturbing":
+		return "violence"
+	case "Hate Symbols":
+		return "hate"
+	}
+	return "other"
+}
+
+func (cfg *apiConfig) handlerAdminVideoModeration(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidID, "Invalid video ID", err)
+		return
+	}
+
+	result, err := cfg.db.GetModerationResult(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get moderation result", err)
+		return
+	}
+	if result == nil {
+		respondWithError(w, http.StatusNotFound, "Video hasn't been moderated", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, result)
+}
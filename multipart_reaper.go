@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// reapMultipartUploads aborts multipart uploads that were started more
+// than MULTIPART_REAP_AFTER ago and that no live upload session is still
+// filling, since their parts are stored and billed until someone does.
+// Sessions that expired along the way are removed with their upload.
+func (cfg *apiConfig) reapMultipartUploads(ctx context.Context) error {
+	lister, ok := cfg.store.(storage.MultipartLister)
+	if !ok {
+		return nil
+	}
+	after := envDuration("MULTIPART_REAP_AFTER", 24*time.Hour)
+	now := time.Now()
+	uploads, err := lister.ListMultipart(ctx, now.Add(-after))
+	if err != nil {
+		return fmt.Errorf("couldn't list multipart uploads: %w", err)
+	}
+
+	var (
+		aborted int
+		parts   int
+		bytes   int64
+	)
+	for _, upload := range uploads {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		session, err := cfg.db.GetUploadSessionByUploadID(upload.UploadID)
+		if err != nil {
+			return fmt.Errorf("couldn't look up session of upload %s: %w", upload.UploadID, err)
+		}
+		if session.ID != uuid.Nil && session.ExpiresAt.After(now) {
+			continue
+		}
+
+		n, size, err := lister.MultipartParts(ctx, upload.Key, upload.UploadID)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("multipart_reaper: couldn't list parts of %s (%s): %v", upload.Key, upload.UploadID, err)
+		}
+		if err := cfg.store.AbortMultipart(ctx, upload.Key, upload.UploadID); err != nil {
+			cfg.reportStorageError("abort_multipart", upload.Key, err)
+			log.Printf("multipart_reaper: couldn't abort %s (%s): %v", upload.Key, upload.UploadID, err)
+			continue
+		}
+		if session.ID != uuid.Nil {
+			if err := cfg.db.DeleteUploadSession(session.ID); err != nil {
+				log.Printf("multipart_reaper: couldn't delete expired session %s: %v", session.ID, err)
+			}
+		}
+		log.Printf("multipart_reaper: aborted %s (%s), started %s, %d parts, %d bytes", upload.Key, upload.UploadID, upload.Initiated.Format(time.RFC3339), n, size)
+		aborted++
+		parts += n
+		bytes += size
+	}
+
+	if aborted > 0 {
+		log.Printf("multipart_reaper: aborted %d uploads, reclaimed %d parts (%d bytes)", aborted, parts, bytes)
+	}
+	return nil
+}
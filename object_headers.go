@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// maxMetadataFilename bounds the original file name kept in object
+// metadata. S3 allows 2 KB of user metadata per object in all.
+const maxMetadataFilename = 255
+
+// objectHeaders is the response metadata stored with new objects, so the
+// CDN and browsers cache and present them right without anyone editing
+// objects by hand.
+type objectHeaders struct {
+	// immutableCacheControl is for keys that are never written twice,
+	// mutableCacheControl for the ones overwritten in place.
+	immutableCacheControl string
+	mutableCacheControl   string
+	// disposition is "inline" or "attachment", or empty to leave
+	// Content-Disposition unset. filenameTemplate names the file in it.
+	disposition      string
+	filenameTemplate string
+	// language is the Content-Language of video objects.
+	language string
+}
+
+// filenamePlaceholders can appear in OBJECT_FILENAME_TEMPLATE.
+var filenamePlaceholders = []string{"{title}", "{video_id}", "{ext}"}
+
+func loadObjectHeaders() (objectHeaders, error) {
+	h := objectHeaders{
+		immutableCacheControl: os.Getenv("OBJECT_CACHE_CONTROL"),
+		mutableCacheControl:   os.Getenv("OBJECT_CACHE_CONTROL_MUTABLE"),
+		disposition:           os.Getenv("OBJECT_CONTENT_DISPOSITION"),
+		filenameTemplate:      os.Getenv("OBJECT_FILENAME_TEMPLATE"),
+		language:              os.Getenv("OBJECT_CONTENT_LANGUAGE"),
+	}
+	if h.immutableCacheControl == "" {
+		h.immutableCacheControl = "public, max-age=31536000, immutable"
+	}
+	if h.mutableCacheControl == "" {
+		h.mutableCacheControl = "public, max-age=300"
+	}
+	if h.filenameTemplate == "" {
+		h.filenameTemplate = "{title}{ext}"
+	}
+	switch h.disposition {
+	case "", "inline", "attachment":
+	default:
+		return h, fmt.Errorf("OBJECT_CONTENT_DISPOSITION must be inline or attachment, got %q", h.disposition)
+	}
+	rest := h.filenameTemplate
+	for _, p := range filenamePlaceholders {
+		rest = strings.ReplaceAll(rest, p, "")
+	}
+	if strings.ContainsAny(rest, "{}") {
+		return h, fmt.Errorf("OBJECT_FILENAME_TEMPLATE can only use %s", strings.Join(filenamePlaceholders, ", "))
+	}
+	return h, nil
+}
+
+// cacheControl picks the policy for a key that's written once, or one
+// that's overwritten in place.
+func (h objectHeaders) cacheControl(mutable bool) string {
+	if mutable {
+		return h.mutableCacheControl
+	}
+	return h.immutableCacheControl
+}
+
+// videoDisposition fills in the filename template for the video, or
+// returns "" when no disposition is configured.
+func (h objectHeaders) videoDisposition(video database.Video, ext string) string {
+	if h.disposition == "" {
+		return ""
+	}
+	title := strings.Map(func(r rune) rune {
+		// Path separators and control characters have no place in a
+		// file name.
+		if r == '/' || r == '\\' || r < ' ' || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(video.Title))
+	if title == "" {
+		title = video.ID.String()
+	}
+	name := strings.NewReplacer("{title}", title, "{video_id}", video.ID.String(), "{ext}", ext).Replace(h.filenameTemplate)
+	return mime.FormatMediaType(h.disposition, map[string]string{"filename": name})
+}
+
+// videoPutOptions is the metadata for a video object of the given media
+// type. Video-hash keys are reused when the same video is stored again,
+// so they get the mutable policy.
+func (cfg *apiConfig) videoPutOptions(video database.Video, mediaType string) storage.PutOptions {
+	return storage.PutOptions{
+		ContentType:        mediaType,
+		CacheControl:       cfg.objectHeaders.cacheControl(cfg.keys.videoHash),
+		ContentDisposition: cfg.objectHeaders.videoDisposition(video, mediaTypeToExtension(mediaType)),
+		ContentLanguage:    cfg.objectHeaders.language,
+		Retention:          cfg.videoRetention(video),
+	}
+}
+
+// videoObjectMetadata is stored with video objects as x-amz-meta-*, so an
+// object found in the bucket can be traced back to its video and uploader
+// without the database. The uploader and file name are left out when
+// they aren't known.
+func videoObjectMetadata(videoID, uploaderID uuid.UUID, filename string, uploadedAt time.Time) map[string]string {
+	meta := map[string]string{
+		"video-id":    videoID.String(),
+		"uploaded-at": uploadedAt.UTC().Format(time.RFC3339),
+	}
+	if uploaderID != uuid.Nil {
+		meta["uploader-id"] = uploaderID.String()
+	}
+	if filename != "" {
+		if len(filename) > maxMetadataFilename {
+			filename = filename[:maxMetadataFilename]
+			for !utf8.ValidString(filename) {
+				filename = filename[:len(filename)-1]
+			}
+		}
+		// Metadata travels as HTTP headers, so names that aren't plain
+		// ASCII are RFC 2047 encoded.
+		meta["original-filename"] = mime.QEncoding.Encode("utf-8", filename)
+	}
+	return meta
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+var (
+	processingStageFailures = metrics.NewCounterVec(
+		"tubely_video_processing_stage_failures_total",
+		"Video processing stage attempts that failed.",
+		"stage",
+	)
+	processingStageRetries = metrics.NewCounterVec(
+		"tubely_video_processing_stage_retries_total",
+		"Video processing stages run again after a failed attempt.",
+		"stage",
+	)
+)
+
+// pipelineState is what the stages of one upload hand to each other.
+// Stages fill it in as they go; cleanup runs once the pipeline is done,
+// whether it got to the end or not.
+type pipelineState struct {
+	job   *processingJob
+	video database.Video
+	// inputPath is the upload as received, path the file that's stored.
+	inputPath string
+	path      string
+	file      *os.File
+
+	aspectRatio string
+	source      media.Stream
+	digests     fileDigests
+	mediaType   string
+	key         string
+	info        storage.ObjectInfo
+	renditions  []encodedRendition
+
+	cleanups []func()
+}
+
+// encodedRendition is a rendition written to disk, and once uploaded, the
+// row that records it. path is empty until it's encoded, stored.Key until
+// it's uploaded.
+type encodedRendition struct {
+	target renditionTarget
+	path   string
+	stored database.VideoRendition
+}
+
+func (s *pipelineState) cleanup(fn func()) {
+	s.cleanups = append(s.cleanups, fn)
+}
+
+func (s *pipelineState) close() {
+	for i := len(s.cleanups) - 1; i >= 0; i-- {
+		s.cleanups[i]()
+	}
+	s.cleanups = nil
+}
+
+// pipelineStage is one step of processing an upload. Stages run in order
+// and may be run again after an error, so they have to start over cleanly
+// from whatever the earlier stages left in the state.
+type pipelineStage interface {
+	name() string
+	run(ctx context.Context, s *pipelineState) error
+}
+
+// stageFunc adapts a function to pipelineStage.
+type stageFunc struct {
+	stageName string
+	fn        func(ctx context.Context, s *pipelineState) error
+}
+
+func (f stageFunc) name() string { return f.stageName }
+
+func (f stageFunc) run(ctx context.Context, s *pipelineState) error { return f.fn(ctx, s) }
+
+// pipelineStep is a stage and how to run it. attempts below 1 counts as
+// 1. The upload fails with the first required stage that runs out of
+// attempts; optional stages only log their errors.
+type pipelineStep struct {
+	stage    pipelineStage
+	attempts int
+	optional bool
+}
+
+type pipeline []pipelineStep
+
+// pipelineRetryDelay is the wait before a stage's second attempt, doubling
+// for each one after.
+const pipelineRetryDelay = time.Second
+
+func (p pipeline) run(ctx context.Context, s *pipelineState) error {
+	for _, step := range p {
+		err := step.runStage(ctx, s)
+		if err == nil {
+			continue
+		}
+		if step.optional && ctx.Err() == nil {
+			log.Printf("Stage %s of video %s failed: %v", step.stage.name(), s.video.ID, err)
+			continue
+		}
+		return err
+	}
+	return nil
+}
+
+func (step pipelineStep) runStage(ctx context.Context, s *pipelineState) error {
+	name := step.stage.name()
+	delay := pipelineRetryDelay
+	for attempt := 1; ; attempt++ {
+		done := s.job.stage(name)
+		err := step.stage.run(ctx, s)
+		done()
+		if err == nil {
+			return nil
+		}
+		processingStageFailures.Inc(name)
+		if attempt >= step.attempts || ctx.Err() != nil {
+			return err
+		}
+
+		log.Printf("Stage %s of video %s failed, trying again in %s: %v", name, s.video.ID, delay, err)
+		processingStageRetries.Inc(name)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// videoPipeline lists the stages an uploaded video goes through. New
+// steps go here, in the order they should run.
+func (cfg *apiConfig) videoPipeline() pipeline {
+	return pipeline{
+		{stage: stageFunc{stageValidate, cfg.validateStage}},
+		{stage: stageFunc{stageRemux, cfg.remuxStage}},
+		{stage: stageFunc{stageWatermark, cfg.watermarkStage}},
+		{stage: stageFunc{stageProbe, cfg.probeStage}},
+		{stage: stageFunc{stageRenditions, cfg.renditionsStage}, attempts: envInt("UPLOAD_ATTEMPTS", 3)},
+		{stage: stageFunc{stageThumbnails, cfg.thumbnailsStage}, optional: true},
+		{stage: stageFunc{stageUpload, cfg.uploadStage}, attempts: envInt("UPLOAD_ATTEMPTS", 3)},
+		{stage: stageFunc{stageFinalize, cfg.finalizeStage}},
+		{stage: stageFunc{stageChapters, cfg.chaptersStage}, optional: true},
+		{stage: stageFunc{stageModeration, cfg.moderationStage}, optional: true},
+		{stage: stageFunc{stageTranscription, cfg.transcriptionStage}, optional: true},
+		{stage: stageFunc{stagePublish, cfg.publishStage}},
+	}
+}
+
+// validateStage turns away truncated and corrupt uploads, and ones longer
+// or bigger than the plan allows, before any work is spent on them or any
+// of their bytes are stored.
+func (cfg *apiConfig) validateStage(ctx context.Context, s *pipelineState) error {
+	if err := cfg.media.Validate(ctx, s.inputPath); err != nil {
+		return fmt.Errorf("couldn't validate uploaded video: %w", err)
+	}
+	if s.job.reprocess {
+		return nil
+	}
+
+	metadata, err := cfg.media.Probe(ctx, s.inputPath)
+	if err != nil {
+		return fmt.Errorf("couldn't probe uploaded video: %w", err)
+	}
+	plan, err := cfg.videoPlan(s.video)
+	if err != nil {
+		return fmt.Errorf("couldn't get plan: %w", err)
+	}
+	stream, _ := metadata.Video()
+	if details := mediaLimitDetails(plan, metadata.Duration(), stream.Width, stream.Height); len(details) > 0 {
+		return &mediaLimitError{planID: plan.ID, details: details}
+	}
+	return nil
+}
+
+func (cfg *apiConfig) remuxStage(ctx context.Context, s *pipelineState) error {
+	path, err := cfg.media.FastStart(ctx, s.inputPath, s.job.progress)
+	if err != nil {
+		return fmt.Errorf("couldn't process video for fast start: %w", err)
+	}
+	// An upload that's already fast start isn't copied, and its file is
+	// removed by whoever received it.
+	if path != s.inputPath {
+		s.cleanup(func() { os.Remove(path) })
+	}
+	s.path = path
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("couldn't open processed video file: %w", err)
+	}
+	s.cleanup(func() { file.Close() })
+	s.file = file
+	// Whatever container was uploaded, the fast start remux writes MP4.
+	s.mediaType = "video/mp4"
+	return nil
+}
+
+// watermarkStage stamps WATERMARK_IMAGE onto videos made with the
+// watermark setting, before anything is encoded or grabbed from them, so
+// renditions and thumbnails carry it too. Reprocessing starts from the
+// stored video, which already has it.
+func (cfg *apiConfig) watermarkStage(ctx context.Context, s *pipelineState) error {
+	if !s.video.Watermark || s.job.reprocess {
+		return nil
+	}
+	if cfg.watermarkImage == "" {
+		return errors.New("video wants a watermark but WATERMARK_IMAGE isn't set")
+	}
+
+	output := s.path + ".watermarked"
+	s.cleanup(func() { os.Remove(output) })
+	if err := cfg.media.Watermark(ctx, s.path, cfg.watermarkImage, output, s.job.progress); err != nil {
+		return fmt.Errorf("couldn't watermark video: %w", err)
+	}
+	file, err := os.Open(output)
+	if err != nil {
+		return fmt.Errorf("couldn't open watermarked video file: %w", err)
+	}
+	s.cleanup(func() { file.Close() })
+	s.path = output
+	s.file = file
+	return nil
+}
+
+// probeStage sorts the video by aspect ratio, records its colour format
+// and hashes it.
+func (cfg *apiConfig) probeStage(ctx context.Context, s *pipelineState) error {
+	aspectRatio, err := media.AspectRatioCategory(ctx, cfg.media, s.path)
+	if err != nil {
+		return fmt.Errorf("couldn't get video aspect ratio: %w", err)
+	}
+	metadata, err := cfg.media.Probe(ctx, s.path)
+	if err != nil {
+		return fmt.Errorf("couldn't probe processed video: %w", err)
+	}
+	digests, err := hashFile(s.file)
+	if err != nil {
+		return fmt.Errorf("couldn't hash processed video file: %w", err)
+	}
+	s.aspectRatio = aspectRatio
+	s.source, _ = metadata.Video()
+	s.video.AspectRatio = aspectRatio
+	s.video.Color = database.ColorInfo{
+		PixelFormat:    s.source.PixelFormat,
+		ColorTransfer:  s.source.ColorTransfer,
+		ColorPrimaries: s.source.ColorPrimaries,
+		HDR:            s.source.HDR(),
+	}
+	s.digests = digests
+	return nil
+}
+
+// renditionsStage encodes the video at each rung of its preset's ladder,
+// picked by its aspect ratio, and uploads each rendition as soon as it's
+// encoded. The rungs are encoded at the same time, as far as the rendition
+// workers allow, and the first to fail stops the rest. Renditions an
+// earlier attempt got done aren't redone. Videos without a preset only
+// keep the original.
+func (cfg *apiConfig) renditionsStage(ctx context.Context, s *pipelineState) error {
+	preset, err := cfg.videoPreset(s.video)
+	if err != nil {
+		return fmt.Errorf("couldn't get preset: %w", err)
+	}
+	if preset == nil {
+		s.renditions = nil
+		return nil
+	}
+
+	targets := renditionTargets(preset.Renditions, s.aspectRatio, s.source.Width, s.source.Height)
+	if s.source.HDR() && len(targets) > 0 && envBool("HDR_RENDITION", false) {
+		hdr := targets[0]
+		hdr.rendition.Name += "-hdr"
+		hdr.hdr = true
+		targets = append(targets, hdr)
+	}
+	if !sameTargets(s.renditions, targets) {
+		s.renditions = make([]encodedRendition, len(targets))
+		for i, target := range targets {
+			s.renditions[i].target = target
+			output := fmt.Sprintf("%s.%d.mp4", s.path, i)
+			s.cleanup(func() { os.Remove(output) })
+		}
+	}
+
+	putOptions := cfg.videoPutOptions(s.video, s.mediaType)
+	putOptions.Metadata = videoObjectMetadata(s.video.ID, s.job.uploaderID, s.job.filename, s.job.started)
+	key := cfg.videoObjectKey(s)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		failOnce sync.Once
+		firstErr error
+	)
+	for i := range s.renditions {
+		r := &s.renditions[i]
+		if r.stored.Key != "" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			output := fmt.Sprintf("%s.%d.mp4", s.path, i)
+			err := cfg.encodeRendition(ctx, s, preset, r, output)
+			if err == nil {
+				err = cfg.uploadRendition(ctx, s.video.ID, key, r, putOptions)
+			}
+			if err != nil {
+				failOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// sameTargets reports whether renditions were made for exactly these
+// targets, so a retry can pick up where the last attempt stopped.
+func sameTargets(renditions []encodedRendition, targets []renditionTarget) bool {
+	if len(renditions) != len(targets) {
+		return false
+	}
+	for i, r := range renditions {
+		if r.target != targets[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeRendition writes r to output, unless an earlier attempt already
+// did, once a rendition worker is free.
+func (cfg *apiConfig) encodeRendition(ctx context.Context, s *pipelineState, preset *database.TranscodingPreset, r *encodedRendition, output string) error {
+	if r.path != "" {
+		return nil
+	}
+	release, err := cfg.renditionWorkers.acquirePriority(ctx, s.job.priority)
+	if err != nil {
+		return fmt.Errorf("couldn't get a worker for %s rendition: %w", r.target.rendition.Name, err)
+	}
+	defer release()
+
+	err = cfg.media.Transcode(ctx, s.path, transcodeOptions(preset, r.target, s.source), output, nil)
+	if err != nil {
+		return fmt.Errorf("couldn't encode %s rendition: %w", r.target.rendition.Name, err)
+	}
+	r.path = output
+	return nil
+}
+
+// videoObjectKey returns where the processed video is stored, picking it
+// the first time. Renditions are stored next to it, before the video
+// itself is uploaded.
+func (cfg *apiConfig) videoObjectKey(s *pipelineState) string {
+	if s.key == "" {
+		s.key = cfg.keys.objectKey(objectKeyParams{
+			kind:        s.aspectRatio,
+			userID:      s.video.UserID,
+			videoID:     s.video.ID,
+			ext:         mediaTypeToExtension(s.mediaType),
+			contentHash: s.digests.sha256,
+			now:         time.Now(),
+		})
+	}
+	return s.key
+}
+
+func (cfg *apiConfig) thumbnailsStage(ctx context.Context, s *pipelineState) error {
+	s.video = cfg.storeThumbnailCandidates(ctx, s.video, s.path)
+	return nil
+}
+
+func (cfg *apiConfig) uploadStage(ctx context.Context, s *pipelineState) error {
+	// An earlier attempt may have read some of the file.
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("couldn't rewind processed video file: %w", err)
+	}
+	key := cfg.videoObjectKey(s)
+	putOptions := cfg.videoPutOptions(s.video, s.mediaType)
+	putOptions.ContentMD5 = s.digests.contentMD5
+	putOptions.Metadata = videoObjectMetadata(s.video.ID, s.job.uploaderID, s.job.filename, s.job.started)
+	info, err := cfg.store.Put(ctx, key, s.file, putOptions)
+	if err != nil {
+		cfg.reportStorageError("put", key, err)
+		return fmt.Errorf("couldn't upload video: %w", err)
+	}
+	// S3 only reports the size for directory buckets, and the count from
+	// hashing is exactly what was sent.
+	info.Size = s.digests.size
+	s.info = info
+	return nil
+}
+
+func (cfg *apiConfig) uploadRendition(ctx context.Context, videoID uuid.UUID, videoKey string, r *encodedRendition, putOptions storage.PutOptions) error {
+	file, err := os.Open(r.path)
+	if err != nil {
+		return fmt.Errorf("couldn't open %s rendition: %w", r.target.rendition.Name, err)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("couldn't stat %s rendition: %w", r.target.rendition.Name, err)
+	}
+
+	key := renditionKey(videoKey, r.target)
+	if _, err := cfg.store.Put(ctx, key, file, putOptions); err != nil {
+		cfg.reportStorageError("put", key, err)
+		return fmt.Errorf("couldn't upload %s rendition: %w", r.target.rendition.Name, err)
+	}
+	r.stored = database.VideoRendition{
+		VideoID: videoID,
+		Name:    r.target.rendition.Name,
+		Width:   r.target.width,
+		Height:  r.target.height,
+		Key:     key,
+		Size:    stat.Size(),
+		HDR:     r.target.hdr,
+	}
+	return nil
+}
+
+// finalizeStage records the new object on the video row and in the
+// owner's usage.
+func (cfg *apiConfig) finalizeStage(ctx context.Context, s *pipelineState) error {
+	processedAt := time.Now().UTC()
+	s.video.ProcessingVersion = processingVersion
+	s.video.ProcessedAt = &processedAt
+	video, err := cfg.attachVideoObject(ctx, s.video, s.key, s.info)
+	s.video = video
+	if err != nil {
+		return err
+	}
+	renditions := make([]database.VideoRendition, 0, len(s.renditions))
+	for _, r := range s.renditions {
+		renditions = append(renditions, r.stored)
+	}
+	return cfg.saveVideoRenditions(ctx, video, renditions)
+}
+
+func (cfg *apiConfig) chaptersStage(ctx context.Context, s *pipelineState) error {
+	cfg.storeProbedChapters(ctx, s.video.ID, s.path)
+	return nil
+}
+
+func (cfg *apiConfig) moderationStage(ctx context.Context, s *pipelineState) error {
+	cfg.moderateVideoFile(ctx, s.video.ID, s.path)
+	return nil
+}
+
+func (cfg *apiConfig) transcriptionStage(ctx context.Context, s *pipelineState) error {
+	cfg.transcribeVideoFile(ctx, s.video.ID, s.path)
+	return nil
+}
+
+func (cfg *apiConfig) publishStage(ctx context.Context, s *pipelineState) error {
+	cfg.domainEvents.Publish(domainVideoProcessed, s.video.ID, newVideoDomainEvent(s.video))
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// playbackClockSkew backdates the start of a scoped URL's validity window
+// so viewers whose clocks run a little behind CloudFront's aren't refused.
+const playbackClockSkew = time.Minute
+
+// playbackPolicy controls how long signed playback URLs for one visibility
+// level last and whether they only work from the requester's network.
+type playbackPolicy struct {
+	TTL time.Duration
+	// IPv4Bits and IPv6Bits are the prefix lengths URLs are bound to.
+	// Zero leaves URLs usable from anywhere.
+	IPv4Bits int
+	IPv6Bits int
+	// scoped policies sign with an explicit validity window, not just an
+	// expiry.
+	scoped bool
+}
+
+// loadPlaybackPolicies reads PLAYBACK_URL_POLICIES, a comma separated list
+// of "visibility:ttl[:v4bits[/v6bits]]" entries such as
+// "private:15m:24/64". Levels without an entry get defaultTTL and URLs
+// that work from anywhere.
+func loadPlaybackPolicies(defaultTTL time.Duration) (map[string]playbackPolicy, error) {
+	policies := map[string]playbackPolicy{}
+	for _, v := range []string{database.VisibilityPublic, database.VisibilityUnlisted, database.VisibilityPrivate} {
+		policies[v] = playbackPolicy{TTL: defaultTTL}
+	}
+
+	for _, entry := range strings.Split(os.Getenv("PLAYBACK_URL_POLICIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fields := strings.Split(entry, ":")
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("invalid playback policy %q", entry)
+		}
+		visibility := strings.TrimSpace(fields[0])
+		if !database.ValidVisibility(visibility) {
+			return nil, fmt.Errorf("unknown visibility %q", visibility)
+		}
+		ttl, err := time.ParseDuration(strings.TrimSpace(fields[1]))
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid TTL for %s: %q", visibility, fields[1])
+		}
+
+		policy := playbackPolicy{TTL: ttl, scoped: true}
+		if len(fields) == 3 {
+			v4, v6, hasV6 := strings.Cut(strings.TrimSpace(fields[2]), "/")
+			policy.IPv4Bits, err = strconv.Atoi(v4)
+			if err != nil || policy.IPv4Bits < 0 || policy.IPv4Bits > 32 {
+				return nil, fmt.Errorf("invalid IPv4 prefix length for %s: %q", visibility, v4)
+			}
+			// A /64 is what a single IPv6 subscriber usually gets.
+			policy.IPv6Bits = 64
+			if hasV6 {
+				policy.IPv6Bits, err = strconv.Atoi(v6)
+				if err != nil || policy.IPv6Bits < 0 || policy.IPv6Bits > 128 {
+					return nil, fmt.Errorf("invalid IPv6 prefix length for %s: %q", visibility, v6)
+				}
+			}
+		}
+		policies[visibility] = policy
+	}
+	return policies, nil
+}
+
+// signOptions applies the policy to a request from clientIP. Addresses
+// that don't parse leave the URL unbound rather than failing playback.
+func (p playbackPolicy) signOptions(now time.Time, clientIP string) storage.SignOptions {
+	opts := storage.SignOptions{Expires: now.Add(p.TTL)}
+	if !p.scoped {
+		return opts
+	}
+	opts.NotBefore = now.Add(-playbackClockSkew)
+
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return opts
+	}
+	addr = addr.Unmap()
+	bits := p.IPv6Bits
+	if addr.Is4() {
+		bits = p.IPv4Bits
+	}
+	if bits > 0 {
+		prefix, err := addr.Prefix(bits)
+		if err == nil {
+			opts.IPRange = prefix.String()
+		}
+	}
+	return opts
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+	"github.com/google/uuid"
+)
+
+// processingVersion identifies what the pipeline produces. Bump it when
+// that changes, so the reprocess job picks up videos made by older
+// versions.
+const processingVersion = 3
+
+const (
+	stageCopy          = "copy"
+	stageQueued        = "queued"
+	stageFetch         = "fetch"
+	stageValidate      = "validate"
+	stageRemux         = "remux"
+	stageWatermark     = "watermark"
+	stageProbe         = "probe"
+	stageRenditions    = "renditions"
+	stageThumbnails    = "thumbnails"
+	stageUpload        = "upload"
+	stageFinalize      = "finalize"
+	stageChapters      = "chapters"
+	stageModeration    = "moderation"
+	stageTranscription = "transcription"
+	stagePublish       = "publish"
+)
+
+var (
+	processingStageSeconds = metrics.NewHistogramVec(
+		"tubely_video_processing_stage_seconds",
+		"Time spent in each stage of video processing.",
+		"stage",
+		metrics.DurationBuckets,
+	)
+	processingJobSeconds = metrics.NewHistogramVec(
+		"tubely_video_processing_job_seconds",
+		"End to end time to process an uploaded video.",
+		"",
+		metrics.DurationBuckets,
+	)
+)
+
+type stageTiming struct {
+	Stage    string
+	Duration time.Duration
+}
+
+// processingJob times the stages of a single video upload. Stage and job
+// durations feed the processing histograms, and jobs that cross the
+// configured thresholds are logged with their breakdown.
+type processingJob struct {
+	cfg     *apiConfig
+	video   database.Video
+	started time.Time
+	size    int64
+	stages  []stageTiming
+	// uploaderID and filename describe where the upload came from, and
+	// are stored with the object. Either can be unknown.
+	uploaderID uuid.UUID
+	filename   string
+	// reprocess is set when a stored video goes through the pipeline
+	// again. The plan's duration and resolution limits only hold new
+	// uploads back, so videos stored before they changed still reprocess.
+	reprocess bool
+	// sourceKey is where the upload is in the store, when it isn't on
+	// local disk, and staged whether it's a staging copy to delete once
+	// it's been processed.
+	sourceKey string
+	staged    bool
+	// priority is the plan priority the job waits for workers at.
+	priority int
+
+	// current is the stage running now and percent how far ffmpeg has
+	// got through the video. Status requests read them while the job
+	// runs.
+	mu      sync.Mutex
+	current string
+	percent int
+}
+
+func (cfg *apiConfig) newProcessingJob(video database.Video) *processingJob {
+	return &processingJob{
+		cfg:     cfg,
+		video:   video,
+		started: time.Now(),
+	}
+}
+
+// stage starts timing the named stage and returns the func that stops it.
+func (j *processingJob) stage(name string) func() {
+	start := time.Now()
+	j.mu.Lock()
+	j.current = name
+	j.mu.Unlock()
+	return func() {
+		d := time.Since(start)
+		j.stages = append(j.stages, stageTiming{Stage: name, Duration: d})
+		processingStageSeconds.Observe(name, d.Seconds())
+	}
+}
+
+// progress records how far ffmpeg has got, as reported by media.FastStart,
+// and puts each whole percent on the admin event stream.
+func (j *processingJob) progress(done float64) {
+	percent := int(done * 100)
+	j.mu.Lock()
+	if percent <= j.percent {
+		j.mu.Unlock()
+		return
+	}
+	j.percent = percent
+	stage := j.current
+	j.mu.Unlock()
+	j.cfg.events.Publish(eventProcessingProgress, processingProgressEvent{
+		VideoID: j.video.ID,
+		UserID:  j.video.UserID,
+		Stage:   stage,
+		Percent: percent,
+	})
+}
+
+func (j *processingJob) status() processingStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	started := j.started
+	return processingStatus{
+		VideoID:   j.video.ID,
+		State:     processingStateRunning,
+		Stage:     j.current,
+		Percent:   j.percent,
+		StartedAt: &started,
+	}
+}
+
+// Processing states, as reported by the status endpoint.
+const (
+	processingStateRunning = "processing"
+	processingStateIdle    = "idle"
+)
+
+type processingStatus struct {
+	VideoID     uuid.UUID  `json:"video_id"`
+	State       string     `json:"state"`
+	Stage       string     `json:"stage,omitempty"`
+	Percent     int        `json:"percent"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	ProcessedAt *time.Time `json:"processed_at,omitempty"`
+}
+
+// processingJobs are the jobs running on this instance, by video. A job
+// running on another instance isn't seen here.
+type processingJobs struct {
+	mu   sync.Mutex
+	jobs map[uuid.UUID]*processingJob
+}
+
+func newProcessingJobs() *processingJobs {
+	return &processingJobs{jobs: map[uuid.UUID]*processingJob{}}
+}
+
+func (p *processingJobs) add(j *processingJob) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.jobs[j.video.ID] = j
+}
+
+// remove forgets the job, unless a newer job for the same video has
+// already taken its place.
+func (p *processingJobs) remove(j *processingJob) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.jobs[j.video.ID] == j {
+		delete(p.jobs, j.video.ID)
+	}
+}
+
+// counts returns how many of the jobs are waiting for a video worker and
+// how many are being processed.
+func (p *processingJobs) counts() (queued, running int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, j := range p.jobs {
+		j.mu.Lock()
+		if j.current == "" || j.current == stageQueued {
+			queued++
+		} else {
+			running++
+		}
+		j.mu.Unlock()
+	}
+	return queued, running
+}
+
+func (p *processingJobs) get(videoID uuid.UUID) *processingJob {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.jobs[videoID]
+}
+
+// handlerVideoProcessingStatus reports how far processing of the video's
+// latest upload has got, so clients can show a progress bar instead of
+// waiting on the upload request alone.
+func (cfg *apiConfig) handlerVideoProcessingStatus(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizedVideo(w, r, permEdit)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.processingStatus(video))
+}
+
+// processingStatus is the state of the video's job on this instance, or
+// idle when there's none.
+func (cfg *apiConfig) processingStatus(video database.Video) processingStatus {
+	if job := cfg.processing.get(video.ID); job != nil {
+		return job.status()
+	}
+	status := processingStatus{VideoID: video.ID, State: processingStateIdle, ProcessedAt: video.ProcessedAt}
+	if video.ProcessedAt != nil {
+		status.Percent = 100
+	}
+	return status
+}
+
+func (j *processingJob) finish(err error) {
+	total := time.Since(j.started)
+	processingJobSeconds.Observe("", total.Seconds())
+	j.record(total, err)
+	if err != nil {
+		stageMS := make(map[string]int64, len(j.stages))
+		for _, s := range j.stages {
+			stageMS[s.Stage] = s.Duration.Milliseconds()
+		}
+		j.cfg.events.Publish(eventProcessingFailed, processingFailedEvent{
+			VideoID: j.video.ID,
+			UserID:  j.video.UserID,
+			Error:   err.Error(),
+			Stages:  stageMS,
+		})
+	}
+
+	slowStage := ""
+	for _, s := range j.stages {
+		if j.cfg.slowStageThreshold > 0 && s.Duration > j.cfg.slowStageThreshold {
+			slowStage = s.Stage
+			break
+		}
+	}
+	slowJob := j.cfg.slowJobThreshold > 0 && total > j.cfg.slowJobThreshold
+	if !slowJob && slowStage == "" {
+		return
+	}
+
+	stages := make([]any, 0, len(j.stages))
+	for _, s := range j.stages {
+		stages = append(stages, slog.Duration(s.Stage, s.Duration))
+	}
+	attrs := []any{
+		slog.String("video_id", j.video.ID.String()),
+		slog.String("user_id", j.video.UserID.String()),
+		slog.Int64("size", j.size),
+		slog.Duration("total", total),
+		slog.Group("stages", stages...),
+	}
+	if slowStage != "" {
+		attrs = append(attrs, slog.String("slow_stage", slowStage))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	slog.Warn("slow video processing job", attrs...)
+}
+
+// record keeps the run for the admin activity endpoints and counts it in
+// the hourly totals.
+func (j *processingJob) record(total time.Duration, err error) {
+	j.mu.Lock()
+	stage := j.current
+	j.mu.Unlock()
+
+	run := database.ProcessingRun{
+		StartedAt:  j.started,
+		VideoID:    j.video.ID,
+		UserID:     j.video.UserID,
+		Filename:   j.filename,
+		Size:       j.size,
+		Reprocess:  j.reprocess,
+		DurationMS: total.Milliseconds(),
+		Status:     database.ProcessingSucceeded,
+	}
+	if err != nil {
+		run.Status = database.ProcessingFailed
+		run.Stage = stage
+		run.Error = err.Error()
+		var ffmpegErr *media.FFmpegError
+		if errors.As(err, &ffmpegErr) {
+			run.Stderr = ffmpegErr.Stderr
+		}
+	}
+	if recordErr := j.cfg.db.CreateProcessingRun(run); recordErr != nil {
+		log.Printf("Couldn't record processing run of video %s: %v", j.video.ID, recordErr)
+	}
+	if recordErr := j.cfg.db.RecordProcessingOutcome(time.Now(), err != nil, j.size); recordErr != nil {
+		log.Printf("Couldn't record processing outcome of video %s: %v", j.video.ID, recordErr)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+// renditionTarget is one rung of a preset's ladder fitted to a video.
+type renditionTarget struct {
+	rendition database.Rendition
+	width     int
+	height    int
+	// hdr keeps an HDR source's colour; other renditions are tone mapped
+	// to SDR.
+	hdr bool
+}
+
+// renditionTargets fits a preset's ladder to a video of the given size. A
+// rung's height is the short side of the picture, as in "720p", so the
+// ladder is turned upright for portrait videos: the 1080 rung of a 9:16
+// upload is 1080x1920, not 608x1080. Rungs bigger than the video are left
+// out, since upscaling only costs storage.
+func renditionTargets(ladder []database.Rendition, aspectRatio string, width, height int) []renditionTarget {
+	if width <= 0 || height <= 0 {
+		return nil
+	}
+	targets := []renditionTarget{}
+	for _, r := range ladder {
+		t := renditionTarget{rendition: r}
+		if aspectRatio == "portrait" {
+			t.width = r.Height
+			t.height = evenSize(float64(r.Height) * float64(height) / float64(width))
+		} else {
+			t.height = r.Height
+			t.width = evenSize(float64(r.Height) * float64(width) / float64(height))
+		}
+		if t.width > width || t.height > height {
+			continue
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// transcodeOptions is how a rendition of source is encoded. HDR sources
+// are tone mapped for the regular ladder, which would otherwise come out
+// washed out on SDR screens. The HDR rendition is encoded with h265 when
+// the preset asks for h264, which can't carry it. Sources faster than the
+// preset's frame rate cap are brought down to it; slower ones keep theirs.
+func transcodeOptions(preset *database.TranscodingPreset, t renditionTarget, source media.Stream) media.TranscodeOptions {
+	opts := media.TranscodeOptions{
+		Width:            t.width,
+		Height:           t.height,
+		VideoCodec:       preset.VideoCodec,
+		AudioCodec:       preset.AudioCodec,
+		VideoBitrateKbps: t.rendition.VideoBitrateKbps,
+		AudioBitrateKbps: t.rendition.AudioBitrateKbps,
+		ToneMap:          source.HDR() && !t.hdr,
+	}
+	if preset.MaxFrameRate > 0 && source.FrameRate() > float64(preset.MaxFrameRate) {
+		opts.FrameRate = preset.MaxFrameRate
+	}
+	if t.hdr {
+		opts.HDR = true
+		opts.ColorTransfer = source.ColorTransfer
+		if opts.VideoCodec == "h264" {
+			opts.VideoCodec = "h265"
+		}
+	}
+	return opts
+}
+
+// evenSize rounds a dimension to the nearest even number, which 4:2:0
+// video needs.
+func evenSize(v float64) int {
+	return max(2, int(math.Round(v/2))*2)
+}
+
+// renditionKey is where a rendition is stored: next to the original, with
+// its size in the name.
+func renditionKey(originalKey string, t renditionTarget) string {
+	suffix := ""
+	if t.hdr {
+		suffix = "_hdr"
+	}
+	return fmt.Sprintf("%s_%dx%d%s.mp4", strings.TrimSuffix(originalKey, path.Ext(originalKey)), t.width, t.height, suffix)
+}
+
+// videoPreset returns the preset the video is processed with, or nil when
+// it has none and only the original is stored.
+func (cfg *apiConfig) videoPreset(video database.Video) (*database.TranscodingPreset, error) {
+	if video.PresetID != "" {
+		return cfg.db.GetPreset(video.PresetID)
+	}
+	return cfg.db.GetUserPreset(video.UserID)
+}
+
+// saveVideoRenditions records the renditions made for the video in place
+// of its current ones, moving the owner's usage by the difference and
+// deleting the objects that are no longer used.
+func (cfg *apiConfig) saveVideoRenditions(ctx context.Context, video database.Video, renditions []database.VideoRendition) error {
+	replaced, err := cfg.db.SetVideoRenditions(video.ID, renditions)
+	if err != nil {
+		return fmt.Errorf("couldn't save video renditions: %w", err)
+	}
+
+	kept := map[string]bool{}
+	var bytesDelta, objectsDelta int64
+	for _, r := range renditions {
+		kept[r.Key] = true
+		bytesDelta += r.Size
+		objectsDelta++
+	}
+	for _, r := range replaced {
+		bytesDelta -= r.Size
+		objectsDelta--
+	}
+	if err := cfg.adjustVideoUsage(video, bytesDelta, objectsDelta); err != nil {
+		return fmt.Errorf("couldn't update storage usage: %w", err)
+	}
+
+	// Renditions are written with the video's retention, so a locked
+	// video's stay until it runs out.
+	if video.Locked(time.Now()) {
+		return nil
+	}
+	ctx = context.WithoutCancel(ctx)
+	for _, r := range replaced {
+		if !kept[r.Key] {
+			cfg.deleteRenditionObject(ctx, video.ID, r.Key)
+		}
+	}
+	return nil
+}
+
+func (cfg *apiConfig) deleteRenditionObject(ctx context.Context, videoID uuid.UUID, key string) {
+	if err := cfg.store.Delete(ctx, key); err != nil {
+		log.Printf("Couldn't delete rendition %s of video %s: %v", key, videoID, err)
+		cfg.reportStorageError("delete", key, err)
+	}
+}
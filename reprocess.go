@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const jobReprocess = "reprocess"
+
+// maxReportedFailures caps the failures kept in a reprocess report.
+const maxReportedFailures = 100
+
+type reprocessFailure struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Error   string    `json:"error"`
+}
+
+type reprocessReport struct {
+	Total     int                `json:"total"`
+	Processed int                `json:"processed"`
+	Failed    int                `json:"failed"`
+	DryRun    bool               `json:"dry_run"`
+	Failures  []reprocessFailure `json:"failures"`
+}
+
+type reprocessFilter struct {
+	UserID   uuid.UUID
+	PresetID string
+}
+
+// handlerAdminReprocess starts a job that runs every stored video produced
+// by an older pipeline version, or before its preset last changed, through
+// the pipeline again. Videos are processed one at a time, at most
+// rate_per_minute of them.
+func (cfg *apiConfig) handlerAdminReprocess(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		UserID        uuid.UUID `json:"user_id"`
+		PresetID      string    `json:"preset_id"`
+		RatePerMinute int       `json:"rate_per_minute"`
+		DryRun        bool      `json:"dry_run"`
+	}
+
+	params := parameters{RatePerMinute: envInt("REPROCESS_RATE_PER_MINUTE", 6)}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil && !errors.Is(err, io.EOF) {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+	if params.RatePerMinute <= 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid reprocess request",
+			Details: []errorDetail{{Field: "rate_per_minute", Message: "must be positive"}},
+		})
+		return
+	}
+
+	// Reprocess jobs aren't anyone's, so they're filed under the nil user.
+	active, err := cfg.db.GetActiveJob(uuid.Nil, jobReprocess)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check jobs", err)
+		return
+	}
+	if active.ID != uuid.Nil {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Reprocess job %s is still running", active.ID), nil)
+		return
+	}
+
+	job, err := cfg.db.CreateJob(jobReprocess, uuid.Nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create job", err)
+		return
+	}
+	filter := reprocessFilter{UserID: params.UserID, PresetID: params.PresetID}
+	interval := time.Minute / time.Duration(params.RatePerMinute)
+	cfg.startJob(job, func(ctx context.Context) (any, error) {
+		return cfg.reprocessVideos(ctx, job.ID, filter, interval, params.DryRun)
+	})
+
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+func (cfg *apiConfig) reprocessVideos(ctx context.Context, jobID uuid.UUID, filter reprocessFilter, interval time.Duration, dryRun bool) (reprocessReport, error) {
+	report := reprocessReport{DryRun: dryRun, Failures: []reprocessFailure{}}
+
+	videos, err := cfg.staleVideos(filter)
+	if err != nil {
+		return report, err
+	}
+	report.Total = len(videos)
+	if dryRun || len(videos) == 0 {
+		return report, nil
+	}
+	if err := cfg.db.UpdateJobReport(jobID, report); err != nil {
+		log.Printf("reprocess: couldn't record progress: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for i, video := range videos {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return report, ctx.Err()
+			case <-ticker.C:
+			}
+		}
+
+		if err := cfg.reprocessVideo(ctx, video, true); err != nil {
+			log.Printf("reprocess: video %s: %v", video.ID, err)
+			report.Failed++
+			if len(report.Failures) < maxReportedFailures {
+				report.Failures = append(report.Failures, reprocessFailure{VideoID: video.ID, Error: err.Error()})
+			}
+		} else {
+			report.Processed++
+		}
+		if err := cfg.db.UpdateJobReport(jobID, report); err != nil {
+			log.Printf("reprocess: couldn't record progress: %v", err)
+		}
+	}
+	return report, nil
+}
+
+// staleVideos lists the stored videos matching filter whose output is out
+// of date: made by an older pipeline or before their preset changed.
+func (cfg *apiConfig) staleVideos(filter reprocessFilter) ([]database.Video, error) {
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list videos: %w", err)
+	}
+
+	userPresets := map[uuid.UUID]*database.TranscodingPreset{}
+	presetFor := func(video database.Video) (*database.TranscodingPreset, error) {
+		if video.PresetID != "" {
+			return cfg.db.GetPreset(video.PresetID)
+		}
+		if preset, ok := userPresets[video.UserID]; ok {
+			return preset, nil
+		}
+		preset, err := cfg.db.GetUserPreset(video.UserID)
+		userPresets[video.UserID] = preset
+		return preset, err
+	}
+
+	stale := []database.Video{}
+	for _, video := range videos {
+		if video.VideoKey == nil || !video.Playable(time.Now()) || (filter.UserID != uuid.Nil && video.UserID != filter.UserID) {
+			continue
+		}
+		preset, err := presetFor(video)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get preset for video %s: %w", video.ID, err)
+		}
+		if filter.PresetID != "" && (preset == nil || preset.ID != filter.PresetID) {
+			continue
+		}
+		outdated := video.ProcessingVersion < processingVersion ||
+			video.ProcessedAt == nil ||
+			(preset != nil && video.ProcessedAt.Before(preset.UpdatedAt))
+		if outdated {
+			stale = append(stale, video)
+		}
+	}
+	return stale, nil
+}
+
+// reprocessVideo runs the stored video through the same pipeline as a
+// fresh upload, which replaces the stored object. The row is reloaded
+// first since the video may have changed since it was listed. reprocess
+// is false for uploads that were stored before they were processed, which
+// are held to the plan's limits like any other upload. The video is
+// locked from before it's downloaded, so an upload finishing meanwhile
+// isn't overwritten with the object it replaced. It's only downloaded
+// once a worker is free, so videos waiting their turn take no disk.
+func (cfg *apiConfig) reprocessVideo(ctx context.Context, video database.Video, reprocess bool) error {
+	ctx, unlock, err := cfg.lockVideo(ctx, video.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		return err
+	}
+	if video.VideoKey == nil {
+		return errors.New("video was deleted or has no stored object anymore")
+	}
+
+	job := cfg.newProcessingJob(video)
+	job.reprocess = reprocess
+	job.sourceKey = *video.VideoKey
+	job.size = video.VideoSize
+	_, err = cfg.storeVideo(ctx, job, video, "")
+	job.finish(err)
+	return err
+}
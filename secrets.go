@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cfsign"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/secrets"
+	"github.com/google/uuid"
+)
+
+// secretSettings can be fetched from AWS instead of being set in
+// plaintext: NAME_FROM holds a secrets.Fetcher reference to where NAME is
+// kept. CLOUDFRONT_PRIVATE_KEY is the PEM itself, standing in for
+// CLOUDFRONT_PRIVATE_KEY_FILE; rotating it usually means a new
+// CLOUDFRONT_KEY_PAIR_ID too, so both can come from the same secret.
+var secretSettings = []string{"JWT_SECRET", "DB_PATH", "CLOUDFRONT_PRIVATE_KEY", "CLOUDFRONT_KEY_PAIR_ID"}
+
+// secretStore holds the settings fetched from AWS. The values aren't put
+// in the environment, where ffmpeg and the other tools the server runs
+// would inherit them.
+type secretStore struct {
+	fetcher secrets.Fetcher
+	refs    map[string]string
+
+	mu     sync.Mutex
+	values map[string]string
+}
+
+// loadSecrets fetches every setting that has a NAME_FROM reference. Only
+// then are AWS credentials needed.
+func loadSecrets(ctx context.Context) (*secretStore, error) {
+	s := &secretStore{refs: map[string]string{}, values: map[string]string{}}
+	for _, name := range secretSettings {
+		ref := os.Getenv(name + "_FROM")
+		if ref == "" {
+			continue
+		}
+		if !secrets.ValidRef(ref) {
+			return nil, fmt.Errorf("%s_FROM must be secretsmanager:<id>[#key] or ssm:<name>, got %q", name, ref)
+		}
+		s.refs[name] = ref
+	}
+	if len(s.refs) == 0 {
+		return s, nil
+	}
+
+	region := os.Getenv("SECRETS_REGION")
+	if region == "" {
+		region = os.Getenv("S3_REGION")
+	}
+	awsConfig, err := loadAWSConfig(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	s.fetcher = secrets.Fetcher{
+		Config: awsConfig,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+	for name, ref := range s.refs {
+		value, err := s.fetcher.Fetch(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		s.values[name] = value
+	}
+	return s, nil
+}
+
+// get returns the fetched value of the setting, or its environment
+// variable when it has no reference.
+func (s *secretStore) get(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.values[name]; ok {
+		return v
+	}
+	return os.Getenv(name)
+}
+
+// refreshSecrets fetches the referenced settings again and puts the ones
+// that changed into use. Access tokens signed with a rotated JWT secret
+// keep working for JWT_ROTATION_GRACE, see validateJWT. The CloudFront
+// key and key pair ID are fetched before either is used, then swapped as
+// one. DB_PATH is only read at startup.
+func (cfg *apiConfig) refreshSecrets(ctx context.Context) error {
+	s := cfg.secrets
+	fetched := make(map[string]string, len(s.refs))
+	for name, ref := range s.refs {
+		value, err := s.fetcher.Fetch(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		fetched[name] = value
+	}
+
+	s.mu.Lock()
+	changed := map[string]bool{}
+	for name, value := range fetched {
+		changed[name] = s.values[name] != value
+		s.values[name] = value
+	}
+	s.mu.Unlock()
+
+	for name, ok := range changed {
+		if !ok {
+			continue
+		}
+		switch name {
+		case "JWT_SECRET":
+			cfg.jwtSecret.Rotate(fetched[name], envDuration("JWT_ROTATION_GRACE", time.Hour))
+			log.Printf("Rotated %s", name)
+		case "CLOUDFRONT_PRIVATE_KEY", "CLOUDFRONT_KEY_PAIR_ID":
+			// Swapped together below.
+		default:
+			log.Printf("%s changed; restart the server to use the new value", name)
+		}
+	}
+
+	if (changed["CLOUDFRONT_PRIVATE_KEY"] || changed["CLOUDFRONT_KEY_PAIR_ID"]) && cfg.urlSigner != nil {
+		keyPairID := s.get("CLOUDFRONT_KEY_PAIR_ID")
+		key, err := cfsign.ParsePrivateKey([]byte(s.get("CLOUDFRONT_PRIVATE_KEY")))
+		if err != nil {
+			return fmt.Errorf("CLOUDFRONT_PRIVATE_KEY: %w", err)
+		}
+		cfg.urlSigner.SetKey(keyPairID, key)
+		log.Printf("Rotated the CloudFront key pair, now %s", keyPairID)
+	}
+	return nil
+}
+
+// validateJWT is auth.ValidateJWT against the JWT secret, or for a while
+// after it's rotated the one it replaced, so tokens issued just before a
+// rotation aren't all rejected at once.
+func (cfg *apiConfig) validateJWT(token string) (uuid.UUID, error) {
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret.Get())
+	if err == nil {
+		return userID, nil
+	}
+	if previous := cfg.jwtSecret.Previous(); previous != "" {
+		if userID, prevErr := auth.ValidateJWT(token, previous); prevErr == nil {
+			return userID, nil
+		}
+	}
+	return uuid.Nil, err
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/sitemap"
+)
+
+const sitemapTask = "sitemap"
+
+// sitemapCache holds the last generated sitemap, so crawlers are served
+// from memory instead of a query over every public video.
+type sitemapCache struct {
+	mu          sync.RWMutex
+	body        []byte
+	generatedAt time.Time
+}
+
+func (c *sitemapCache) get() ([]byte, time.Time) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.body, c.generatedAt
+}
+
+func (c *sitemapCache) set(body []byte, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.body = body
+	c.generatedAt = at
+}
+
+// sitemapPageURL is the page a sitemap entry points at for the video.
+// SITEMAP_PAGE_URL can point it at a frontend, with {id} standing for the
+// video ID; by default it's the public video API.
+func (cfg *apiConfig) sitemapPageURL(videoID string) string {
+	if cfg.sitemapPageTemplate != "" {
+		return strings.ReplaceAll(cfg.sitemapPageTemplate, "{id}", videoID)
+	}
+	return cfg.getBaseURL() + "/api/videos/" + videoID
+}
+
+// generateSitemap rebuilds the sitemap of public videos. It runs on a
+// schedule and whenever a video is published or leaves public view.
+func (cfg *apiConfig) generateSitemap(ctx context.Context) error {
+	videos, err := cfg.db.GetSitemapVideos(sitemap.MaxURLs)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	urls := make([]sitemap.URL, 0, len(videos))
+	for _, video := range videos {
+		page := cfg.sitemapPageURL(video.ID.String())
+		u := sitemap.URL{Loc: page, LastMod: video.UpdatedAt}
+		if video.ThumbnailURL != nil {
+			u.Video = &sitemap.Video{
+				ThumbnailLoc:    *video.ThumbnailURL,
+				Title:           video.Title,
+				Description:     video.Description,
+				PublicationDate: video.CreatedAt,
+			}
+			if video.VideoKey != nil && video.Playable(now) {
+				u.Video.ContentLoc = cfg.store.URL(*video.VideoKey)
+			}
+		}
+		urls = append(urls, u)
+	}
+
+	var buf bytes.Buffer
+	if err := sitemap.Encode(&buf, urls); err != nil {
+		return err
+	}
+	cfg.sitemap.set(buf.Bytes(), now)
+	if len(videos) == sitemap.MaxURLs {
+		log.Printf("sitemap: only the %d most recently updated videos fit", sitemap.MaxURLs)
+	}
+	return nil
+}
+
+// refreshSitemap queues a rebuild after public videos changed.
+func (cfg *apiConfig) refreshSitemap() {
+	if err := cfg.scheduler.RunNow(sitemapTask); err != nil {
+		// The task is disabled, so the next request rebuilds it instead.
+		cfg.sitemap.set(nil, time.Time{})
+	}
+}
+
+func (cfg *apiConfig) handlerSitemap(w http.ResponseWriter, r *http.Request) {
+	body, generatedAt := cfg.sitemap.get()
+	if body == nil {
+		// Not built yet.
+		if err := cfg.generateSitemap(r.Context()); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate sitemap", err)
+			return
+		}
+		body, generatedAt = cfg.sitemap.get()
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	http.ServeContent(w, r, "sitemap.xml", generatedAt, bytes.NewReader(body))
+}
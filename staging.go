@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// Scratch modes, from SCRATCH_MODE. In the local mode, the default, a raw
+// upload waits for a worker in a temp file. In the staging mode it waits
+// in the store under stagingPrefix instead, for deployments whose
+// instances have little disk: the worker that processes it downloads it
+// once its turn comes, so only the uploads being processed take local
+// space, not every one in flight.
+const (
+	scratchModeLocal   = "local"
+	scratchModeStaging = "staging"
+)
+
+// stagingPrefix holds raw uploads waiting to be processed. Objects under
+// it are deleted once processed; ones left by an instance that died are
+// for a lifecycle rule on the prefix to expire.
+const stagingPrefix = "staging/"
+
+// stagingPartSize is the part size raw uploads are staged in. A part is
+// held in memory while it's sent, and S3 wants at least 5 MiB for every
+// part but the last.
+const stagingPartSize = 8 << 20
+
+// stageUpload streams body into the store under the staging prefix, one
+// part at a time, and returns the key it's stored at and its size.
+func (cfg *apiConfig) stageUpload(ctx context.Context, videoID uuid.UUID, body io.Reader, contentType string) (string, int64, error) {
+	key := stagingPrefix + videoID.String() + "/" + uuid.NewString()
+	uploadID, err := cfg.store.CreateMultipart(ctx, key, storage.PutOptions{ContentType: contentType})
+	if err != nil {
+		cfg.reportStorageError("put", key, err)
+		return "", 0, fmt.Errorf("couldn't start staging upload: %w", err)
+	}
+	abort := func() {
+		if err := cfg.store.AbortMultipart(context.WithoutCancel(ctx), key, uploadID); err != nil {
+			log.Printf("Couldn't abort staging upload %s: %v", key, err)
+		}
+	}
+
+	buf := make([]byte, stagingPartSize)
+	var parts []storage.CompletedPart
+	var size int64
+	for partNumber := int32(1); ; partNumber++ {
+		n, err := io.ReadFull(body, buf)
+		if err == io.EOF && partNumber > 1 {
+			break
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			abort()
+			return "", 0, err
+		}
+		sum := md5.Sum(buf[:n])
+		part, err := cfg.store.UploadPart(ctx, key, uploadID, partNumber, bytes.NewReader(buf[:n]), int64(n), base64.StdEncoding.EncodeToString(sum[:]))
+		if err != nil {
+			abort()
+			cfg.reportStorageError("put", key, err)
+			return "", 0, fmt.Errorf("couldn't stage upload: %w", err)
+		}
+		parts = append(parts, part)
+		size += int64(n)
+		if n < len(buf) {
+			break
+		}
+	}
+
+	if _, err := cfg.store.CompleteMultipart(ctx, key, uploadID, parts); err != nil {
+		abort()
+		cfg.reportStorageError("put", key, err)
+		return "", 0, fmt.Errorf("couldn't finish staging upload: %w", err)
+	}
+	return key, size, nil
+}
+
+// fetchSource downloads the object a job processes to a temp file, which
+// the caller removes.
+func (cfg *apiConfig) fetchSource(ctx context.Context, key string) (string, error) {
+	body, err := cfg.store.Get(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("couldn't download video: %w", err)
+	}
+	defer body.Close()
+
+	tempFile, err := os.CreateTemp("", tempUploadPattern)
+	if err != nil {
+		return "", err
+	}
+	defer tempFile.Close()
+	if _, err := io.Copy(tempFile, body); err != nil {
+		os.Remove(tempFile.Name())
+		return "", fmt.Errorf("couldn't download video: %w", err)
+	}
+	return tempFile.Name(), nil
+}
+
+// discardStagedUpload deletes a staged upload once it's been processed,
+// or turned away.
+func (cfg *apiConfig) discardStagedUpload(ctx context.Context, key string) {
+	if err := cfg.store.Delete(context.WithoutCancel(ctx), key); err != nil {
+		cfg.reportStorageError("delete", key, err)
+	}
+}
+
+// formFilePart returns the named file of a multipart form as the body
+// reads it, without spooling it to disk the way r.FormFile does with big
+// files. Parts after it are left unread.
+func formFilePart(r *http.Request, name string) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, http.ErrMissingFile
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == name && part.FileName() != "" {
+			return part, nil
+		}
+	}
+}
+
+// stageVideoUpload is storeVideoUpload for the staging mode, reading the
+// video straight from the form part. Its size is only known once it's
+// staged, so the size limits are checked then, and the staged copy is
+// deleted if it's over them.
+func (cfg *apiConfig) stageVideoUpload(ctx context.Context, plan *database.Plan, video database.Video, uploaderID uuid.UUID, part *multipart.Part) (database.Video, *apiError) {
+	if err := cfg.checkVideoQuota(plan, video, video.VideoSize, 0); err != nil {
+		return video, quotaError(err)
+	}
+
+	body := bufio.NewReaderSize(part, 512)
+	head, err := body.Peek(512)
+	if err != nil && err != io.EOF {
+		return video, &apiError{Status: http.StatusBadRequest, Code: errCodeInvalidBody, Message: "Couldn't read uploaded file", Err: err}
+	}
+	header := &multipart.FileHeader{Filename: part.FileName(), Header: part.Header}
+	rule, apiErr := cfg.checkMediaUpload(mediaKindVideo, bytes.NewReader(head), header)
+	if apiErr != nil {
+		return video, apiErr
+	}
+
+	upload := uploadEvent{VideoID: video.ID, UserID: video.UserID, Method: uploadMethodForm}
+	cfg.events.Publish(eventUploadStarted, upload)
+	job := cfg.newProcessingJob(video)
+	job.uploaderID = uploaderID
+	job.filename = header.Filename
+	return cfg.storeStagedVideo(ctx, job, video, upload, body, header.Header.Get("Content-Type"), func(size int64) *apiError {
+		if rule.MaxSize > 0 && size > rule.MaxSize {
+			return &apiError{Status: http.StatusRequestEntityTooLarge, Code: errCodePayloadTooLarge, Message: fmt.Sprintf("Files of type %s are limited to %d bytes", rule.MediaType, rule.MaxSize)}
+		}
+		if err := cfg.checkVideoQuota(plan, video, video.VideoSize, size); err != nil {
+			return quotaError(err)
+		}
+		return nil
+	})
+}
+
+// storeStagedVideo stages body and runs it through the processing
+// pipeline. check, if set, is given the upload's size once it's staged,
+// and the staged copy is deleted if it returns an error.
+func (cfg *apiConfig) storeStagedVideo(ctx context.Context, job *processingJob, video database.Video, upload uploadEvent, body io.Reader, contentType string, check func(size int64) *apiError) (database.Video, *apiError) {
+	doneCopy := job.stage(stageCopy)
+	key, size, err := cfg.stageUpload(ctx, video.ID, body, contentType)
+	doneCopy()
+	job.size = size
+	if err != nil {
+		job.finish(err)
+		return video, &apiError{Status: http.StatusInternalServerError, Code: errCodeInternal, Message: "Couldn't stage video", Err: err}
+	}
+	if check != nil {
+		if apiErr := check(size); apiErr != nil {
+			cfg.discardStagedUpload(ctx, key)
+			job.finish(errors.New(apiErr.Message))
+			return video, apiErr
+		}
+	}
+
+	job.sourceKey = key
+	job.staged = true
+	video, err = cfg.storeVideo(ctx, job, video, "")
+	job.finish(err)
+	return cfg.uploadProcessed(video, upload, err)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cfsign"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+const (
+	storageBackendS3         = "s3"
+	storageBackendFilesystem = "filesystem"
+	storageBackendMemory     = "memory"
+)
+
+// objectsPath is where the server itself serves objects for the backends
+// that have no CDN in front of them.
+const objectsPath = "/objects"
+
+func (cfg *apiConfig) newStore(backend, root string) (storage.Store, error) {
+	switch backend {
+	case storageBackendS3:
+		signer, err := cfg.newURLSigner()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't set up CloudFront URL signing: %w", err)
+		}
+		cfg.urlSigner = signer
+		store, err := newS3Store(cfg.s3Bucket, cfg.s3Region, cfg.s3CfDistribution, signer)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case storageBackendFilesystem:
+		if root == "" {
+			root = "./objects"
+		}
+		return storage.NewFilesystem(root, cfg.getBaseURL()+objectsPath)
+	case storageBackendMemory:
+		return storage.NewMemory(cfg.getBaseURL() + objectsPath), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+func newS3Store(bucket, region, cdnDomain string, signer *cfsign.Signer) (*storage.S3Store, error) {
+	s3Config, err := loadAWSConfig(context.Background(), region)
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewS3(s3.NewFromConfig(s3Config), storage.S3Options{
+		Bucket:         bucket,
+		CDNDomain:      cdnDomain,
+		URLSigner:      signer,
+		PutTimeout:     envDuration("S3_PUT_TIMEOUT", 30*time.Minute),
+		RequestTimeout: envDuration("S3_REQUEST_TIMEOUT", 30*time.Second),
+
+		ArchiveStorageClass: os.Getenv("S3_ARCHIVE_STORAGE_CLASS"),
+	}), nil
+}
+
+// newURLSigner returns nil when no CloudFront key pair is configured, in
+// which case playback falls back to plain CDN URLs. The private key comes
+// from CLOUDFRONT_PRIVATE_KEY when it's fetched from a secret store, and
+// from CLOUDFRONT_PRIVATE_KEY_FILE otherwise.
+func (cfg *apiConfig) newURLSigner() (*cfsign.Signer, error) {
+	keyPairID := cfg.secrets.get("CLOUDFRONT_KEY_PAIR_ID")
+	if keyPairID == "" {
+		return nil, nil
+	}
+	var (
+		key *rsa.PrivateKey
+		err error
+	)
+	if pem := cfg.secrets.get("CLOUDFRONT_PRIVATE_KEY"); pem != "" {
+		key, err = cfsign.ParsePrivateKey([]byte(pem))
+	} else {
+		key, err = cfsign.LoadPrivateKey(os.Getenv("CLOUDFRONT_PRIVATE_KEY_FILE"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cfsign.New(keyPairID, key), nil
+}
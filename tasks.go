@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const tempUploadPattern = "tubely-upload"
+
+// registerTasks registers the background tasks. The ones working on what
+// an instance keeps to itself run on every instance; the rest only run on
+// the leader.
+func (cfg *apiConfig) registerTasks() {
+	cfg.scheduler.RegisterLocal("temp_sweep", envDuration("TEMP_SWEEP_INTERVAL", time.Hour), cfg.sweepTempFiles)
+	cfg.scheduler.RegisterLocal("orphan_assets", envDuration("ORPHAN_SWEEP_INTERVAL", 6*time.Hour), cfg.reconcileOrphanAssets)
+	cfg.scheduler.Register(integrityAuditTask, envDuration("INTEGRITY_AUDIT_INTERVAL", 24*time.Hour), cfg.auditIntegrity)
+	cfg.scheduler.Register("scheduled_publish", envDuration("SCHEDULED_PUBLISH_INTERVAL", time.Minute), cfg.publishScheduledVideos)
+	cfg.scheduler.Register("expired_purge", envDuration("EXPIRED_PURGE_INTERVAL", 5*time.Minute), cfg.purgeExpiredVideos)
+	cfg.scheduler.Register("cold_tiering", envDuration("COLD_TIER_INTERVAL", 24*time.Hour), cfg.archiveColdVideos)
+	cfg.scheduler.Register("restore_poll", envDuration("RESTORE_POLL_INTERVAL", 15*time.Minute), cfg.pollRestores)
+	cfg.scheduler.Register("webhook_retry", envDuration("WEBHOOK_RETRY_INTERVAL", time.Minute), cfg.retryWebhooks)
+	cfg.scheduler.RegisterLocal(sitemapTask, envDuration("SITEMAP_INTERVAL", time.Hour), cfg.generateSitemap)
+	cfg.scheduler.Register("multipart_reaper", envDuration("MULTIPART_REAP_INTERVAL", 6*time.Hour), cfg.reapMultipartUploads)
+	cfg.scheduler.Register("upload_token_purge", envDuration("UPLOAD_TOKEN_PURGE_INTERVAL", time.Hour), cfg.purgeUploadTokens)
+	cfg.scheduler.Register("export_purge", envDuration("EXPORT_PURGE_INTERVAL", time.Hour), cfg.purgeExports)
+	cfg.scheduler.Register("processing_outcome_purge", envDuration("PROCESSING_OUTCOME_PURGE_INTERVAL", 24*time.Hour), cfg.purgeProcessingOutcomes)
+	if len(cfg.secrets.refs) > 0 {
+		cfg.scheduler.RegisterLocal("secrets_refresh", envDuration("SECRETS_REFRESH_INTERVAL", 0), cfg.refreshSecrets)
+	}
+}
+
+// sweepTempFiles removes upload temp files (and their .processing
+// counterparts) left behind by requests that died before cleanup ran.
+func (cfg *apiConfig) sweepTempFiles(ctx context.Context) error {
+	entries, err := os.ReadDir(os.TempDir())
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), tempUploadPattern) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < cfg.tempMaxAge {
+			continue
+		}
+		if err := os.Remove(filepath.Join(os.TempDir(), entry.Name())); err != nil {
+			log.Printf("temp_sweep: couldn't remove %s: %v", entry.Name(), err)
+			continue
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		log.Printf("temp_sweep: removed %d stale temp files", removed)
+	}
+	return nil
+}
+
+// reconcileOrphanAssets deletes files in the assets directory that no video
+// or profile references anymore. Recently written files are skipped so an
+// upload that hasn't saved its row yet isn't raced.
+func (cfg *apiConfig) reconcileOrphanAssets(ctx context.Context) error {
+	urls, err := cfg.db.GetThumbnailURLs()
+	if err != nil {
+		return fmt.Errorf("couldn't list thumbnails: %w", err)
+	}
+	candidates, err := cfg.db.GetThumbnailCandidateFiles()
+	if err != nil {
+		return fmt.Errorf("couldn't list thumbnail candidates: %w", err)
+	}
+	renditions, err := cfg.db.GetThumbnailRenditionFiles()
+	if err != nil {
+		return fmt.Errorf("couldn't list thumbnail renditions: %w", err)
+	}
+	userImages, err := cfg.db.GetUserImageURLs()
+	if err != nil {
+		return fmt.Errorf("couldn't list avatars and banners: %w", err)
+	}
+	urls = append(urls, userImages...)
+	referenced := make(map[string]bool, len(urls)+len(candidates)+len(renditions))
+	for _, url := range urls {
+		referenced[path.Base(url)] = true
+	}
+	for _, file := range append(candidates, renditions...) {
+		referenced[file] = true
+	}
+
+	entries, err := os.ReadDir(cfg.assetsRoot)
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if entry.IsDir() || referenced[entry.Name()] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < time.Hour {
+			continue
+		}
+		if err := os.Remove(cfg.getAssetDiskPath(entry.Name())); err != nil {
+			log.Printf("orphan_assets: couldn't remove %s: %v", entry.Name(), err)
+			continue
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		log.Printf("orphan_assets: removed %d unreferenced assets", removed)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"cmp"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/throttle"
+)
+
+// throttleLimits are byte rates per second; zero turns a limit off. The
+// per-user rate is shared by all of a requester's connections.
+type throttleLimits struct {
+	perConnection int64
+	perUser       int64
+	users         *throttle.Registry
+}
+
+func loadThrottleLimits(prefix string) throttleLimits {
+	return throttleLimits{
+		perConnection: int64(envInt(prefix+"_RATE_PER_CONNECTION", 0)),
+		perUser:       int64(envInt(prefix+"_RATE_PER_USER", 0)),
+		users:         throttle.NewRegistry(10 * time.Minute),
+	}
+}
+
+// buckets returns the buckets a request with the given throttle key draws
+// from, with perUser replacing the configured per-user rate when set.
+func (l throttleLimits) buckets(key string, perUser int64) []*throttle.Bucket {
+	buckets := []*throttle.Bucket{}
+	if l.perConnection > 0 {
+		buckets = append(buckets, throttle.NewBucket(l.perConnection))
+	}
+	if b := l.users.Bucket(key, cmp.Or(perUser, l.perUser)); b != nil {
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	body io.Writer
+}
+
+func (w *throttledResponseWriter) Write(p []byte) (int, error) {
+	return w.body.Write(p)
+}
+
+// throttleDownloads slows down the responses of the asset and object
+// endpoints so a few downloaders can't use up the instance's bandwidth.
+func (cfg *apiConfig) throttleDownloads(next http.Handler) http.Handler {
+	limits := cfg.downloadLimits
+	if limits.perConnection <= 0 && limits.perUser <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buckets := limits.buckets(cfg.throttleKey(r), 0)
+		next.ServeHTTP(&throttledResponseWriter{
+			ResponseWriter: w,
+			body:           throttle.NewWriter(r.Context(), w, buckets...),
+		}, r)
+	})
+}
+
+// throttleKey identifies the requester: the user when the request carries
+// a valid token, which media elements can only pass as a query param, and
+// the client IP otherwise.
+func (cfg *apiConfig) throttleKey(r *http.Request) string {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token, _ = auth.GetBearerToken(r.Header)
+	}
+	if token != "" {
+		if userID, err := cfg.validateJWT(token); err == nil {
+			return "user:" + userID.String()
+		}
+	}
+	return "ip:" + cfg.clientIP(r)
+}
+
+type throttledBody struct {
+	io.Reader
+	io.Closer
+}
+
+// throttleUpload slows down reading the request body to the upload rate
+// of the plan paying for video, and to UPLOAD_RATE_PER_CONNECTION.
+func (cfg *apiConfig) throttleUpload(r *http.Request, video database.Video, plan *database.Plan) {
+	buckets := cfg.uploadBuckets(video, plan)
+	if len(buckets) == 0 {
+		return
+	}
+	r.Body = throttledBody{
+		Reader: throttle.NewReader(r.Context(), r.Body, buckets...),
+		Closer: r.Body,
+	}
+}
+
+// uploadBuckets returns the buckets an upload to video draws from. The
+// plan's rate is shared by every upload billed to it, whoever sends them,
+// so collaborators can't multiply the owner's rate.
+func (cfg *apiConfig) uploadBuckets(video database.Video, plan *database.Plan) []*throttle.Bucket {
+	key := "user:" + video.UserID.String()
+	if video.OrgID != nil {
+		key = "org:" + video.OrgID.String()
+	}
+	return cfg.uploadLimits.buckets(key, plan.MaxUploadRate)
+}
+
+// rateLimit answers 429 to requesters, told apart by throttleKey, making
+// more than perSecond requests a second to next, allowing short bursts of
+// up to perSecond. It's meant for endpoints anyone can call without
+// signing in. Zero turns the limit off.
+func (cfg *apiConfig) rateLimit(perSecond int, next http.HandlerFunc) http.HandlerFunc {
+	if perSecond <= 0 {
+		return next
+	}
+	clients := throttle.NewRegistry(10 * time.Minute)
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := clients.Bucket(cfg.throttleKey(r), int64(perSecond)).Allow(1)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			respondWithErrorCode(w, http.StatusTooManyRequests, errCodeRateLimited, "Too many requests", nil)
+			return
+		}
+		next(w, r)
+	}
+}
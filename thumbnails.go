@@ -0,0 +1,629 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+type thumbnailSize struct {
+	width  int
+	height int
+}
+
+// loadThumbnailSizes reads THUMBNAIL_RENDITIONS, a comma separated list
+// of "widthxheight" sizes every thumbnail is cut to. Setting it empty
+// turns renditions off.
+func loadThumbnailSizes() ([]thumbnailSize, error) {
+	value, ok := os.LookupEnv("THUMBNAIL_RENDITIONS")
+	if !ok {
+		value = "1280x720,640x360,320x180"
+	}
+	sizes := []thumbnailSize{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		w, h, ok := strings.Cut(entry, "x")
+		width, errW := strconv.Atoi(w)
+		height, errH := strconv.Atoi(h)
+		if !ok || errW != nil || errH != nil || width <= 0 || height <= 0 || width > 4096 || height > 4096 {
+			return nil, fmt.Errorf("invalid thumbnail size %q", entry)
+		}
+		sizes = append(sizes, thumbnailSize{width: width, height: height})
+	}
+	return sizes, nil
+}
+
+// thumbnailFraming reads the crop or focal_point query parameter that
+// goes with a new thumbnail, as "x,y,width,height" or "x,y" fractions of
+// the image. Without either the renditions are centred.
+func thumbnailFraming(r *http.Request) (media.Framing, []errorDetail) {
+	var framing media.Framing
+	var details []errorDetail
+	query := r.URL.Query()
+	if s := query.Get("crop"); s != "" {
+		crop, err := media.ParseRect(s)
+		if err != nil {
+			details = append(details, errorDetail{Field: "crop", Message: "must be x,y,width,height as fractions of the image"})
+		} else {
+			framing.Crop = &crop
+		}
+	}
+	if s := query.Get("focal_point"); s != "" {
+		point, err := media.ParsePoint(s)
+		if err != nil {
+			details = append(details, errorDetail{Field: "focal_point", Message: "must be x,y as fractions of the image"})
+		} else {
+			framing.FocalPoint = &point
+		}
+	}
+	if len(details) == 0 {
+		if err := framing.Validate(); err != nil {
+			details = append(details, errorDetail{Message: err.Error()})
+		}
+	}
+	return framing, details
+}
+
+func respondInvalidFraming(w http.ResponseWriter, details []errorDetail) {
+	respondWithAPIError(w, apiError{
+		Status:  http.StatusBadRequest,
+		Code:    errCodeValidation,
+		Message: "Invalid thumbnail framing",
+		Details: details,
+	})
+}
+
+// renderThumbnailRenditions cuts the image at source to every configured
+// size. The files are written to the assets directory.
+func (cfg *apiConfig) renderThumbnailRenditions(ctx context.Context, source string, framing media.Framing) ([]database.ThumbnailRendition, error) {
+	if len(cfg.thumbnailSizes) == 0 {
+		return nil, nil
+	}
+	release, err := cfg.thumbnailWorkers.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	metadata, err := cfg.media.Probe(ctx, source)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't probe thumbnail: %w", err)
+	}
+	if len(metadata.Streams) == 0 || metadata.Streams[0].Width == 0 || metadata.Streams[0].Height == 0 {
+		return nil, errors.New("couldn't determine thumbnail dimensions")
+	}
+	width, height := metadata.Streams[0].Width, metadata.Streams[0].Height
+
+	renditions := make([]database.ThumbnailRendition, 0, len(cfg.thumbnailSizes))
+	for _, size := range cfg.thumbnailSizes {
+		region := framing.Region(width, height, float64(size.width)/float64(size.height))
+		fileName := newAssetName() + ".jpg"
+		err := cfg.media.Crop(ctx, source, region, size.width, size.height, cfg.getAssetDiskPath(fileName))
+		if err == nil {
+			var info os.FileInfo
+			info, err = os.Stat(cfg.getAssetDiskPath(fileName))
+			if err == nil {
+				renditions = append(renditions, database.ThumbnailRendition{
+					Width:    size.width,
+					Height:   size.height,
+					FileName: fileName,
+					Size:     info.Size(),
+				})
+				continue
+			}
+		}
+		cfg.removeThumbnailRenditions(renditions)
+		return nil, fmt.Errorf("couldn't render %dx%d thumbnail: %w", size.width, size.height, err)
+	}
+	return renditions, nil
+}
+
+// saveThumbnailRenditions records freshly rendered renditions in place of
+// the video's current ones, moving the owner's usage by the difference.
+func (cfg *apiConfig) saveThumbnailRenditions(video database.Video, framing media.Framing, renditions []database.ThumbnailRendition) error {
+	framingJSON, err := json.Marshal(framing)
+	if err != nil {
+		return err
+	}
+	replaced, err := cfg.db.SetThumbnailRenditions(video.ID, framingJSON, renditions)
+	if err != nil {
+		cfg.removeThumbnailRenditions(renditions)
+		return fmt.Errorf("couldn't save thumbnail renditions: %w", err)
+	}
+
+	bytesDelta := renditionsSize(renditions) - renditionsSize(replaced)
+	objectsDelta := int64(len(renditions) - len(replaced))
+	if err := cfg.adjustVideoUsage(video, bytesDelta, objectsDelta); err != nil {
+		return fmt.Errorf("couldn't update storage usage: %w", err)
+	}
+	cfg.removeThumbnailRenditions(replaced)
+	return nil
+}
+
+func renditionsSize(renditions []database.ThumbnailRendition) int64 {
+	var size int64
+	for _, r := range renditions {
+		size += r.Size
+	}
+	return size
+}
+
+func (cfg *apiConfig) removeThumbnailRenditions(renditions []database.ThumbnailRendition) {
+	for _, r := range renditions {
+		err := os.Remove(cfg.getAssetDiskPath(r.FileName))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Couldn't delete thumbnail rendition %s: %v", r.FileName, err)
+		}
+	}
+}
+
+// stagedThumbnail is a thumbnail asset with its renditions cut, not yet
+// attached to a video.
+type stagedThumbnail struct {
+	url        string
+	size       int64
+	framing    media.Framing
+	renditions []database.ThumbnailRendition
+}
+
+// stageThumbnail cuts the renditions of the asset at thumbnailURL with the
+// given framing.
+func (cfg *apiConfig) stageThumbnail(ctx context.Context, thumbnailURL string, size int64, framing media.Framing) (stagedThumbnail, error) {
+	renditions, err := cfg.renderThumbnailRenditions(ctx, cfg.getAssetDiskPath(path.Base(thumbnailURL)), framing)
+	if err != nil {
+		return stagedThumbnail{}, err
+	}
+	return stagedThumbnail{url: thumbnailURL, size: size, framing: framing, renditions: renditions}, nil
+}
+
+// discardThumbnail removes a staged thumbnail that won't be attached after
+// all, asset and renditions.
+func (cfg *apiConfig) discardThumbnail(staged stagedThumbnail) {
+	cfg.removeThumbnailRenditions(staged.renditions)
+	err := os.Remove(cfg.getAssetDiskPath(path.Base(staged.url)))
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Couldn't delete staged thumbnail %s: %v", staged.url, err)
+	}
+}
+
+// attachThumbnail points the video at the asset fileName, cuts its
+// renditions with the given framing, moves the owner's usage by the size
+// difference and removes the asset it replaces.
+func (cfg *apiConfig) attachThumbnail(ctx context.Context, video database.Video, thumbnailURL string, size int64, framing media.Framing) (database.Video, error) {
+	staged, err := cfg.stageThumbnail(ctx, thumbnailURL, size, framing)
+	if err != nil {
+		return video, err
+	}
+	return cfg.attachStagedThumbnail(video, staged)
+}
+
+// attachStagedThumbnail is attachThumbnail for a thumbnail whose
+// renditions are already cut.
+func (cfg *apiConfig) attachStagedThumbnail(video database.Video, staged stagedThumbnail) (database.Video, error) {
+	fileName := path.Base(staged.url)
+	thumbnailURL, size, framing, renditions := staged.url, staged.size, staged.framing, staged.renditions
+
+	previousURL := video.ThumbnailURL
+
+	var objectsDelta int64 = 1
+	if previousURL != nil {
+		objectsDelta = 0
+	}
+	bytesDelta := size - video.ThumbnailSize
+
+	video.ThumbnailURL = &thumbnailURL
+	video.ThumbnailSize = size
+
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		cfg.removeThumbnailRenditions(renditions)
+		return video, fmt.Errorf("couldn't update video: %w", err)
+	}
+	if err := cfg.adjustVideoUsage(video, bytesDelta, objectsDelta); err != nil {
+		cfg.removeThumbnailRenditions(renditions)
+		return video, fmt.Errorf("couldn't update storage usage: %w", err)
+	}
+	if err := cfg.saveThumbnailRenditions(video, framing, renditions); err != nil {
+		return video, err
+	}
+
+	if previousURL != nil && path.Base(*previousURL) != fileName {
+		err := os.Remove(cfg.getAssetDiskPath(path.Base(*previousURL)))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Couldn't delete replaced thumbnail of video %s: %v", video.ID, err)
+		}
+	}
+	return video, nil
+}
+
+// storeThumbnailCandidates grabs frames spread over the processed video
+// at path for the owner to choose a thumbnail from. When the video was
+// created with auto_thumbnail and has no thumbnail yet, the middle frame
+// becomes the thumbnail. Candidates are a convenience, so failures are
+// only logged.
+func (cfg *apiConfig) storeThumbnailCandidates(ctx context.Context, video database.Video, path string) database.Video {
+	n := envInt("THUMBNAIL_CANDIDATES", 5)
+	if n <= 0 {
+		return video
+	}
+	release, err := cfg.thumbnailWorkers.acquire(ctx)
+	if err != nil {
+		return video
+	}
+	frames, err := media.SampleFrames(ctx, cfg.media, path, n, envInt("THUMBNAIL_CANDIDATE_WIDTH", 1280))
+	release()
+	if err != nil {
+		log.Printf("Couldn't grab thumbnail candidates of video %s: %v", video.ID, err)
+		return video
+	}
+
+	candidates := make([]database.ThumbnailCandidate, 0, len(frames))
+	for i, frame := range frames {
+		fileName := newAssetName() + ".jpg"
+		size, err := moveFile(frame.Path, cfg.getAssetDiskPath(fileName))
+		if err != nil {
+			log.Printf("Couldn't store thumbnail candidate of video %s: %v", video.ID, err)
+			continue
+		}
+		candidates = append(candidates, database.ThumbnailCandidate{
+			Position: i,
+			Seconds:  frame.Seconds,
+			FileName: fileName,
+			Size:     size,
+		})
+	}
+
+	replaced, err := cfg.db.ReplaceThumbnailCandidates(video.ID, candidates)
+	if err != nil {
+		log.Printf("Couldn't save thumbnail candidates of video %s: %v", video.ID, err)
+		cfg.removeThumbnailCandidates(candidates)
+		return video
+	}
+	cfg.removeThumbnailCandidates(replaced)
+
+	if video.AutoThumbnail && video.ThumbnailURL == nil && len(candidates) > 0 {
+		candidate := candidates[len(candidates)/2]
+		updated, err := cfg.promoteThumbnailCandidate(ctx, video, candidate, media.Framing{})
+		if err != nil {
+			log.Printf("Couldn't set automatic thumbnail of video %s: %v", video.ID, err)
+			return video
+		}
+		return updated
+	}
+	return video
+}
+
+func (cfg *apiConfig) removeThumbnailCandidates(candidates []database.ThumbnailCandidate) {
+	for _, candidate := range candidates {
+		err := os.Remove(cfg.getAssetDiskPath(candidate.FileName))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Couldn't delete thumbnail candidate %s: %v", candidate.FileName, err)
+		}
+	}
+}
+
+// promoteThumbnailCandidate makes a copy of the candidate the video's
+// thumbnail, so the candidates can be replaced later without touching it.
+func (cfg *apiConfig) promoteThumbnailCandidate(ctx context.Context, video database.Video, candidate database.ThumbnailCandidate, framing media.Framing) (database.Video, error) {
+	fileName := newAssetName() + path.Ext(candidate.FileName)
+	size, err := copyFile(cfg.getAssetDiskPath(candidate.FileName), cfg.getAssetDiskPath(fileName))
+	if err != nil {
+		return video, fmt.Errorf("couldn't copy thumbnail candidate: %w", err)
+	}
+	video, err = cfg.attachThumbnail(ctx, video, cfg.getAssetURL(fileName), size, framing)
+	if err != nil {
+		os.Remove(cfg.getAssetDiskPath(fileName))
+		return video, err
+	}
+	return video, nil
+}
+
+// moveFile renames src to dst, copying when they're on different
+// filesystems, and returns the size of the file.
+func moveFile(src, dst string) (int64, error) {
+	if err := os.Rename(src, dst); err == nil {
+		info, err := os.Stat(dst)
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+	defer os.Remove(src)
+	return copyFile(src, dst)
+}
+
+type thumbnailCandidateResponse struct {
+	database.ThumbnailCandidate
+	URL string `json:"url"`
+}
+
+func (cfg *apiConfig) handlerThumbnailCandidates(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizedVideo(w, r, permEdit)
+	if !ok {
+		return
+	}
+
+	candidates, err := cfg.db.GetThumbnailCandidates(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail candidates", err)
+		return
+	}
+
+	resp := make([]thumbnailCandidateResponse, 0, len(candidates))
+	for _, candidate := range candidates {
+		resp = append(resp, thumbnailCandidateResponse{
+			ThumbnailCandidate: candidate,
+			URL:                cfg.getAssetURL(candidate.FileName),
+		})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerThumbnailSelect(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		CandidateID uuid.UUID `json:"candidate_id"`
+	}
+
+	video, ok := cfg.authorizedVideo(w, r, permEdit)
+	if !ok {
+		return
+	}
+	framing, details := thumbnailFraming(r)
+	if len(details) > 0 {
+		respondInvalidFraming(w, details)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+
+	candidate, err := cfg.db.GetThumbnailCandidate(params.CandidateID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail candidate", err)
+		return
+	}
+	if candidate.ID == uuid.Nil || candidate.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Thumbnail candidate not found", nil)
+		return
+	}
+
+	plan, err := cfg.videoPlan(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	err = cfg.checkVideoQuota(plan, video, video.ThumbnailSize, candidate.Size)
+	if errors.Is(err, errStorageQuotaExceeded) {
+		respondWithErrorCode(w, http.StatusForbidden, errCodeQuotaExceeded, "Storage quota exceeded", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check storage quota", err)
+		return
+	}
+
+	video, err = cfg.promoteThumbnailCandidate(r.Context(), video, candidate, framing)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't set thumbnail", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// videoSource returns something ffmpeg can read the stored video from.
+// Stores that presign downloads are read in place with range requests,
+// pinned to versionID when the bucket keeps versions so a replacement
+// landing mid-read can't mix two files; others have the object copied to
+// a temp file first.
+func (cfg *apiConfig) videoSource(ctx context.Context, key, versionID string) (string, func(), error) {
+	url, err := cfg.store.PresignGet(ctx, key, storage.PresignGetOptions{Expires: 15 * time.Minute, VersionID: versionID})
+	if err == nil {
+		return url, func() {}, nil
+	}
+	if !errors.Is(err, storage.ErrPresignNotSupported) {
+		return "", nil, err
+	}
+	path, err := cfg.downloadObject(ctx, key)
+	if err != nil {
+		return "", nil, err
+	}
+	return path, func() { os.Remove(path) }, nil
+}
+
+// handlerThumbnailFromFrame sets the thumbnail to the frame of the stored
+// video at t seconds.
+func (cfg *apiConfig) handlerThumbnailFromFrame(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizedVideo(w, r, permEdit)
+	if !ok {
+		return
+	}
+	seconds, err := strconv.ParseFloat(r.URL.Query().Get("t"), 64)
+	if err != nil || seconds < 0 || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid frame",
+			Details: []errorDetail{{Field: "t", Message: "must be a number of seconds, zero or more"}},
+		})
+		return
+	}
+	framing, details := thumbnailFraming(r)
+	if len(details) > 0 {
+		respondInvalidFraming(w, details)
+		return
+	}
+	if video.VideoKey == nil {
+		respondWithErrorCode(w, http.StatusConflict, errCodeConflict, "Video has no uploaded file yet", nil)
+		return
+	}
+	if !video.Playable(time.Now()) {
+		respondVideoArchived(w, video)
+		return
+	}
+
+	source, cleanup, err := cfg.videoSource(r.Context(), *video.VideoKey, video.VideoVersionID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video", err)
+		return
+	}
+	defer cleanup()
+
+	metadata, err := cfg.media.Probe(r.Context(), source)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't probe video", err)
+		return
+	}
+	if duration := metadata.Duration(); duration > 0 && seconds >= duration {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid frame",
+			Details: []errorDetail{{Field: "t", Message: fmt.Sprintf("must be less than the video's duration of %.3f seconds", duration)}},
+		})
+		return
+	}
+
+	tempFile, err := os.CreateTemp("", tempUploadPattern+"-*.jpg")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create temporary file", err)
+		return
+	}
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	release, err := cfg.thumbnailWorkers.acquire(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusServiceUnavailable, "Couldn't grab frame", err)
+		return
+	}
+	err = cfg.media.Frame(r.Context(), source, seconds, envInt("THUMBNAIL_CANDIDATE_WIDTH", 1280), tempFile.Name())
+	release()
+	if errors.Is(err, media.ErrNoFrame) {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid frame",
+			Details: []errorDetail{{Field: "t", Message: "there's no frame at this time"}},
+		})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't grab frame", err)
+		return
+	}
+	info, err := os.Stat(tempFile.Name())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read frame", err)
+		return
+	}
+
+	plan, err := cfg.videoPlan(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+	err = cfg.checkVideoQuota(plan, video, video.ThumbnailSize, info.Size())
+	if errors.Is(err, errStorageQuotaExceeded) {
+		respondWithErrorCode(w, http.StatusForbidden, errCodeQuotaExceeded, "Storage quota exceeded", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check storage quota", err)
+		return
+	}
+
+	fileName := newAssetName() + ".jpg"
+	size, err := moveFile(tempFile.Name(), cfg.getAssetDiskPath(fileName))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store thumbnail", err)
+		return
+	}
+	video, err = cfg.attachThumbnail(r.Context(), video, cfg.getAssetURL(fileName), size, framing)
+	if err != nil {
+		os.Remove(cfg.getAssetDiskPath(fileName))
+		respondWithError(w, http.StatusInternalServerError, "Couldn't set thumbnail", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+type thumbnailRenditionResponse struct {
+	database.ThumbnailRendition
+	URL string `json:"url"`
+}
+
+func (cfg *apiConfig) thumbnailRenditionResponses(renditions []database.ThumbnailRendition) []thumbnailRenditionResponse {
+	resp := make([]thumbnailRenditionResponse, 0, len(renditions))
+	for _, rendition := range renditions {
+		resp = append(resp, thumbnailRenditionResponse{
+			ThumbnailRendition: rendition,
+			URL:                cfg.getAssetURL(rendition.FileName),
+		})
+	}
+	return resp
+}
+
+// handlerThumbnailFraming recuts the renditions of the current thumbnail
+// with a new crop or focal point. The uploaded image itself is kept as
+// is, so the framing can be changed again later.
+func (cfg *apiConfig) handlerThumbnailFraming(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Framing    media.Framing                `json:"framing"`
+		Renditions []thumbnailRenditionResponse `json:"renditions"`
+	}
+
+	video, ok := cfg.authorizedVideo(w, r, permEdit)
+	if !ok {
+		return
+	}
+	if video.ThumbnailURL == nil {
+		respondWithErrorCode(w, http.StatusConflict, errCodeConflict, "Video has no thumbnail yet", nil)
+		return
+	}
+
+	framing := media.Framing{}
+	if err := json.NewDecoder(r.Body).Decode(&framing); err != nil {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeInvalidBody, "Couldn't decode parameters", err)
+		return
+	}
+	if err := framing.Validate(); err != nil {
+		respondInvalidFraming(w, []errorDetail{{Message: err.Error()}})
+		return
+	}
+
+	renditions, err := cfg.renderThumbnailRenditions(r.Context(), cfg.getAssetDiskPath(path.Base(*video.ThumbnailURL)), framing)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't render thumbnail", err)
+		return
+	}
+	if err := cfg.saveThumbnailRenditions(video, framing, renditions); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save thumbnail", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Framing:    framing,
+		Renditions: cfg.thumbnailRenditionResponses(renditions),
+	})
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcribe"
+	"github.com/google/uuid"
+)
+
+// newTranscriber returns nil when TRANSCRIPTION_PROVIDER isn't set, which
+// turns automatic captions off. It needs the store set up, since AWS
+// Transcribe reads the audio from the bucket.
+func (cfg *apiConfig) newTranscriber() (transcribe.Transcriber, error) {
+	switch provider := os.Getenv("TRANSCRIPTION_PROVIDER"); provider {
+	case "":
+		return nil, nil
+	case "whisper":
+		model := os.Getenv("WHISPER_MODEL")
+		if model == "" {
+			return nil, fmt.Errorf("WHISPER_MODEL must be set for whisper transcription")
+		}
+		return transcribe.Whisper{
+			Binary:  os.Getenv("WHISPER_BINARY"),
+			Model:   model,
+			Threads: envInt("WHISPER_THREADS", 0),
+		}, nil
+	case "aws":
+		s3Store, ok := cfg.store.(*storage.S3Store)
+		if !ok {
+			return nil, fmt.Errorf("aws transcription needs the s3 storage backend")
+		}
+		awsConfig, err := loadAWSConfig(context.Background(), cfg.s3Region)
+		if err != nil {
+			return nil, err
+		}
+		return &transcribe.AWSTranscribe{
+			Config:       awsConfig,
+			Client:       &http.Client{Timeout: 30 * time.Second},
+			Stage:        stageTranscriptionAudio(s3Store),
+			PollInterval: envDuration("TRANSCRIPTION_POLL_INTERVAL", 10*time.Second),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown transcription provider %q", provider)
+	}
+}
+
+func stageTranscriptionAudio(store *storage.S3Store) func(ctx context.Context, path string) (string, func(), error) {
+	return func(ctx context.Context, path string) (string, func(), error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", nil, err
+		}
+		defer f.Close()
+
+		key := "transcription/" + uuid.NewString() + ".wav"
+		if _, err := store.Put(ctx, key, f, storage.PutOptions{ContentType: "audio/wav"}); err != nil {
+			return "", nil, err
+		}
+		cleanup := func() {
+			if err := store.Delete(context.Background(), key); err != nil {
+				log.Printf("Couldn't delete staged transcription audio %s: %v", key, err)
+			}
+		}
+		return "s3://" + store.Bucket() + "/" + key, cleanup, nil
+	}
+}
+
+// transcribeVideoFile extracts the audio of the processed video at path
+// and transcribes it in the background. At most TRANSCRIPTION_CONCURRENCY
+// transcriptions run at once; the rest wait their turn.
+func (cfg *apiConfig) transcribeVideoFile(ctx context.Context, videoID uuid.UUID, path string) {
+	if cfg.transcriber == nil {
+		return
+	}
+	audioPath, err := cfg.media.ExtractAudio(ctx, path)
+	if err != nil {
+		log.Printf("transcription: couldn't extract audio of video %s: %v", videoID, err)
+		return
+	}
+	go func() {
+		defer os.Remove(audioPath)
+		cfg.transcribeAudio(context.WithoutCancel(ctx), videoID, audioPath)
+	}()
+}
+
+func (cfg *apiConfig) transcribeAudio(ctx context.Context, videoID uuid.UUID, audioPath string) {
+	release, err := cfg.transcriptionWorkers.acquire(ctx)
+	if err != nil {
+		return
+	}
+	defer release()
+
+	transcript, err := cfg.transcriber.Transcribe(ctx, audioPath, os.Getenv("TRANSCRIPTION_LANGUAGE"))
+	if err != nil {
+		log.Printf("transcription: couldn't transcribe video %s: %v", videoID, err)
+		return
+	}
+	if len(transcript.Segments) == 0 {
+		log.Printf("transcription: no speech found in video %s", videoID)
+		return
+	}
+	if err := cfg.storeCaptions(ctx, videoID, transcript); err != nil {
+		log.Printf("transcription: video %s: %v", videoID, err)
+	}
+}
+
+// storeCaptions uploads the transcript as a WebVTT track and indexes its
+// text.
+func (cfg *apiConfig) storeCaptions(ctx context.Context, videoID uuid.UUID, transcript transcribe.Transcript) error {
+	// The video may have been deleted while it was being transcribed.
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return fmt.Errorf("couldn't get video: %w", err)
+	}
+	if video.ID == uuid.Nil {
+		return nil
+	}
+
+	language := strings.ToLower(transcript.Language)
+	if language == "" {
+		language = "und"
+	}
+	if err := cfg.saveCaptionTrack(ctx, videoID, language, cfg.transcriber.Name(), strings.NewReader(transcript.WebVTT()), transcript.Text()); err != nil {
+		return err
+	}
+	log.Printf("transcription: stored %s captions for video %s", language, videoID)
+	return nil
+}
+
+// saveCaptionTrack uploads a WebVTT track and records it, replacing the
+// video's existing track in that language. text is what search indexes.
+func (cfg *apiConfig) saveCaptionTrack(ctx context.Context, videoID uuid.UUID, language, source string, vtt io.Reader, text string) error {
+	key := captionKey(videoID, language)
+	if _, err := cfg.store.Put(ctx, key, vtt, cfg.captionPutOptions(language)); err != nil {
+		return fmt.Errorf("couldn't upload captions: %w", err)
+	}
+	return cfg.recordCaptionTrack(videoID, language, source, key, text)
+}
+
+// stageCaptionTrack uploads a WebVTT track under the staging prefix and
+// returns its key, for commitCaptionTrack to move into place once the rest
+// of an upload has gone in.
+func (cfg *apiConfig) stageCaptionTrack(ctx context.Context, videoID uuid.UUID, language string, vtt io.Reader) (string, error) {
+	key := stagingPrefix + videoID.String() + "/" + uuid.NewString() + ".vtt"
+	if _, err := cfg.store.Put(ctx, key, vtt, cfg.captionPutOptions(language)); err != nil {
+		return "", fmt.Errorf("couldn't upload captions: %w", err)
+	}
+	return key, nil
+}
+
+// commitCaptionTrack is saveCaptionTrack for a track staged with
+// stageCaptionTrack. The staged copy is deleted either way.
+func (cfg *apiConfig) commitCaptionTrack(ctx context.Context, videoID uuid.UUID, language, source, stagedKey, text string) error {
+	defer cfg.discardStagedUpload(ctx, stagedKey)
+	key := captionKey(videoID, language)
+	if err := cfg.store.Copy(ctx, stagedKey, key); err != nil {
+		return fmt.Errorf("couldn't move captions into place: %w", err)
+	}
+	return cfg.recordCaptionTrack(videoID, language, source, key, text)
+}
+
+// captionKey is where the video's track in language is kept.
+func captionKey(videoID uuid.UUID, language string) string {
+	return fmt.Sprintf("captions/%s/%s.vtt", videoID, language)
+}
+
+func (cfg *apiConfig) captionPutOptions(language string) storage.PutOptions {
+	return storage.PutOptions{
+		ContentType:     "text/vtt",
+		CacheControl:    cfg.objectHeaders.cacheControl(true),
+		ContentLanguage: language,
+	}
+}
+
+// recordCaptionTrack points the video's track in language at key.
+func (cfg *apiConfig) recordCaptionTrack(videoID uuid.UUID, language, source, key, text string) error {
+	err := cfg.db.SetCaption(database.Caption{
+		VideoID:  videoID,
+		Language: language,
+		Source:   source,
+		Key:      key,
+		URL:      cfg.store.URL(key),
+	}, text)
+	if err != nil {
+		return fmt.Errorf("couldn't save captions: %w", err)
+	}
+	// Tracks are kept at one key per language, so a replaced track would
+	// otherwise be served stale.
+	cfg.invalidateCDN(key)
+	return nil
+}
+
+func (cfg *apiConfig) handlerVideoCaptions(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.watchableVideo(w, r)
+	if !ok {
+		return
+	}
+
+	captions, err := cfg.db.GetCaptions(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get captions", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, captions)
+}
+
+// handlerVideoSearch finds videos whose captions contain every word of q.
+// Results are limited to public videos and the caller's own.
+func (cfg *apiConfig) handlerVideoSearch(w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		database.CaptionMatch
+		Video database.Video `json:"video"`
+	}
+
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Every word is quoted so FTS operators in the input are searched for
+	// literally.
+	terms := []string{}
+	for _, word := range strings.Fields(r.URL.Query().Get("q")) {
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"`)
+	}
+	if len(terms) == 0 {
+		respondWithAPIError(w, apiError{
+			Status:  http.StatusBadRequest,
+			Code:    errCodeValidation,
+			Message: "Invalid search",
+			Details: []errorDetail{{Field: "q", Message: "is required"}},
+		})
+		return
+	}
+
+	matches, err := cfg.db.SearchCaptions(strings.Join(terms, " "), userID, envInt("SEARCH_MAX_RESULTS", 50))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search captions", err)
+		return
+	}
+
+	results := make([]result, 0, len(matches))
+	for _, m := range matches {
+		video, err := cfg.db.GetVideo(m.VideoID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		results = append(results, result{CaptionMatch: m, Video: video})
+	}
+
+	respondWithJSON(w, http.StatusOK, results)
+}
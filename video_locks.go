@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cache"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// videoLocker hands out leases on videos, so one upload or processing run
+// at a time replaces a video's object and rewrites its row, whichever
+// instance it runs on. A lease runs out on its own if its holder dies.
+type videoLocker interface {
+	// acquire takes the lease for owner until ttl from now, or extends
+	// it if owner already holds it, reporting false when someone else
+	// does.
+	acquire(ctx context.Context, videoID uuid.UUID, owner string, ttl time.Duration) (bool, error)
+	release(ctx context.Context, videoID uuid.UUID, owner string) error
+}
+
+// dbVideoLocker keeps leases in the database, which every instance
+// sharing it sees.
+type dbVideoLocker struct {
+	db database.Client
+}
+
+func (l dbVideoLocker) acquire(ctx context.Context, videoID uuid.UUID, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	return l.db.AcquireVideoLock(videoID, owner, now, now.Add(ttl))
+}
+
+func (l dbVideoLocker) release(ctx context.Context, videoID uuid.UUID, owner string) error {
+	return l.db.ReleaseVideoLock(videoID, owner)
+}
+
+// redisVideoLocker keeps leases in Redis, with the scripts below checking
+// the owner and setting the key in one step.
+type redisVideoLocker struct {
+	redis  *cache.Redis
+	prefix string
+}
+
+const (
+	redisAcquireScript = `
+local owner = redis.call('GET', KEYS[1])
+if owner == false or owner == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 1
+end
+return 0`
+	redisReleaseScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`
+)
+
+func (l redisVideoLocker) key(videoID uuid.UUID) string {
+	return l.prefix + "lock:video:" + videoID.String()
+}
+
+func (l redisVideoLocker) acquire(ctx context.Context, videoID uuid.UUID, owner string, ttl time.Duration) (bool, error) {
+	ms := max(1, ttl.Milliseconds())
+	reply, err := l.redis.Eval(ctx, redisAcquireScript, []string{l.key(videoID)}, owner, strconv.FormatInt(ms, 10))
+	if err != nil {
+		return false, err
+	}
+	n, _ := reply.(int64)
+	return n == 1, nil
+}
+
+func (l redisVideoLocker) release(ctx context.Context, videoID uuid.UUID, owner string) error {
+	_, err := l.redis.Eval(ctx, redisReleaseScript, []string{l.key(videoID)}, owner)
+	return err
+}
+
+// videoLocks takes leases from locker and keeps renewing them while
+// they're held.
+type videoLocks struct {
+	locker videoLocker
+	// ttl is how long a lease outlives an instance that stopped renewing
+	// it.
+	ttl time.Duration
+	// poll is how often a busy lease is tried again.
+	poll time.Duration
+}
+
+// errVideoLockLost cancels the work done under a lease that couldn't be
+// renewed in time, since another instance may have taken it over.
+var errVideoLockLost = errors.New("lost the lock on the video")
+
+// newVideoLocks uses Redis when it's configured for the cache, and the
+// database otherwise.
+func newVideoLocks(db database.Client, c *appCache) *videoLocks {
+	locks := &videoLocks{
+		locker: dbVideoLocker{db: db},
+		ttl:    max(time.Second, envDuration("VIDEO_LOCK_TTL", 30*time.Second)),
+		poll:   500 * time.Millisecond,
+	}
+	if c != nil {
+		locks.locker = redisVideoLocker{redis: c.redis, prefix: c.prefix}
+	}
+	return locks
+}
+
+type videoLockContextKey struct {
+	videoID uuid.UUID
+}
+
+// lockVideo waits until it holds the video's lease or ctx is done. The
+// context it returns is cancelled if the lease is lost, and unlock gives
+// the lease up. A context that already holds the lease is returned as it
+// is, so work done under the lease can call code that takes it too.
+func (cfg *apiConfig) lockVideo(ctx context.Context, videoID uuid.UUID) (context.Context, func(), error) {
+	if ctx.Value(videoLockContextKey{videoID}) != nil {
+		return ctx, func() {}, nil
+	}
+	l := cfg.videoLocks
+	owner := uuid.NewString()
+	for {
+		ok, err := l.locker.acquire(ctx, videoID, owner, l.ttl)
+		if err != nil {
+			return ctx, nil, err
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx, nil, context.Cause(ctx)
+		case <-time.After(l.poll):
+		}
+	}
+
+	lockCtx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	go func() {
+		renewed := time.Now()
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-lockCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			ok, err := l.locker.acquire(lockCtx, videoID, owner, l.ttl)
+			switch {
+			case ok:
+				renewed = time.Now()
+			case err == nil:
+				cancel(errVideoLockLost)
+				return
+			case time.Since(renewed)+l.ttl/3 >= l.ttl:
+				// The lease runs out before the next try.
+				log.Printf("Couldn't renew lock on video %s: %v", videoID, err)
+				cancel(errVideoLockLost)
+				return
+			}
+		}
+	}()
+
+	unlock := func() {
+		close(done)
+		cancel(nil)
+		releaseCtx, cancelRelease := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancelRelease()
+		if err := l.locker.release(releaseCtx, videoID, owner); err != nil {
+			log.Printf("Couldn't release lock on video %s: %v", videoID, err)
+		}
+	}
+	return context.WithValue(lockCtx, videoLockContextKey{videoID}, owner), unlock, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/webhook"
+	"github.com/google/uuid"
+)
+
+// envWebhookEndpointID stands for the endpoint configured with WEBHOOK_URL,
+// which isn't stored in the database like the ones added through the
+// admin API.
+var envWebhookEndpointID = uuid.Nil
+
+// webhookDispatcher queues every event for each subscribed endpoint and
+// keeps a log of the attempts to deliver it. Failed deliveries are retried
+// with exponential backoff until they run out of attempts.
+type webhookDispatcher struct {
+	db          database.Client
+	client      *http.Client
+	envEndpoint database.WebhookEndpoint
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+}
+
+func newWebhookDispatcher(db database.Client) *webhookDispatcher {
+	d := &webhookDispatcher{
+		db:          db,
+		client:      &http.Client{Timeout: envDuration("WEBHOOK_TIMEOUT", 10*time.Second)},
+		maxAttempts: max(1, envInt("WEBHOOK_MAX_ATTEMPTS", 8)),
+		backoff:     envDuration("WEBHOOK_RETRY_BACKOFF", time.Minute),
+		maxBackoff:  envDuration("WEBHOOK_RETRY_MAX_BACKOFF", 6*time.Hour),
+	}
+	if url := os.Getenv("WEBHOOK_URL"); url != "" {
+		d.envEndpoint = database.WebhookEndpoint{
+			ID:     envWebhookEndpointID,
+			URL:    url,
+			Secret: os.Getenv("WEBHOOK_SECRET"),
+			Events: []string{},
+		}
+	}
+	return d
+}
+
+// endpoints lists the WEBHOOK_URL endpoint, if there is one, followed by
+// the stored ones.
+func (d *webhookDispatcher) endpoints() ([]database.WebhookEndpoint, error) {
+	stored, err := d.db.GetWebhookEndpoints()
+	if err != nil {
+		return nil, err
+	}
+	if d.envEndpoint.URL == "" {
+		return stored, nil
+	}
+	return append([]database.WebhookEndpoint{d.envEndpoint}, stored...), nil
+}
+
+// endpoint returns a zero value when the endpoint is gone.
+func (d *webhookDispatcher) endpoint(id uuid.UUID) (database.WebhookEndpoint, error) {
+	if id == envWebhookEndpointID {
+		return d.envEndpoint, nil
+	}
+	return d.db.GetWebhookEndpoint(id)
+}
+
+// Send queues the event for every endpoint subscribed to it and makes the
+// first delivery attempts right away. Failed attempts are left to the
+// webhook_retry task, so an error only means the event couldn't be
+// queued.
+func (d *webhookDispatcher) Send(ctx context.Context, eventType string, data any) error {
+	endpoints, err := d.endpoints()
+	if err != nil {
+		return fmt.Errorf("webhook %s: couldn't list endpoints: %w", eventType, err)
+	}
+	var body []byte
+	var errs []error
+	for _, endpoint := range endpoints {
+		if !endpoint.Subscribed(eventType) {
+			continue
+		}
+		if body == nil {
+			if body, err = webhook.Encode(eventType, data); err != nil {
+				return err
+			}
+		}
+		delivery, err := d.db.CreateWebhookDelivery(endpoint.ID, eventType, string(body))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("webhook %s: couldn't queue for %s: %w", eventType, endpoint.URL, err))
+			continue
+		}
+		if err := d.attempt(ctx, endpoint, delivery); err != nil {
+			log.Printf("webhook %s: couldn't record delivery %s: %v", eventType, delivery.ID, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// attempt makes the delivery's next attempt and records how it went.
+func (d *webhookDispatcher) attempt(ctx context.Context, endpoint database.WebhookEndpoint, delivery database.WebhookDelivery) error {
+	attempt := database.WebhookAttempt{Attempt: delivery.Attempts + 1}
+	start := time.Now()
+	code, err := webhook.Post(ctx, d.client, endpoint.URL, endpoint.Secret, delivery.ID.String(), []byte(delivery.Payload))
+	attempt.StatusCode = code
+	attempt.DurationMS = time.Since(start).Milliseconds()
+
+	status := database.DeliverySucceeded
+	var next *time.Time
+	if err != nil {
+		attempt.Error = err.Error()
+		status = database.DeliveryFailed
+		if attempt.Attempt < d.maxAttempts {
+			status = database.DeliveryPending
+			at := time.Now().Add(webhook.Backoff(attempt.Attempt, d.backoff, d.maxBackoff))
+			next = &at
+		}
+	}
+	return d.db.RecordWebhookAttempt(delivery, attempt, status, next)
+}
+
+// retryWebhooks makes the next attempt at deliveries whose backoff is
+// over.
+func (cfg *apiConfig) retryWebhooks(ctx context.Context) error {
+	d := cfg.webhooks
+	deliveries, err := d.db.GetDueWebhookDeliveries(time.Now(), envInt("WEBHOOK_RETRY_BATCH", 100))
+	if err != nil {
+		return err
+	}
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		endpoint, err := d.endpoint(delivery.EndpointID)
+		if err != nil {
+			return err
+		}
+		if endpoint.URL == "" {
+			// WEBHOOK_URL was unset since the event was queued.
+			err = d.db.RecordWebhookAttempt(delivery, database.WebhookAttempt{
+				Attempt: delivery.Attempts + 1,
+				Error:   "endpoint is no longer configured",
+			}, database.DeliveryFailed, nil)
+		} else {
+			err = d.attempt(ctx, endpoint, delivery)
+		}
+		if err != nil {
+			log.Printf("webhook_retry: couldn't record delivery %s: %v", delivery.ID, err)
+		}
+	}
+	return nil
+}
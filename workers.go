@@ -0,0 +1,129 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+)
+
+var workerWaitSeconds = metrics.NewHistogramVec(
+	"tubely_worker_wait_seconds",
+	"Time work waited for a free worker, by pool.",
+	"pool",
+	metrics.DurationBuckets,
+)
+
+// workerPool bounds how much of one kind of media work runs at once.
+// Each kind has its own pool, so a burst of one can't hold up the others.
+// When the pool is full, a freed worker goes to the waiting work with the
+// highest priority, and among equal priorities to whatever waited longest.
+type workerPool struct {
+	name string
+	size int
+
+	mu      sync.Mutex
+	busy    int
+	waiters workerWaiters
+	seq     uint64
+}
+
+func newWorkerPool(name string, size int) *workerPool {
+	return &workerPool{name: name, size: max(1, size)}
+}
+
+// acquire waits for a free worker at the lowest priority and returns the
+// func that frees it again. It gives up when ctx is done first.
+func (p *workerPool) acquire(ctx context.Context) (func(), error) {
+	return p.acquirePriority(ctx, 0)
+}
+
+// acquirePriority is acquire for work at the given priority, such as the
+// plan priority of the video it's for.
+func (p *workerPool) acquirePriority(ctx context.Context, priority int) (func(), error) {
+	start := time.Now()
+	p.mu.Lock()
+	if p.busy < p.size && len(p.waiters) == 0 {
+		p.busy++
+		p.mu.Unlock()
+	} else {
+		w := &workerWaiter{priority: priority, seq: p.seq, ready: make(chan struct{})}
+		p.seq++
+		heap.Push(&p.waiters, w)
+		p.mu.Unlock()
+
+		select {
+		case <-w.ready:
+		case <-ctx.Done():
+			p.mu.Lock()
+			if w.index >= 0 {
+				heap.Remove(&p.waiters, w.index)
+				p.mu.Unlock()
+				return nil, ctx.Err()
+			}
+			p.mu.Unlock()
+			// The worker was handed over as ctx ended; pass it on.
+			p.release()
+			return nil, ctx.Err()
+		}
+	}
+	workerWaitSeconds.Observe(p.name, time.Since(start).Seconds())
+	var once sync.Once
+	return func() { once.Do(p.release) }, nil
+}
+
+// release hands the worker to the next waiter, or frees it.
+func (p *workerPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.waiters) > 0 {
+		w := heap.Pop(&p.waiters).(*workerWaiter)
+		close(w.ready)
+		return
+	}
+	p.busy--
+}
+
+type workerWaiter struct {
+	priority int
+	seq      uint64
+	ready    chan struct{}
+	// index is the waiter's place in the heap, -1 once it's been handed a
+	// worker.
+	index int
+}
+
+// workerWaiters is a heap of waiters, highest priority first.
+type workerWaiters []*workerWaiter
+
+func (h workerWaiters) Len() int { return len(h) }
+
+func (h workerWaiters) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h workerWaiters) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *workerWaiters) Push(x any) {
+	w := x.(*workerWaiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *workerWaiters) Pop() any {
+	old := *h
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*h = old[:len(old)-1]
+	return w
+}